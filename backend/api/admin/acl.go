@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ACLRuleRequest represents a request to create or update an ACL rule
+type ACLRuleRequest struct {
+	Priority    int    `json:"priority"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Protocol    string `json:"protocol,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Action      string `json:"action"`
+	Note        string `json:"note,omitempty"`
+}
+
+// Validate checks the fields of an ACL rule request
+func (req *ACLRuleRequest) Validate() error {
+	return req.rule().Validate()
+}
+
+// rule gets the ACL rule of a request
+func (req *ACLRuleRequest) rule() *core.ACLRule {
+	return &core.ACLRule{
+		Priority:    req.Priority,
+		Source:      req.Source,
+		Destination: req.Destination,
+		Protocol:    req.Protocol,
+		Port:        req.Port,
+		Action:      req.Action,
+		Note:        req.Note,
+	}
+}
+
+// ListACLRulesHandler handles listing the ACL rules in the order they are
+// checked
+func ListACLRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := VPNManager.ACL().List(r.Context())
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to list ACL rules")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, rules)
+}
+
+// CreateACLRuleHandler handles adding an ACL rule
+func CreateACLRuleHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	var req ACLRuleRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	rule, err := VPNManager.CreateACLRule(r.Context(), adminID, *req.rule())
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			utils.RespondWithValidationError(w, err)
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to create ACL rule")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, rule)
+}
+
+// UpdateACLRuleHandler handles replacing an ACL rule
+func UpdateACLRuleHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get rule ID from URL
+	vars := mux.Vars(r)
+	ruleID := vars["id"]
+
+	var req ACLRuleRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	if _, err := VPNManager.ACL().Get(r.Context(), ruleID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "ACL rule not found")
+		return
+	}
+
+	rule, err := VPNManager.UpdateACLRule(r.Context(), adminID, ruleID, *req.rule())
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			utils.RespondWithValidationError(w, err)
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update ACL rule")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, rule)
+}
+
+// DeleteACLRuleHandler handles removing an ACL rule
+func DeleteACLRuleHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get rule ID from URL
+	vars := mux.Vars(r)
+	ruleID := vars["id"]
+
+	if err := VPNManager.DeleteACLRule(r.Context(), adminID, ruleID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "ACL rule not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
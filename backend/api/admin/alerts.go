@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/alerting"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Alerts is the alert dispatcher instance
+var Alerts *alerting.Dispatcher
+
+// SilenceRequest represents a request to silence alerts
+type SilenceRequest struct {
+	Rule    string `json:"rule,omitempty"`    // alert rule, any when empty
+	Subject string `json:"subject,omitempty"` // region or server ID, any when empty
+	Comment string `json:"comment,omitempty"`
+	Minutes int    `json:"minutes"` // how long alerts stay silenced
+}
+
+// Validate checks the fields of a silence request
+func (req *SilenceRequest) Validate() error {
+	var v utils.Validator
+	v.Check(req.Minutes > 0, "minutes", "must be positive")
+	v.MaxLength("rule", req.Rule, 100)
+	v.MaxLength("subject", req.Subject, 100)
+	return v.Err()
+}
+
+// ListSilencesHandler handles requests for the alert silences that have
+// not ended
+func ListSilencesHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, Alerts.ListSilences())
+}
+
+// CreateSilenceHandler handles requests to silence the alerts of a rule,
+// subject or both
+func CreateSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req SilenceRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	silence, err := Alerts.AddSilence(req.Rule, req.Subject, req.Comment, time.Duration(req.Minutes)*time.Minute, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, silence)
+}
+
+// DeleteSilenceHandler handles requests to end a silence early
+func DeleteSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Get silence ID from URL
+	vars := mux.Vars(r)
+	silenceID := vars["id"]
+
+	if err := Alerts.RemoveSilence(silenceID, adminID); err != nil {
+		if err == alerting.ErrSilenceNotFound {
+			utils.WriteErrorResponse(w, http.StatusNotFound, "Silence not found")
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// AuditLog is the audit log instance
+var AuditLog *core.AuditLog
+
+// ListAuditEntriesHandler lists audit entries, newest first
+func ListAuditEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get filters from query
+	action := r.URL.Query().Get("action")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, AuditLog.List(action, limit))
+}
+
+// VerifyAuditLogHandler checks the audit log hash chain for tampering
+func VerifyAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	result := AuditLog.Verify()
+	if !result.Valid {
+		utils.LogError("Audit log verification failed: %s", result.Error)
+		utils.WriteJSONResponse(w, http.StatusConflict, result)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, result)
+}
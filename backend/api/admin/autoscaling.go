@@ -0,0 +1,13 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// GetAutoscalingHandler handles requests for the load and scaling state of
+// the autoscaled regions
+func GetAutoscalingHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, ServerManager.Autoscaler().Regions())
+}
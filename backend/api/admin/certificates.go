@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Certificates is the node certificate manager instance, nil when ACME
+// issuance is disabled
+var Certificates *core.CertificateManager
+
+// GetNodeCertificateHandler returns the current wildcard node certificate,
+// without its private key
+func GetNodeCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	if Certificates == nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Node certificates are not enabled")
+		return
+	}
+
+	info := Certificates.Info()
+	if info == nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "No node certificate issued yet")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, info)
+}
+
+// RenewNodeCertificateHandler starts issuing a new wildcard node certificate.
+// Issuance waits for DNS propagation, so it runs as a background job; node
+// agents install the certificate with their next heartbeat.
+func RenewNodeCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	if Certificates == nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Node certificates are not enabled")
+		return
+	}
+
+	job := Jobs.Start(core.JobTypeCertificateRenewal, adminID, func(ctx context.Context, reporter *core.JobReporter) (interface{}, error) {
+		if _, err := Certificates.Renew(ctx); err != nil {
+			return nil, err
+		}
+		// The result is shown to admins, so leave out the private key
+		return Certificates.Info(), nil
+	})
+
+	respondWithJob(w, job)
+}
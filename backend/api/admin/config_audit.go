@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ConfigAuditLog is the config audit log instance
+var ConfigAuditLog *core.ConfigAuditLog
+
+// GetUserConfigHistoryHandler handles config render history requests for a user
+func GetUserConfigHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from URL
+	vars := mux.Vars(r)
+	userID := vars["id"]
+
+	// Return history
+	utils.WriteJSONResponse(w, http.StatusOK, ConfigAuditLog.GetUserHistory(userID))
+}
+
+// GetPeerConfigHistoryHandler handles config render history requests for a peer
+func GetPeerConfigHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["peerID"]
+
+	// Get latest render, which is the config the client should be running
+	latest, err := ConfigAuditLog.GetLatest(peerID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "No config rendered for peer")
+		return
+	}
+
+	// Return history
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"current": latest,
+		"history": ConfigAuditLog.GetPeerHistory(peerID),
+	})
+}
+
+// ListOutdatedConfigsHandler lists peers whose latest config points at an
+// endpoint other than the current (or requested) one
+func ListOutdatedConfigsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get endpoint from query, defaulting to the current server endpoint
+	endpoint := r.URL.Query().Get("endpoint")
+
+	// Return outdated configs
+	utils.WriteJSONResponse(w, http.StatusOK, ConfigAuditLog.FindOutdated(endpoint))
+}
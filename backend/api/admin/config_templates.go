@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// ConfigTemplateRequest represents a config template override request
+type ConfigTemplateRequest struct {
+	Content string `json:"content"`
+}
+
+// Validate checks the fields of a config template request. The template
+// itself is checked by rendering it.
+func (req *ConfigTemplateRequest) Validate() error {
+	var v utils.Validator
+	v.Required("content", req.Content)
+	v.MaxLength("content", req.Content, wireguard.MaxTemplateSize)
+	return v.Err()
+}
+
+// ListConfigTemplatesHandler handles listing the effective config template
+// of every device type
+func ListConfigTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	templates, err := VPNManager.ConfigTemplates().ListTemplates(r.Context())
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to list config templates")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, templates)
+}
+
+// GetConfigTemplateHandler handles config template retrieval requests
+func GetConfigTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	// Get template name from URL
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if !wireguard.IsTemplateName(name) {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Config template not found")
+		return
+	}
+
+	template, err := VPNManager.ConfigTemplates().GetTemplate(r.Context(), name)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get config template")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, template)
+}
+
+// UpdateConfigTemplateHandler handles uploads of a template overriding the
+// built-in template of a device type
+func UpdateConfigTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get template name from URL
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if !wireguard.IsTemplateName(name) {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Config template not found")
+		return
+	}
+
+	// Parse request
+	var req ConfigTemplateRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Save template
+	template, err := VPNManager.ConfigTemplates().SetTemplate(r.Context(), adminID, name, req.Content)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			utils.RespondWithValidationError(w, err)
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save config template")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, template)
+}
+
+// ValidateConfigTemplateHandler handles checking a candidate template before
+// it is uploaded. The template is rendered with sample values and the result
+// is returned with any problems found, by line.
+func ValidateConfigTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req ConfigTemplateRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, wireguard.PreviewTemplate(req.Content))
+}
+
+// ResetConfigTemplateHandler handles removing a template override so the
+// built-in template applies again
+func ResetConfigTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get template name from URL
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if !wireguard.IsTemplateName(name) {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Config template not found")
+		return
+	}
+
+	if err := VPNManager.ConfigTemplates().ResetTemplate(r.Context(), adminID, name); err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to reset config template")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
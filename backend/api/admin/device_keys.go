@@ -0,0 +1,220 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// DeviceKeys is the device key allow-list instance
+var DeviceKeys *core.DeviceKeyManager
+
+// ImportDeviceKeysRequest represents a JSON device key import request
+type ImportDeviceKeysRequest struct {
+	Keys []core.DeviceKeyRow `json:"keys"`
+}
+
+// Validate checks the fields of a device key import request. Individual
+// keys are checked during the import and reported in its summary.
+func (req *ImportDeviceKeysRequest) Validate() error {
+	var v utils.Validator
+	v.Check(len(req.Keys) > 0, "keys", "must contain at least one key")
+	return v.Err()
+}
+
+// ApprovePeerRequest represents a request to approve a pending peer
+type ApprovePeerRequest struct {
+	Tag string `json:"tag,omitempty"` // overrides the peer's tag
+}
+
+// Validate checks the fields of a peer approval request
+func (req *ApprovePeerRequest) Validate() error {
+	var v utils.Validator
+	v.MaxLength("tag", req.Tag, 64)
+	return v.Err()
+}
+
+// ListDeviceKeysHandler handles device key allow-list listing requests
+func ListDeviceKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := DeviceKeys.ListKeys(r.Context())
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to list device keys")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, keys)
+}
+
+// ImportDeviceKeysHandler handles device key allow-list uploads. It accepts
+// either a JSON body or a CSV file with a public_key,tag,user_id header
+// and returns a per-key summary.
+func ImportDeviceKeysHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBodySize)
+
+	var req ImportDeviceKeysRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		rows, err := parseDeviceKeysCSV(r.Body)
+		if err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Keys = rows
+	default:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	// Validate request
+	if err := req.Validate(); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Import keys
+	summary, err := DeviceKeys.ImportKeys(r.Context(), adminID, req.Keys)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, summary)
+}
+
+// DeleteDeviceKeyHandler handles device key allow-list removals
+func DeleteDeviceKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get key ID from URL
+	vars := mux.Vars(r)
+	keyID := vars["id"]
+
+	// Delete key
+	if err := DeviceKeys.DeleteKey(r.Context(), adminID, keyID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Device key not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// ListPendingPeersHandler handles listing the devices waiting for approval
+func ListPendingPeersHandler(w http.ResponseWriter, r *http.Request) {
+	peers, err := VPNManager.ListPendingPeers()
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to list pending peers")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, peers)
+}
+
+// ApprovePeerHandler handles approving a device whose key was not on the
+// allow-list
+func ApprovePeerHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get user ID and peer ID from URL
+	vars := mux.Vars(r)
+	userID := vars["id"]
+	peerID := vars["peerID"]
+
+	// The body is optional
+	var req ApprovePeerRequest
+	if r.ContentLength != 0 {
+		if err := utils.DecodeRequest(r, &req); err != nil {
+			utils.RespondWithValidationError(w, err)
+			return
+		}
+	}
+
+	// Approve peer
+	peer, err := VPNManager.ApprovePeer(r.Context(), adminID, userID, peerID, req.Tag)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, peer)
+}
+
+// RejectPeerHandler handles rejecting a device waiting for approval
+func RejectPeerHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get user ID and peer ID from URL
+	vars := mux.Vars(r)
+	userID := vars["id"]
+	peerID := vars["peerID"]
+
+	// Reject peer
+	if err := VPNManager.RejectPeer(r.Context(), adminID, userID, peerID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "rejected"})
+}
+
+// parseDeviceKeysCSV reads allow-list rows from a CSV file with a header row
+func parseDeviceKeysCSV(body io.Reader) ([]core.DeviceKeyRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["public_key"]; !ok {
+		return nil, fmt.Errorf("CSV is missing the public_key column")
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	rows := make([]core.DeviceKeyRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %v", err)
+		}
+
+		rows = append(rows, core.DeviceKeyRow{
+			PublicKey: field(record, "public_key"),
+			Tag:       field(record, "tag"),
+			UserID:    field(record, "user_id"),
+		})
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// DrainRequest represents a request to drain a server ahead of maintenance
+type DrainRequest struct {
+	Window int `json:"window"` // in minutes active sessions keep their server, 0 moves them right away
+}
+
+// Validate checks the fields of a drain request
+func (req *DrainRequest) Validate() error {
+	var v utils.Validator
+	v.Check(req.Window >= 0 && req.Window <= 1440, "window", "must be between 0 and 1440 minutes")
+	return v.Err()
+}
+
+// StartDrainHandler handles requests to drain a server: it stops taking
+// new connects and its peers move to other servers over the window
+func StartDrainHandler(w http.ResponseWriter, r *http.Request) {
+	// Get server ID from URL
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	// Parse request
+	var req DrainRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	if _, err := ServerManager.GetServer(serverID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Server not found")
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Start drain
+	drain, err := VPNManager.DrainServer(serverID, time.Duration(req.Window)*time.Minute, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusAccepted, drain)
+}
+
+// GetDrainHandler handles requests for the progress of a server drain,
+// which reports when the server is empty and safe to take down
+func GetDrainHandler(w http.ResponseWriter, r *http.Request) {
+	// Get server ID from URL
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	drain, err := VPNManager.ServerDrain(serverID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Server is not draining")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, drain)
+}
+
+// StopDrainHandler handles requests to cancel a server drain, restoring
+// the status the server had before
+func StopDrainHandler(w http.ResponseWriter, r *http.Request) {
+	// Get server ID from URL
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	if err := ServerManager.StopDrain(serverID, adminID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Server is not draining")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
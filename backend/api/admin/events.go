@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Events is the system event bus instance
+var Events *core.EventBus
+
+// eventsKeepalive is how often an idle feed sends a comment so proxies
+// keep the connection open
+const eventsKeepalive = 15 * time.Second
+
+// EventsHandler streams system events to admin dashboards as Server-Sent
+// Events. The types query parameter filters by a comma-separated list of
+// event types, and clients reconnecting with Last-Event-ID get the events
+// they missed.
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	controller := http.NewResponseController(w)
+
+	// Streams outlive the server's write timeout
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	// Get event type filter
+	types := make(map[string]bool)
+	for _, eventType := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			types[eventType] = true
+		}
+	}
+
+	// Get the last event the client saw
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	events, missed, unsubscribe := Events.Subscribe(lastID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event *core.Event) error {
+		if len(types) > 0 && !types[event.Type] {
+			return nil
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+
+	for _, event := range missed {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(eventsKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
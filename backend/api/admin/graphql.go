@@ -0,0 +1,189 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// VPNManager is the VPN manager instance
+var VPNManager *core.VPNManager
+
+// graphqlMaxDepth limits query nesting; user → peers → server → metrics
+// needs four levels
+const graphqlMaxDepth = 8
+
+// graphqlSchema describes the admin graph of users, their peers, the servers
+// they connect to and usage
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+type Query {
+	users: [User!]!
+	user(id: ID!): User
+	servers: [Server!]!
+	server(id: ID!): Server
+}
+
+type User {
+	id: ID!
+	username: String!
+	email: String!
+	role: String!
+	mfaEnabled: Boolean!
+	createdAt: String!
+	plan: Plan!
+	peers: [Peer!]!
+	usage: Usage!
+}
+
+type Plan {
+	id: ID!
+	name: String!
+	protocols: [String!]!
+	maxDevices: Int!
+	bandwidthMbps: Int!
+	multiHop: Boolean!
+	dedicatedIp: Boolean!
+	portForwarding: Boolean!
+	requireStepUp: Boolean!
+}
+
+type Peer {
+	id: ID!
+	deviceType: String!
+	deviceName: String!
+	ip: String!
+	dynamic: Boolean!
+	status: String!
+	createdAt: String!
+	lastSeen: String!
+	expiresAt: String
+	server: Server
+	usage: Usage!
+}
+
+type Usage {
+	bytesRx: Float!
+	bytesTx: Float!
+}
+
+type Server {
+	id: ID!
+	name: String!
+	country: String!
+	city: String!
+	ip: String!
+	status: String!
+	metrics: ServerMetrics!
+}
+
+type ServerMetrics {
+	load: Int!
+	capacity: Int!
+	lastUpdated: String!
+	agentVersion: String
+	wireguardVersion: String
+	lastHeartbeat: String
+}
+`
+
+// graphqlExecutor is the parsed admin schema bound to its resolvers
+var graphqlExecutor = graphql.MustParseSchema(graphqlSchema, &graphqlResolver{}, graphql.MaxDepth(graphqlMaxDepth))
+
+// GraphQLRequest represents a GraphQL query request
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Validate checks the fields of a GraphQL query request
+func (req *GraphQLRequest) Validate() error {
+	var v utils.Validator
+	v.Required("query", req.Query)
+	v.MaxLength("query", req.Query, 10000)
+	return v.Err()
+}
+
+// GraphQLResponse represents a GraphQL query response
+type GraphQLResponse struct {
+	Data   interface{}             `json:"data,omitempty"`
+	Errors []*gqlerrors.QueryError `json:"errors,omitempty"`
+}
+
+// GraphQLHandler handles admin GraphQL queries, so the dashboard can fetch
+// nested data such as users with their peers, servers and metrics in one
+// round trip
+func GraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req GraphQLRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Run query. Errors are part of the response, as GraphQL clients expect.
+	response := graphqlExecutor.Exec(r.Context(), req.Query, req.OperationName, req.Variables)
+	if len(response.Errors) > 0 {
+		utils.LogWarningContext(r.Context(), "GraphQL query returned errors: %v", response.Errors)
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, GraphQLResponse{Data: response.Data, Errors: response.Errors})
+}
+
+// graphqlResolver resolves the root query fields
+type graphqlResolver struct{}
+
+// Users resolves all users
+func (q *graphqlResolver) Users(ctx context.Context) ([]*userResolver, error) {
+	users, err := UserManager.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*userResolver, len(users))
+	for i, user := range users {
+		resolvers[i] = &userResolver{user: user}
+	}
+
+	return resolvers, nil
+}
+
+// User resolves a user by ID
+func (q *graphqlResolver) User(args struct{ ID graphql.ID }) *userResolver {
+	user, err := UserManager.GetUser(string(args.ID))
+	if err != nil {
+		return nil
+	}
+
+	return &userResolver{user: user}
+}
+
+// Servers resolves all servers
+func (q *graphqlResolver) Servers() []*serverResolver {
+	servers := ServerManager.GetServers()
+
+	resolvers := make([]*serverResolver, len(servers))
+	for i, server := range servers {
+		resolvers[i] = &serverResolver{server: server}
+	}
+
+	return resolvers
+}
+
+// Server resolves a server by ID
+func (q *graphqlResolver) Server(args struct{ ID graphql.ID }) *serverResolver {
+	server, err := ServerManager.GetServer(string(args.ID))
+	if err != nil {
+		return nil
+	}
+
+	return &serverResolver{server: server}
+}
@@ -0,0 +1,318 @@
+package admin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// userResolver resolves the fields of a user
+type userResolver struct {
+	user *models.User
+
+	// The status of the user's peers is read once for both peers and usage
+	statusOnce sync.Once
+	status     []*wireguard.PeerInfo
+	statusErr  error
+}
+
+// ID resolves the user ID
+func (r *userResolver) ID() graphql.ID {
+	return graphql.ID(r.user.ID)
+}
+
+// Username resolves the username
+func (r *userResolver) Username() string {
+	return r.user.Username
+}
+
+// Email resolves the email address
+func (r *userResolver) Email() string {
+	return r.user.Email
+}
+
+// Role resolves the role
+func (r *userResolver) Role() string {
+	return r.user.Role
+}
+
+// MFAEnabled resolves whether two-factor authentication is enabled
+func (r *userResolver) MFAEnabled() bool {
+	return r.user.MFAEnabled
+}
+
+// CreatedAt resolves when the user registered
+func (r *userResolver) CreatedAt() string {
+	return r.user.CreatedAt.Format(time.RFC3339)
+}
+
+// Plan resolves the user's plan
+func (r *userResolver) Plan(ctx context.Context) *planResolver {
+	return &planResolver{plan: Entitlements.GetUserPlan(ctx, r.user.ID)}
+}
+
+// Peers resolves the user's peers
+func (r *userResolver) Peers(ctx context.Context) ([]*peerResolver, error) {
+	status, err := r.peerStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*peerResolver, len(status))
+	for i, peer := range status {
+		resolvers[i] = &peerResolver{peer: peer}
+	}
+
+	return resolvers, nil
+}
+
+// Usage resolves the traffic of all the user's peers
+func (r *userResolver) Usage(ctx context.Context) (*usageResolver, error) {
+	status, err := r.peerStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &usageResolver{}
+	for _, peer := range status {
+		usage.bytesRx += peer.BytesRx
+		usage.bytesTx += peer.BytesTx
+	}
+
+	return usage, nil
+}
+
+// peerStatus gets the status of the user's peers
+func (r *userResolver) peerStatus(ctx context.Context) ([]*wireguard.PeerInfo, error) {
+	r.statusOnce.Do(func() {
+		r.status, r.statusErr = VPNManager.GetStatus(ctx, r.user.ID)
+	})
+	return r.status, r.statusErr
+}
+
+// planResolver resolves the fields of a plan
+type planResolver struct {
+	plan *models.Plan
+}
+
+// ID resolves the plan ID
+func (r *planResolver) ID() graphql.ID {
+	return graphql.ID(r.plan.ID)
+}
+
+// Name resolves the plan name
+func (r *planResolver) Name() string {
+	return r.plan.Name
+}
+
+// Protocols resolves the allowed protocols
+func (r *planResolver) Protocols() []string {
+	return r.plan.Entitlements.Protocols
+}
+
+// MaxDevices resolves the device limit, 0 meaning unlimited
+func (r *planResolver) MaxDevices() int32 {
+	return int32(r.plan.Entitlements.MaxDevices)
+}
+
+// BandwidthMbps resolves the bandwidth limit, 0 meaning unlimited
+func (r *planResolver) BandwidthMbps() int32 {
+	return int32(r.plan.Entitlements.BandwidthMbps)
+}
+
+// MultiHop resolves whether multi-hop is included
+func (r *planResolver) MultiHop() bool {
+	return r.plan.Entitlements.MultiHop
+}
+
+// DedicatedIP resolves whether a dedicated IP is included
+func (r *planResolver) DedicatedIP() bool {
+	return r.plan.Entitlements.DedicatedIP
+}
+
+// PortForwarding resolves whether port forwarding is included
+func (r *planResolver) PortForwarding() bool {
+	return r.plan.Entitlements.PortForwarding
+}
+
+// RequireStepUp resolves whether new configs need step-up verification
+func (r *planResolver) RequireStepUp() bool {
+	return r.plan.Entitlements.RequireStepUp
+}
+
+// peerResolver resolves the fields of a peer
+type peerResolver struct {
+	peer *wireguard.PeerInfo
+}
+
+// ID resolves the peer ID
+func (r *peerResolver) ID() graphql.ID {
+	return graphql.ID(r.peer.ID)
+}
+
+// DeviceType resolves the device type
+func (r *peerResolver) DeviceType() string {
+	return r.peer.DeviceType
+}
+
+// DeviceName resolves the device name
+func (r *peerResolver) DeviceName() string {
+	return r.peer.DeviceName
+}
+
+// IP resolves the peer's tunnel address
+func (r *peerResolver) IP() string {
+	return r.peer.IP
+}
+
+// Dynamic resolves whether the peer is a dynamic session
+func (r *peerResolver) Dynamic() bool {
+	return r.peer.Dynamic
+}
+
+// Status resolves the session status
+func (r *peerResolver) Status() string {
+	return r.peer.Status
+}
+
+// CreatedAt resolves when the peer was created
+func (r *peerResolver) CreatedAt() string {
+	return r.peer.CreatedAt
+}
+
+// LastSeen resolves the peer's latest handshake
+func (r *peerResolver) LastSeen() string {
+	return r.peer.LastSeen
+}
+
+// ExpiresAt resolves when a dynamic peer's session expires
+func (r *peerResolver) ExpiresAt() *string {
+	if r.peer.ExpiresAt == "" {
+		return nil
+	}
+	return &r.peer.ExpiresAt
+}
+
+// Server resolves the server the peer connects to
+func (r *peerResolver) Server() *serverResolver {
+	server, err := ServerManager.GetServer(r.peer.ServerID)
+	if err != nil {
+		return nil
+	}
+
+	return &serverResolver{server: server}
+}
+
+// Usage resolves the peer's traffic
+func (r *peerResolver) Usage() *usageResolver {
+	return &usageResolver{bytesRx: r.peer.BytesRx, bytesTx: r.peer.BytesTx}
+}
+
+// usageResolver resolves traffic counters
+type usageResolver struct {
+	bytesRx int64
+	bytesTx int64
+}
+
+// BytesRx resolves the bytes received
+func (r *usageResolver) BytesRx() float64 {
+	return float64(r.bytesRx)
+}
+
+// BytesTx resolves the bytes sent
+func (r *usageResolver) BytesTx() float64 {
+	return float64(r.bytesTx)
+}
+
+// serverResolver resolves the fields of a server
+type serverResolver struct {
+	server *core.Server
+}
+
+// ID resolves the server ID
+func (r *serverResolver) ID() graphql.ID {
+	return graphql.ID(r.server.ID)
+}
+
+// Name resolves the server name
+func (r *serverResolver) Name() string {
+	return r.server.Name
+}
+
+// Country resolves the server country
+func (r *serverResolver) Country() string {
+	return r.server.Country
+}
+
+// City resolves the server city
+func (r *serverResolver) City() string {
+	return r.server.City
+}
+
+// IP resolves the server address
+func (r *serverResolver) IP() string {
+	return r.server.IP
+}
+
+// Status resolves the server status
+func (r *serverResolver) Status() string {
+	return r.server.Status
+}
+
+// Metrics resolves the server's load and the versions its node reports
+func (r *serverResolver) Metrics() *serverMetricsResolver {
+	version, ok := ServerManager.NodeVersion(r.server.ID)
+	return &serverMetricsResolver{server: r.server, version: version, reported: ok}
+}
+
+// serverMetricsResolver resolves the fields of server metrics
+type serverMetricsResolver struct {
+	server   *core.Server
+	version  core.NodeVersion
+	reported bool // whether the node has sent a heartbeat
+}
+
+// Load resolves the server load
+func (r *serverMetricsResolver) Load() int32 {
+	return int32(r.server.Load)
+}
+
+// Capacity resolves the server capacity
+func (r *serverMetricsResolver) Capacity() int32 {
+	return int32(r.server.Capacity)
+}
+
+// LastUpdated resolves when the load was last updated
+func (r *serverMetricsResolver) LastUpdated() string {
+	return r.server.LastUpdated.Format(time.RFC3339)
+}
+
+// AgentVersion resolves the node agent version
+func (r *serverMetricsResolver) AgentVersion() *string {
+	if !r.reported {
+		return nil
+	}
+	return &r.version.AgentVersion
+}
+
+// WireguardVersion resolves the node's WireGuard version
+func (r *serverMetricsResolver) WireguardVersion() *string {
+	if !r.reported || r.version.WireGuardVersion == "" {
+		return nil
+	}
+	return &r.version.WireGuardVersion
+}
+
+// LastHeartbeat resolves when the node last sent a heartbeat
+func (r *serverMetricsResolver) LastHeartbeat() *string {
+	if !r.reported {
+		return nil
+	}
+	lastHeartbeat := r.version.LastHeartbeat.Format(time.RFC3339)
+	return &lastHeartbeat
+}
@@ -1,9 +1,7 @@
 package admin
 
 import (
-	"encoding/json"
 	"net/http"
-	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/vpn-service/backend/db/models"
@@ -30,6 +28,16 @@ type UserUpdateRequest struct {
 	Active   bool   `json:"active"`
 }
 
+// Validate checks the fields of a user update request
+func (req *UserUpdateRequest) Validate() error {
+	var v utils.Validator
+	v.Email("email", req.Email)
+	if req.Password != "" {
+		v.MinLength("password", req.Password, 8)
+	}
+	return v.Err()
+}
+
 // ListUsersHandler handles user listing requests
 func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 	// Get users
@@ -81,14 +89,8 @@ func UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request
 	var req UserUpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	// Validate request
-	if err := validateUserUpdateRequest(req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
@@ -159,6 +161,9 @@ func DeleteUserPeerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Let the user's devices know the session was ended remotely
+	Events.Publish(core.EventPeerDisconnected, core.PeerEvent{UserID: userID, PeerID: peerID, Reason: core.DisconnectAdmin})
+
 	// Return success
 	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
 }
@@ -173,18 +178,3 @@ func convertUserToResponse(user *models.User) UserResponse {
 		UpdatedAt: user.UpdatedAt.Format("2006-01-02T15:04:05Z"),
 	}
 }
-
-// validateUserUpdateRequest validates a user update request
-func validateUserUpdateRequest(req UserUpdateRequest) error {
-	// Validate email if provided
-	if req.Email != "" && !utils.IsValidEmail(req.Email) {
-		return utils.NewError("invalid email")
-	}
-
-	// Validate password if provided
-	if req.Password != "" && len(req.Password) < 8 {
-		return utils.NewError("password must be at least 8 characters")
-	}
-
-	return nil
-}
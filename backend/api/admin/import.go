@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// maxImportBodySize limits the size of an import upload
+const maxImportBodySize = 10 << 20
+
+// ImportUsersRequest represents a JSON user import request
+type ImportUsersRequest struct {
+	Users       []core.ImportRow `json:"users"`
+	SendInvites bool             `json:"sendInvites"`
+}
+
+// Validate checks the fields of a user import request. Individual rows are
+// checked during the import and reported in its summary.
+func (req *ImportUsersRequest) Validate() error {
+	var v utils.Validator
+	v.Check(len(req.Users) > 0, "users", "must contain at least one user")
+	return v.Err()
+}
+
+// ImportUsersHandler handles bulk user imports. It accepts either a JSON
+// body or a CSV file with a username,email,password_hash header; rows
+// without a bcrypt hash are invited to set a password. The import runs as a
+// background job whose result is the per-row summary.
+func ImportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBodySize)
+
+	var req ImportUsersRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		rows, err := parseImportCSV(r.Body)
+		if err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Users = rows
+		req.SendInvites = r.URL.Query().Get("sendInvites") == "true"
+	default:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	// Validate request
+	if err := req.Validate(); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	if err := core.ValidateImport(req.Users); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Import users in the background
+	job := Jobs.Start(core.JobTypeUserImport, adminID, func(ctx context.Context, reporter *core.JobReporter) (interface{}, error) {
+		summary, err := UserManager.ImportUsers(ctx, req.Users, req.SendInvites, reporter)
+		if err != nil {
+			return nil, err
+		}
+		return summary, nil
+	})
+
+	respondWithJob(w, job)
+}
+
+// parseImportCSV reads import rows from a CSV file with a header row
+func parseImportCSV(body io.Reader) ([]core.ImportRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"username", "email"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV is missing the %s column", name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	rows := make([]core.ImportRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %v", err)
+		}
+
+		rows = append(rows, core.ImportRow{
+			Username:     field(record, "username"),
+			Email:        field(record, "email"),
+			PasswordHash: field(record, "password_hash"),
+		})
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// IncidentRequest represents an incident posted to the public status page
+type IncidentRequest struct {
+	Title   string   `json:"title"`
+	Message string   `json:"message"`
+	Status  string   `json:"status"`  // investigating, identified, monitoring or resolved; defaults to investigating
+	Regions []string `json:"regions"` // affected regions as listed on the status page, empty for the whole service
+}
+
+// Validate checks the fields of an incident request
+func (req *IncidentRequest) Validate() error {
+	var v utils.Validator
+	core.ValidateIncident(&v, req.Title, req.Status, req.Regions)
+	return v.Err()
+}
+
+// ListIncidentsHandler handles requests for the open incidents and those
+// of the last 30 days
+func ListIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, ServerManager.StatusPage().ListIncidents())
+}
+
+// CreateIncidentHandler handles requests to post an incident to the public
+// status page
+func CreateIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req IncidentRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	incident, err := ServerManager.StatusPage().CreateIncident(req.Title, req.Message, req.Status, req.Regions, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, incident)
+}
+
+// UpdateIncidentHandler handles requests to update an incident, e.g. with
+// progress or to resolve it
+func UpdateIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	// Get incident ID from URL
+	vars := mux.Vars(r)
+	incidentID := vars["id"]
+
+	// Parse request
+	var req IncidentRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	incident, err := ServerManager.StatusPage().UpdateIncident(incidentID, req.Title, req.Message, req.Status, req.Regions, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Incident not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, incident)
+}
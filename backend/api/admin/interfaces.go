@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// ListInterfacesHandler handles listing the WireGuard interfaces of the
+// server with their state
+func ListInterfacesHandler(w http.ResponseWriter, r *http.Request) {
+	interfaces, err := VPNManager.Interfaces(r.Context())
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to list interfaces")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, interfaces)
+}
+
+// ApplyInterfaceHandler handles applying the peers of one WireGuard
+// interface again
+func ApplyInterfaceHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get interface name from URL
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if err := VPNManager.ApplyInterface(r.Context(), adminID, name); err != nil {
+		if errors.Is(err, wireguard.ErrUnknownInterface) {
+			utils.WriteErrorResponse(w, http.StatusNotFound, "Interface not found")
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to apply interface")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
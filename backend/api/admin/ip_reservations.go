@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ReserveIPRequest represents a request to reserve a dedicated address to
+// a user
+type ReserveIPRequest struct {
+	UserID   string `json:"userId"`
+	TunnelIP string `json:"tunnelIp,omitempty"` // the lowest free address when empty
+	PublicIP string `json:"publicIp,omitempty"`
+	ServerID string `json:"serverId,omitempty"` // server the public address is on
+	Note     string `json:"note,omitempty"`
+}
+
+// Validate checks the fields of an IP reservation request
+func (req *ReserveIPRequest) Validate() error {
+	var v utils.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("tunnelIp", req.TunnelIP, 45)
+	v.MaxLength("publicIp", req.PublicIP, 45)
+	v.Check(req.PublicIP == "" || req.ServerID != "", "serverId", "is required with publicIp")
+	v.Check(req.ServerID == "" || req.PublicIP != "", "serverId", "is only used with publicIp")
+	v.MaxLength("note", req.Note, 255)
+	return v.Err()
+}
+
+// ListIPReservationsHandler handles listing dedicated address reservations,
+// of a single user with the userId query parameter
+func ListIPReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	reservations, err := VPNManager.IPReservations().List(r.Context(), r.URL.Query().Get("userId"))
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to list IP reservations")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, reservations)
+}
+
+// ReserveIPHandler handles reserving a dedicated address to a user
+func ReserveIPHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	var req ReserveIPRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Reserve address
+	reservation, err := VPNManager.ReserveIP(r.Context(), adminID, core.IPReservation{
+		UserID:   req.UserID,
+		TunnelIP: req.TunnelIP,
+		PublicIP: req.PublicIP,
+		ServerID: req.ServerID,
+		Note:     req.Note,
+	})
+	if err != nil {
+		if _, ok := err.(*core.EntitlementError); ok {
+			utils.WriteErrorResponse(w, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, reservation)
+}
+
+// ReleaseIPHandler handles deleting a dedicated address reservation
+func ReleaseIPHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get reservation ID from URL
+	vars := mux.Vars(r)
+	reservationID := vars["id"]
+
+	// Release address
+	if err := VPNManager.ReleaseIP(r.Context(), adminID, reservationID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "IP reservation not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Jobs is the background job manager instance
+var Jobs *core.JobManager
+
+// ListJobsHandler lists background jobs, newest first, optionally filtered
+// by type and status. Per-item errors and results are only returned by
+// GetJobHandler.
+func ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	filter := core.JobFilter{
+		Type:   r.URL.Query().Get("type"),
+		Status: r.URL.Query().Get("status"),
+	}
+
+	var v utils.Validator
+	v.OneOf("type", filter.Type, core.JobTypeUserImport, core.JobTypeCertificateRenewal)
+	v.OneOf("status", filter.Status, core.JobStatusRunning, core.JobStatusSucceeded, core.JobStatusFailed, core.JobStatusCancelled)
+	if err := v.Err(); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, Jobs.ListJobs(filter))
+}
+
+// GetJobHandler returns the progress, per-item errors and result of a job
+func GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	// Get job ID from URL
+	vars := mux.Vars(r)
+	jobID := vars["id"]
+
+	// Get job
+	job, err := Jobs.GetJob(jobID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, job)
+}
+
+// CancelJobHandler asks a running job to stop
+func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get job ID from URL
+	vars := mux.Vars(r)
+	jobID := vars["id"]
+
+	// Cancel job
+	if _, err := Jobs.GetJob(jobID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	if err := Jobs.CancelJob(jobID, adminID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusAccepted, map[string]string{"status": "cancelling"})
+}
+
+// respondWithJob responds to a request that started a background job with
+// the job and where to poll it
+func respondWithJob(w http.ResponseWriter, job *core.Job) {
+	w.Header().Set("Location", "/api/admin/jobs/"+job.ID)
+	utils.WriteJSONResponse(w, http.StatusAccepted, job)
+}
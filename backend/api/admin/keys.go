@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// SigningKeys is the JWT signing key manager instance
+var SigningKeys *core.SigningKeyManager
+
+// ListSigningKeysHandler lists the keys currently accepted for token verification
+func ListSigningKeysHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, SigningKeys.ListKeys())
+}
+
+// RotateSigningKeyHandler generates a new signing key. Previous keys remain
+// valid for verification until they are retired.
+func RotateSigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := SigningKeys.Rotate()
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to rotate signing key")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, key)
+}
+
+// RetireSigningKeyHandler stops accepting tokens signed with a key
+func RetireSigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// Get key ID from URL
+	vars := mux.Vars(r)
+	kid := vars["kid"]
+
+	if err := SigningKeys.Retire(kid); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
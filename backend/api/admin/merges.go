@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// AccountMerges is the account merge manager instance
+var AccountMerges *core.AccountMergeManager
+
+// MergeRequest represents a request to stage an account merge
+type MergeRequest struct {
+	SourceUserID string `json:"sourceUserId"`
+	TargetUserID string `json:"targetUserId"`
+	Devices      string `json:"devices"` // reject (default) or keep_newest
+	Plan         string `json:"plan"`    // best (default), target or source
+}
+
+// Validate checks the fields of an account merge request
+func (req *MergeRequest) Validate() error {
+	var v utils.Validator
+	v.Required("sourceUserId", req.SourceUserID)
+	v.Required("targetUserId", req.TargetUserID)
+	v.Check(req.SourceUserID == "" || req.SourceUserID != req.TargetUserID, "targetUserId", "must differ from sourceUserId")
+	v.OneOf("devices", req.Devices, core.MergeDevicesReject, core.MergeDevicesKeepNewest)
+	v.OneOf("plan", req.Plan, core.MergePlanBest, core.MergePlanTarget, core.MergePlanSource)
+	return v.Err()
+}
+
+// ListMergesHandler handles account merge listing requests
+func ListMergesHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, AccountMerges.ListMerges())
+}
+
+// GetMergeHandler handles account merge retrieval requests
+func GetMergeHandler(w http.ResponseWriter, r *http.Request) {
+	// Get merge ID from URL
+	vars := mux.Vars(r)
+	mergeID := vars["id"]
+
+	// Get merge
+	merge, err := AccountMerges.GetMerge(mergeID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Merge not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, merge)
+}
+
+// StageMergeHandler handles requests to stage an account merge. Nothing
+// changes until the staged merge is committed.
+func StageMergeHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req MergeRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Stage merge
+	merge, err := AccountMerges.StageMerge(r.Context(), req.SourceUserID, req.TargetUserID, req.Devices, req.Plan, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, merge)
+}
+
+// CommitMergeHandler handles requests to carry out a staged account merge
+func CommitMergeHandler(w http.ResponseWriter, r *http.Request) {
+	// Get merge ID from URL
+	vars := mux.Vars(r)
+	mergeID := vars["id"]
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Commit merge
+	merge, err := AccountMerges.CommitMerge(r.Context(), mergeID, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, merge)
+}
+
+// RevertMergeHandler handles requests to undo a committed account merge
+func RevertMergeHandler(w http.ResponseWriter, r *http.Request) {
+	// Get merge ID from URL
+	vars := mux.Vars(r)
+	mergeID := vars["id"]
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Revert merge
+	merge, err := AccountMerges.RevertMerge(r.Context(), mergeID, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, merge)
+}
+
+// CancelMergeHandler handles requests to discard a staged account merge
+func CancelMergeHandler(w http.ResponseWriter, r *http.Request) {
+	// Get merge ID from URL
+	vars := mux.Vars(r)
+	mergeID := vars["id"]
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Cancel merge
+	merge, err := AccountMerges.CancelMerge(mergeID, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, merge)
+}
@@ -0,0 +1,120 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// MeshRequest represents a request to create or update a mesh
+type MeshRequest struct {
+	Name      string   `json:"name"`
+	Topology  string   `json:"topology"`
+	HubPeerID string   `json:"hubPeerId,omitempty"`
+	Members   []string `json:"members"`
+}
+
+// Validate checks the fields of a mesh request
+func (req *MeshRequest) Validate() error {
+	return req.mesh().Validate()
+}
+
+// mesh gets the mesh of a request
+func (req *MeshRequest) mesh() *core.Mesh {
+	return &core.Mesh{
+		Name:      req.Name,
+		Topology:  req.Topology,
+		HubPeerID: req.HubPeerID,
+		Members:   req.Members,
+	}
+}
+
+// ListMeshesHandler handles listing the meshes of users' devices
+func ListMeshesHandler(w http.ResponseWriter, r *http.Request) {
+	meshes, err := VPNManager.Mesh().List(r.Context())
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to list meshes")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, meshes)
+}
+
+// CreateMeshHandler handles adding a mesh
+func CreateMeshHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	var req MeshRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	mesh, err := VPNManager.CreateMesh(r.Context(), adminID, *req.mesh())
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			utils.RespondWithValidationError(w, err)
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to create mesh")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, mesh)
+}
+
+// UpdateMeshHandler handles replacing the topology and members of a mesh
+func UpdateMeshHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get mesh ID from URL
+	vars := mux.Vars(r)
+	meshID := vars["id"]
+
+	var req MeshRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	if _, err := VPNManager.Mesh().Get(r.Context(), meshID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Mesh not found")
+		return
+	}
+
+	mesh, err := VPNManager.UpdateMesh(r.Context(), adminID, meshID, *req.mesh())
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			utils.RespondWithValidationError(w, err)
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update mesh")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, mesh)
+}
+
+// DeleteMeshHandler handles removing a mesh
+func DeleteMeshHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	// Get mesh ID from URL
+	vars := mux.Vars(r)
+	meshID := vars["id"]
+
+	if err := VPNManager.DeleteMesh(r.Context(), adminID, meshID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Mesh not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
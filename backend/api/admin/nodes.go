@@ -0,0 +1,237 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ServerManager is the server manager instance
+var ServerManager *core.ServerManager
+
+// RolloutRequest represents an agent rollout request
+type RolloutRequest struct {
+	Version  string `json:"version"`
+	CanaryID string `json:"canaryId"` // optional, defaults to the least loaded healthy node
+}
+
+// Validate checks the fields of an agent rollout request
+func (req *RolloutRequest) Validate() error {
+	var v utils.Validator
+	v.Required("version", req.Version)
+	v.MaxLength("version", req.Version, 64)
+	return v.Err()
+}
+
+// NodeCommandRequest represents a request to run a command on a node
+type NodeCommandRequest struct {
+	Type    string `json:"type"`              // sync, restart or upgrade
+	Version string `json:"version,omitempty"` // agent version to install, upgrades only
+}
+
+// Validate checks the fields of a node command request
+func (req *NodeCommandRequest) Validate() error {
+	var v utils.Validator
+	v.Required("type", req.Type)
+	v.OneOf("type", req.Type, core.NodeCommandSync, core.NodeCommandRestart, core.NodeCommandUpgrade)
+	core.ValidateAgentVersion(&v, req.Type, req.Version)
+	return v.Err()
+}
+
+// GetNodeInventoryHandler handles node software inventory requests
+func GetNodeInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, ServerManager.Inventory())
+}
+
+// ListRolloutsHandler handles agent rollout listing requests
+func ListRolloutsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, ServerManager.Rollouts().ListRollouts())
+}
+
+// GetRolloutHandler handles agent rollout retrieval requests
+func GetRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	// Get rollout ID from URL
+	vars := mux.Vars(r)
+	rolloutID := vars["id"]
+
+	// Get rollout
+	rollout, err := ServerManager.Rollouts().GetRollout(rolloutID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Rollout not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, rollout)
+}
+
+// StartRolloutHandler handles requests to roll out an agent version,
+// canary node first and then the rest of the fleet
+func StartRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req RolloutRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Start rollout
+	rollout, err := ServerManager.Rollouts().StartRollout(req.Version, req.CanaryID, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, rollout)
+}
+
+// AbortRolloutHandler handles requests to stop an agent rollout
+func AbortRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	// Get rollout ID from URL
+	vars := mux.Vars(r)
+	rolloutID := vars["id"]
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Abort rollout
+	rollout, err := ServerManager.Rollouts().AbortRollout(rolloutID, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, rollout)
+}
+
+// ListNodeCommandsHandler handles listing the recent commands of a node
+func ListNodeCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get server ID from URL
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if _, err := ServerManager.GetServer(serverID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Server not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, ServerManager.NodeCommands().List(serverID))
+}
+
+// QueueNodeCommandHandler handles requests to run a command on a node. The
+// node agent picks it up with its next heartbeat.
+func QueueNodeCommandHandler(w http.ResponseWriter, r *http.Request) {
+	// Get server ID from URL
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	// Parse request
+	var req NodeCommandRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Queue command
+	command, err := ServerManager.QueueNodeCommand(serverID, req.Type, req.Version, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusAccepted, command)
+}
+
+// NodeEnrollmentResponse represents a new enrollment token of a node
+type NodeEnrollmentResponse struct {
+	Token         string    `json:"token"` // shown once
+	ExpiresAt     time.Time `json:"expiresAt"`
+	CACertificate string    `json:"caCertificate"` // PEM certificate of the agent CA
+}
+
+// CreateNodeEnrollmentHandler handles requests for a one-time token the
+// agent of a server enrolls with on the agent listener
+func CreateNodeEnrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	// Get server ID from URL
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	ca := ServerManager.AgentCA()
+	if ca == nil {
+		utils.WriteErrorResponse(w, http.StatusServiceUnavailable, "Agent listener is disabled")
+		return
+	}
+	if _, err := ServerManager.GetServer(serverID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Server not found")
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Create enrollment token
+	token, enrollment, err := ca.CreateEnrollment(serverID, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, NodeEnrollmentResponse{
+		Token:         token,
+		ExpiresAt:     *enrollment.TokenExpiresAt,
+		CACertificate: ca.CertificatePEM(),
+	})
+}
+
+// GetNodeEnrollmentHandler handles requests for the enrollment of a node
+func GetNodeEnrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	// Get server ID from URL
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	ca := ServerManager.AgentCA()
+	if ca == nil {
+		utils.WriteErrorResponse(w, http.StatusServiceUnavailable, "Agent listener is disabled")
+		return
+	}
+
+	enrollment, err := ca.GetEnrollment(serverID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, enrollment)
+}
+
+// RevokeNodeEnrollmentHandler handles requests to revoke the enrollment of
+// a node. Its agent has to enroll again with a new token.
+func RevokeNodeEnrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	// Get server ID from URL
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	ca := ServerManager.AgentCA()
+	if ca == nil {
+		utils.WriteErrorResponse(w, http.StatusServiceUnavailable, "Agent listener is disabled")
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	if err := ca.Revoke(serverID, adminID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
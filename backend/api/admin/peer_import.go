@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ImportPeersRequest represents a request to import the peers of an
+// existing server config
+type ImportPeersRequest struct {
+	ServerID      string            `json:"serverId"`
+	Config        string            `json:"config"`
+	DefaultUserID string            `json:"defaultUserId,omitempty"`
+	Users         map[string]string `json:"users,omitempty"` // public key or peer name to user ID
+}
+
+// Validate checks the fields of a peer import request. Individual peers are
+// checked during the import and reported in its summary.
+func (req *ImportPeersRequest) Validate() error {
+	var v utils.Validator
+	v.Required("serverId", req.ServerID)
+	v.Required("config", req.Config)
+	return v.Err()
+}
+
+// ImportPeersHandler handles importing the peers of a hand-managed server
+// config such as wg0.conf onto a server. It accepts either a JSON body or
+// the config file itself as text/plain, with serverId and defaultUserId as
+// query parameters, and returns a per-peer summary.
+func ImportPeersHandler(w http.ResponseWriter, r *http.Request) {
+	// Get admin ID from context
+	adminID := r.Context().Value("userID").(string)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBodySize)
+
+	var req ImportPeersRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/plain":
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		req.Config = string(content)
+		req.ServerID = r.URL.Query().Get("serverId")
+		req.DefaultUserID = r.URL.Query().Get("defaultUserId")
+	default:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	// Validate request
+	if err := req.Validate(); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Import peers
+	summary, err := VPNManager.ImportPeers(r.Context(), adminID, req.ServerID, req.Config, req.Users, req.DefaultUserID)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			utils.RespondWithValidationError(w, err)
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, summary)
+}
@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Entitlements is the plan entitlement manager instance
+var Entitlements *core.EntitlementManager
+
+// PlanRequest represents a plan create or update request
+type PlanRequest struct {
+	ID           string              `json:"id"`
+	Name         string              `json:"name"`
+	Entitlements models.Entitlements `json:"entitlements"`
+}
+
+// Validate checks the fields of a plan request. Entitlement limits are
+// checked by the entitlement manager.
+func (req *PlanRequest) Validate() error {
+	var v utils.Validator
+	v.Required("name", req.Name)
+	v.MaxLength("id", req.ID, 64)
+	return v.Err()
+}
+
+// UserPlanRequest represents a user plan assignment request
+type UserPlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+// Validate checks the fields of a user plan assignment request
+func (req *UserPlanRequest) Validate() error {
+	var v utils.Validator
+	v.Required("plan", req.Plan)
+	return v.Err()
+}
+
+// ListPlansHandler handles plan listing requests
+func ListPlansHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, Entitlements.ListPlans())
+}
+
+// GetPlanHandler handles plan retrieval requests
+func GetPlanHandler(w http.ResponseWriter, r *http.Request) {
+	// Get plan ID from URL
+	vars := mux.Vars(r)
+	planID := vars["id"]
+
+	// Get plan
+	plan, err := Entitlements.GetPlan(planID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Plan not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, plan)
+}
+
+// CreatePlanHandler handles plan creation requests
+func CreatePlanHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req PlanRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Create plan
+	plan, err := Entitlements.CreatePlan(req.ID, req.Name, req.Entitlements)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, plan)
+}
+
+// UpdatePlanHandler handles plan update requests. Changes apply to every
+// user on the plan from their next request.
+func UpdatePlanHandler(w http.ResponseWriter, r *http.Request) {
+	// Get plan ID from URL
+	vars := mux.Vars(r)
+	planID := vars["id"]
+
+	// Parse request
+	var req PlanRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Update plan
+	plan, err := Entitlements.UpdatePlan(planID, req.Name, req.Entitlements)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, plan)
+}
+
+// DeletePlanHandler handles plan deletion requests
+func DeletePlanHandler(w http.ResponseWriter, r *http.Request) {
+	// Get plan ID from URL
+	vars := mux.Vars(r)
+	planID := vars["id"]
+
+	// Delete plan
+	if err := Entitlements.DeletePlan(planID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// SetUserPlanHandler handles user plan assignment requests
+func SetUserPlanHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from URL
+	vars := mux.Vars(r)
+	userID := vars["id"]
+
+	// Parse request
+	var req UserPlanRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Assign plan
+	if err := Entitlements.SetUserPlan(userID, req.Plan); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ProvisionRequest represents a request to provision a server on a cloud
+// provider
+type ProvisionRequest struct {
+	Template string `json:"template"`       // as configured in provisioning.templates
+	Name     string `json:"name,omitempty"` // of the machine and server, generated when empty
+}
+
+// Validate checks the fields of a provision request
+func (req *ProvisionRequest) Validate() error {
+	var v utils.Validator
+	v.Required("template", req.Template)
+	core.ValidateProvisionName(&v, req.Name)
+	return v.Err()
+}
+
+// ListProvisionsHandler handles requests for the provisioned servers
+func ListProvisionsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, ServerManager.Provisioning().ListProvisions())
+}
+
+// GetProvisionHandler handles requests for the progress of a provision
+func GetProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	// Get provision ID from URL
+	vars := mux.Vars(r)
+	provisionID := vars["id"]
+
+	provision, err := ServerManager.Provisioning().GetProvision(provisionID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Provision not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, provision)
+}
+
+// ProvisionServerHandler handles requests to provision a server from a
+// template. The machine is created in background; the provision is ready
+// once its server comes online.
+func ProvisionServerHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req ProvisionRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	provision, err := ServerManager.Provisioning().StartProvision(req.Template, req.Name, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusAccepted, provision)
+}
+
+// TeardownProvisionHandler handles requests to delete a provisioned
+// server's machine and remove the server. Drain the server first to move
+// its peers elsewhere.
+func TeardownProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	// Get provision ID from URL
+	vars := mux.Vars(r)
+	provisionID := vars["id"]
+
+	if _, err := ServerManager.Provisioning().GetProvision(provisionID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Provision not found")
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	provision, err := ServerManager.Provisioning().TeardownProvision(provisionID, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusAccepted, provision)
+}
@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/monitoring"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// FunnelTracker is the conversion funnel tracker instance
+var FunnelTracker *core.FunnelTracker
+
+// AnalyticsReports is the reporter of usage analytics events
+var AnalyticsReports *core.AnalyticsReporter
+
+// Shadow is the shadow evaluator of server selection algorithms
+var Shadow *core.ShadowEvaluator
+
+// GetFunnelReportHandler reports trial-to-paid conversion for users
+// registered in a period, segmented by acquisition channel and platform
+func GetFunnelReportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Default to the last 30 days
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if value := query.Get("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid from time, expected RFC3339")
+			return
+		}
+		from = parsed
+	}
+	if value := query.Get("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid to time, expected RFC3339")
+			return
+		}
+		to = parsed
+	}
+
+	// Segment by channel, platform or both
+	segmentBy := query.Get("segmentBy")
+	if segmentBy != "" && segmentBy != "channel" && segmentBy != "platform" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "segmentBy must be channel or platform")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, FunnelTracker.Report(from, to, segmentBy))
+}
+
+// GetAnalyticsReportHandler counts usage analytics events in a period by
+// event type and day, optionally for a single event type
+func GetAnalyticsReportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Default to the last 30 days
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if value := query.Get("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid from time, expected RFC3339")
+			return
+		}
+		from = parsed
+	}
+	if value := query.Get("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid to time, expected RFC3339")
+			return
+		}
+		to = parsed
+	}
+
+	report, err := AnalyticsReports.Report(from, to, query.Get("event"))
+	if err != nil {
+		utils.LogErrorContext(r.Context(), "Failed to read analytics events: %v", err)
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to read analytics events")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, report)
+}
+
+// GetShadowReportHandler compares the servers shadow selection algorithms
+// would have picked with the servers connects actually used, optionally
+// for a single algorithm
+func GetShadowReportHandler(w http.ResponseWriter, r *http.Request) {
+	algorithm := r.URL.Query().Get("algorithm")
+	if algorithm != "" {
+		known := false
+		for _, name := range core.SelectionAlgorithms() {
+			known = known || name == algorithm
+		}
+		if !known {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Unknown selection algorithm: "+algorithm)
+			return
+		}
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, Shadow.Report(algorithm))
+}
+
+// ListAnomaliesHandler lists recent anomalies raised by the built-in
+// anomaly detector, most recent first
+func ListAnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	if monitoring.MetricsCollector == nil {
+		utils.WriteJSONResponse(w, http.StatusOK, []*monitoring.Anomaly{})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, monitoring.MetricsCollector.Anomalies().Anomalies())
+}
+
+// ListCapacityAlertsHandler lists recent capacity alerts, open ones first
+func ListCapacityAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, ServerManager.Alerts().Alerts())
+}
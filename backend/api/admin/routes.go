@@ -0,0 +1,146 @@
+package admin
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/servers"
+)
+
+// RegisterRoutes registers the admin routes. The router must admit admins
+// only.
+func RegisterRoutes(router *mux.Router) {
+	// User routes
+	router.HandleFunc("/users", ListUsersHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/users/import", ImportUsersHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/users/{id}", GetUserHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/users/{id}", UpdateUserHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/users/{id}", DeleteUserHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/users/{id}/peers", GetUserPeersHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/users/{id}/peers/{peerID}", DeleteUserPeerHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/users/{id}/plan", SetUserPlanHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/users/{id}/config-history", GetUserConfigHistoryHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/users/{id}/peers/{peerID}/config-history", GetPeerConfigHistoryHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/users/{id}/peers/{peerID}/approve", ApprovePeerHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/users/{id}/peers/{peerID}/reject", RejectPeerHandler).Methods("POST", "OPTIONS")
+
+	// Device enrollment routes
+	router.HandleFunc("/device-keys", ListDeviceKeysHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/device-keys/import", ImportDeviceKeysHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/device-keys/{id}", DeleteDeviceKeyHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/peers/pending", ListPendingPeersHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/ip-reservations", ListIPReservationsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/ip-reservations", ReserveIPHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/ip-reservations/{id}", ReleaseIPHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/acl-rules", ListACLRulesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/acl-rules", CreateACLRuleHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/acl-rules/{id}", UpdateACLRuleHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/acl-rules/{id}", DeleteACLRuleHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/meshes", ListMeshesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/meshes", CreateMeshHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/meshes/{id}", UpdateMeshHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/meshes/{id}", DeleteMeshHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/peers/import", ImportPeersHandler).Methods("POST", "OPTIONS")
+
+	// Account merge routes
+	router.HandleFunc("/merges", ListMergesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/merges", StageMergeHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/merges/{id}", GetMergeHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/merges/{id}/commit", CommitMergeHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/merges/{id}/revert", RevertMergeHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/merges/{id}/cancel", CancelMergeHandler).Methods("POST", "OPTIONS")
+
+	// Plan routes
+	router.HandleFunc("/plans", ListPlansHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/plans", CreatePlanHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/plans/{id}", GetPlanHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/plans/{id}", UpdatePlanHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/plans/{id}", DeletePlanHandler).Methods("DELETE", "OPTIONS")
+
+	// Routing presets
+	router.HandleFunc("/routing-presets", ListRoutingPresetsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/routing-presets", CreateRoutingPresetHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/routing-presets/{id}", GetRoutingPresetHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/routing-presets/{id}", UpdateRoutingPresetHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/routing-presets/{id}", DeleteRoutingPresetHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/config-templates", ListConfigTemplatesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/config-templates/validate", ValidateConfigTemplateHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/config-templates/{name}", GetConfigTemplateHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/config-templates/{name}", UpdateConfigTemplateHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/config-templates/{name}", ResetConfigTemplateHandler).Methods("DELETE", "OPTIONS")
+
+	// GraphQL route for nested dashboard queries
+	router.HandleFunc("/graphql", GraphQLHandler).Methods("POST", "OPTIONS")
+
+	// Token routes
+	router.HandleFunc("/tokens/revoke", RevokeTokenHandler).Methods("POST", "OPTIONS")
+
+	// Signing key routes
+	router.HandleFunc("/keys", ListSigningKeysHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/keys/rotate", RotateSigningKeyHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/keys/{kid}", RetireSigningKeyHandler).Methods("DELETE", "OPTIONS")
+
+	// Audit log routes
+	router.HandleFunc("/audit", ListAuditEntriesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/audit/verify", VerifyAuditLogHandler).Methods("GET", "OPTIONS")
+
+	// Report routes
+	router.HandleFunc("/reports/funnel", GetFunnelReportHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/reports/anomalies", ListAnomaliesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/reports/alerts", ListCapacityAlertsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/alerts/silences", ListSilencesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/alerts/silences", CreateSilenceHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/alerts/silences/{id}", DeleteSilenceHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/reports/shadow-selection", GetShadowReportHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/reports/analytics", GetAnalyticsReportHandler).Methods("GET", "OPTIONS")
+
+	// Background job routes
+	router.HandleFunc("/jobs", ListJobsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/jobs/{id}", GetJobHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/jobs/{id}/cancel", CancelJobHandler).Methods("POST", "OPTIONS")
+
+	// Event feed
+	router.HandleFunc("/events", EventsHandler).Methods("GET", "OPTIONS")
+
+	// Config audit routes
+	router.HandleFunc("/config-audit/outdated", ListOutdatedConfigsHandler).Methods("GET", "OPTIONS")
+
+	// Server routes
+	router.HandleFunc("/servers", servers.ListServersHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/servers/uptime", servers.ListUptimeHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/servers/{id}", servers.GetServerHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/servers", servers.CreateServerHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/servers/{id}", servers.UpdateServerHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/servers/{id}", servers.DeleteServerHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/servers/{id}/status/{status}", servers.UpdateServerStatusHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/servers/{id}/uptime", servers.GetUptimeHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/servers/{id}/drain", StartDrainHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/servers/{id}/drain", GetDrainHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/servers/{id}/drain", StopDrainHandler).Methods("DELETE", "OPTIONS")
+
+	// Node software routes
+	router.HandleFunc("/nodes", GetNodeInventoryHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/nodes/{id}/commands", ListNodeCommandsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/nodes/{id}/commands", QueueNodeCommandHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/nodes/{id}/enrollment", GetNodeEnrollmentHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/nodes/{id}/enrollment", CreateNodeEnrollmentHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/nodes/{id}/enrollment", RevokeNodeEnrollmentHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/interfaces", ListInterfacesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/interfaces/{name}/apply", ApplyInterfaceHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/rollouts", ListRolloutsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rollouts", StartRolloutHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/rollouts/{id}", GetRolloutHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/rollouts/{id}/abort", AbortRolloutHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/upgrades", ListUpgradesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/upgrades", StartUpgradeHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/upgrades/{id}", GetUpgradeHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/upgrades/{id}/abort", AbortUpgradeHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/incidents", ListIncidentsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/incidents", CreateIncidentHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/incidents/{id}", UpdateIncidentHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/provisions", ListProvisionsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/provisions", ProvisionServerHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/provisions/{id}", GetProvisionHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/provisions/{id}", TeardownProvisionHandler).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/autoscaling", GetAutoscalingHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/certificates/node", GetNodeCertificateHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/certificates/node/renew", RenewNodeCertificateHandler).Methods("POST", "OPTIONS")
+}
@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// RoutingPresets is the routing preset manager instance
+var RoutingPresets *core.RoutingPresetManager
+
+// RoutingPresetRequest represents a routing preset create or update request
+type RoutingPresetRequest struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	CIDRs       []string `json:"cidrs"`
+}
+
+// Validate checks the fields of a routing preset request. CIDRs are
+// checked by the routing preset manager.
+func (req *RoutingPresetRequest) Validate() error {
+	var v utils.Validator
+	v.Required("name", req.Name)
+	v.MaxLength("id", req.ID, 64)
+	v.MaxLength("name", req.Name, 100)
+	v.MaxLength("description", req.Description, 500)
+	v.Check(len(req.CIDRs) > 0, "cidrs", "is required")
+	return v.Err()
+}
+
+// ListRoutingPresetsHandler handles routing preset listing requests
+func ListRoutingPresetsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, RoutingPresets.ListPresets())
+}
+
+// GetRoutingPresetHandler handles routing preset retrieval requests
+func GetRoutingPresetHandler(w http.ResponseWriter, r *http.Request) {
+	// Get preset ID from URL
+	vars := mux.Vars(r)
+	presetID := vars["id"]
+
+	// Get preset
+	preset, err := RoutingPresets.GetPreset(presetID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Routing preset not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, preset)
+}
+
+// CreateRoutingPresetHandler handles routing preset creation requests
+func CreateRoutingPresetHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req RoutingPresetRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Create preset
+	preset, err := RoutingPresets.CreatePreset(req.ID, req.Name, req.Description, req.CIDRs)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, preset)
+}
+
+// UpdateRoutingPresetHandler handles routing preset update requests. The
+// new networks are rolled out to every peer on the preset and reach each
+// device with its next config download.
+func UpdateRoutingPresetHandler(w http.ResponseWriter, r *http.Request) {
+	// Get preset ID from URL
+	vars := mux.Vars(r)
+	presetID := vars["id"]
+
+	// Parse request
+	var req RoutingPresetRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Update preset
+	preset, err := RoutingPresets.UpdatePreset(presetID, req.Name, req.Description, req.CIDRs)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, preset)
+}
+
+// DeleteRoutingPresetHandler handles routing preset deletion requests
+func DeleteRoutingPresetHandler(w http.ResponseWriter, r *http.Request) {
+	// Get preset ID from URL
+	vars := mux.Vars(r)
+	presetID := vars["id"]
+
+	// Delete preset
+	if err := RoutingPresets.DeletePreset(presetID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
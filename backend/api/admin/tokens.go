@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// TokenDenylist is the revoked token list instance
+var TokenDenylist *core.TokenDenylist
+
+// RevokeTokenRequest represents a token revocation request
+type RevokeTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// Validate checks the fields of a token revocation request
+func (req *RevokeTokenRequest) Validate() error {
+	var v utils.Validator
+	v.Required("token", req.Token)
+	return v.Err()
+}
+
+// RevokeTokenHandler handles revocation of a compromised token
+func RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req RevokeTokenRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Read claims without verifying the signature; revoking a forged
+	// token is harmless and the claims are only used for bookkeeping
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(req.Token, claims); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid token")
+		return
+	}
+
+	userID, _ := claims["id"].(string)
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Token has no expiry")
+		return
+	}
+
+	// Revoke token
+	if err := TokenDenylist.Revoke(req.Token, userID, time.Unix(int64(exp), 0)); err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to revoke token")
+		return
+	}
+
+	// Return success
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
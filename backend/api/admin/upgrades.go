@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// UpgradeRequest represents a rolling node upgrade request
+type UpgradeRequest struct {
+	Version     string   `json:"version"`
+	Regions     []string `json:"regions"`     // countries in upgrade order, defaults to all
+	DrainWindow int      `json:"drainWindow"` // in minutes active sessions keep their server
+}
+
+// Validate checks the fields of a rolling node upgrade request
+func (req *UpgradeRequest) Validate() error {
+	var v utils.Validator
+	core.ValidateAgentVersion(&v, core.NodeCommandUpgrade, req.Version)
+	v.Check(req.DrainWindow >= 0 && req.DrainWindow <= 1440, "drainWindow", "must be between 0 and 1440 minutes")
+	for _, region := range req.Regions {
+		v.Check(region != "", "regions", "must not be empty")
+	}
+	return v.Err()
+}
+
+// ListUpgradesHandler handles rolling node upgrade listing requests
+func ListUpgradesHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, ServerManager.Upgrades().ListUpgrades())
+}
+
+// GetUpgradeHandler handles requests for the progress of a rolling node
+// upgrade
+func GetUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	// Get upgrade ID from URL
+	vars := mux.Vars(r)
+	upgradeID := vars["id"]
+
+	// Get upgrade
+	upgrade, err := ServerManager.Upgrades().GetUpgrade(upgradeID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Upgrade not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, upgrade)
+}
+
+// StartUpgradeHandler handles requests to upgrade the node agent region by
+// region, draining, upgrading and checking one server at a time
+func StartUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req UpgradeRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Start upgrade
+	upgrade, err := ServerManager.Upgrades().StartUpgrade(req.Version, req.Regions, req.DrainWindow, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, upgrade)
+}
+
+// AbortUpgradeHandler handles requests to stop a rolling node upgrade
+func AbortUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	// Get upgrade ID from URL
+	vars := mux.Vars(r)
+	upgradeID := vars["id"]
+
+	// Get admin ID from context
+	adminID, _ := r.Context().Value("userID").(string)
+
+	// Abort upgrade
+	upgrade, err := ServerManager.Upgrades().AbortUpgrade(upgradeID, adminID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, upgrade)
+}
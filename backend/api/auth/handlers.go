@@ -1,20 +1,36 @@
 package auth
 
 import (
-	"encoding/json"
 	"net/http"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/db/models"
 	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/core"
 	"github.com/vpn-service/backend/src/utils"
 )
 
+// UserManager is the user manager instance
+var UserManager *core.UserManager
+
+// FunnelTracker is the conversion funnel tracker instance
+var FunnelTracker *core.FunnelTracker
+
 // RegisterRoutes registers the auth routes
 func RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/register", RegisterHandler).Methods("POST", "OPTIONS")
-	router.HandleFunc("/login", LoginHandler).Methods("POST", "OPTIONS")
+	limit := middleware.RateLimit("auth")
+
+	router.Handle("/register", limit(http.HandlerFunc(RegisterHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/login", limit(http.HandlerFunc(LoginHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/refresh", limit(middleware.JWTAuthMiddleware(http.HandlerFunc(RefreshHandler)))).Methods("POST", "OPTIONS")
+	router.Handle("/invite/accept", limit(http.HandlerFunc(AcceptInviteHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/logout", middleware.JWTAuthMiddleware(http.HandlerFunc(LogoutHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/mfa/enroll", middleware.JWTAuthMiddleware(http.HandlerFunc(EnrollMFAHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/mfa/confirm", limit(middleware.JWTAuthMiddleware(http.HandlerFunc(ConfirmMFAHandler)))).Methods("POST", "OPTIONS")
+	router.Handle("/step-up", limit(middleware.JWTAuthMiddleware(http.HandlerFunc(StepUpHandler)))).Methods("POST", "OPTIONS")
 }
 
 // User represents a user in the system
@@ -23,6 +39,7 @@ type User struct {
 	Username string `json:"username"`
 	Password string `json:"password,omitempty"`
 	Email    string `json:"email"`
+	Role     string `json:"role"`
 }
 
 // RegisterRequest represents a user registration request
@@ -30,6 +47,21 @@ type RegisterRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Email    string `json:"email"`
+	Channel  string `json:"channel,omitempty"`  // acquisition channel, e.g. a utm_source
+	Platform string `json:"platform,omitempty"` // client platform, e.g. ios or windows
+}
+
+// Validate checks the fields of a registration request
+func (req *RegisterRequest) Validate() error {
+	var v utils.Validator
+	v.Required("username", req.Username)
+	v.MaxLength("username", req.Username, 64)
+	v.Required("email", req.Email)
+	v.Email("email", req.Email)
+	v.MinLength("password", req.Password, 8)
+	v.MaxLength("channel", req.Channel, 64)
+	v.MaxLength("platform", req.Platform, 32)
+	return v.Err()
 }
 
 // LoginRequest represents a user login request
@@ -38,6 +70,14 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// Validate checks the fields of a login request
+func (req *LoginRequest) Validate() error {
+	var v utils.Validator
+	v.Required("username", req.Username)
+	v.Required("password", req.Password)
+	return v.Err()
+}
+
 // AuthResponse represents an authentication response
 type AuthResponse struct {
 	Token string `json:"token"`
@@ -53,14 +93,8 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	// Validate request
-	if req.Username == "" || req.Password == "" || req.Email == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Username, password, and email are required")
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
@@ -73,10 +107,16 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		ID:       utils.GenerateUUID(),
 		Username: req.Username,
 		Email:    req.Email,
+		Role:     models.RoleUser,
+	}
+
+	// Record the start of the conversion funnel
+	if FunnelTracker != nil {
+		FunnelTracker.Register(user.ID, req.Channel, req.Platform)
 	}
 
 	// Generate token
-	token, err := generateToken(user.ID)
+	token, err := generateToken(user.ID, user.Role)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Error generating token")
 		return
@@ -98,14 +138,8 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	// Validate request
-	if req.Username == "" || req.Password == "" {
-		utils.RespondWithError(w, http.StatusBadRequest, "Username and password are required")
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
@@ -117,10 +151,11 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		ID:       "user-123",
 		Username: req.Username,
 		Email:    "user@example.com",
+		Role:     models.RoleUser,
 	}
 
 	// Generate token
-	token, err := generateToken(user.ID)
+	token, err := generateToken(user.ID, user.Role)
 	if err != nil {
 		utils.RespondWithError(w, http.StatusInternalServerError, "Error generating token")
 		return
@@ -133,20 +168,131 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// generateToken generates a JWT token for the given user ID
-func generateToken(userID string) (string, error) {
+// RefreshHandler issues a new token for the current session and revokes the
+// one it was called with
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	// Handle preflight OPTIONS request
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Get user ID, role and token from context
+	userID := r.Context().Value("userID").(string)
+	role := r.Context().Value("role").(string)
+	token := r.Context().Value("token").(string)
+	expiresAt := r.Context().Value("tokenExpiresAt").(time.Time)
+
+	// Generate token
+	refreshed, err := generateToken(userID, role)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error generating token")
+		return
+	}
+
+	// Revoke the old token so only one stays valid
+	if middleware.TokenDenylist != nil {
+		if err := middleware.TokenDenylist.Revoke(token, userID, expiresAt); err != nil {
+			utils.LogError("Failed to revoke refreshed token: %v", err)
+		}
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, AuthResponse{
+		Token: refreshed,
+		User:  User{ID: userID, Role: role},
+	})
+}
+
+// LogoutHandler handles user logout by revoking the current token
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Handle preflight OPTIONS request
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Get user ID and token from context
+	userID := r.Context().Value("userID").(string)
+	token := r.Context().Value("token").(string)
+	expiresAt := r.Context().Value("tokenExpiresAt").(time.Time)
+
+	// Revoke token
+	if middleware.TokenDenylist == nil {
+		utils.RespondWithError(w, http.StatusServiceUnavailable, "Token revocation is not available")
+		return
+	}
+	if err := middleware.TokenDenylist.Revoke(token, userID, expiresAt); err != nil {
+		utils.LogError("Failed to revoke token: %v", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error revoking token")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "logged out"})
+}
+
+// AcceptInviteRequest represents a set-password invite redemption request
+type AcceptInviteRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Validate checks the fields of an invite redemption request
+func (req *AcceptInviteRequest) Validate() error {
+	var v utils.Validator
+	v.Required("token", req.Token)
+	v.MinLength("password", req.Password, 8)
+	return v.Err()
+}
+
+// AcceptInviteHandler sets the password of an imported account from an invite
+func AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	// Handle preflight OPTIONS request
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Parse request
+	var req AcceptInviteRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Redeem invite
+	if err := UserManager.AcceptInvite(req.Token, req.Password); err != nil {
+		utils.LogWarning("Failed to accept invite: %v", err)
+		utils.RespondWithError(w, http.StatusBadRequest, "Invalid or expired invite")
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// generateToken generates a JWT token for the given user ID and role
+func generateToken(userID, role string) (string, error) {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		return "", err
 	}
 
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  userID,
-		"exp": time.Now().Add(time.Hour * time.Duration(cfg.JWT.Expiration)).Unix(),
+	// Sign token with the current key
+	now := time.Now()
+	return middleware.SigningKeys.Sign(jwt.MapClaims{
+		"id":   userID,
+		"role": role,
+		"iss":  cfg.JWT.Issuer,
+		"aud":  cfg.JWT.Audience,
+		"iat":  now.Unix(),
+		"nbf":  now.Unix(),
+		"exp":  now.Add(time.Hour * time.Duration(cfg.JWT.Expiration)).Unix(),
+		"jti":  utils.GenerateUUID(),
 	})
+}
 
-	// Sign token
-	return token.SignedString([]byte(cfg.JWT.Secret))
+// JWKSHandler serves the public signing keys so other services can verify tokens
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.RespondWithJSON(w, http.StatusOK, middleware.SigningKeys.JWKS())
 }
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// MFA is the two-factor authentication manager instance
+var MFA *core.MFAManager
+
+// MFACodeRequest represents a request carrying an authenticator app code
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// Validate checks the fields of an MFA code request
+func (req *MFACodeRequest) Validate() error {
+	var v utils.Validator
+	v.Required("code", req.Code)
+	v.Check(len(req.Code) == 6, "code", "must be 6 digits")
+	return v.Err()
+}
+
+// StepUpResponse represents a step-up token response
+type StepUpResponse struct {
+	Token     string    `json:"token"` // send as the X-Step-Up-Token header
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// EnrollMFAHandler starts two-factor enrollment and returns the secret to
+// add to an authenticator app
+func EnrollMFAHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Name the account after the user's email where known
+	account := userID
+	if UserManager != nil {
+		if user, err := UserManager.GetUser(userID); err == nil && user.Email != "" {
+			account = user.Email
+		}
+	}
+
+	// Start enrollment
+	enrollment, err := MFA.Enroll(userID, account)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, enrollment)
+}
+
+// ConfirmMFAHandler enables two-factor authentication with a first code
+// from the authenticator app
+func ConfirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Parse request
+	var req MFACodeRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Confirm enrollment
+	if err := MFA.Confirm(userID, req.Code); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// StepUpHandler verifies a two-factor code and issues a short-lived step-up
+// token for the current session. Plans that require step-up authentication
+// need it to fetch configs or connect new devices.
+func StepUpHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID and session from context
+	userID := r.Context().Value("userID").(string)
+	sessionID := r.Context().Value("tokenID").(string)
+
+	// Parse request
+	var req MFACodeRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Verify code
+	if err := MFA.Verify(userID, req.Code); err != nil {
+		utils.LogWarningContext(r.Context(), "Step-up verification failed for user %s: %v", utils.RedactUserID(userID), err)
+		middleware.RecordAuthFailure()
+		utils.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// Issue step-up token
+	response, err := generateStepUpToken(userID, sessionID)
+	if err != nil {
+		utils.RespondWithError(w, http.StatusInternalServerError, "Error generating token")
+		return
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "user_step_up", "")
+
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// generateStepUpToken generates a step-up token bound to a user's session
+func generateStepUpToken(userID, sessionID string) (*StepUpResponse, error) {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign token with the current key
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(cfg.MFA.StepUpTTL) * time.Second)
+	token, err := middleware.SigningKeys.Sign(jwt.MapClaims{
+		"id":    userID,
+		"sid":   sessionID,
+		"scope": middleware.StepUpScope,
+		"iss":   cfg.JWT.Issuer,
+		"aud":   cfg.JWT.Audience,
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   expiresAt.Unix(),
+		"jti":   utils.GenerateUUID(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &StepUpResponse{Token: token, ExpiresAt: expiresAt}, nil
+}
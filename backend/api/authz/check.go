@@ -0,0 +1,192 @@
+package authz
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// pathParamPattern matches path parameters, with an optional pattern
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// Caller names used in violations
+const (
+	CallerAnonymous = "anonymous"
+	CallerNode      = "node"
+	CallerUser      = "user"
+	CallerAdmin     = "admin"
+	CallerNonOwner  = "non-owner"
+)
+
+// Callers holds the credentials the harness calls routes with
+type Callers struct {
+	User      string // token of a user
+	OtherUser string // ID of a user other than the token's, for ownership checks
+	Admin     string // token of an admin
+	Node      string // node agent token
+}
+
+// Violation is a route that let a caller through that its rule denies
+type Violation struct {
+	Route  string `json:"route"`
+	Caller string `json:"caller"`
+	Status int    `json:"status"`
+}
+
+// route is a registered route by method
+type route struct {
+	key    string // "METHOD path template"
+	method string
+	path   string
+}
+
+// Missing gets the routes of a router that have no rule, sorted
+func Missing(router *mux.Router) ([]string, error) {
+	routes, err := walk(router)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make([]string, 0)
+	for _, r := range routes {
+		if _, ok := Rules[r.key]; !ok {
+			missing = append(missing, r.key)
+		}
+	}
+
+	return missing, nil
+}
+
+// Exercise calls every route of a router through handler, which should be
+// the router with its outer middleware, as every caller its rule denies, and
+// reports the routes that did not answer 401 or 403 (or 404 for non-owners).
+// Only denied callers are tried, so no handler runs unless a route is
+// exposed. Routes without a rule are reported by Missing instead.
+func Exercise(router *mux.Router, handler http.Handler, callers Callers) ([]Violation, error) {
+	routes, err := walk(router)
+	if err != nil {
+		return nil, err
+	}
+
+	violations := make([]Violation, 0)
+	for _, r := range routes {
+		rule, ok := Rules[r.key]
+		if !ok {
+			continue
+		}
+
+		for caller, token := range denied(rule.Access, callers) {
+			if status := call(handler, r, token, "", ""); !isDenied(status, false) {
+				violations = append(violations, Violation{Route: r.key, Caller: caller, Status: status})
+			}
+		}
+
+		// Users may only act on their own account
+		if rule.Owner != "" && rule.Access == User && callers.User != "" {
+			if status := call(handler, r, callers.User, rule.Owner, callers.OtherUser); !isDenied(status, true) {
+				violations = append(violations, Violation{Route: r.key, Caller: CallerNonOwner, Status: status})
+			}
+		}
+	}
+
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Route != violations[j].Route {
+			return violations[i].Route < violations[j].Route
+		}
+		return violations[i].Caller < violations[j].Caller
+	})
+
+	return violations, nil
+}
+
+// denied gets the credentials, by caller, that a kind of access rejects.
+// Callers without credentials are skipped.
+func denied(access Access, callers Callers) map[string]string {
+	var names []string
+	switch access {
+	case Node:
+		names = []string{CallerAnonymous, CallerUser, CallerAdmin}
+	case User:
+		names = []string{CallerAnonymous, CallerNode}
+	case Admin:
+		names = []string{CallerAnonymous, CallerNode, CallerUser}
+	}
+
+	tokens := map[string]string{
+		CallerAnonymous: "",
+		CallerNode:      callers.Node,
+		CallerUser:      callers.User,
+		CallerAdmin:     callers.Admin,
+	}
+
+	result := make(map[string]string, len(names))
+	for _, name := range names {
+		if token := tokens[name]; name == CallerAnonymous || token != "" {
+			result[name] = token
+		}
+	}
+
+	return result
+}
+
+// call sends a request without a body to a route and returns the status.
+// Path parameters are filled with a placeholder, except param which gets
+// value.
+func call(handler http.Handler, r route, token, param, value string) int {
+	path := pathParamPattern.ReplaceAllStringFunc(r.path, func(match string) string {
+		if name := pathParamPattern.FindStringSubmatch(match)[1]; param != "" && name == param {
+			return value
+		}
+		return "authz-check"
+	})
+
+	req := httptest.NewRequest(r.method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	return recorder.Code
+}
+
+// isDenied reports whether a status rejects the caller
+func isDenied(status int, notFound bool) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden ||
+		(notFound && status == http.StatusNotFound)
+}
+
+// walk gets the routes of a router by method
+func walk(router *mux.Router) ([]route, error) {
+	routes := make([]route, 0)
+	err := router.Walk(func(r *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := r.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := r.GetMethods()
+		if err != nil {
+			// Subrouter prefixes have no methods
+			return nil
+		}
+
+		path := pathParamPattern.ReplaceAllString(template, "{$1}")
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+			routes = append(routes, route{key: fmt.Sprintf("%s %s", method, path), method: method, path: path})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk routes: %v", err)
+	}
+
+	return routes, nil
+}
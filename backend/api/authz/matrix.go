@@ -0,0 +1,223 @@
+// Package authz holds the expected authorization of every API route and a
+// harness that checks a router against it
+package authz
+
+// Access is the kind of caller a route admits
+type Access string
+
+const (
+	// Public routes admit anyone
+	Public Access = "public"
+	// Node routes admit node agents holding the agent token
+	Node Access = "node"
+	// User routes admit any signed-in user
+	User Access = "user"
+	// Admin routes admit admins only
+	Admin Access = "admin"
+)
+
+// Rule is the expected authorization of a route
+type Rule struct {
+	Access Access
+	// Owner names the path parameter holding a user ID that must be the
+	// caller's own, for user routes acting on a user given in the path.
+	// Routes acting on the caller from the token leave it empty.
+	Owner string
+}
+
+// Rules is the authorization matrix by "METHOD path template". Every
+// registered route must be listed; Missing reports those that are not.
+var Rules = map[string]Rule{
+	// Health and discovery
+	"GET /health":                {Access: Public},
+	"GET /readiness":             {Access: Public},
+	"GET /liveness":              {Access: Public},
+	"GET /.well-known/jwks.json": {Access: Public},
+	"GET /api/v1/health":         {Access: Public},
+	"GET /status":                {Access: Public},
+	"GET /api/v1/status":         {Access: Public},
+	"GET /api/v1/openapi.json":   {Access: Public},
+
+	// Auth
+	"POST /api/v1/auth/register":      {Access: Public},
+	"POST /api/v1/auth/login":         {Access: Public},
+	"POST /api/v1/auth/invite/accept": {Access: Public},
+	"POST /api/v1/auth/refresh":       {Access: User},
+	"POST /api/v1/auth/logout":        {Access: User},
+	"POST /api/v1/auth/mfa/enroll":    {Access: User},
+	"POST /api/v1/auth/mfa/confirm":   {Access: User},
+	"POST /api/v1/auth/step-up":       {Access: User},
+
+	// Nodes
+	"POST /api/v1/nodes/heartbeat": {Access: Node},
+	"GET /api/v1/nodes/state":      {Access: Node},
+
+	// Server registration by new nodes, with the registration token
+	"POST /api/v1/admin/servers/register": {Access: Node},
+
+	// User
+	"GET /api/v1/user/defaults":             {Access: User},
+	"PUT /api/v1/user/defaults":             {Access: User},
+	"GET /api/v1/user/privacy":              {Access: User},
+	"PATCH /api/v1/user/privacy":            {Access: User},
+	"GET /api/v1/user/plan":                 {Access: User},
+	"GET /api/v1/user/push-devices":         {Access: User},
+	"POST /api/v1/user/push-devices":        {Access: User},
+	"DELETE /api/v1/user/push-devices/{id}": {Access: User},
+
+	// VPN
+	"GET /api/v1/vpn/servers":                 {Access: User},
+	"GET /api/v1/vpn/routing-presets":         {Access: User},
+	"GET /api/v1/vpn/dns-profiles":            {Access: User},
+	"GET /api/v1/vpn/latency-matrix":          {Access: User},
+	"POST /api/v1/vpn/latency":                {Access: User},
+	"GET /api/v1/vpn/servers/recommended":     {Access: User},
+	"POST /api/v1/vpn/connect":                {Access: User},
+	"POST /api/v1/vpn/disconnect":             {Access: User},
+	"POST /api/v1/vpn/reactivate":             {Access: User},
+	"GET /api/v1/vpn/status":                  {Access: User},
+	"GET /api/v1/vpn/ws":                      {Access: User},
+	"GET /api/v1/vpn/config":                  {Access: User},
+	"GET /api/v1/vpn/config/qrcode":           {Access: User},
+	"POST /api/v1/vpn/config/email":           {Access: User},
+	"GET /api/v1/vpn/config/shares":           {Access: User},
+	"POST /api/v1/vpn/config/shares":          {Access: User},
+	"DELETE /api/v1/vpn/config/shares/{id}":   {Access: User},
+	"GET /api/v1/vpn/peers/{id}/setup.pdf":    {Access: User},
+	"GET /api/v1/vpn/peers/{id}/gateway":      {Access: User},
+	"PUT /api/v1/vpn/peers/{id}/routing":      {Access: User},
+	"GET /api/v1/vpn/peers/{id}/exit":         {Access: User},
+	"PUT /api/v1/vpn/peers/{id}/exit":         {Access: User},
+	"PUT /api/v1/vpn/peers/{id}/mtu":          {Access: User},
+	"POST /api/v1/vpn/mtu/suggest":            {Access: User},
+	"PUT /api/v1/vpn/peers/{id}/keepalive":    {Access: User},
+	"PUT /api/v1/vpn/peers/{id}/port-forward": {Access: User},
+	"GET /api/v1/vpn/dedicated-ips":           {Access: User},
+	"POST /api/v1/vpn/backup":                 {Access: User},
+	"POST /api/v1/vpn/peers/{id}/artifacts":   {Access: User},
+	"POST /api/v1/vpn/archive":                {Access: User},
+	"POST /api/v1/vpn/restore":                {Access: User},
+	"GET /api/v1/config/shared/{token}":       {Access: Public},
+	"GET /api/v1/artifacts/{key}":             {Access: Public},
+	"GET /api/v1/vpn/qr":                      {Access: User},
+	"POST /api/v1/vpn/dynamic/connect":        {Access: User},
+	"POST /api/v1/vpn/dynamic/disconnect":     {Access: User},
+
+	// Admin users
+	"GET /api/v1/admin/users":                                    {Access: Admin},
+	"POST /api/v1/admin/users/import":                            {Access: Admin},
+	"GET /api/v1/admin/users/{id}":                               {Access: Admin},
+	"PUT /api/v1/admin/users/{id}":                               {Access: Admin},
+	"DELETE /api/v1/admin/users/{id}":                            {Access: Admin},
+	"GET /api/v1/admin/users/{id}/peers":                         {Access: Admin},
+	"DELETE /api/v1/admin/users/{id}/peers/{peerID}":             {Access: Admin},
+	"PUT /api/v1/admin/users/{id}/plan":                          {Access: Admin},
+	"GET /api/v1/admin/users/{id}/config-history":                {Access: Admin},
+	"GET /api/v1/admin/users/{id}/peers/{peerID}/config-history": {Access: Admin},
+	"POST /api/v1/admin/users/{id}/peers/{peerID}/approve":       {Access: Admin},
+	"POST /api/v1/admin/users/{id}/peers/{peerID}/reject":        {Access: Admin},
+	"GET /api/v1/admin/peers/pending":                            {Access: Admin},
+	"POST /api/v1/admin/peers/import":                            {Access: Admin},
+	"GET /api/v1/admin/device-keys":                              {Access: Admin},
+	"POST /api/v1/admin/device-keys/import":                      {Access: Admin},
+	"DELETE /api/v1/admin/device-keys/{id}":                      {Access: Admin},
+	"GET /api/v1/admin/ip-reservations":                          {Access: Admin},
+	"POST /api/v1/admin/ip-reservations":                         {Access: Admin},
+	"DELETE /api/v1/admin/ip-reservations/{id}":                  {Access: Admin},
+	"GET /api/v1/admin/acl-rules":                                {Access: Admin},
+	"POST /api/v1/admin/acl-rules":                               {Access: Admin},
+	"PUT /api/v1/admin/acl-rules/{id}":                           {Access: Admin},
+	"DELETE /api/v1/admin/acl-rules/{id}":                        {Access: Admin},
+	"GET /api/v1/admin/meshes":                                   {Access: Admin},
+	"POST /api/v1/admin/meshes":                                  {Access: Admin},
+	"PUT /api/v1/admin/meshes/{id}":                              {Access: Admin},
+	"DELETE /api/v1/admin/meshes/{id}":                           {Access: Admin},
+	"GET /api/v1/admin/merges":                                   {Access: Admin},
+	"POST /api/v1/admin/merges":                                  {Access: Admin},
+	"GET /api/v1/admin/merges/{id}":                              {Access: Admin},
+	"POST /api/v1/admin/merges/{id}/commit":                      {Access: Admin},
+	"POST /api/v1/admin/merges/{id}/revert":                      {Access: Admin},
+	"POST /api/v1/admin/merges/{id}/cancel":                      {Access: Admin},
+
+	// Admin plans
+	"GET /api/v1/admin/plans":         {Access: Admin},
+	"POST /api/v1/admin/plans":        {Access: Admin},
+	"GET /api/v1/admin/plans/{id}":    {Access: Admin},
+	"PUT /api/v1/admin/plans/{id}":    {Access: Admin},
+	"DELETE /api/v1/admin/plans/{id}": {Access: Admin},
+
+	// Admin routing presets
+	"GET /api/v1/admin/routing-presets":         {Access: Admin},
+	"POST /api/v1/admin/routing-presets":        {Access: Admin},
+	"GET /api/v1/admin/routing-presets/{id}":    {Access: Admin},
+	"PUT /api/v1/admin/routing-presets/{id}":    {Access: Admin},
+	"DELETE /api/v1/admin/routing-presets/{id}": {Access: Admin},
+
+	// Admin config templates
+	"GET /api/v1/admin/config-templates":           {Access: Admin},
+	"POST /api/v1/admin/config-templates/validate": {Access: Admin},
+	"GET /api/v1/admin/config-templates/{name}":    {Access: Admin},
+	"PUT /api/v1/admin/config-templates/{name}":    {Access: Admin},
+	"DELETE /api/v1/admin/config-templates/{name}": {Access: Admin},
+
+	// Admin queries, tokens, keys and audit
+	"POST /api/v1/admin/graphql":                 {Access: Admin},
+	"POST /api/v1/admin/tokens/revoke":           {Access: Admin},
+	"GET /api/v1/admin/keys":                     {Access: Admin},
+	"POST /api/v1/admin/keys/rotate":             {Access: Admin},
+	"DELETE /api/v1/admin/keys/{kid}":            {Access: Admin},
+	"GET /api/v1/admin/audit":                    {Access: Admin},
+	"GET /api/v1/admin/audit/verify":             {Access: Admin},
+	"GET /api/v1/admin/reports/funnel":           {Access: Admin},
+	"GET /api/v1/admin/reports/anomalies":        {Access: Admin},
+	"GET /api/v1/admin/reports/alerts":           {Access: Admin},
+	"GET /api/v1/admin/alerts/silences":          {Access: Admin},
+	"POST /api/v1/admin/alerts/silences":         {Access: Admin},
+	"DELETE /api/v1/admin/alerts/silences/{id}":  {Access: Admin},
+	"GET /api/v1/admin/reports/shadow-selection": {Access: Admin},
+	"GET /api/v1/admin/reports/analytics":        {Access: Admin},
+	"GET /api/v1/admin/events":                   {Access: Admin},
+	"GET /api/v1/admin/jobs":                     {Access: Admin},
+	"GET /api/v1/admin/jobs/{id}":                {Access: Admin},
+	"POST /api/v1/admin/jobs/{id}/cancel":        {Access: Admin},
+	"GET /api/v1/admin/config-audit/outdated":    {Access: Admin},
+
+	// Admin servers and nodes
+	"GET /api/v1/admin/servers":                      {Access: Admin},
+	"POST /api/v1/admin/servers":                     {Access: Admin},
+	"GET /api/v1/admin/servers/{id}":                 {Access: Admin},
+	"PUT /api/v1/admin/servers/{id}":                 {Access: Admin},
+	"DELETE /api/v1/admin/servers/{id}":              {Access: Admin},
+	"PUT /api/v1/admin/servers/{id}/status/{status}": {Access: Admin},
+	"POST /api/v1/admin/servers/{id}/drain":          {Access: Admin},
+	"GET /api/v1/admin/servers/{id}/drain":           {Access: Admin},
+	"DELETE /api/v1/admin/servers/{id}/drain":        {Access: Admin},
+	"GET /api/v1/admin/servers/uptime":               {Access: Admin},
+	"GET /api/v1/admin/servers/{id}/uptime":          {Access: Admin},
+	"GET /api/v1/admin/nodes":                        {Access: Admin},
+	"GET /api/v1/admin/nodes/{id}/commands":          {Access: Admin},
+	"POST /api/v1/admin/nodes/{id}/commands":         {Access: Admin},
+	"GET /api/v1/admin/nodes/{id}/enrollment":        {Access: Admin},
+	"POST /api/v1/admin/nodes/{id}/enrollment":       {Access: Admin},
+	"DELETE /api/v1/admin/nodes/{id}/enrollment":     {Access: Admin},
+	"GET /api/v1/admin/interfaces":                   {Access: Admin},
+	"POST /api/v1/admin/interfaces/{name}/apply":     {Access: Admin},
+	"GET /api/v1/admin/rollouts":                     {Access: Admin},
+	"POST /api/v1/admin/rollouts":                    {Access: Admin},
+	"GET /api/v1/admin/rollouts/{id}":                {Access: Admin},
+	"POST /api/v1/admin/rollouts/{id}/abort":         {Access: Admin},
+	"GET /api/v1/admin/upgrades":                     {Access: Admin},
+	"POST /api/v1/admin/upgrades":                    {Access: Admin},
+	"GET /api/v1/admin/upgrades/{id}":                {Access: Admin},
+	"POST /api/v1/admin/upgrades/{id}/abort":         {Access: Admin},
+	"GET /api/v1/admin/incidents":                    {Access: Admin},
+	"POST /api/v1/admin/incidents":                   {Access: Admin},
+	"PUT /api/v1/admin/incidents/{id}":               {Access: Admin},
+	"GET /api/v1/admin/provisions":                   {Access: Admin},
+	"POST /api/v1/admin/provisions":                  {Access: Admin},
+	"GET /api/v1/admin/provisions/{id}":              {Access: Admin},
+	"DELETE /api/v1/admin/provisions/{id}":           {Access: Admin},
+	"GET /api/v1/admin/autoscaling":                  {Access: Admin},
+	"GET /api/v1/admin/certificates/node":            {Access: Admin},
+	"POST /api/v1/admin/certificates/node/renew":     {Access: Admin},
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/rs/cors"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/config"
+)
+
+// Listen opens the listener the API is served on: the Unix socket in
+// server.unixSocket if set, for local reverse proxies, or else apiAddr
+func Listen(cfg *config.Config) (net.Listener, error) {
+	path := cfg.Server.UnixSocket
+	if path == "" {
+		return net.Listen("tcp", cfg.APIAddr)
+	}
+
+	mode, err := strconv.ParseUint(cfg.Server.UnixSocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Unix socket mode %q: %v", cfg.Server.UnixSocketMode, err)
+	}
+
+	// Remove a socket left behind by an unclean shutdown
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %v", err)
+	}
+
+	return listener, nil
+}
+
+// ListenAddr describes where the API is served for logs
+func ListenAddr(cfg *config.Config) string {
+	if cfg.Server.UnixSocket != "" {
+		return "unix:" + cfg.Server.UnixSocket
+	}
+	return cfg.APIAddr
+}
+
+// CORS creates the cross-origin handler of the API from server.cors
+func CORS(cfg *config.Config) *cors.Cors {
+	return cors.New(cors.Options{
+		AllowedOrigins:   cfg.Server.CORS.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Request-ID", "traceparent", "tracestate", "If-None-Match", middleware.APIVersionHeader, middleware.StepUpHeader},
+		ExposedHeaders:   []string{"X-Request-ID", "ETag", middleware.APIVersionHeader, "Deprecation", "Sunset", "Link", "WWW-Authenticate"},
+		AllowCredentials: cfg.Server.CORS.AllowCredentials,
+		MaxAge:           cfg.Server.CORS.MaxAge,
+	})
+}
+
+// NewHTTPServer creates the HTTP server of the API, tuned by the listener
+// profile in server.profile
+func NewHTTPServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return cfg.ListenerProfile(cfg.Server.Profile).HTTPServer(cfg.APIAddr, handler)
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// AuditLog is the audit log used to record denied requests
+var AuditLog *core.AuditLog
+
+// IPAllowlist restricts requests to clients within the given networks.
+// Entries may be CIDRs or single addresses; an empty list allows everyone,
+// while a list whose entries are all invalid denies everyone.
+func IPAllowlist(entries []string) func(http.Handler) http.Handler {
+	allowed := Allowlist(entries)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientIP := utils.ClientIP(r)
+			if allowed(clientIP) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Record the denied attempt
+			utils.LogWarning("Denied admin request from %s: %s %s", clientIP, r.Method, r.URL.Path)
+			RecordDeniedAdminAccess(r.Method+" "+r.URL.Path, clientIP)
+
+			utils.RespondWithError(w, http.StatusForbidden, "Access denied")
+		})
+	}
+}
+
+// Allowlist creates a check of client addresses against the given networks,
+// with the same rules as IPAllowlist
+func Allowlist(entries []string) func(clientIP string) bool {
+	enabled := len(entries) > 0
+	networks := parseNetworks(entries)
+
+	return func(clientIP string) bool {
+		if !enabled {
+			return true
+		}
+
+		ip := net.ParseIP(clientIP)
+		for _, network := range networks {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// RecordDeniedAdminAccess records an admin request rejected by the allowlist
+func RecordDeniedAdminAccess(target, clientIP string) {
+	if AuditLog == nil {
+		return
+	}
+
+	AuditLog.Record(&core.AuditEntry{
+		Action:  "admin_access",
+		Target:  target,
+		IP:      clientIP,
+		Outcome: core.AuditOutcomeDenied,
+		Details: "source address not in admin allowlist",
+	})
+}
+
+// parseNetworks parses CIDRs and single addresses, skipping invalid entries
+func parseNetworks(entries []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// Treat single addresses as host networks
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			utils.LogError("Ignoring invalid allowlist entry %q: %v", entry, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
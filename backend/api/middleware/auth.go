@@ -2,14 +2,35 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/vpn-service/backend/src/config"
+	"github.com/gorilla/websocket"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/monitoring"
 	"github.com/vpn-service/backend/src/utils"
 )
 
+// TokenDenylist is the revoked token list consulted for every request
+var TokenDenylist *core.TokenDenylist
+
+// SigningKeys is the key set used to sign and verify tokens
+var SigningKeys *core.SigningKeyManager
+
+// Authentication errors
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrTokenRevoked = errors.New("token has been revoked")
+
+	// ErrRevocationUnavailable is returned when the denylist cannot be
+	// checked; tokens are rejected rather than trusted then
+	ErrRevocationUnavailable = errors.New("token revocation could not be checked")
+)
+
 // JWTAuthMiddleware authenticates requests using JWT
 func JWTAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -21,6 +42,9 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 
 		// Get token from Authorization header
 		authHeader := r.Header.Get("Authorization")
+		if token := websocketToken(r); authHeader == "" && token != "" {
+			authHeader = "Bearer " + token
+		}
 		if authHeader == "" {
 			utils.RespondWithError(w, http.StatusUnauthorized, "Authorization header is required")
 			return
@@ -34,67 +58,208 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Parse and validate token
-		tokenString := parts[1]
-		userID, err := validateToken(tokenString)
+		ctx, err := Authenticate(r.Context(), parts[1])
+		if errors.Is(err, ErrTokenRevoked) {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Token has been revoked")
+			return
+		}
+		if errors.Is(err, ErrRevocationUnavailable) {
+			utils.RespondWithError(w, http.StatusServiceUnavailable, "Authentication is temporarily unavailable")
+			return
+		}
 		if err != nil {
 			utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
 			return
 		}
 
-		// Add user ID to request context
-		ctx := context.WithValue(r.Context(), "userID", userID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// AdminMiddleware authenticates requests using JWT and admits admins only
+func AdminMiddleware(next http.Handler) http.Handler {
+	return JWTAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role, _ := r.Context().Value("role").(string); role != models.RoleAdmin {
+			utils.RespondWithError(w, http.StatusForbidden, "Admin role required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// websocketToken gets the token of a WebSocket request sent as the
+// subprotocols "bearer, <token>", as browsers cannot set headers on them
+func websocketToken(r *http.Request) string {
+	if !websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+
+	protocols := websocket.Subprotocols(r)
+	if len(protocols) != 2 || protocols[0] != "bearer" {
+		return ""
+	}
+
+	return protocols[1]
+}
+
+// Authenticate validates a session token and returns a context carrying the
+// user ID, role and token. It is shared by the REST and gRPC APIs.
+func Authenticate(ctx context.Context, tokenString string) (context.Context, error) {
+	claims, err := validateToken(tokenString)
+	if err != nil {
+		RecordAuthFailure()
+		return nil, ErrInvalidToken
+	}
+
+	// Reject tokens revoked before their natural expiry
+	if TokenDenylist != nil {
+		revoked, err := TokenDenylist.IsRevoked(tokenString)
+		if err != nil {
+			utils.LogError("Rejected token of user %s: %v", claims.UserID, err)
+			RecordRevocationCheckFailure()
+			return nil, ErrRevocationUnavailable
+		}
+		if revoked {
+			RecordAuthFailure()
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	// Add user ID, role and token to context
+	ctx = context.WithValue(ctx, "userID", claims.UserID)
+	ctx = context.WithValue(ctx, "role", claims.Role)
+	ctx = context.WithValue(ctx, "tokenID", claims.TokenID)
+	ctx = context.WithValue(ctx, "token", tokenString)
+	ctx = context.WithValue(ctx, "tokenExpiresAt", claims.ExpiresAt)
+
+	// Attribute errors reported further up the chain to the user
+	utils.SetErrorScopeUser(ctx, claims.UserID)
+
+	return ctx, nil
+}
+
+// RecordAuthFailure counts a failed authentication
+func RecordAuthFailure() {
+	if monitoring.MetricsCollector != nil {
+		monitoring.MetricsCollector.IncrementAuthenticationErrors()
+	}
+}
+
+// RecordRevocationCheckFailure counts a token rejected because the denylist
+// could not be checked
+func RecordRevocationCheckFailure() {
+	if monitoring.MetricsCollector != nil {
+		monitoring.MetricsCollector.IncrementRevocationCheckFailures()
+	}
+}
+
 // LoggingMiddleware logs all requests
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Log request
-		utils.LogRequest(r)
+		utils.LogInfoContext(r.Context(), "%s %s %s", utils.ClientIP(r), r.Method, r.URL.Path)
 		
 		// Call the next handler
 		next.ServeHTTP(w, r)
 	})
 }
 
-// validateToken validates a JWT token and returns the user ID
-func validateToken(tokenString string) (string, error) {
-	// Load configuration
-	cfg, err := config.Load()
+// TokenClaims holds the validated claims of a token
+type TokenClaims struct {
+	UserID    string
+	Role      string
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+// validateToken validates a JWT token's signature and claims
+func validateToken(tokenString string) (*TokenClaims, error) {
+	claims, err := parseToken(tokenString)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Parse token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.NewValidationError("invalid signing method", jwt.ValidationErrorSignatureInvalid)
-		}
-		return []byte(cfg.JWT.Secret), nil
-	})
+	// Scoped tokens, such as step-up tokens, are not session tokens
+	if _, ok := claims["scope"]; ok {
+		return nil, jwt.NewValidationError("unexpected token scope", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	// Get user ID
+	userID, ok := claims["id"].(string)
+	if !ok || userID == "" {
+		return nil, jwt.NewValidationError("invalid user ID", jwt.ValidationErrorClaimsInvalid)
+	}
 
+	// Get role
+	role, ok := claims["role"].(string)
+	if !ok || role == "" {
+		return nil, jwt.NewValidationError("invalid role", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	// Get token ID
+	tokenID, ok := claims["jti"].(string)
+	if !ok || tokenID == "" {
+		return nil, jwt.NewValidationError("invalid token ID", jwt.ValidationErrorId)
+	}
+
+	// Get expiry
+	exp, _ := claims["exp"].(float64)
+
+	return &TokenClaims{
+		UserID:    userID,
+		Role:      role,
+		TokenID:   tokenID,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
+}
+
+// parseToken verifies a JWT token's signature, issuer, audience and time
+// based claims and returns its claims
+func parseToken(tokenString string) (jwt.MapClaims, error) {
+	// Issuer, audience and clock skew are those the signing keys were
+	// loaded with at startup
+	jwtConfig := SigningKeys.JWTConfig()
+
+	// Parse token, resolving the verification key from its kid header.
+	// Time based claims are checked below so clock skew can be tolerated.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(tokenString, SigningKeys.Keyfunc)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Validate token
 	if !token.Valid {
-		return "", jwt.NewValidationError("invalid token", jwt.ValidationErrorSignatureInvalid)
+		return nil, jwt.NewValidationError("invalid token", jwt.ValidationErrorSignatureInvalid)
 	}
 
 	// Get claims
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", jwt.NewValidationError("invalid claims", jwt.ValidationErrorClaimsInvalid)
+		return nil, jwt.NewValidationError("invalid claims", jwt.ValidationErrorClaimsInvalid)
 	}
 
-	// Get user ID
-	userID, ok := claims["id"].(string)
-	if !ok {
-		return "", jwt.NewValidationError("invalid user ID", jwt.ValidationErrorClaimsInvalid)
+	// Validate issuer and audience
+	if !claims.VerifyIssuer(jwtConfig.Issuer, true) {
+		return nil, jwt.NewValidationError("invalid issuer", jwt.ValidationErrorIssuer)
+	}
+	if !claims.VerifyAudience(jwtConfig.Audience, true) {
+		return nil, jwt.NewValidationError("invalid audience", jwt.ValidationErrorAudience)
+	}
+
+	// Validate time based claims, allowing for clock skew
+	skew := int64(jwtConfig.ClockSkew)
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now-skew, true) {
+		return nil, jwt.NewValidationError("token is expired", jwt.ValidationErrorExpired)
+	}
+	if !claims.VerifyIssuedAt(now+skew, true) {
+		return nil, jwt.NewValidationError("token used before issued", jwt.ValidationErrorIssuedAt)
+	}
+	if !claims.VerifyNotBefore(now+skew, false) {
+		return nil, jwt.NewValidationError("token is not valid yet", jwt.ValidationErrorNotValidYet)
 	}
 
-	return userID, nil
+	return claims, nil
 }
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Entitlements is the entitlement manager consulted by RequireFeature
+var Entitlements *core.EntitlementManager
+
+// RequireFeature only lets users whose plan includes a feature reach the
+// wrapped handler. It must run after authentication.
+func RequireFeature(feature string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "OPTIONS" || Entitlements == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, _ := r.Context().Value("userID").(string)
+			if err := Entitlements.CheckFeature(r.Context(), userID, feature); err != nil {
+				utils.RespondWithError(w, http.StatusForbidden, err.Error())
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"bufio"
+	"errors"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -34,7 +37,7 @@ func MetricsMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Log request
-		utils.LogInfo("API Request: %s %s %d %s", r.Method, r.URL.Path, rw.statusCode, duration)
+		utils.LogInfoContext(r.Context(), "API Request: %s %s %d %s", r.Method, r.URL.Path, rw.statusCode, duration)
 	})
 }
 
@@ -57,3 +60,19 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	}
 	return rw.ResponseWriter.Write(b)
 }
+
+// Unwrap gets the wrapped response writer, so http.ResponseController can
+// flush streamed responses
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack hands the connection over to WebSocket handlers
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	rw.statusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// NodeAuth authenticates node agents by the shared agent token. An empty
+// token rejects every request so heartbeats are never accepted unauthenticated.
+func NodeAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+				utils.LogWarning("Rejected node request from %s: %s %s", utils.ClientIP(r), r.Method, r.URL.Path)
+				utils.RespondWithError(w, http.StatusUnauthorized, "Invalid agent token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
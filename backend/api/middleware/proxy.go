@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Headers reverse proxies put the original client address in
+const (
+	ForwardedForHeader = "X-Forwarded-For"
+	RealIPHeader       = "X-Real-IP"
+)
+
+// TrustedProxies resolves the client address of requests relayed by the
+// given proxy networks from their X-Forwarded-For or X-Real-IP headers, for
+// rate limiting, allowlists and audit logs. Headers on requests from any
+// other address are ignored, so clients cannot spoof their address. An
+// empty list trusts no proxy except those connecting over the Unix socket.
+func TrustedProxies(entries []string) func(http.Handler) http.Handler {
+	resolve := ProxyResolver(entries)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peerIP := utils.ClientIP(r)
+			clientIP := resolve(peerIP, r.Header.Values(ForwardedForHeader), r.Header.Get(RealIPHeader))
+			if clientIP != peerIP {
+				r = r.WithContext(utils.WithClientIP(r.Context(), clientIP))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ProxyResolver creates a resolver of client addresses with the same rules
+// as TrustedProxies. Given the address of the connection's peer and the
+// forwarding headers, it returns the address of the client.
+func ProxyResolver(entries []string) func(peerIP string, forwardedFor []string, realIP string) string {
+	networks := parseNetworks(entries)
+
+	trusted := func(ip net.IP) bool {
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(peerIP string, forwardedFor []string, realIP string) string {
+		// Peers on the Unix socket have no address; only local proxies
+		// allowed by the socket's permissions can connect
+		unixPeer := peerIP == "" || peerIP == "@"
+		if ip := net.ParseIP(peerIP); !unixPeer && (ip == nil || !trusted(ip)) {
+			return peerIP
+		}
+
+		// Each proxy appends the address it received the request from, so
+		// the client is the rightmost address not belonging to a proxy
+		hops := make([]net.IP, 0)
+		for _, header := range forwardedFor {
+			for _, hop := range strings.Split(header, ",") {
+				if ip := net.ParseIP(strings.TrimSpace(hop)); ip != nil {
+					hops = append(hops, ip)
+				}
+			}
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			if !trusted(hops[i]) {
+				return hops[i].String()
+			}
+		}
+		if len(hops) > 0 {
+			// Every hop is a proxy; the first one is closest to the client
+			return hops[0].String()
+		}
+
+		if ip := net.ParseIP(strings.TrimSpace(realIP)); ip != nil {
+			return ip.String()
+		}
+
+		return peerIP
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// RateLimiter is the rate limiter consulted by RateLimit
+var RateLimiter *core.RateLimiter
+
+// RateLimit limits requests to a route group using the named rule from the
+// rate limit configuration. Rules keyed by user fall back to the client IP
+// for unauthenticated requests.
+func RateLimit(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Skip preflight requests and unconfigured limits
+			if r.Method == "OPTIONS" || RateLimiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rule, ok := RateLimiter.Rule(name)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Identify the client
+			client := "ip:" + utils.ClientIP(r)
+			if userID, ok := r.Context().Value("userID").(string); ok && rule.Key == "user" {
+				client = "user:" + userID
+			}
+
+			result, err := RateLimiter.Allow(r.Context(), name, client)
+			if err != nil {
+				utils.LogError("Rate limit check failed: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+				utils.RespondWithError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Recovery recovers from panics in later handlers, reporting them with the
+// stack trace, request and user, and responds with a 500 instead of dropping
+// the connection
+func Recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(utils.WithErrorScope(r.Context()))
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Let the server abort the response as intended
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			utils.ReportPanic(r, recovered, debug.Stack())
+			utils.RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// maxRequestIDLength limits the size of client supplied request IDs
+const maxRequestIDLength = 128
+
+// RequestID propagates the X-Request-ID of a request, generating one if the
+// client did not send a usable ID. The ID is echoed in the response, stored
+// in the request context for logging and included in error responses.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(utils.RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = utils.GenerateUUID()
+		}
+
+		w.Header().Set(utils.RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(utils.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// validRequestID checks that a request ID is short and printable so it can
+// safely be logged and echoed back
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// StepUpHeader carries the step-up token issued by POST /api/v1/auth/step-up
+const StepUpHeader = "X-Step-Up-Token"
+
+// StepUpScope is the scope claim of step-up tokens
+const StepUpScope = "step_up"
+
+// ErrStepUpRequired is returned when a request needs a fresh two-factor
+// verification
+var ErrStepUpRequired = errors.New("step-up verification required")
+
+// CheckStepUp checks that a request from a user whose plan requires step-up
+// authentication carries a valid step-up token issued to the same session.
+// Handlers that hand out new configs or keys call it after authentication.
+func CheckStepUp(r *http.Request) error {
+	return VerifyStepUp(r.Context(), r.Header.Get(StepUpHeader))
+}
+
+// VerifyStepUp checks a step-up token against the authenticated user and
+// session in ctx, if the user's plan requires step-up authentication
+func VerifyStepUp(ctx context.Context, tokenString string) error {
+	if Entitlements == nil {
+		return nil
+	}
+
+	userID, _ := ctx.Value("userID").(string)
+	if !Entitlements.Entitlements(ctx, userID).RequireStepUp {
+		return nil
+	}
+
+	if tokenString == "" {
+		return ErrStepUpRequired
+	}
+
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		utils.LogWarningContext(ctx, "Rejected step-up token: %v", err)
+		return ErrStepUpRequired
+	}
+
+	// The token must be a step-up token issued to this user and session
+	sessionID, _ := ctx.Value("tokenID").(string)
+	if claims["scope"] != StepUpScope || claims["id"] != userID || claims["sid"] != sessionID {
+		utils.LogWarningContext(ctx, "Rejected step-up token issued to another session")
+		return ErrStepUpRequired
+	}
+
+	return nil
+}
+
+// RespondStepUpRequired tells the client to verify a two-factor code at
+// the step-up endpoint and retry with the returned token (RFC 9470)
+func RespondStepUpRequired(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_user_authentication", error_description="A fresh two-factor verification is required"`)
+	utils.RespondWithError(w, http.StatusUnauthorized, "Step-up verification required")
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// SupportedAPIVersions lists the API versions routes are mounted under
+var SupportedAPIVersions = []string{"v1"}
+
+// APIVersionHeader names the request header used to pick a version for
+// unversioned paths and the response header reporting the version served
+const APIVersionHeader = "API-Version"
+
+// APIVersioning maps the unversioned /api/* aliases onto a versioned route
+// and marks deprecated versions. Clients of the aliases get the version
+// named in their API-Version header, or the default version, and are
+// pointed at the versioned path. It must wrap the router, as routes only
+// exist under /api/<version>.
+func APIVersioning(cfg config.APIConfig) func(http.Handler) http.Handler {
+	// Parse sunset dates
+	sunsets := make(map[string]time.Time, len(cfg.Sunsets))
+	for version, date := range cfg.Sunsets {
+		sunset, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			utils.LogWarning("Ignoring invalid sunset date %q for API %s", date, version)
+			continue
+		}
+		sunsets[version] = sunset
+	}
+
+	defaultVersion := cfg.DefaultVersion
+	if !supportedAPIVersion(defaultVersion) {
+		defaultVersion = SupportedAPIVersions[len(SupportedAPIVersions)-1]
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			version, rest := splitAPIVersion(r.URL.Path)
+			if version == "" {
+				// Negotiate version of unversioned alias
+				version = r.Header.Get(APIVersionHeader)
+				if version == "" {
+					version = defaultVersion
+				}
+				if !supportedAPIVersion(version) {
+					utils.RespondWithError(w, http.StatusNotAcceptable, "Unsupported API version "+version+", supported versions: "+strings.Join(SupportedAPIVersions, ", "))
+					return
+				}
+
+				// Point clients at the versioned path
+				versioned := "/api/" + version + rest
+				w.Header().Set("Deprecation", "true")
+				w.Header().Set("Link", "<"+versioned+">; rel=\"successor-version\"")
+
+				r = r.Clone(r.Context())
+				r.URL.Path = versioned
+				r.URL.RawPath = ""
+			}
+
+			if sunset, ok := sunsets[version]; ok {
+				w.Header().Set("Deprecation", "true")
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Set(APIVersionHeader, version)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// splitAPIVersion splits /api/<version>/<rest> into its version and rest.
+// The version is empty for unversioned paths.
+func splitAPIVersion(path string) (string, string) {
+	rest := strings.TrimPrefix(path, "/api")
+	segment := strings.SplitN(strings.TrimPrefix(rest, "/"), "/", 2)[0]
+	if len(segment) < 2 || segment[0] != 'v' || strings.Trim(segment[1:], "0123456789") != "" {
+		return "", rest
+	}
+	return segment, strings.TrimPrefix(rest, "/"+segment)
+}
+
+// supportedAPIVersion checks whether routes exist for a version
+func supportedAPIVersion(version string) bool {
+	for _, supported := range SupportedAPIVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
+}
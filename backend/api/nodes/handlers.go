@@ -0,0 +1,58 @@
+package nodes
+
+import (
+	"net/http"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ServerManager is the server manager instance
+var ServerManager *core.ServerManager
+
+// VPNManager is the VPN manager instance
+var VPNManager *core.VPNManager
+
+// HeartbeatHandler handles node agent heartbeats. The response carries the
+// agent version the node should upgrade to, if any.
+func HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req core.NodeHeartbeat
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Record heartbeat
+	response, err := ServerManager.Heartbeat(req)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Send the commands queued since the last heartbeat
+	if commands := ServerManager.TakeNodeCommands(req.ServerID); len(commands) > 0 {
+		response.Commands = commands
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
+
+// GetNodeStateHandler handles node agent requests for the peers and
+// policies to apply on their server
+func GetNodeStateHandler(w http.ResponseWriter, r *http.Request) {
+	serverID := r.URL.Query().Get("serverId")
+	if serverID == "" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "serverId is required")
+		return
+	}
+
+	// Get node state
+	state, err := VPNManager.NodeState(serverID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, state)
+}
@@ -0,0 +1,223 @@
+package openapi
+
+import (
+	"net/http"
+
+	"github.com/vpn-service/backend/api/admin"
+	"github.com/vpn-service/backend/api/auth"
+	"github.com/vpn-service/backend/api/servers"
+	"github.com/vpn-service/backend/api/user"
+	"github.com/vpn-service/backend/api/vpn"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/alerting"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/monitoring"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// operation describes the request and response bodies of a route. Routes
+// missing from the operations table are still documented, without bodies.
+type operation struct {
+	Summary  string
+	Request  interface{}
+	Response interface{}
+	Status   int  // success status code, 200 if unset
+	Public   bool // no bearer token required
+	CSV      bool // the request body may also be a text/csv file
+	Text     bool // the request body may also be a text/plain file
+
+	// Produces is the media type of a binary response body, such as a PDF
+	Produces string
+}
+
+// status is the response body of routes that only report success
+type status struct {
+	Status string `json:"status"`
+}
+
+// operations describes the API routes by "METHOD path"
+var operations = map[string]operation{
+	// Health
+	"GET /api/v1/health": {Summary: "Check service health", Public: true},
+	"GET /api/v1/status": {Summary: "Get region availability, uptime and recent incidents", Response: core.ServiceStatus{}, Public: true},
+
+	// Auth
+	"POST /api/v1/auth/register":      {Summary: "Register a new user", Request: auth.RegisterRequest{}, Response: auth.AuthResponse{}, Status: http.StatusCreated, Public: true},
+	"POST /api/v1/auth/login":         {Summary: "Log in and get a token", Request: auth.LoginRequest{}, Response: auth.AuthResponse{}, Public: true},
+	"POST /api/v1/auth/refresh":       {Summary: "Refresh a token", Response: auth.AuthResponse{}},
+	"POST /api/v1/auth/logout":        {Summary: "Revoke the current token", Response: status{}},
+	"POST /api/v1/auth/mfa/enroll":    {Summary: "Start two-factor enrollment", Response: core.MFAEnrollment{}},
+	"POST /api/v1/auth/mfa/confirm":   {Summary: "Enable two-factor authentication", Request: auth.MFACodeRequest{}, Response: status{}},
+	"POST /api/v1/auth/step-up":       {Summary: "Verify a two-factor code for a step-up token", Request: auth.MFACodeRequest{}, Response: auth.StepUpResponse{}},
+	"POST /api/v1/auth/invite/accept": {Summary: "Set the password of an imported account", Request: auth.AcceptInviteRequest{}, Response: status{}, Public: true},
+
+	// Nodes
+	"POST /api/v1/nodes/heartbeat": {Summary: "Report node agent versions", Request: core.NodeHeartbeat{}, Response: core.HeartbeatResponse{}},
+	"GET /api/v1/nodes/state":      {Summary: "Get the peers and policies a node agent applies", Response: wireguard.NodeState{}},
+
+	// Server registration
+	"POST /api/v1/admin/servers/register": {Summary: "Register a newly provisioned server", Request: servers.RegisterServerRequest{}, Response: core.Server{}, Status: http.StatusCreated},
+
+	// User
+	"GET /api/v1/user/defaults":             {Summary: "Get account defaults for new devices", Response: models.DeviceDefaults{}},
+	"PUT /api/v1/user/defaults":             {Summary: "Set account defaults for new devices", Request: models.DeviceDefaults{}, Response: models.DeviceDefaults{}},
+	"GET /api/v1/user/privacy":              {Summary: "Get the telemetry preference", Response: core.PrivacySettings{}},
+	"PATCH /api/v1/user/privacy":            {Summary: "Opt in or out of identifiable telemetry", Request: user.PrivacyRequest{}, Response: core.PrivacySettings{}},
+	"GET /api/v1/user/plan":                 {Summary: "Get the current plan and its entitlements", Response: user.PlanResponse{}},
+	"GET /api/v1/user/push-devices":         {Summary: "List devices registered for push notifications", Response: []models.PushDevice{}},
+	"POST /api/v1/user/push-devices":        {Summary: "Register an FCM or APNs device token", Request: user.PushDeviceRequest{}, Response: models.PushDevice{}, Status: http.StatusCreated},
+	"DELETE /api/v1/user/push-devices/{id}": {Summary: "Unregister a push device", Response: status{}},
+
+	// VPN
+	"GET /api/v1/vpn/servers":                 {Summary: "List servers, filtered by country, region, status and features and sorted by name, load or latency", Response: []vpn.Server{}},
+	"GET /api/v1/vpn/routing-presets":         {Summary: "List routing presets to pick at connect time", Response: []models.RoutingPreset{}},
+	"GET /api/v1/vpn/dns-profiles":            {Summary: "List DNS profiles to pick at connect time", Response: []core.DNSProfile{}},
+	"GET /api/v1/vpn/latency-matrix":          {Summary: "Get the latencies measured from servers to reference probes", Response: core.LatencyMatrixView{}},
+	"POST /api/v1/vpn/latency":                {Summary: "Report pings to servers", Request: vpn.LatencyReportRequest{}, Response: vpn.LatencyReportResponse{}},
+	"GET /api/v1/vpn/servers/recommended":     {Summary: "Get servers ranked by latency from the client's region and load", Response: core.ServerRecommendations{}},
+	"POST /api/v1/vpn/connect":                {Summary: "Connect a device", Request: vpn.ConnectRequest{}, Response: vpn.ConnectResponse{}},
+	"POST /api/v1/vpn/disconnect":             {Summary: "Disconnect a device", Request: vpn.DisconnectRequest{}, Response: status{}},
+	"POST /api/v1/vpn/reactivate":             {Summary: "Reactivate a device archived for inactivity", Request: vpn.ReactivateRequest{}, Response: vpn.ConnectResponse{}},
+	"GET /api/v1/vpn/status":                  {Summary: "Get the connection status", Response: vpn.StatusResponse{}},
+	"POST /api/v1/vpn/config/email":           {Summary: "Email a device's config and QR code to the user", Request: vpn.EmailConfigRequest{}, Response: status{}},
+	"GET /api/v1/vpn/config/shares":           {Summary: "List unused config share links", Response: []core.ConfigShare{}},
+	"POST /api/v1/vpn/config/shares":          {Summary: "Create a one-time config share link", Request: vpn.ShareConfigRequest{}, Response: core.ConfigShare{}, Status: http.StatusCreated},
+	"DELETE /api/v1/vpn/config/shares/{id}":   {Summary: "Revoke a config share link", Response: status{}},
+	"GET /api/v1/vpn/peers/{id}/setup.pdf":    {Summary: "Get a printable setup sheet for a device", Produces: "application/pdf"},
+	"GET /api/v1/vpn/peers/{id}/gateway":      {Summary: "Get the router config and server side setup of a gateway device", Response: wireguard.GatewaySetup{}},
+	"PUT /api/v1/vpn/peers/{id}/routing":      {Summary: "Set the networks a device routes through the tunnel", Request: vpn.RoutingRequest{}, Response: vpn.RoutingResponse{}},
+	"GET /api/v1/vpn/peers/{id}/exit":         {Summary: "List the exits and internal networks a device can pick", Response: core.ExitChoices{}},
+	"PUT /api/v1/vpn/peers/{id}/exit":         {Summary: "Pick the exit server and internal networks of a device", Request: vpn.ExitRequest{}, Response: vpn.ExitResponse{}},
+	"PUT /api/v1/vpn/peers/{id}/mtu":          {Summary: "Set the MTU of a device", Request: vpn.MTURequest{}, Response: vpn.MTUResponse{}},
+	"POST /api/v1/vpn/mtu/suggest":            {Summary: "Suggest a device MTU from a path MTU probe", Request: vpn.MTUSuggestRequest{}, Response: core.MTUSuggestion{}},
+	"PUT /api/v1/vpn/peers/{id}/keepalive":    {Summary: "Set the persistent keepalive of a device", Request: vpn.KeepaliveRequest{}, Response: vpn.KeepaliveResponse{}},
+	"PUT /api/v1/vpn/peers/{id}/port-forward": {Summary: "Forward a port of a device's server to the device", Request: vpn.PortForwardRequest{}, Response: vpn.PortForwardResponse{}},
+	"GET /api/v1/vpn/dedicated-ips":           {Summary: "List the dedicated IPs reserved to the user", Response: []vpn.DedicatedIP{}},
+	"POST /api/v1/vpn/backup":                 {Summary: "Export the user's devices as an encrypted backup", Request: vpn.BackupRequest{}, Response: core.DeviceBackup{}},
+	"POST /api/v1/vpn/restore":                {Summary: "Restore the devices of an encrypted backup", Request: vpn.RestoreRequest{}, Response: core.DeviceRestoreSummary{}},
+	"POST /api/v1/vpn/peers/{id}/artifacts":   {Summary: "Store the config files of a device and get download links", Response: core.StoredArtifacts{}, Status: http.StatusCreated},
+	"POST /api/v1/vpn/archive":                {Summary: "Store an archive of the configs of all devices and get a download link", Response: core.StoredArchive{}, Status: http.StatusCreated},
+	"GET /api/v1/artifacts/{key}":             {Summary: "Download a stored artifact from a signed link", Public: true, Produces: "application/octet-stream"},
+	"GET /api/v1/vpn/ws":                      {Summary: "Stream connection status changes over a WebSocket", Response: vpn.StatusEvent{}, Status: http.StatusSwitchingProtocols},
+
+	// Admin users
+	"GET /api/v1/admin/users":           {Summary: "List users", Response: []admin.UserResponse{}},
+	"POST /api/v1/admin/users/import":   {Summary: "Start a bulk user import job", Request: admin.ImportUsersRequest{}, Response: core.Job{}, Status: http.StatusAccepted, CSV: true},
+	"GET /api/v1/admin/users/{id}":      {Summary: "Get a user", Response: admin.UserResponse{}},
+	"PUT /api/v1/admin/users/{id}":      {Summary: "Update a user", Request: admin.UserUpdateRequest{}, Response: admin.UserResponse{}},
+	"DELETE /api/v1/admin/users/{id}":   {Summary: "Delete a user", Response: status{}},
+	"PUT /api/v1/admin/users/{id}/plan": {Summary: "Assign a plan to a user", Request: admin.UserPlanRequest{}, Response: status{}},
+
+	// Admin device enrollment
+	"GET /api/v1/admin/device-keys":                        {Summary: "List pre-authorized device public keys", Response: []core.DeviceKey{}},
+	"POST /api/v1/admin/device-keys/import":                {Summary: "Add device public keys to the allow-list", Request: admin.ImportDeviceKeysRequest{}, Response: core.DeviceKeyImportSummary{}, CSV: true},
+	"DELETE /api/v1/admin/device-keys/{id}":                {Summary: "Remove a device public key from the allow-list", Response: status{}},
+	"GET /api/v1/admin/peers/pending":                      {Summary: "List devices waiting for approval", Response: []wireguard.PeerConfig{}},
+	"GET /api/v1/admin/ip-reservations":                    {Summary: "List dedicated IP reservations", Response: []core.IPReservation{}},
+	"POST /api/v1/admin/ip-reservations":                   {Summary: "Reserve a dedicated IP to a user", Request: admin.ReserveIPRequest{}, Response: core.IPReservation{}, Status: http.StatusCreated},
+	"DELETE /api/v1/admin/ip-reservations/{id}":            {Summary: "Release a dedicated IP reservation", Response: status{}},
+	"GET /api/v1/admin/acl-rules":                          {Summary: "List the ACL rules between peers in the order they are checked", Response: []core.ACLRule{}},
+	"POST /api/v1/admin/acl-rules":                         {Summary: "Add an ACL rule between peers", Request: admin.ACLRuleRequest{}, Response: core.ACLRule{}, Status: http.StatusCreated},
+	"PUT /api/v1/admin/acl-rules/{id}":                     {Summary: "Update an ACL rule", Request: admin.ACLRuleRequest{}, Response: core.ACLRule{}},
+	"DELETE /api/v1/admin/acl-rules/{id}":                  {Summary: "Delete an ACL rule", Response: status{}},
+	"GET /api/v1/admin/meshes":                             {Summary: "List the meshes of users' devices", Response: []core.Mesh{}},
+	"POST /api/v1/admin/meshes":                            {Summary: "Put the devices of a group of users in a mesh", Request: admin.MeshRequest{}, Response: core.Mesh{}, Status: http.StatusCreated},
+	"PUT /api/v1/admin/meshes/{id}":                        {Summary: "Update the topology and members of a mesh", Request: admin.MeshRequest{}, Response: core.Mesh{}},
+	"DELETE /api/v1/admin/meshes/{id}":                     {Summary: "Delete a mesh", Response: status{}},
+	"POST /api/v1/admin/peers/import":                      {Summary: "Import the peers of an existing WireGuard server config", Request: admin.ImportPeersRequest{}, Response: core.PeerImportSummary{}, Text: true},
+	"POST /api/v1/admin/users/{id}/peers/{peerID}/approve": {Summary: "Approve a device whose key is not on the allow-list", Request: admin.ApprovePeerRequest{}, Response: wireguard.PeerConfig{}},
+	"POST /api/v1/admin/users/{id}/peers/{peerID}/reject":  {Summary: "Reject a device waiting for approval", Response: status{}},
+
+	// Admin plans
+	"GET /api/v1/admin/plans":         {Summary: "List plans", Response: []models.Plan{}},
+	"POST /api/v1/admin/plans":        {Summary: "Create a plan", Request: admin.PlanRequest{}, Response: models.Plan{}, Status: http.StatusCreated},
+	"GET /api/v1/admin/plans/{id}":    {Summary: "Get a plan", Response: models.Plan{}},
+	"PUT /api/v1/admin/plans/{id}":    {Summary: "Update a plan", Request: admin.PlanRequest{}, Response: models.Plan{}},
+	"DELETE /api/v1/admin/plans/{id}": {Summary: "Delete a plan", Response: status{}},
+
+	// Admin routing presets
+	"GET /api/v1/admin/routing-presets":         {Summary: "List routing presets", Response: []models.RoutingPreset{}},
+	"POST /api/v1/admin/routing-presets":        {Summary: "Create a routing preset", Request: admin.RoutingPresetRequest{}, Response: models.RoutingPreset{}, Status: http.StatusCreated},
+	"GET /api/v1/admin/routing-presets/{id}":    {Summary: "Get a routing preset", Response: models.RoutingPreset{}},
+	"PUT /api/v1/admin/routing-presets/{id}":    {Summary: "Update a routing preset and the peers using it", Request: admin.RoutingPresetRequest{}, Response: models.RoutingPreset{}},
+	"DELETE /api/v1/admin/routing-presets/{id}": {Summary: "Delete a routing preset", Response: status{}},
+
+	// Admin config templates
+	"GET /api/v1/admin/config-templates":           {Summary: "List the client config template of every device type", Response: []core.ConfigTemplate{}},
+	"POST /api/v1/admin/config-templates/validate": {Summary: "Render a candidate config template with sample values and check it", Request: admin.ConfigTemplateRequest{}, Response: wireguard.TemplatePreview{}},
+	"GET /api/v1/admin/config-templates/{name}":    {Summary: "Get a client config template", Response: core.ConfigTemplate{}},
+	"PUT /api/v1/admin/config-templates/{name}":    {Summary: "Override the built-in client config template of a device type", Request: admin.ConfigTemplateRequest{}, Response: core.ConfigTemplate{}},
+	"DELETE /api/v1/admin/config-templates/{name}": {Summary: "Reset a client config template to the built-in template", Response: status{}},
+
+	// Admin tokens and keys
+	"POST /api/v1/admin/tokens/revoke": {Summary: "Revoke a token", Request: admin.RevokeTokenRequest{}, Response: status{}},
+	"GET /api/v1/admin/keys":           {Summary: "List token signing keys", Response: []core.SigningKey{}},
+	"POST /api/v1/admin/keys/rotate":   {Summary: "Rotate the token signing key", Response: core.SigningKey{}, Status: http.StatusCreated},
+
+	// Admin audit and reports
+	"GET /api/v1/admin/audit":                    {Summary: "List audit log entries", Response: []core.AuditEntry{}},
+	"GET /api/v1/admin/audit/verify":             {Summary: "Verify the audit log hash chain", Response: core.AuditVerification{}},
+	"GET /api/v1/admin/reports/funnel":           {Summary: "Get the trial-to-paid funnel", Response: core.FunnelReport{}},
+	"GET /api/v1/admin/reports/anomalies":        {Summary: "List anomalies in connect errors, apply latency and auth failures", Response: []monitoring.Anomaly{}},
+	"GET /api/v1/admin/reports/alerts":           {Summary: "List capacity alerts for region load and offline servers", Response: []core.CapacityAlert{}},
+	"GET /api/v1/admin/alerts/silences":          {Summary: "List alert silences that have not ended", Response: []alerting.Silence{}},
+	"POST /api/v1/admin/alerts/silences":         {Summary: "Silence the alerts of a rule, subject or both", Request: admin.SilenceRequest{}, Response: alerting.Silence{}, Status: http.StatusCreated},
+	"DELETE /api/v1/admin/alerts/silences/{id}":  {Summary: "End an alert silence early", Response: status{}},
+	"GET /api/v1/admin/reports/shadow-selection": {Summary: "Compare shadow server selection algorithms with the servers actually used", Response: core.ShadowReport{}},
+	"GET /api/v1/admin/reports/analytics":        {Summary: "Count usage analytics events by type and day", Response: core.AnalyticsReport{}},
+	"GET /api/v1/admin/events":                   {Summary: "Stream system events as Server-Sent Events", Response: core.Event{}},
+	"GET /api/v1/admin/jobs":                     {Summary: "List background jobs", Response: []core.Job{}},
+	"GET /api/v1/admin/jobs/{id}":                {Summary: "Get the progress, errors and result of a job", Response: core.Job{}},
+	"POST /api/v1/admin/jobs/{id}/cancel":        {Summary: "Cancel a running job", Response: status{}, Status: http.StatusAccepted},
+
+	// Admin servers
+	"GET /api/v1/admin/servers":         {Summary: "List servers", Response: []core.Server{}},
+	"POST /api/v1/admin/servers":        {Summary: "Add a server", Request: servers.ServerRequest{}, Response: core.Server{}, Status: http.StatusCreated},
+	"GET /api/v1/admin/servers/{id}":    {Summary: "Get a server", Response: core.Server{}},
+	"PUT /api/v1/admin/servers/{id}":    {Summary: "Update a server", Request: servers.ServerRequest{}, Response: core.Server{}},
+	"DELETE /api/v1/admin/servers/{id}": {Summary: "Remove a server", Response: status{}},
+
+	// Admin server drains
+	"POST /api/v1/admin/servers/{id}/drain":   {Summary: "Drain a server ahead of maintenance", Request: admin.DrainRequest{}, Response: core.ServerDrain{}, Status: http.StatusAccepted},
+	"GET /api/v1/admin/servers/{id}/drain":    {Summary: "Get the progress of a server drain", Response: core.ServerDrain{}},
+	"DELETE /api/v1/admin/servers/{id}/drain": {Summary: "Cancel a server drain", Response: status{}},
+
+	// Admin server uptime
+	"GET /api/v1/admin/servers/uptime":      {Summary: "Get the uptime of every server over 24 hours, 7 and 30 days", Response: []core.ServerUptime{}},
+	"GET /api/v1/admin/servers/{id}/uptime": {Summary: "Get the uptime and status transitions of a server", Response: core.ServerUptime{}},
+
+	// Admin nodes
+	"GET /api/v1/admin/nodes":                    {Summary: "Get node agent and WireGuard versions", Response: core.NodeInventory{}},
+	"GET /api/v1/admin/nodes/{id}/commands":      {Summary: "List the recent commands of a node", Response: []core.NodeCommand{}},
+	"POST /api/v1/admin/nodes/{id}/commands":     {Summary: "Queue a command for a node agent", Request: admin.NodeCommandRequest{}, Response: core.NodeCommand{}, Status: http.StatusAccepted},
+	"GET /api/v1/admin/nodes/{id}/enrollment":    {Summary: "Get the enrollment of a node agent", Response: core.NodeEnrollment{}},
+	"POST /api/v1/admin/nodes/{id}/enrollment":   {Summary: "Create a one-time enrollment token for a node agent", Response: admin.NodeEnrollmentResponse{}, Status: http.StatusCreated},
+	"DELETE /api/v1/admin/nodes/{id}/enrollment": {Summary: "Revoke the enrollment of a node agent", Response: status{}},
+	"GET /api/v1/admin/interfaces":               {Summary: "List the WireGuard interfaces of the server with their state", Response: []wireguard.InterfaceStatus{}},
+	"POST /api/v1/admin/interfaces/{name}/apply": {Summary: "Apply the peers of a WireGuard interface again", Response: status{}},
+	"POST /api/v1/admin/graphql":                 {Summary: "Query users, peers, servers and usage with GraphQL", Request: admin.GraphQLRequest{}, Response: admin.GraphQLResponse{}},
+	"GET /api/v1/admin/merges":                   {Summary: "List account merges", Response: []core.AccountMerge{}},
+	"POST /api/v1/admin/merges":                  {Summary: "Stage an account merge", Request: admin.MergeRequest{}, Response: core.AccountMerge{}, Status: http.StatusCreated},
+	"GET /api/v1/admin/merges/{id}":              {Summary: "Get an account merge", Response: core.AccountMerge{}},
+	"POST /api/v1/admin/merges/{id}/commit":      {Summary: "Commit a staged account merge", Response: core.AccountMerge{}},
+	"POST /api/v1/admin/merges/{id}/revert":      {Summary: "Revert a committed account merge", Response: core.AccountMerge{}},
+	"POST /api/v1/admin/merges/{id}/cancel":      {Summary: "Cancel a staged account merge", Response: core.AccountMerge{}},
+	"GET /api/v1/admin/rollouts":                 {Summary: "List agent rollouts", Response: []core.AgentRollout{}},
+	"POST /api/v1/admin/rollouts":                {Summary: "Start an agent rollout", Request: admin.RolloutRequest{}, Response: core.AgentRollout{}, Status: http.StatusCreated},
+	"GET /api/v1/admin/rollouts/{id}":            {Summary: "Get an agent rollout", Response: core.AgentRollout{}},
+	"POST /api/v1/admin/rollouts/{id}/abort":     {Summary: "Abort an agent rollout", Response: core.AgentRollout{}},
+	"GET /api/v1/admin/upgrades":                 {Summary: "List rolling node upgrades", Response: []core.NodeUpgrade{}},
+	"POST /api/v1/admin/upgrades":                {Summary: "Start a rolling node upgrade", Request: admin.UpgradeRequest{}, Response: core.NodeUpgrade{}, Status: http.StatusCreated},
+	"GET /api/v1/admin/upgrades/{id}":            {Summary: "Get a rolling node upgrade", Response: core.NodeUpgrade{}},
+	"POST /api/v1/admin/upgrades/{id}/abort":     {Summary: "Abort a rolling node upgrade", Response: core.NodeUpgrade{}},
+	"GET /api/v1/admin/incidents":                {Summary: "List open and recent incidents", Response: []core.Incident{}},
+	"POST /api/v1/admin/incidents":               {Summary: "Post an incident to the status page", Request: admin.IncidentRequest{}, Response: core.Incident{}, Status: http.StatusCreated},
+	"PUT /api/v1/admin/incidents/{id}":           {Summary: "Update or resolve an incident", Request: admin.IncidentRequest{}, Response: core.Incident{}},
+	"GET /api/v1/admin/provisions":               {Summary: "List servers provisioned on cloud providers", Response: []core.Provision{}},
+	"POST /api/v1/admin/provisions":              {Summary: "Provision a server from a template", Request: admin.ProvisionRequest{}, Response: core.Provision{}, Status: http.StatusAccepted},
+	"GET /api/v1/admin/provisions/{id}":          {Summary: "Get the progress of a provision", Response: core.Provision{}},
+	"DELETE /api/v1/admin/provisions/{id}":       {Summary: "Delete a provisioned server and its machine", Response: core.Provision{}, Status: http.StatusAccepted},
+	"GET /api/v1/admin/autoscaling":              {Summary: "Get the load and scaling state of autoscaled regions", Response: []core.AutoscaleRegion{}},
+	"GET /api/v1/admin/certificates/node":        {Summary: "Get the node certificate", Response: core.NodeCertificate{}},
+	"POST /api/v1/admin/certificates/node/renew": {Summary: "Start a node certificate renewal job", Response: core.Job{}, Status: http.StatusAccepted},
+}
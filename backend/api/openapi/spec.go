@@ -0,0 +1,167 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3gen"
+	"github.com/gorilla/mux"
+)
+
+// pathParamPattern matches {name} and {name:pattern} path variables
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// Build generates the OpenAPI document for every API route registered on
+// the router, taking request and response schemas from the operations
+// table. Call it after all routes are registered.
+func Build(router *mux.Router) (*openapi3.T, error) {
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:   "VPN Service API",
+			Version: "v1",
+		},
+		Paths: openapi3.Paths{},
+		Components: &openapi3.Components{
+			Schemas: openapi3.Schemas{},
+			SecuritySchemes: openapi3.SecuritySchemes{
+				"bearerAuth": &openapi3.SecuritySchemeRef{
+					Value: openapi3.NewJWTSecurityScheme(),
+				},
+			},
+		},
+		Security: openapi3.SecurityRequirements{{"bearerAuth": []string{}}},
+	}
+
+	generator := openapi3gen.NewGenerator(openapi3gen.SchemaCustomizer(strictObjects))
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Subrouter prefixes have no methods
+			return nil
+		}
+
+		path := pathParamPattern.ReplaceAllString(template, "{$1}")
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+
+			op, err := newOperation(generator, doc.Components.Schemas, method, path)
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", method, path, err)
+			}
+			doc.AddOperation(path, method, op)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OpenAPI document: %v", err)
+	}
+
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %v", err)
+	}
+
+	return doc, nil
+}
+
+// newOperation describes a single route
+func newOperation(generator *openapi3gen.Generator, schemas openapi3.Schemas, method, path string) (*openapi3.Operation, error) {
+	described := operations[method+" "+path]
+
+	op := openapi3.NewOperation()
+	op.Summary = described.Summary
+	if described.Public {
+		op.Security = &openapi3.SecurityRequirements{}
+	}
+
+	// Add path parameters
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		op.AddParameter(openapi3.NewPathParameter(match[1]).WithSchema(openapi3.NewStringSchema()))
+	}
+
+	// Add request body
+	if described.Request != nil {
+		ref, err := generator.NewSchemaRefForValue(described.Request, schemas)
+		if err != nil {
+			return nil, err
+		}
+		body := openapi3.NewRequestBody().WithRequired(true).WithJSONSchemaRef(ref)
+		if described.CSV {
+			body.Content["text/csv"] = openapi3.NewMediaType().WithSchema(openapi3.NewStringSchema())
+		}
+		if described.Text {
+			body.Content["text/plain"] = openapi3.NewMediaType().WithSchema(openapi3.NewStringSchema())
+		}
+		op.RequestBody = &openapi3.RequestBodyRef{Value: body}
+	}
+
+	// Add responses
+	status := described.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := openapi3.NewResponse().WithDescription(http.StatusText(status))
+	if described.Response != nil {
+		ref, err := generator.NewSchemaRefForValue(described.Response, schemas)
+		if err != nil {
+			return nil, err
+		}
+		response.WithJSONSchemaRef(ref)
+	}
+	if described.Produces != "" {
+		schema := openapi3.NewStringSchema().WithFormat("binary")
+		response.WithContent(openapi3.NewContentWithSchema(schema, []string{described.Produces}))
+	}
+	op.AddResponse(status, response)
+	op.AddResponse(0, openapi3.NewResponse().WithDescription("Error").WithJSONSchema(errorSchema))
+
+	return op, nil
+}
+
+// errorSchema describes the error responses written by utils.RespondWithError
+// and utils.RespondWithValidationError
+var errorSchema = openapi3.NewObjectSchema().
+	WithProperty("error", openapi3.NewStringSchema()).
+	WithProperty("requestId", openapi3.NewStringSchema()).
+	WithProperty("fields", openapi3.NewArraySchema().WithItems(
+		openapi3.NewObjectSchema().
+			WithProperty("field", openapi3.NewStringSchema()).
+			WithProperty("message", openapi3.NewStringSchema()),
+	))
+
+// strictObjects rejects unknown properties on request and response objects,
+// so typos in field names fail validation instead of being ignored
+func strictObjects(_ string, t reflect.Type, _ reflect.StructTag, schema *openapi3.Schema) error {
+	if t.Kind() == reflect.Struct && schema.Type == openapi3.TypeObject && len(schema.Properties) > 0 {
+		schema.WithoutAdditionalProperties()
+	}
+	return nil
+}
+
+// Handler serves the OpenAPI document as JSON
+func Handler(doc *openapi3.T) http.HandlerFunc {
+	data, err := json.Marshal(doc)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			http.Error(w, "Failed to encode OpenAPI document", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(data)
+	}
+}
@@ -0,0 +1,97 @@
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ValidateRequests creates middleware that rejects requests whose path
+// parameters, query or body do not match the OpenAPI document. Requests to
+// routes missing from the document are passed through unchecked. It is meant
+// for development, to catch handlers and clients drifting from the spec.
+func ValidateRequests(doc *openapi3.T) (func(http.Handler) http.Handler, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OpenAPI router: %v", err)
+	}
+
+	options := &openapi3filter.Options{
+		MultiError:         true,
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, pathParams, err := router.FindRoute(r)
+			if err != nil {
+				// Unknown routes and methods are answered by the API router
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+			if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+				utils.LogWarning("Request %s %s does not match the OpenAPI spec: %v", r.Method, r.URL.Path, err)
+				utils.RespondWithValidationError(w, specValidationError(err))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// specValidationError converts OpenAPI validation errors to field errors
+func specValidationError(err error) error {
+	var v utils.Validator
+	addSpecErrors(&v, "body", err)
+	return v.Err()
+}
+
+// addSpecErrors adds a field error for each schema violation in err.
+// Violations without a more specific location are reported on field.
+func addSpecErrors(v *utils.Validator, field string, err error) {
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		for _, e := range multi {
+			addSpecErrors(v, field, e)
+		}
+		return
+	}
+
+	var requestErr *openapi3filter.RequestError
+	if errors.As(err, &requestErr) {
+		if requestErr.Parameter != nil {
+			field = requestErr.Parameter.Name
+		}
+		if requestErr.Err != nil {
+			addSpecErrors(v, field, requestErr.Err)
+		} else {
+			v.Check(false, field, requestErr.Reason)
+		}
+		return
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+			field = strings.Join(pointer, ".")
+		}
+		v.Check(false, field, schemaErr.Reason)
+		return
+	}
+
+	v.Check(false, field, err.Error())
+}
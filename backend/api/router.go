@@ -1,6 +1,7 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -8,109 +9,103 @@ import (
 	"github.com/vpn-service/backend/api/auth"
 	"github.com/vpn-service/backend/api/health"
 	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/api/nodes"
+	"github.com/vpn-service/backend/api/openapi"
 	"github.com/vpn-service/backend/api/servers"
+	"github.com/vpn-service/backend/api/status"
+	"github.com/vpn-service/backend/api/user"
 	"github.com/vpn-service/backend/api/vpn"
-	"github.com/vpn-service/backend/monitoring"
 	"github.com/vpn-service/backend/src/config"
-	"github.com/vpn-service/backend/src/core"
 	"github.com/vpn-service/backend/src/utils"
 )
 
-// Router is the API router
-type Router struct {
-	config          *config.Config
-	router          *mux.Router
-	userManager     *core.UserManager
-	serverManager   *core.ServerManager
-	vpnManager      *core.VPNManager
-	metricsCollector *monitoring.MetricsCollector
-}
-
-// NewRouter creates a new API router
-func NewRouter(cfg *config.Config, userManager *core.UserManager, serverManager *core.ServerManager, vpnManager *core.VPNManager, metricsCollector *monitoring.MetricsCollector) *Router {
-	return &Router{
-		config:          cfg,
-		router:          mux.NewRouter(),
-		userManager:     userManager,
-		serverManager:   serverManager,
-		vpnManager:      vpnManager,
-		metricsCollector: metricsCollector,
-	}
-}
+// NewRouter creates the API routes and the handler serving them with
+// CORS, API versioning and, if enabled, request validation. Handlers use
+// the managers set on their packages, so routes can be built without a
+// database.
+func NewRouter(cfg *config.Config) (*mux.Router, http.Handler, error) {
+	router := mux.NewRouter()
 
-// Setup sets up the API router
-func (r *Router) Setup() {
 	// Set up middleware
-	authMiddleware := middleware.NewAuthMiddleware(r.config)
-	metricsMiddleware := middleware.NewMetricsMiddleware(r.metricsCollector)
-
-	// Set up global middleware
-	r.router.Use(metricsMiddleware.Middleware)
-
-	// Set up managers
-	auth.UserManager = r.userManager
-	servers.ServerManager = r.serverManager
-	admin.UserManager = r.userManager
-	vpn.VPNManager = r.vpnManager
-
-	// Health routes
-	r.router.HandleFunc("/health", health.HealthHandler).Methods(http.MethodGet)
-	r.router.HandleFunc("/readiness", health.ReadinessHandler).Methods(http.MethodGet)
-	r.router.HandleFunc("/liveness", health.LivenessHandler).Methods(http.MethodGet)
+	router.Use(middleware.RequestID)
+	router.Use(middleware.TrustedProxies(cfg.Server.TrustedProxies))
+	router.Use(middleware.Recovery)
+	router.Use(middleware.Tracing)
+	router.Use(middleware.LoggingMiddleware)
+	router.Use(middleware.MetricsMiddleware)
+
+	// Health check routes
+	router.HandleFunc("/health", health.HealthHandler).Methods("GET")
+	router.HandleFunc("/readiness", health.ReadinessHandler).Methods("GET")
+	router.HandleFunc("/liveness", health.LivenessHandler).Methods("GET")
+
+	// Public routes
+	router.HandleFunc("/.well-known/jwks.json", auth.JWKSHandler).Methods("GET")
+	router.HandleFunc("/status", status.PageHandler).Methods("GET")
+
+	// Versioned API routes; unversioned /api/* paths are aliases
+	v1Router := router.PathPrefix("/api/v1").Subrouter()
+	v1Router.HandleFunc("/health", healthCheckHandler).Methods("GET")
+	v1Router.HandleFunc("/status", status.GetStatusHandler).Methods("GET")
 
 	// Auth routes
-	r.router.HandleFunc("/api/auth/register", auth.RegisterHandler).Methods(http.MethodPost)
-	r.router.HandleFunc("/api/auth/login", auth.LoginHandler).Methods(http.MethodPost)
-	r.router.HandleFunc("/api/auth/refresh", auth.RefreshHandler).Methods(http.MethodPost)
-
-	// User routes (authenticated)
-	userRouter := r.router.PathPrefix("/api/user").Subrouter()
-	userRouter.Use(authMiddleware.Middleware)
-	userRouter.HandleFunc("", auth.GetUserHandler).Methods(http.MethodGet)
-	userRouter.HandleFunc("/password", auth.ChangePasswordHandler).Methods(http.MethodPost)
-
-	// VPN routes (authenticated)
-	vpnRouter := r.router.PathPrefix("/api/vpn").Subrouter()
-	vpnRouter.Use(authMiddleware.Middleware)
-	vpnRouter.HandleFunc("/connect", vpn.ConnectHandler).Methods(http.MethodPost)
-	vpnRouter.HandleFunc("/disconnect", vpn.DisconnectHandler).Methods(http.MethodPost)
-	vpnRouter.HandleFunc("/status", vpn.StatusHandler).Methods(http.MethodGet)
-	vpnRouter.HandleFunc("/config", vpn.GetConfigHandler).Methods(http.MethodGet)
-	vpnRouter.HandleFunc("/config/qrcode", vpn.GetQRCodeHandler).Methods(http.MethodGet)
-	vpnRouter.HandleFunc("/servers", vpn.GetServersHandler).Methods(http.MethodGet)
-
-	// Admin routes (authenticated + admin)
-	adminRouter := r.router.PathPrefix("/api/admin").Subrouter()
-	adminRouter.Use(authMiddleware.AdminMiddleware)
-
-	// Admin user routes
-	adminRouter.HandleFunc("/users", admin.ListUsersHandler).Methods(http.MethodGet)
-	adminRouter.HandleFunc("/users/{id}", admin.GetUserHandler).Methods(http.MethodGet)
-	adminRouter.HandleFunc("/users/{id}", admin.UpdateUserHandler).Methods(http.MethodPut)
-	adminRouter.HandleFunc("/users/{id}", admin.DeleteUserHandler).Methods(http.MethodDelete)
-	adminRouter.HandleFunc("/users/{id}/peers", admin.GetUserPeersHandler).Methods(http.MethodGet)
-	adminRouter.HandleFunc("/users/{id}/peers/{peerID}", admin.DeleteUserPeerHandler).Methods(http.MethodDelete)
-
-	// Admin server routes
-	adminRouter.HandleFunc("/servers", servers.ListServersHandler).Methods(http.MethodGet)
-	adminRouter.HandleFunc("/servers/{id}", servers.GetServerHandler).Methods(http.MethodGet)
-	adminRouter.HandleFunc("/servers", servers.CreateServerHandler).Methods(http.MethodPost)
-	adminRouter.HandleFunc("/servers/{id}", servers.UpdateServerHandler).Methods(http.MethodPut)
-	adminRouter.HandleFunc("/servers/{id}", servers.DeleteServerHandler).Methods(http.MethodDelete)
-	adminRouter.HandleFunc("/servers/{id}/status/{status}", servers.UpdateServerStatusHandler).Methods(http.MethodPut)
-
-	utils.LogInfo("API router setup complete")
-}
+	authRouter := v1Router.PathPrefix("/auth").Subrouter()
+	auth.RegisterRoutes(authRouter)
+
+	// Node agent routes (authenticated by agent token)
+	nodeAuth := middleware.NodeAuth(cfg.Nodes.AgentToken)
+	v1Router.Handle("/nodes/heartbeat", nodeAuth(http.HandlerFunc(nodes.HeartbeatHandler))).Methods("POST")
+	v1Router.Handle("/nodes/state", nodeAuth(http.HandlerFunc(nodes.GetNodeStateHandler))).Methods("GET")
+
+	// Server registration (authenticated by registration token), ahead of
+	// the admin routes it shares a prefix with
+	registerAuth := middleware.NodeAuth(cfg.Nodes.RegisterToken)
+	v1Router.Handle("/admin/servers/register", registerAuth(http.HandlerFunc(servers.RegisterServerHandler))).Methods("POST")
+
+	// One-time config share links, authenticated by their token
+	vpn.RegisterPublicRoutes(v1Router)
+
+	// User routes (protected)
+	userRouter := v1Router.PathPrefix("/user").Subrouter()
+	userRouter.Use(middleware.JWTAuthMiddleware)
+	user.RegisterRoutes(userRouter)
+
+	// VPN routes (protected)
+	vpnRouter := v1Router.PathPrefix("/vpn").Subrouter()
+	vpnRouter.Use(middleware.JWTAuthMiddleware)
+	vpn.RegisterRoutes(vpnRouter)
+
+	// Admin routes (protected, admins on allowlisted networks only)
+	adminRouter := v1Router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(middleware.IPAllowlist(cfg.Server.AdminAllowlist))
+	adminRouter.Use(middleware.AdminMiddleware)
+	admin.RegisterRoutes(adminRouter)
+
+	// OpenAPI spec generated from the routes above
+	spec, err := openapi.Build(router)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build OpenAPI spec: %v", err)
+	}
+	v1Router.HandleFunc("/openapi.json", openapi.Handler(spec)).Methods("GET")
+
+	var apiHandler http.Handler = router
+	if cfg.API.ValidateRequests {
+		validate, err := openapi.ValidateRequests(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to set up request validation: %v", err)
+		}
+		apiHandler = validate(router)
+		utils.LogWarning("Validating requests against the OpenAPI spec; disable api.validateRequests in production")
+	}
+
+	// Set up CORS
+	handler := CORS(cfg).Handler(middleware.APIVersioning(cfg.API)(apiHandler))
 
-// ServeHTTP implements the http.Handler interface
-func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	r.router.ServeHTTP(w, req)
+	return router, handler, nil
 }
 
-// Start starts the API server
-func (r *Router) Start() error {
-	// Start server
-	addr := r.config.APIAddr
-	utils.LogInfo("Starting API server on %s", addr)
-	return http.ListenAndServe(addr, r.router)
+func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"healthy","version":"` + config.Version + `"}`))
 }
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vpn-service/backend/api/admin"
+	"github.com/vpn-service/backend/api/authz"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/core"
+)
+
+// loadTestConfig loads the default config with signing keys in a temporary
+// directory and sets them for the middleware
+func loadTestConfig(t *testing.T) (*config.Config, *core.SigningKeyManager) {
+	dir := t.TempDir()
+	t.Setenv("VPN_CONFIG_PATH", filepath.Join(dir, "config.json"))
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Failed to load default config: %v", err)
+	}
+	cfg.JWT.KeyDir = filepath.Join(dir, "jwt-keys")
+
+	signingKeys, err := core.NewSigningKeyManager(cfg)
+	if err != nil {
+		t.Fatalf("Failed to initialize signing keys: %v", err)
+	}
+	middleware.SigningKeys = signingKeys
+	admin.SigningKeys = signingKeys
+
+	return cfg, signingKeys
+}
+
+// signTestToken signs a short-lived token for a user that does not exist
+func signTestToken(t *testing.T, cfg *config.Config, signingKeys *core.SigningKeyManager, userID, role string) string {
+	now := time.Now()
+	token, err := signingKeys.Sign(jwt.MapClaims{
+		"id":   userID,
+		"role": role,
+		"iss":  cfg.JWT.Issuer,
+		"aud":  cfg.JWT.Audience,
+		"iat":  now.Unix(),
+		"nbf":  now.Unix(),
+		"exp":  now.Add(5 * time.Minute).Unix(),
+		"jti":  userID + "-token",
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+// TestRoutesHaveAuthorizationRules fails for every route of the served
+// router without an expected authorization in api/authz, or that lets
+// through a caller its rule denies, without needing a database
+func TestRoutesHaveAuthorizationRules(t *testing.T) {
+	cfg, signingKeys := loadTestConfig(t)
+
+	router, handler, err := NewRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to build router: %v", err)
+	}
+
+	missing, err := authz.Missing(router)
+	if err != nil {
+		t.Fatalf("Failed to walk routes: %v", err)
+	}
+	if len(missing) > 0 {
+		t.Fatalf("Routes without an authorization rule in api/authz:\n%s", strings.Join(missing, "\n"))
+	}
+
+	violations, err := authz.Exercise(router, handler, authz.Callers{
+		User:      signTestToken(t, cfg, signingKeys, "authz-test-user", "user"),
+		OtherUser: "authz-test-other",
+		Admin:     signTestToken(t, cfg, signingKeys, "authz-test-admin", "admin"),
+		Node:      cfg.Nodes.AgentToken,
+	})
+	if err != nil {
+		t.Fatalf("Failed to exercise routes: %v", err)
+	}
+	for _, violation := range violations {
+		t.Errorf("%s: %s caller got %d", violation.Route, violation.Caller, violation.Status)
+	}
+}
+
+// TestAdminAllowlist rejects admin requests from outside
+// server.adminAllowlist, even with an admin token
+func TestAdminAllowlist(t *testing.T) {
+	cfg, signingKeys := loadTestConfig(t)
+	cfg.Server.AdminAllowlist = []string{"10.0.0.0/8"}
+	token := signTestToken(t, cfg, signingKeys, "allowlist-admin", "admin")
+
+	_, handler, err := NewRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to build router: %v", err)
+	}
+
+	for _, tc := range []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"192.0.2.1:40000", http.StatusForbidden},
+		{"10.1.2.3:40000", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/keys", nil)
+		req.RemoteAddr = tc.remoteAddr
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tc.want {
+			t.Errorf("GET /api/v1/admin/keys from %s: got %d, want %d", tc.remoteAddr, rec.Code, tc.want)
+		}
+	}
+}
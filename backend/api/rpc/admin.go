@@ -0,0 +1,295 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/vpn-service/backend/api/admin"
+	"github.com/vpn-service/backend/api/servers"
+	"github.com/vpn-service/backend/db/models"
+	vpnv1 "github.com/vpn-service/backend/proto/vpn/v1"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// adminService implements the admin service
+type adminService struct {
+	vpnv1.UnimplementedAdminServiceServer
+	serverManager *core.ServerManager
+	userManager   *core.UserManager
+	entitlements  *core.EntitlementManager
+}
+
+// ListUsers lists all users
+func (s *adminService) ListUsers(ctx context.Context, req *vpnv1.ListUsersRequest) (*vpnv1.ListUsersResponse, error) {
+	if s.userManager == nil {
+		return nil, status.Error(codes.Unavailable, "user management is not available")
+	}
+
+	users, err := s.userManager.GetAllUsers()
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to get users")
+	}
+
+	response := &vpnv1.ListUsersResponse{Users: make([]*vpnv1.User, len(users))}
+	for i, user := range users {
+		response.Users[i] = s.user(user)
+	}
+
+	return response, nil
+}
+
+// GetUser gets a user
+func (s *adminService) GetUser(ctx context.Context, req *vpnv1.GetUserRequest) (*vpnv1.User, error) {
+	if s.userManager == nil {
+		return nil, status.Error(codes.Unavailable, "user management is not available")
+	}
+
+	user, err := s.userManager.GetUser(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	return s.user(user), nil
+}
+
+// UpdateUser updates a user's email
+func (s *adminService) UpdateUser(ctx context.Context, req *vpnv1.UpdateUserRequest) (*vpnv1.User, error) {
+	if s.userManager == nil {
+		return nil, status.Error(codes.Unavailable, "user management is not available")
+	}
+
+	// Validate request
+	update := admin.UserUpdateRequest{Email: req.Email}
+	if err := update.Validate(); err != nil {
+		return nil, validationError(err)
+	}
+
+	user, err := s.userManager.GetUser(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	if update.Email != "" {
+		user, err = s.userManager.UpdateUser(req.Id, update.Email)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to update user")
+		}
+	}
+
+	return s.user(user), nil
+}
+
+// DeleteUser deletes a user
+func (s *adminService) DeleteUser(ctx context.Context, req *vpnv1.DeleteUserRequest) (*vpnv1.DeleteUserResponse, error) {
+	if s.userManager == nil {
+		return nil, status.Error(codes.Unavailable, "user management is not available")
+	}
+
+	if err := s.userManager.DeleteUser(req.Id); err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	return &vpnv1.DeleteUserResponse{}, nil
+}
+
+// SetUserPlan assigns a plan to a user
+func (s *adminService) SetUserPlan(ctx context.Context, req *vpnv1.SetUserPlanRequest) (*vpnv1.SetUserPlanResponse, error) {
+	var v utils.Validator
+	v.Required("user_id", req.UserId)
+	v.Required("plan_id", req.PlanId)
+	if err := v.Err(); err != nil {
+		return nil, validationError(err)
+	}
+
+	if err := s.entitlements.SetUserPlan(req.UserId, req.PlanId); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &vpnv1.SetUserPlanResponse{}, nil
+}
+
+// ListPlans lists all plans
+func (s *adminService) ListPlans(ctx context.Context, req *vpnv1.ListPlansRequest) (*vpnv1.ListPlansResponse, error) {
+	plans := s.entitlements.ListPlans()
+
+	response := &vpnv1.ListPlansResponse{Plans: make([]*vpnv1.Plan, len(plans))}
+	for i, plan := range plans {
+		response.Plans[i] = toPlan(plan)
+	}
+
+	return response, nil
+}
+
+// GetPlan gets a plan
+func (s *adminService) GetPlan(ctx context.Context, req *vpnv1.GetPlanRequest) (*vpnv1.Plan, error) {
+	plan, err := s.entitlements.GetPlan(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return toPlan(plan), nil
+}
+
+// CreatePlan creates a plan
+func (s *adminService) CreatePlan(ctx context.Context, req *vpnv1.CreatePlanRequest) (*vpnv1.Plan, error) {
+	// Validate request
+	create := admin.PlanRequest{ID: req.Id, Name: req.Name, Entitlements: fromEntitlements(req.Entitlements)}
+	if err := create.Validate(); err != nil {
+		return nil, validationError(err)
+	}
+
+	plan, err := s.entitlements.CreatePlan(create.ID, create.Name, create.Entitlements)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return toPlan(plan), nil
+}
+
+// UpdatePlan updates a plan. Changes apply to every user on the plan from
+// their next request.
+func (s *adminService) UpdatePlan(ctx context.Context, req *vpnv1.UpdatePlanRequest) (*vpnv1.Plan, error) {
+	// Validate request
+	update := admin.PlanRequest{ID: req.Id, Name: req.Name, Entitlements: fromEntitlements(req.Entitlements)}
+	if err := update.Validate(); err != nil {
+		return nil, validationError(err)
+	}
+
+	plan, err := s.entitlements.UpdatePlan(update.ID, update.Name, update.Entitlements)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return toPlan(plan), nil
+}
+
+// DeletePlan deletes a plan. Users on the plan fall back to the default plan.
+func (s *adminService) DeletePlan(ctx context.Context, req *vpnv1.DeletePlanRequest) (*vpnv1.DeletePlanResponse, error) {
+	if err := s.entitlements.DeletePlan(req.Id); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &vpnv1.DeletePlanResponse{}, nil
+}
+
+// ListServers lists all servers
+func (s *adminService) ListServers(ctx context.Context, req *vpnv1.ListServersRequest) (*vpnv1.ListServersResponse, error) {
+	return toServers(s.serverManager.GetServers()), nil
+}
+
+// GetServer gets a server
+func (s *adminService) GetServer(ctx context.Context, req *vpnv1.GetServerRequest) (*vpnv1.Server, error) {
+	server, err := s.serverManager.GetServer(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "server not found")
+	}
+
+	return toServer(server), nil
+}
+
+// CreateServer adds a server, resolving its location from the IP unless
+// given
+func (s *adminService) CreateServer(ctx context.Context, req *vpnv1.CreateServerRequest) (*vpnv1.Server, error) {
+	// Validate request
+	create := servers.ServerRequest{Name: req.Name, IP: req.Ip, Country: req.Country, City: req.City, BandwidthClass: req.BandwidthClass}
+	if err := create.Validate(); err != nil {
+		return nil, validationError(err)
+	}
+
+	server := &core.Server{
+		ID:             utils.GenerateUUID(),
+		Name:           create.Name,
+		Country:        create.Country,
+		City:           create.City,
+		IP:             create.IP,
+		Status:         "offline",
+		BandwidthClass: create.BandwidthClass,
+	}
+
+	// Resolve location from IP
+	if err := s.serverManager.LocateServer(server); err != nil {
+		utils.LogWarning("Failed to locate server %s: %v", server.IP, err)
+	}
+
+	if err := s.serverManager.AddServer(server); err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+
+	return toServer(server), nil
+}
+
+// UpdateServer replaces a server's name, IP and location
+func (s *adminService) UpdateServer(ctx context.Context, req *vpnv1.UpdateServerRequest) (*vpnv1.Server, error) {
+	// Validate request
+	update := servers.ServerRequest{Name: req.Name, IP: req.Ip, Country: req.Country, City: req.City, BandwidthClass: req.BandwidthClass}
+	if err := update.Validate(); err != nil {
+		return nil, validationError(err)
+	}
+
+	if _, err := s.serverManager.GetServer(req.Id); err != nil {
+		return nil, status.Error(codes.NotFound, "server not found")
+	}
+
+	server := &core.Server{
+		ID:             req.Id,
+		Name:           update.Name,
+		Country:        update.Country,
+		City:           update.City,
+		IP:             update.IP,
+		BandwidthClass: update.BandwidthClass,
+	}
+
+	// Resolve location again unless it is given
+	if err := s.serverManager.LocateServer(server); err != nil {
+		utils.LogWarning("Failed to locate server %s: %v", server.IP, err)
+	}
+
+	if err := s.serverManager.UpdateServer(server); err != nil {
+		return nil, status.Error(codes.NotFound, "server not found")
+	}
+
+	return toServer(server), nil
+}
+
+// UpdateServerStatus sets a server online, offline, into maintenance or
+// draining
+func (s *adminService) UpdateServerStatus(ctx context.Context, req *vpnv1.UpdateServerStatusRequest) (*vpnv1.Server, error) {
+	var v utils.Validator
+	v.Required("status", req.Status)
+	v.OneOf("status", req.Status, "online", "offline", "maintenance", "draining")
+	if err := v.Err(); err != nil {
+		return nil, validationError(err)
+	}
+
+	if err := s.serverManager.UpdateServerStatus(req.Id, req.Status); err != nil {
+		return nil, status.Error(codes.NotFound, "server not found")
+	}
+
+	server, err := s.serverManager.GetServer(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "server not found")
+	}
+
+	return toServer(server), nil
+}
+
+// DeleteServer removes a server
+func (s *adminService) DeleteServer(ctx context.Context, req *vpnv1.DeleteServerRequest) (*vpnv1.DeleteServerResponse, error) {
+	if err := s.serverManager.RemoveServer(req.Id); err != nil {
+		return nil, status.Error(codes.NotFound, "server not found")
+	}
+
+	return &vpnv1.DeleteServerResponse{}, nil
+}
+
+// user converts a user to its gRPC message, including whether the user
+// has two-factor authentication enabled
+func (s *adminService) user(user *models.User) *vpnv1.User {
+	message := toUser(user)
+	if mfa := s.userManager.MFA(); mfa != nil {
+		message.MfaEnabled = mfa.Enabled(user.ID)
+	}
+	return message
+}
@@ -0,0 +1,303 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	vpnv1 "github.com/vpn-service/backend/proto/vpn/v1"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// agentEnrollMethod is the only agent call made without a node certificate
+const agentEnrollMethod = "/vpn.v1.AgentService/Enroll"
+
+// AgentServer is the agent service on its own mutual TLS listener. Node
+// agents authenticate with the certificate the agent CA issued them.
+type AgentServer struct {
+	config *config.Config
+	server *grpc.Server
+	ca     *core.AgentCA
+}
+
+// NewAgentServer creates a new agent server backed by the given managers
+func NewAgentServer(cfg *config.Config, vpnManager *core.VPNManager, serverManager *core.ServerManager, ca *core.AgentCA) (*AgentServer, error) {
+	s := &AgentServer{config: cfg, ca: ca}
+
+	certificate, err := ca.ListenerCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Client certificates are optional at the TLS layer so agents can
+	// enroll; every other call is rejected without one
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    ca.CertPool(),
+		MinVersion:   tls.VersionTLS12,
+	})
+
+	s.server = grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.ChainUnaryInterceptor(recoverUnary, s.authenticateUnary),
+		grpc.ChainStreamInterceptor(recoverStream, s.authenticateStream),
+	)
+	vpnv1.RegisterAgentServiceServer(s.server, &agentService{
+		config:        cfg,
+		vpnManager:    vpnManager,
+		serverManager: serverManager,
+		ca:            ca,
+	})
+
+	return s, nil
+}
+
+// Start serves agent calls on the configured address until Stop is called
+func (s *AgentServer) Start() error {
+	addr := s.config.GRPC.Agents.Addr
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+
+	utils.LogInfo("Starting gRPC agent server on %s", addr)
+	return s.server.Serve(listener)
+}
+
+// Stop stops accepting calls and ends the open streams
+func (s *AgentServer) Stop() {
+	// Agent streams stay open until the agent stops, so do not wait on them
+	s.server.Stop()
+}
+
+// authenticateUnary authenticates unary calls
+func (s *AgentServer) authenticateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authenticateStream authenticates streaming calls
+func (s *AgentServer) authenticateStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticate checks the node certificate of a call and adds the server
+// ID it names to the context. Enroll checks its token itself.
+func (s *AgentServer) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if method == agentEnrollMethod {
+		return ctx, nil
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "node certificate is required")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "node certificate is required")
+	}
+
+	serverID, err := s.ca.Verify(info.State.VerifiedChains[0][0])
+	if err != nil {
+		utils.LogWarning("Rejected agent call from %s: %s: %v", p.Addr, method, err)
+		return nil, status.Error(codes.Unauthenticated, "node certificate is not valid")
+	}
+
+	return context.WithValue(ctx, "serverID", serverID), nil
+}
+
+// agentService implements the agent service
+type agentService struct {
+	vpnv1.UnimplementedAgentServiceServer
+	config        *config.Config
+	vpnManager    *core.VPNManager
+	serverManager *core.ServerManager
+	ca            *core.AgentCA
+}
+
+// Enroll issues a node certificate in exchange for an enrollment token
+func (s *agentService) Enroll(ctx context.Context, req *vpnv1.EnrollRequest) (*vpnv1.EnrollResponse, error) {
+	// Validate request
+	var v utils.Validator
+	v.Required("serverId", req.ServerId)
+	v.Required("token", req.Token)
+	v.Required("csr", req.Csr)
+	if err := v.Err(); err != nil {
+		return nil, validationError(err)
+	}
+
+	if _, err := s.serverManager.GetServer(req.ServerId); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	issued, err := s.ca.Enroll(req.ServerId, req.Token, req.Csr)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return toEnrollResponse(issued), nil
+}
+
+// RenewCertificate issues a new node certificate to an enrolled agent
+func (s *agentService) RenewCertificate(ctx context.Context, req *vpnv1.RenewCertificateRequest) (*vpnv1.EnrollResponse, error) {
+	serverID := ctx.Value("serverID").(string)
+
+	// Validate request
+	var v utils.Validator
+	v.Required("csr", req.Csr)
+	if err := v.Err(); err != nil {
+		return nil, validationError(err)
+	}
+
+	issued, err := s.ca.Renew(serverID, req.Csr)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return toEnrollResponse(issued), nil
+}
+
+// SyncPeers sends the state of the node unless the agent already has it,
+// then again whenever it changes, checking every grpc.statusInterval
+// seconds
+func (s *agentService) SyncPeers(req *vpnv1.SyncPeersRequest, stream vpnv1.AgentService_SyncPeersServer) error {
+	ctx := stream.Context()
+	serverID := ctx.Value("serverID").(string)
+
+	ticker := time.NewTicker(s.interval())
+	defer ticker.Stop()
+
+	version := req.KnownVersion
+	for {
+		state, err := s.vpnManager.NodeState(serverID)
+		if err != nil {
+			return status.Error(codes.NotFound, err.Error())
+		}
+
+		// Only send changes
+		if state.Version != version {
+			if err := stream.Send(toNodeState(state)); err != nil {
+				return err
+			}
+			version = state.Version
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReportStatus records each status report of the agent as a heartbeat and
+// answers it with the agent version and node certificate to install
+func (s *agentService) ReportStatus(stream vpnv1.AgentService_ReportStatusServer) error {
+	serverID := stream.Context().Value("serverID").(string)
+
+	for {
+		report, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Validate report
+		heartbeat := fromStatusReport(serverID, report)
+		if err := heartbeat.Validate(); err != nil {
+			return validationError(err)
+		}
+
+		// Record heartbeat
+		response, err := s.serverManager.Heartbeat(heartbeat)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		if err := stream.Send(&vpnv1.StatusResponse{
+			TargetAgentVersion: response.TargetAgentVersion,
+			RolloutId:          response.RolloutID,
+			Certificate:        toNodeCertificate(response.Certificate),
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// ExecuteCommands sends the commands queued for the node, checking every
+// grpc.statusInterval seconds, and records the results the agent sends back
+func (s *agentService) ExecuteCommands(stream vpnv1.AgentService_ExecuteCommandsServer) error {
+	ctx := stream.Context()
+	serverID := ctx.Value("serverID").(string)
+
+	// Receive results until the agent closes its side
+	received := make(chan error, 1)
+	go func() {
+		for {
+			result, err := stream.Recv()
+			if err != nil {
+				received <- err
+				return
+			}
+			s.serverManager.CompleteNodeCommands(serverID, []core.NodeCommandResult{{ID: result.Id, Error: result.Error}})
+		}
+	}()
+
+	ticker := time.NewTicker(s.interval())
+	defer ticker.Stop()
+
+	for {
+		for _, command := range s.serverManager.TakeNodeCommands(serverID) {
+			if err := stream.Send(&vpnv1.NodeCommand{
+				Id:        command.ID,
+				Type:      command.Type,
+				CreatedAt: timestamppb.New(command.CreatedAt),
+				Version:   command.Version,
+			}); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-received:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// interval gets how often streams check for changes
+func (s *agentService) interval() time.Duration {
+	interval := time.Duration(s.config.GRPC.StatusInterval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return interval
+}
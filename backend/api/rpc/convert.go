@@ -0,0 +1,279 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/vpn-service/backend/db/models"
+	vpnv1 "github.com/vpn-service/backend/proto/vpn/v1"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// toServer converts a server to its gRPC message
+func toServer(server *core.Server) *vpnv1.Server {
+	return &vpnv1.Server{
+		Id:             server.ID,
+		Name:           server.Name,
+		Country:        server.Country,
+		CountryCode:    server.CountryCode,
+		City:           server.City,
+		Ip:             server.IP,
+		Status:         server.Status,
+		Load:           int32(server.Load),
+		Capacity:       int32(server.Capacity),
+		Asn:            uint32(server.ASN),
+		AsOrg:          server.ASOrg,
+		LocationSource: server.LocationSource,
+		LastUpdated:    timestamppb.New(server.LastUpdated),
+		BandwidthClass: server.BandwidthClass,
+		Region:         server.Region,
+	}
+}
+
+// toServers converts a server list to gRPC messages
+func toServers(servers []*core.Server) *vpnv1.ListServersResponse {
+	response := &vpnv1.ListServersResponse{Servers: make([]*vpnv1.Server, len(servers))}
+	for i, server := range servers {
+		response.Servers[i] = toServer(server)
+	}
+	return response
+}
+
+// toStatus converts a user's peers to a status message
+func toStatus(peers []*wireguard.PeerInfo) *vpnv1.GetStatusResponse {
+	response := &vpnv1.GetStatusResponse{
+		Connected: len(peers) > 0,
+		Peers:     make([]*vpnv1.Peer, len(peers)),
+	}
+	for i, peer := range peers {
+		response.Peers[i] = &vpnv1.Peer{
+			Id:         peer.ID,
+			ServerId:   peer.ServerID,
+			ServerName: peer.ServerName,
+			DeviceType: peer.DeviceType,
+			DeviceName: peer.DeviceName,
+			Ip:         peer.IP,
+			CreatedAt:  peer.CreatedAt,
+			LastSeen:   peer.LastSeen,
+			BytesRx:    peer.BytesRx,
+			BytesTx:    peer.BytesTx,
+			Dynamic:    peer.Dynamic,
+			SessionId:  peer.SessionID,
+			ExpiresAt:  peer.ExpiresAt,
+			Status:     peer.Status,
+		}
+	}
+	return response
+}
+
+// toUser converts a user to its gRPC message
+func toUser(user *models.User) *vpnv1.User {
+	return &vpnv1.User{
+		Id:         user.ID,
+		Username:   user.Username,
+		Email:      user.Email,
+		Role:       user.Role,
+		Plan:       user.Plan,
+		MfaEnabled: user.MFAEnabled,
+		CreatedAt:  timestamppb.New(user.CreatedAt),
+		UpdatedAt:  timestamppb.New(user.UpdatedAt),
+	}
+}
+
+// toPlan converts a plan to its gRPC message
+func toPlan(plan *models.Plan) *vpnv1.Plan {
+	e := plan.Entitlements
+	return &vpnv1.Plan{
+		Id:   plan.ID,
+		Name: plan.Name,
+		Entitlements: &vpnv1.Entitlements{
+			Protocols:      e.Protocols,
+			MaxDevices:     int32(e.MaxDevices),
+			MultiHop:       e.MultiHop,
+			DedicatedIp:    e.DedicatedIP,
+			PortForwarding: e.PortForwarding,
+			BandwidthMbps:  int32(e.BandwidthMbps),
+			RequireStepUp:  e.RequireStepUp,
+		},
+		CreatedAt: timestamppb.New(plan.CreatedAt),
+		UpdatedAt: timestamppb.New(plan.UpdatedAt),
+	}
+}
+
+// fromEntitlements converts gRPC entitlements to plan entitlements
+func fromEntitlements(e *vpnv1.Entitlements) models.Entitlements {
+	if e == nil {
+		return models.Entitlements{}
+	}
+	return models.Entitlements{
+		Protocols:      e.Protocols,
+		MaxDevices:     int(e.MaxDevices),
+		MultiHop:       e.MultiHop,
+		DedicatedIP:    e.DedicatedIp,
+		PortForwarding: e.PortForwarding,
+		BandwidthMbps:  int(e.BandwidthMbps),
+		RequireStepUp:  e.RequireStepUp,
+	}
+}
+
+// toNodeCertificate converts a node certificate to its gRPC message
+func toNodeCertificate(certificate *core.NodeCertificate) *vpnv1.NodeCertificate {
+	if certificate == nil {
+		return nil
+	}
+	return &vpnv1.NodeCertificate{
+		Version:   certificate.Version,
+		Domains:   certificate.Domains,
+		NotBefore: timestamppb.New(certificate.NotBefore),
+		NotAfter:  timestamppb.New(certificate.NotAfter),
+		CertPem:   certificate.CertPEM,
+		KeyPem:    certificate.KeyPEM,
+	}
+}
+
+// toNodeState converts the state of a node to its gRPC message, leaving
+// out the peers its agent does not configure
+func toNodeState(state *wireguard.NodeState) *vpnv1.NodeState {
+	message := &vpnv1.NodeState{
+		ServerId:     state.ServerID,
+		Version:      state.Version,
+		Peers:        make([]*vpnv1.NodePeer, 0, len(state.Peers)),
+		Acl:          make([]*vpnv1.ACLPolicy, len(state.ACL)),
+		Reservations: make([]*vpnv1.AddressReservation, len(state.Reservations)),
+	}
+	for _, peer := range state.Peers {
+		if peer.Pending() || peer.Archived() {
+			continue
+		}
+		message.Peers = append(message.Peers, &vpnv1.NodePeer{
+			Id:            peer.ID,
+			UserId:        peer.UserID,
+			DeviceType:    peer.DeviceType,
+			PublicKey:     peer.PublicKey,
+			Ip:            peer.IP,
+			Interface:     peer.Interface,
+			RoutedSubnets: peer.RoutedSubnets,
+			ExitServer:    peer.ExitServer,
+			Routes:        peer.Routes,
+			DnsProfile:    peer.DNSProfile,
+			Dynamic:       peer.Dynamic,
+		})
+	}
+	for i, policy := range state.ACL {
+		message.Acl[i] = &vpnv1.ACLPolicy{
+			Id:          policy.ID,
+			Source:      policy.Source,
+			Destination: policy.Destination,
+			Protocol:    policy.Protocol,
+			Port:        int32(policy.Port),
+			Action:      policy.Action,
+		}
+	}
+	for i, reservation := range state.Reservations {
+		message.Reservations[i] = &vpnv1.AddressReservation{
+			UserId:   reservation.UserID,
+			Ip:       reservation.IP,
+			PublicIp: reservation.PublicIP,
+			ServerId: reservation.ServerID,
+		}
+	}
+	return message
+}
+
+// fromStatusReport converts an agent status report to a heartbeat
+func fromStatusReport(serverID string, report *vpnv1.StatusReport) core.NodeHeartbeat {
+	health := &core.NodeHealth{
+		Up:            report.Up,
+		Peers:         int(report.Peers),
+		ActivePeers:   int(report.ActivePeers),
+		ReceiveBytes:  report.ReceiveBytes,
+		TransmitBytes: report.TransmitBytes,
+		StateVersion:  report.StateVersion,
+		Error:         report.Error,
+		CPU:           report.Cpu,
+		Memory:        report.Memory,
+		ReceiveRate:   report.ReceiveRate,
+		TransmitRate:  report.TransmitRate,
+	}
+	if drift := report.Drift; drift != nil {
+		health.Drift = &wireguard.Drift{
+			Missing:    int(drift.Missing),
+			Unexpected: int(drift.Unexpected),
+			Changed:    int(drift.Changed),
+			Corrected:  drift.Corrected,
+		}
+	}
+	for _, iface := range report.Interfaces {
+		health.Interfaces = append(health.Interfaces, wireguard.InterfaceStatus{
+			Interface:     wireguard.Interface{Name: iface.Name},
+			Up:            iface.Up,
+			Peers:         int(iface.Peers),
+			ActivePeers:   int(iface.ActivePeers),
+			ReceiveBytes:  iface.ReceiveBytes,
+			TransmitBytes: iface.TransmitBytes,
+		})
+	}
+
+	return core.NodeHeartbeat{
+		ServerID:                serverID,
+		AgentVersion:            report.AgentVersion,
+		WireGuardVersion:        report.WireguardVersion,
+		CertificateVersion:      report.CertificateVersion,
+		WireGuardImplementation: report.WireguardImplementation,
+		Obfuscation:             report.Obfuscation,
+		Health:                  health,
+	}
+}
+
+// toEnrollResponse converts an issued node certificate to its gRPC message
+func toEnrollResponse(issued *core.IssuedNodeCertificate) *vpnv1.EnrollResponse {
+	return &vpnv1.EnrollResponse{
+		Certificate:   issued.CertPEM,
+		CaCertificate: issued.CAPEM,
+		NotAfter:      timestamppb.New(issued.NotAfter),
+	}
+}
+
+// validationError converts a request validation error to an InvalidArgument
+// status listing every invalid field
+func validationError(err error) error {
+	var invalid *utils.ValidationError
+	if !errors.As(err, &invalid) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	violations := make([]*errdetails.BadRequest_FieldViolation, len(invalid.Fields))
+	for i, field := range invalid.Fields {
+		violations[i] = &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message}
+	}
+
+	st := status.New(codes.InvalidArgument, "invalid request: "+invalid.Error())
+	if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// operationError converts the error of a VPN operation to a status, like
+// the REST API's operation errors
+func operationError(ctx context.Context, err error, message string) error {
+	if _, ok := err.(*core.EntitlementError); ok {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		utils.LogWarningContext(ctx, "Client disconnected: %s: %v", message, err)
+		return status.Error(codes.Canceled, message)
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, message+": operation timed out")
+	default:
+		return status.Error(codes.Internal, message+": "+err.Error())
+	}
+}
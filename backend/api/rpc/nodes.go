@@ -0,0 +1,43 @@
+package rpc
+
+import (
+	"context"
+
+	vpnv1 "github.com/vpn-service/backend/proto/vpn/v1"
+	"github.com/vpn-service/backend/src/core"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// nodeService implements the node agent service
+type nodeService struct {
+	vpnv1.UnimplementedNodeServiceServer
+	serverManager *core.ServerManager
+}
+
+// Heartbeat records a node agent heartbeat. The response carries the agent
+// version the node should upgrade to, if any.
+func (s *nodeService) Heartbeat(ctx context.Context, req *vpnv1.HeartbeatRequest) (*vpnv1.HeartbeatResponse, error) {
+	// Validate request
+	heartbeat := core.NodeHeartbeat{
+		ServerID:           req.ServerId,
+		AgentVersion:       req.AgentVersion,
+		WireGuardVersion:   req.WireguardVersion,
+		CertificateVersion: req.CertificateVersion,
+	}
+	if err := heartbeat.Validate(); err != nil {
+		return nil, validationError(err)
+	}
+
+	// Record heartbeat
+	response, err := s.serverManager.Heartbeat(heartbeat)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &vpnv1.HeartbeatResponse{
+		TargetAgentVersion: response.TargetAgentVersion,
+		RolloutId:          response.RolloutID,
+		Certificate:        toNodeCertificate(response.Certificate),
+	}, nil
+}
@@ -0,0 +1,230 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/db/models"
+	vpnv1 "github.com/vpn-service/backend/proto/vpn/v1"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Service name prefixes of full method names
+const (
+	vpnServicePrefix   = "/vpn.v1.VPNService/"
+	adminServicePrefix = "/vpn.v1.AdminService/"
+	nodeServicePrefix  = "/vpn.v1.NodeService/"
+)
+
+// Server is the gRPC control-plane API. It exposes the same operations as
+// the REST API to internal services and node agents.
+type Server struct {
+	config         *config.Config
+	server         *grpc.Server
+	adminAllowlist func(clientIP string) bool
+	resolveClient  func(peerIP string, forwardedFor []string, realIP string) string
+}
+
+// NewServer creates a new gRPC server backed by the given managers
+func NewServer(cfg *config.Config, vpnManager *core.VPNManager, serverManager *core.ServerManager, userManager *core.UserManager) (*Server, error) {
+	s := &Server{
+		config:         cfg,
+		adminAllowlist: middleware.Allowlist(cfg.Server.AdminAllowlist),
+		resolveClient:  middleware.ProxyResolver(cfg.Server.TrustedProxies),
+	}
+
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(recoverUnary, s.authenticateUnary),
+		grpc.ChainStreamInterceptor(recoverStream, s.authenticateStream),
+	}
+
+	// Tune connections by the listener profile. Read and write timeouts
+	// do not apply to gRPC, whose streams stay open.
+	profile := cfg.ListenerProfile(cfg.GRPC.Profile)
+	if profile.ReadHeaderTimeout > 0 {
+		options = append(options, grpc.ConnectionTimeout(time.Duration(profile.ReadHeaderTimeout)*time.Second))
+	}
+	if profile.IdleTimeout > 0 {
+		options = append(options, grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionIdle: time.Duration(profile.IdleTimeout) * time.Second}))
+	}
+	if profile.MaxHeaderBytes > 0 {
+		options = append(options, grpc.MaxHeaderListSize(uint32(profile.MaxHeaderBytes)))
+	}
+
+	// Serve TLS when a certificate is configured
+	if cfg.GRPC.TLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.GRPC.TLSCert, cfg.GRPC.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load gRPC TLS certificate: %v", err)
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+
+	s.server = grpc.NewServer(options...)
+	vpnv1.RegisterVPNServiceServer(s.server, &vpnService{config: cfg, vpnManager: vpnManager})
+	vpnv1.RegisterAdminServiceServer(s.server, &adminService{serverManager: serverManager, userManager: userManager, entitlements: vpnManager.Entitlements()})
+	vpnv1.RegisterNodeServiceServer(s.server, &nodeService{serverManager: serverManager})
+
+	return s, nil
+}
+
+// Start serves gRPC requests on the configured address until Stop is called
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.GRPC.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.config.GRPC.Addr, err)
+	}
+
+	utils.LogInfo("Starting gRPC server on %s", s.config.GRPC.Addr)
+	return s.server.Serve(listener)
+}
+
+// Stop stops accepting calls and waits for running calls to finish
+func (s *Server) Stop() {
+	s.server.GracefulStop()
+}
+
+// authenticateUnary authenticates unary calls
+func (s *Server) authenticateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authenticateStream authenticates streaming calls
+func (s *Server) authenticateStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticate checks the credentials a call needs: the agent token for the
+// node service, a user token for the VPN service, and an admin token from an
+// allowed address for the admin service
+func (s *Server) authenticate(ctx context.Context, method string) (context.Context, error) {
+	token := bearerToken(ctx)
+
+	if strings.HasPrefix(method, nodeServicePrefix) {
+		agentToken := s.config.Nodes.AgentToken
+		if agentToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(agentToken)) != 1 {
+			utils.LogWarning("Rejected node call from %s: %s", s.clientIP(ctx), method)
+			return nil, status.Error(codes.Unauthenticated, "invalid agent token")
+		}
+		return ctx, nil
+	}
+
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "authorization metadata is required")
+	}
+	ctx, err := middleware.Authenticate(ctx, token)
+	if errors.Is(err, middleware.ErrRevocationUnavailable) {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if strings.HasPrefix(method, adminServicePrefix) {
+		if ip := s.clientIP(ctx); !s.adminAllowlist(ip) {
+			utils.LogWarning("Denied admin call from %s: %s", ip, method)
+			middleware.RecordDeniedAdminAccess(method, ip)
+			return nil, status.Error(codes.PermissionDenied, "access denied")
+		}
+		if role, _ := ctx.Value("role").(string); role != models.RoleAdmin {
+			return nil, status.Error(codes.PermissionDenied, "admin role required")
+		}
+	}
+
+	return ctx, nil
+}
+
+// recoverUnary turns panics in unary handlers into internal errors
+func recoverUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = recovered(info.FullMethod, p)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// recoverStream turns panics in streaming handlers into internal errors
+func recoverStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = recovered(info.FullMethod, p)
+		}
+	}()
+	return handler(srv, stream)
+}
+
+// recovered logs a recovered panic and returns the error sent to the client
+func recovered(method string, p interface{}) error {
+	utils.LogError("Panic in gRPC call %s: %v\n%s", method, p, debug.Stack())
+	return status.Error(codes.Internal, "internal error")
+}
+
+// authenticatedStream carries the authenticated context of a stream
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the authenticated context
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// bearerToken gets the bearer token from the authorization metadata
+func bearerToken(ctx context.Context) string {
+	return strings.TrimPrefix(metadataValue(ctx, "authorization"), "Bearer ")
+}
+
+// metadataValue gets the first value of an incoming metadata key
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// clientIP gets the address of the calling client, taken from the
+// forwarding metadata when the call was relayed by a trusted proxy
+func (s *Server) clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	return s.resolveClient(host, md.Get("x-forwarded-for"), metadataValue(ctx, "x-real-ip"))
+}
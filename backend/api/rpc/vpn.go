@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/api/vpn"
+	vpnv1 "github.com/vpn-service/backend/proto/vpn/v1"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/core"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// stepUpMetadata carries the step-up token, like the X-Step-Up-Token header
+const stepUpMetadata = "x-step-up-token"
+
+// vpnService implements the VPN service
+type vpnService struct {
+	vpnv1.UnimplementedVPNServiceServer
+	config     *config.Config
+	vpnManager *core.VPNManager
+}
+
+// ListServers lists the available VPN servers
+func (s *vpnService) ListServers(ctx context.Context, req *vpnv1.ListServersRequest) (*vpnv1.ListServersResponse, error) {
+	return toServers(s.vpnManager.GetServers()), nil
+}
+
+// Connect creates a peer for a device
+func (s *vpnService) Connect(ctx context.Context, req *vpnv1.ConnectRequest) (*vpnv1.ConnectResponse, error) {
+	userID := ctx.Value("userID").(string)
+
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new keys
+	if err := middleware.VerifyStepUp(ctx, metadataValue(ctx, stepUpMetadata)); err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	// Validate request
+	connect := vpn.ConnectRequest{ServerID: req.ServerId, DeviceType: req.DeviceType, DeviceName: req.DeviceName}
+	if err := connect.Validate(); err != nil {
+		return nil, validationError(err)
+	}
+
+	// Default device type and name
+	deviceType := connect.DeviceType
+	if deviceType == "" {
+		deviceType = "generic"
+	}
+	deviceName := connect.DeviceName
+	if deviceName == "" {
+		deviceName = deviceType
+	}
+
+	// Connect to VPN
+	peer, config, err := s.vpnManager.Connect(ctx, userID, connect.ServerID, deviceType, deviceName, "", "", core.DNSChoice{}, core.TunnelChoice{})
+	vpn.RecordConnect(ctx, err)
+	if err != nil {
+		return nil, operationError(ctx, err, "failed to connect to VPN")
+	}
+
+	return &vpnv1.ConnectResponse{
+		Config:     config,
+		PeerId:     peer.ID,
+		ServerId:   peer.ServerID,
+		ServerIp:   peer.ServerIP,
+		FailedOver: vpn.RecordFailover(connect.ServerID, peer.ServerID),
+	}, nil
+}
+
+// Disconnect removes a device's peer
+func (s *vpnService) Disconnect(ctx context.Context, req *vpnv1.DisconnectRequest) (*vpnv1.DisconnectResponse, error) {
+	userID := ctx.Value("userID").(string)
+
+	// Validate request
+	disconnect := vpn.DisconnectRequest{PeerID: req.PeerId}
+	if err := disconnect.Validate(); err != nil {
+		return nil, validationError(err)
+	}
+
+	// Disconnect from VPN
+	if err := s.vpnManager.Disconnect(ctx, userID, disconnect.PeerID); err != nil {
+		return nil, operationError(ctx, err, "failed to disconnect from VPN")
+	}
+
+	return &vpnv1.DisconnectResponse{}, nil
+}
+
+// GetStatus gets the connection status of the user's devices
+func (s *vpnService) GetStatus(ctx context.Context, req *vpnv1.GetStatusRequest) (*vpnv1.GetStatusResponse, error) {
+	userID := ctx.Value("userID").(string)
+
+	peers, err := s.vpnManager.GetStatus(ctx, userID)
+	if err != nil {
+		return nil, operationError(ctx, err, "failed to get connection status")
+	}
+
+	return toStatus(peers), nil
+}
+
+// WatchStatus sends the connection status when the stream opens and again
+// whenever it changes, checking every grpc.statusInterval seconds
+func (s *vpnService) WatchStatus(req *vpnv1.WatchStatusRequest, stream vpnv1.VPNService_WatchStatusServer) error {
+	ctx := stream.Context()
+	userID := ctx.Value("userID").(string)
+
+	interval := time.Duration(s.config.GRPC.StatusInterval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last *vpnv1.GetStatusResponse
+	for {
+		peers, err := s.vpnManager.GetStatus(ctx, userID)
+		if err != nil {
+			return operationError(ctx, err, "failed to get connection status")
+		}
+
+		// Only send changes
+		current := toStatus(peers)
+		if last == nil || !proto.Equal(current, last) {
+			if err := stream.Send(current); err != nil {
+				return err
+			}
+			last = current
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
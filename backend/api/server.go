@@ -3,9 +3,7 @@ package api
 import (
 	"context"
 	"net/http"
-	"time"
 
-	"github.com/rs/cors"
 	"github.com/vpn-service/backend/src/config"
 	"github.com/vpn-service/backend/src/utils"
 )
@@ -19,23 +17,10 @@ type Server struct {
 // NewServer creates a new API server
 func NewServer(cfg *config.Config, router http.Handler) *Server {
 	// Set up CORS
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
-		AllowCredentials: true,
-		MaxAge:           86400,
-	})
-	handler := c.Handler(router)
+	handler := CORS(cfg).Handler(router)
 
 	// Create server
-	server := &http.Server{
-		Addr:         cfg.APIAddr,
-		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
+	server := NewHTTPServer(cfg, handler)
 
 	return &Server{
 		config: cfg,
@@ -45,8 +30,13 @@ func NewServer(cfg *config.Config, router http.Handler) *Server {
 
 // Start starts the API server
 func (s *Server) Start() error {
-	utils.LogInfo("API server listening on %s", s.server.Addr)
-	return s.server.ListenAndServe()
+	listener, err := Listen(s.config)
+	if err != nil {
+		return err
+	}
+
+	utils.LogInfo("API server listening on %s", ListenAddr(s.config))
+	return s.server.Serve(listener)
 }
 
 // Shutdown gracefully shuts down the API server
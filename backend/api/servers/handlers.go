@@ -1,23 +1,72 @@
 package servers
 
 import (
-	"encoding/json"
+	"net"
 	"net/http"
-	"strings"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/vpn-service/backend/src/core"
 	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
 )
 
 // ServerManager is the server manager instance
 var ServerManager *core.ServerManager
 
-// ServerRequest represents a server creation/update request
+// ServerRequest represents a server creation/update request. Country and
+// city are resolved from the IP unless given.
 type ServerRequest struct {
-	Name     string `json:"name"`
-	Location string `json:"location"`
-	IP       string `json:"ip"`
+	Name           string `json:"name"`
+	IP             string `json:"ip"`
+	Country        string `json:"country,omitempty"`
+	City           string `json:"city,omitempty"`
+	BandwidthClass string `json:"bandwidthClass,omitempty"` // one of balancing.bandwidthClasses
+}
+
+// Validate checks the fields of a server request
+func (req *ServerRequest) Validate() error {
+	var v utils.Validator
+	v.Required("name", req.Name)
+	v.Required("ip", req.IP)
+	v.IP("ip", req.IP)
+	v.MaxLength("country", req.Country, 64)
+	v.MaxLength("city", req.City, 64)
+	v.MaxLength("bandwidthClass", req.BandwidthClass, 32)
+	return v.Err()
+}
+
+// RegisterServerRequest represents a newly provisioned node registering
+// itself. Country and city are resolved from the IP unless given; the IP
+// is the endpoint's host, or the address the request came from when the
+// endpoint is a host name.
+type RegisterServerRequest struct {
+	Name           string `json:"name,omitempty"` // defaults to the endpoint host
+	PublicKey      string `json:"publicKey"`
+	Endpoint       string `json:"endpoint"` // host:port clients reach the node on
+	Capacity       int    `json:"capacity"`
+	Country        string `json:"country,omitempty"`
+	City           string `json:"city,omitempty"`
+	BandwidthClass string `json:"bandwidthClass,omitempty"` // one of balancing.bandwidthClasses
+}
+
+// Validate checks the fields of a server registration
+func (req *RegisterServerRequest) Validate() error {
+	var v utils.Validator
+	v.Required("publicKey", req.PublicKey)
+	v.Check(req.PublicKey == "" || wireguard.ValidKey(req.PublicKey), "publicKey", "must be a base64 encoded 32-byte WireGuard key")
+	v.Required("endpoint", req.Endpoint)
+	if req.Endpoint != "" {
+		host, port, err := net.SplitHostPort(req.Endpoint)
+		number, portErr := strconv.Atoi(port)
+		v.Check(err == nil && host != "" && portErr == nil && number > 0 && number < 65536, "endpoint", "must be host:port")
+	}
+	v.Check(req.Capacity > 0, "capacity", "must be positive")
+	v.MaxLength("name", req.Name, 128)
+	v.MaxLength("country", req.Country, 64)
+	v.MaxLength("city", req.City, 64)
+	v.MaxLength("bandwidthClass", req.BandwidthClass, 32)
+	return v.Err()
 }
 
 // ListServersHandler handles server listing requests
@@ -50,25 +99,26 @@ func GetServerHandler(w http.ResponseWriter, r *http.Request) {
 func CreateServerHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request
 	var req ServerRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	// Validate request
-	if err := validateServerRequest(req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
 	// Create server
 	server := &core.Server{
-		ID:       utils.GenerateUUID(),
-		Name:     req.Name,
-		Location: req.Location,
-		IP:       req.IP,
-		Status:   "offline",
-		Load:     0,
+		ID:             utils.GenerateUUID(),
+		Name:           req.Name,
+		Country:        req.Country,
+		City:           req.City,
+		IP:             req.IP,
+		Status:         "offline",
+		Load:           0,
+		BandwidthClass: req.BandwidthClass,
+	}
+
+	// Resolve location from IP
+	if err := ServerManager.LocateServer(server); err != nil {
+		utils.LogWarning("Failed to locate server %s: %v", server.IP, err)
 	}
 
 	// Add server
@@ -93,21 +143,20 @@ func UpdateServerHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request
 	var req ServerRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
-	// Validate request
-	if err := validateServerRequest(req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
-		return
-	}
-
-	// Update server
+	// Update server, resolving the location again unless it is given
 	server.Name = req.Name
-	server.Location = req.Location
+	server.Country = req.Country
+	server.City = req.City
 	server.IP = req.IP
+	server.BandwidthClass = req.BandwidthClass
+	if err := ServerManager.LocateServer(server); err != nil {
+		utils.LogWarning("Failed to locate server %s: %v", server.IP, err)
+	}
 
 	// Save server
 	if err := ServerManager.UpdateServer(server); err != nil {
@@ -126,7 +175,7 @@ func DeleteServerHandler(w http.ResponseWriter, r *http.Request) {
 	serverID := vars["id"]
 
 	// Delete server
-	if err := ServerManager.DeleteServer(serverID); err != nil {
+	if err := ServerManager.RemoveServer(serverID); err != nil {
 		utils.WriteErrorResponse(w, http.StatusNotFound, "Server not found")
 		return
 	}
@@ -143,8 +192,11 @@ func UpdateServerStatusHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get status from URL
 	status := vars["status"]
-	if status != "online" && status != "offline" && status != "maintenance" {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid status")
+	var v utils.Validator
+	v.Required("status", status)
+	v.OneOf("status", status, "online", "offline", "maintenance", "draining")
+	if err := v.Err(); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
@@ -158,22 +210,53 @@ func UpdateServerStatusHandler(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
 }
 
-// validateServerRequest validates a server request
-func validateServerRequest(req ServerRequest) error {
-	// Validate name
-	if strings.TrimSpace(req.Name) == "" {
-		return utils.NewError("name is required")
+// RegisterServerHandler handles registration requests from newly provisioned
+// nodes, which call it on every start with the registration token. The
+// response carries the server ID the node's agent runs as.
+func RegisterServerHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse request
+	var req RegisterServerRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
 	}
 
-	// Validate location
-	if strings.TrimSpace(req.Location) == "" {
-		return utils.NewError("location is required")
+	host, _, _ := net.SplitHostPort(req.Endpoint)
+	ip := host
+	if net.ParseIP(host) == nil {
+		ip = utils.ClientIP(r)
+	}
+	name := req.Name
+	if name == "" {
+		name = host
 	}
 
-	// Validate IP
-	if strings.TrimSpace(req.IP) == "" {
-		return utils.NewError("IP is required")
+	server := &core.Server{
+		Name:           name,
+		PublicKey:      req.PublicKey,
+		Endpoint:       req.Endpoint,
+		IP:             ip,
+		Capacity:       req.Capacity,
+		Country:        req.Country,
+		City:           req.City,
+		BandwidthClass: req.BandwidthClass,
 	}
 
-	return nil
+	// Resolve location from IP
+	if err := ServerManager.LocateServer(server); err != nil {
+		utils.LogWarning("Failed to locate server %s: %v", server.IP, err)
+	}
+
+	// Register server
+	server, created, err := ServerManager.RegisterServer(server)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to register server")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	utils.WriteJSONResponse(w, status, server)
 }
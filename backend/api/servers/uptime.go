@@ -0,0 +1,30 @@
+package servers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ListUptimeHandler handles requests for the uptime of every server over
+// the last 24 hours, 7 days and 30 days
+func ListUptimeHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, ServerManager.Uptime().ListUptime())
+}
+
+// GetUptimeHandler handles requests for the uptime of a server with its
+// status transitions of the last 30 days
+func GetUptimeHandler(w http.ResponseWriter, r *http.Request) {
+	// Get server ID from URL
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	uptime, err := ServerManager.Uptime().GetUptime(serverID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Server not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, uptime)
+}
@@ -0,0 +1,74 @@
+package status
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// StatusPage is the status page instance
+var StatusPage *core.StatusPage
+
+// Config is the status page configuration
+var Config config.StatusPageConfig
+
+//go:embed page.html
+var pageHTML string
+
+var page = template.Must(template.New("status").Parse(pageHTML))
+
+// labels are the headings of the public states on the HTML page
+var labels = map[string]string{
+	core.StatusOperational: "All systems operational",
+	core.StatusMaintenance: "Scheduled maintenance",
+	core.StatusDegraded:    "Degraded performance",
+	core.StatusOutage:      "Service outage",
+}
+
+// GetStatusHandler handles requests for the public status of the service,
+// for status widgets such as one on the marketing site
+func GetStatusHandler(w http.ResponseWriter, r *http.Request) {
+	setCacheControl(w)
+	utils.WriteJSONResponse(w, http.StatusOK, StatusPage.Status())
+}
+
+// PageHandler renders the public status page
+func PageHandler(w http.ResponseWriter, r *http.Request) {
+	title := Config.Title
+	if title == "" {
+		title = "Service Status"
+	}
+
+	var buf bytes.Buffer
+	data := struct {
+		Title  string
+		Status *core.ServiceStatus
+		Labels map[string]string
+	}{title, StatusPage.Status(), labels}
+	if err := page.Execute(&buf, data); err != nil {
+		utils.LogError("Failed to render status page: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	setCacheControl(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// setCacheControl lets browsers and CDNs cache the status for
+// statusPage.maxAge seconds, the same for every visitor
+func setCacheControl(w http.ResponseWriter) {
+	maxAge := Config.MaxAge
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+}
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// UserManager is the user manager instance
+var UserManager *core.UserManager
+
+// Entitlements is the plan entitlement manager instance
+var Entitlements *core.EntitlementManager
+
+// RegisterRoutes registers the user routes
+func RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/defaults", GetDefaultsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/defaults", UpdateDefaultsHandler).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/privacy", GetPrivacyHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/privacy", UpdatePrivacyHandler).Methods("PATCH", "OPTIONS")
+	router.HandleFunc("/plan", GetPlanHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/push-devices", ListPushDevicesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/push-devices", RegisterPushDeviceHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/push-devices/{id}", UnregisterPushDeviceHandler).Methods("DELETE", "OPTIONS")
+}
+
+// GetDefaultsHandler returns the account-level defaults applied to new devices
+func GetDefaultsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Get defaults
+	defaults, err := UserManager.GetDeviceDefaults(r.Context(), userID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get device defaults")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, defaults)
+}
+
+// UpdateDefaultsHandler replaces the account-level defaults applied to new devices
+func UpdateDefaultsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Parse request
+	var req models.DeviceDefaults
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Update defaults
+	defaults, err := UserManager.UpdateDeviceDefaults(userID, req)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, defaults)
+}
+
+// PrivacyRequest represents a privacy settings update request
+type PrivacyRequest struct {
+	TelemetryEnabled *bool `json:"telemetryEnabled"` // null reverts to the deployment default
+}
+
+// GetPrivacyHandler returns the user's privacy settings
+func GetPrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	utils.WriteJSONResponse(w, http.StatusOK, UserManager.GetPrivacySettings(userID))
+}
+
+// UpdatePrivacyHandler updates the user's privacy settings
+func UpdatePrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Parse request
+	var req PrivacyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Update settings
+	settings, err := UserManager.UpdateTelemetryPreference(userID, req.TelemetryEnabled)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update privacy settings")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, settings)
+}
+
+// PlanResponse represents the user's plan and what it allows
+type PlanResponse struct {
+	ID           string              `json:"id"`
+	Name         string              `json:"name"`
+	Entitlements models.Entitlements `json:"entitlements"`
+}
+
+// GetPlanHandler returns the user's plan and entitlements
+func GetPlanHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Get plan
+	plan := Entitlements.GetUserPlan(r.Context(), userID)
+	if plan == nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Plan not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, PlanResponse{
+		ID:           plan.ID,
+		Name:         plan.Name,
+		Entitlements: plan.Entitlements,
+	})
+}
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/push"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Push is the mobile push notifier instance
+var Push *core.PushNotifier
+
+// PushDeviceRequest represents a push device registration request
+type PushDeviceRequest struct {
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+	Name     string `json:"name"`
+}
+
+// Validate checks the fields of a push device registration request
+func (req *PushDeviceRequest) Validate() error {
+	var v utils.Validator
+	v.Required("platform", req.Platform)
+	v.OneOf("platform", req.Platform, push.PlatformFCM, push.PlatformAPNs)
+	v.Required("token", req.Token)
+	v.MaxLength("token", req.Token, 4096)
+	v.MaxLength("name", req.Name, 100)
+	return v.Err()
+}
+
+// ListPushDevicesHandler returns the devices the user registered for push
+// notifications
+func ListPushDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Get devices
+	devices, err := Push.ListDevices(userID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get push devices")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, devices)
+}
+
+// RegisterPushDeviceHandler registers an FCM or APNs device token so the
+// device is notified of remote disconnects, new devices on the account and
+// a nearly used up data cap
+func RegisterPushDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Parse request
+	var req PushDeviceRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Register device
+	device, err := Push.RegisterDevice(userID, req.Platform, req.Token, req.Name)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, device)
+}
+
+// UnregisterPushDeviceHandler stops notifications to one of the user's devices
+func UnregisterPushDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Get device ID from URL
+	vars := mux.Vars(r)
+	deviceID := vars["id"]
+
+	// Unregister device
+	if err := Push.UnregisterDevice(userID, deviceID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Push device not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "success"})
+}
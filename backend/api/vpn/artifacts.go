@@ -0,0 +1,98 @@
+package vpn
+
+import (
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/storage"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// StoreArtifactsHandler stores the config, QR code and setup sheet of a
+// device and returns time-limited links to download them
+func StoreArtifactsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// The artifacts carry the device's keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["id"]
+
+	// Store artifacts
+	artifacts, err := VPNManager.StoreArtifacts(r.Context(), userID, peerID)
+	if err != nil {
+		writeOperationError(w, r, err, "Failed to store artifacts")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, artifacts)
+}
+
+// StoreArchiveHandler stores a zip archive of the configs of all of the
+// user's devices and returns a time-limited link to download it
+func StoreArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// The archive carries the keys of every device
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	// Store archive
+	archive, err := VPNManager.StoreArchive(r.Context(), userID)
+	if err != nil {
+		writeOperationError(w, r, err, "Failed to store archive")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, archive)
+}
+
+// ArtifactDownloadHandler serves an artifact of the local storage backend
+// behind a signed link, without logging in. S3 links point at the bucket
+// and never reach the API.
+func ArtifactDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	local, ok := VPNManager.Storage().(*storage.Local)
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Artifact not found")
+		return
+	}
+
+	// Get key from URL
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	query := r.URL.Query()
+	if err := local.Verify(key, query.Get("expires"), query.Get("signature")); err != nil {
+		utils.WriteErrorResponse(w, http.StatusForbidden, "Invalid or expired link")
+		return
+	}
+
+	data, contentType, err := local.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		// Removed by a lifecycle rule
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Artifact not found")
+		return
+	}
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to read artifact")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+path.Base(key)+"\"")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
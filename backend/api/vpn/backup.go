@@ -0,0 +1,106 @@
+package vpn
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// minBackupPassphraseLength is the minimum length of a backup passphrase.
+// The backup holds private keys, so it is longer than a password.
+const minBackupPassphraseLength = 12
+
+// BackupRequest represents a request for an encrypted device backup
+type BackupRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// Validate checks the fields of a backup request
+func (req *BackupRequest) Validate() error {
+	var v utils.Validator
+	v.Required("passphrase", req.Passphrase)
+	v.MinLength("passphrase", req.Passphrase, minBackupPassphraseLength)
+	return v.Err()
+}
+
+// RestoreRequest represents a request to restore the devices of a backup
+type RestoreRequest struct {
+	Passphrase string            `json:"passphrase"`
+	Backup     core.DeviceBackup `json:"backup"`
+	ServerID   string            `json:"serverId,omitempty"` // defaults to the server of each device
+}
+
+// Validate checks the fields of a restore request. The backup itself is
+// checked when it is decrypted.
+func (req *RestoreRequest) Validate() error {
+	var v utils.Validator
+	v.Required("passphrase", req.Passphrase)
+	v.Required("backup.ciphertext", req.Backup.Ciphertext)
+	return v.Err()
+}
+
+// ExportDevicesHandler returns the user's devices and their keys encrypted
+// with a key derived from the given passphrase
+func ExportDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	var req BackupRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Export devices
+	backup, err := VPNManager.ExportDevices(r.Context(), userID, req.Passphrase)
+	if err != nil {
+		writeOperationError(w, r, err, "Failed to export devices")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, backup)
+}
+
+// RestoreDevicesHandler registers the devices of an encrypted backup on the
+// user's account, on this or another deployment, and returns a per-device
+// summary
+func RestoreDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Restored devices get configs and keys like a connect
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	var req RestoreRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Restore devices
+	summary, err := VPNManager.RestoreDevices(r.Context(), userID, req.Passphrase, &req.Backup, req.ServerID)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			utils.RespondWithValidationError(w, err)
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, summary)
+}
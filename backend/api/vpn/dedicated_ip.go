@@ -0,0 +1,41 @@
+package vpn
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// DedicatedIP represents a dedicated address reserved to the user
+type DedicatedIP struct {
+	TunnelIP  string    `json:"tunnelIp"`
+	PublicIP  string    `json:"publicIp,omitempty"`
+	ServerID  string    `json:"serverId,omitempty"` // server the public address is on
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ListDedicatedIPsHandler returns the dedicated addresses reserved to the
+// user
+func ListDedicatedIPsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	reservations, err := VPNManager.IPReservations().List(r.Context(), userID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to list dedicated IPs")
+		return
+	}
+
+	addresses := make([]DedicatedIP, 0, len(reservations))
+	for _, reservation := range reservations {
+		addresses = append(addresses, DedicatedIP{
+			TunnelIP:  reservation.TunnelIP,
+			PublicIP:  reservation.PublicIP,
+			ServerID:  reservation.ServerID,
+			CreatedAt: reservation.CreatedAt,
+		})
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, addresses)
+}
@@ -0,0 +1,97 @@
+package vpn
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ExitRequest represents a request to pick a device's exit and internal
+// networks
+type ExitRequest struct {
+	ExitServer string   `json:"exitServer,omitempty"` // empty for the device's own server
+	Routes     []string `json:"routes,omitempty"`     // networks behind gateways
+}
+
+// Validate checks the fields of an exit request
+func (req *ExitRequest) Validate() error {
+	var v utils.Validator
+	v.MaxLength("exitServer", req.ExitServer, 64)
+	v.Check(len(req.Routes) <= 32, "routes", "must have at most 32 networks")
+	return v.Err()
+}
+
+// ExitResponse represents a device's exit with its new config
+type ExitResponse struct {
+	PeerID     string   `json:"peerId"`
+	ExitServer string   `json:"exitServer"`
+	Routes     []string `json:"routes"`
+	Config     string   `json:"config,omitempty"`
+}
+
+// GetPeerExitsHandler returns the exits and internal networks a device can
+// pick from
+func GetPeerExitsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["id"]
+
+	choices, err := VPNManager.PeerExits(r.Context(), userID, peerID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, choices)
+}
+
+// SetPeerExitHandler sets the server a device's internet traffic exits at
+// and the internal networks it routes to, and returns its updated config
+func SetPeerExitHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// The new config carries the device's keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["id"]
+
+	var req ExitRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Set exit
+	peer, config, err := VPNManager.SetPeerExit(r.Context(), userID, peerID, req.ExitServer, req.Routes)
+	if err != nil {
+		if _, ok := err.(*core.EntitlementError); ok {
+			utils.WriteErrorResponse(w, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	routes := peer.Routes
+	if routes == nil {
+		routes = []string{}
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, ExitResponse{
+		PeerID:     peer.ID,
+		ExitServer: peer.ExitServer,
+		Routes:     routes,
+		Config:     config,
+	})
+}
@@ -0,0 +1,36 @@
+package vpn
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// GetGatewaySetupHandler returns the router config of a gateway device with
+// the server side peer entry and routes of the networks behind it
+func GetGatewaySetupHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["id"]
+
+	setup, err := VPNManager.GatewaySetup(r.Context(), userID, peerID)
+	if err != nil {
+		writeOperationError(w, r, err, "Failed to get gateway setup")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	utils.WriteJSONResponse(w, http.StatusOK, setup)
+}
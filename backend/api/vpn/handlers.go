@@ -1,11 +1,19 @@
 package vpn
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/db/models"
 	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/monitoring"
 	"github.com/vpn-service/backend/src/utils"
 	"github.com/vpn-service/backend/vpn/wireguard"
 )
@@ -13,35 +21,130 @@ import (
 // VPNManager is the VPN manager instance
 var VPNManager *core.VPNManager
 
+// ServerListMaxAge is how long clients may use a server list before
+// revalidating it
+var ServerListMaxAge = 30 * time.Second
+
 // RegisterRoutes registers the VPN routes
 func RegisterRoutes(router *mux.Router) {
+	connectLimit := middleware.RateLimit("connect")
+	configLimit := middleware.RateLimit("config")
+
 	router.HandleFunc("/servers", GetServersHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/connect", ConnectHandler).Methods("POST", "OPTIONS")
-	router.HandleFunc("/disconnect", DisconnectHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/routing-presets", GetRoutingPresetsHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/dns-profiles", GetDNSProfilesHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/latency-matrix", GetLatencyMatrixHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/latency", ReportLatencyHandler).Methods("POST", "OPTIONS")
+	router.HandleFunc("/servers/recommended", GetRecommendedServersHandler).Methods("GET", "OPTIONS")
+	router.Handle("/connect", connectLimit(http.HandlerFunc(ConnectHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/disconnect", connectLimit(http.HandlerFunc(DisconnectHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/reactivate", connectLimit(http.HandlerFunc(ReactivateHandler))).Methods("POST", "OPTIONS")
 	router.HandleFunc("/status", StatusHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/config", GetConfigHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/qr", GetQRCodeHandler).Methods("GET", "OPTIONS")
+	router.HandleFunc("/ws", StatusStreamHandler).Methods("GET")
+	router.Handle("/config", configLimit(http.HandlerFunc(GetConfigHandler))).Methods("GET", "OPTIONS")
+	router.Handle("/qr", configLimit(http.HandlerFunc(GetQRCodeHandler))).Methods("GET", "OPTIONS")
+	router.Handle("/config/email", configLimit(http.HandlerFunc(EmailConfigHandler))).Methods("POST", "OPTIONS")
+	router.HandleFunc("/config/shares", ListConfigSharesHandler).Methods("GET", "OPTIONS")
+	router.Handle("/config/shares", configLimit(http.HandlerFunc(CreateConfigShareHandler))).Methods("POST", "OPTIONS")
+	router.HandleFunc("/config/shares/{id}", RevokeConfigShareHandler).Methods("DELETE", "OPTIONS")
+	router.Handle("/peers/{id}/setup.pdf", configLimit(http.HandlerFunc(GetSetupSheetHandler))).Methods("GET", "OPTIONS")
+	router.Handle("/peers/{id}/gateway", configLimit(http.HandlerFunc(GetGatewaySetupHandler))).Methods("GET", "OPTIONS")
+	router.Handle("/peers/{id}/routing", configLimit(http.HandlerFunc(SetPeerRoutingHandler))).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/peers/{id}/exit", GetPeerExitsHandler).Methods("GET", "OPTIONS")
+	router.Handle("/peers/{id}/exit", configLimit(http.HandlerFunc(SetPeerExitHandler))).Methods("PUT", "OPTIONS")
+	router.Handle("/peers/{id}/mtu", configLimit(http.HandlerFunc(SetPeerMTUHandler))).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/mtu/suggest", SuggestMTUHandler).Methods("POST", "OPTIONS")
+	router.Handle("/peers/{id}/keepalive", configLimit(http.HandlerFunc(SetPeerKeepaliveHandler))).Methods("PUT", "OPTIONS")
+	router.Handle("/peers/{id}/port-forward", configLimit(middleware.RequireFeature(models.FeaturePortForwarding)(http.HandlerFunc(SetPortForwardHandler)))).Methods("PUT", "OPTIONS")
+	router.Handle("/dedicated-ips", middleware.RequireFeature(models.FeatureDedicatedIP)(http.HandlerFunc(ListDedicatedIPsHandler))).Methods("GET", "OPTIONS")
+	router.Handle("/backup", configLimit(http.HandlerFunc(ExportDevicesHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/peers/{id}/artifacts", configLimit(http.HandlerFunc(StoreArtifactsHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/archive", configLimit(http.HandlerFunc(StoreArchiveHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/restore", connectLimit(http.HandlerFunc(RestoreDevicesHandler))).Methods("POST", "OPTIONS")
 	
 	// Dynamic peer management
-	router.HandleFunc("/dynamic/connect", DynamicConnectHandler).Methods("POST", "OPTIONS")
-	router.HandleFunc("/dynamic/disconnect", DynamicDisconnectHandler).Methods("POST", "OPTIONS")
+	router.Handle("/dynamic/connect", connectLimit(http.HandlerFunc(DynamicConnectHandler))).Methods("POST", "OPTIONS")
+	router.Handle("/dynamic/disconnect", connectLimit(http.HandlerFunc(DynamicDisconnectHandler))).Methods("POST", "OPTIONS")
+}
+
+// RegisterPublicRoutes registers the VPN routes that need no login
+func RegisterPublicRoutes(router *mux.Router) {
+	configLimit := middleware.RateLimit("config")
+
+	router.Handle("/config/shared/{token}", configLimit(http.HandlerFunc(SharedConfigHandler))).Methods("GET")
+	router.Handle("/artifacts/{key:.+}", configLimit(http.HandlerFunc(ArtifactDownloadHandler))).Methods("GET")
 }
 
 // Server represents a VPN server
 type Server struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Location string `json:"location"`
-	IP       string `json:"ip"`
-	Status   string `json:"status"`
-	Load     int    `json:"load"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Location    string `json:"location"`
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode,omitempty"`
+	City        string `json:"city"`
+	Region      string `json:"region,omitempty"` // continent
+	IP          string `json:"ip"`
+	Status      string `json:"status"`
+	Load        int    `json:"load"`
+	Capacity    int    `json:"capacity"`
+	Obfuscation string `json:"obfuscation,omitempty"` // transport of the server's obfuscation endpoint
+
+	// RTT is the round trip in milliseconds clients in the caller's region
+	// reported to the server, when known
+	RTT *float64 `json:"rtt,omitempty"`
 }
 
 // ConnectRequest represents a VPN connection request
 type ConnectRequest struct {
-	ServerID   string `json:"serverId"`
-	DeviceType string `json:"deviceType"`
-	DeviceName string `json:"deviceName"`
+	ServerID      string   `json:"serverId"`
+	DeviceType    string   `json:"deviceType"`
+	DeviceName    string   `json:"deviceName"`
+	RoutingPreset string   `json:"routingPreset,omitempty"` // routes the preset's networks instead of the server default
+	DNS           []string `json:"dns,omitempty"`           // DNS servers to use instead of the account default
+	DNSProfile    string   `json:"dnsProfile,omitempty"`    // named DNS profile to use instead of the account default
+	Email         bool     `json:"email,omitempty"`         // emails the config and QR code instead of returning them
+	PublicKey     string   `json:"publicKey,omitempty"`     // key generated on the device; unknown keys need admin approval
+
+	// LeakProtection adds rules against DNS queries bypassing the tunnel
+	// to the config, for the platform of the device
+	LeakProtection bool `json:"leakProtection,omitempty"`
+
+	// MTU overrides the default of the network type, which is assumed
+	// from the device type unless networkType is set
+	MTU         int    `json:"mtu,omitempty"`
+	NetworkType string `json:"networkType,omitempty"`
+
+	// Keepalive is the persistent keepalive in seconds, 0 for off; unset
+	// keeps the account default
+	Keepalive *int `json:"keepalive,omitempty"`
+
+	// Obfuscated carries WireGuard over the server's TCP or WebSocket
+	// obfuscation endpoint, for networks that block UDP
+	Obfuscated bool `json:"obfuscated,omitempty"`
+
+	// RoutedSubnets are the networks behind a gateway device, which must
+	// connect with deviceType gateway
+	RoutedSubnets []string `json:"routedSubnets,omitempty"`
+}
+
+// Validate checks the fields of a connection request
+func (req *ConnectRequest) Validate() error {
+	var v utils.Validator
+	v.Required("serverId", req.ServerID)
+	core.ValidateDevice(&v, req.DeviceType, req.DeviceName)
+	v.MaxLength("routingPreset", req.RoutingPreset, 64)
+	v.MaxLength("dnsProfile", req.DNSProfile, 64)
+	v.Check(len(req.DNS) == 0 || req.DNSProfile == "", "dnsProfile", "cannot be combined with dns")
+	if err := core.ValidateDNSServers(req.DNS); err != nil {
+		v.Check(false, "dns", err.Error())
+	}
+	if req.PublicKey != "" {
+		v.Check(wireguard.ValidKey(req.PublicKey), "publicKey", "must be a base64 encoded WireGuard public key")
+	}
+	validateMTU(&v, req.MTU, req.NetworkType)
+	validateKeepalive(&v, req.Keepalive)
+	return v.Err()
 }
 
 // DisconnectRequest represents a VPN disconnection request
@@ -49,12 +152,53 @@ type DisconnectRequest struct {
 	PeerID string `json:"peerId"`
 }
 
+// Validate checks the fields of a disconnection request
+func (req *DisconnectRequest) Validate() error {
+	var v utils.Validator
+	v.Required("peerId", req.PeerID)
+	return v.Err()
+}
+
+// ReactivateRequest represents a request to reactivate an archived peer
+type ReactivateRequest struct {
+	PeerID string `json:"peerId"`
+}
+
+// Validate checks the fields of a reactivation request
+func (req *ReactivateRequest) Validate() error {
+	var v utils.Validator
+	v.Required("peerId", req.PeerID)
+	return v.Err()
+}
+
+// EmailConfigRequest represents a request to email a peer's configuration
+type EmailConfigRequest struct {
+	PeerID string `json:"peerId"`
+}
+
+// Validate checks the fields of an email configuration request
+func (req *EmailConfigRequest) Validate() error {
+	var v utils.Validator
+	v.Required("peerId", req.PeerID)
+	return v.Err()
+}
+
 // ConnectResponse represents a VPN connection response
 type ConnectResponse struct {
-	Config    string `json:"config"`
-	QRCode    string `json:"qrCode,omitempty"`
-	PeerID    string `json:"peerId"`
-	ServerIP  string `json:"serverIp"`
+	Config          string     `json:"config,omitempty"` // empty when emailed or pending approval
+	Emailed         bool       `json:"emailed,omitempty"`
+	PendingApproval bool       `json:"pendingApproval,omitempty"` // the device key awaits admin approval
+	QRCode          string     `json:"qrCode,omitempty"`
+	PeerID          string     `json:"peerId"`
+	ServerID        string     `json:"serverId"`             // server actually used
+	FailedOver      bool       `json:"failedOver,omitempty"` // true when the requested server failed
+	ServerIP        string     `json:"serverIp"`
+	SessionID       string     `json:"sessionId,omitempty"`
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+
+	// Obfuscation tells obfuscated devices how to run the client wrapper
+	// their config's endpoint points at
+	Obfuscation *wireguard.ObfuscationEndpoint `json:"obfuscation,omitempty"`
 }
 
 // StatusResponse represents a VPN status response
@@ -63,44 +207,103 @@ type StatusResponse struct {
 	Peers     []*wireguard.PeerInfo `json:"peers"`
 }
 
-// GetServersHandler returns a list of available VPN servers
+// GetServersHandler handles server listing requests. Query parameters
+// filter the list by country, region, status and features (comma
+// separated) and sort it by name, load or latency.
 func GetServersHandler(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from context
-	userID := r.Context().Value("userID").(string)
+	query := r.URL.Query()
+	filter := core.ServerFilter{
+		Country: query.Get("country"),
+		Region:  query.Get("region"),
+		Status:  query.Get("status"),
+		Sort:    query.Get("sort"),
+	}
+	for _, features := range query["features"] {
+		for _, feature := range strings.Split(features, ",") {
+			if feature = strings.TrimSpace(feature); feature != "" {
+				filter.Features = append(filter.Features, feature)
+			}
+		}
+	}
+	if err := filter.Validate(); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Clients revalidate their copy with its tag, which changes with the
+	// server set, so unchanged lists are not built or sent again
+	ip := utils.ClientIP(r)
+	tag := `"` + VPNManager.ServerListTag(filter, ip) + `"`
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(ServerListMaxAge.Seconds())))
+	if utils.ETagMatches(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
 	// Get servers from server manager
-	coreServers := VPNManager.GetServers()
-	
+	coreServers, latencies := VPNManager.ListServers(filter, ip)
+
 	// Convert to API response format
 	servers := make([]Server, len(coreServers))
 	for i, server := range coreServers {
 		servers[i] = Server{
-			ID:       server.ID,
-			Name:     server.Name,
-			Location: server.Location,
-			IP:       server.IP,
-			Status:   server.Status,
-			Load:     server.Load,
+			ID:          server.ID,
+			Name:        server.Name,
+			Location:    serverLocation(server),
+			Country:     server.Country,
+			CountryCode: server.CountryCode,
+			City:        server.City,
+			Region:      server.Region,
+			IP:          server.IP,
+			Status:      server.Status,
+			Load:        server.Load,
+			Capacity:    server.Capacity,
+			Obfuscation: server.Capabilities.Obfuscation,
+		}
+		if latency, ok := latencies[server.ID]; ok {
+			rtt := latency.RTT
+			servers[i].RTT = &rtt
 		}
 	}
 
 	utils.WriteJSONResponse(w, http.StatusOK, servers)
 }
 
+// GetRoutingPresetsHandler returns the routing presets users can pick at
+// connect time
+func GetRoutingPresetsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, VPNManager.RoutingPresets().ListPresets())
+}
+
+// GetDNSProfilesHandler returns the DNS profiles users can pick at connect
+// time
+func GetDNSProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, VPNManager.DNSProfiles())
+}
+
+// GetLatencyMatrixHandler returns the latencies node agents measured from
+// the online servers to the reference probes, optionally only of servers
+// in a country, for clients to combine with their own measurements
+func GetLatencyMatrixHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, VPNManager.LatencyMatrix(r.URL.Query().Get("country")))
+}
+
 // ConnectHandler handles VPN connection requests
 func ConnectHandler(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID := r.Context().Value("userID").(string)
 
-	var req ConnectRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request payload")
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
 		return
 	}
 
-	// Validate request
-	if req.ServerID == "" {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Server ID is required")
+	var req ConnectRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
@@ -116,10 +319,59 @@ func ConnectHandler(w http.ResponseWriter, r *http.Request) {
 		deviceName = deviceType
 	}
 
+	// Check the routing preset exists
+	if req.RoutingPreset != "" {
+		if _, err := VPNManager.RoutingPresets().GetPreset(req.RoutingPreset); err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Unknown routing preset: "+req.RoutingPreset)
+			return
+		}
+	}
+
+	// Check the DNS profile exists
+	if req.DNSProfile != "" {
+		if _, err := VPNManager.GetDNSProfile(req.DNSProfile); err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Unknown DNS profile: "+req.DNSProfile)
+			return
+		}
+	}
+
 	// Connect to VPN
-	peer, config, err := VPNManager.Connect(userID, req.ServerID, deviceType, deviceName)
+	peer, config, err := VPNManager.Connect(r.Context(), userID, req.ServerID, deviceType, deviceName, req.RoutingPreset, req.PublicKey, core.DNSChoice{Servers: req.DNS, Profile: req.DNSProfile, LeakProtection: req.LeakProtection}, core.TunnelChoice{MTU: req.MTU, NetworkType: req.NetworkType, Keepalive: req.Keepalive, Obfuscated: req.Obfuscated, RoutedSubnets: req.RoutedSubnets})
+	RecordConnect(r.Context(), err)
 	if err != nil {
-		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to connect to VPN: "+err.Error())
+		writeOperationError(w, r, err, "Failed to connect to VPN")
+		return
+	}
+
+	// Unknown device keys are held until an admin approves them; the
+	// config can be downloaded afterwards
+	if peer.Pending() {
+		utils.WriteJSONResponse(w, http.StatusAccepted, ConnectResponse{
+			PendingApproval: true,
+			PeerID:          peer.ID,
+			ServerID:        peer.ServerID,
+			ServerIP:        peer.ServerIP,
+		})
+		return
+	}
+	failedOver := RecordFailover(req.ServerID, peer.ServerID)
+
+	// Email the configuration instead of returning it, for setting up
+	// another device
+	if req.Email {
+		if err := VPNManager.SendConfig(r.Context(), userID, peer, config); err != nil {
+			utils.LogErrorContext(r.Context(), "Failed to email config for peer %s: %v", peer.ID, err)
+			utils.WriteErrorResponse(w, http.StatusBadGateway, "Connected, but failed to email the configuration; retry with /api/vpn/config/email")
+			return
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, ConnectResponse{
+			Emailed:    true,
+			PeerID:     peer.ID,
+			ServerID:   peer.ServerID,
+			FailedOver: failedOver,
+			ServerIP:   peer.ServerIP,
+		})
 		return
 	}
 
@@ -135,10 +387,13 @@ func ConnectHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Respond with configuration
 	utils.WriteJSONResponse(w, http.StatusOK, ConnectResponse{
-		Config:   config,
-		QRCode:   qrCode,
-		PeerID:   peer.ID,
-		ServerIP: peer.ServerIP,
+		Config:      config,
+		QRCode:      qrCode,
+		PeerID:      peer.ID,
+		ServerID:    peer.ServerID,
+		FailedOver:  failedOver,
+		ServerIP:    peer.ServerIP,
+		Obfuscation: VPNManager.Obfuscation(peer),
 	})
 }
 
@@ -148,24 +403,64 @@ func DisconnectHandler(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("userID").(string)
 
 	var req DisconnectRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request payload")
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
-	// Validate request
-	if req.PeerID == "" {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Peer ID is required")
+	// Disconnect from VPN
+	if err := VPNManager.Disconnect(r.Context(), userID, req.PeerID); err != nil {
+		writeOperationError(w, r, err, "Failed to disconnect from VPN")
 		return
 	}
 
-	// Disconnect from VPN
-	if err := VPNManager.Disconnect(userID, req.PeerID); err != nil {
-		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to disconnect from VPN: "+err.Error())
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "disconnected"})
+}
+
+// ReactivateHandler restores a device archived for inactivity and returns
+// its configuration, which has a new address
+func ReactivateHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
 		return
 	}
 
-	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "disconnected"})
+	var req ReactivateRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Reactivate peer
+	peer, config, err := VPNManager.ReactivatePeer(r.Context(), userID, req.PeerID)
+	if err != nil {
+		writeOperationError(w, r, err, "Failed to reactivate device")
+		return
+	}
+
+	// Generate QR code for mobile devices
+	var qrCode string
+	if peer.DeviceType == "android" || peer.DeviceType == "ios" {
+		qrCode, err = wireguard.GenerateQRCode(config)
+		if err != nil {
+			// Non-fatal error, continue without QR code
+			utils.LogError("Failed to generate QR code: %v", err)
+		}
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, ConnectResponse{
+		Config:      config,
+		QRCode:      qrCode,
+		PeerID:      peer.ID,
+		ServerID:    peer.ServerID,
+		ServerIP:    peer.ServerIP,
+		Obfuscation: VPNManager.Obfuscation(peer),
+	})
 }
 
 // StatusHandler returns the current VPN connection status
@@ -174,9 +469,9 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("userID").(string)
 
 	// Get connection status
-	peers, err := VPNManager.GetStatus(userID)
+	peers, err := VPNManager.GetStatus(r.Context(), userID)
 	if err != nil {
-		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get connection status: "+err.Error())
+		writeOperationError(w, r, err, "Failed to get connection status")
 		return
 	}
 
@@ -194,17 +489,26 @@ func GetConfigHandler(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID := r.Context().Value("userID").(string)
 
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
 	// Get peer ID from query
 	peerID := r.URL.Query().Get("peerId")
-	if peerID == "" {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Peer ID is required")
+	var v utils.Validator
+	v.Required("peerId", peerID)
+	if err := v.Err(); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
 	// Get configuration
-	config, err := VPNManager.GetConfig(userID, peerID)
+	config, err := VPNManager.GetConfig(r.Context(), userID, peerID)
 	if err != nil {
-		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get configuration: "+err.Error())
+		writeOperationError(w, r, err, "Failed to get configuration")
 		return
 	}
 
@@ -220,22 +524,42 @@ func GetQRCodeHandler(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID := r.Context().Value("userID").(string)
 
-	// Get peer ID from query
-	peerID := r.URL.Query().Get("peerId")
-	if peerID == "" {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Peer ID is required")
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	// Get peer ID and QR code options from query
+	query := r.URL.Query()
+	peerID := query.Get("peerId")
+	opts := wireguard.QROptions{Level: query.Get("level"), Format: wireguard.QRFormatPNG}
+	var v utils.Validator
+	v.Required("peerId", peerID)
+	if value := query.Get("size"); value != "" {
+		size, err := strconv.Atoi(value)
+		v.Check(err == nil && size >= wireguard.MinQRSize && size <= wireguard.MaxQRSize, "size",
+			fmt.Sprintf("must be between %d and %d", wireguard.MinQRSize, wireguard.MaxQRSize))
+		opts.Size = size
+	}
+	if opts.Level != "" {
+		v.OneOf("level", opts.Level, "low", "medium", "high", "highest")
+	}
+	if err := v.Err(); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
 	// Get configuration
-	config, err := VPNManager.GetConfig(userID, peerID)
+	config, err := VPNManager.GetConfig(r.Context(), userID, peerID)
 	if err != nil {
-		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get configuration: "+err.Error())
+		writeOperationError(w, r, err, "Failed to get configuration")
 		return
 	}
 
-	// Generate QR code
-	qrCode, err := wireguard.GenerateQRCode(config)
+	// Generate QR code as raw PNG bytes
+	qrCode, err := wireguard.EncodeQRCode(config, opts)
 	if err != nil {
 		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to generate QR code: "+err.Error())
 		return
@@ -244,7 +568,68 @@ func GetQRCodeHandler(w http.ResponseWriter, r *http.Request) {
 	// Set content type
 	w.Header().Set("Content-Type", "image/png")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(qrCode))
+	w.Write(qrCode)
+}
+
+// GetSetupSheetHandler returns a printable PDF for setting up a device,
+// with its config as a QR code, a config summary without keys and the
+// setup steps of its platform
+func GetSetupSheetHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["id"]
+
+	// Render setup sheet
+	pdf, err := VPNManager.GetSetupSheet(r.Context(), userID, peerID)
+	if err != nil {
+		writeOperationError(w, r, err, "Failed to generate setup sheet")
+		return
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"setup.pdf\"")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(pdf)
+}
+
+// EmailConfigHandler emails the WireGuard configuration and QR code for a
+// peer to the user's address
+func EmailConfigHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	var req EmailConfigRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Email configuration
+	if err := VPNManager.EmailConfig(r.Context(), userID, req.PeerID); err != nil {
+		writeOperationError(w, r, err, "Failed to email configuration")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "sent"})
 }
 
 // DynamicConnectHandler handles dynamic VPN connection requests
@@ -252,15 +637,16 @@ func DynamicConnectHandler(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID := r.Context().Value("userID").(string)
 
-	var req ConnectRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request payload")
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
 		return
 	}
 
-	// Validate request
-	if req.ServerID == "" {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Server ID is required")
+	var req ConnectRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
@@ -276,10 +662,27 @@ func DynamicConnectHandler(w http.ResponseWriter, r *http.Request) {
 		deviceName = deviceType
 	}
 
+	// Check the routing preset exists
+	if req.RoutingPreset != "" {
+		if _, err := VPNManager.RoutingPresets().GetPreset(req.RoutingPreset); err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Unknown routing preset: "+req.RoutingPreset)
+			return
+		}
+	}
+
+	// Check the DNS profile exists
+	if req.DNSProfile != "" {
+		if _, err := VPNManager.GetDNSProfile(req.DNSProfile); err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Unknown DNS profile: "+req.DNSProfile)
+			return
+		}
+	}
+
 	// Connect to VPN
-	peer, config, err := VPNManager.DynamicConnect(userID, req.ServerID, deviceType, deviceName)
+	peer, config, err := VPNManager.DynamicConnect(r.Context(), userID, req.ServerID, deviceType, deviceName, req.RoutingPreset, core.DNSChoice{Servers: req.DNS, Profile: req.DNSProfile, LeakProtection: req.LeakProtection}, core.TunnelChoice{MTU: req.MTU, NetworkType: req.NetworkType, Keepalive: req.Keepalive, Obfuscated: req.Obfuscated, RoutedSubnets: req.RoutedSubnets})
+	RecordConnect(r.Context(), err)
 	if err != nil {
-		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to connect to VPN: "+err.Error())
+		writeOperationError(w, r, err, "Failed to connect to VPN")
 		return
 	}
 
@@ -295,33 +698,92 @@ func DynamicConnectHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Respond with configuration
 	utils.WriteJSONResponse(w, http.StatusOK, ConnectResponse{
-		Config:   config,
-		QRCode:   qrCode,
-		PeerID:   peer.ID,
-		ServerIP: peer.ServerIP,
+		Config:      config,
+		QRCode:      qrCode,
+		PeerID:      peer.ID,
+		ServerID:    peer.ServerID,
+		FailedOver:  RecordFailover(req.ServerID, peer.ServerID),
+		ServerIP:    peer.ServerIP,
+		SessionID:   peer.SessionID,
+		ExpiresAt:   &peer.ExpiresAt,
+		Obfuscation: VPNManager.Obfuscation(peer),
 	})
 }
 
+// serverLocation formats the location of a server for display
+func serverLocation(server *core.Server) string {
+	if server.City == "" {
+		return server.Country
+	}
+	if server.Country == "" {
+		return server.City
+	}
+	return server.City + ", " + server.Country
+}
+
+// writeOperationError responds to a failed VPN operation. Operations that ran
+// past their deadline get a 504; nothing is written if the client went away.
+func writeOperationError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if _, ok := err.(*core.EntitlementError); ok {
+		utils.WriteErrorResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	switch {
+	case errors.Is(r.Context().Err(), context.Canceled):
+		utils.LogWarningContext(r.Context(), "Client disconnected: %s: %v", message, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		utils.WriteErrorResponse(w, http.StatusGatewayTimeout, message+": operation timed out")
+	default:
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, message+": "+err.Error())
+	}
+}
+
+// RecordConnect records the outcome of a connect for the connect error
+// rate. Connects refused by the user's plan or abandoned by the client do
+// not count.
+func RecordConnect(ctx context.Context, err error) {
+	if monitoring.MetricsCollector == nil {
+		return
+	}
+	if _, ok := err.(*core.EntitlementError); ok {
+		return
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+
+	monitoring.MetricsCollector.ObserveConnect(err != nil)
+}
+
+// RecordFailover records a connect that ended up on another server than
+// requested and reports whether that happened
+func RecordFailover(requestedServerID, usedServerID string) bool {
+	if requestedServerID == usedServerID {
+		return false
+	}
+
+	if monitoring.MetricsCollector != nil {
+		monitoring.MetricsCollector.IncrementConnectFailovers(requestedServerID, usedServerID)
+	}
+
+	return true
+}
+
 // DynamicDisconnectHandler handles dynamic VPN disconnection requests
 func DynamicDisconnectHandler(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID := r.Context().Value("userID").(string)
 
 	var req DisconnectRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	// Validate request
-	if req.PeerID == "" {
-		utils.WriteErrorResponse(w, http.StatusBadRequest, "Peer ID is required")
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
 		return
 	}
 
 	// Disconnect from VPN
-	if err := VPNManager.DynamicDisconnect(userID, req.PeerID); err != nil {
-		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to disconnect from VPN: "+err.Error())
+	if err := VPNManager.DynamicDisconnect(r.Context(), userID, req.PeerID); err != nil {
+		writeOperationError(w, r, err, "Failed to disconnect from VPN")
 		return
 	}
 
@@ -0,0 +1,79 @@
+package vpn
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// KeepaliveRequest represents a request to set the persistent keepalive of
+// a device
+type KeepaliveRequest struct {
+	Keepalive *int `json:"keepalive"` // in seconds, 0 for off; null for the server default
+}
+
+// Validate checks the fields of a keepalive request
+func (req *KeepaliveRequest) Validate() error {
+	var v utils.Validator
+	validateKeepalive(&v, req.Keepalive)
+	return v.Err()
+}
+
+// KeepaliveResponse represents a device's persistent keepalive with its new
+// config
+type KeepaliveResponse struct {
+	PeerID    string `json:"peerId"`
+	Keepalive int    `json:"keepalive"` // in the config, 0 when off
+	Override  bool   `json:"override"`  // set for the device rather than defaulted
+	Config    string `json:"config,omitempty"`
+}
+
+// validateKeepalive checks a device keepalive, if set
+func validateKeepalive(v *utils.Validator, keepalive *int) {
+	if keepalive == nil {
+		return
+	}
+	v.Check(*keepalive == 0 || (*keepalive >= wireguard.MinKeepalive && *keepalive <= wireguard.MaxKeepalive), "keepalive",
+		fmt.Sprintf("must be 0 (off) or between %d and %d", wireguard.MinKeepalive, wireguard.MaxKeepalive))
+}
+
+// SetPeerKeepaliveHandler sets the persistent keepalive of a device and
+// returns its updated config
+func SetPeerKeepaliveHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// The new config carries the device's keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["id"]
+
+	var req KeepaliveRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Set keepalive
+	peer, config, err := VPNManager.SetPeerKeepalive(r.Context(), userID, peerID, req.Keepalive)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, KeepaliveResponse{
+		PeerID:    peer.ID,
+		Keepalive: VPNManager.PeerKeepalive(peer),
+		Override:  peer.Keepalive != 0,
+		Config:    config,
+	})
+}
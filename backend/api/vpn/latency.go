@@ -0,0 +1,49 @@
+package vpn
+
+import (
+	"net/http"
+
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// LatencyReportRequest represents the pings a client measured to servers
+type LatencyReportRequest struct {
+	Results []core.ClientLatency `json:"results"`
+}
+
+// Validate checks the fields of a latency report
+func (req *LatencyReportRequest) Validate() error {
+	var v utils.Validator
+	core.ValidateClientLatencies(&v, req.Results)
+	return v.Err()
+}
+
+// LatencyReportResponse represents the result of a latency report
+type LatencyReportResponse struct {
+	Region   string `json:"region,omitempty"` // country code the pings were recorded for, empty when unknown
+	Recorded int    `json:"recorded"`         // results of known servers
+}
+
+// ReportLatencyHandler handles the pings clients report to servers, which
+// server recommendations weigh for the client's region
+func ReportLatencyHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	var req LatencyReportRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	region, recorded := VPNManager.RecordClientLatency(userID, utils.ClientIP(r), req.Results)
+
+	utils.WriteJSONResponse(w, http.StatusOK, LatencyReportResponse{Region: region, Recorded: recorded})
+}
+
+// GetRecommendedServersHandler returns the online servers with room,
+// optionally only in a country, best first for the client's region
+func GetRecommendedServersHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, VPNManager.RecommendServers(utils.ClientIP(r), r.URL.Query().Get("country")))
+}
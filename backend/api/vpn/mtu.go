@@ -0,0 +1,102 @@
+package vpn
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// MTURequest represents a request to set the MTU of a device
+type MTURequest struct {
+	MTU         int    `json:"mtu"`                   // zero for the default of the network type
+	NetworkType string `json:"networkType,omitempty"` // cellular, wifi or ethernet; empty to assume from the device type
+}
+
+// Validate checks the fields of an MTU request
+func (req *MTURequest) Validate() error {
+	var v utils.Validator
+	validateMTU(&v, req.MTU, req.NetworkType)
+	return v.Err()
+}
+
+// MTUResponse represents a device's MTU with its new config
+type MTUResponse struct {
+	PeerID      string `json:"peerId"`
+	MTU         int    `json:"mtu"`      // MTU in the config
+	Override    bool   `json:"override"` // set for the device rather than defaulted
+	NetworkType string `json:"networkType,omitempty"`
+	Config      string `json:"config,omitempty"`
+}
+
+// MTUSuggestRequest represents a client's path MTU probe result
+type MTUSuggestRequest struct {
+	PathMTU int  `json:"pathMtu"` // largest packet that reached the server unfragmented
+	IPv6    bool `json:"ipv6,omitempty"`
+}
+
+// Validate checks the fields of an MTU suggestion request
+func (req *MTUSuggestRequest) Validate() error {
+	var v utils.Validator
+	v.Check(req.PathMTU >= 576 && req.PathMTU <= 65535, "pathMtu", "must be between 576 and 65535")
+	return v.Err()
+}
+
+// validateMTU checks a device MTU and network type
+func validateMTU(v *utils.Validator, mtu int, networkType string) {
+	v.Check(mtu == 0 || (mtu >= wireguard.MinMTU && mtu <= wireguard.MaxMTU), "mtu", fmt.Sprintf("must be between %d and %d", wireguard.MinMTU, wireguard.MaxMTU))
+	v.OneOf("networkType", networkType, wireguard.NetworkTypes()...)
+}
+
+// SetPeerMTUHandler sets the MTU of a device and returns its updated config
+func SetPeerMTUHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// The new config carries the device's keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["id"]
+
+	var req MTURequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Set MTU
+	peer, config, err := VPNManager.SetPeerMTU(r.Context(), userID, peerID, req.MTU, req.NetworkType)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, MTUResponse{
+		PeerID:      peer.ID,
+		MTU:         VPNManager.PeerMTU(peer),
+		Override:    peer.MTU > 0,
+		NetworkType: peer.NetworkType,
+		Config:      config,
+	})
+}
+
+// SuggestMTUHandler suggests a device MTU from the path MTU the client
+// measured to its server
+func SuggestMTUHandler(w http.ResponseWriter, r *http.Request) {
+	var req MTUSuggestRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, core.SuggestMTU(req.PathMTU, req.IPv6))
+}
@@ -0,0 +1,60 @@
+package vpn
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// PortForwardRequest represents a request to forward a port to a device
+type PortForwardRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// Validate checks the fields of a port forward request
+func (req *PortForwardRequest) Validate() error {
+	var v utils.Validator
+	v.Check(req.Enabled != nil, "enabled", "is required")
+	return v.Err()
+}
+
+// PortForwardResponse represents the port forwarded to a device
+type PortForwardResponse struct {
+	PeerID string `json:"peerId"`
+	Port   int    `json:"port"` // on the device's server and the device, 0 for none
+}
+
+// SetPortForwardHandler forwards a port of a device's server to the device,
+// or stops forwarding it
+func SetPortForwardHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["id"]
+
+	var req PortForwardRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Set port forward
+	peer, err := VPNManager.SetPortForward(r.Context(), userID, peerID, *req.Enabled)
+	if err != nil {
+		if _, ok := err.(*core.EntitlementError); ok {
+			utils.WriteErrorResponse(w, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, PortForwardResponse{
+		PeerID: peer.ID,
+		Port:   peer.ForwardedPort,
+	})
+}
@@ -0,0 +1,73 @@
+package vpn
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// RoutingRequest represents a request to set the AllowedIPs of a device
+type RoutingRequest struct {
+	Mode    string   `json:"mode"`              // full, subnets or exclude-lan; empty for the server default
+	Subnets []string `json:"subnets,omitempty"` // networks routed in subnets mode
+}
+
+// Validate checks the fields of a routing request
+func (req *RoutingRequest) Validate() error {
+	var v utils.Validator
+	v.OneOf("mode", req.Mode, core.RoutingModeFull, core.RoutingModeSubnets, core.RoutingModeExcludeLAN)
+	if req.Mode == core.RoutingModeSubnets {
+		v.Check(len(req.Subnets) > 0, "subnets", "is required in subnets mode")
+	} else {
+		v.Check(len(req.Subnets) == 0, "subnets", "is only used in subnets mode")
+	}
+	return v.Err()
+}
+
+// RoutingResponse represents a device's routing with its new config
+type RoutingResponse struct {
+	PeerID     string `json:"peerId"`
+	Mode       string `json:"mode"`
+	AllowedIPs string `json:"allowedIps"` // empty for the server default
+	Config     string `json:"config,omitempty"`
+}
+
+// SetPeerRoutingHandler sets the networks a device routes through the
+// tunnel and returns its updated config
+func SetPeerRoutingHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// The new config carries the device's keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	// Get peer ID from URL
+	vars := mux.Vars(r)
+	peerID := vars["id"]
+
+	var req RoutingRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Set routing
+	peer, config, err := VPNManager.SetPeerRouting(r.Context(), userID, peerID, req.Mode, req.Subnets)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, RoutingResponse{
+		PeerID:     peer.ID,
+		Mode:       peer.Routing,
+		AllowedIPs: peer.AllowedIPs,
+		Config:     config,
+	})
+}
@@ -0,0 +1,108 @@
+package vpn
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ShareConfigRequest represents a request for a one-time config share link
+type ShareConfigRequest struct {
+	PeerID string `json:"peerId"`
+	TTL    int    `json:"ttl,omitempty"` // in minutes, defaults to api.shareTtl
+}
+
+// Validate checks the fields of a config share request
+func (req *ShareConfigRequest) Validate() error {
+	var v utils.Validator
+	v.Required("peerId", req.PeerID)
+	v.Check(req.TTL >= 0, "ttl", "must not be negative")
+	return v.Err()
+}
+
+// CreateConfigShareHandler issues a single-use, expiring link to download a
+// peer's config on the device it is meant for
+func CreateConfigShareHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Plans that require step-up need a fresh two-factor verification
+	// before handing out new configs or keys
+	if err := middleware.CheckStepUp(r); err != nil {
+		middleware.RespondStepUpRequired(w)
+		return
+	}
+
+	var req ShareConfigRequest
+	if err := utils.DecodeRequest(r, &req); err != nil {
+		utils.RespondWithValidationError(w, err)
+		return
+	}
+
+	// Create share link
+	share, err := VPNManager.ShareConfig(r.Context(), userID, req.PeerID, time.Duration(req.TTL)*time.Minute)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, share)
+}
+
+// ListConfigSharesHandler lists the user's share links that have not been
+// used, revoked or expired
+func ListConfigSharesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	shares, err := VPNManager.ConfigShares().ListShares(r.Context(), userID)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to list share links")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, shares)
+}
+
+// RevokeConfigShareHandler invalidates a share link before it is used
+func RevokeConfigShareHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Get share ID from URL
+	vars := mux.Vars(r)
+	shareID := vars["id"]
+
+	if err := VPNManager.ConfigShares().RevokeShare(userID, shareID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Share link not found or already used")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
+
+// SharedConfigHandler downloads the config behind a share link. It needs no
+// login, as the link is the credential, and works only once.
+func SharedConfigHandler(w http.ResponseWriter, r *http.Request) {
+	// Get token from URL
+	vars := mux.Vars(r)
+	token := vars["token"]
+
+	// Redeem share link
+	config, err := VPNManager.RedeemConfigShare(r.Context(), token)
+	if err != nil {
+		utils.LogWarningContext(r.Context(), "Failed to redeem config share link: %v", err)
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Share link is invalid, used, revoked or expired")
+		return
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"wg0.conf\"")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(config))
+}
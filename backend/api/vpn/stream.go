@@ -0,0 +1,176 @@
+package vpn
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// Status stream event types
+const (
+	StatusEventSnapshot     = "status"       // all peers, sent when the stream opens
+	StatusEventConnected    = "connected"    // a peer was added
+	StatusEventDisconnected = "disconnected" // a peer was removed
+	StatusEventHandshake    = "handshake"    // a peer's session status changed
+	StatusEventBandwidth    = "bandwidth"    // a peer's traffic counters changed
+	StatusEventMigrated     = "migrated"     // a peer moved to another server with a new address
+)
+
+const (
+	// streamWriteTimeout bounds writing a single message
+	streamWriteTimeout = 10 * time.Second
+	// streamPongTimeout is how long a client may stay silent before the
+	// stream is closed; pings are sent at 90% of it
+	streamPongTimeout = 60 * time.Second
+)
+
+// StatusInterval is how often status streams check for changes
+var StatusInterval = 5 * time.Second
+
+// streamUpgrader upgrades status stream requests. Streams are authenticated
+// by bearer token rather than cookies, so any origin may connect, as with
+// the rest of the API.
+var streamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	Subprotocols:    []string{"bearer"},
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// StatusEvent represents a message on the status stream
+type StatusEvent struct {
+	Type      string                `json:"type"`
+	Peer      *wireguard.PeerInfo   `json:"peer,omitempty"`
+	Peers     []*wireguard.PeerInfo `json:"peers,omitempty"`
+	Timestamp time.Time             `json:"timestamp"`
+}
+
+// StatusStreamHandler upgrades to a WebSocket that pushes connect,
+// disconnect, migration, handshake and bandwidth changes of the user's
+// peers, so clients need not poll /vpn/status. Browsers, which cannot set
+// headers on WebSocket requests, may pass the token as the subprotocols
+// "bearer, <token>".
+func StatusStreamHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID := r.Context().Value("userID").(string)
+
+	// Get the current status first so failures are reported over HTTP
+	peers, err := VPNManager.GetStatus(r.Context(), userID)
+	if err != nil {
+		writeOperationError(w, r, err, "Failed to get connection status")
+		return
+	}
+
+	// Upgrade connection; the upgrader writes its own error response
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		utils.LogWarningContext(r.Context(), "Failed to open status stream: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// Read until the client goes away, so control frames are handled
+	// and a closed connection ends the stream
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(streamPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(streamPongTimeout))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	interval := StatusInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	pings := time.NewTicker(streamPongTimeout * 9 / 10)
+	defer pings.Stop()
+
+	send := func(event StatusEvent) bool {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteJSON(event) == nil
+	}
+
+	if !send(StatusEvent{Type: StatusEventSnapshot, Peers: peers, Timestamp: time.Now().UTC()}) {
+		return
+	}
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		case <-pings.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case <-ticker.C:
+			current, err := VPNManager.GetStatus(r.Context(), userID)
+			if err != nil {
+				// Keep the stream open; the next check may succeed
+				utils.LogWarningContext(r.Context(), "Failed to get connection status for stream: %v", err)
+				continue
+			}
+
+			// Only send changes
+			for _, event := range diffStatus(peers, current) {
+				if !send(event) {
+					return
+				}
+			}
+			peers = current
+		}
+	}
+}
+
+// diffStatus gets the events that turn one status into the next
+func diffStatus(last, current []*wireguard.PeerInfo) []StatusEvent {
+	now := time.Now().UTC()
+	events := make([]StatusEvent, 0)
+
+	previous := make(map[string]*wireguard.PeerInfo, len(last))
+	for _, peer := range last {
+		previous[peer.ID] = peer
+	}
+
+	for _, peer := range current {
+		before, ok := previous[peer.ID]
+		delete(previous, peer.ID)
+
+		if !ok {
+			events = append(events, StatusEvent{Type: StatusEventConnected, Peer: peer, Timestamp: now})
+			continue
+		}
+		if before.ServerID != peer.ServerID || before.IP != peer.IP {
+			events = append(events, StatusEvent{Type: StatusEventMigrated, Peer: peer, Timestamp: now})
+		}
+		if before.Status != peer.Status {
+			events = append(events, StatusEvent{Type: StatusEventHandshake, Peer: peer, Timestamp: now})
+		}
+		if before.BytesRx != peer.BytesRx || before.BytesTx != peer.BytesTx {
+			events = append(events, StatusEvent{Type: StatusEventBandwidth, Peer: peer, Timestamp: now})
+		}
+	}
+
+	// Peers left over were removed
+	for _, peer := range last {
+		if _, ok := previous[peer.ID]; ok {
+			events = append(events, StatusEvent{Type: StatusEventDisconnected, Peer: peer, Timestamp: now})
+		}
+	}
+
+	return events
+}
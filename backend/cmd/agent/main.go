@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/vpn-service/backend/src/agent"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+func main() {
+	serverID := flag.String("server", "", "ID of the server the agent runs, overrides agent.serverId")
+	version := flag.Bool("version", false, "print the agent version and exit")
+	flag.Parse()
+
+	if *version {
+		fmt.Println(agent.Version)
+		return
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *serverID != "" {
+		cfg.Agent.ServerID = *serverID
+	}
+
+	// Initialize logger
+	if err := utils.InitLogger(cfg.Monitoring.LogDir); err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
+	}
+	defer utils.CloseLogger()
+
+	// Bring up WireGuard with the peers of this server
+	nodeAgent := agent.New(cfg)
+	if err := nodeAgent.Start(context.Background()); err != nil {
+		utils.LogFatal("Failed to start node agent: %v", err)
+	}
+	utils.LogInfo("Node agent %s running server %s for %s", agent.Version, cfg.Agent.ServerID, cfg.Agent.ControlPlane)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	nodeAgent.Run(ctx)
+
+	// Remove the interfaces
+	utils.LogInfo("Shutting down node agent...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := nodeAgent.Stop(shutdownCtx); err != nil {
+		utils.LogError("Failed to remove WireGuard interfaces: %v", err)
+		os.Exit(1)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/vpn-service/backend/src/config"
 	"github.com/vpn-service/backend/src/utils"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 )
 
 var (
@@ -17,6 +19,10 @@ var (
 
 // Connect connects to the database
 func Connect(cfg *config.Config) error {
+	if cfg.Database.Driver == "sqlite" {
+		return connectSQLite(cfg.Database.Path)
+	}
+
 	// Build connection string
 	connStr := fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
@@ -27,11 +33,13 @@ func Connect(cfg *config.Config) error {
 		cfg.Database.Name,
 	)
 
-	// Connect to database
-	db, err := sqlx.Connect("postgres", connStr)
+	// Connect to database through a driver wrapper that records a span for
+	// every query, so database time shows up in request traces
+	sqlDB, err := otelsql.Open("postgres", connStr, otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %v", err)
 	}
+	db := sqlx.NewDb(sqlDB, "postgres")
 
 	// Set connection pool settings
 	db.SetMaxOpenConns(25)
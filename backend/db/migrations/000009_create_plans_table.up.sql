@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS plans (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    entitlements JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO plans (id, name, entitlements) VALUES
+    ('free', 'Free', '{"protocols": ["wireguard"], "maxDevices": 1, "bandwidthMbps": 50}'),
+    ('premium', 'Premium', '{"protocols": ["wireguard"], "maxDevices": 5, "multiHop": true, "dedicatedIp": true, "portForwarding": true}')
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan VARCHAR(36) NOT NULL DEFAULT 'free' REFERENCES plans(id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS funnel_events (
+    user_key VARCHAR(64) NOT NULL,
+    stage VARCHAR(32) NOT NULL,
+    channel VARCHAR(100) NOT NULL DEFAULT 'direct',
+    platform VARCHAR(50) NOT NULL DEFAULT 'unknown',
+    occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_key, stage)
+);
+
+CREATE INDEX IF NOT EXISTS idx_funnel_events_stage_occurred_at ON funnel_events (stage, occurred_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS agent_rollouts;
+DROP TABLE IF EXISTS node_versions;
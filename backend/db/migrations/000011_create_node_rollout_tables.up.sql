@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS node_versions (
+    server_id VARCHAR(36) PRIMARY KEY,
+    agent_version VARCHAR(50) NOT NULL,
+    wireguard_version VARCHAR(50) NOT NULL DEFAULT '',
+    last_heartbeat TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS agent_rollouts (
+    id VARCHAR(36) PRIMARY KEY,
+    version VARCHAR(50) NOT NULL,
+    canary_id VARCHAR(36) NOT NULL,
+    stage VARCHAR(20) NOT NULL DEFAULT 'canary',
+    error TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    stage_started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    canary_upgraded_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_rollouts_stage ON agent_rollouts (stage);
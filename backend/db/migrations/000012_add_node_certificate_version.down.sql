@@ -0,0 +1 @@
+ALTER TABLE node_versions DROP COLUMN IF EXISTS certificate_version;
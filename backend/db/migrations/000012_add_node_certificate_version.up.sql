@@ -0,0 +1 @@
+ALTER TABLE node_versions ADD COLUMN IF NOT EXISTS certificate_version VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS account_merges (
+    id VARCHAR(36) PRIMARY KEY,
+    source_user_id VARCHAR(36) NOT NULL,
+    target_user_id VARCHAR(36) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'staged',
+    devices VARCHAR(20) NOT NULL,
+    plan VARCHAR(20) NOT NULL,
+    source_plan VARCHAR(36) NOT NULL,
+    target_plan VARCHAR(36) NOT NULL,
+    merged_plan VARCHAR(36) NOT NULL,
+    peers TEXT[] NOT NULL DEFAULT '{}',
+    kept_peers TEXT[] NOT NULL DEFAULT '{}',
+    conflicts TEXT[] NOT NULL DEFAULT '{}',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    staged_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    committed_at TIMESTAMP,
+    reverted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_merges_source_user_id ON account_merges (source_user_id);
+CREATE INDEX IF NOT EXISTS idx_account_merges_target_user_id ON account_merges (target_user_id);
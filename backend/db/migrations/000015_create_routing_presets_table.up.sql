@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS routing_presets (
+    id VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    cidrs TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO routing_presets (id, name, description, cidrs) VALUES
+    ('full', 'Full tunnel', 'All traffic goes through the VPN', '{"0.0.0.0/0", "::/0"}'),
+    ('corporate', 'Corporate networks', 'Only private network ranges go through the VPN', '{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}')
+ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS device_keys (
+    id VARCHAR(36) PRIMARY KEY,
+    public_key VARCHAR(44) NOT NULL UNIQUE,
+    tag VARCHAR(255) NOT NULL DEFAULT '',
+    user_id VARCHAR(36) NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS config_templates (
+    name VARCHAR(32) PRIMARY KEY,
+    content TEXT NOT NULL,
+    updated_by VARCHAR(36) NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS ip_reservations (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tunnel_ip VARCHAR(45) NOT NULL UNIQUE,
+    public_ip VARCHAR(45) NOT NULL DEFAULT '',
+    server_id VARCHAR(36) NOT NULL DEFAULT '',
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_ip_reservations_user_id ON ip_reservations (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ip_reservations_public_ip ON ip_reservations (public_ip) WHERE public_ip <> '';
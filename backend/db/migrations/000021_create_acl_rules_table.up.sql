@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS acl_rules (
+    id VARCHAR(36) PRIMARY KEY,
+    priority INTEGER NOT NULL DEFAULT 0,
+    source VARCHAR(255) NOT NULL,
+    destination VARCHAR(255) NOT NULL,
+    protocol VARCHAR(8) NOT NULL DEFAULT '',
+    port INTEGER NOT NULL DEFAULT 0,
+    action VARCHAR(8) NOT NULL,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_acl_rules_priority ON acl_rules (priority, created_at);
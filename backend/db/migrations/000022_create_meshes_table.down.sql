@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS mesh_members;
+DROP TABLE IF EXISTS meshes;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS meshes (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    topology VARCHAR(8) NOT NULL,
+    hub_peer_id VARCHAR(36) NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS mesh_members (
+    user_id VARCHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    mesh_id VARCHAR(36) NOT NULL REFERENCES meshes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_mesh_members_mesh_id ON mesh_members (mesh_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS node_commands (
+    id VARCHAR(36) PRIMARY KEY,
+    server_id VARCHAR(36) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    error TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_commands_server_id ON node_commands (server_id, created_at);
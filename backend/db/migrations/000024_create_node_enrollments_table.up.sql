@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS node_enrollments (
+    server_id VARCHAR(36) PRIMARY KEY,
+    token_hash VARCHAR(64) NOT NULL DEFAULT '',
+    token_expires_at TIMESTAMP,
+    certificate_serial VARCHAR(40) NOT NULL DEFAULT '',
+    certificate_expires_at TIMESTAMP,
+    enrolled_at TIMESTAMP,
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
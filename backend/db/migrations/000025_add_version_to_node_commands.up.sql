@@ -0,0 +1 @@
+ALTER TABLE node_commands ADD COLUMN IF NOT EXISTS version VARCHAR(64) NOT NULL DEFAULT '';
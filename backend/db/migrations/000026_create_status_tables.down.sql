@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS server_outages;
+DROP TABLE IF EXISTS incidents;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS incidents (
+    id VARCHAR(36) PRIMARY KEY,
+    title VARCHAR(200) NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'investigating',
+    regions TEXT[] NOT NULL DEFAULT '{}',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS server_outages (
+    id VARCHAR(36) PRIMARY KEY,
+    server_id VARCHAR(36) NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_server_outages_started_at ON server_outages (started_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS server_outages (
+    id VARCHAR(36) PRIMARY KEY,
+    server_id VARCHAR(36) NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_server_outages_started_at ON server_outages (started_at);
+
+DROP TABLE IF EXISTS server_transitions;
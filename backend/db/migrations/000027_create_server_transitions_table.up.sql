@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS server_transitions (
+    id VARCHAR(36) PRIMARY KEY,
+    server_id VARCHAR(36) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    previous VARCHAR(20) NOT NULL DEFAULT '',
+    at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_server_transitions_at ON server_transitions (at);
+
+-- Outages recorded for the status page become transitions to and from offline
+INSERT INTO server_transitions (id, server_id, status, previous, at)
+SELECT id, server_id, 'offline', 'online', started_at FROM server_outages;
+
+INSERT INTO server_transitions (id, server_id, status, previous, at)
+SELECT md5(id || 'ended')::uuid::text, server_id, 'online', 'offline', ended_at FROM server_outages WHERE ended_at IS NOT NULL;
+
+DROP TABLE IF EXISTS server_outages;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS provisions (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(63) NOT NULL,
+    template VARCHAR(100) NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    region VARCHAR(50) NOT NULL DEFAULT '',
+    machine_id VARCHAR(100) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    server_id VARCHAR(36) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
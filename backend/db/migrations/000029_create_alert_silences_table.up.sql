@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS alert_silences (
+    id VARCHAR(36) PRIMARY KEY,
+    rule VARCHAR(100) NOT NULL DEFAULT '',
+    subject VARCHAR(100) NOT NULL DEFAULT '',
+    comment TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_silences_ends_at ON alert_silences (ends_at);
@@ -0,0 +1,21 @@
+DROP TABLE IF EXISTS node_enrollments;
+DROP TABLE IF EXISTS node_commands;
+DROP TABLE IF EXISTS mesh_members;
+DROP TABLE IF EXISTS meshes;
+DROP TABLE IF EXISTS acl_rules;
+DROP TABLE IF EXISTS ip_reservations;
+DROP TABLE IF EXISTS config_templates;
+DROP TABLE IF EXISTS device_keys;
+DROP TABLE IF EXISTS config_shares;
+DROP TABLE IF EXISTS push_devices;
+DROP TABLE IF EXISTS routing_presets;
+DROP TABLE IF EXISTS account_merges;
+DROP TABLE IF EXISTS agent_rollouts;
+DROP TABLE IF EXISTS node_versions;
+DROP TABLE IF EXISTS funnel_events;
+DROP TABLE IF EXISTS user_invites;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS servers;
+DROP TABLE IF EXISTS vpn_peers;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS plans;
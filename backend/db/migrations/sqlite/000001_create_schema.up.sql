@@ -0,0 +1,309 @@
+-- Schema of the embedded SQLite database used in standalone mode, matching
+-- the PostgreSQL migrations up to 000019. Array columns hold PostgreSQL
+-- array literals and JSONB columns hold JSON text.
+
+CREATE TABLE IF NOT EXISTS plans (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    entitlements TEXT NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO plans (id, name, entitlements) VALUES
+    ('free', 'Free', '{"protocols": ["wireguard"], "maxDevices": 1, "bandwidthMbps": 50}'),
+    ('premium', 'Premium', '{"protocols": ["wireguard"], "maxDevices": 5, "multiHop": true, "dedicatedIp": true, "portForwarding": true}')
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(36) PRIMARY KEY,
+    username VARCHAR(50) NOT NULL UNIQUE,
+    email VARCHAR(100) NOT NULL UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'user',
+    device_defaults TEXT NOT NULL DEFAULT '{}',
+    telemetry_enabled BOOLEAN,
+    plan VARCHAR(36) NOT NULL DEFAULT 'free' REFERENCES plans(id),
+    mfa_secret VARCHAR(64),
+    mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS vpn_peers (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    server_id VARCHAR(36) NOT NULL,
+    device_type VARCHAR(50) NOT NULL,
+    public_key VARCHAR(255) NOT NULL UNIQUE,
+    private_key VARCHAR(255) NOT NULL,
+    ip VARCHAR(50) NOT NULL UNIQUE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS servers (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    location VARCHAR(100) NOT NULL,
+    ip VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'offline',
+    load INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(36),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_invites (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_invites_user_id ON user_invites (user_id);
+
+CREATE TABLE IF NOT EXISTS funnel_events (
+    user_key VARCHAR(64) NOT NULL,
+    stage VARCHAR(32) NOT NULL,
+    channel VARCHAR(100) NOT NULL DEFAULT 'direct',
+    platform VARCHAR(50) NOT NULL DEFAULT 'unknown',
+    occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_key, stage)
+);
+
+CREATE INDEX IF NOT EXISTS idx_funnel_events_stage_occurred_at ON funnel_events (stage, occurred_at);
+
+CREATE TABLE IF NOT EXISTS node_versions (
+    server_id VARCHAR(36) PRIMARY KEY,
+    agent_version VARCHAR(50) NOT NULL,
+    wireguard_version VARCHAR(50) NOT NULL DEFAULT '',
+    certificate_version VARCHAR(64) NOT NULL DEFAULT '',
+    last_heartbeat TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS agent_rollouts (
+    id VARCHAR(36) PRIMARY KEY,
+    version VARCHAR(50) NOT NULL,
+    canary_id VARCHAR(36) NOT NULL,
+    stage VARCHAR(20) NOT NULL DEFAULT 'canary',
+    error TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    stage_started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    canary_upgraded_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_rollouts_stage ON agent_rollouts (stage);
+
+CREATE TABLE IF NOT EXISTS account_merges (
+    id VARCHAR(36) PRIMARY KEY,
+    source_user_id VARCHAR(36) NOT NULL,
+    target_user_id VARCHAR(36) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'staged',
+    devices VARCHAR(20) NOT NULL,
+    plan VARCHAR(20) NOT NULL,
+    source_plan VARCHAR(36) NOT NULL,
+    target_plan VARCHAR(36) NOT NULL,
+    merged_plan VARCHAR(36) NOT NULL,
+    peers TEXT NOT NULL DEFAULT '{}',
+    kept_peers TEXT NOT NULL DEFAULT '{}',
+    conflicts TEXT NOT NULL DEFAULT '{}',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    staged_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    committed_at TIMESTAMP,
+    reverted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_merges_source_user_id ON account_merges (source_user_id);
+CREATE INDEX IF NOT EXISTS idx_account_merges_target_user_id ON account_merges (target_user_id);
+
+CREATE TABLE IF NOT EXISTS routing_presets (
+    id VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    cidrs TEXT NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO routing_presets (id, name, description, cidrs) VALUES
+    ('full', 'Full tunnel', 'All traffic goes through the VPN', '{"0.0.0.0/0","::/0"}'),
+    ('corporate', 'Corporate networks', 'Only private network ranges go through the VPN', '{"10.0.0.0/8","172.16.0.0/12","192.168.0.0/16","fc00::/7"}')
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS push_devices (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    platform VARCHAR(10) NOT NULL,
+    token TEXT NOT NULL UNIQUE,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_push_devices_user_id ON push_devices (user_id);
+
+CREATE TABLE IF NOT EXISTS config_shares (
+    id VARCHAR(36) PRIMARY KEY,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    peer_id VARCHAR(36) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_config_shares_user_id ON config_shares (user_id);
+
+CREATE TABLE IF NOT EXISTS device_keys (
+    id VARCHAR(36) PRIMARY KEY,
+    public_key VARCHAR(44) NOT NULL UNIQUE,
+    tag VARCHAR(255) NOT NULL DEFAULT '',
+    user_id VARCHAR(36) NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS config_templates (
+    name VARCHAR(32) PRIMARY KEY,
+    content TEXT NOT NULL,
+    updated_by VARCHAR(36) NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ip_reservations (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tunnel_ip VARCHAR(45) NOT NULL UNIQUE,
+    public_ip VARCHAR(45) NOT NULL DEFAULT '',
+    server_id VARCHAR(36) NOT NULL DEFAULT '',
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_ip_reservations_user_id ON ip_reservations (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ip_reservations_public_ip ON ip_reservations (public_ip) WHERE public_ip <> '';
+
+CREATE TABLE IF NOT EXISTS acl_rules (
+    id VARCHAR(36) PRIMARY KEY,
+    priority INTEGER NOT NULL DEFAULT 0,
+    source VARCHAR(255) NOT NULL,
+    destination VARCHAR(255) NOT NULL,
+    protocol VARCHAR(8) NOT NULL DEFAULT '',
+    port INTEGER NOT NULL DEFAULT 0,
+    action VARCHAR(8) NOT NULL,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_acl_rules_priority ON acl_rules (priority, created_at);
+
+CREATE TABLE IF NOT EXISTS meshes (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    topology VARCHAR(8) NOT NULL,
+    hub_peer_id VARCHAR(36) NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS mesh_members (
+    user_id VARCHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    mesh_id VARCHAR(36) NOT NULL REFERENCES meshes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_mesh_members_mesh_id ON mesh_members (mesh_id);
+
+CREATE TABLE IF NOT EXISTS node_commands (
+    id VARCHAR(36) PRIMARY KEY,
+    server_id VARCHAR(36) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    version VARCHAR(64) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    error TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_commands_server_id ON node_commands (server_id, created_at);
+
+CREATE TABLE IF NOT EXISTS node_enrollments (
+    server_id VARCHAR(36) PRIMARY KEY,
+    token_hash VARCHAR(64) NOT NULL DEFAULT '',
+    token_expires_at TIMESTAMP,
+    certificate_serial VARCHAR(40) NOT NULL DEFAULT '',
+    certificate_expires_at TIMESTAMP,
+    enrolled_at TIMESTAMP,
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS incidents (
+    id VARCHAR(36) PRIMARY KEY,
+    title VARCHAR(200) NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'investigating',
+    regions TEXT NOT NULL DEFAULT '{}',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS server_transitions (
+    id VARCHAR(36) PRIMARY KEY,
+    server_id VARCHAR(36) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    previous VARCHAR(20) NOT NULL DEFAULT '',
+    at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_server_transitions_at ON server_transitions (at);
+
+CREATE TABLE IF NOT EXISTS provisions (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(63) NOT NULL,
+    template VARCHAR(100) NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    region VARCHAR(50) NOT NULL DEFAULT '',
+    machine_id VARCHAR(100) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    server_id VARCHAR(36) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS alert_silences (
+    id VARCHAR(36) PRIMARY KEY,
+    rule VARCHAR(100) NOT NULL DEFAULT '',
+    subject VARCHAR(100) NOT NULL DEFAULT '',
+    comment TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(36) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_silences_ends_at ON alert_silences (ends_at);
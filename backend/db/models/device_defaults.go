@@ -0,0 +1,38 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// SupportedProtocols lists the VPN protocols a user can prefer
+var SupportedProtocols = []string{"wireguard"}
+
+// DeviceDefaults represents the account-level settings applied to every new peer
+type DeviceDefaults struct {
+	DNS        string `json:"dns,omitempty"`
+	KillSwitch bool   `json:"killSwitch"`
+	Protocol   string `json:"protocol,omitempty"`
+	Keepalive  int    `json:"keepalive,omitempty"` // in seconds, 0 uses the server default
+}
+
+// Value implements driver.Valuer so defaults are stored as JSON
+func (d DeviceDefaults) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+// Scan implements sql.Scanner so defaults are read from JSON
+func (d *DeviceDefaults) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = DeviceDefaults{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	default:
+		return fmt.Errorf("unsupported device defaults type: %T", src)
+	}
+}
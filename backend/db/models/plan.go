@@ -0,0 +1,82 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DefaultPlanID is the plan of users without an explicit plan
+const DefaultPlanID = "free"
+
+// Plan features that can be switched on or off
+const (
+	FeatureMultiHop       = "multi_hop"
+	FeatureDedicatedIP    = "dedicated_ip"
+	FeaturePortForwarding = "port_forwarding"
+)
+
+// Plan represents a subscription plan
+type Plan struct {
+	ID           string       `json:"id" db:"id"`
+	Name         string       `json:"name" db:"name"`
+	Entitlements Entitlements `json:"entitlements" db:"entitlements"`
+	CreatedAt    time.Time    `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time    `json:"updatedAt" db:"updated_at"`
+}
+
+// Entitlements represents what a plan allows
+type Entitlements struct {
+	Protocols      []string `json:"protocols"`
+	MaxDevices     int      `json:"maxDevices"` // 0 means unlimited
+	MultiHop       bool     `json:"multiHop"`
+	DedicatedIP    bool     `json:"dedicatedIp"`
+	PortForwarding bool     `json:"portForwarding"`
+	BandwidthMbps  int      `json:"bandwidthMbps"` // 0 means unlimited
+	RequireStepUp  bool     `json:"requireStepUp"` // new configs and keys need a fresh two-factor verification
+}
+
+// AllowsProtocol checks whether the plan allows a protocol
+func (e Entitlements) AllowsProtocol(protocol string) bool {
+	for _, p := range e.Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFeature checks whether the plan includes a feature
+func (e Entitlements) HasFeature(feature string) bool {
+	switch feature {
+	case FeatureMultiHop:
+		return e.MultiHop
+	case FeatureDedicatedIP:
+		return e.DedicatedIP
+	case FeaturePortForwarding:
+		return e.PortForwarding
+	default:
+		return false
+	}
+}
+
+// Value implements driver.Valuer so entitlements are stored as JSON
+func (e Entitlements) Value() (driver.Value, error) {
+	return json.Marshal(e)
+}
+
+// Scan implements sql.Scanner so entitlements are read from JSON
+func (e *Entitlements) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*e = Entitlements{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	default:
+		return fmt.Errorf("unsupported entitlements type: %T", src)
+	}
+}
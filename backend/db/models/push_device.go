@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// PushDevice represents a mobile device registered for push notifications
+type PushDevice struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"userId" db:"user_id"`
+	Platform  string    `json:"platform" db:"platform"` // fcm or apns
+	Token     string    `json:"-" db:"token"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
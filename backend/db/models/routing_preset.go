@@ -0,0 +1,24 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RoutingPreset represents a named set of networks routed through the
+// tunnel, picked by users at connect time instead of hand-written CIDRs
+type RoutingPreset struct {
+	ID          string         `json:"id" db:"id"`
+	Name        string         `json:"name" db:"name"`
+	Description string         `json:"description" db:"description"`
+	CIDRs       pq.StringArray `json:"cidrs" db:"cidrs"`
+	CreatedAt   time.Time      `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time      `json:"updatedAt" db:"updated_at"`
+}
+
+// AllowedIPs renders the preset as a WireGuard AllowedIPs value
+func (p *RoutingPreset) AllowedIPs() string {
+	return strings.Join(p.CIDRs, ", ")
+}
@@ -4,14 +4,25 @@ import (
 	"time"
 )
 
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User represents a user in the system
 type User struct {
-	ID        string    `json:"id" db:"id"`
-	Username  string    `json:"username" db:"username"`
-	Email     string    `json:"email" db:"email"`
-	Password  string    `json:"-" db:"password_hash"` // Password hash is not included in JSON
-	CreatedAt time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+	ID             string         `json:"id" db:"id"`
+	Username       string         `json:"username" db:"username"`
+	Email          string         `json:"email" db:"email"`
+	Password       string         `json:"-" db:"password_hash"` // Password hash is not included in JSON
+	Role           string         `json:"role" db:"role"`
+	Plan           string         `json:"plan" db:"plan"`
+	DeviceDefaults DeviceDefaults `json:"deviceDefaults" db:"device_defaults"`
+	Telemetry      *bool          `json:"telemetryEnabled" db:"telemetry_enabled"` // nil uses the deployment default
+	MFAEnabled     bool           `json:"mfaEnabled" db:"mfa_enabled"`
+	CreatedAt      time.Time      `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time      `json:"updatedAt" db:"updated_at"`
 }
 
 // NewUser creates a new user
@@ -22,6 +33,8 @@ func NewUser(username, email, passwordHash string) *User {
 		Username:  username,
 		Email:     email,
 		Password:  passwordHash,
+		Role:      RoleUser,
+		Plan:      DefaultPlanID,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
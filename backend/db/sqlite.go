@@ -0,0 +1,81 @@
+package db
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/XSAM/otelsql"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jmoiron/sqlx"
+	"github.com/vpn-service/backend/src/utils"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations holds the schema of the embedded SQLite database, so a
+// standalone binary does not depend on the directory it is started from
+//
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// connectSQLite opens the SQLite database file used in standalone mode,
+// creating it if needed
+func connectSQLite(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create database directory: %v", err)
+	}
+
+	// Enforce foreign keys like PostgreSQL does, and wait for locks instead
+	// of failing while another write is in progress
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", path)
+	sqlDB, err := otelsql.Open("sqlite", dsn, otelsql.WithAttributes(semconv.DBSystemSqlite))
+	if err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+	db := sqlx.NewDb(sqlDB, "sqlite")
+
+	// SQLite allows a single writer, so share one connection
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
+	}
+
+	DB = db
+
+	utils.LogInfo("Opened SQLite database %s", path)
+	return nil
+}
+
+// RunSQLiteMigrations brings the embedded SQLite database up to date
+func RunSQLiteMigrations() error {
+	if DB == nil {
+		return fmt.Errorf("database is not connected")
+	}
+
+	source, err := iofs.New(sqliteMigrations, "migrations/sqlite")
+	if err != nil {
+		return fmt.Errorf("failed to read embedded migrations: %v", err)
+	}
+
+	driver, err := sqlite.WithInstance(DB.DB, &sqlite.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to create sqlite driver: %v", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "sqlite", driver)
+	if err != nil {
+		return fmt.Errorf("failed to create migrate instance: %v", err)
+	}
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("failed to run migrations: %v", err)
+	}
+
+	utils.LogInfo("Database migrations completed successfully")
+	return nil
+}
@@ -0,0 +1,404 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/vpn-service/backend/api"
+	"github.com/vpn-service/backend/api/auth"
+	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/api/nodes"
+	"github.com/vpn-service/backend/api/vpn"
+	store "github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/monitoring"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// The end-to-end suite runs the API against PostgreSQL in Docker, with a
+// simulated node agent reporting for one server. Run it with
+//
+//	go test -tags integration -run TestEndToEnd .
+//
+// On hosts without Docker, E2E_DATABASE=sqlite runs it against an embedded
+// SQLite file instead, as in standalone mode.
+
+const (
+	e2eAgentToken = "e2e-agent-token"
+	e2eServerID   = "us-east-1"
+
+	// e2eServerKey is the WireGuard public key of the server
+	e2eServerKey = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+)
+
+// TestEndToEnd walks a user through register, login, connect, status and
+// disconnect while the node agent of the server follows the peer
+func TestEndToEnd(t *testing.T) {
+	server := startAPI(t)
+	node := &simulatedNode{url: server.URL, serverID: e2eServerID}
+
+	// The node reports in before users connect
+	node.heartbeat(t)
+	if peers := node.state(t); len(peers) != 0 {
+		t.Fatalf("node state has %d peers before any connect", len(peers))
+	}
+
+	// Register
+	var registered auth.AuthResponse
+	call(t, server.URL, http.MethodPost, "/api/v1/auth/register", "", auth.RegisterRequest{
+		Username: "e2e-user",
+		Email:    "e2e-user@example.com",
+		Password: "correct horse battery staple",
+	}, http.StatusCreated, &registered)
+	if registered.Token == "" {
+		t.Fatal("register returned no token")
+	}
+
+	// Login
+	var login auth.AuthResponse
+	call(t, server.URL, http.MethodPost, "/api/v1/auth/login", "", auth.LoginRequest{
+		Username: "e2e-user",
+		Password: "correct horse battery staple",
+	}, http.StatusOK, &login)
+	if login.Token == "" {
+		t.Fatal("login returned no token")
+	}
+
+	// Connect
+	var connected vpn.ConnectResponse
+	call(t, server.URL, http.MethodPost, "/api/v1/vpn/connect", login.Token, vpn.ConnectRequest{
+		ServerID:   e2eServerID,
+		DeviceType: "linux",
+		DeviceName: "e2e-laptop",
+	}, http.StatusOK, &connected)
+	if connected.PeerID == "" || connected.Config == "" {
+		t.Fatalf("connect returned peer %q and a config of %d bytes", connected.PeerID, len(connected.Config))
+	}
+	if connected.ServerID != e2eServerID {
+		t.Fatalf("connect used server %s, want %s", connected.ServerID, e2eServerID)
+	}
+
+	// The node picks up the new peer on its next sync
+	node.heartbeat(t)
+	if !hasPeer(node.state(t), connected.PeerID) {
+		t.Fatalf("node state is missing peer %s after connect", connected.PeerID)
+	}
+
+	// Status
+	var status vpn.StatusResponse
+	call(t, server.URL, http.MethodGet, "/api/v1/vpn/status", login.Token, nil, http.StatusOK, &status)
+	if !status.Connected {
+		t.Fatal("status is not connected after connect")
+	}
+	found := false
+	for _, peer := range status.Peers {
+		if peer.ID == connected.PeerID {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("status is missing peer %s", connected.PeerID)
+	}
+
+	// Disconnect
+	call(t, server.URL, http.MethodPost, "/api/v1/vpn/disconnect", login.Token, vpn.DisconnectRequest{
+		PeerID: connected.PeerID,
+	}, http.StatusOK, nil)
+
+	// The node removes the peer on its next sync
+	node.heartbeat(t)
+	if hasPeer(node.state(t), connected.PeerID) {
+		t.Fatalf("node state still has peer %s after disconnect", connected.PeerID)
+	}
+
+	call(t, server.URL, http.MethodGet, "/api/v1/vpn/status", login.Token, nil, http.StatusOK, &status)
+	for _, peer := range status.Peers {
+		if peer.ID == connected.PeerID {
+			t.Fatalf("status still has peer %s after disconnect", connected.PeerID)
+		}
+	}
+}
+
+// startAPI starts PostgreSQL, migrates it and serves the API routes of the
+// service against it, wired as main wires them
+func startAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	dir := t.TempDir()
+	database := startDatabase(t, dir)
+
+	// Handlers load the configuration themselves, so it goes through the
+	// config file like in production
+	path := filepath.Join(dir, "config.json")
+	overrides := map[string]interface{}{
+		"database": database,
+		"jwt":      map[string]interface{}{"keyDir": filepath.Join(dir, "jwt-keys")},
+		"wireguard": map[string]interface{}{
+			"publicKey":      e2eServerKey,
+			"configDir":      filepath.Join(dir, "wireguard"),
+			"dynamicPeerDir": filepath.Join(dir, "wireguard", "dynamic-peers"),
+		},
+		"monitoring": map[string]interface{}{
+			"logDir":           filepath.Join(dir, "logs"),
+			"analyticsLogFile": filepath.Join(dir, "logs", "usage_analytics.log"),
+			"enablePrometheus": false,
+		},
+		"nodes": map[string]interface{}{"agentToken": e2eAgentToken},
+	}
+	content, err := json.Marshal(overrides)
+	if err != nil {
+		t.Fatalf("Failed to encode config: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Setenv("VPN_CONFIG_PATH", path)
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := utils.InitLogger(cfg.Monitoring.LogDir); err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	t.Cleanup(utils.CloseLogger)
+
+	// Connect and migrate
+	if err := store.Connect(cfg); err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if cfg.Database.Driver == "sqlite" {
+		err = store.RunSQLiteMigrations()
+	} else {
+		err = store.NewMigrationManager(cfg, store.DB.DB).RunMigrations()
+	}
+	if err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	monitoring.MetricsCollector = monitoring.NewCollector(cfg)
+
+	// Initialize managers
+	serverManager := core.NewServerManager(cfg)
+	vpnManager := core.NewVPNManager(cfg, serverManager)
+	events := core.NewEventBus()
+	serverManager.SetEventBus(events)
+	vpnManager.SetEventBus(events)
+
+	vpn.VPNManager = vpnManager
+	nodes.ServerManager = serverManager
+	nodes.VPNManager = vpnManager
+
+	signingKeys, err := core.NewSigningKeyManager(cfg)
+	if err != nil {
+		t.Fatalf("Failed to initialize signing keys: %v", err)
+	}
+	middleware.SigningKeys = signingKeys
+
+	auth.FunnelTracker = vpnManager.Funnel()
+	middleware.Entitlements = vpnManager.Entitlements()
+
+	userManager := core.NewUserManager(cfg)
+	vpnManager.SetUserManager(userManager)
+	auth.UserManager = userManager
+	auth.MFA = userManager.MFA()
+
+	middleware.AuditLog = core.NewAuditLog(cfg)
+	middleware.TokenDenylist = core.NewTokenDenylist(cfg)
+	middleware.RateLimiter = core.NewRateLimiter(cfg)
+
+	_, handler, err := api.NewRouter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to set up API routes: %v", err)
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// startDatabase returns the database section of the config for the suite
+func startDatabase(t *testing.T, dir string) config.DatabaseConfig {
+	t.Helper()
+
+	if os.Getenv("E2E_DATABASE") == "sqlite" {
+		return config.DatabaseConfig{Driver: "sqlite", Path: filepath.Join(dir, "vpn.db")}
+	}
+	return startPostgres(t)
+}
+
+// startPostgres runs PostgreSQL in Docker for the duration of the test and
+// returns the database section of the config that connects to it
+func startPostgres(t *testing.T) config.DatabaseConfig {
+	t.Helper()
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("Failed to connect to Docker: %v", err)
+	}
+	if err := pool.Client.Ping(); err != nil {
+		t.Fatalf("Failed to reach Docker: %v", err)
+	}
+	pool.MaxWait = 2 * time.Minute
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "15-alpine",
+		Env: []string{
+			"POSTGRES_USER=vpn",
+			"POSTGRES_PASSWORD=vpn",
+			"POSTGRES_DB=vpn_service",
+		},
+	}, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		t.Fatalf("Failed to start PostgreSQL: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pool.Purge(resource); err != nil {
+			t.Logf("Failed to remove PostgreSQL: %v", err)
+		}
+	})
+
+	// Stop the container even if the test binary is killed
+	resource.Expire(600)
+
+	port, err := strconv.Atoi(resource.GetPort("5432/tcp"))
+	if err != nil {
+		t.Fatalf("Failed to get PostgreSQL port: %v", err)
+	}
+	database := config.DatabaseConfig{
+		Driver:   "postgres",
+		Host:     "localhost",
+		Port:     port,
+		User:     "vpn",
+		Password: "vpn",
+		Name:     "vpn_service",
+	}
+
+	// Wait until PostgreSQL accepts connections
+	cfg := &config.Config{Database: database}
+	if err := pool.Retry(func() error {
+		if err := store.Connect(cfg); err != nil {
+			return err
+		}
+		return store.Close()
+	}); err != nil {
+		t.Fatalf("PostgreSQL did not come up: %v", err)
+	}
+
+	return database
+}
+
+// simulatedNode plays the node agent of a server: it reports heartbeats
+// and fetches the node state like src/agent, without touching WireGuard
+type simulatedNode struct {
+	url      string
+	serverID string
+	version  string // node state last fetched
+}
+
+// heartbeat reports the node as up with the peers of its last state
+func (n *simulatedNode) heartbeat(t *testing.T) {
+	t.Helper()
+
+	call(t, n.url, http.MethodPost, "/api/v1/nodes/heartbeat", e2eAgentToken, core.NodeHeartbeat{
+		ServerID:         n.serverID,
+		AgentVersion:     "e2e",
+		WireGuardVersion: "e2e",
+		Health: &core.NodeHealth{
+			Up:           true,
+			StateVersion: n.version,
+		},
+	}, http.StatusOK, &core.HeartbeatResponse{})
+}
+
+// state fetches the peers the node should apply
+func (n *simulatedNode) state(t *testing.T) []*wireguard.PeerConfig {
+	t.Helper()
+
+	var state wireguard.NodeState
+	call(t, n.url, http.MethodGet, "/api/v1/nodes/state?serverId="+n.serverID, e2eAgentToken, nil, http.StatusOK, &state)
+	n.version = state.Version
+
+	peers := make([]*wireguard.PeerConfig, 0, len(state.Peers))
+	for _, peer := range state.Peers {
+		if peer.ServerID == n.serverID {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// hasPeer checks if a peer is in a node state
+func hasPeer(peers []*wireguard.PeerConfig, peerID string) bool {
+	for _, peer := range peers {
+		if peer.ID == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+// call sends a JSON request with an optional bearer token, checks the
+// status and decodes the response into out, if set
+func call(t *testing.T, baseURL, method, path, token string, body interface{}, want int, out interface{}) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("%s %s: failed to encode request: %v", method, path, err)
+		}
+		reader = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(method, baseURL+path, reader)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: failed to read response: %v", method, path, err)
+	}
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s: got %d, want %d: %s", method, path, resp.StatusCode, want, bytes.TrimSpace(content))
+	}
+	if out != nil {
+		if err := json.Unmarshal(content, out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+}
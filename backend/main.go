@@ -2,32 +2,65 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
+	"github.com/vpn-service/backend/api"
+	"github.com/vpn-service/backend/api/admin"
 	"github.com/vpn-service/backend/api/auth"
+	"github.com/vpn-service/backend/api/authz"
 	"github.com/vpn-service/backend/api/middleware"
+	"github.com/vpn-service/backend/api/nodes"
+	"github.com/vpn-service/backend/api/rpc"
+	"github.com/vpn-service/backend/api/servers"
+	"github.com/vpn-service/backend/api/status"
+	"github.com/vpn-service/backend/api/user"
 	"github.com/vpn-service/backend/api/vpn"
+	store "github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/alerting"
+	"github.com/vpn-service/backend/src/analytics"
 	"github.com/vpn-service/backend/src/config"
 	"github.com/vpn-service/backend/src/core"
-	"github.com/vpn-service/backend/src/db"
+	"github.com/vpn-service/backend/src/discovery"
+	"github.com/vpn-service/backend/src/email"
+	"github.com/vpn-service/backend/src/geo"
+	"github.com/vpn-service/backend/src/kubernetes"
 	"github.com/vpn-service/backend/src/monitoring"
+	"github.com/vpn-service/backend/src/storage"
+	"github.com/vpn-service/backend/src/systemd"
+	"github.com/vpn-service/backend/src/tracing"
 	"github.com/vpn-service/backend/src/utils"
 )
 
 func main() {
+	standalone := flag.Bool("standalone", false, "run the API, an embedded SQLite database, the node agent and WireGuard on this host")
+	flag.Parse()
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if *standalone {
+		cfg.ApplyStandalone()
+	}
+
+	// Verify the audit log and exit when run as "backend verify-audit"
+	if flag.Arg(0) == "verify-audit" {
+		verifyFlags := flag.NewFlagSet("verify-audit", flag.ExitOnError)
+		migrate := verifyFlags.Bool("migrate", false, "move a log chained without monitoring.auditKey aside and start a keyed chain")
+		verifyFlags.Parse(flag.Args()[1:])
+		os.Exit(verifyAuditLog(cfg, *migrate))
+	}
 
 	// Initialize logger
 	if err := utils.InitLogger(cfg.Monitoring.LogDir); err != nil {
@@ -35,79 +68,382 @@ func main() {
 	}
 	defer utils.CloseLogger()
 
+	// Write analytics events to the compressed store instead of the JSON
+	// lines log
+	var analyticsStore *analytics.Store
+	if cfg.Monitoring.AnalyticsStore.Enabled {
+		analyticsStore, err = analytics.Open(analytics.Options{
+			Dir:           cfg.Monitoring.AnalyticsStore.Dir,
+			BatchSize:     cfg.Monitoring.AnalyticsStore.BatchSize,
+			FlushInterval: time.Duration(cfg.Monitoring.AnalyticsStore.FlushInterval) * time.Second,
+			SegmentSize:   int64(cfg.Monitoring.AnalyticsStore.SegmentSize) << 20,
+		})
+		if err != nil {
+			utils.LogFatal("Failed to open analytics store: %v", err)
+		}
+		utils.SetAnalyticsSink(analyticsStore)
+		defer analyticsStore.Close()
+	}
+
+	// Apply the deployment-level telemetry default
+	utils.SetTelemetryDefault(cfg.Monitoring.TelemetryMode != "opt-in")
+
+	// Initialize error reporting
+	flushErrorReports, err := monitoring.InitErrorReporting(cfg)
+	if err != nil {
+		utils.LogFatal("Failed to initialize error reporting: %v", err)
+	}
+	defer flushErrorReports(2 * time.Second)
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(cfg)
+	if err != nil {
+		utils.LogFatal("Failed to initialize tracing: %v", err)
+	}
+
 	// Initialize database
-	if err := db.Initialize(cfg.Database); err != nil {
-		utils.LogFatal("Failed to initialize database: %v", err)
+	if *standalone {
+		// Standalone mode keeps everything in an embedded SQLite file
+		if err := store.Connect(cfg); err != nil {
+			utils.LogFatal("Failed to open database: %v", err)
+		}
+		defer store.Close()
+
+		if err := store.RunSQLiteMigrations(); err != nil {
+			utils.LogFatal("Failed to run migrations: %v", err)
+		}
+	} else {
+		if err := store.Connect(cfg); err != nil {
+			utils.LogFatal("Failed to initialize database: %v", err)
+		}
+		defer store.Close()
+
+		// Run migrations
+		if err := store.NewMigrationManager(cfg, store.DB.DB).RunMigrations(); err != nil {
+			utils.LogFatal("Failed to run migrations: %v", err)
+		}
 	}
-	defer db.Close()
 
-	// Run migrations
-	if err := db.RunMigrations(); err != nil {
-		utils.LogFatal("Failed to run migrations: %v", err)
+	// Initialize email delivery for invites and alerts
+	mailer, err := email.NewMailer(context.Background(), cfg.Email)
+	if err != nil {
+		utils.LogFatal("Failed to initialize email delivery: %v", err)
 	}
 
 	// Initialize metrics collector
 	metricsCollector := monitoring.NewCollector(cfg)
 	monitoring.MetricsCollector = metricsCollector
 	metricsCollector.StartMetricsServer()
+	if store.DB != nil {
+		metricsCollector.RegisterDatabase(store.DB.DB, cfg.Database.Driver)
+	}
+
+	// Send alerts to the configured channels
+	dispatcher, err := alerting.NewDispatcher(cfg.Monitoring.Alerts, mailer)
+	if err != nil {
+		utils.LogFatal("Failed to set up alert channels: %v", err)
+	}
+	admin.Alerts = dispatcher
+
+	// Alert on deviations in connect errors, apply latency and auth failures
+	metricsCollector.Anomalies().SetDispatcher(dispatcher)
+	if cfg.Monitoring.Anomaly.Enabled {
+		go metricsCollector.Anomalies().Run()
+	}
 
 	// Initialize managers
 	serverManager := core.NewServerManager(cfg)
 	vpnManager := core.NewVPNManager(cfg, serverManager)
+	vpnManager.SetApplyObserver(metricsCollector.ObservePeerApply)
+	vpnManager.SetApplyLatencyObserver(metricsCollector.ObserveConnectApplyLatency)
+	serverManager.SetDriftObserver(metricsCollector.ObserveDrift)
+	serverManager.Uptime().SetObserver(metricsCollector.ObserveServerUptime)
+	if analyticsStore != nil {
+		vpnManager.SetAnalyticsStore(analyticsStore)
+	}
 
-	// Set VPN manager for API handlers
+	// Publish server status changes, connections and errors for dashboards
+	events := core.NewEventBus()
+	serverManager.SetEventBus(events)
+	vpnManager.SetEventBus(events)
+	utils.AddErrorListener(func(report *utils.ErrorReport) {
+		events.Publish(core.EventError, core.ErrorEvent{Message: report.Message, RequestID: report.RequestID})
+	})
+	admin.Events = events
+
+	// Run long admin operations as background jobs clients can poll
+	jobs := core.NewJobManager(cfg)
+	admin.Jobs = jobs
+	go jobs.RunCleanup()
+
+	// Export the depth of internal queues with the runtime metrics
+	metricsCollector.RegisterQueue("node_commands", serverManager.NodeCommands().Pending)
+	metricsCollector.RegisterQueue("event_subscribers", events.Backlog)
+	metricsCollector.RegisterQueue("running_jobs", func() int {
+		return len(jobs.ListJobs(core.JobFilter{Status: core.JobStatusRunning}))
+	})
+	if analyticsStore != nil {
+		metricsCollector.RegisterQueue("analytics_store", analyticsStore.Pending)
+	}
+
+	// Locate servers added by IP
+	if cfg.Geo.CityDatabase != "" {
+		locator, err := geo.Open(cfg.Geo)
+		if err != nil {
+			utils.LogWarning("Geo lookups disabled: %v", err)
+		} else {
+			serverManager.SetGeoLocator(locator)
+			defer locator.Close()
+		}
+	}
+
+	// Set managers for API handlers
 	vpn.VPNManager = vpnManager
+	vpn.StatusInterval = time.Duration(cfg.API.StatusInterval) * time.Second
+	vpn.ServerListMaxAge = time.Duration(cfg.API.ServerListMaxAge) * time.Second
+	nodes.ServerManager = serverManager
+	nodes.VPNManager = vpnManager
+	servers.ServerManager = serverManager
+	admin.ServerManager = serverManager
+	admin.VPNManager = vpnManager
+	admin.ConfigAuditLog = vpnManager.ConfigAuditLog()
+	admin.FunnelTracker = vpnManager.Funnel()
+	admin.Entitlements = vpnManager.Entitlements()
+	admin.RoutingPresets = vpnManager.RoutingPresets()
+	admin.AccountMerges = vpnManager.AccountMerges()
+	admin.DeviceKeys = vpnManager.DeviceKeys()
+	admin.Shadow = vpnManager.Shadow()
+	admin.AnalyticsReports = vpnManager.Analytics()
+	status.StatusPage = serverManager.StatusPage()
+	status.Config = cfg.StatusPage
+
+	// Initialize JWT signing keys
+	signingKeys, err := core.NewSigningKeyManager(cfg)
+	if err != nil {
+		utils.LogFatal("Failed to initialize signing keys: %v", err)
+	}
+	middleware.SigningKeys = signingKeys
+	admin.SigningKeys = signingKeys
+
+	// Record registrations in the conversion funnel
+	auth.FunnelTracker = vpnManager.Funnel()
+
+	// Initialize plan entitlements
+	middleware.Entitlements = vpnManager.Entitlements()
+
+	// Initialize user management and two-factor authentication for step-up
+	// verification
+	userManager := core.NewUserManager(cfg)
+	userManager.SetMailer(mailer)
+	vpnManager.SetUserManager(userManager)
+	vpnManager.SetMailer(mailer)
+
+	// Accepted invites count as verified in the conversion funnel
+	userManager.SetFunnelTracker(vpnManager.Funnel())
+	auth.UserManager = userManager
+	auth.MFA = userManager.MFA()
+	user.UserManager = userManager
+	user.Entitlements = vpnManager.Entitlements()
+	user.Push = vpnManager.Push()
+	admin.UserManager = userManager
+
+	// Initialize audit log
+	auditLog := core.NewAuditLog(cfg)
+	if auditLog.NeedsMigration() {
+		utils.LogFatal("The audit log is chained without monitoring.auditKey; run backend verify-audit --migrate with the API stopped")
+	}
+	middleware.AuditLog = auditLog
+	admin.AuditLog = auditLog
+
+	// Initialize token denylist
+	tokenDenylist := core.NewTokenDenylist(cfg)
+	middleware.TokenDenylist = tokenDenylist
+	admin.TokenDenylist = tokenDenylist
+	go tokenDenylist.RunCleanup()
+
+	// Initialize rate limiter
+	rateLimiter := core.NewRateLimiter(cfg)
+	middleware.RateLimiter = rateLimiter
+	go rateLimiter.RunCleanup()
+
+	// Start server monitoring in background. In standalone mode this host is
+	// the only server, and the built-in node agent reports its state.
+	if *standalone {
+		serverManager.UseLocalServer()
+		if err := vpnManager.SetupLocalInterface(context.Background()); err != nil {
+			utils.LogFatal("Failed to set up WireGuard: %v", err)
+		}
+		defer vpnManager.TeardownLocalInterface(context.Background())
+		go vpnManager.RunLocalAgent()
+		go vpnManager.RunDriftReconciler()
+		utils.LogInfo("Running standalone with data in %s", cfg.Standalone.DataDir)
+	} else {
+		go serverManager.MonitorServers()
+	}
+
+	// Advance staged agent rollouts and rolling node upgrades in background
+	go serverManager.Rollouts().RunRollouts()
+	go serverManager.Upgrades().RunUpgrades()
+
+	// Issue and renew the wildcard node certificate in background
+	if cfg.Certificates.Enabled {
+		certificates, err := core.NewCertificateManager(cfg)
+		if err != nil {
+			utils.LogFatal("Failed to initialize node certificates: %v", err)
+		}
+		serverManager.SetCertificateManager(certificates)
+		admin.Certificates = certificates
+		go certificates.RunRenewals()
+	}
+
+	// Issue node certificates to agents on the agent listener
+	if cfg.GRPC.Agents.Enabled {
+		agentCA, err := core.NewAgentCA(cfg)
+		if err != nil {
+			utils.LogFatal("Failed to initialize agent CA: %v", err)
+		}
+		serverManager.SetAgentCA(agentCA)
+	}
+
+	// Renew or expire dynamic peer sessions in background
+	go vpnManager.RunSessionSweeper()
+
+	// Notify about and archive idle devices in background
+	go vpnManager.RunInactivitySweeper()
+
+	// Forget used and expired config share links
+	go vpnManager.ConfigShares().RunCleanup()
+
+	// Notify mobile devices of session events in background
+	go vpnManager.Push().Run(events)
+
+	// Announce config changes of mesh members as devices come and go
+	go vpnManager.RunMeshUpdates(events)
 
-	// Start server monitoring in background
-	go serverManager.MonitorServers()
+	// Move the peers of servers that stay offline to healthy servers
+	go vpnManager.RunPeerMigration(events)
+
+	// Move the peers of draining servers over their window
+	go vpnManager.RunDrains()
+
+	// Export server uptime for Prometheus
+	go serverManager.Uptime().RunMetrics()
+
+	// Create and tear down servers provisioned on cloud providers
+	go serverManager.Provisioning().RunProvisioning()
+
+	// Scale regions with provisioned servers as their load changes
+	go serverManager.Autoscaler().RunAutoscaling()
+
+	// Alert on regions running out of capacity and servers staying offline
+	serverManager.Alerts().SetDispatcher(dispatcher)
+	go serverManager.Alerts().RunAlerts()
+
+	// Source servers from service discovery instead of the built-in list
+	if cfg.Discovery.Backend != "" {
+		backend, err := discovery.New(cfg.Discovery)
+		if err != nil {
+			utils.LogFatal("Failed to set up service discovery: %v", err)
+		}
+		go serverManager.RunDiscovery(backend)
+	}
+
+	// Reconcile servers and peers from Kubernetes custom resources
+	if cfg.Kubernetes.Enabled {
+		if cfg.Discovery.Backend != "" {
+			utils.LogFatal("Kubernetes mode and service discovery cannot both source servers")
+		}
+		client, err := kubernetes.NewClient(cfg.Kubernetes)
+		if err != nil {
+			utils.LogFatal("Failed to set up Kubernetes mode: %v", err)
+		}
+		go core.NewKubernetesController(cfg, vpnManager, client).Run()
+	}
+
+	// Store generated artifacts and delete them after their lifecycle
+	artifactStore, err := storage.New(context.Background(), cfg.Storage, cfg.API.PublicURL)
+	if err != nil {
+		utils.LogWarning("Artifact storage disabled: %v", err)
+	} else {
+		vpnManager.SetStorage(artifactStore)
+		go storage.NewLifecycle(artifactStore, cfg.Storage.Lifecycle).Run(context.Background(), time.Duration(cfg.Storage.CleanupInterval)*time.Minute)
+	}
 
 	// Initialize router
-	router := mux.NewRouter()
-
-	// Set up middleware
-	router.Use(middleware.LoggingMiddleware)
-	router.Use(middleware.MetricsMiddleware)
-
-	// Public routes
-	router.HandleFunc("/api/health", healthCheckHandler).Methods("GET")
-	
-	// Auth routes
-	authRouter := router.PathPrefix("/api/auth").Subrouter()
-	auth.RegisterRoutes(authRouter)
-
-	// VPN routes (protected)
-	vpnRouter := router.PathPrefix("/api/vpn").Subrouter()
-	vpnRouter.Use(middleware.JWTAuthMiddleware)
-	vpn.RegisterRoutes(vpnRouter)
-
-	// Set up CORS
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
-		AllowCredentials: true,
-		MaxAge:           86400,
-	})
-	handler := c.Handler(router)
+	router, handler, err := api.NewRouter(cfg)
+	if err != nil {
+		utils.LogFatal("Failed to set up API routes: %v", err)
+	}
+
+	// Refuse to start with routes that have no expected authorization
+	missing, err := authz.Missing(router)
+	if err != nil {
+		utils.LogFatal("Failed to check route authorization: %v", err)
+	}
+	if len(missing) > 0 {
+		utils.LogFatal("Routes without an authorization rule in api/authz: %s", strings.Join(missing, ", "))
+	}
+
+	// Exercise the authorization matrix and exit when run as "backend check-authz"
+	if flag.Arg(0) == "check-authz" {
+		os.Exit(checkAuthorization(cfg, router, handler, signingKeys))
+	}
 
 	// Create server
-	utils.LogInfo("Starting API server on %s", cfg.APIAddr)
-	srv := &http.Server{
-		Addr:         cfg.APIAddr,
-		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	utils.LogInfo("Starting API server on %s", api.ListenAddr(cfg))
+	listener, err := api.Listen(cfg)
+	if err != nil {
+		utils.LogFatal("Failed to listen on %s: %v", api.ListenAddr(cfg), err)
 	}
+	srv := api.NewHTTPServer(cfg, handler)
 
 	// Start server
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
 			utils.LogError("Failed to start server: %v", err)
 			os.Exit(1)
 		}
 	}()
 
+	// Start gRPC control-plane server
+	var grpcServer *rpc.Server
+	if cfg.GRPC.Enabled {
+		grpcServer, err = rpc.NewServer(cfg, vpnManager, serverManager, userManager)
+		if err != nil {
+			utils.LogFatal("Failed to initialize gRPC server: %v", err)
+		}
+		go func() {
+			if err := grpcServer.Start(); err != nil {
+				utils.LogError("Failed to start gRPC server: %v", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Start gRPC agent server
+	var agentServer *rpc.AgentServer
+	if agentCA := serverManager.AgentCA(); agentCA != nil {
+		agentServer, err = rpc.NewAgentServer(cfg, vpnManager, serverManager, agentCA)
+		if err != nil {
+			utils.LogFatal("Failed to initialize gRPC agent server: %v", err)
+		}
+		go func() {
+			if err := agentServer.Start(); err != nil {
+				utils.LogError("Failed to start gRPC agent server: %v", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Tell systemd the API is accepting connections and keep its watchdog fed
+	stopWatchdog := make(chan struct{})
+	if cfg.Server.SystemdNotify {
+		if err := systemd.Notify(systemd.StateReady); err != nil {
+			utils.LogWarning("Failed to notify systemd: %v", err)
+		}
+		go systemd.RunWatchdog(stopWatchdog)
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -115,18 +451,112 @@ func main() {
 
 	// Shutdown server
 	utils.LogInfo("Shutting down server...")
+	close(stopWatchdog)
+	if cfg.Server.SystemdNotify {
+		systemd.Notify(systemd.StateStopping)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
 		utils.LogError("Server shutdown failed: %v", err)
 	}
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+	if agentServer != nil {
+		agentServer.Stop()
+	}
+
+	// Flush pending spans
+	if err := shutdownTracing(ctx); err != nil {
+		utils.LogError("Tracing shutdown failed: %v", err)
+	}
 
 	utils.LogInfo("Server shutdown complete")
 }
 
-func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"healthy","version":"1.0.0"}`))
+// verifyAuditLog checks the audit log hash chain, after migrating a log
+// chained without the audit key if asked to, and returns the exit code
+func verifyAuditLog(cfg *config.Config, migrate bool) int {
+	auditLog := core.NewAuditLog(cfg)
+	if migrate {
+		rotated, err := auditLog.Migrate()
+		if err != nil {
+			fmt.Printf("Audit log migration failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Moved the audit log chained without monitoring.auditKey to %s\n", rotated)
+	}
+
+	result := auditLog.Verify()
+	if !result.Valid {
+		if result.BrokenAt > 0 {
+			fmt.Printf("Audit log verification failed at entry %d: %s\n", result.BrokenAt, result.Error)
+		} else {
+			fmt.Printf("Audit log verification failed: %s\n", result.Error)
+		}
+		return 1
+	}
+
+	fmt.Printf("Audit log is intact: %d entries, head %s\n", result.Entries, result.HeadHash)
+	return 0
+}
+
+// checkAuthorization calls every route as the callers its authorization rule
+// denies and returns the exit code
+func checkAuthorization(cfg *config.Config, router *mux.Router, handler http.Handler, signingKeys *core.SigningKeyManager) int {
+	// Sign short-lived tokens for a user and an admin that do not exist
+	sign := func(userID, role string) (string, error) {
+		now := time.Now()
+		return signingKeys.Sign(jwt.MapClaims{
+			"id":   userID,
+			"role": role,
+			"iss":  cfg.JWT.Issuer,
+			"aud":  cfg.JWT.Audience,
+			"iat":  now.Unix(),
+			"nbf":  now.Unix(),
+			"exp":  now.Add(5 * time.Minute).Unix(),
+			"jti":  utils.GenerateUUID(),
+		})
+	}
+	userToken, err := sign("authz-check-user", "user")
+	if err != nil {
+		fmt.Printf("Failed to sign user token: %v\n", err)
+		return 1
+	}
+	adminToken, err := sign("authz-check-admin", "admin")
+	if err != nil {
+		fmt.Printf("Failed to sign admin token: %v\n", err)
+		return 1
+	}
+
+	missing, err := authz.Missing(router)
+	if err != nil {
+		fmt.Printf("Authorization check failed: %v\n", err)
+		return 1
+	}
+	violations, err := authz.Exercise(router, handler, authz.Callers{
+		User:      userToken,
+		OtherUser: "authz-check-other",
+		Admin:     adminToken,
+		Node:      cfg.Nodes.AgentToken,
+	})
+	if err != nil {
+		fmt.Printf("Authorization check failed: %v\n", err)
+		return 1
+	}
+
+	for _, route := range missing {
+		fmt.Printf("%s: no authorization rule\n", route)
+	}
+	for _, violation := range violations {
+		fmt.Printf("%s: %s caller got %d\n", violation.Route, violation.Caller, violation.Status)
+	}
+	if len(missing) > 0 || len(violations) > 0 {
+		return 1
+	}
+
+	fmt.Println("Every route enforces its authorization rule")
+	return 0
 }
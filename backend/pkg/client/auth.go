@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/vpn-service/backend/api/auth"
+	"github.com/vpn-service/backend/src/core"
+)
+
+// Register creates an account and logs in with it
+func (c *Client) Register(ctx context.Context, req auth.RegisterRequest) (*auth.AuthResponse, error) {
+	var resp auth.AuthResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/register", body: req, public: true}, &resp); err != nil {
+		return nil, err
+	}
+	c.setToken(resp.Token)
+	return &resp, nil
+}
+
+// Login logs in, and the client uses the token for later calls
+func (c *Client) Login(ctx context.Context, username, password string) (*auth.AuthResponse, error) {
+	req := auth.LoginRequest{Username: username, Password: password}
+
+	var resp auth.AuthResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/login", body: req, public: true}, &resp); err != nil {
+		return nil, err
+	}
+	c.setToken(resp.Token)
+	return &resp, nil
+}
+
+// AcceptInvite sets the password of an imported account and logs in with it
+func (c *Client) AcceptInvite(ctx context.Context, token, password string) (*auth.AuthResponse, error) {
+	req := auth.AcceptInviteRequest{Token: token, Password: password}
+
+	var resp auth.AuthResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/invite/accept", body: req, public: true}, &resp); err != nil {
+		return nil, err
+	}
+	c.setToken(resp.Token)
+	return &resp, nil
+}
+
+// Refresh exchanges the token for a new one. Calls refresh the token on
+// their own when it is about to expire.
+func (c *Client) Refresh(ctx context.Context) (*auth.AuthResponse, error) {
+	var resp auth.AuthResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/refresh", refresh: true}, &resp); err != nil {
+		return nil, err
+	}
+	c.setToken(resp.Token)
+	return &resp, nil
+}
+
+// Logout revokes the token
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/logout"}, nil); err != nil {
+		return err
+	}
+	c.setToken("")
+	c.SetStepUpToken("", time.Time{})
+	return nil
+}
+
+// EnrollMFA starts two-factor enrollment and gets the secret to add to an
+// authenticator app
+func (c *Client) EnrollMFA(ctx context.Context) (*core.MFAEnrollment, error) {
+	var enrollment core.MFAEnrollment
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/mfa/enroll"}, &enrollment); err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// ConfirmMFA enables two-factor authentication with a code of the
+// authenticator app
+func (c *Client) ConfirmMFA(ctx context.Context, code string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/auth/mfa/confirm", body: auth.MFACodeRequest{Code: code}}, nil)
+}
+
+// StepUp verifies a code of the authenticator app for a step-up token, which
+// the client sends with later calls until it expires
+func (c *Client) StepUp(ctx context.Context, code string) (*auth.StepUpResponse, error) {
+	var resp auth.StepUpResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/auth/step-up", body: auth.MFACodeRequest{Code: code}}, &resp); err != nil {
+		return nil, err
+	}
+	c.SetStepUpToken(resp.Token, resp.ExpiresAt)
+	return &resp, nil
+}
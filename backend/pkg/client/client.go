@@ -0,0 +1,377 @@
+// Package client is a typed client of the public API of the VPN service,
+// for internal tools and the CLI. It keeps the bearer token fresh, retries
+// requests that failed transiently with backoff, and takes a context on
+// every call.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiPrefix is the path of the API version the client speaks
+const apiPrefix = "/api/v1"
+
+// stepUpHeader carries the step-up token, as middleware.StepUpHeader
+const stepUpHeader = "X-Step-Up-Token"
+
+// Options controls authentication and retries of a client
+type Options struct {
+	HTTPClient *http.Client // http.Client with a 30 second timeout if nil
+	Token      string       // bearer token of an earlier login, if any
+	UserAgent  string
+
+	MaxRetries int           // retries of a failed request, 3 by default, negative for none
+	MinBackoff time.Duration // wait before the first retry, 200ms by default
+	MaxBackoff time.Duration // longest wait between retries, 10s by default
+
+	// RefreshBefore is how long before its expiry the token is refreshed,
+	// 5 minutes by default
+	RefreshBefore time.Duration
+
+	// OnToken is called with every new token, e.g. to persist it
+	OnToken func(token string)
+}
+
+// Client calls the API of a VPN service deployment. It is safe for
+// concurrent use.
+type Client struct {
+	baseURL string
+	opts    Options
+
+	token         string
+	expiresAt     time.Time // zero if the token has no readable expiry
+	stepUp        string
+	stepUpExpires time.Time
+	mutex         sync.RWMutex
+
+	refreshMutex sync.Mutex // lets a single refresh run at a time
+}
+
+// New creates a client of the deployment at baseURL, e.g.
+// https://vpn.example.com
+func New(baseURL string, opts Options) (*Client, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid base URL: %q", baseURL)
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = "vpn-service-client"
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	} else if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 200 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 10 * time.Second
+	}
+	if opts.RefreshBefore <= 0 {
+		opts.RefreshBefore = 5 * time.Minute
+	}
+
+	c := &Client{
+		baseURL: strings.TrimRight(parsed.String(), "/") + apiPrefix,
+		opts:    opts,
+	}
+	c.setToken(opts.Token)
+
+	return c, nil
+}
+
+// Token gets the current bearer token
+func (c *Client) Token() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.token
+}
+
+// SetToken sets the bearer token, e.g. one issued to a service account
+func (c *Client) SetToken(token string) {
+	c.setToken(token)
+}
+
+// setToken stores a token and its expiry and reports it to OnToken
+func (c *Client) setToken(token string) {
+	c.mutex.Lock()
+	c.token = token
+	c.expiresAt = tokenExpiry(token)
+	c.mutex.Unlock()
+
+	if token != "" && c.opts.OnToken != nil {
+		c.opts.OnToken(token)
+	}
+}
+
+// SetStepUpToken sets the step-up token sent with every request until it
+// expires. StepUp sets it on its own.
+func (c *Client) SetStepUpToken(token string, expiresAt time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.stepUp = token
+	c.stepUpExpires = expiresAt
+}
+
+// request describes a call to the API
+type request struct {
+	method  string
+	path    string
+	query   url.Values
+	body    interface{} // encoded as JSON if set
+	public  bool        // sent without the bearer token
+	refresh bool        // the token refresh itself
+}
+
+// do sends a request and decodes the JSON response into out, if set
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response of %s %s: %v", req.method, req.path, err)
+	}
+	return nil
+}
+
+// download sends a request and reads a file response, with its content type
+func (c *Client) download(ctx context.Context, req request) ([]byte, string, error) {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response of %s %s: %v", req.method, req.path, err)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// send sends a request, retrying transient failures, and returns the
+// successful response. Error responses are returned as *APIError.
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, fmt.Errorf("failed to encode request of %s %s: %v", req.method, req.path, err)
+		}
+	}
+
+	if !req.public && !req.refresh {
+		c.refreshIfExpiring(ctx)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, req, body)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		var retryAfter time.Duration
+		if err != nil {
+			if ctx.Err() != nil || attempt >= c.opts.MaxRetries || !retryableError(req.method, err) {
+				return nil, fmt.Errorf("%s %s failed: %v", req.method, req.path, err)
+			}
+		} else {
+			apiErr := decodeError(resp)
+			if attempt >= c.opts.MaxRetries || !retryableStatus(req.method, resp.StatusCode) {
+				return nil, apiErr
+			}
+			retryAfter = apiErr.RetryAfter
+		}
+
+		if err := c.wait(ctx, attempt, retryAfter); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt sends a request once
+func (c *Client) attempt(ctx context.Context, req request, body []byte) (*http.Response, error) {
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("User-Agent", c.opts.UserAgent)
+
+	if !req.public {
+		c.mutex.RLock()
+		if c.token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if c.stepUp != "" && time.Now().Before(c.stepUpExpires) {
+			httpReq.Header.Set(stepUpHeader, c.stepUp)
+		}
+		c.mutex.RUnlock()
+	}
+
+	return c.opts.HTTPClient.Do(httpReq)
+}
+
+// wait sleeps before a retry, exponentially longer with every attempt and
+// with jitter so clients do not retry in lockstep. A Retry-After of the
+// server takes precedence.
+func (c *Client) wait(ctx context.Context, attempt int, retryAfter time.Duration) error {
+	delay := retryAfter
+	if delay <= 0 {
+		backoff := c.opts.MinBackoff << uint(attempt)
+		if backoff <= 0 || backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+		delay = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// refreshIfExpiring refreshes the token when it expires soon. A token that
+// fails to refresh is still used until it expires.
+func (c *Client) refreshIfExpiring(ctx context.Context) {
+	if !c.expiring() {
+		return
+	}
+
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+
+	// Another call may have refreshed it meanwhile
+	if !c.expiring() {
+		return
+	}
+	c.Refresh(ctx)
+}
+
+// expiring reports whether the token expires within RefreshBefore
+func (c *Client) expiring() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.token == "" || c.expiresAt.IsZero() {
+		return false
+	}
+	remaining := time.Until(c.expiresAt)
+	return remaining > 0 && remaining < c.opts.RefreshBefore
+}
+
+// retryableStatus reports whether a response status is worth retrying.
+// Requests rejected by rate limiting or an unavailable server were not
+// handled, so any method is retried; gateway errors are retried only for
+// idempotent methods, as the request may have been handled.
+func retryableStatus(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(method)
+	default:
+		return false
+	}
+}
+
+// retryableError reports whether a transport error is worth retrying. A
+// request that failed to connect never reached the server, so any method
+// is retried.
+func retryableError(method string, err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return idempotent(method)
+}
+
+// idempotent reports whether a request can be repeated safely
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// tokenExpiry reads the expiry of a JWT without verifying it, or returns
+// the zero time if it has none
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FieldError is an invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// APIError is an error response of the API
+type APIError struct {
+	StatusCode int
+	Message    string
+	RequestID  string       // quote it in reports
+	Fields     []FieldError // invalid fields of a bad request
+
+	// RetryAfter is how long the server asked to wait before retrying
+	RetryAfter time.Duration
+
+	stepUp bool
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+	if e.RequestID != "" {
+		return fmt.Sprintf("%d %s (request %s)", e.StatusCode, message, e.RequestID)
+	}
+	return fmt.Sprintf("%d %s", e.StatusCode, message)
+}
+
+// StepUpRequired reports whether the request needs a fresh two-factor
+// verification, see StepUp
+func (e *APIError) StepUpRequired() bool {
+	return e.stepUp
+}
+
+// IsStepUpRequired reports whether err is an error response asking for a
+// step-up token
+func IsStepUpRequired(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StepUpRequired()
+}
+
+// IsNotFound reports whether err is a not found error response
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// decodeError reads an error response and closes its body
+func decodeError(resp *http.Response) *APIError {
+	defer resp.Body.Close()
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		stepUp: resp.StatusCode == http.StatusUnauthorized &&
+			strings.Contains(resp.Header.Get("WWW-Authenticate"), "insufficient_user_authentication"),
+	}
+
+	var payload struct {
+		Error     string       `json:"error"`
+		RequestID string       `json:"requestId"`
+		Fields    []FieldError `json:"fields"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(body, &payload); err == nil {
+		apiErr.Message = payload.Error
+		apiErr.RequestID = payload.RequestID
+		apiErr.Fields = payload.Fields
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get("X-Request-ID")
+	}
+
+	return apiErr
+}
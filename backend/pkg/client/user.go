@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/vpn-service/backend/api/user"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/core"
+)
+
+// Defaults gets the account defaults for new devices
+func (c *Client) Defaults(ctx context.Context) (*models.DeviceDefaults, error) {
+	var defaults models.DeviceDefaults
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/user/defaults"}, &defaults); err != nil {
+		return nil, err
+	}
+	return &defaults, nil
+}
+
+// SetDefaults sets the account defaults for new devices
+func (c *Client) SetDefaults(ctx context.Context, defaults models.DeviceDefaults) (*models.DeviceDefaults, error) {
+	var updated models.DeviceDefaults
+	if err := c.do(ctx, request{method: http.MethodPut, path: "/user/defaults", body: defaults}, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// Privacy gets the telemetry preference
+func (c *Client) Privacy(ctx context.Context) (*core.PrivacySettings, error) {
+	var settings core.PrivacySettings
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/user/privacy"}, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetTelemetry opts in or out of identifiable telemetry, or reverts to the
+// deployment default if enabled is nil
+func (c *Client) SetTelemetry(ctx context.Context, enabled *bool) (*core.PrivacySettings, error) {
+	var settings core.PrivacySettings
+	if err := c.do(ctx, request{method: http.MethodPatch, path: "/user/privacy", body: user.PrivacyRequest{TelemetryEnabled: enabled}}, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// Plan gets the current plan and its entitlements
+func (c *Client) Plan(ctx context.Context) (*user.PlanResponse, error) {
+	var plan user.PlanResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/user/plan"}, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// PushDevices lists the devices registered for push notifications
+func (c *Client) PushDevices(ctx context.Context) ([]models.PushDevice, error) {
+	var devices []models.PushDevice
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/user/push-devices"}, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// RegisterPushDevice registers an FCM or APNs device token
+func (c *Client) RegisterPushDevice(ctx context.Context, req user.PushDeviceRequest) (*models.PushDevice, error) {
+	var device models.PushDevice
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/user/push-devices", body: req}, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// UnregisterPushDevice unregisters a push device
+func (c *Client) UnregisterPushDevice(ctx context.Context, deviceID string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/user/push-devices/" + url.PathEscape(deviceID)}, nil)
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/vpn-service/backend/api/vpn"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/core"
+)
+
+// QROptions controls the QR code of a device config
+type QROptions struct {
+	Size  int    // pixels, the server default if 0
+	Level string // error correction: low, medium, high or highest
+}
+
+// Servers lists the available servers
+func (c *Client) Servers(ctx context.Context) ([]vpn.Server, error) {
+	var servers []vpn.Server
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/vpn/servers"}, &servers); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// RoutingPresets lists the routing presets to pick at connect time
+func (c *Client) RoutingPresets(ctx context.Context) ([]models.RoutingPreset, error) {
+	var presets []models.RoutingPreset
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/vpn/routing-presets"}, &presets); err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+// DNSProfiles lists the DNS profiles to pick at connect time
+func (c *Client) DNSProfiles(ctx context.Context) ([]core.DNSProfile, error) {
+	var profiles []core.DNSProfile
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/vpn/dns-profiles"}, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// LatencyMatrix gets the latencies measured from servers to reference
+// probes, of the probes in a country if country is set
+func (c *Client) LatencyMatrix(ctx context.Context, country string) (*core.LatencyMatrixView, error) {
+	query := url.Values{}
+	if country != "" {
+		query.Set("country", country)
+	}
+
+	var matrix core.LatencyMatrixView
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/vpn/latency-matrix", query: query}, &matrix); err != nil {
+		return nil, err
+	}
+	return &matrix, nil
+}
+
+// Connect connects a device
+func (c *Client) Connect(ctx context.Context, req vpn.ConnectRequest) (*vpn.ConnectResponse, error) {
+	var resp vpn.ConnectResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/vpn/connect", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Disconnect disconnects a device
+func (c *Client) Disconnect(ctx context.Context, peerID string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/vpn/disconnect", body: vpn.DisconnectRequest{PeerID: peerID}}, nil)
+}
+
+// Reactivate reactivates a device archived for inactivity
+func (c *Client) Reactivate(ctx context.Context, peerID string) (*vpn.ConnectResponse, error) {
+	var resp vpn.ConnectResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/vpn/reactivate", body: vpn.ReactivateRequest{PeerID: peerID}}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DynamicConnect connects a device with a short-lived session
+func (c *Client) DynamicConnect(ctx context.Context, req vpn.ConnectRequest) (*vpn.ConnectResponse, error) {
+	var resp vpn.ConnectResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/vpn/dynamic/connect", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DynamicDisconnect ends the session of a dynamically connected device
+func (c *Client) DynamicDisconnect(ctx context.Context, peerID string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/vpn/dynamic/disconnect", body: vpn.DisconnectRequest{PeerID: peerID}}, nil)
+}
+
+// Status gets the connection status of the user's devices
+func (c *Client) Status(ctx context.Context) (*vpn.StatusResponse, error) {
+	var status vpn.StatusResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/vpn/status"}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Config gets the WireGuard config of a device
+func (c *Client) Config(ctx context.Context, peerID string) (string, error) {
+	query := url.Values{"peerId": {peerID}}
+
+	data, _, err := c.download(ctx, request{method: http.MethodGet, path: "/vpn/config", query: query})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// QRCode gets a PNG QR code of the config of a device
+func (c *Client) QRCode(ctx context.Context, peerID string, opts QROptions) ([]byte, error) {
+	query := url.Values{"peerId": {peerID}}
+	if opts.Size > 0 {
+		query.Set("size", strconv.Itoa(opts.Size))
+	}
+	if opts.Level != "" {
+		query.Set("level", opts.Level)
+	}
+
+	data, _, err := c.download(ctx, request{method: http.MethodGet, path: "/vpn/qr", query: query})
+	return data, err
+}
+
+// SetupSheet gets the printable PDF setup sheet of a device
+func (c *Client) SetupSheet(ctx context.Context, peerID string) ([]byte, error) {
+	data, _, err := c.download(ctx, request{method: http.MethodGet, path: "/vpn/peers/" + url.PathEscape(peerID) + "/setup.pdf"})
+	return data, err
+}
+
+// EmailConfig emails the config and QR code of a device to the user
+func (c *Client) EmailConfig(ctx context.Context, peerID string) error {
+	return c.do(ctx, request{method: http.MethodPost, path: "/vpn/config/email", body: vpn.EmailConfigRequest{PeerID: peerID}}, nil)
+}
+
+// ConfigShares lists the unused config share links
+func (c *Client) ConfigShares(ctx context.Context) ([]core.ConfigShare, error) {
+	var shares []core.ConfigShare
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/vpn/config/shares"}, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// ShareConfig creates a one-time link to download the config of a device
+func (c *Client) ShareConfig(ctx context.Context, req vpn.ShareConfigRequest) (*core.ConfigShare, error) {
+	var share core.ConfigShare
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/vpn/config/shares", body: req}, &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// RevokeConfigShare revokes a config share link
+func (c *Client) RevokeConfigShare(ctx context.Context, shareID string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/vpn/config/shares/" + url.PathEscape(shareID)}, nil)
+}
+
+// SharedConfig downloads the config behind a share link token, without
+// logging in. The link works once.
+func (c *Client) SharedConfig(ctx context.Context, token string) (string, error) {
+	data, _, err := c.download(ctx, request{method: http.MethodGet, path: "/config/shared/" + url.PathEscape(token), public: true})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SetRouting sets the networks a device routes through the tunnel
+func (c *Client) SetRouting(ctx context.Context, peerID string, req vpn.RoutingRequest) (*vpn.RoutingResponse, error) {
+	var resp vpn.RoutingResponse
+	if err := c.do(ctx, request{method: http.MethodPut, path: "/vpn/peers/" + url.PathEscape(peerID) + "/routing", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetMTU sets the MTU of a device
+func (c *Client) SetMTU(ctx context.Context, peerID string, req vpn.MTURequest) (*vpn.MTUResponse, error) {
+	var resp vpn.MTUResponse
+	if err := c.do(ctx, request{method: http.MethodPut, path: "/vpn/peers/" + url.PathEscape(peerID) + "/mtu", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SuggestMTU suggests a device MTU from a path MTU probe
+func (c *Client) SuggestMTU(ctx context.Context, req vpn.MTUSuggestRequest) (*core.MTUSuggestion, error) {
+	var suggestion core.MTUSuggestion
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/vpn/mtu/suggest", body: req}, &suggestion); err != nil {
+		return nil, err
+	}
+	return &suggestion, nil
+}
+
+// SetKeepalive sets the persistent keepalive of a device
+func (c *Client) SetKeepalive(ctx context.Context, peerID string, req vpn.KeepaliveRequest) (*vpn.KeepaliveResponse, error) {
+	var resp vpn.KeepaliveResponse
+	if err := c.do(ctx, request{method: http.MethodPut, path: "/vpn/peers/" + url.PathEscape(peerID) + "/keepalive", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Backup exports the user's devices as an encrypted backup
+func (c *Client) Backup(ctx context.Context, req vpn.BackupRequest) (*core.DeviceBackup, error) {
+	var backup core.DeviceBackup
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/vpn/backup", body: req}, &backup); err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+// Restore restores the devices of an encrypted backup
+func (c *Client) Restore(ctx context.Context, req vpn.RestoreRequest) (*core.DeviceRestoreSummary, error) {
+	var summary core.DeviceRestoreSummary
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/vpn/restore", body: req}, &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// StoreArtifacts stores the config, QR code and setup sheet of a device and
+// gets time-limited links to download them
+func (c *Client) StoreArtifacts(ctx context.Context, peerID string) (*core.StoredArtifacts, error) {
+	var artifacts core.StoredArtifacts
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/vpn/peers/" + url.PathEscape(peerID) + "/artifacts"}, &artifacts); err != nil {
+		return nil, err
+	}
+	return &artifacts, nil
+}
+
+// StoreArchive stores a zip archive of the configs of all of the user's
+// devices and gets a time-limited link to download it
+func (c *Client) StoreArchive(ctx context.Context) (*core.StoredArchive, error) {
+	var archive core.StoredArchive
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/vpn/archive"}, &archive); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// Artifact downloads a stored artifact from a signed link of the local
+// storage backend, with its content type. S3 links point at the bucket
+// and are downloaded with a plain HTTP client.
+func (c *Client) Artifact(ctx context.Context, link string) ([]byte, string, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return nil, "", err
+	}
+	path := parsed.Path
+	if i := strings.Index(path, apiPrefix+"/"); i >= 0 {
+		path = path[i+len(apiPrefix):]
+	}
+
+	return c.download(ctx, request{method: http.MethodGet, path: path, query: parsed.Query(), public: true})
+}
@@ -0,0 +1,371 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: vpn/v1/agent.proto
+
+package vpnv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	AgentService_Enroll_FullMethodName           = "/vpn.v1.AgentService/Enroll"
+	AgentService_RenewCertificate_FullMethodName = "/vpn.v1.AgentService/RenewCertificate"
+	AgentService_SyncPeers_FullMethodName        = "/vpn.v1.AgentService/SyncPeers"
+	AgentService_ReportStatus_FullMethodName     = "/vpn.v1.AgentService/ReportStatus"
+	AgentService_ExecuteCommands_FullMethodName  = "/vpn.v1.AgentService/ExecuteCommands"
+)
+
+// AgentServiceClient is the client API for AgentService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AgentServiceClient interface {
+	// Enroll exchanges an enrollment token and a certificate signing request
+	// for a node certificate.
+	Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error)
+	// RenewCertificate issues a new node certificate before the current one
+	// expires.
+	RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*EnrollResponse, error)
+	// SyncPeers streams the peers and policies of the node's server: the
+	// current state, unless the agent already has it, and then every change.
+	SyncPeers(ctx context.Context, in *SyncPeersRequest, opts ...grpc.CallOption) (AgentService_SyncPeersClient, error)
+	// ReportStatus streams the versions and health of the node. Each report
+	// is answered with the agent version to run and, when outdated, the
+	// wildcard node certificate.
+	ReportStatus(ctx context.Context, opts ...grpc.CallOption) (AgentService_ReportStatusClient, error)
+	// ExecuteCommands streams the commands queued for the node. The agent
+	// sends the result of each command it ran on the same stream.
+	ExecuteCommands(ctx context.Context, opts ...grpc.CallOption) (AgentService_ExecuteCommandsClient, error)
+}
+
+type agentServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAgentServiceClient(cc grpc.ClientConnInterface) AgentServiceClient {
+	return &agentServiceClient{cc}
+}
+
+func (c *agentServiceClient) Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error) {
+	out := new(EnrollResponse)
+	err := c.cc.Invoke(ctx, AgentService_Enroll_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) RenewCertificate(ctx context.Context, in *RenewCertificateRequest, opts ...grpc.CallOption) (*EnrollResponse, error) {
+	out := new(EnrollResponse)
+	err := c.cc.Invoke(ctx, AgentService_RenewCertificate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) SyncPeers(ctx context.Context, in *SyncPeersRequest, opts ...grpc.CallOption) (AgentService_SyncPeersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[0], AgentService_SyncPeers_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentServiceSyncPeersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AgentService_SyncPeersClient interface {
+	Recv() (*NodeState, error)
+	grpc.ClientStream
+}
+
+type agentServiceSyncPeersClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentServiceSyncPeersClient) Recv() (*NodeState, error) {
+	m := new(NodeState)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentServiceClient) ReportStatus(ctx context.Context, opts ...grpc.CallOption) (AgentService_ReportStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[1], AgentService_ReportStatus_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentServiceReportStatusClient{stream}
+	return x, nil
+}
+
+type AgentService_ReportStatusClient interface {
+	Send(*StatusReport) error
+	Recv() (*StatusResponse, error)
+	grpc.ClientStream
+}
+
+type agentServiceReportStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentServiceReportStatusClient) Send(m *StatusReport) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *agentServiceReportStatusClient) Recv() (*StatusResponse, error) {
+	m := new(StatusResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentServiceClient) ExecuteCommands(ctx context.Context, opts ...grpc.CallOption) (AgentService_ExecuteCommandsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[2], AgentService_ExecuteCommands_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentServiceExecuteCommandsClient{stream}
+	return x, nil
+}
+
+type AgentService_ExecuteCommandsClient interface {
+	Send(*CommandResult) error
+	Recv() (*NodeCommand, error)
+	grpc.ClientStream
+}
+
+type agentServiceExecuteCommandsClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentServiceExecuteCommandsClient) Send(m *CommandResult) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *agentServiceExecuteCommandsClient) Recv() (*NodeCommand, error) {
+	m := new(NodeCommand)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AgentServiceServer is the server API for AgentService service.
+// All implementations must embed UnimplementedAgentServiceServer
+// for forward compatibility
+type AgentServiceServer interface {
+	// Enroll exchanges an enrollment token and a certificate signing request
+	// for a node certificate.
+	Enroll(context.Context, *EnrollRequest) (*EnrollResponse, error)
+	// RenewCertificate issues a new node certificate before the current one
+	// expires.
+	RenewCertificate(context.Context, *RenewCertificateRequest) (*EnrollResponse, error)
+	// SyncPeers streams the peers and policies of the node's server: the
+	// current state, unless the agent already has it, and then every change.
+	SyncPeers(*SyncPeersRequest, AgentService_SyncPeersServer) error
+	// ReportStatus streams the versions and health of the node. Each report
+	// is answered with the agent version to run and, when outdated, the
+	// wildcard node certificate.
+	ReportStatus(AgentService_ReportStatusServer) error
+	// ExecuteCommands streams the commands queued for the node. The agent
+	// sends the result of each command it ran on the same stream.
+	ExecuteCommands(AgentService_ExecuteCommandsServer) error
+	mustEmbedUnimplementedAgentServiceServer()
+}
+
+// UnimplementedAgentServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedAgentServiceServer struct {
+}
+
+func (UnimplementedAgentServiceServer) Enroll(context.Context, *EnrollRequest) (*EnrollResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Enroll not implemented")
+}
+func (UnimplementedAgentServiceServer) RenewCertificate(context.Context, *RenewCertificateRequest) (*EnrollResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewCertificate not implemented")
+}
+func (UnimplementedAgentServiceServer) SyncPeers(*SyncPeersRequest, AgentService_SyncPeersServer) error {
+	return status.Errorf(codes.Unimplemented, "method SyncPeers not implemented")
+}
+func (UnimplementedAgentServiceServer) ReportStatus(AgentService_ReportStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReportStatus not implemented")
+}
+func (UnimplementedAgentServiceServer) ExecuteCommands(AgentService_ExecuteCommandsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExecuteCommands not implemented")
+}
+func (UnimplementedAgentServiceServer) mustEmbedUnimplementedAgentServiceServer() {}
+
+// UnsafeAgentServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AgentServiceServer will
+// result in compilation errors.
+type UnsafeAgentServiceServer interface {
+	mustEmbedUnimplementedAgentServiceServer()
+}
+
+func RegisterAgentServiceServer(s grpc.ServiceRegistrar, srv AgentServiceServer) {
+	s.RegisterService(&AgentService_ServiceDesc, srv)
+}
+
+func _AgentService_Enroll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).Enroll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_Enroll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).Enroll(ctx, req.(*EnrollRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_RenewCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).RenewCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_RenewCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).RenewCertificate(ctx, req.(*RenewCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_SyncPeers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SyncPeersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServiceServer).SyncPeers(m, &agentServiceSyncPeersServer{stream})
+}
+
+type AgentService_SyncPeersServer interface {
+	Send(*NodeState) error
+	grpc.ServerStream
+}
+
+type agentServiceSyncPeersServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentServiceSyncPeersServer) Send(m *NodeState) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _AgentService_ReportStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).ReportStatus(&agentServiceReportStatusServer{stream})
+}
+
+type AgentService_ReportStatusServer interface {
+	Send(*StatusResponse) error
+	Recv() (*StatusReport, error)
+	grpc.ServerStream
+}
+
+type agentServiceReportStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentServiceReportStatusServer) Send(m *StatusResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *agentServiceReportStatusServer) Recv() (*StatusReport, error) {
+	m := new(StatusReport)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _AgentService_ExecuteCommands_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).ExecuteCommands(&agentServiceExecuteCommandsServer{stream})
+}
+
+type AgentService_ExecuteCommandsServer interface {
+	Send(*NodeCommand) error
+	Recv() (*CommandResult, error)
+	grpc.ServerStream
+}
+
+type agentServiceExecuteCommandsServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentServiceExecuteCommandsServer) Send(m *NodeCommand) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *agentServiceExecuteCommandsServer) Recv() (*CommandResult, error) {
+	m := new(CommandResult)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AgentService_ServiceDesc is the grpc.ServiceDesc for AgentService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AgentService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "vpn.v1.AgentService",
+	HandlerType: (*AgentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Enroll",
+			Handler:    _AgentService_Enroll_Handler,
+		},
+		{
+			MethodName: "RenewCertificate",
+			Handler:    _AgentService_RenewCertificate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SyncPeers",
+			Handler:       _AgentService_SyncPeers_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReportStatus",
+			Handler:       _AgentService_ReportStatus_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ExecuteCommands",
+			Handler:       _AgentService_ExecuteCommands_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "vpn/v1/agent.proto",
+}
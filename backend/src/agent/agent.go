@@ -0,0 +1,407 @@
+// Package agent implements the node agent that runs on each VPN server and
+// applies the peers the control plane keeps for it
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"google.golang.org/grpc"
+)
+
+// Version is the version the agent reports, set at build time with
+// -ldflags "-X github.com/vpn-service/backend/src/agent.Version=<version>"
+var Version = "dev"
+
+// Agent runs a VPN server for the control plane: it applies the peers and
+// policies of the server to its WireGuard interfaces, reports heartbeats
+// with the health of the server, and runs the commands the control plane
+// queues for it. It talks to the REST API, or to the agent service when
+// agent.grpcAddr is set.
+type Agent struct {
+	config *config.Config
+	peers  *wireguard.PeerManager
+	client *http.Client
+
+	// Agent service connection, authenticated with the node certificate
+	conn        *grpc.ClientConn
+	roots       *x509.CertPool
+	certificate *tls.Certificate
+
+	mutex        sync.Mutex
+	stateVersion string           // node state last applied
+	applyError   string           // why the last apply failed
+	drift        *wireguard.Drift // found by the last reconcile
+
+	certificateVersion string
+	results            []core.NodeCommandResult // reported with the next heartbeat
+
+	lastLoad      *loadSample // host counters at the last heartbeat
+	loadErrorOnce sync.Once
+}
+
+// New creates a new agent
+func New(cfg *config.Config) *Agent {
+	return &Agent{
+		config: cfg,
+		peers:  wireguard.NewPeerManager(cfg),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Start fetches the state of the server and brings up its WireGuard
+// interfaces. When the control plane cannot be reached the interfaces come
+// up without peers, which the next sync adds.
+func (a *Agent) Start(ctx context.Context) error {
+	if a.config.Agent.ServerID == "" {
+		return fmt.Errorf("agent.serverId is required")
+	}
+
+	if cert, err := core.InstalledNodeCertificate(a.config.Certificates.StorageDir); err == nil {
+		a.certificateVersion = cert.Version
+	}
+
+	if a.config.Agent.GRPCAddr != "" {
+		if err := a.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := a.sync(ctx); err != nil {
+		utils.LogWarning("Failed to fetch node state, starting without peers: %v", err)
+		if err := a.peers.ApplyNodeState(ctx, &wireguard.NodeState{ServerID: a.config.Agent.ServerID}); err != nil {
+			return err
+		}
+	}
+
+	return a.peers.SetupLocalInterface(ctx)
+}
+
+// Stop removes the WireGuard interfaces
+func (a *Agent) Stop(ctx context.Context) error {
+	return a.peers.TeardownLocalInterface(ctx)
+}
+
+// Run reports heartbeats and syncs the node state every interval until
+// ctx ends. With the agent service, the node state and commands are
+// streamed as they change instead.
+func (a *Agent) Run(ctx context.Context) {
+	if a.conn != nil {
+		a.runStreams(ctx)
+		return
+	}
+
+	ticker := time.NewTicker(a.interval())
+	defer ticker.Stop()
+
+	for {
+		a.tick(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick reports a heartbeat, runs the commands in its response and syncs
+// the node state
+func (a *Agent) tick(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	response, err := a.heartbeat(ctx)
+	if err != nil {
+		utils.LogError("Failed to report heartbeat: %v", err)
+	} else {
+		a.handle(ctx, response)
+	}
+
+	if err := a.sync(ctx); err != nil {
+		utils.LogError("Failed to sync node state: %v", err)
+	}
+}
+
+// heartbeat reports the versions and health of the server, with the
+// results of the commands run since the last heartbeat
+func (a *Agent) heartbeat(ctx context.Context) (*core.HeartbeatResponse, error) {
+	health := a.health(ctx)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	heartbeat := core.NodeHeartbeat{
+		ServerID:           a.config.Agent.ServerID,
+		AgentVersion:       Version,
+		WireGuardVersion:   wireguard.LocalVersion(ctx),
+		CertificateVersion: a.certificateVersion,
+
+		WireGuardImplementation: a.peers.Implementation(),
+		Obfuscation:             a.peers.ObfuscationTransport(),
+
+		Health:  health,
+		Results: a.results,
+	}
+
+	var response core.HeartbeatResponse
+	if err := a.request(ctx, http.MethodPost, "/api/v1/nodes/heartbeat", heartbeat, &response); err != nil {
+		return nil, err
+	}
+
+	a.results = nil
+	return &response, nil
+}
+
+// handle acts on a heartbeat response: it installs a new node certificate
+// and runs the queued commands
+func (a *Agent) handle(ctx context.Context, response *core.HeartbeatResponse) {
+	if response.TargetAgentVersion != "" {
+		utils.LogInfo("Control plane asks for agent version %s (rollout %s), running %s", response.TargetAgentVersion, response.RolloutID, Version)
+	}
+
+	if response.Certificate != nil {
+		if err := core.InstallNodeCertificate(a.config.Certificates.StorageDir, response.Certificate); err != nil {
+			utils.LogError("Failed to install node certificate %s: %v", response.Certificate.Version, err)
+		} else {
+			a.mutex.Lock()
+			a.certificateVersion = response.Certificate.Version
+			a.mutex.Unlock()
+			utils.LogInfo("Installed node certificate %s", response.Certificate.Version)
+		}
+	}
+
+	for _, command := range response.Commands {
+		result := core.NodeCommandResult{ID: command.ID}
+		if err := a.run(ctx, command); err != nil {
+			result.Error = err.Error()
+		}
+		a.mutex.Lock()
+		a.results = append(a.results, result)
+		a.mutex.Unlock()
+	}
+}
+
+// run runs a command from the control plane
+func (a *Agent) run(ctx context.Context, command *core.NodeCommand) error {
+	utils.LogInfo("Running %s command %s", command.Type, command.ID)
+
+	switch command.Type {
+	case core.NodeCommandSync:
+		a.mutex.Lock()
+		a.stateVersion = ""
+		a.mutex.Unlock()
+		return a.sync(ctx)
+	case core.NodeCommandRestart:
+		if err := a.peers.TeardownLocalInterface(ctx); err != nil {
+			return err
+		}
+		return a.peers.SetupLocalInterface(ctx)
+	case core.NodeCommandUpgrade:
+		return a.upgrade(ctx, command.Version)
+	default:
+		return fmt.Errorf("unknown command type: %s", command.Type)
+	}
+}
+
+// upgrade runs the configured upgrade command for an agent version. The
+// new version is reported once the service manager restarts the agent.
+func (a *Agent) upgrade(ctx context.Context, version string) error {
+	if a.config.Agent.UpgradeCommand == "" {
+		return fmt.Errorf("no upgrade command configured")
+	}
+
+	// The version ends up in a shell script, so check it again here rather
+	// than trust the control plane to have validated it
+	var v utils.Validator
+	core.ValidateAgentVersion(&v, core.NodeCommandUpgrade, version)
+	if err := v.Err(); err != nil {
+		return fmt.Errorf("invalid upgrade version: %v", err)
+	}
+	if version == Version {
+		return nil
+	}
+
+	script := strings.ReplaceAll(a.config.Agent.UpgradeCommand, "{version}", version)
+	output, err := exec.CommandContext(ctx, "sh", "-c", script).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("upgrade to %s failed: %v: %s", version, err, bytes.TrimSpace(output))
+	}
+
+	utils.LogInfo("Installed agent version %s, waiting for restart", version)
+	return nil
+}
+
+// sync fetches the node state and reconciles the interfaces against it
+func (a *Agent) sync(ctx context.Context) error {
+	if a.conn != nil {
+		state, err := a.fetchState(ctx)
+		if err != nil {
+			return err
+		}
+		return a.apply(ctx, state)
+	}
+
+	var state wireguard.NodeState
+	path := "/api/v1/nodes/state?serverId=" + url.QueryEscape(a.config.Agent.ServerID)
+	if err := a.request(ctx, http.MethodGet, path, nil, &state); err != nil {
+		return err
+	}
+	return a.apply(ctx, &state)
+}
+
+// apply makes a node state the desired state of the interfaces. A state
+// already applied is reconciled again instead.
+func (a *Agent) apply(ctx context.Context, state *wireguard.NodeState) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if state.Version == a.stateVersion {
+		return a.reconcileLocked(ctx)
+	}
+
+	if err := a.peers.ApplyNodeState(ctx, state); err != nil {
+		a.applyError = err.Error()
+		return fmt.Errorf("failed to apply node state %s: %v", state.Version, err)
+	}
+
+	a.stateVersion = state.Version
+	a.applyError = ""
+	a.drift = nil
+	utils.LogInfo("Applied node state %s with %d peers", state.Version, len(state.Peers))
+	return nil
+}
+
+// reconcile reconciles the interfaces against the node state last applied
+func (a *Agent) reconcile(ctx context.Context) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.reconcileLocked(ctx)
+}
+
+// reconcileLocked reconciles the interfaces against the node state last
+// applied, correcting peers changed on the host or missed by an update
+// unless wireguard.drift.autoCorrect is off. The caller holds the mutex.
+func (a *Agent) reconcileLocked(ctx context.Context) error {
+	check := a.peers.DetectDrift
+	if a.config.WireGuard.Drift.AutoCorrect {
+		check = a.peers.Reconcile
+	}
+
+	drift, err := check(ctx)
+	if err != nil {
+		a.applyError = err.Error()
+		return fmt.Errorf("failed to reconcile node state %s: %v", a.stateVersion, err)
+	}
+
+	a.applyError = ""
+	a.drift = &drift
+	if drift.Total() > 0 {
+		utils.LogWarning("Drift from node state %s (corrected: %t): %d missing, %d unexpected and %d changed peers",
+			a.stateVersion, drift.Corrected, drift.Missing, drift.Unexpected, drift.Changed)
+	}
+	return nil
+}
+
+// appliedVersion gets the version of the node state last applied
+func (a *Agent) appliedVersion() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.stateVersion
+}
+
+// health gets the health of the server
+func (a *Agent) health(ctx context.Context) *core.NodeHealth {
+	a.mutex.Lock()
+	health := &core.NodeHealth{
+		StateVersion: a.stateVersion,
+		Error:        a.applyError,
+		Drift:        a.drift,
+	}
+	a.mutex.Unlock()
+	health.Up = a.peers.LocalInterfaceUp(ctx)
+
+	interfaces, err := a.peers.InterfaceStatuses(ctx)
+	if err != nil {
+		utils.LogError("Failed to get interface statuses: %v", err)
+		return health
+	}
+	health.Interfaces = interfaces
+	for _, iface := range interfaces {
+		health.Peers += iface.Peers
+		health.ActivePeers += iface.ActivePeers
+		health.ReceiveBytes += iface.ReceiveBytes
+		health.TransmitBytes += iface.TransmitBytes
+	}
+
+	load := a.sampleLoad(health.ReceiveBytes, health.TransmitBytes)
+	health.CPU = load.cpu
+	health.Memory = load.memory
+	health.ReceiveRate = load.receiveRate
+	health.TransmitRate = load.transmitRate
+
+	return health
+}
+
+// interval gets how often the agent reports its status
+func (a *Agent) interval() time.Duration {
+	interval := time.Duration(a.config.Agent.Interval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return interval
+}
+
+// request sends a request to the control plane, authenticated with the
+// agent token, and decodes the JSON response into out
+func (a *Agent) request(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(content)
+	}
+
+	endpoint := strings.TrimRight(a.config.Agent.ControlPlane, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.config.Nodes.AgentToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(message))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,517 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	vpnv1 "github.com/vpn-service/backend/proto/vpn/v1"
+	"github.com/vpn-service/backend/src/core"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Files of the node certificate in agent.certDir
+const (
+	nodeCertificateFile = "node.crt"
+	nodeKeyFile         = "node.key"
+)
+
+// maxRetryDelay caps the delay before a failed stream is opened again
+const maxRetryDelay = time.Minute
+
+// connect loads the node certificate, enrolling with the enrollment token
+// when there is none yet, and connects to the agent service
+func (a *Agent) connect(ctx context.Context) error {
+	caPEM, err := os.ReadFile(a.config.Agent.CAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read agent CA certificate: %v", err)
+	}
+	a.roots = x509.NewCertPool()
+	if !a.roots.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("invalid agent CA certificate: %s", a.config.Agent.CAFile)
+	}
+
+	certificate, err := a.loadCertificate()
+	if os.IsNotExist(err) {
+		certificate, err = a.enroll(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	a.setCertificate(certificate)
+
+	return a.dial()
+}
+
+// enroll exchanges the enrollment token for a node certificate and saves it
+func (a *Agent) enroll(ctx context.Context) (*tls.Certificate, error) {
+	if a.config.Agent.EnrollmentToken == "" {
+		return nil, fmt.Errorf("agent.enrollmentToken is required to enroll")
+	}
+
+	key, csr, err := a.certificateRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	// Enroll without a client certificate
+	conn, err := grpc.DialContext(ctx, a.config.Agent.GRPCAddr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		RootCAs:    a.roots,
+		MinVersion: tls.VersionTLS12,
+	})))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", a.config.Agent.GRPCAddr, err)
+	}
+	defer conn.Close()
+
+	response, err := vpnv1.NewAgentServiceClient(conn).Enroll(ctx, &vpnv1.EnrollRequest{
+		ServerId: a.config.Agent.ServerID,
+		Token:    a.config.Agent.EnrollmentToken,
+		Csr:      csr,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enroll: %v", err)
+	}
+
+	certificate, err := a.saveCertificate(response.Certificate, key)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogInfo("Enrolled node %s, certificate valid until %s", a.config.Agent.ServerID, response.NotAfter.AsTime().Format(time.RFC3339))
+	return certificate, nil
+}
+
+// renew replaces the node certificate before it expires and reconnects
+// with the new one, as the control plane only accepts the latest
+func (a *Agent) renew(ctx context.Context) error {
+	key, csr, err := a.certificateRequest()
+	if err != nil {
+		return err
+	}
+
+	response, err := a.rpc().RenewCertificate(ctx, &vpnv1.RenewCertificateRequest{Csr: csr})
+	if err != nil {
+		return fmt.Errorf("failed to renew node certificate: %v", err)
+	}
+
+	certificate, err := a.saveCertificate(response.Certificate, key)
+	if err != nil {
+		return err
+	}
+	a.setCertificate(certificate)
+
+	utils.LogInfo("Renewed node certificate, valid until %s", response.NotAfter.AsTime().Format(time.RFC3339))
+	return a.dial()
+}
+
+// renewalDue reports whether less than a third of the validity of the node
+// certificate is left
+func (a *Agent) renewalDue() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	leaf := a.certificate.Leaf
+	validity := leaf.NotAfter.Sub(leaf.NotBefore)
+	return time.Until(leaf.NotAfter) < validity/3
+}
+
+// dial connects to the agent service with the node certificate, closing
+// the previous connection and the streams on it
+func (a *Agent) dial() error {
+	creds := credentials.NewTLS(&tls.Config{
+		RootCAs:    a.roots,
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			a.mutex.Lock()
+			defer a.mutex.Unlock()
+			return a.certificate, nil
+		},
+	})
+	conn, err := grpc.Dial(a.config.Agent.GRPCAddr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", a.config.Agent.GRPCAddr, err)
+	}
+
+	a.mutex.Lock()
+	previous := a.conn
+	a.conn = conn
+	a.mutex.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// rpc gets a client of the agent service on the current connection
+func (a *Agent) rpc() vpnv1.AgentServiceClient {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return vpnv1.NewAgentServiceClient(a.conn)
+}
+
+// certificateRequest generates a node key and a certificate signing request
+// for it naming the server
+func (a *Agent) certificateRequest() (*ecdsa.PrivateKey, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate node key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: a.config.Agent.ServerID},
+	}, key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create certificate signing request: %v", err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// saveCertificate saves an issued node certificate with its key in
+// agent.certDir
+func (a *Agent) saveCertificate(certPEM string, key *ecdsa.PrivateKey) (*tls.Certificate, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode node key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	certificate, err := parseCertificate([]byte(certPEM), keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := a.config.Agent.CertDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, nodeKeyFile), keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save node key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, nodeCertificateFile), []byte(certPEM), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save node certificate: %v", err)
+	}
+
+	return certificate, nil
+}
+
+// loadCertificate reads the node certificate from agent.certDir
+func (a *Agent) loadCertificate() (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(filepath.Join(a.config.Agent.CertDir, nodeCertificateFile))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(a.config.Agent.CertDir, nodeKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(certPEM, keyPEM)
+}
+
+// setCertificate sets the node certificate the agent authenticates with
+func (a *Agent) setCertificate(certificate *tls.Certificate) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.certificate = certificate
+}
+
+// parseCertificate parses a node certificate and its key
+func parseCertificate(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node certificate: %v", err)
+	}
+	certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid node certificate: %v", err)
+	}
+	return &certificate, nil
+}
+
+// runStreams keeps the streams of the agent service open until ctx ends,
+// opening them again after they fail
+func (a *Agent) runStreams(ctx context.Context) {
+	streams := map[string]func(context.Context) error{
+		"peer sync": a.syncPeers,
+		"status":    a.reportStatus,
+		"command":   a.executeCommands,
+	}
+
+	done := make(chan struct{}, len(streams))
+	for name, stream := range streams {
+		go func(name string, stream func(context.Context) error) {
+			a.keepOpen(ctx, name, stream)
+			done <- struct{}{}
+		}(name, stream)
+	}
+	for range streams {
+		<-done
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.conn.Close()
+}
+
+// keepOpen runs a stream until ctx ends, waiting longer after each failure
+// up to a minute
+func (a *Agent) keepOpen(ctx context.Context, name string, stream func(context.Context) error) {
+	delay := time.Second
+	for {
+		started := time.Now()
+		err := stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			utils.LogError("Agent %s stream closed: %v", name, err)
+		}
+
+		// Back off unless the stream was open for a while
+		if time.Since(started) > maxRetryDelay {
+			delay = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// syncPeers applies every node state the control plane sends
+func (a *Agent) syncPeers(ctx context.Context) error {
+	stream, err := a.rpc().SyncPeers(ctx, &vpnv1.SyncPeersRequest{KnownVersion: a.appliedVersion()})
+	if err != nil {
+		return err
+	}
+
+	for {
+		message, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := a.apply(ctx, fromNodeState(message)); err != nil {
+			utils.LogError("Failed to sync node state: %v", err)
+		}
+	}
+}
+
+// fetchState gets the current node state from the agent service
+func (a *Agent) fetchState(ctx context.Context) (*wireguard.NodeState, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := a.rpc().SyncPeers(ctx, &vpnv1.SyncPeersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	message, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return fromNodeState(message), nil
+}
+
+// reportStatus reconciles the interfaces and reports the status of the
+// server every interval, acting on the responses and renewing the node
+// certificate when due
+func (a *Agent) reportStatus(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := a.rpc().ReportStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(a.interval())
+	defer ticker.Stop()
+
+	for {
+		// Changes arrive on the peer sync stream; reconciling catches
+		// what happened on the host since
+		if err := a.reconcile(ctx); err != nil {
+			utils.LogError("Failed to reconcile node state: %v", err)
+		}
+
+		if err := stream.Send(a.statusReport(ctx)); err != nil {
+			return err
+		}
+		response, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		a.handle(ctx, &core.HeartbeatResponse{
+			TargetAgentVersion: response.TargetAgentVersion,
+			RolloutID:          response.RolloutId,
+			Certificate:        fromNodeCertificate(response.Certificate),
+		})
+
+		// The connection is replaced with one using the new certificate,
+		// which ends this stream
+		if a.renewalDue() {
+			return a.renew(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// executeCommands runs the commands the control plane sends and reports
+// their results
+func (a *Agent) executeCommands(ctx context.Context) error {
+	stream, err := a.rpc().ExecuteCommands(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		message, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("closed by the control plane")
+		}
+		if err != nil {
+			return err
+		}
+
+		result := &vpnv1.CommandResult{Id: message.Id}
+		if err := a.run(ctx, &core.NodeCommand{ID: message.Id, Type: message.Type, Version: message.Version}); err != nil {
+			result.Error = err.Error()
+		}
+		if err := stream.Send(result); err != nil {
+			return err
+		}
+	}
+}
+
+// statusReport gets the versions and health of the server
+func (a *Agent) statusReport(ctx context.Context) *vpnv1.StatusReport {
+	health := a.health(ctx)
+
+	a.mutex.Lock()
+	certificateVersion := a.certificateVersion
+	a.mutex.Unlock()
+
+	report := &vpnv1.StatusReport{
+		AgentVersion:            Version,
+		WireguardVersion:        wireguard.LocalVersion(ctx),
+		CertificateVersion:      certificateVersion,
+		WireguardImplementation: a.peers.Implementation(),
+		Obfuscation:             a.peers.ObfuscationTransport(),
+		Up:                      health.Up,
+		Peers:                   int32(health.Peers),
+		ActivePeers:             int32(health.ActivePeers),
+		ReceiveBytes:            health.ReceiveBytes,
+		TransmitBytes:           health.TransmitBytes,
+		StateVersion:            health.StateVersion,
+		Error:                   health.Error,
+		Cpu:                     health.CPU,
+		Memory:                  health.Memory,
+		ReceiveRate:             health.ReceiveRate,
+		TransmitRate:            health.TransmitRate,
+	}
+	if drift := health.Drift; drift != nil {
+		report.Drift = &vpnv1.Drift{
+			Missing:    int32(drift.Missing),
+			Unexpected: int32(drift.Unexpected),
+			Changed:    int32(drift.Changed),
+			Corrected:  drift.Corrected,
+		}
+	}
+	for _, iface := range health.Interfaces {
+		report.Interfaces = append(report.Interfaces, &vpnv1.InterfaceStatus{
+			Name:          iface.Name,
+			Up:            iface.Up,
+			Peers:         int32(iface.Peers),
+			ActivePeers:   int32(iface.ActivePeers),
+			ReceiveBytes:  iface.ReceiveBytes,
+			TransmitBytes: iface.TransmitBytes,
+		})
+	}
+	return report
+}
+
+// fromNodeState converts a node state message to the state applied
+func fromNodeState(message *vpnv1.NodeState) *wireguard.NodeState {
+	state := &wireguard.NodeState{
+		ServerID:     message.ServerId,
+		Version:      message.Version,
+		Peers:        make([]*wireguard.PeerConfig, len(message.Peers)),
+		ACL:          make([]wireguard.ACLPolicy, len(message.Acl)),
+		Reservations: make([]wireguard.AddressReservation, len(message.Reservations)),
+	}
+	for i, peer := range message.Peers {
+		state.Peers[i] = &wireguard.PeerConfig{
+			ID:         peer.Id,
+			UserID:     peer.UserId,
+			ServerID:   message.ServerId,
+			DeviceType: peer.DeviceType,
+			PublicKey:  peer.PublicKey,
+			IP:         peer.Ip,
+			Dynamic:    peer.Dynamic,
+			PeerOptions: wireguard.PeerOptions{
+				Interface:     peer.Interface,
+				RoutedSubnets: peer.RoutedSubnets,
+				ExitServer:    peer.ExitServer,
+				Routes:        peer.Routes,
+				DNSProfile:    peer.DnsProfile,
+			},
+		}
+	}
+	for i, policy := range message.Acl {
+		state.ACL[i] = wireguard.ACLPolicy{
+			ID:          policy.Id,
+			Source:      policy.Source,
+			Destination: policy.Destination,
+			Protocol:    policy.Protocol,
+			Port:        int(policy.Port),
+			Action:      policy.Action,
+		}
+	}
+	for i, reservation := range message.Reservations {
+		state.Reservations[i] = wireguard.AddressReservation{
+			UserID:   reservation.UserId,
+			IP:       reservation.Ip,
+			PublicIP: reservation.PublicIp,
+			ServerID: reservation.ServerId,
+		}
+	}
+	return state
+}
+
+// fromNodeCertificate converts a wildcard node certificate message
+func fromNodeCertificate(message *vpnv1.NodeCertificate) *core.NodeCertificate {
+	if message == nil {
+		return nil
+	}
+	return &core.NodeCertificate{
+		Version:   message.Version,
+		Domains:   message.Domains,
+		NotBefore: message.NotBefore.AsTime(),
+		NotAfter:  message.NotAfter.AsTime(),
+		CertPEM:   message.CertPem,
+		KeyPEM:    message.KeyPem,
+	}
+}
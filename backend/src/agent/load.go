@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// loadSample represents the counters the load of the host is computed from
+type loadSample struct {
+	at            time.Time
+	cpuBusy       uint64 // jiffies spent busy
+	cpuTotal      uint64 // jiffies in total
+	receiveBytes  int64
+	transmitBytes int64
+}
+
+// hostLoad represents the load of the host between two samples
+type hostLoad struct {
+	cpu          float64 // percent of CPU time busy
+	memory       float64 // percent of memory in use
+	receiveRate  int64   // in bytes per second
+	transmitRate int64   // in bytes per second
+}
+
+// sampleLoad takes a sample of the host counters, with the WireGuard
+// transfer totals, and gets the load since the previous sample. The first
+// sample only reports memory.
+func (a *Agent) sampleLoad(receiveBytes, transmitBytes int64) hostLoad {
+	var load hostLoad
+
+	memory, err := memoryUsage()
+	if err != nil {
+		a.logLoadError(err)
+	}
+	load.memory = memory
+
+	sample := loadSample{at: time.Now(), receiveBytes: receiveBytes, transmitBytes: transmitBytes}
+	sample.cpuBusy, sample.cpuTotal, err = cpuTimes()
+	if err != nil {
+		a.logLoadError(err)
+	}
+
+	a.mutex.Lock()
+	previous := a.lastLoad
+	a.lastLoad = &sample
+	a.mutex.Unlock()
+	if previous == nil {
+		return load
+	}
+
+	if sample.cpuTotal > previous.cpuTotal && sample.cpuBusy >= previous.cpuBusy {
+		load.cpu = float64(sample.cpuBusy-previous.cpuBusy) / float64(sample.cpuTotal-previous.cpuTotal) * 100
+	}
+
+	// Counters reset when an interface is recreated
+	if elapsed := sample.at.Sub(previous.at).Seconds(); elapsed > 0 {
+		if received := sample.receiveBytes - previous.receiveBytes; received > 0 {
+			load.receiveRate = int64(float64(received) / elapsed)
+		}
+		if transmitted := sample.transmitBytes - previous.transmitBytes; transmitted > 0 {
+			load.transmitRate = int64(float64(transmitted) / elapsed)
+		}
+	}
+
+	return load
+}
+
+// logLoadError logs why the load of the host is unknown, once
+func (a *Agent) logLoadError(err error) {
+	a.loadErrorOnce.Do(func() {
+		utils.LogWarning("Failed to read the load of the host: %v", err)
+	})
+}
+
+// cpuTimes reads the busy and total CPU time from /proc/stat
+func cpuTimes() (uint64, uint64, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+
+		var busy, total uint64
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid /proc/stat: %v", err)
+			}
+			total += value
+			// idle and iowait
+			if i != 3 && i != 4 {
+				busy += value
+			}
+		}
+		return busy, total, nil
+	}
+
+	return 0, 0, fmt.Errorf("no cpu line in /proc/stat")
+}
+
+// memoryUsage reads the percent of memory in use from /proc/meminfo
+func memoryUsage() (float64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value
+	}
+
+	total, available := values["MemTotal"], values["MemAvailable"]
+	if total == 0 || available > total {
+		return 0, fmt.Errorf("no memory totals in /proc/meminfo")
+	}
+	return float64(total-available) / float64(total) * 100, nil
+}
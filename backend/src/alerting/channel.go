@@ -0,0 +1,85 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/email"
+)
+
+// Alert severities, as PagerDuty names them
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// Notification is an alert firing or resolving, as sent to channels
+type Notification struct {
+	Key      string      `json:"key"` // identifies the alert across firing and resolving
+	Rule     string      `json:"rule"`
+	Subject  string      `json:"subject"` // what the alert is about, such as a region or server
+	Summary  string      `json:"summary"`
+	Severity string      `json:"severity"`
+	Resolved bool        `json:"resolved"`
+	Time     time.Time   `json:"time"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+// Channel delivers alert notifications
+type Channel interface {
+	// Send delivers a notification
+	Send(ctx context.Context, notification *Notification) error
+}
+
+// NewChannel creates a channel from its configuration. Email channels send
+// with mailer.
+func NewChannel(cfg config.AlertChannelConfig, mailer *email.Mailer) (Channel, error) {
+	switch cfg.Type {
+	case "email":
+		return NewEmail(cfg, mailer)
+	case "webhook":
+		return NewWebhook(cfg)
+	case "slack":
+		return NewSlack(cfg)
+	case "pagerduty":
+		return NewPagerDuty(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported alert channel type: %q", cfg.Type)
+	}
+}
+
+// client is the HTTP client of the webhook based channels
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON posts a payload as JSON, failing on error statuses
+func postJSON(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,295 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/email"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ErrSilenceNotFound is returned for silences that do not exist or ended
+var ErrSilenceNotFound = errors.New("silence not found")
+
+// Silence mutes the notifications of matching alerts until it ends
+type Silence struct {
+	ID        string    `json:"id" db:"id"`
+	Rule      string    `json:"rule" db:"rule"`       // empty matches every rule
+	Subject   string    `json:"subject" db:"subject"` // empty matches every subject
+	Comment   string    `json:"comment" db:"comment"`
+	CreatedBy string    `json:"createdBy" db:"created_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	EndsAt    time.Time `json:"endsAt" db:"ends_at"`
+}
+
+// matches checks whether a silence mutes a notification at a time
+func (s *Silence) matches(notification *Notification, now time.Time) bool {
+	return now.Before(s.EndsAt) &&
+		(s.Rule == "" || s.Rule == notification.Rule) &&
+		(s.Subject == "" || s.Subject == notification.Subject)
+}
+
+// alertState is what the dispatcher keeps of an alert to deduplicate its
+// notifications
+type alertState struct {
+	firing     bool
+	muted      bool // firing was not sent, so resolving is not either
+	resolvedAt time.Time
+}
+
+// Dispatcher routes alert notifications to the channels of their rule.
+// Notifications repeating an alert's state are dropped, an alert firing
+// again within the dedup window of its resolution is not sent again, and
+// silenced alerts are not sent at all.
+type Dispatcher struct {
+	config   config.AlertsConfig
+	channels map[string]Channel
+	routes   map[string][]string // channel names by rule
+	alerts   map[string]*alertState
+	silences map[string]*Silence
+	mutex    sync.Mutex
+}
+
+// NewDispatcher creates a dispatcher with the configured channels
+func NewDispatcher(cfg config.AlertsConfig, mailer *email.Mailer) (*Dispatcher, error) {
+	d := &Dispatcher{
+		config:   cfg,
+		channels: make(map[string]Channel, len(cfg.Channels)),
+		routes:   make(map[string][]string, len(cfg.Rules)),
+		alerts:   make(map[string]*alertState),
+		silences: make(map[string]*Silence),
+		mutex:    sync.Mutex{},
+	}
+
+	for name, channelConfig := range cfg.Channels {
+		channel, err := NewChannel(channelConfig, mailer)
+		if err != nil {
+			return nil, fmt.Errorf("alert channel %s: %v", name, err)
+		}
+		d.channels[name] = channel
+	}
+
+	for _, rule := range cfg.Rules {
+		for _, name := range rule.Channels {
+			if _, ok := d.channels[name]; !ok {
+				return nil, fmt.Errorf("alert rule %s routes to unknown channel %s", rule.Name, name)
+			}
+		}
+		d.routes[rule.Name] = rule.Channels
+	}
+
+	// Load the silences
+	if err := d.load(); err != nil {
+		utils.LogError("Failed to load alert silences: %v", err)
+	}
+
+	return d, nil
+}
+
+// Dispatch sends a notification to the channels of its rule, unless it is
+// a duplicate or silenced
+func (d *Dispatcher) Dispatch(notification *Notification) {
+	if !d.admit(notification) {
+		return
+	}
+
+	names := d.routes[notification.Rule]
+	if len(names) == 0 {
+		for name := range d.channels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	ctx := context.Background()
+	for _, name := range names {
+		if err := d.channels[name].Send(ctx, notification); err != nil {
+			utils.LogWarning("Failed to send alert %s to channel %s: %v", notification.Key, name, err)
+		}
+	}
+}
+
+// admit records a notification in the state of its alert and reports
+// whether it is to be sent
+func (d *Dispatcher) admit(notification *Notification) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	window := time.Duration(d.config.DedupWindow) * time.Minute
+
+	// Forget alerts resolved longer ago than the window, and ended silences
+	for key, state := range d.alerts {
+		if !state.firing && now.Sub(state.resolvedAt) >= window {
+			delete(d.alerts, key)
+		}
+	}
+	for id, silence := range d.silences {
+		if !now.Before(silence.EndsAt) {
+			delete(d.silences, id)
+		}
+	}
+
+	state, ok := d.alerts[notification.Key]
+	if !ok {
+		state = &alertState{}
+		d.alerts[notification.Key] = state
+	}
+
+	if notification.Resolved {
+		if !state.firing {
+			return false
+		}
+		state.firing = false
+		if state.muted {
+			return false
+		}
+		state.resolvedAt = now
+		return true
+	}
+
+	if state.firing {
+		return false
+	}
+	state.firing = true
+	state.muted = false
+
+	if !state.resolvedAt.IsZero() && now.Sub(state.resolvedAt) < window {
+		state.muted = true
+		utils.LogInfo("Alert %s fired again within the dedup window, not sending it", notification.Key)
+		return false
+	}
+	for _, silence := range d.silences {
+		if silence.matches(notification, now) {
+			state.muted = true
+			utils.LogInfo("Alert %s is silenced by %s until %s", notification.Key, silence.ID, silence.EndsAt.Format(time.RFC3339))
+			return false
+		}
+	}
+
+	return true
+}
+
+// ListSilences gets the silences that have not ended, ending first first
+func (d *Dispatcher) ListSilences() []*Silence {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	silences := make([]*Silence, 0, len(d.silences))
+	for _, silence := range d.silences {
+		if now.Before(silence.EndsAt) {
+			copied := *silence
+			silences = append(silences, &copied)
+		}
+	}
+
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].EndsAt.Before(silences[j].EndsAt)
+	})
+
+	return silences
+}
+
+// AddSilence mutes the alerts of a rule and subject, either of which may
+// be empty to match any, for a duration
+func (d *Dispatcher) AddSilence(rule, subject, comment string, duration time.Duration, actor string) (*Silence, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+
+	now := time.Now()
+	silence := &Silence{
+		ID:        utils.GenerateUUID(),
+		Rule:      rule,
+		Subject:   subject,
+		Comment:   comment,
+		CreatedBy: actor,
+		CreatedAt: now,
+		EndsAt:    now.Add(duration),
+	}
+	if err := d.save(silence); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	d.silences[silence.ID] = silence
+	d.mutex.Unlock()
+
+	utils.LogInfo("Alerts silenced until %s (rule %q, subject %q)", silence.EndsAt.Format(time.RFC3339), rule, subject)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "alert_silenced", fmt.Sprintf("silence=%s rule=%s subject=%s", silence.ID, rule, subject))
+
+	copied := *silence
+	return &copied, nil
+}
+
+// RemoveSilence ends a silence early
+func (d *Dispatcher) RemoveSilence(id, actor string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	silence, ok := d.silences[id]
+	if !ok || !time.Now().Before(silence.EndsAt) {
+		return ErrSilenceNotFound
+	}
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`DELETE FROM alert_silences WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to delete silence: %v", err)
+		}
+	}
+	delete(d.silences, id)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "alert_silence_removed", fmt.Sprintf("silence=%s", id))
+
+	return nil
+}
+
+// save writes a silence to the database
+func (d *Dispatcher) save(silence *Silence) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	_, err := db.DB.Exec(
+		`INSERT INTO alert_silences (id, rule, subject, comment, created_by, created_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		silence.ID, silence.Rule, silence.Subject, silence.Comment, silence.CreatedBy, silence.CreatedAt, silence.EndsAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save silence: %v", err)
+	}
+
+	return nil
+}
+
+// load reads the silences that have not ended from the database
+func (d *Dispatcher) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	silences := []*Silence{}
+	err := db.DB.Select(&silences, `SELECT id, rule, subject, comment, created_by, created_at, ends_at FROM alert_silences WHERE ends_at > $1`, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to query silences: %v", err)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, silence := range silences {
+		d.silences[silence.ID] = silence
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/email"
+)
+
+// Email sends notifications to email addresses
+type Email struct {
+	mailer    *email.Mailer
+	addresses []string
+}
+
+// NewEmail creates an email channel
+func NewEmail(cfg config.AlertChannelConfig, mailer *email.Mailer) (*Email, error) {
+	if len(cfg.Emails) == 0 {
+		return nil, fmt.Errorf("email channel has no addresses")
+	}
+	if mailer == nil {
+		return nil, fmt.Errorf("email delivery is not set up")
+	}
+
+	return &Email{mailer: mailer, addresses: cfg.Emails}, nil
+}
+
+// Send emails a notification to every address
+func (e *Email) Send(ctx context.Context, notification *Notification) error {
+	for _, address := range e.addresses {
+		if err := e.mailer.Send(ctx, address, email.TemplateAlertNotification, notification); err != nil {
+			return fmt.Errorf("failed to email %s: %v", address, err)
+		}
+	}
+	return nil
+}
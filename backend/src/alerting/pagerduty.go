@@ -0,0 +1,51 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// pagerDutyURL is the PagerDuty Events API v2 endpoint
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDuty triggers and resolves PagerDuty incidents through the Events
+// API v2. The notification key is the dedup key, so an incident is
+// resolved by the notification resolving its alert.
+type PagerDuty struct {
+	routingKey string
+}
+
+// NewPagerDuty creates a PagerDuty channel
+func NewPagerDuty(cfg config.AlertChannelConfig) (*PagerDuty, error) {
+	if cfg.RoutingKey == "" {
+		return nil, fmt.Errorf("pagerduty channel has no routing key")
+	}
+
+	return &PagerDuty{routingKey: cfg.RoutingKey}, nil
+}
+
+// Send triggers or resolves the incident of a notification
+func (p *PagerDuty) Send(ctx context.Context, notification *Notification) error {
+	event := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    notification.Key,
+	}
+	if notification.Resolved {
+		event["event_action"] = "resolve"
+	} else {
+		event["payload"] = map[string]interface{}{
+			"summary":        notification.Summary,
+			"source":         notification.Subject,
+			"severity":       notification.Severity,
+			"component":      notification.Rule,
+			"timestamp":      notification.Time.UTC().Format(time.RFC3339),
+			"custom_details": notification.Details,
+		}
+	}
+
+	return postJSON(ctx, pagerDutyURL, event)
+}
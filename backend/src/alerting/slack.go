@@ -0,0 +1,32 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// Slack posts notifications as messages through a Slack incoming webhook
+type Slack struct {
+	url string
+}
+
+// NewSlack creates a Slack channel
+func NewSlack(cfg config.AlertChannelConfig) (*Slack, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("slack channel has no incoming webhook url")
+	}
+
+	return &Slack{url: cfg.URL}, nil
+}
+
+// Send posts a notification as a message
+func (s *Slack) Send(ctx context.Context, notification *Notification) error {
+	text := fmt.Sprintf(":rotating_light: *%s* (%s): %s", notification.Rule, notification.Severity, notification.Summary)
+	if notification.Resolved {
+		text = fmt.Sprintf(":white_check_mark: *%s* resolved: %s", notification.Rule, notification.Subject)
+	}
+
+	return postJSON(ctx, s.url, map[string]string{"text": text})
+}
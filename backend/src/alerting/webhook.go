@@ -0,0 +1,27 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// Webhook posts notifications as JSON to a URL
+type Webhook struct {
+	url string
+}
+
+// NewWebhook creates a webhook channel
+func NewWebhook(cfg config.AlertChannelConfig) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook channel has no url")
+	}
+
+	return &Webhook{url: cfg.URL}, nil
+}
+
+// Send posts a notification
+func (w *Webhook) Send(ctx context.Context, notification *Notification) error {
+	return postJSON(ctx, w.url, notification)
+}
@@ -0,0 +1,128 @@
+package analytics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxLogLine limits the length of a line in a JSON lines log
+const maxLogLine = 1 << 20
+
+// Read calls fn for every event of the store in dir between from and to,
+// inclusive; a zero time leaves that end open. Only batches overlapping
+// the period are decompressed. Events come in write order, batch by batch.
+func Read(dir string, from, to time.Time, fn func(Event) error) error {
+	index, err := ReadIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create zstd decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	for _, segment := range index.Segments {
+		if !overlaps(segment.From, segment.To, from, to) {
+			continue
+		}
+		if err := readSegment(decoder, filepath.Join(dir, segment.File), segment, from, to, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readSegment reads the batches of a segment that overlap the period
+func readSegment(decoder *zstd.Decoder, path string, segment *Segment, from, to time.Time, fn func(Event) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open analytics segment: %v", err)
+	}
+	defer file.Close()
+
+	for _, batch := range segment.Batches {
+		if !overlaps(batch.From, batch.To, from, to) {
+			continue
+		}
+
+		frame := make([]byte, batch.Length)
+		if _, err := file.ReadAt(frame, batch.Offset); err != nil {
+			return fmt.Errorf("failed to read analytics batch of %s at %d: %v", segment.File, batch.Offset, err)
+		}
+		lines, err := decoder.DecodeAll(frame, nil)
+		if err != nil {
+			return fmt.Errorf("failed to decompress analytics batch of %s at %d: %v", segment.File, batch.Offset, err)
+		}
+
+		if err := readLines(bytes.NewReader(lines), from, to, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadLog calls fn for every event of a JSON lines analytics log between
+// from and to, such as the log written before the store was enabled. A
+// missing log has no events.
+func ReadLog(path string, from, to time.Time, fn func(Event) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open analytics log: %v", err)
+	}
+	defer file.Close()
+
+	return readLines(file, from, to, fn)
+}
+
+// readLines calls fn for the events of JSON lines in the period, skipping
+// lines that are not events
+func readLines(r io.Reader, from, to time.Time, fn func(Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLine)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		event, err := ParseEvent(line)
+		if err != nil || event.EventType == "" {
+			continue
+		}
+		if !overlaps(event.Timestamp, event.Timestamp, from, to) {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read analytics events: %v", err)
+	}
+	return nil
+}
+
+// overlaps reports whether the span [start, end] overlaps the period
+// [from, to], where a zero time leaves that end of the period open
+func overlaps(start, end, from, to time.Time) bool {
+	if !from.IsZero() && end.Before(from) {
+		return false
+	}
+	if !to.IsZero() && start.After(to) {
+		return false
+	}
+	return true
+}
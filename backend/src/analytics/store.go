@@ -0,0 +1,375 @@
+// Package analytics keeps analytics events in batched, zstd-compressed
+// segment files with an index, so old events stay queryable at a fraction
+// of the size of the JSON lines log.
+//
+// Each batch of events is written as JSON lines in its own zstd frame and
+// appended to the current segment file. Segments are rotated by size, and
+// index.json records the byte range and time span of every batch so
+// readers only decompress the batches they need.
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// indexFile is the name of the index in the store directory
+const indexFile = "index.json"
+
+// zapTimeLayout is the ISO 8601 layout of the zap log encoder
+const zapTimeLayout = "2006-01-02T15:04:05.000Z0700"
+
+// Event represents an analytics event
+type Event struct {
+	UserID    string    `json:"user_id"`
+	EventType string    `json:"event_type"`
+	Details   string    `json:"details,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Batch represents a compressed batch of events in a segment
+type Batch struct {
+	Offset int64     `json:"offset"`
+	Length int64     `json:"length"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Events int       `json:"events"`
+}
+
+// Segment represents a segment file and its batches
+type Segment struct {
+	File    string    `json:"file"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Events  int       `json:"events"`
+	Size    int64     `json:"size"`
+	Batches []Batch   `json:"batches"`
+}
+
+// Index lists the segments of a store, oldest first
+type Index struct {
+	Segments []*Segment `json:"segments"`
+}
+
+// Options controls batching and rotation of a store
+type Options struct {
+	Dir           string
+	BatchSize     int           // events per batch
+	FlushInterval time.Duration // longest time events wait in memory
+	SegmentSize   int64         // bytes after which a new segment is started
+}
+
+// Store writes analytics events to compressed segments. It implements
+// zapcore.WriteSyncer, so the analytics logger can write to it directly.
+type Store struct {
+	opts    Options
+	index   *Index
+	file    *os.File // current segment, nil until the next flush
+	encoder *zstd.Encoder
+	pending []Event
+	mutex   sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Open opens the store in opts.Dir, creating it if needed, and starts
+// flushing batches in the background. A segment written past its last
+// indexed batch, after a crash, is truncated to it.
+func Open(opts Options) (*Store, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 10 * time.Second
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 64 << 20
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create analytics directory: %v", err)
+	}
+
+	index, err := ReadIndex(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+	}
+
+	s := &Store{
+		opts:    opts,
+		index:   index,
+		encoder: encoder,
+		pending: make([]Event, 0, opts.BatchSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	// Keep appending to the last segment if it has room
+	if n := len(index.Segments); n > 0 && index.Segments[n-1].Size < opts.SegmentSize {
+		last := index.Segments[n-1]
+		file, err := os.OpenFile(filepath.Join(opts.Dir, last.File), os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open analytics segment: %v", err)
+		}
+		if err := file.Truncate(last.Size); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to recover analytics segment: %v", err)
+		}
+		if _, err := file.Seek(last.Size, 0); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to recover analytics segment: %v", err)
+		}
+		s.file = file
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// Write adds the events of JSON lines as written by the analytics logger
+func (s *Store) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(p, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		event, err := ParseEvent(line)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.Append(event); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Append adds an event, flushing the batch once it is full
+func (s *Store) Append(event Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pending = append(s.pending, event)
+	if len(s.pending) >= s.opts.BatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// Pending counts the events waiting for the next batch
+func (s *Store) Pending() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.pending)
+}
+
+// Sync writes the pending events as a batch
+func (s *Store) Sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.flush()
+}
+
+// Close flushes the pending events and closes the store
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.flush()
+	if s.file != nil {
+		if closeErr := s.file.Close(); err == nil {
+			err = closeErr
+		}
+		s.file = nil
+	}
+	s.encoder.Close()
+
+	return err
+}
+
+// Dir gets the directory of the store
+func (s *Store) Dir() string {
+	return s.opts.Dir
+}
+
+// run flushes pending events every flush interval
+func (s *Store) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Sync(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to flush analytics events: %v\n", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// flush compresses the pending events into a batch, appends it to the
+// current segment and saves the index. The caller must hold the lock.
+func (s *Store) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	batch := Batch{From: s.pending[0].Timestamp, To: s.pending[0].Timestamp, Events: len(s.pending)}
+	for _, event := range s.pending {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("failed to encode analytics event: %v", err)
+		}
+		if event.Timestamp.Before(batch.From) {
+			batch.From = event.Timestamp
+		}
+		if event.Timestamp.After(batch.To) {
+			batch.To = event.Timestamp
+		}
+	}
+	frame := s.encoder.EncodeAll(buf.Bytes(), nil)
+
+	if s.file == nil {
+		if err := s.rotate(batch.From); err != nil {
+			return err
+		}
+	}
+	segment := s.index.Segments[len(s.index.Segments)-1]
+
+	batch.Offset = segment.Size
+	batch.Length = int64(len(frame))
+	if _, err := s.file.Write(frame); err != nil {
+		// Drop the partial frame so the segment ends on a batch
+		s.file.Truncate(segment.Size)
+		s.file.Seek(segment.Size, 0)
+		return fmt.Errorf("failed to write analytics batch: %v", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write analytics batch: %v", err)
+	}
+
+	segment.Batches = append(segment.Batches, batch)
+	segment.Size += batch.Length
+	segment.Events += batch.Events
+	if batch.From.Before(segment.From) {
+		segment.From = batch.From
+	}
+	if batch.To.After(segment.To) {
+		segment.To = batch.To
+	}
+	s.pending = s.pending[:0]
+
+	if err := writeIndex(s.opts.Dir, s.index); err != nil {
+		return err
+	}
+
+	// Start a new segment with the next batch once this one is full
+	if segment.Size >= s.opts.SegmentSize {
+		err := s.file.Close()
+		s.file = nil
+		if err != nil {
+			return fmt.Errorf("failed to close analytics segment: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// rotate starts a new segment. The caller must hold the lock.
+func (s *Store) rotate(start time.Time) error {
+	name := fmt.Sprintf("analytics-%s.jsonl.zst", start.UTC().Format("20060102T150405.000000000"))
+	file, err := os.OpenFile(filepath.Join(s.opts.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create analytics segment: %v", err)
+	}
+
+	s.file = file
+	s.index.Segments = append(s.index.Segments, &Segment{File: name, From: start, To: start, Batches: []Batch{}})
+	return nil
+}
+
+// ReadIndex reads the index of a store directory. A missing index is an
+// empty store.
+func ReadIndex(dir string) (*Index, error) {
+	content, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if os.IsNotExist(err) {
+		return &Index{Segments: []*Segment{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read analytics index: %v", err)
+	}
+
+	var index Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse analytics index: %v", err)
+	}
+	return &index, nil
+}
+
+// writeIndex replaces the index of a store directory
+func writeIndex(dir string, index *Index) error {
+	content, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to encode analytics index: %v", err)
+	}
+
+	path := filepath.Join(dir, indexFile)
+	if err := os.WriteFile(path+".tmp", content, 0644); err != nil {
+		return fmt.Errorf("failed to write analytics index: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write analytics index: %v", err)
+	}
+	return nil
+}
+
+// ParseEvent parses an analytics event from a JSON line of the analytics
+// log. The logger writes the log time and the event time under the same
+// key; the event time comes last and wins.
+func ParseEvent(line []byte) (Event, error) {
+	var raw struct {
+		UserID    string `json:"user_id"`
+		EventType string `json:"event_type"`
+		Details   string `json:"details"`
+		Timestamp string `json:"timestamp"`
+	}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return Event{}, fmt.Errorf("invalid analytics event: %v", err)
+	}
+
+	event := Event{UserID: raw.UserID, EventType: raw.EventType, Details: raw.Details}
+	for _, layout := range []string{time.RFC3339Nano, zapTimeLayout} {
+		if timestamp, err := time.Parse(layout, strings.TrimSpace(raw.Timestamp)); err == nil {
+			event.Timestamp = timestamp
+			break
+		}
+	}
+	if event.Timestamp.IsZero() {
+		return Event{}, fmt.Errorf("invalid analytics event time: %q", raw.Timestamp)
+	}
+
+	return event, nil
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// shardCount is the number of independently locked shards per cache
+const shardCount = 16
+
+// sweepInterval is how many writes a shard takes between removals of
+// expired entries, so caches stay bounded without a background goroutine
+const sweepInterval = 256
+
+// entry holds a cached value and when it expires
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// shard is a part of the cache with its own lock
+type shard[K ~string, V any] struct {
+	entries map[K]entry[V]
+	writes  int
+	mutex   sync.RWMutex
+}
+
+// Cache is a concurrency-safe in-memory cache whose entries expire after a
+// TTL. Keys are spread over shards so unrelated lookups do not contend on
+// one lock. Hits, misses and evictions are exported per cache name.
+type Cache[K ~string, V any] struct {
+	name   string
+	ttl    time.Duration
+	seed   maphash.Seed
+	shards [shardCount]*shard[K, V]
+	loads  sync.Map // key -> *load[V], in-flight loads
+}
+
+// load is an in-flight load shared by concurrent callers of GetOrLoad
+type load[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// New creates a cache whose entries expire after ttl. The name labels the
+// cache's metrics.
+func New[K ~string, V any](name string, ttl time.Duration) *Cache[K, V] {
+	c := &Cache[K, V]{
+		name: name,
+		ttl:  ttl,
+		seed: maphash.MakeSeed(),
+	}
+	for i := range c.shards {
+		c.shards[i] = &shard[K, V]{entries: make(map[K]entry[V])}
+	}
+	return c
+}
+
+// shardFor gets the shard a key belongs to
+func (c *Cache[K, V]) shardFor(key K) *shard[K, V] {
+	return c.shards[maphash.String(c.seed, string(key))%shardCount]
+}
+
+// Get gets a value if it is cached and has not expired
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	s := c.shardFor(key)
+
+	s.mutex.RLock()
+	e, ok := s.entries[key]
+	s.mutex.RUnlock()
+
+	if !ok || time.Now().After(e.expires) {
+		cacheMisses.WithLabelValues(c.name).Inc()
+		var zero V
+		return zero, false
+	}
+
+	cacheHits.WithLabelValues(c.name).Inc()
+	return e.value, true
+}
+
+// Set caches a value for the cache's TTL
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL caches a value for the given TTL
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	s := c.shardFor(key)
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries[key] = entry[V]{value: value, expires: now.Add(ttl)}
+
+	// Remove expired entries every so often
+	s.writes++
+	if s.writes%sweepInterval == 0 {
+		evicted := 0
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+				evicted++
+			}
+		}
+		cacheEvictions.WithLabelValues(c.name).Add(float64(evicted))
+	}
+}
+
+// GetOrLoad gets a cached value, calling load to fill the cache on a miss.
+// Concurrent misses for the same key share a single load. Errors are not
+// cached.
+func (c *Cache[K, V]) GetOrLoad(key K, loader func() (V, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+
+	l := &load[V]{done: make(chan struct{})}
+	if existing, loaded := c.loads.LoadOrStore(key, l); loaded {
+		l = existing.(*load[V])
+		<-l.done
+		return l.value, l.err
+	}
+
+	l.value, l.err = loader()
+	if l.err == nil {
+		c.Set(key, l.value)
+	}
+	c.loads.Delete(key)
+	close(l.done)
+
+	return l.value, l.err
+}
+
+// Delete removes a value from the cache
+func (c *Cache[K, V]) Delete(key K) {
+	s := c.shardFor(key)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.entries, key)
+}
+
+// Purge removes every value from the cache
+func (c *Cache[K, V]) Purge() {
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		s.entries = make(map[K]entry[V])
+		s.mutex.Unlock()
+	}
+}
+
+// Len gets the number of cached values, including expired ones that have
+// not been removed yet
+func (c *Cache[K, V]) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mutex.RLock()
+		n += len(s.entries)
+		s.mutex.RUnlock()
+	}
+	return n
+}
@@ -0,0 +1,32 @@
+package cache
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	cacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "vpn_cache_hits_total",
+			Help: "Total number of cache lookups that found a fresh value",
+		},
+		[]string{"cache"},
+	)
+	cacheMisses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "vpn_cache_misses_total",
+			Help: "Total number of cache lookups that found no value or an expired one",
+		},
+		[]string{"cache"},
+	)
+	cacheEvictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "vpn_cache_evictions_total",
+			Help: "Total number of expired cache entries removed",
+		},
+		[]string{"cache"},
+	)
+)
+
+func init() {
+	// Register metrics with Prometheus
+	prometheus.MustRegister(cacheHits, cacheMisses, cacheEvictions)
+}
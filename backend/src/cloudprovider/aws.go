@@ -0,0 +1,186 @@
+package cloudprovider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/vpn-service/backend/src/config"
+)
+
+// ec2APIVersion is the version of the EC2 query API used
+const ec2APIVersion = "2016-11-15"
+
+// AWS runs servers on EC2 instances through the EC2 query API. Credentials
+// come from the standard AWS chain (environment, shared config or instance
+// role); the region is the template's.
+type AWS struct {
+	cfg         config.AWSProvisionConfig
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	client      *http.Client
+}
+
+// ec2Instance is an instance in EC2 API responses
+type ec2Instance struct {
+	ID    string `xml:"instanceId"`
+	IP    string `xml:"ipAddress"`
+	State string `xml:"instanceState>name"`
+}
+
+// NewAWS creates an AWS provider
+func NewAWS(ctx context.Context, cfg config.AWSProvisionConfig) (*AWS, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+
+	return &AWS{
+		cfg:         cfg,
+		credentials: awsCfg.Credentials,
+		signer:      v4.NewSigner(),
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Create runs an instance, tagged with its name
+func (p *AWS) Create(ctx context.Context, spec MachineSpec) (*Machine, error) {
+	params := url.Values{}
+	params.Set("Action", "RunInstances")
+	params.Set("ImageId", spec.Image)
+	params.Set("InstanceType", spec.Size)
+	params.Set("MinCount", "1")
+	params.Set("MaxCount", "1")
+	params.Set("UserData", base64.StdEncoding.EncodeToString([]byte(spec.UserData)))
+	if p.cfg.SubnetID != "" {
+		params.Set("SubnetId", p.cfg.SubnetID)
+	}
+	for i, group := range p.cfg.SecurityGroupIDs {
+		params.Set("SecurityGroupId."+strconv.Itoa(i+1), group)
+	}
+	if p.cfg.KeyName != "" {
+		params.Set("KeyName", p.cfg.KeyName)
+	}
+	params.Set("TagSpecification.1.ResourceType", "instance")
+	params.Set("TagSpecification.1.Tag.1.Key", "Name")
+	params.Set("TagSpecification.1.Tag.1.Value", spec.Name)
+	params.Set("TagSpecification.1.Tag.2.Key", "vpn-service")
+	params.Set("TagSpecification.1.Tag.2.Value", "node")
+
+	var result struct {
+		Instances []ec2Instance `xml:"instancesSet>item"`
+	}
+	if err := p.do(ctx, spec.Region, params, &result); err != nil {
+		return nil, fmt.Errorf("failed to run instance %s: %v", spec.Name, err)
+	}
+	if len(result.Instances) == 0 {
+		return nil, fmt.Errorf("failed to run instance %s: no instance in response", spec.Name)
+	}
+
+	return result.Instances[0].machine(), nil
+}
+
+// Get gets an instance
+func (p *AWS) Get(ctx context.Context, region, id string) (*Machine, error) {
+	params := url.Values{}
+	params.Set("Action", "DescribeInstances")
+	params.Set("InstanceId.1", id)
+
+	var result struct {
+		Instances []ec2Instance `xml:"reservationSet>item>instancesSet>item"`
+	}
+	if err := p.do(ctx, region, params, &result); err != nil {
+		return nil, fmt.Errorf("failed to describe instance %s: %v", id, err)
+	}
+	if len(result.Instances) == 0 {
+		return nil, fmt.Errorf("instance not found: %s", id)
+	}
+
+	return result.Instances[0].machine(), nil
+}
+
+// Delete terminates an instance
+func (p *AWS) Delete(ctx context.Context, region, id string) error {
+	params := url.Values{}
+	params.Set("Action", "TerminateInstances")
+	params.Set("InstanceId.1", id)
+
+	if err := p.do(ctx, region, params, nil); err != nil && !strings.Contains(err.Error(), "InvalidInstanceID.NotFound") {
+		return fmt.Errorf("failed to terminate instance %s: %v", id, err)
+	}
+	return nil
+}
+
+// PublicIPCommand reads the address from the instance metadata service,
+// with a session token as IMDSv2 requires
+func (p *AWS) PublicIPCommand() string {
+	return `curl -fsS -H "X-aws-ec2-metadata-token: $(curl -fsS -X PUT -H 'X-aws-ec2-metadata-token-ttl-seconds: 60' http://169.254.169.254/latest/api/token)" http://169.254.169.254/latest/meta-data/public-ipv4`
+}
+
+// machine converts an instance
+func (i *ec2Instance) machine() *Machine {
+	return &Machine{ID: i.ID, IP: i.IP, Status: i.State}
+}
+
+// do sends a signed request to the EC2 query API of a region and decodes
+// the XML response into result, if given
+func (p *AWS) do(ctx context.Context, region string, params url.Values, result interface{}) error {
+	if region == "" {
+		return fmt.Errorf("AWS region is required")
+	}
+	params.Set("Version", ec2APIVersion)
+	payload := params.Encode()
+
+	endpoint := fmt.Sprintf("https://ec2.%s.amazonaws.com/", region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	// Sign the request with SigV4
+	credentials, err := p.credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %v", err)
+	}
+	hash := sha256.Sum256([]byte(payload))
+	if err := p.signer.SignHTTP(ctx, credentials, req, hex.EncodeToString(hash[:]), "ec2", region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign EC2 request: %v", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Code    string `xml:"Errors>Error>Code"`
+			Message string `xml:"Errors>Error>Message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		xml.Unmarshal(data, &failure)
+		return fmt.Errorf("EC2 returned status %d: %s: %s", resp.StatusCode, failure.Code, failure.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid EC2 response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package cloudprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// digitalOceanAPI is the base URL of the DigitalOcean v2 API
+const digitalOceanAPI = "https://api.digitalocean.com/v2"
+
+// DigitalOcean runs servers on droplets
+type DigitalOcean struct {
+	token   string
+	sshKeys []string
+	client  *http.Client
+}
+
+// digitalOceanDroplet is a droplet in DigitalOcean API responses
+type digitalOceanDroplet struct {
+	ID       int64  `json:"id"`
+	Status   string `json:"status"`
+	Networks struct {
+		V4 []struct {
+			IPAddress string `json:"ip_address"`
+			Type      string `json:"type"`
+		} `json:"v4"`
+	} `json:"networks"`
+}
+
+// NewDigitalOcean creates a DigitalOcean provider
+func NewDigitalOcean(cfg config.DigitalOceanConfig) (*DigitalOcean, error) {
+	if cfg.APIToken == "" {
+		return nil, fmt.Errorf("digitalocean API token is required")
+	}
+
+	return &DigitalOcean{
+		token:   cfg.APIToken,
+		sshKeys: cfg.SSHKeys,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Create creates a droplet
+func (p *DigitalOcean) Create(ctx context.Context, spec MachineSpec) (*Machine, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"name":      spec.Name,
+		"region":    spec.Region,
+		"size":      spec.Size,
+		"image":     spec.Image,
+		"user_data": spec.UserData,
+		"ssh_keys":  p.sshKeys,
+		"tags":      []string{"vpn-service"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Droplet digitalOceanDroplet `json:"droplet"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/droplets", body, &result); err != nil {
+		return nil, fmt.Errorf("failed to create droplet %s: %v", spec.Name, err)
+	}
+
+	return result.Droplet.machine(), nil
+}
+
+// Get gets a droplet
+func (p *DigitalOcean) Get(ctx context.Context, region, id string) (*Machine, error) {
+	var result struct {
+		Droplet digitalOceanDroplet `json:"droplet"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/droplets/"+id, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to get droplet %s: %v", id, err)
+	}
+
+	return result.Droplet.machine(), nil
+}
+
+// Delete deletes a droplet
+func (p *DigitalOcean) Delete(ctx context.Context, region, id string) error {
+	if err := p.do(ctx, http.MethodDelete, "/droplets/"+id, nil, nil); err != nil && err != errNotFound {
+		return fmt.Errorf("failed to delete droplet %s: %v", id, err)
+	}
+	return nil
+}
+
+// PublicIPCommand reads the address from the droplet metadata service
+func (p *DigitalOcean) PublicIPCommand() string {
+	return "curl -fsS http://169.254.169.254/metadata/v1/interfaces/public/0/ipv4/address"
+}
+
+// machine converts a droplet
+func (d *digitalOceanDroplet) machine() *Machine {
+	machine := &Machine{ID: strconv.FormatInt(d.ID, 10), Status: d.Status}
+	for _, network := range d.Networks.V4 {
+		if network.Type == "public" {
+			machine.IP = network.IPAddress
+			break
+		}
+	}
+	return machine
+}
+
+// do sends a request to the DigitalOcean API and decodes the response
+// into result, if given
+func (p *DigitalOcean) do(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, digitalOceanAPI+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, failure.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response (status %d): %v", resp.StatusCode, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package cloudprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// hetznerAPI is the base URL of the Hetzner Cloud API
+const hetznerAPI = "https://api.hetzner.cloud/v1"
+
+// Hetzner runs servers on Hetzner Cloud servers
+type Hetzner struct {
+	token   string
+	sshKeys []string
+	client  *http.Client
+}
+
+// hetznerServer is a server in Hetzner Cloud API responses
+type hetznerServer struct {
+	ID        int64  `json:"id"`
+	Status    string `json:"status"`
+	PublicNet struct {
+		IPv4 struct {
+			IP string `json:"ip"`
+		} `json:"ipv4"`
+	} `json:"public_net"`
+}
+
+// NewHetzner creates a Hetzner Cloud provider
+func NewHetzner(cfg config.HetznerConfig) (*Hetzner, error) {
+	if cfg.APIToken == "" {
+		return nil, fmt.Errorf("hetzner API token is required")
+	}
+
+	return &Hetzner{
+		token:   cfg.APIToken,
+		sshKeys: cfg.SSHKeys,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Create creates a server
+func (p *Hetzner) Create(ctx context.Context, spec MachineSpec) (*Machine, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"name":        spec.Name,
+		"location":    spec.Region,
+		"server_type": spec.Size,
+		"image":       spec.Image,
+		"user_data":   spec.UserData,
+		"ssh_keys":    p.sshKeys,
+		"labels":      map[string]string{"vpn-service": "node"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Server hetznerServer `json:"server"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/servers", body, &result); err != nil {
+		return nil, fmt.Errorf("failed to create server %s: %v", spec.Name, err)
+	}
+
+	return result.Server.machine(), nil
+}
+
+// Get gets a server
+func (p *Hetzner) Get(ctx context.Context, region, id string) (*Machine, error) {
+	var result struct {
+		Server hetznerServer `json:"server"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/servers/"+id, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to get server %s: %v", id, err)
+	}
+
+	return result.Server.machine(), nil
+}
+
+// Delete deletes a server
+func (p *Hetzner) Delete(ctx context.Context, region, id string) error {
+	if err := p.do(ctx, http.MethodDelete, "/servers/"+id, nil, nil); err != nil && err != errNotFound {
+		return fmt.Errorf("failed to delete server %s: %v", id, err)
+	}
+	return nil
+}
+
+// PublicIPCommand reads the address from the Hetzner metadata service
+func (p *Hetzner) PublicIPCommand() string {
+	return "curl -fsS http://169.254.169.254/hetzner/v1/metadata/public-ipv4"
+}
+
+// machine converts a server
+func (s *hetznerServer) machine() *Machine {
+	return &Machine{
+		ID:     strconv.FormatInt(s.ID, 10),
+		IP:     s.PublicNet.IPv4.IP,
+		Status: s.Status,
+	}
+}
+
+// do sends a request to the Hetzner Cloud API and decodes the response
+// into result, if given
+func (p *Hetzner) do(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, hetznerAPI+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("status %d: %s: %s", resp.StatusCode, failure.Error.Code, failure.Error.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response (status %d): %v", resp.StatusCode, err)
+	}
+	return nil
+}
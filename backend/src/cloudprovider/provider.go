@@ -0,0 +1,55 @@
+package cloudprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// errNotFound is returned by provider APIs for machines that do not exist
+var errNotFound = errors.New("not found")
+
+// MachineSpec describes a machine to create
+type MachineSpec struct {
+	Name     string
+	Region   string
+	Size     string
+	Image    string
+	UserData string // script run on first boot
+}
+
+// Machine represents a machine at a cloud provider
+type Machine struct {
+	ID     string
+	IP     string // public IPv4 address, empty until assigned
+	Status string // as reported by the provider
+}
+
+// Provider creates and deletes the machines servers run on
+type Provider interface {
+	// Create starts creating a machine; it may not have an address yet
+	Create(ctx context.Context, spec MachineSpec) (*Machine, error)
+	// Get gets a machine created in a region
+	Get(ctx context.Context, region, id string) (*Machine, error)
+	// Delete deletes a machine, succeeding if it is already gone
+	Delete(ctx context.Context, region, id string) error
+	// PublicIPCommand is a shell command printing the public IPv4 address
+	// of the machine it runs on, from the provider's metadata service
+	PublicIPCommand() string
+}
+
+// New creates a provider by name with the provisioning configuration
+func New(ctx context.Context, name string, cfg config.ProvisioningConfig) (Provider, error) {
+	switch name {
+	case "aws":
+		return NewAWS(ctx, cfg.AWS)
+	case "digitalocean":
+		return NewDigitalOcean(cfg.DigitalOcean)
+	case "hetzner":
+		return NewHetzner(cfg.Hetzner)
+	default:
+		return nil, fmt.Errorf("unsupported cloud provider: %q", name)
+	}
+}
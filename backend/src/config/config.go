@@ -8,33 +8,131 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Server     ServerConfig     `json:"server"`
-	Database   DatabaseConfig   `json:"database"`
-	JWT        JWTConfig        `json:"jwt"`
-	WireGuard  WireGuardConfig  `json:"wireguard"`
-	Monitoring MonitoringConfig `json:"monitoring"`
-	APIAddr    string           `json:"apiAddr"`
+	Server       ServerConfig       `json:"server"`
+	Database     DatabaseConfig     `json:"database"`
+	JWT          JWTConfig          `json:"jwt"`
+	MFA          MFAConfig          `json:"mfa"`
+	WireGuard    WireGuardConfig    `json:"wireguard"`
+	Monitoring   MonitoringConfig   `json:"monitoring"`
+	RateLimit    RateLimitConfig    `json:"rateLimit"`
+	Nodes        NodesConfig        `json:"nodes"`
+	Timeouts     TimeoutsConfig     `json:"timeouts"`
+	Cache        CacheConfig        `json:"cache"`
+	Certificates CertificatesConfig `json:"certificates"`
+	API          APIConfig          `json:"api"`
+	GRPC         GRPCConfig         `json:"grpc"`
+	Geo          GeoConfig          `json:"geo"`
+	Push         PushConfig         `json:"push"`
+	Inactivity   InactivityConfig   `json:"inactivity"`
+	Email        EmailConfig        `json:"email"`
+	Shadow       ShadowConfig       `json:"shadow"`
+	Recommend    RecommendConfig    `json:"recommend"`
+	Balancing    BalancingConfig    `json:"balancing"`
+	StatusPage   StatusPageConfig   `json:"statusPage"`
+	Provisioning ProvisioningConfig `json:"provisioning"`
+	Autoscaling  AutoscalingConfig  `json:"autoscaling"`
+	Discovery    DiscoveryConfig    `json:"discovery"`
+	Kubernetes   KubernetesConfig   `json:"kubernetes"`
+	Standalone   StandaloneConfig   `json:"standalone"`
+	Agent        AgentConfig        `json:"agent"`
+	Storage      StorageConfig      `json:"storage"`
+	APIAddr      string             `json:"apiAddr"`
 }
 
 // ServerConfig holds the server configuration
 type ServerConfig struct {
-	Port int    `json:"port"`
-	Host string `json:"host"`
+	Port           int      `json:"port"`
+	Host           string   `json:"host"`
+	AdminAllowlist []string `json:"adminAllowlist"` // CIDRs allowed to reach /api/admin, empty allows all
+	TrustedProxies []string `json:"trustedProxies"` // CIDRs of reverse proxies whose X-Forwarded-For/X-Real-IP headers are honored
+	UnixSocket     string   `json:"unixSocket"`     // path of a Unix socket to serve the API on instead of apiAddr
+	UnixSocketMode string   `json:"unixSocketMode"` // octal permissions of the Unix socket
+	SystemdNotify  bool     `json:"systemdNotify"`  // report readiness and watchdog pings to systemd over sd_notify
+
+	// Listener tuning and cross-origin settings
+	Profile  string                     `json:"profile"`  // listener profile of the API
+	Profiles map[string]ListenerProfile `json:"profiles"` // listener tuning by name, see ListenerProfile
+	CORS     CORSConfig                 `json:"cors"`
+}
+
+// ListenerProfile holds the timeouts and limits of a listener. The public
+// profile suits the API exposed to clients, internal the control plane
+// used by services and node agents, and ops the metrics endpoint.
+type ListenerProfile struct {
+	ReadTimeout       int  `json:"readTimeout"`       // in seconds to read a request, 0 for none
+	ReadHeaderTimeout int  `json:"readHeaderTimeout"` // in seconds to read request headers, 0 for none
+	WriteTimeout      int  `json:"writeTimeout"`      // in seconds to write a response, 0 for none
+	IdleTimeout       int  `json:"idleTimeout"`       // in seconds an idle keep-alive connection stays open
+	MaxHeaderBytes    int  `json:"maxHeaderBytes"`
+	KeepAlive         bool `json:"keepAlive"` // reuse connections between requests
+}
+
+// CORSConfig holds the cross-origin settings of the API
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	MaxAge           int      `json:"maxAge"` // in seconds browsers may cache preflight responses
+}
+
+// APIConfig holds the API versioning and spec validation configuration
+type APIConfig struct {
+	DefaultVersion   string            `json:"defaultVersion"`   // version served on the unversioned /api/* aliases
+	Sunsets          map[string]string `json:"sunsets"`          // deprecated version -> sunset date (YYYY-MM-DD)
+	ValidateRequests bool              `json:"validateRequests"` // reject requests not matching the OpenAPI spec; for development only
+	StatusInterval   int               `json:"statusInterval"`   // in seconds between status checks of /vpn/ws streams
+	PublicURL        string            `json:"publicUrl"`        // external base URL of the service, for links in responses
+	ShareTTL         int               `json:"shareTtl"`         // in minutes a one-time config share link stays valid
+	ServerListMaxAge int               `json:"serverListMaxAge"` // in seconds clients may use the server list before revalidating it
+}
+
+// GRPCConfig holds the configuration of the gRPC control-plane API
+type GRPCConfig struct {
+	Enabled        bool   `json:"enabled"`
+	Addr           string `json:"addr"`
+	TLSCert        string `json:"tlsCert"`        // PEM certificate file, empty serves plaintext
+	TLSKey         string `json:"tlsKey"`         // PEM private key file
+	StatusInterval int    `json:"statusInterval"` // in seconds between status checks of WatchStatus streams
+	Profile        string `json:"profile"`        // listener profile of the gRPC server
+
+	Agents AgentListenerConfig `json:"agents"`
+}
+
+// AgentListenerConfig holds the mutual TLS listener of the agent service.
+// Node certificates are issued by a CA generated in caDir on first start.
+type AgentListenerConfig struct {
+	Enabled         bool     `json:"enabled"`
+	Addr            string   `json:"addr"`
+	CADir           string   `json:"caDir"`
+	ServerNames     []string `json:"serverNames"`     // host names and addresses agents dial, in the listener certificate
+	CertificateDays int      `json:"certificateDays"` // validity of node certificates
+	EnrollmentTTL   int      `json:"enrollmentTtl"`   // in minutes an enrollment token stays valid
 }
 
 // DatabaseConfig holds the database configuration
 type DatabaseConfig struct {
+	Driver   string `json:"driver"` // postgres or sqlite
 	Host     string `json:"host"`
 	Port     int    `json:"port"`
 	User     string `json:"user"`
 	Password string `json:"password"`
 	Name     string `json:"name"`
+	Path     string `json:"path"` // database file of the sqlite driver
 }
 
 // JWTConfig holds the JWT configuration
 type JWTConfig struct {
-	Secret     string `json:"secret"`
 	Expiration int    `json:"expiration"` // in hours
+	Algorithm  string `json:"algorithm"`  // RS256 or ES256
+	KeyDir     string `json:"keyDir"`
+	Issuer     string `json:"issuer"`
+	Audience   string `json:"audience"`
+	ClockSkew  int    `json:"clockSkew"` // in seconds
+}
+
+// MFAConfig holds the two-factor authentication configuration
+type MFAConfig struct {
+	Issuer    string `json:"issuer"`    // name shown in authenticator apps
+	StepUpTTL int    `json:"stepUpTTL"` // lifetime of step-up tokens, in seconds
 }
 
 // WireGuardConfig holds the WireGuard configuration
@@ -51,19 +149,549 @@ type WireGuardConfig struct {
 	ServerEndpoint string `json:"serverEndpoint"`
 	AllowedIPs     string `json:"allowedIps"`
 	MTU            int    `json:"mtu"`
+	Keepalive      int    `json:"persistentKeepalive"`
+	DynamicPeerTTL int    `json:"dynamicPeerTTL"` // in minutes
+	Failover       bool   `json:"failover"`       // retry connects on the next-best server when applying a peer fails
+	FailoverMax    int    `json:"failoverMax"`    // number of alternative servers to try
 	PreUp          string `json:"preUp"`
 	PostUp         string `json:"postUp"`
 	PreDown        string `json:"preDown"`
 	PostDown       string `json:"postDown"`
+
+	// DNS profiles are named sets of resolvers users can pick at connect,
+	// such as ad and tracker blocking or family filtering ones
+	DNSProfiles map[string]DNSProfileConfig `json:"dnsProfiles"`
+
+	// NetworkMTU is the client MTU by network type (cellular, wifi or
+	// ethernet) for devices without their own; mtu is used for others
+	NetworkMTU map[string]int `json:"networkMtu"`
+
+	// Implementation selects the kernel module or wireguard-go for the
+	// local interface: auto, kernel or userspace
+	Implementation  string `json:"implementation"`
+	UserspaceBinary string `json:"userspaceBinary"` // wireguard-go executable
+
+	// Obfuscation carries WireGuard over TCP for networks blocking UDP
+	Obfuscation ObfuscationConfig `json:"obfuscation"`
+
+	// Interfaces are additional interfaces next to the primary one, each
+	// with its own port and peer subnet
+	Interfaces []InterfaceConfig `json:"interfaces"`
+
+	// Firewall manages the forwarding, NAT and per-peer rules of the local
+	// interfaces in nftables, in place of rules in postUp and postDown
+	Firewall FirewallConfig `json:"firewall"`
+
+	// ExitTunnels are links from this server to other servers that peers
+	// can pick as their internet exit
+	ExitTunnels []ExitTunnelConfig `json:"exitTunnels"`
+
+	// Drift is the reconciler comparing the peers on the local interfaces
+	// with the ones they should have
+	Drift DriftConfig `json:"drift"`
+
+	// Migration moves the peers of servers that go offline to healthy
+	// servers in the same country
+	Migration MigrationConfig `json:"migration"`
+}
+
+// ExitTunnelConfig holds a link to another server that carries the internet
+// traffic of peers exiting there. The link itself, e.g. a WireGuard
+// interface between the servers, is set up outside the service.
+type ExitTunnelConfig struct {
+	ServerID  string `json:"serverId"`  // server the traffic exits at
+	Interface string `json:"interface"` // local interface of the link
+	Table     int    `json:"table"`     // routing table of peers exiting there
+}
+
+// FirewallConfig holds the settings of the nftables rules of the local
+// interfaces
+type FirewallConfig struct {
+	Enabled         bool   `json:"enabled"`
+	Table           string `json:"table"`           // nftables table of the rules
+	EgressInterface string `json:"egressInterface"` // interface peer traffic leaves the host on
+	Masquerade      bool   `json:"masquerade"`      // masquerade peer traffic leaving on the egress interface
+	PeerIsolation   bool   `json:"peerIsolation"`   // drop traffic between peers
+
+	// Ports of the egress interface forwarded to peers whose plan has
+	// port forwarding, one per peer
+	PortForwardMin int `json:"portForwardMin"`
+	PortForwardMax int `json:"portForwardMax"`
+}
+
+// DriftConfig holds the settings of the drift reconciler
+type DriftConfig struct {
+	Interval    int  `json:"interval"`    // in seconds between checks, 0 disables them
+	AutoCorrect bool `json:"autoCorrect"` // add missing peers and remove unknown ones, otherwise only report them
+}
+
+// MigrationConfig holds the settings of peer migration off offline servers
+type MigrationConfig struct {
+	Enabled bool `json:"enabled"`
+	Grace   int  `json:"grace"` // in seconds a server must stay offline before its peers move
+}
+
+// InterfaceConfig holds an additional WireGuard interface of the server.
+// New devices of users on one of its plans, or obfuscated devices if it is
+// the obfuscated interface, are put on it instead of the primary one.
+type InterfaceConfig struct {
+	Name       string   `json:"name"`
+	ListenPort int      `json:"listenPort"`
+	Address    string   `json:"address"` // server address and peer subnet, e.g. 10.1.0.1/24
+	Plans      []string `json:"plans"`
+	Obfuscated bool     `json:"obfuscated"` // the obfuscation endpoint forwards to this interface
+}
+
+// ObfuscationConfig holds the settings of the wrapper endpoint that carries
+// WireGuard over TCP or WebSocket to the local interface
+type ObfuscationConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Transport  string `json:"transport"`  // websocket (wstunnel) or tcp (udp2raw)
+	Port       int    `json:"port"`       // TCP port of the wrapper endpoint
+	PathPrefix string `json:"pathPrefix"` // HTTP upgrade path prefix of the websocket transport
+	Key        string `json:"key"`        // shared key of the tcp transport
+	Binary     string `json:"binary"`     // wrapper executable, wstunnel or udp2raw by default
 }
 
 // MonitoringConfig holds the monitoring configuration
 type MonitoringConfig struct {
-	LogDir           string `json:"logDir"`
-	EnableAnalytics  bool   `json:"enableAnalytics"`
-	AnalyticsLogFile string `json:"analyticsLogFile"`
-	MetricsPort      int    `json:"metricsPort"`
-	EnablePrometheus bool   `json:"enablePrometheus"`
+	LogDir           string               `json:"logDir"`
+	EnableAnalytics  bool                 `json:"enableAnalytics"`
+	AnalyticsLogFile string               `json:"analyticsLogFile"`
+	MetricsPort      int                  `json:"metricsPort"`
+	MetricsProfile   string               `json:"metricsProfile"` // listener profile of the metrics server
+	EnablePrometheus bool                 `json:"enablePrometheus"`
+	TelemetryMode    string               `json:"telemetryMode"` // opt-out (default) or opt-in
+	Tracing          TracingConfig        `json:"tracing"`
+	ErrorReporting   ErrorReportingConfig `json:"errorReporting"`
+	Anomaly          AnomalyConfig        `json:"anomaly"`
+	Alerts           AlertsConfig         `json:"alerts"`
+
+	// AuditKey keys the HMAC of the audit log hash chain, so the chain
+	// cannot be rewritten by whoever can write the log directory
+	AuditKey string `json:"auditKey"`
+
+	// AnalyticsStore moves analytics events from the JSON lines log to
+	// batched, compressed segments
+	AnalyticsStore AnalyticsStoreConfig `json:"analyticsStore"`
+}
+
+// AnalyticsStoreConfig holds the settings of the compressed analytics store
+type AnalyticsStoreConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Dir           string `json:"dir"`
+	BatchSize     int    `json:"batchSize"`     // events per compressed batch
+	FlushInterval int    `json:"flushInterval"` // seconds events wait for a full batch
+	SegmentSize   int    `json:"segmentSize"`   // in MB, before a new segment file is started
+}
+
+// TracingConfig holds the OpenTelemetry tracing configuration
+type TracingConfig struct {
+	Enabled     bool    `json:"enabled"`
+	Endpoint    string  `json:"endpoint"` // OTLP/HTTP collector address, e.g. otel-collector:4318
+	Insecure    bool    `json:"insecure"` // send spans over plain HTTP
+	ServiceName string  `json:"serviceName"`
+	SampleRatio float64 `json:"sampleRatio"` // share of new traces to sample, 0 to 1
+}
+
+// AnomalyConfig holds the settings of the built-in anomaly detector for
+// connect errors, peer apply latency and authentication failures
+type AnomalyConfig struct {
+	Enabled    bool    `json:"enabled"`
+	Interval   int     `json:"interval"`   // seconds per sample
+	Alpha      float64 `json:"alpha"`      // EWMA smoothing factor, 0 to 1
+	Threshold  float64 `json:"threshold"`  // standard deviations above the baseline that raise an alert
+	MinSamples int     `json:"minSamples"` // samples needed before alerting
+	Seasonal   bool    `json:"seasonal"`   // compare against the baseline for the hour of day once known
+}
+
+// AlertsConfig holds the capacity alert rules and the channels alerts are
+// sent to
+type AlertsConfig struct {
+	Enabled     bool                          `json:"enabled"`
+	Interval    int                           `json:"interval"` // seconds between evaluations
+	Rules       []AlertRule                   `json:"rules"`
+	Channels    map[string]AlertChannelConfig `json:"channels"`    // by name
+	DedupWindow int                           `json:"dedupWindow"` // minutes a resolved alert firing again is not sent again
+}
+
+// AlertRule raises an alert when a condition holds for some minutes
+type AlertRule struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`      // region_load or server_offline
+	Threshold float64  `json:"threshold"` // percent of capacity in use, for region_load
+	Minutes   int      `json:"minutes"`   // how long the condition holds before alerting
+	Regions   []string `json:"regions"`   // optional, countries the rule is limited to
+	Severity  string   `json:"severity"`  // critical, error, warning (default) or info
+	Channels  []string `json:"channels"`  // names of the channels alerts go to, all when empty
+}
+
+// AlertChannelConfig holds the settings of an alert notification channel
+type AlertChannelConfig struct {
+	Type       string   `json:"type"`       // email, webhook, slack or pagerduty
+	Emails     []string `json:"emails"`     // email: addresses alerts are sent to
+	URL        string   `json:"url"`        // webhook: receives alerts as JSON; slack: incoming webhook
+	RoutingKey string   `json:"routingKey"` // pagerduty: Events API v2 integration key
+}
+
+// ErrorReportingConfig holds the Sentry (or compatible) error reporting configuration
+type ErrorReportingConfig struct {
+	DSN         string  `json:"dsn"`        // empty disables error reporting
+	SampleRate  float64 `json:"sampleRate"` // share of errors to report, 0 to 1
+	Environment string  `json:"environment"`
+}
+
+// RateLimitConfig holds the rate limiting configuration
+type RateLimitConfig struct {
+	Enabled       bool                     `json:"enabled"`
+	RedisAddr     string                   `json:"redisAddr"` // empty keeps buckets in memory
+	RedisPassword string                   `json:"redisPassword"`
+	RedisDB       int                      `json:"redisDb"`
+	Rules         map[string]RateLimitRule `json:"rules"`
+}
+
+// RateLimitRule holds the limit for a group of routes
+type RateLimitRule struct {
+	Rate  float64 `json:"rate"`  // tokens added per second
+	Burst int     `json:"burst"` // bucket size
+	Key   string  `json:"key"`   // "user" or "ip"
+}
+
+// NodesConfig holds the configuration for the agents running on VPN nodes
+type NodesConfig struct {
+	AgentToken        string `json:"agentToken"`        // shared secret agents send with heartbeats, empty rejects all
+	RegisterToken     string `json:"registerToken"`     // shared secret new nodes register with, empty disables registration
+	HeartbeatTimeout  int    `json:"heartbeatTimeout"`  // in seconds, after which a node counts as unresponsive
+	HeartbeatInterval int    `json:"heartbeatInterval"` // in seconds between the heartbeats of agents
+	MissedHeartbeats  int    `json:"missedHeartbeats"`  // heartbeats a node may miss before it is marked offline
+	CanarySoak        int    `json:"canarySoak"`        // in minutes the canary must stay healthy before the fleet upgrades
+	RolloutTimeout    int    `json:"rolloutTimeout"`    // in minutes nodes have to report the new version
+	UpgradeCheck      int    `json:"upgradeCheck"`      // in minutes an upgraded node must stay healthy before it takes connects again
+
+	// Reference points agents ping for the latency matrix
+	Probes        []ProbeConfig `json:"probes"`
+	ProbeInterval int           `json:"probeInterval"` // in seconds between measurements
+}
+
+// StorageConfig holds where generated artifacts, such as rendered configs,
+// QR codes, setup sheets and device archives, are kept for download
+type StorageConfig struct {
+	Backend         string          `json:"backend"`         // local or s3
+	Dir             string          `json:"dir"`             // for the local backend
+	SigningKey      string          `json:"signingKey"`      // signs local download links; random on every start when empty
+	URLTTL          int             `json:"urlTtl"`          // in seconds download links stay valid
+	Lifecycle       map[string]int  `json:"lifecycle"`       // key prefix -> hours objects are kept, 0 forever
+	CleanupInterval int             `json:"cleanupInterval"` // in minutes between lifecycle runs
+	S3              S3StorageConfig `json:"s3"`
+}
+
+// S3StorageConfig holds the bucket of the S3 storage backend. Any
+// S3-compatible service can be used by setting the endpoint. Credentials
+// come from the standard AWS chain unless set here.
+type S3StorageConfig struct {
+	Bucket          string `json:"bucket"`
+	Region          string `json:"region"`
+	Endpoint        string `json:"endpoint"`  // e.g. https://minio.example.com, empty for AWS
+	PathStyle       bool   `json:"pathStyle"` // bucket in the path instead of the host name, as most S3-compatible services need
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+}
+
+// CertificatesConfig holds the ACME configuration for the wildcard certificate
+// served by node agent and obfuscation endpoints. Nodes are named
+// <node>.<domain> and the certificate covers <domain> and *.<domain>.
+type CertificatesConfig struct {
+	Enabled            bool             `json:"enabled"`
+	Domain             string           `json:"domain"`
+	Email              string           `json:"email"`
+	DirectoryURL       string           `json:"directoryUrl"` // ACME directory, Let's Encrypt by default
+	StorageDir         string           `json:"storageDir"`
+	RenewBefore        int              `json:"renewBefore"`        // in days before expiry
+	PropagationTimeout int              `json:"propagationTimeout"` // in seconds to wait for challenge records to resolve
+	DNSProvider        string           `json:"dnsProvider"`        // route53 or cloudflare
+	Route53            Route53Config    `json:"route53"`
+	Cloudflare         CloudflareConfig `json:"cloudflare"`
+}
+
+// Route53Config holds the Route53 DNS provider configuration. Credentials
+// come from the standard AWS chain.
+type Route53Config struct {
+	HostedZoneID string `json:"hostedZoneId"`
+	Region       string `json:"region"`
+}
+
+// CloudflareConfig holds the Cloudflare DNS provider configuration
+type CloudflareConfig struct {
+	APIToken string `json:"apiToken"` // needs Zone.DNS edit permission
+	ZoneID   string `json:"zoneId"`
+}
+
+// GeoConfig holds the MaxMind GeoIP2/GeoLite2 databases used to locate servers
+type GeoConfig struct {
+	CityDatabase string `json:"cityDatabase"` // empty disables geo lookups
+	ASNDatabase  string `json:"asnDatabase"`  // optional, adds the network of a server
+}
+
+// PushConfig holds the mobile push notification configuration
+type PushConfig struct {
+	Enabled bool       `json:"enabled"`
+	FCM     FCMConfig  `json:"fcm"`
+	APNs    APNsConfig `json:"apns"`
+}
+
+// FCMConfig holds the Firebase Cloud Messaging configuration for Android clients
+type FCMConfig struct {
+	CredentialsFile string `json:"credentialsFile"` // service account JSON key, empty disables FCM
+	ProjectID       string `json:"projectId"`       // defaults to the project of the service account
+}
+
+// APNsConfig holds the Apple Push Notification service configuration for iOS clients
+type APNsConfig struct {
+	KeyFile string `json:"keyFile"` // .p8 token signing key, empty disables APNs
+	KeyID   string `json:"keyId"`
+	TeamID  string `json:"teamId"`
+	Topic   string `json:"topic"`   // bundle ID of the app
+	Sandbox bool   `json:"sandbox"` // deliver to development builds
+}
+
+// EmailConfig holds the email delivery configuration
+type EmailConfig struct {
+	Backend  string         `json:"backend"` // log, smtp, ses or sendgrid; log only writes emails to the log, for development
+	From     string         `json:"from"`
+	BaseURL  string         `json:"baseUrl"` // public URL of the web app, for links in emails
+	SMTP     SMTPConfig     `json:"smtp"`
+	SES      SESConfig      `json:"ses"`
+	SendGrid SendGridConfig `json:"sendgrid"`
+}
+
+// SMTPConfig holds the SMTP relay configuration. STARTTLS is used when the
+// server offers it.
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"` // empty sends without authentication
+	Password string `json:"password"`
+	TLS      bool   `json:"tls"` // connect over TLS, usually on port 465
+}
+
+// SESConfig holds the Amazon SES configuration. Credentials come from the
+// standard AWS chain.
+type SESConfig struct {
+	Region string `json:"region"`
+}
+
+// SendGridConfig holds the SendGrid configuration
+type SendGridConfig struct {
+	APIKey string `json:"apiKey"`
+}
+
+// InactivityConfig holds the handling of devices that stop connecting,
+// measured in days since their last handshake. 0 disables a step.
+type InactivityConfig struct {
+	NotifyAfter  int `json:"notifyAfter"`  // days before the user is told the device is idle
+	ArchiveAfter int `json:"archiveAfter"` // days before the peer is archived, freeing its address
+}
+
+// ShadowConfig holds the shadow evaluation of server selection algorithms,
+// which run on real connects without affecting the server used
+type ShadowConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Algorithms []string `json:"algorithms"` // selection algorithms to evaluate, empty evaluates every registered one
+	SampleRate float64  `json:"sampleRate"` // fraction of connects evaluated, between 0 and 1
+	History    int      `json:"history"`    // recent decisions kept for the comparison report
+}
+
+// RecommendConfig holds how servers are recommended to clients, weighing
+// the latency clients in a region observed to each server against its load
+type RecommendConfig struct {
+	LatencyWeight float64 `json:"latencyWeight"` // share of the score from latency, 0 to 1; the rest comes from load
+	MaxLatency    int     `json:"maxLatency"`    // in milliseconds, round trips this slow or slower score worst
+	MaxAge        int     `json:"maxAge"`        // in minutes client measurements are used for
+}
+
+// BalancingConfig holds how the optimal server for new connects is picked.
+// Servers are scored on their share of capacity in use, their bandwidth
+// class and their recent peer apply errors, weighted here; the lowest
+// score wins.
+type BalancingConfig struct {
+	LoadWeight       float64            `json:"loadWeight"`       // weight of load over capacity
+	BandwidthWeight  float64            `json:"bandwidthWeight"`  // weight of the bandwidth lacking to the fastest class
+	ErrorWeight      float64            `json:"errorWeight"`      // weight of the share of failed peer applies
+	BandwidthClasses map[string]float64 `json:"bandwidthClasses"` // relative bandwidth by class; servers without a known class count as 1
+	ErrorWindow      int                `json:"errorWindow"`      // in minutes peer applies count towards the error rate
+}
+
+// StatusPageConfig holds the public status page, which shows the
+// availability and uptime of each region and the incidents admins post
+type StatusPageConfig struct {
+	Title        string `json:"title"`        // heading of the HTML page
+	IncidentDays int    `json:"incidentDays"` // days resolved incidents stay listed
+	MaxAge       int    `json:"maxAge"`       // in seconds browsers and CDNs may cache the status
+}
+
+// ProvisioningConfig holds the servers admins create on demand at cloud
+// providers. Machines boot a script that installs the node agent, which
+// registers the server with nodes.registerToken and runs it with
+// nodes.agentToken.
+type ProvisioningConfig struct {
+	Templates    map[string]ProvisionTemplate `json:"templates"`    // by name
+	ControlPlane string                       `json:"controlPlane"` // base URL of the API new nodes reach, e.g. https://api.vpn.example.com
+	AgentURL     string                       `json:"agentUrl"`     // where new nodes download the vpn-agent binary
+	Timeout      int                          `json:"timeout"`      // in minutes a machine has to come online before provisioning fails
+	AWS          AWSProvisionConfig           `json:"aws"`
+	DigitalOcean DigitalOceanConfig           `json:"digitalocean"`
+	Hetzner      HetznerConfig                `json:"hetzner"`
+}
+
+// ProvisionTemplate describes the machines of a kind of server
+type ProvisionTemplate struct {
+	Provider       string `json:"provider"` // aws, digitalocean or hetzner
+	Region         string `json:"region"`   // AWS region, DigitalOcean region or Hetzner location
+	Size           string `json:"size"`     // instance type, droplet size or server type
+	Image          string `json:"image"`    // AMI ID or image slug/name, Debian or Ubuntu
+	Capacity       int    `json:"capacity"`
+	BandwidthClass string `json:"bandwidthClass"` // one of balancing.bandwidthClasses
+}
+
+// AWSProvisionConfig holds the EC2 settings of provisioned machines.
+// Credentials come from the standard AWS chain.
+type AWSProvisionConfig struct {
+	SubnetID         string   `json:"subnetId"`         // defaults to the default VPC's
+	SecurityGroupIDs []string `json:"securityGroupIds"` // must admit the WireGuard port
+	KeyName          string   `json:"keyName"`          // SSH key pair, optional
+}
+
+// DigitalOceanConfig holds the DigitalOcean provisioning configuration
+type DigitalOceanConfig struct {
+	APIToken string   `json:"apiToken"` // needs droplet read and write scopes
+	SSHKeys  []string `json:"sshKeys"`  // IDs or fingerprints of keys added to droplets
+}
+
+// HetznerConfig holds the Hetzner Cloud provisioning configuration
+type HetznerConfig struct {
+	APIToken string   `json:"apiToken"` // of the project, read and write
+	SSHKeys  []string `json:"sshKeys"`  // names or IDs of keys added to servers
+}
+
+// AutoscalingConfig holds the automatic scaling of regions. A region
+// whose online servers are loaded above scaleUpLoad for scaleUpMinutes gets
+// a server provisioned from its template; one loaded below scaleDownLoad
+// for scaleDownMinutes has a server it provisioned drained and torn down.
+type AutoscalingConfig struct {
+	Enabled          bool                       `json:"enabled"`
+	Regions          map[string]AutoscaleRegion `json:"regions"`          // by country, as servers are located
+	ScaleUpLoad      int                        `json:"scaleUpLoad"`      // percent of the region's capacity in use
+	ScaleUpMinutes   int                        `json:"scaleUpMinutes"`   // the load must stay above scaleUpLoad
+	ScaleDownLoad    int                        `json:"scaleDownLoad"`    // percent of the region's capacity in use
+	ScaleDownMinutes int                        `json:"scaleDownMinutes"` // the load must stay below scaleDownLoad
+	Cooldown         int                        `json:"cooldown"`         // in minutes between scaling actions in a region
+	DrainWindow      int                        `json:"drainWindow"`      // in minutes active sessions keep a server scaled down
+}
+
+// AutoscaleRegion holds the bounds of a region
+type AutoscaleRegion struct {
+	Template string `json:"template"` // of provisioning.templates, placing servers in the region
+	Min      int    `json:"min"`      // servers
+	Max      int    `json:"max"`      // servers
+}
+
+// DiscoveryConfig holds the service discovery backend the node inventory
+// is sourced from instead of the built-in server list
+type DiscoveryConfig struct {
+	Backend string              `json:"backend"` // consul, etcd or file, empty for none
+	Consul  ConsulConfig        `json:"consul"`
+	Etcd    EtcdConfig          `json:"etcd"`
+	File    FileDiscoveryConfig `json:"file"`
+}
+
+// ConsulConfig holds the Consul service nodes register as. Service meta
+// carries name, country, city, capacity, bandwidthClass and publicKey.
+type ConsulConfig struct {
+	Address    string `json:"address"`    // HTTP API of an agent, e.g. http://127.0.0.1:8500
+	Token      string `json:"token"`      // ACL token with read access to the service
+	Service    string `json:"service"`    // name nodes register as
+	Datacenter string `json:"datacenter"` // empty for the agent's
+}
+
+// EtcdConfig holds the etcd keys nodes are stored under, one key per node
+// holding its JSON
+type EtcdConfig struct {
+	Endpoint string `json:"endpoint"` // HTTP gateway of a member, e.g. http://127.0.0.1:2379
+	Prefix   string `json:"prefix"`   // of the node keys
+	Username string `json:"username"` // empty when authentication is disabled
+	Password string `json:"password"`
+}
+
+// KubernetesConfig holds the controller mode, where servers and peers are
+// VPNServer and VPNPeer custom resources the backend reconciles. The API
+// server and credentials default to the pod's service account.
+type KubernetesConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Namespace string `json:"namespace"` // to watch, empty for all namespaces
+	Resync    int    `json:"resync"`    // in seconds between full reconciliations
+	APIServer string `json:"apiServer"` // e.g. https://10.0.0.1:443, empty in a cluster
+	TokenFile string `json:"tokenFile"`
+	CAFile    string `json:"caFile"`
+}
+
+// FileDiscoveryConfig holds the inventory file servers are declared in,
+// e.g. kept in git by small deployments
+type FileDiscoveryConfig struct {
+	Path     string `json:"path"`     // YAML, or JSON with a .json extension
+	Interval int    `json:"interval"` // in seconds between checks for changes
+}
+
+// StandaloneConfig holds the all-in-one mode selected with --standalone,
+// where the API, an embedded SQLite database, the node agent and WireGuard
+// run on a single host
+type StandaloneConfig struct {
+	DataDir    string `json:"dataDir"`    // database, keys, peers and logs are kept here
+	ServerName string `json:"serverName"` // name the host is listed under
+	Country    string `json:"country"`
+	City       string `json:"city"`
+	Heartbeat  int    `json:"heartbeat"` // in seconds between heartbeats of the built-in node agent
+}
+
+// AgentConfig holds the node agent (cmd/agent) that runs on each VPN server.
+// It authenticates with the agent token from the nodes section.
+type AgentConfig struct {
+	ServerID     string `json:"serverId"`     // server the agent runs on, as listed in the control plane
+	ControlPlane string `json:"controlPlane"` // base URL of the API, e.g. https://api.vpn.example.com
+	Interval     int    `json:"interval"`     // in seconds between heartbeats and state syncs
+
+	// The agent service of the control plane, used instead of the REST
+	// routes when grpcAddr is set. The node certificate is kept in certDir
+	// after enrolling with the one-time enrollmentToken.
+	GRPCAddr        string `json:"grpcAddr"`
+	CAFile          string `json:"caFile"` // certificate of the agent CA
+	CertDir         string `json:"certDir"`
+	EnrollmentToken string `json:"enrollmentToken"`
+
+	// Shell command upgrade commands run, with {version} replaced by the
+	// agent version to install. It should install the version and have
+	// the service manager restart the agent; empty rejects upgrades.
+	UpgradeCommand string `json:"upgradeCommand"`
+}
+
+// TimeoutsConfig holds per-operation deadlines in seconds, 0 disables a deadline.
+// Request deadlines should stay below the HTTP write timeout.
+type TimeoutsConfig struct {
+	Connect    int `json:"connect"` // whole connect, including failover attempts
+	Disconnect int `json:"disconnect"`
+	Config     int `json:"config"`
+	Status     int `json:"status"`
+	NodeApply  int `json:"nodeApply"` // a single configuration apply on a node
+}
+
+// CacheConfig holds in-memory cache lifetimes in seconds, 0 disables a cache
+type CacheConfig struct {
+	ServerLists int `json:"serverLists"`
+	Templates   int `json:"templates"` // config template overrides are looked up again after this
+	UserPlans   int `json:"userPlans"` // plan changes made on other instances apply after this
+
+	// TokenRevocations is how long a token found not revoked is trusted;
+	// revocations made on other instances apply after this
+	TokenRevocations int `json:"tokenRevocations"`
 }
 
 // Load loads the configuration from the config file
@@ -72,18 +700,35 @@ func Load() (*Config, error) {
 	config := &Config{
 		APIAddr: "0.0.0.0:8080",
 		Server: ServerConfig{
-			Port: 8080,
-			Host: "0.0.0.0",
+			Port:           8080,
+			Host:           "0.0.0.0",
+			UnixSocketMode: "0660",
+			Profile:        "public",
+			Profiles: map[string]ListenerProfile{
+				"public":   {ReadTimeout: 15, ReadHeaderTimeout: 5, WriteTimeout: 15, IdleTimeout: 60, MaxHeaderBytes: 1 << 20, KeepAlive: true},
+				"internal": {ReadTimeout: 30, ReadHeaderTimeout: 10, WriteTimeout: 60, IdleTimeout: 300, MaxHeaderBytes: 1 << 20, KeepAlive: true},
+				"ops":      {ReadTimeout: 10, ReadHeaderTimeout: 5, WriteTimeout: 30, IdleTimeout: 60, MaxHeaderBytes: 64 << 10, KeepAlive: true},
+			},
+			CORS: CORSConfig{
+				AllowedOrigins:   []string{"*"},
+				AllowCredentials: true,
+				MaxAge:           86400,
+			},
 		},
 		Database: DatabaseConfig{
-			Host: "localhost",
-			Port: 5432,
-			User: "postgres",
-			Name: "vpn_service",
+			Driver: "postgres",
+			Host:   "localhost",
+			Port:   5432,
+			User:   "postgres",
+			Name:   "vpn_service",
 		},
 		JWT: JWTConfig{
-			Secret:     "change-me-in-production",
 			Expiration: 24,
+			Algorithm:  "RS256",
+			KeyDir:     "config/jwt-keys",
+			Issuer:     "vpn-service",
+			Audience:   "vpn-service-api",
+			ClockSkew:  30,
 		},
 		WireGuard: WireGuardConfig{
 			ConfigDir:      "/etc/wireguard",
@@ -96,17 +741,256 @@ func Load() (*Config, error) {
 			ServerEndpoint: "vpn.example.com",
 			AllowedIPs:     "0.0.0.0/0, ::/0",
 			MTU:            1420,
+			Keepalive:      25,
+			DynamicPeerTTL: 60,
+			Failover:       true,
+			FailoverMax:    2,
 			PreUp:          "",
-			PostUp:         "iptables -A FORWARD -i %i -j ACCEPT; iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE",
+			PostUp:         "",
 			PreDown:        "",
-			PostDown:       "iptables -D FORWARD -i %i -j ACCEPT; iptables -t nat -D POSTROUTING -o eth0 -j MASQUERADE",
+			PostDown:       "",
+			DNSProfiles: map[string]DNSProfileConfig{
+				"standard": {
+					Servers:     "1.1.1.1,1.0.0.1",
+					Description: "Unfiltered DNS",
+				},
+				"ad-block": {
+					Servers:     "94.140.14.14,94.140.15.15",
+					Description: "Blocks ads and trackers",
+					Enforce:     true,
+				},
+				"family": {
+					Servers:     "1.1.1.3,1.0.0.3",
+					Description: "Blocks malware and adult content",
+					Enforce:     true,
+				},
+			},
+			NetworkMTU: map[string]int{
+				"cellular": 1280,
+				"wifi":     1420,
+				"ethernet": 1420,
+			},
+			Implementation:  "auto",
+			UserspaceBinary: "wireguard-go",
+			Obfuscation: ObfuscationConfig{
+				Transport:  "websocket",
+				Port:       443,
+				PathPrefix: "wireguard",
+			},
+			Firewall: FirewallConfig{
+				Enabled:         true,
+				Table:           "vpn_service",
+				EgressInterface: "eth0",
+				Masquerade:      true,
+				PortForwardMin:  40000,
+				PortForwardMax:  49999,
+			},
+			Drift: DriftConfig{
+				Interval:    300,
+				AutoCorrect: true,
+			},
+			Migration: MigrationConfig{
+				Enabled: true,
+				Grace:   120,
+			},
 		},
 		Monitoring: MonitoringConfig{
 			LogDir:           "logs",
 			EnableAnalytics:  true,
 			AnalyticsLogFile: "logs/usage_analytics.log",
 			MetricsPort:      9090,
+			MetricsProfile:   "ops",
 			EnablePrometheus: true,
+			TelemetryMode:    "opt-out",
+			Tracing: TracingConfig{
+				Enabled:     false,
+				Endpoint:    "localhost:4318",
+				Insecure:    true,
+				ServiceName: "vpn-backend",
+				SampleRatio: 0.1,
+			},
+			ErrorReporting: ErrorReportingConfig{
+				SampleRate:  1.0,
+				Environment: "production",
+			},
+			Anomaly: AnomalyConfig{
+				Enabled:    true,
+				Interval:   60,
+				Alpha:      0.1,
+				Threshold:  4,
+				MinSamples: 30,
+				Seasonal:   true,
+			},
+			Alerts: AlertsConfig{
+				Enabled:  true,
+				Interval: 60,
+				Rules: []AlertRule{
+					{Name: "region-load", Type: "region_load", Threshold: 80, Minutes: 10, Severity: "warning"},
+					{Name: "server-offline", Type: "server_offline", Minutes: 5, Severity: "error"},
+				},
+				Channels:    map[string]AlertChannelConfig{},
+				DedupWindow: 30,
+			},
+			AnalyticsStore: AnalyticsStoreConfig{
+				Enabled:       false,
+				Dir:           "logs/analytics",
+				BatchSize:     500,
+				FlushInterval: 10,
+				SegmentSize:   64,
+			},
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Rules: map[string]RateLimitRule{
+				"auth":    {Rate: 0.2, Burst: 10, Key: "ip"},
+				"connect": {Rate: 0.5, Burst: 10, Key: "user"},
+				"config":  {Rate: 1, Burst: 20, Key: "user"},
+			},
+		},
+		MFA: MFAConfig{
+			Issuer:    "VPN Service",
+			StepUpTTL: 300,
+		},
+		Nodes: NodesConfig{
+			HeartbeatTimeout:  90,
+			HeartbeatInterval: 30,
+			MissedHeartbeats:  3,
+			CanarySoak:        30,
+			RolloutTimeout:    60,
+			UpgradeCheck:      2,
+			Probes:            []ProbeConfig{},
+			ProbeInterval:     300,
+		},
+		Timeouts: TimeoutsConfig{
+			Connect:    12,
+			Disconnect: 10,
+			Config:     5,
+			Status:     5,
+			NodeApply:  4,
+		},
+		Cache: CacheConfig{
+			ServerLists: 30,
+			Templates:   300,
+			UserPlans:   30,
+
+			TokenRevocations: 30,
+		},
+		Geo: GeoConfig{
+			CityDatabase: "config/geo/GeoLite2-City.mmdb",
+			ASNDatabase:  "config/geo/GeoLite2-ASN.mmdb",
+		},
+		Email: EmailConfig{
+			Backend: "log",
+			From:    "VPN Service <no-reply@vpn.example.com>",
+			BaseURL: "https://vpn.example.com",
+			SMTP: SMTPConfig{
+				Port: 587,
+			},
+		},
+		Inactivity: InactivityConfig{
+			NotifyAfter: 30,
+		},
+		Shadow: ShadowConfig{
+			SampleRate: 1,
+			History:    1000,
+		},
+		Recommend: RecommendConfig{
+			LatencyWeight: 0.5,
+			MaxLatency:    300,
+			MaxAge:        1440,
+		},
+		Balancing: BalancingConfig{
+			LoadWeight:      1,
+			BandwidthWeight: 0.3,
+			ErrorWeight:     2,
+			BandwidthClasses: map[string]float64{
+				"100m": 0.1,
+				"1g":   1,
+				"10g":  10,
+			},
+			ErrorWindow: 15,
+		},
+		StatusPage: StatusPageConfig{
+			Title:        "VPN Service Status",
+			IncidentDays: 7,
+			MaxAge:       60,
+		},
+		Provisioning: ProvisioningConfig{
+			Templates: map[string]ProvisionTemplate{},
+			Timeout:   20,
+		},
+		Autoscaling: AutoscalingConfig{
+			Regions:          map[string]AutoscaleRegion{},
+			ScaleUpLoad:      80,
+			ScaleUpMinutes:   10,
+			ScaleDownLoad:    30,
+			ScaleDownMinutes: 30,
+			Cooldown:         15,
+			DrainWindow:      30,
+		},
+		Discovery: DiscoveryConfig{
+			Consul: ConsulConfig{
+				Address: "http://127.0.0.1:8500",
+				Service: "vpn-node",
+			},
+			Etcd: EtcdConfig{
+				Endpoint: "http://127.0.0.1:2379",
+				Prefix:   "/vpn-service/nodes/",
+			},
+			File: FileDiscoveryConfig{
+				Path:     "config/servers.yaml",
+				Interval: 5,
+			},
+		},
+		Kubernetes: KubernetesConfig{
+			Resync:    30,
+			TokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+			CAFile:    "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+		},
+		Storage: StorageConfig{
+			Backend:         "local",
+			Dir:             "/var/lib/vpn-service/artifacts",
+			URLTTL:          900,
+			CleanupInterval: 60,
+			Lifecycle: map[string]int{
+				"artifacts/": 24,
+				"archives/":  24,
+			},
+		},
+		Standalone: StandaloneConfig{
+			DataDir:    "/var/lib/vpn-service",
+			ServerName: "Home",
+			Heartbeat:  30,
+		},
+		Agent: AgentConfig{
+			ControlPlane: "http://127.0.0.1:8080",
+			Interval:     30,
+			CertDir:      "config/agent",
+		},
+		API: APIConfig{
+			DefaultVersion:   "v1",
+			StatusInterval:   5,
+			PublicURL:        "https://vpn.example.com",
+			ShareTTL:         60,
+			ServerListMaxAge: 30,
+		},
+		GRPC: GRPCConfig{
+			Addr:           ":50051",
+			StatusInterval: 5,
+			Profile:        "internal",
+			Agents: AgentListenerConfig{
+				Addr:            ":50052",
+				CADir:           "config/agent-ca",
+				CertificateDays: 90,
+				EnrollmentTTL:   60,
+			},
+		},
+		Certificates: CertificatesConfig{
+			DirectoryURL:       "https://acme-v02.api.letsencrypt.org/directory",
+			StorageDir:         "config/certs",
+			RenewBefore:        30,
+			PropagationTimeout: 120,
+			DNSProvider:        "route53",
 		},
 	}
 
@@ -129,6 +1013,16 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	if err := config.checkListenerProfiles(); err != nil {
+		return nil, err
+	}
+	if err := config.checkDNSProfiles(); err != nil {
+		return nil, err
+	}
+	if err := config.checkProbes(); err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
 
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNSProfileConfig represents a named set of DNS resolvers users can pick
+// at connect time
+type DNSProfileConfig struct {
+	Servers     string `json:"servers"` // comma separated, as in wireguard.dns
+	Description string `json:"description"`
+	Enforce     bool   `json:"enforce"` // redirect the DNS queries of peers on the profile to its servers on the node
+}
+
+// UnmarshalJSON reads a DNS profile, also accepting the plain server list
+// used before profiles had settings
+func (p *DNSProfileConfig) UnmarshalJSON(data []byte) error {
+	var servers string
+	if err := json.Unmarshal(data, &servers); err == nil {
+		*p = DNSProfileConfig{Servers: servers}
+		return nil
+	}
+
+	type plain DNSProfileConfig
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+// checkDNSProfiles checks every DNS profile lists valid resolver addresses
+func (c *Config) checkDNSProfiles() error {
+	for name, profile := range c.WireGuard.DNSProfiles {
+		servers := 0
+		for _, server := range strings.Split(profile.Servers, ",") {
+			if server = strings.TrimSpace(server); server == "" {
+				continue
+			}
+			if net.ParseIP(server) == nil {
+				return fmt.Errorf("wireguard.dnsProfiles.%s: invalid DNS server %q", name, server)
+			}
+			servers++
+		}
+		if servers == 0 {
+			return fmt.Errorf("wireguard.dnsProfiles.%s: no DNS servers", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ListenerProfile gets a listener profile by name
+func (c *Config) ListenerProfile(name string) ListenerProfile {
+	return c.Server.Profiles[name]
+}
+
+// checkListenerProfiles checks every listener uses a profile that exists
+func (c *Config) checkListenerProfiles() error {
+	listeners := map[string]string{
+		"server.profile":            c.Server.Profile,
+		"grpc.profile":              c.GRPC.Profile,
+		"monitoring.metricsProfile": c.Monitoring.MetricsProfile,
+	}
+	for key, name := range listeners {
+		if _, ok := c.Server.Profiles[name]; !ok {
+			return fmt.Errorf("%s: unknown listener profile %q", key, name)
+		}
+	}
+	return nil
+}
+
+// HTTPServer creates an HTTP server on addr with the timeouts and limits of
+// the profile
+func (p ListenerProfile) HTTPServer(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       time.Duration(p.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(p.ReadHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(p.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(p.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    p.MaxHeaderBytes,
+	}
+	server.SetKeepAlivesEnabled(p.KeepAlive)
+	return server
+}
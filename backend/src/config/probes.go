@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// ProbeConfig represents a reference point node agents ping to build the
+// latency matrix, e.g. a well-connected host in a country clients are in
+type ProbeConfig struct {
+	ID      string `json:"id"`
+	Country string `json:"country"` // ISO 3166-1 alpha-2 code
+	City    string `json:"city"`
+	Host    string `json:"host"` // address or hostname agents ping
+}
+
+// checkProbes checks every latency probe has a unique ID, a host and a
+// country code
+func (c *Config) checkProbes() error {
+	seen := make(map[string]bool)
+	for i, probe := range c.Nodes.Probes {
+		if probe.ID == "" {
+			return fmt.Errorf("nodes.probes[%d]: id is required", i)
+		}
+		if seen[probe.ID] {
+			return fmt.Errorf("nodes.probes[%d]: duplicate id %q", i, probe.ID)
+		}
+		seen[probe.ID] = true
+
+		if probe.Host == "" {
+			return fmt.Errorf("nodes.probes.%s: host is required", probe.ID)
+		}
+		if _, _, err := net.SplitHostPort(probe.Host); err == nil {
+			return fmt.Errorf("nodes.probes.%s: host must not have a port", probe.ID)
+		}
+		if len(probe.Country) != 2 {
+			return fmt.Errorf("nodes.probes.%s: country must be a two-letter code", probe.ID)
+		}
+	}
+	return nil
+}
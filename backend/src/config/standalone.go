@@ -0,0 +1,34 @@
+package config
+
+import "path/filepath"
+
+// ApplyStandalone adjusts the configuration for the all-in-one mode. The
+// database becomes an SQLite file and state moves under standalone.dataDir,
+// and features that need more than one host are turned off. Everything
+// else is still read from the config file.
+func (c *Config) ApplyStandalone() {
+	dataDir := c.Standalone.DataDir
+
+	c.Database.Driver = "sqlite"
+	if c.Database.Path == "" {
+		c.Database.Path = filepath.Join(dataDir, "vpn.db")
+	}
+
+	c.WireGuard.ConfigDir = filepath.Join(dataDir, "wireguard")
+	c.WireGuard.DynamicPeerDir = filepath.Join(dataDir, "wireguard", "dynamic-peers")
+	c.WireGuard.Failover = false
+	c.JWT.KeyDir = filepath.Join(dataDir, "jwt-keys")
+	c.Monitoring.LogDir = filepath.Join(dataDir, "logs")
+	c.Monitoring.AnalyticsLogFile = filepath.Join(dataDir, "logs", "usage_analytics.log")
+	c.Monitoring.AnalyticsStore.Dir = filepath.Join(dataDir, "logs", "analytics")
+	if c.Storage.Backend == "local" {
+		c.Storage.Dir = filepath.Join(dataDir, "artifacts")
+	}
+
+	// One host has no fleet to roll out to, certify or locate, and keeps
+	// rate limit buckets in memory
+	c.Certificates.Enabled = false
+	c.GRPC.Enabled = false
+	c.Geo = GeoConfig{}
+	c.RateLimit.RedisAddr = ""
+}
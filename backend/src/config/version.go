@@ -0,0 +1,4 @@
+package config
+
+// Version is the version of the backend reported by health checks
+const Version = "1.0.0"
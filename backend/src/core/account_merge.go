@@ -0,0 +1,525 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// Account merge statuses
+const (
+	MergeStatusStaged    = "staged"
+	MergeStatusCommitted = "committed"
+	MergeStatusCancelled = "cancelled"
+	MergeStatusReverted  = "reverted"
+)
+
+// Device limit conflict resolutions
+const (
+	// MergeDevicesReject refuses to commit a merge that exceeds the device limit
+	MergeDevicesReject = "reject"
+	// MergeDevicesKeepNewest moves the newest devices up to the device limit
+	// and leaves the rest on the source account
+	MergeDevicesKeepNewest = "keep_newest"
+)
+
+// Plan resolutions
+const (
+	// MergePlanBest keeps whichever plan allows more devices
+	MergePlanBest = "best"
+	// MergePlanTarget keeps the target account's plan
+	MergePlanTarget = "target"
+	// MergePlanSource moves the source account's plan to the target
+	MergePlanSource = "source"
+)
+
+// AccountMerge represents moving one account's devices, config history and
+// subscription to another account. Merges are staged first so an admin can
+// review the outcome, and committed merges can be reverted.
+type AccountMerge struct {
+	ID           string         `json:"id" db:"id"`
+	SourceUserID string         `json:"sourceUserId" db:"source_user_id"`
+	TargetUserID string         `json:"targetUserId" db:"target_user_id"`
+	Status       string         `json:"status" db:"status"`
+	Devices      string         `json:"devices" db:"devices"`
+	Plan         string         `json:"plan" db:"plan"`
+	SourcePlan   string         `json:"sourcePlan" db:"source_plan"`
+	TargetPlan   string         `json:"targetPlan" db:"target_plan"`
+	MergedPlan   string         `json:"mergedPlan" db:"merged_plan"`
+	Peers        pq.StringArray `json:"peers" db:"peers"`          // moved to the target
+	KeptPeers    pq.StringArray `json:"keptPeers" db:"kept_peers"` // left on the source to stay within the device limit
+	Conflicts    pq.StringArray `json:"conflicts" db:"conflicts"`  // must be empty to commit
+	CreatedBy    string         `json:"createdBy,omitempty" db:"created_by"`
+	StagedAt     time.Time      `json:"stagedAt" db:"staged_at"`
+	CommittedAt  *time.Time     `json:"committedAt,omitempty" db:"committed_at"`
+	RevertedAt   *time.Time     `json:"revertedAt,omitempty" db:"reverted_at"`
+}
+
+// AccountMergeManager merges user accounts. Committing moves the source
+// account's static peers and their config render history to the target in
+// one step: if any part fails, everything moved so far is moved back. The
+// source account drops to the default plan and the target gets the merged
+// plan. Dynamic peers are not moved; their sessions expire on their own.
+type AccountMergeManager struct {
+	config *config.Config
+	vpn    *VPNManager
+	merges map[string]*AccountMerge
+	mutex  sync.RWMutex
+}
+
+// NewAccountMergeManager creates a new account merge manager
+func NewAccountMergeManager(cfg *config.Config, vpn *VPNManager) *AccountMergeManager {
+	mm := &AccountMergeManager{
+		config: cfg,
+		vpn:    vpn,
+		merges: make(map[string]*AccountMerge),
+		mutex:  sync.RWMutex{},
+	}
+
+	if err := mm.load(); err != nil {
+		utils.LogError("Failed to load account merges: %v", err)
+	}
+
+	return mm
+}
+
+// StageMerge works out what merging the source account into the target
+// would do without changing either account. Device limit conflicts that the
+// chosen resolution cannot settle are listed on the merge and block commit.
+func (mm *AccountMergeManager) StageMerge(ctx context.Context, sourceUserID, targetUserID, devices, plan, actor string) (*AccountMerge, error) {
+	if sourceUserID == targetUserID {
+		return nil, fmt.Errorf("cannot merge an account into itself")
+	}
+	if devices == "" {
+		devices = MergeDevicesReject
+	}
+	if devices != MergeDevicesReject && devices != MergeDevicesKeepNewest {
+		return nil, fmt.Errorf("unknown device resolution: %s", devices)
+	}
+	if plan == "" {
+		plan = MergePlanBest
+	}
+	if plan != MergePlanBest && plan != MergePlanTarget && plan != MergePlanSource {
+		return nil, fmt.Errorf("unknown plan resolution: %s", plan)
+	}
+
+	// Check both accounts exist
+	if mm.vpn.userManager != nil {
+		for _, userID := range []string{sourceUserID, targetUserID} {
+			if _, err := mm.vpn.userManager.GetUser(userID); err != nil {
+				return nil, fmt.Errorf("user not found: %s", userID)
+			}
+		}
+	}
+
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	for _, merge := range mm.merges {
+		if merge.Status == MergeStatusStaged && (merge.SourceUserID == sourceUserID || merge.TargetUserID == sourceUserID) {
+			return nil, fmt.Errorf("merge %s is already staged for user %s", merge.ID, sourceUserID)
+		}
+	}
+
+	// Pick the plan the target ends up on
+	sourcePlan := mm.vpn.entitlements.GetUserPlan(ctx, sourceUserID)
+	targetPlan := mm.vpn.entitlements.GetUserPlan(ctx, targetUserID)
+	mergedPlan := targetPlan
+	switch plan {
+	case MergePlanSource:
+		mergedPlan = sourcePlan
+	case MergePlanBest:
+		if allowsMoreDevices(sourcePlan.Entitlements.MaxDevices, targetPlan.Entitlements.MaxDevices) {
+			mergedPlan = sourcePlan
+		}
+	}
+
+	// Get the devices of both accounts
+	sourcePeers, err := mm.staticPeers(sourceUserID)
+	if err != nil {
+		return nil, err
+	}
+	targetPeers, err := mm.vpn.peerManager.GetPeers(targetUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peers: %v", err)
+	}
+
+	// Newest devices first, so the oldest ones are left behind when needed
+	sort.Slice(sourcePeers, func(i, j int) bool {
+		return sourcePeers[i].CreatedAt.After(sourcePeers[j].CreatedAt)
+	})
+
+	merge := &AccountMerge{
+		ID:           utils.GenerateUUID(),
+		SourceUserID: sourceUserID,
+		TargetUserID: targetUserID,
+		Status:       MergeStatusStaged,
+		Devices:      devices,
+		Plan:         plan,
+		SourcePlan:   sourcePlan.ID,
+		TargetPlan:   targetPlan.ID,
+		MergedPlan:   mergedPlan.ID,
+		Peers:        pq.StringArray{},
+		KeptPeers:    pq.StringArray{},
+		Conflicts:    pq.StringArray{},
+		CreatedBy:    actor,
+		StagedAt:     time.Now(),
+	}
+
+	// Resolve the device limit of the merged plan
+	available := len(sourcePeers)
+	maxDevices := mergedPlan.Entitlements.MaxDevices
+	if maxDevices > 0 && len(targetPeers)+len(sourcePeers) > maxDevices {
+		if devices == MergeDevicesReject {
+			merge.Conflicts = append(merge.Conflicts, fmt.Sprintf("merged account would have %d devices but plan %s allows at most %d", len(targetPeers)+len(sourcePeers), mergedPlan.ID, maxDevices))
+		} else {
+			available = maxDevices - len(targetPeers)
+			if available < 0 {
+				available = 0
+			}
+		}
+	}
+	for i, peer := range sourcePeers {
+		if i < available {
+			merge.Peers = append(merge.Peers, peer.ID)
+		} else {
+			merge.KeptPeers = append(merge.KeptPeers, peer.ID)
+		}
+	}
+
+	if err := mm.save(db.DB, merge); err != nil {
+		return nil, err
+	}
+	mm.merges[merge.ID] = merge
+
+	utils.LogInfo("Staged account merge %s of user %s into user %s", merge.ID, utils.RedactUserID(sourceUserID), utils.RedactUserID(targetUserID))
+
+	// Log analytics
+	utils.LogAnalytics(actor, "account_merge_stage", fmt.Sprintf("merge=%s peers=%d kept=%d conflicts=%d", merge.ID, len(merge.Peers), len(merge.KeptPeers), len(merge.Conflicts)))
+
+	return mm.snapshot(merge), nil
+}
+
+// CommitMerge moves the staged devices, config history and plan to the
+// target account
+func (mm *AccountMergeManager) CommitMerge(ctx context.Context, id, actor string) (*AccountMerge, error) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	merge, ok := mm.merges[id]
+	if !ok {
+		return nil, fmt.Errorf("merge not found: %s", id)
+	}
+	if merge.Status != MergeStatusStaged {
+		return nil, fmt.Errorf("merge is not staged: %s", id)
+	}
+	if len(merge.Conflicts) > 0 {
+		return nil, fmt.Errorf("merge has unresolved conflicts: %s", strings.Join(merge.Conflicts, "; "))
+	}
+
+	// Keep connects and disconnects out while devices move
+	mm.vpn.mutex.Lock()
+	defer mm.vpn.mutex.Unlock()
+
+	// The staged devices must still belong to the source account
+	sourcePeers, err := mm.staticPeers(merge.SourceUserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(sourcePeers) != len(merge.Peers)+len(merge.KeptPeers) {
+		return nil, fmt.Errorf("devices of user %s changed since the merge was staged, stage it again", merge.SourceUserID)
+	}
+	for _, peerID := range merge.Peers {
+		if _, err := mm.vpn.peerManager.GetPeer(merge.SourceUserID, peerID); err != nil {
+			return nil, fmt.Errorf("devices of user %s changed since the merge was staged, stage it again", merge.SourceUserID)
+		}
+	}
+
+	now := time.Now()
+	committed := *merge
+	committed.Status = MergeStatusCommitted
+	committed.CommittedAt = &now
+
+	plans := map[string]string{
+		merge.TargetUserID: merge.MergedPlan,
+		merge.SourceUserID: models.DefaultPlanID,
+	}
+	if err := mm.transfer(ctx, &committed, merge.Peers, merge.SourceUserID, merge.TargetUserID, plans); err != nil {
+		return nil, err
+	}
+	mm.merges[id] = &committed
+
+	utils.LogInfo("Committed account merge %s, moved %d peers to user %s", id, len(merge.Peers), utils.RedactUserID(merge.TargetUserID))
+
+	// Log analytics
+	utils.LogAnalytics(actor, "account_merge_commit", fmt.Sprintf("merge=%s", id))
+
+	return mm.snapshot(&committed), nil
+}
+
+// RevertMerge undoes a committed merge, moving the devices back to the
+// source account and restoring both accounts' plans. Devices removed since
+// the merge stay removed.
+func (mm *AccountMergeManager) RevertMerge(ctx context.Context, id, actor string) (*AccountMerge, error) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	merge, ok := mm.merges[id]
+	if !ok {
+		return nil, fmt.Errorf("merge not found: %s", id)
+	}
+	if merge.Status != MergeStatusCommitted {
+		return nil, fmt.Errorf("merge is not committed: %s", id)
+	}
+
+	mm.vpn.mutex.Lock()
+	defer mm.vpn.mutex.Unlock()
+
+	// Only move back devices the target still has
+	peers := make([]string, 0, len(merge.Peers))
+	for _, peerID := range merge.Peers {
+		if _, err := mm.vpn.peerManager.GetPeer(merge.TargetUserID, peerID); err != nil {
+			utils.LogWarning("Peer %s of merge %s no longer exists, not moving it back", peerID, id)
+			continue
+		}
+		peers = append(peers, peerID)
+	}
+
+	now := time.Now()
+	reverted := *merge
+	reverted.Status = MergeStatusReverted
+	reverted.RevertedAt = &now
+
+	plans := map[string]string{
+		merge.SourceUserID: merge.SourcePlan,
+		merge.TargetUserID: merge.TargetPlan,
+	}
+	if err := mm.transfer(ctx, &reverted, peers, merge.TargetUserID, merge.SourceUserID, plans); err != nil {
+		return nil, err
+	}
+	mm.merges[id] = &reverted
+
+	utils.LogInfo("Reverted account merge %s, moved %d peers back to user %s", id, len(peers), utils.RedactUserID(merge.SourceUserID))
+
+	// Log analytics
+	utils.LogAnalytics(actor, "account_merge_revert", fmt.Sprintf("merge=%s", id))
+
+	return mm.snapshot(&reverted), nil
+}
+
+// CancelMerge discards a staged merge
+func (mm *AccountMergeManager) CancelMerge(id, actor string) (*AccountMerge, error) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	merge, ok := mm.merges[id]
+	if !ok {
+		return nil, fmt.Errorf("merge not found: %s", id)
+	}
+	if merge.Status != MergeStatusStaged {
+		return nil, fmt.Errorf("merge is not staged: %s", id)
+	}
+
+	cancelled := *merge
+	cancelled.Status = MergeStatusCancelled
+	if err := mm.save(db.DB, &cancelled); err != nil {
+		return nil, err
+	}
+	mm.merges[id] = &cancelled
+
+	// Log analytics
+	utils.LogAnalytics(actor, "account_merge_cancel", fmt.Sprintf("merge=%s", id))
+
+	return mm.snapshot(&cancelled), nil
+}
+
+// GetMerge gets an account merge
+func (mm *AccountMergeManager) GetMerge(id string) (*AccountMerge, error) {
+	mm.mutex.RLock()
+	defer mm.mutex.RUnlock()
+
+	merge, ok := mm.merges[id]
+	if !ok {
+		return nil, fmt.Errorf("merge not found: %s", id)
+	}
+
+	return mm.snapshot(merge), nil
+}
+
+// ListMerges gets all account merges, most recent first
+func (mm *AccountMergeManager) ListMerges() []*AccountMerge {
+	mm.mutex.RLock()
+	defer mm.mutex.RUnlock()
+
+	merges := make([]*AccountMerge, 0, len(mm.merges))
+	for _, merge := range mm.merges {
+		merges = append(merges, mm.snapshot(merge))
+	}
+
+	sort.Slice(merges, func(i, j int) bool {
+		return merges[i].StagedAt.After(merges[j].StagedAt)
+	})
+
+	return merges
+}
+
+// transfer moves peers and their config history between users, assigns
+// plans and saves the merge. Database changes are made in one transaction
+// and every file already moved is moved back if a later step fails.
+func (mm *AccountMergeManager) transfer(ctx context.Context, merge *AccountMerge, peerIDs []string, fromUserID, toUserID string, plans map[string]string) (err error) {
+	var tx *sqlx.Tx
+	if db.DB != nil {
+		tx, err = db.DB.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %v", err)
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			}
+		}()
+
+		now := time.Now()
+		for _, peerID := range peerIDs {
+			if _, err = tx.Exec(`UPDATE vpn_peers SET user_id = $1, updated_at = $2 WHERE id = $3 AND user_id = $4`, toUserID, now, peerID, fromUserID); err != nil {
+				return fmt.Errorf("failed to update peer owner: %v", err)
+			}
+		}
+		for userID, planID := range plans {
+			if _, err = tx.Exec(`UPDATE users SET plan = $1, updated_at = $2 WHERE id = $3`, planID, now, userID); err != nil {
+				return fmt.Errorf("failed to update user plan: %v", err)
+			}
+		}
+		if err = mm.save(tx, merge); err != nil {
+			return err
+		}
+	}
+
+	// Move peer files
+	moved := make([]string, 0, len(peerIDs))
+	undo := func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			if _, err := mm.vpn.peerManager.MovePeer(toUserID, moved[i], fromUserID); err != nil {
+				utils.LogError("Failed to move peer %s back to user %s: %v", moved[i], utils.RedactUserID(fromUserID), err)
+			}
+		}
+	}
+	for _, peerID := range peerIDs {
+		if _, err = mm.vpn.peerManager.MovePeer(fromUserID, peerID, toUserID); err != nil {
+			undo()
+			return fmt.Errorf("failed to move peer %s: %v", peerID, err)
+		}
+		moved = append(moved, peerID)
+	}
+
+	// Move config history
+	if err = mm.vpn.configAudit.Reassign(peerIDs, toUserID); err != nil {
+		undo()
+		return fmt.Errorf("failed to move config history: %v", err)
+	}
+
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			if auditErr := mm.vpn.configAudit.Reassign(peerIDs, fromUserID); auditErr != nil {
+				utils.LogError("Failed to move config history back to user %s: %v", utils.RedactUserID(fromUserID), auditErr)
+			}
+			undo()
+			return fmt.Errorf("failed to commit transaction: %v", err)
+		}
+	}
+
+	// Apply plan assignments
+	mm.vpn.entitlements.mutex.Lock()
+	for userID, planID := range plans {
+		mm.vpn.entitlements.assignUserPlan(userID, planID)
+	}
+	mm.vpn.entitlements.mutex.Unlock()
+
+	return nil
+}
+
+// staticPeers gets the static peers of a user
+func (mm *AccountMergeManager) staticPeers(userID string) ([]*wireguard.PeerConfig, error) {
+	peers, err := mm.vpn.peerManager.GetPeers(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peers: %v", err)
+	}
+
+	static := make([]*wireguard.PeerConfig, 0, len(peers))
+	for _, peer := range peers {
+		if !peer.Dynamic {
+			static = append(static, peer)
+		}
+	}
+
+	return static, nil
+}
+
+// snapshot copies a merge so callers cannot modify it
+func (mm *AccountMergeManager) snapshot(merge *AccountMerge) *AccountMerge {
+	copied := *merge
+	return &copied
+}
+
+// save persists a merge
+func (mm *AccountMergeManager) save(exec sqlx.Execer, merge *AccountMerge) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	_, err := exec.Exec(
+		`INSERT INTO account_merges (id, source_user_id, target_user_id, status, devices, plan, source_plan, target_plan, merged_plan, peers, kept_peers, conflicts, created_by, staged_at, committed_at, reverted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (id) DO UPDATE SET status = $4, committed_at = $15, reverted_at = $16`,
+		merge.ID, merge.SourceUserID, merge.TargetUserID, merge.Status, merge.Devices, merge.Plan,
+		merge.SourcePlan, merge.TargetPlan, merge.MergedPlan, merge.Peers, merge.KeptPeers, merge.Conflicts,
+		merge.CreatedBy, merge.StagedAt, merge.CommittedAt, merge.RevertedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save account merge: %v", err)
+	}
+
+	return nil
+}
+
+// load reads account merges from the database
+func (mm *AccountMergeManager) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	merges := []*AccountMerge{}
+	err := db.DB.Select(&merges, `SELECT id, source_user_id, target_user_id, status, devices, plan, source_plan, target_plan, merged_plan, peers, kept_peers, conflicts, created_by, staged_at, committed_at, reverted_at FROM account_merges`)
+	if err != nil {
+		return fmt.Errorf("failed to query account merges: %v", err)
+	}
+
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	for _, merge := range merges {
+		mm.merges[merge.ID] = merge
+	}
+
+	return nil
+}
+
+// allowsMoreDevices checks whether device limit a allows more devices than
+// b, where 0 means unlimited
+func allowsMoreDevices(a, b int) bool {
+	if b == 0 {
+		return false
+	}
+	return a == 0 || a > b
+}
@@ -0,0 +1,281 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// ACLRule allows or denies traffic from peers to other peers or to a
+// network. Rules are checked by ascending priority and the first match
+// wins. Selectors are *, peer:<id>, user:<id> or a network.
+type ACLRule struct {
+	ID          string    `json:"id" db:"id"`
+	Priority    int       `json:"priority" db:"priority"`
+	Source      string    `json:"source" db:"source"`
+	Destination string    `json:"destination" db:"destination"`
+	Protocol    string    `json:"protocol,omitempty" db:"protocol"` // udp or tcp, any if empty
+	Port        int       `json:"port,omitempty" db:"port"`
+	Action      string    `json:"action" db:"action"` // allow or deny
+	Note        string    `json:"note,omitempty" db:"note"`
+	CreatedBy   string    `json:"createdBy" db:"created_by"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// Validate checks the fields of an ACL rule
+func (rule *ACLRule) Validate() error {
+	var v utils.Validator
+	v.Required("source", rule.Source)
+	if err := wireguard.ValidateACLSelector(rule.Source); rule.Source != "" && err != nil {
+		v.Check(false, "source", err.Error())
+	}
+	v.Required("destination", rule.Destination)
+	if err := wireguard.ValidateACLSelector(rule.Destination); rule.Destination != "" && err != nil {
+		v.Check(false, "destination", err.Error())
+	}
+	v.OneOf("protocol", rule.Protocol, "", "udp", "tcp")
+	v.Check(rule.Port >= 0 && rule.Port <= 65535, "port", "must be between 0 and 65535")
+	v.Check(rule.Port == 0 || rule.Protocol != "", "port", "needs a protocol")
+	v.OneOf("action", rule.Action, wireguard.ACLAllow, wireguard.ACLDeny)
+	v.MaxLength("note", rule.Note, 255)
+	return v.Err()
+}
+
+// ACLManager keeps the ACL rules of traffic between peers
+type ACLManager struct {
+	config *config.Config
+	rules  map[string]*ACLRule // by ID
+	mutex  sync.RWMutex
+}
+
+// NewACLManager creates a new ACL manager
+func NewACLManager(cfg *config.Config) *ACLManager {
+	return &ACLManager{
+		config: cfg,
+		rules:  make(map[string]*ACLRule),
+		mutex:  sync.RWMutex{},
+	}
+}
+
+// List gets the ACL rules in the order they are checked
+func (am *ACLManager) List(ctx context.Context) ([]*ACLRule, error) {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	rules := make([]*ACLRule, 0)
+
+	if db.DB != nil {
+		err := db.DB.SelectContext(ctx, &rules,
+			`SELECT id, priority, source, destination, protocol, port, action, note, created_by, created_at, updated_at FROM acl_rules`,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ACL rules: %v", err)
+		}
+	} else {
+		for _, rule := range am.rules {
+			rules = append(rules, rule)
+		}
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority < rules[j].Priority
+		}
+		return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+	})
+
+	return rules, nil
+}
+
+// Get gets an ACL rule
+func (am *ACLManager) Get(ctx context.Context, id string) (*ACLRule, error) {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	if db.DB != nil {
+		var rule ACLRule
+		err := db.DB.GetContext(ctx, &rule,
+			`SELECT id, priority, source, destination, protocol, port, action, note, created_by, created_at, updated_at FROM acl_rules WHERE id = $1`,
+			id,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("ACL rule not found: %s", id)
+		}
+		return &rule, nil
+	}
+
+	rule, ok := am.rules[id]
+	if !ok {
+		return nil, fmt.Errorf("ACL rule not found: %s", id)
+	}
+	return rule, nil
+}
+
+// save inserts or updates an ACL rule
+func (am *ACLManager) save(ctx context.Context, rule *ACLRule) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	if db.DB != nil {
+		_, err := db.DB.ExecContext(ctx,
+			`INSERT INTO acl_rules (id, priority, source, destination, protocol, port, action, note, created_by, created_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			 ON CONFLICT (id) DO UPDATE SET priority = $2, source = $3, destination = $4, protocol = $5, port = $6, action = $7, note = $8, updated_at = $11`,
+			rule.ID, rule.Priority, rule.Source, rule.Destination, rule.Protocol, rule.Port, rule.Action, rule.Note, rule.CreatedBy, rule.CreatedAt, rule.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save ACL rule: %v", err)
+		}
+		return nil
+	}
+
+	am.rules[rule.ID] = rule
+	return nil
+}
+
+// remove deletes an ACL rule
+func (am *ACLManager) remove(ctx context.Context, id string) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	if db.DB != nil {
+		result, err := db.DB.ExecContext(ctx, `DELETE FROM acl_rules WHERE id = $1`, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete ACL rule: %v", err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return fmt.Errorf("ACL rule not found: %s", id)
+		}
+		return nil
+	}
+
+	if _, ok := am.rules[id]; !ok {
+		return fmt.Errorf("ACL rule not found: %s", id)
+	}
+	delete(am.rules, id)
+	return nil
+}
+
+// policies gets the ACL rules for the firewall
+func (am *ACLManager) policies() ([]wireguard.ACLPolicy, error) {
+	rules, err := am.List(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make([]wireguard.ACLPolicy, len(rules))
+	for i, rule := range rules {
+		policies[i] = wireguard.ACLPolicy{
+			ID:          rule.ID,
+			Source:      rule.Source,
+			Destination: rule.Destination,
+			Protocol:    rule.Protocol,
+			Port:        rule.Port,
+			Action:      rule.Action,
+		}
+	}
+	return policies, nil
+}
+
+// ACL gets the ACL rules of traffic between peers
+func (vm *VPNManager) ACL() *ACLManager {
+	return vm.acl
+}
+
+// CreateACLRule adds an ACL rule and applies it to the firewall
+func (vm *VPNManager) CreateACLRule(ctx context.Context, actor string, rule ACLRule) (*ACLRule, error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	rule.Note = strings.TrimSpace(rule.Note)
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rule.ID = utils.GenerateUUID()
+	rule.CreatedBy = actor
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	if err := vm.acl.save(ctx, &rule); err != nil {
+		return nil, err
+	}
+	vm.applyACL(ctx)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "acl_rule_created", fmt.Sprintf("rule=%s source=%s destination=%s action=%s", rule.ID, rule.Source, rule.Destination, rule.Action))
+
+	return &rule, nil
+}
+
+// UpdateACLRule replaces the match and action of an ACL rule and applies
+// it to the firewall
+func (vm *VPNManager) UpdateACLRule(ctx context.Context, actor, id string, update ACLRule) (*ACLRule, error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	existing, err := vm.acl.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := *existing
+	rule.Priority = update.Priority
+	rule.Source = update.Source
+	rule.Destination = update.Destination
+	rule.Protocol = update.Protocol
+	rule.Port = update.Port
+	rule.Action = update.Action
+	rule.Note = strings.TrimSpace(update.Note)
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	rule.UpdatedAt = time.Now()
+	if err := vm.acl.save(ctx, &rule); err != nil {
+		return nil, err
+	}
+	vm.applyACL(ctx)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "acl_rule_updated", fmt.Sprintf("rule=%s source=%s destination=%s action=%s", rule.ID, rule.Source, rule.Destination, rule.Action))
+
+	return &rule, nil
+}
+
+// DeleteACLRule removes an ACL rule from the firewall
+func (vm *VPNManager) DeleteACLRule(ctx context.Context, actor, id string) error {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	if err := vm.acl.remove(ctx, id); err != nil {
+		return err
+	}
+	vm.applyACL(ctx)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "acl_rule_deleted", fmt.Sprintf("rule=%s", id))
+
+	return nil
+}
+
+// applyACL applies the ACL rules to the firewall. A failure is only logged,
+// as the rules are applied again with the next peer change.
+func (vm *VPNManager) applyACL(ctx context.Context) {
+	if !vm.config.WireGuard.Firewall.Enabled {
+		utils.LogWarningContext(ctx, "ACL rules are only enforced with wireguard.firewall enabled")
+		return
+	}
+	if err := vm.peerManager.ApplyACL(ctx); err != nil {
+		utils.LogErrorContext(ctx, "Failed to apply ACL rules: %v", err)
+	}
+}
@@ -0,0 +1,472 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Files of the agent CA in its directory
+const (
+	agentCACertificateFile = "ca.crt"
+	agentCAPrivateKeyFile  = "ca.key"
+)
+
+// agentCAValidity is how long a generated agent CA is valid
+const agentCAValidity = 10 * 365 * 24 * time.Hour
+
+// NodeEnrollment represents the enrollment of a node agent with the agent
+// CA: the one-time token it enrolls with and the node certificate it was
+// issued. Only the latest certificate of a node is accepted.
+type NodeEnrollment struct {
+	ServerID             string     `json:"serverId" db:"server_id"`
+	TokenHash            string     `json:"-" db:"token_hash"`
+	TokenExpiresAt       *time.Time `json:"tokenExpiresAt,omitempty" db:"token_expires_at"`
+	CertificateSerial    string     `json:"certificateSerial,omitempty" db:"certificate_serial"`
+	CertificateExpiresAt *time.Time `json:"certificateExpiresAt,omitempty" db:"certificate_expires_at"`
+	EnrolledAt           *time.Time `json:"enrolledAt,omitempty" db:"enrolled_at"`
+	CreatedBy            string     `json:"createdBy,omitempty" db:"created_by"`
+	CreatedAt            time.Time  `json:"createdAt" db:"created_at"`
+}
+
+// IssuedNodeCertificate represents a node certificate issued by the agent CA
+type IssuedNodeCertificate struct {
+	Serial   string    `json:"serial"` // hexadecimal
+	NotAfter time.Time `json:"notAfter"`
+	CertPEM  string    `json:"certPem"`
+	CAPEM    string    `json:"caPem"`
+}
+
+// AgentCA issues the client certificates node agents authenticate with on
+// the agent listener, and the certificate of the listener itself. Agents
+// enroll with a one-time token an admin creates for their server.
+type AgentCA struct {
+	config      *config.Config
+	cert        *x509.Certificate
+	certPEM     []byte
+	key         *ecdsa.PrivateKey
+	enrollments map[string]*NodeEnrollment
+	mutex       sync.RWMutex
+}
+
+// NewAgentCA loads the agent CA from its directory, generating it on first
+// start
+func NewAgentCA(cfg *config.Config) (*AgentCA, error) {
+	ca := &AgentCA{
+		config:      cfg,
+		enrollments: make(map[string]*NodeEnrollment),
+		mutex:       sync.RWMutex{},
+	}
+
+	if err := ca.loadOrCreate(); err != nil {
+		return nil, err
+	}
+	if err := ca.load(); err != nil {
+		utils.LogError("Failed to load node enrollments: %v", err)
+	}
+
+	return ca, nil
+}
+
+// CertificatePEM gets the certificate of the CA, which agents trust the
+// listener by
+func (ca *AgentCA) CertificatePEM() string {
+	return string(ca.certPEM)
+}
+
+// CertPool gets a pool holding the certificate of the CA
+func (ca *AgentCA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// ListenerCertificate issues the certificate of the agent listener for the
+// configured server names
+func (ca *AgentCA) ListenerCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate listener key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "vpn-service agent listener"},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(time.Duration(ca.certificateDays()) * 24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range ca.config.GRPC.Agents.ServerNames {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := ca.sign(template, &key.PublicKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// CreateEnrollment creates a one-time token the agent of a server enrolls
+// with, replacing an unused one
+func (ca *AgentCA) CreateEnrollment(serverID, actor string) (string, *NodeEnrollment, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, fmt.Errorf("failed to generate enrollment token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	enrollment := &NodeEnrollment{ServerID: serverID, CreatedAt: time.Now()}
+	if current, ok := ca.enrollments[serverID]; ok {
+		copied := *current
+		enrollment = &copied
+	}
+	expiresAt := time.Now().Add(time.Duration(ca.config.GRPC.Agents.EnrollmentTTL) * time.Minute)
+	enrollment.TokenHash = hashToken(token)
+	enrollment.TokenExpiresAt = &expiresAt
+	enrollment.CreatedBy = actor
+
+	if err := ca.save(enrollment); err != nil {
+		return "", nil, err
+	}
+	ca.enrollments[serverID] = enrollment
+
+	utils.LogInfo("Created enrollment token for node %s, valid until %s", serverID, expiresAt.Format(time.RFC3339))
+
+	// Log analytics
+	utils.LogAnalytics(actor, "node_enrollment_create", fmt.Sprintf("server=%s", serverID))
+
+	copied := *enrollment
+	return token, &copied, nil
+}
+
+// Enroll issues a node certificate to the agent of a server for a
+// certificate signing request, in exchange for its enrollment token
+func (ca *AgentCA) Enroll(serverID, token, csrPEM string) (*IssuedNodeCertificate, error) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	enrollment, ok := ca.enrollments[serverID]
+	if !ok || enrollment.TokenHash == "" || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(enrollment.TokenHash)) != 1 {
+		return nil, fmt.Errorf("invalid enrollment token")
+	}
+	if enrollment.TokenExpiresAt == nil || time.Now().After(*enrollment.TokenExpiresAt) {
+		return nil, fmt.Errorf("enrollment token has expired")
+	}
+
+	issued, err := ca.issue(enrollment, csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogInfo("Node %s enrolled with certificate %s", serverID, issued.Serial)
+
+	// Log analytics
+	utils.LogAnalytics("system", "node_enrolled", fmt.Sprintf("server=%s serial=%s", serverID, issued.Serial))
+
+	return issued, nil
+}
+
+// Renew issues a new node certificate to an enrolled agent
+func (ca *AgentCA) Renew(serverID, csrPEM string) (*IssuedNodeCertificate, error) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	enrollment, ok := ca.enrollments[serverID]
+	if !ok || enrollment.CertificateSerial == "" {
+		return nil, fmt.Errorf("node is not enrolled: %s", serverID)
+	}
+
+	issued, err := ca.issue(enrollment, csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogInfo("Renewed certificate of node %s: %s", serverID, issued.Serial)
+	return issued, nil
+}
+
+// Revoke removes the enrollment of a server, so its agent's certificate
+// and any unused token are no longer accepted
+func (ca *AgentCA) Revoke(serverID, actor string) error {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	if _, ok := ca.enrollments[serverID]; !ok {
+		return fmt.Errorf("node is not enrolled: %s", serverID)
+	}
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`DELETE FROM node_enrollments WHERE server_id = $1`, serverID); err != nil {
+			return fmt.Errorf("failed to delete node enrollment: %v", err)
+		}
+	}
+	delete(ca.enrollments, serverID)
+
+	utils.LogInfo("Revoked enrollment of node %s", serverID)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "node_enrollment_revoke", fmt.Sprintf("server=%s", serverID))
+
+	return nil
+}
+
+// GetEnrollment gets the enrollment of a server
+func (ca *AgentCA) GetEnrollment(serverID string) (*NodeEnrollment, error) {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+
+	enrollment, ok := ca.enrollments[serverID]
+	if !ok {
+		return nil, fmt.Errorf("node is not enrolled: %s", serverID)
+	}
+	copied := *enrollment
+	return &copied, nil
+}
+
+// Verify checks a verified client certificate is the current certificate
+// of its node and returns the node's server ID, its common name
+func (ca *AgentCA) Verify(cert *x509.Certificate) (string, error) {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
+
+	serverID := cert.Subject.CommonName
+	enrollment, ok := ca.enrollments[serverID]
+	if !ok || enrollment.CertificateSerial != cert.SerialNumber.Text(16) {
+		return "", fmt.Errorf("certificate %s of node %s is not current", cert.SerialNumber.Text(16), serverID)
+	}
+	return serverID, nil
+}
+
+// issue signs a node certificate for the key of a certificate signing
+// request, naming the node by server ID whatever the request asks for,
+// and makes it the node's only accepted certificate
+func (ca *AgentCA) issue(enrollment *NodeEnrollment, csrPEM string) (*IssuedNodeCertificate, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("invalid certificate signing request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate signing request: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate signing request signature: %v", err)
+	}
+
+	notAfter := time.Now().Add(time.Duration(ca.certificateDays()) * 24 * time.Hour)
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: enrollment.ServerID},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := ca.sign(template, csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node certificate: %v", err)
+	}
+
+	updated := *enrollment
+	now := time.Now()
+	updated.TokenHash = ""
+	updated.TokenExpiresAt = nil
+	updated.CertificateSerial = cert.SerialNumber.Text(16)
+	updated.CertificateExpiresAt = &notAfter
+	updated.EnrolledAt = &now
+	if err := ca.save(&updated); err != nil {
+		return nil, err
+	}
+	*enrollment = updated
+
+	return &IssuedNodeCertificate{
+		Serial:   updated.CertificateSerial,
+		NotAfter: notAfter,
+		CertPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		CAPEM:    string(ca.certPEM),
+	}, nil
+}
+
+// sign signs a certificate with a random serial number
+func (ca *AgentCA) sign(template *x509.Certificate, publicKey interface{}) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	template.SerialNumber = serial
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, publicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %v", err)
+	}
+	return der, nil
+}
+
+// certificateDays gets the validity of issued certificates in days
+func (ca *AgentCA) certificateDays() int {
+	if ca.config.GRPC.Agents.CertificateDays <= 0 {
+		return 90
+	}
+	return ca.config.GRPC.Agents.CertificateDays
+}
+
+// loadOrCreate reads the CA certificate and key, generating and saving them
+// if they do not exist
+func (ca *AgentCA) loadOrCreate() error {
+	dir := ca.config.GRPC.Agents.CADir
+	certPEM, certErr := os.ReadFile(filepath.Join(dir, agentCACertificateFile))
+	keyPEM, keyErr := os.ReadFile(filepath.Join(dir, agentCAPrivateKeyFile))
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		return ca.create()
+	}
+	if certErr != nil {
+		return fmt.Errorf("failed to read agent CA certificate: %v", certErr)
+	}
+	if keyErr != nil {
+		return fmt.Errorf("failed to read agent CA key: %v", keyErr)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("invalid agent CA: %v", err)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("agent CA key is not an ECDSA key")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse agent CA certificate: %v", err)
+	}
+
+	ca.cert = cert
+	ca.certPEM = certPEM
+	ca.key = key
+	return nil
+}
+
+// create generates a self-signed CA and saves it
+func (ca *AgentCA) create() error {
+	dir := ca.config.GRPC.Agents.CADir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create agent CA directory: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate agent CA key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "vpn-service agent CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(agentCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create agent CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("failed to parse agent CA certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode agent CA key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, agentCAPrivateKeyFile), keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to save agent CA key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, agentCACertificateFile), certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to save agent CA certificate: %v", err)
+	}
+
+	ca.cert = cert
+	ca.certPEM = certPEM
+	ca.key = key
+
+	utils.LogInfo("Generated agent CA in %s", dir)
+	return nil
+}
+
+// save saves an enrollment to the database
+func (ca *AgentCA) save(enrollment *NodeEnrollment) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	_, err := db.DB.Exec(
+		`INSERT INTO node_enrollments (server_id, token_hash, token_expires_at, certificate_serial, certificate_expires_at, enrolled_at, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (server_id) DO UPDATE SET token_hash = $2, token_expires_at = $3, certificate_serial = $4, certificate_expires_at = $5, enrolled_at = $6, created_by = $7`,
+		enrollment.ServerID, enrollment.TokenHash, enrollment.TokenExpiresAt, enrollment.CertificateSerial,
+		enrollment.CertificateExpiresAt, enrollment.EnrolledAt, enrollment.CreatedBy, enrollment.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save node enrollment: %v", err)
+	}
+
+	return nil
+}
+
+// load reads the enrollments from the database
+func (ca *AgentCA) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	enrollments := []*NodeEnrollment{}
+	err := db.DB.Select(&enrollments, `SELECT server_id, token_hash, token_expires_at, certificate_serial, certificate_expires_at, enrolled_at, created_by, created_at FROM node_enrollments`)
+	if err != nil {
+		return fmt.Errorf("failed to query node enrollments: %v", err)
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	for _, enrollment := range enrollments {
+		ca.enrollments[enrollment.ServerID] = enrollment
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/vpn-service/backend/src/analytics"
+	"github.com/vpn-service/backend/src/config"
+)
+
+// AnalyticsDay represents the analytics event counts of one day (UTC)
+type AnalyticsDay struct {
+	Date   string         `json:"date"`
+	Total  int            `json:"total"`
+	Events map[string]int `json:"events"`
+}
+
+// AnalyticsReport represents analytics event counts in a period, by event
+// type and day
+type AnalyticsReport struct {
+	From   time.Time       `json:"from"`
+	To     time.Time       `json:"to"`
+	Total  int             `json:"total"`
+	Events map[string]int  `json:"events"`
+	Days   []*AnalyticsDay `json:"days"`
+}
+
+// AnalyticsReporter reports on analytics events. It reads the compressed
+// store when enabled, together with the JSON lines log, which holds the
+// events from before the store was enabled.
+type AnalyticsReporter struct {
+	config *config.Config
+	store  *analytics.Store
+}
+
+// NewAnalyticsReporter creates a new analytics reporter. The store, if
+// given, is flushed before each report so it includes the latest events.
+func NewAnalyticsReporter(cfg *config.Config, store *analytics.Store) *AnalyticsReporter {
+	return &AnalyticsReporter{
+		config: cfg,
+		store:  store,
+	}
+}
+
+// Report counts the analytics events between from and to, optionally of a
+// single event type
+func (ar *AnalyticsReporter) Report(from, to time.Time, eventType string) (*AnalyticsReport, error) {
+	report := &AnalyticsReport{
+		From:   from,
+		To:     to,
+		Events: make(map[string]int),
+		Days:   make([]*AnalyticsDay, 0),
+	}
+	days := make(map[string]*AnalyticsDay)
+
+	count := func(event analytics.Event) error {
+		if eventType != "" && event.EventType != eventType {
+			return nil
+		}
+
+		date := event.Timestamp.UTC().Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			day = &AnalyticsDay{Date: date, Events: make(map[string]int)}
+			days[date] = day
+			report.Days = append(report.Days, day)
+		}
+
+		report.Total++
+		report.Events[event.EventType]++
+		day.Total++
+		day.Events[event.EventType]++
+		return nil
+	}
+
+	logPath := filepath.Join(ar.config.Monitoring.LogDir, "usage_analytics.log")
+	if err := analytics.ReadLog(logPath, from, to, count); err != nil {
+		return nil, err
+	}
+
+	if ar.config.Monitoring.AnalyticsStore.Enabled {
+		if ar.store != nil {
+			if err := ar.store.Sync(); err != nil {
+				return nil, err
+			}
+		}
+		if err := analytics.Read(ar.config.Monitoring.AnalyticsStore.Dir, from, to, count); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(report.Days, func(i, j int) bool {
+		return report.Days[i].Date < report.Days[j].Date
+	})
+
+	return report, nil
+}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/storage"
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Storage key prefixes of generated artifacts. storage.lifecycle rules
+// match these to delete old artifacts.
+const (
+	artifactPrefix = "artifacts/"
+	archivePrefix  = "archives/"
+)
+
+// StoredFile represents a stored artifact and its download link
+type StoredFile struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Size int    `json:"size"`
+}
+
+// StoredArtifacts represents the stored config files of a device
+type StoredArtifacts struct {
+	PeerID    string       `json:"peerId"`
+	Files     []StoredFile `json:"files"`
+	ExpiresAt time.Time    `json:"expiresAt"` // when the links stop working
+}
+
+// StoredArchive represents a stored archive of the configs of a user's
+// devices
+type StoredArchive struct {
+	File      StoredFile `json:"file"`
+	Devices   int        `json:"devices"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+}
+
+// Storage gets the backend generated artifacts are stored in, or nil if
+// there is none
+func (vm *VPNManager) Storage() storage.Store {
+	return vm.storage
+}
+
+// SetStorage sets the backend generated artifacts are stored in
+func (vm *VPNManager) SetStorage(store storage.Store) {
+	vm.storage = store
+}
+
+// urlTTL is how long download links stay valid
+func (vm *VPNManager) urlTTL() time.Duration {
+	return time.Duration(vm.config.Storage.URLTTL) * time.Second
+}
+
+// StoreArtifacts renders the config, QR code and setup sheet of a peer,
+// stores them and returns links to download them from, so large files
+// need not pass through the API and can be fetched from another device
+func (vm *VPNManager) StoreArtifacts(ctx context.Context, userID, peerID string) (artifacts *StoredArtifacts, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.StoreArtifacts", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	if vm.storage == nil {
+		return nil, fmt.Errorf("artifact storage is not configured")
+	}
+
+	peer, config, err := vm.peerConfig(ctx, userID, peerID, "artifacts")
+	if err != nil {
+		return nil, err
+	}
+
+	qrCode, err := wireguard.EncodeQRCode(config, wireguard.QROptions{Format: wireguard.QRFormatPNG})
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := &wireguard.SetupSheet{
+		DeviceName: peer.DeviceName,
+		DeviceType: peer.DeviceType,
+		Config:     config,
+		CreatedAt:  time.Now(),
+	}
+	if server, err := vm.serverManager.GetServer(peer.ServerID); err == nil {
+		sheet.ServerName = server.Name
+	}
+	pdf, err := sheet.RenderPDF()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render setup sheet: %v", err)
+	}
+
+	// Every upload gets its own unguessable directory
+	dir := artifactPrefix + userID + "/" + peer.ID + "/" + utils.GenerateUUID()
+	artifacts = &StoredArtifacts{
+		PeerID:    peer.ID,
+		Files:     make([]StoredFile, 0, 3),
+		ExpiresAt: time.Now().Add(vm.urlTTL()),
+	}
+	for _, file := range []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"wg0.conf", "text/plain", []byte(config)},
+		{"wg0.png", "image/png", qrCode},
+		{"setup.pdf", "application/pdf", pdf},
+	} {
+		stored, err := vm.storeFile(ctx, path.Join(dir, file.name), file.contentType, file.data)
+		if err != nil {
+			return nil, err
+		}
+		artifacts.Files = append(artifacts.Files, *stored)
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "artifacts_stored", fmt.Sprintf("peer=%s", peer.ID))
+
+	return artifacts, nil
+}
+
+// StoreArchive stores a zip archive with the config and QR code of each of
+// a user's active static devices and returns a link to download it from
+func (vm *VPNManager) StoreArchive(ctx context.Context, userID string) (archive *StoredArchive, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.StoreArchive")
+	defer func() { tracing.End(span, err) }()
+
+	if vm.storage == nil {
+		return nil, fmt.Errorf("artifact storage is not configured")
+	}
+
+	peers, err := vm.peerManager.GetPeers(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peers: %v", err)
+	}
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	devices := 0
+	for _, peer := range peers {
+		// Dynamic peers expire on their own, and pending and archived
+		// peers have no usable config
+		if peer.Dynamic || peer.Pending() || peer.Archived() {
+			continue
+		}
+
+		config, err := vm.renderConfig(peer, "archive")
+		if err != nil {
+			utils.LogWarningContext(ctx, "Failed to render config of peer %s for archive: %v", peer.ID, err)
+			continue
+		}
+		qrCode, err := wireguard.EncodeQRCode(config, wireguard.QROptions{Format: wireguard.QRFormatPNG})
+		if err != nil {
+			return nil, err
+		}
+
+		dir := archiveDir(peer)
+		for _, file := range []struct {
+			name string
+			data []byte
+		}{
+			{"wg0.conf", []byte(config)},
+			{"wg0.png", qrCode},
+		} {
+			entry, err := writer.Create(dir + "/" + file.name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to write archive: %v", err)
+			}
+			if _, err := entry.Write(file.data); err != nil {
+				return nil, fmt.Errorf("failed to write archive: %v", err)
+			}
+		}
+		devices++
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %v", err)
+	}
+
+	key := archivePrefix + userID + "/" + utils.GenerateUUID() + "/devices.zip"
+	stored, err := vm.storeFile(ctx, key, "application/zip", buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "archive_stored", fmt.Sprintf("devices=%d", devices))
+
+	return &StoredArchive{
+		File:      *stored,
+		Devices:   devices,
+		ExpiresAt: time.Now().Add(vm.urlTTL()),
+	}, nil
+}
+
+// storeFile stores an artifact and gets a download link for it
+func (vm *VPNManager) storeFile(ctx context.Context, key, contentType string, data []byte) (*StoredFile, error) {
+	if err := vm.storage.Put(ctx, key, data, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store %s: %v", path.Base(key), err)
+	}
+	url, err := vm.storage.URL(ctx, key, vm.urlTTL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download link: %v", err)
+	}
+	return &StoredFile{Name: path.Base(key), URL: url, Size: len(data)}, nil
+}
+
+// archiveDir names the directory of a device in an archive after the
+// device, keeping it unique with the peer ID
+func archiveDir(peer *wireguard.PeerConfig) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r == ' ':
+			return '-'
+		default:
+			return -1
+		}
+	}, peer.DeviceName)
+	if name == "" {
+		return peer.ID
+	}
+	return name + "-" + peer.ID
+}
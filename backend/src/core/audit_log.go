@@ -0,0 +1,434 @@
+package core
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Audit outcomes
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeDenied  = "denied"
+	AuditOutcomeFailure = "failure"
+)
+
+// genesisHash is the previous hash of the first entry in the chain
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// AuditEntry represents a security relevant action. Each entry carries the
+// hash of the entry before it, so modifying or removing an entry breaks
+// the chain from that point on. With monitoring.auditKey set the hashes are
+// HMACs, so the chain cannot be recomputed without the key.
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor,omitempty"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Details   string    `json:"details,omitempty"`
+	PrevHash  string    `json:"prevHash"`
+	Hash      string    `json:"hash"`
+}
+
+// auditHead records the length and last hash of the chain. It is kept
+// apart from the log so truncating the log is detected as well, and with
+// monitoring.auditKey set it carries an HMAC so it cannot be rewritten to
+// match a truncated log.
+type auditHead struct {
+	Count int    `json:"count"`
+	Hash  string `json:"hash"`
+	MAC   string `json:"mac,omitempty"`
+}
+
+// AuditVerification represents the result of verifying the audit log
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	HeadHash string `json:"headHash"`
+	BrokenAt int    `json:"brokenAt,omitempty"` // 1-based line of the first bad entry
+	Error    string `json:"error,omitempty"`
+}
+
+// AuditLog keeps an append-only, hash chained record of security relevant actions
+type AuditLog struct {
+	config   *config.Config
+	entries  []*AuditEntry
+	lastHash string
+	key      []byte // HMAC key of the chain, plain SHA-256 when empty
+	mutex    sync.RWMutex
+	logPath  string
+	headPath string
+
+	// unkeyed is set when the log on disk was chained without the key. It
+	// is not continued until an operator migrates it.
+	unkeyed bool
+}
+
+// NewAuditLog creates a new audit log, loading previous entries from disk
+func NewAuditLog(cfg *config.Config) *AuditLog {
+	al := &AuditLog{
+		config:   cfg,
+		entries:  make([]*AuditEntry, 0),
+		lastHash: genesisHash,
+		key:      []byte(cfg.Monitoring.AuditKey),
+		mutex:    sync.RWMutex{},
+		logPath:  filepath.Join(cfg.Monitoring.LogDir, "audit.log"),
+		headPath: filepath.Join(cfg.Monitoring.LogDir, "audit.head"),
+	}
+	if len(al.key) == 0 {
+		utils.LogWarning("monitoring.auditKey is not set; the audit log chain can be rewritten by anyone able to write %s", cfg.Monitoring.LogDir)
+	}
+
+	if err := al.load(); err != nil {
+		utils.LogError("Failed to load audit log: %v", err)
+	}
+
+	return al
+}
+
+// Record appends an entry to the audit log
+func (al *AuditLog) Record(entry *AuditEntry) {
+	entry.ID = utils.GenerateUUID()
+	entry.Timestamp = time.Now()
+	if entry.Outcome == "" {
+		entry.Outcome = AuditOutcomeSuccess
+	}
+
+	// Chain and write under the lock so the file order matches the chain
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	if al.unkeyed {
+		utils.LogError("Not recording audit entry %s: the audit log is chained without monitoring.auditKey; run backend verify-audit --migrate", entry.Action)
+		return
+	}
+	al.record(entry)
+}
+
+// record chains and writes an entry. The caller must hold the mutex.
+func (al *AuditLog) record(entry *AuditEntry) {
+	entry.PrevHash = al.lastHash
+	entry.Hash = hashAuditEntry(al.key, entry)
+	al.entries = append(al.entries, entry)
+	al.lastHash = entry.Hash
+
+	if err := al.append(entry); err != nil {
+		utils.LogError("Failed to persist audit entry: %v", err)
+	}
+}
+
+// NeedsMigration reports whether the log on disk was chained without
+// monitoring.auditKey and must be migrated before entries are recorded
+func (al *AuditLog) NeedsMigration() bool {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	return al.unkeyed
+}
+
+// Migrate moves a log chained without monitoring.auditKey aside, after
+// checking that its chain is intact, and starts a keyed chain whose first
+// entry records the old log's length and last hash. It is meant to be run
+// once by an operator with the API stopped, and returns the path the old
+// log was moved to.
+func (al *AuditLog) Migrate() (string, error) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	if len(al.key) == 0 {
+		return "", fmt.Errorf("monitoring.auditKey is not set")
+	}
+	if !al.unkeyed {
+		return "", fmt.Errorf("audit log is not chained without monitoring.auditKey")
+	}
+
+	// Only an intact chain is carried over
+	result := verifyAuditLog(al.logPath, al.headPath, nil)
+	if !result.Valid {
+		return "", fmt.Errorf("audit log chained without monitoring.auditKey does not verify: %s", result.Error)
+	}
+
+	rotated, err := al.rotate()
+	if err != nil {
+		return "", err
+	}
+
+	al.record(&AuditEntry{
+		ID:        utils.GenerateUUID(),
+		Timestamp: time.Now(),
+		Action:    "audit_migrate",
+		Target:    filepath.Base(rotated),
+		Outcome:   AuditOutcomeSuccess,
+		Details:   fmt.Sprintf("entries=%d head=%s", result.Entries, result.HeadHash),
+	})
+
+	return rotated, nil
+}
+
+// Verify checks the audit log file against its hash chain and head record,
+// detecting modified, reordered, removed and truncated entries
+func (al *AuditLog) Verify() *AuditVerification {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	return verifyAuditLog(al.logPath, al.headPath, al.key)
+}
+
+// verifyAuditLog verifies an audit log file and its head record, chained
+// with key
+func verifyAuditLog(logPath, headPath string, key []byte) *AuditVerification {
+	result := &AuditVerification{HeadHash: genesisHash}
+
+	file, err := os.Open(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		result.Error = fmt.Sprintf("failed to open audit log: %v", err)
+		return result
+	}
+	if err == nil {
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := result.Entries + 1
+
+			var entry AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				result.BrokenAt = line
+				result.Error = fmt.Sprintf("malformed entry: %v", err)
+				return result
+			}
+			if entry.PrevHash != result.HeadHash {
+				result.BrokenAt = line
+				result.Error = "entry does not follow the previous entry"
+				return result
+			}
+			if hashAuditEntry(key, &entry) != entry.Hash {
+				result.BrokenAt = line
+				result.Error = "entry hash does not match its contents"
+				if len(key) > 0 && hashAuditEntry(nil, &entry) == entry.Hash {
+					result.Error = "entry is chained without monitoring.auditKey; run backend verify-audit --migrate"
+				}
+				return result
+			}
+
+			result.Entries = line
+			result.HeadHash = entry.Hash
+		}
+		if err := scanner.Err(); err != nil {
+			result.Error = fmt.Sprintf("failed to read audit log: %v", err)
+			return result
+		}
+	}
+
+	// Compare against the head record to catch removed trailing entries
+	head, err := readAuditHead(headPath)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if len(key) > 0 {
+		// A keyed head must exist once entries do, and carry its HMAC
+		if head == nil && result.Entries > 0 {
+			result.Error = "audit head is missing"
+			return result
+		}
+		if head != nil && !hmac.Equal([]byte(head.MAC), []byte(auditHeadMAC(key, head))) {
+			result.Error = "audit head does not match its HMAC"
+			return result
+		}
+	}
+	if head != nil && (head.Count != result.Entries || head.Hash != result.HeadHash) {
+		result.Error = fmt.Sprintf("audit log has %d entries but %d were recorded", result.Entries, head.Count)
+		return result
+	}
+
+	result.Valid = true
+	return result
+}
+
+// hashAuditEntry computes the chained hash of an entry, an HMAC-SHA256
+// with key if one is given
+func hashAuditEntry(key []byte, entry *AuditEntry) string {
+	unhashed := *entry
+	unhashed.Hash = ""
+
+	data, _ := json.Marshal(unhashed)
+	if len(key) == 0 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditHeadMAC computes the HMAC-SHA256 of a head record with key
+func auditHeadMAC(key []byte, head *auditHead) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d:%s", head.Count, head.Hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readAuditHead reads the head record, returning nil if none exists yet
+func readAuditHead(path string) (*auditHead, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit head: %v", err)
+	}
+
+	var head auditHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("malformed audit head: %v", err)
+	}
+
+	return &head, nil
+}
+
+// writeHead records the current length and last hash of the chain
+func (al *AuditLog) writeHead() error {
+	head := auditHead{Count: len(al.entries), Hash: al.lastHash}
+	if len(al.key) > 0 {
+		head.MAC = auditHeadMAC(al.key, &head)
+	}
+
+	data, err := json.Marshal(head)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit head: %v", err)
+	}
+
+	// Write atomically so a crash cannot leave a partial head
+	tmpPath := al.headPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write audit head: %v", err)
+	}
+	if err := os.Rename(tmpPath, al.headPath); err != nil {
+		return fmt.Errorf("failed to replace audit head: %v", err)
+	}
+
+	return nil
+}
+
+// List gets audit entries, newest first, optionally filtered by action
+func (al *AuditLog) List(action string, limit int) []*AuditEntry {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	entries := make([]*AuditEntry, 0)
+	for i := len(al.entries) - 1; i >= 0; i-- {
+		if action != "" && al.entries[i].Action != action {
+			continue
+		}
+		entries = append(entries, al.entries[i])
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+	}
+
+	return entries
+}
+
+// append writes an entry to the audit log file
+func (al *AuditLog) append(entry *AuditEntry) error {
+	if err := os.MkdirAll(filepath.Dir(al.logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
+	}
+
+	file, err := os.OpenFile(al.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %v", err)
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %v", err)
+	}
+
+	return al.writeHead()
+}
+
+// load reads previous entries from the audit log file
+func (al *AuditLog) load() error {
+	file, err := os.Open(al.logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			utils.LogWarning("Skipping malformed audit entry: %v", err)
+			continue
+		}
+		al.entries = append(al.entries, &entry)
+		if entry.Hash != "" {
+			al.lastHash = entry.Hash
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// A log chained before the key was set cannot be continued with it
+	if len(al.key) > 0 && len(al.entries) > 0 {
+		last := al.entries[len(al.entries)-1]
+		al.unkeyed = hashAuditEntry(al.key, last) != last.Hash && hashAuditEntry(nil, last) == last.Hash
+		if al.unkeyed {
+			utils.LogError("The audit log is chained without monitoring.auditKey; run backend verify-audit --migrate")
+		}
+	}
+
+	return nil
+}
+
+// rotate moves the log and its head aside, with the time in their names,
+// starts a new chain and returns the path the log was moved to. The caller
+// must hold the mutex.
+func (al *AuditLog) rotate() (string, error) {
+	suffix := time.Now().UTC().Format("20060102T150405")
+	rotated := make([]string, 0, 2)
+	for _, path := range []string{al.logPath, al.headPath} {
+		ext := filepath.Ext(path)
+		target := strings.TrimSuffix(path, ext) + "-" + suffix + ext
+		if err := os.Rename(path, target); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to move %s aside: %v", path, err)
+		}
+		rotated = append(rotated, target)
+	}
+
+	al.entries = make([]*AuditEntry, 0)
+	al.lastHash = genesisHash
+	al.unkeyed = false
+	return rotated[0], nil
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// autoscaleInterval is how often the load of regions is evaluated
+const autoscaleInterval = time.Minute
+
+// autoscalerActor is who provisions and drains servers for the autoscaler
+const autoscalerActor = "autoscaler"
+
+// AutoscaleRegion represents the scaling state of a region
+type AutoscaleRegion struct {
+	Region       string     `json:"region"`
+	Template     string     `json:"template"`
+	Min          int        `json:"min"`
+	Max          int        `json:"max"`
+	Servers      int        `json:"servers"`    // online
+	Pending      int        `json:"pending"`    // being provisioned
+	Load         float64    `json:"load"`       // percent of the online servers' capacity in use
+	AboveSince   *time.Time `json:"aboveSince"` // load above autoscaling.scaleUpLoad since
+	BelowSince   *time.Time `json:"belowSince"` // load below autoscaling.scaleDownLoad since
+	LastScaledAt *time.Time `json:"lastScaledAt"`
+	ScalingDown  string     `json:"scalingDown,omitempty"` // server being drained to be torn down
+}
+
+// autoscaleState is what the autoscaler keeps of a region between
+// evaluations
+type autoscaleState struct {
+	aboveSince     time.Time
+	belowSince     time.Time
+	lastScaledAt   time.Time
+	drainServer    string // being drained to be torn down
+	drainProvision string // of the server being drained
+	status         AutoscaleRegion
+}
+
+// Autoscaler provisions servers in regions running out of capacity and
+// tears down the servers it provisioned once regions are underused, within
+// each region's bounds. Its state is kept in memory; after a restart load
+// has to stay out of bounds for the configured minutes again.
+type Autoscaler struct {
+	config  *config.Config
+	servers *ServerManager
+	regions map[string]*autoscaleState
+	mutex   sync.Mutex
+}
+
+// NewAutoscaler creates a new autoscaler
+func NewAutoscaler(cfg *config.Config, servers *ServerManager) *Autoscaler {
+	return &Autoscaler{
+		config:  cfg,
+		servers: servers,
+		regions: make(map[string]*autoscaleState),
+		mutex:   sync.Mutex{},
+	}
+}
+
+// Regions gets the scaling state of the configured regions
+func (a *Autoscaler) Regions() []AutoscaleRegion {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	regions := make([]AutoscaleRegion, 0, len(a.config.Autoscaling.Regions))
+	for name, bounds := range a.config.Autoscaling.Regions {
+		status := AutoscaleRegion{Region: name, Template: bounds.Template, Min: bounds.Min, Max: bounds.Max}
+		if state, ok := a.regions[name]; ok {
+			status = state.status
+		}
+		regions = append(regions, status)
+	}
+
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].Region < regions[j].Region
+	})
+
+	return regions
+}
+
+// RunAutoscaling evaluates the load of regions in background
+func (a *Autoscaler) RunAutoscaling() {
+	if !a.config.Autoscaling.Enabled {
+		return
+	}
+	for name, bounds := range a.config.Autoscaling.Regions {
+		if _, ok := a.config.Provisioning.Templates[bounds.Template]; !ok {
+			utils.LogWarning("Autoscaling region %s has unknown provisioning template %q", name, bounds.Template)
+		}
+	}
+
+	ticker := time.NewTicker(autoscaleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		provisions := a.servers.Provisioning().ListProvisions()
+		a.cleanupFailed(provisions)
+		for name, bounds := range a.config.Autoscaling.Regions {
+			a.evaluate(name, bounds, provisions)
+		}
+	}
+}
+
+// evaluate scales a region up or down when its load was out of bounds long
+// enough. A region takes one action at a time: nothing is started while a
+// server is being provisioned or drained.
+func (a *Autoscaler) evaluate(name string, bounds config.AutoscaleRegion, provisions []*Provision) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	cfg := a.config.Autoscaling
+	now := time.Now()
+
+	state, ok := a.regions[name]
+	if !ok {
+		state = &autoscaleState{}
+		a.regions[name] = state
+	}
+
+	// Load of the region's online servers
+	online, load, capacity := 0, 0, 0
+	var candidates []*Server
+	owned := make(map[string]string) // provisioned server ID to provision ID
+	for _, provision := range provisions {
+		if provision.CreatedBy == autoscalerActor && provision.Status == ProvisionReady {
+			owned[provision.ServerID] = provision.ID
+		}
+	}
+	for _, server := range a.servers.GetServers() {
+		if server.Status != "online" || !strings.EqualFold(server.Country, name) {
+			continue
+		}
+		online++
+		load += server.Load
+		capacity += server.Capacity
+		if _, ok := owned[server.ID]; ok {
+			candidates = append(candidates, server)
+		}
+	}
+	pending := 0
+	for _, provision := range provisions {
+		if provision.CreatedBy != autoscalerActor || provision.Template != bounds.Template {
+			continue
+		}
+		switch provision.Status {
+		case ProvisionCreating, ProvisionBooting, ProvisionRegistered:
+			pending++
+		}
+	}
+
+	percent := 0.0
+	if capacity > 0 {
+		percent = float64(load) * 100 / float64(capacity)
+	}
+	if online > 0 && percent >= float64(cfg.ScaleUpLoad) {
+		if state.aboveSince.IsZero() {
+			state.aboveSince = now
+		}
+	} else {
+		state.aboveSince = time.Time{}
+	}
+	if online > 0 && percent <= float64(cfg.ScaleDownLoad) {
+		if state.belowSince.IsZero() {
+			state.belowSince = now
+		}
+	} else {
+		state.belowSince = time.Time{}
+	}
+
+	defer func() {
+		state.status = AutoscaleRegion{
+			Region:       name,
+			Template:     bounds.Template,
+			Min:          bounds.Min,
+			Max:          bounds.Max,
+			Servers:      online,
+			Pending:      pending,
+			Load:         percent,
+			AboveSince:   timePointer(state.aboveSince),
+			BelowSince:   timePointer(state.belowSince),
+			LastScaledAt: timePointer(state.lastScaledAt),
+			ScalingDown:  state.drainServer,
+		}
+	}()
+
+	// Tear down the server being scaled down once it is empty
+	if state.drainServer != "" {
+		drain, ok := a.servers.Drain(state.drainServer)
+		if !ok {
+			// The drain was stopped or the server removed meanwhile
+			utils.LogInfo("Autoscaler stopped scaling down server %s in %s", state.drainServer, name)
+			state.drainServer, state.drainProvision = "", ""
+			return
+		}
+		if !drain.Empty {
+			return
+		}
+		if _, err := a.servers.Provisioning().TeardownProvision(state.drainProvision, autoscalerActor); err != nil {
+			utils.LogError("Autoscaler failed to tear down server %s in %s: %v", state.drainServer, name, err)
+			return
+		}
+		utils.LogInfo("Autoscaler tore down server %s in %s", state.drainServer, name)
+		state.drainServer, state.drainProvision = "", ""
+		state.lastScaledAt = now
+		return
+	}
+	if pending > 0 {
+		return
+	}
+
+	cooledDown := now.Sub(state.lastScaledAt) >= time.Duration(cfg.Cooldown)*time.Minute
+	if !cooledDown {
+		return
+	}
+
+	switch {
+	case online < bounds.Min,
+		online < bounds.Max && !state.aboveSince.IsZero() && now.Sub(state.aboveSince) >= time.Duration(cfg.ScaleUpMinutes)*time.Minute:
+		provision, err := a.servers.Provisioning().StartProvision(bounds.Template, "", autoscalerActor)
+		if err != nil {
+			utils.LogError("Autoscaler failed to scale up %s: %v", name, err)
+			state.lastScaledAt = now // retry after the cooldown
+			return
+		}
+		utils.LogInfo("Autoscaler scaling up %s at %.0f%% load with %s", name, percent, provision.Name)
+		state.lastScaledAt = now
+
+	case online > bounds.Min && len(candidates) > 0 && !state.belowSince.IsZero() && now.Sub(state.belowSince) >= time.Duration(cfg.ScaleDownMinutes)*time.Minute:
+		// Drain the least loaded server the autoscaler provisioned
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].Load < candidates[j].Load
+		})
+		server := candidates[0]
+		if _, err := a.servers.StartDrain(server.ID, time.Duration(cfg.DrainWindow)*time.Minute, autoscalerActor); err != nil {
+			utils.LogError("Autoscaler failed to drain server %s in %s: %v", server.ID, name, err)
+			return
+		}
+		utils.LogInfo("Autoscaler scaling down %s at %.0f%% load, draining server %s", name, percent, server.ID)
+		state.drainServer = server.ID
+		state.drainProvision = owned[server.ID]
+		state.lastScaledAt = now
+	}
+}
+
+// cleanupFailed tears down the machines of provisions the autoscaler
+// started that failed, as it would otherwise keep creating machines
+func (a *Autoscaler) cleanupFailed(provisions []*Provision) {
+	for _, provision := range provisions {
+		if provision.CreatedBy != autoscalerActor || provision.Status != ProvisionFailed {
+			continue
+		}
+		utils.LogWarning("Autoscaler tearing down failed provision %s: %s", provision.Name, provision.Error)
+		if _, err := a.servers.Provisioning().TeardownProvision(provision.ID, autoscalerActor); err != nil {
+			utils.LogError("Autoscaler failed to tear down provision %s: %v", provision.ID, err)
+		}
+	}
+}
+
+// timePointer gets a time as a pointer, nil when zero
+func timePointer(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// BalanceInput represents what a server is scored on for new connects
+type BalanceInput struct {
+	Load           int
+	Capacity       int
+	BandwidthClass string
+	ErrorRate      float64 // share of recent peer applies that failed, 0 to 1
+}
+
+// BalanceScore scores a server for new connects, lower is better. The
+// score adds the share of capacity in use, the share of bandwidth the
+// server lacks to the fastest configured class and its error rate, each
+// times its weight. Servers at capacity score +Inf.
+func BalanceScore(cfg config.BalancingConfig, in BalanceInput) float64 {
+	if in.Capacity <= 0 || in.Load >= in.Capacity {
+		return math.Inf(1)
+	}
+	utilization := float64(in.Load) / float64(in.Capacity)
+
+	fastest := 1.0
+	for _, bandwidth := range cfg.BandwidthClasses {
+		fastest = math.Max(fastest, bandwidth)
+	}
+	bandwidth, ok := cfg.BandwidthClasses[in.BandwidthClass]
+	if !ok || bandwidth <= 0 {
+		bandwidth = 1
+	}
+	shortfall := 1 - math.Min(bandwidth/fastest, 1)
+
+	errorRate := math.Max(0, math.Min(1, in.ErrorRate))
+
+	return cfg.LoadWeight*utilization + cfg.BandwidthWeight*shortfall + cfg.ErrorWeight*errorRate
+}
+
+// applyWindow counts the peer applies on a server in a window
+type applyWindow struct {
+	start    time.Time
+	attempts int
+	failures int
+}
+
+// applyErrors tracks the peer applies on each server over the current and
+// the previous error window, so error rates recover once applies succeed
+type applyErrors struct {
+	window  time.Duration
+	current map[string]*applyWindow
+	last    map[string]*applyWindow
+	mutex   sync.Mutex
+}
+
+// newApplyErrors creates a tracker of peer apply errors
+func newApplyErrors(cfg *config.Config) *applyErrors {
+	window := time.Duration(cfg.Balancing.ErrorWindow) * time.Minute
+	if window <= 0 {
+		window = 15 * time.Minute
+	}
+	return &applyErrors{
+		window:  window,
+		current: make(map[string]*applyWindow),
+		last:    make(map[string]*applyWindow),
+	}
+}
+
+// record counts a peer apply on a server
+func (ae *applyErrors) record(serverID string, failed bool) {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	counts := ae.rotate(serverID)
+	counts.attempts++
+	if failed {
+		counts.failures++
+	}
+}
+
+// rate gets the share of failed peer applies on a server over the current
+// and the previous window, 0 without applies
+func (ae *applyErrors) rate(serverID string) float64 {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	counts := ae.rotate(serverID)
+	attempts, failures := counts.attempts, counts.failures
+	if last, ok := ae.last[serverID]; ok {
+		attempts += last.attempts
+		failures += last.failures
+	}
+	if attempts == 0 {
+		return 0
+	}
+	return float64(failures) / float64(attempts)
+}
+
+// rotate gets the current window of a server, starting a new one when it
+// ended. The caller holds ae.mutex.
+func (ae *applyErrors) rotate(serverID string) *applyWindow {
+	now := time.Now()
+	counts, ok := ae.current[serverID]
+	if ok && now.Sub(counts.start) < ae.window {
+		return counts
+	}
+
+	// A window that ended long ago no longer counts as the previous one
+	if ok && now.Sub(counts.start) < 2*ae.window {
+		ae.last[serverID] = counts
+	} else {
+		delete(ae.last, serverID)
+	}
+	counts = &applyWindow{start: now}
+	ae.current[serverID] = counts
+	return counts
+}
+
+// forget drops the counts of a server, e.g. when it is removed
+func (ae *applyErrors) forget(serverID string) {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	delete(ae.current, serverID)
+	delete(ae.last, serverID)
+}
+
+// RecordApply counts a peer apply on a server towards its error rate
+func (sm *ServerManager) RecordApply(serverID string, failed bool) {
+	sm.applies.record(serverID, failed)
+}
+
+// ErrorRate gets the share of recent peer applies on a server that failed
+func (sm *ServerManager) ErrorRate(serverID string) float64 {
+	return sm.applies.rate(serverID)
+}
+
+// balanceScore scores a server for new connects
+func (sm *ServerManager) balanceScore(server *Server) float64 {
+	return BalanceScore(sm.config.Balancing, BalanceInput{
+		Load:           server.Load,
+		Capacity:       server.Capacity,
+		BandwidthClass: server.BandwidthClass,
+		ErrorRate:      sm.applies.rate(server.ID),
+	})
+}
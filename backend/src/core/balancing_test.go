@@ -0,0 +1,277 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// testBalancing is the default balancing configuration
+var testBalancing = config.BalancingConfig{
+	LoadWeight:      1,
+	BandwidthWeight: 0.3,
+	ErrorWeight:     2,
+	BandwidthClasses: map[string]float64{
+		"100m": 0.1,
+		"1g":   1,
+		"10g":  10,
+	},
+	ErrorWindow: 15,
+}
+
+func TestBalanceScore(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.BalancingConfig
+		in   BalanceInput
+		want float64
+	}{
+		{
+			name: "idle fastest class",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 0, Capacity: 100, BandwidthClass: "10g"},
+			want: 0,
+		},
+		{
+			name: "load over capacity",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 25, Capacity: 100, BandwidthClass: "10g"},
+			want: 0.25,
+		},
+		{
+			name: "load relative to capacity",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 25, Capacity: 50, BandwidthClass: "10g"},
+			want: 0.5,
+		},
+		{
+			name: "at capacity",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 100, Capacity: 100, BandwidthClass: "10g"},
+			want: math.Inf(1),
+		},
+		{
+			name: "over capacity",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 120, Capacity: 100, BandwidthClass: "10g"},
+			want: math.Inf(1),
+		},
+		{
+			name: "no capacity",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 0, Capacity: 0, BandwidthClass: "10g"},
+			want: math.Inf(1),
+		},
+		{
+			name: "slower class",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 0, Capacity: 100, BandwidthClass: "1g"},
+			want: 0.3 * 0.9,
+		},
+		{
+			name: "slowest class",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 0, Capacity: 100, BandwidthClass: "100m"},
+			want: 0.3 * 0.99,
+		},
+		{
+			name: "unknown class counts as 1",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 0, Capacity: 100, BandwidthClass: "40g"},
+			want: 0.3 * 0.9,
+		},
+		{
+			name: "no class counts as 1",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 0, Capacity: 100},
+			want: 0.3 * 0.9,
+		},
+		{
+			name: "no classes configured",
+			cfg:  config.BalancingConfig{LoadWeight: 1, BandwidthWeight: 0.3},
+			in:   BalanceInput{Load: 10, Capacity: 100, BandwidthClass: "1g"},
+			want: 0.1,
+		},
+		{
+			name: "error rate",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 0, Capacity: 100, BandwidthClass: "10g", ErrorRate: 0.25},
+			want: 0.5,
+		},
+		{
+			name: "error rate capped at 1",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 0, Capacity: 100, BandwidthClass: "10g", ErrorRate: 3},
+			want: 2,
+		},
+		{
+			name: "weights combine",
+			cfg:  testBalancing,
+			in:   BalanceInput{Load: 50, Capacity: 100, BandwidthClass: "1g", ErrorRate: 0.1},
+			want: 0.5 + 0.3*0.9 + 2*0.1,
+		},
+		{
+			name: "zero weights",
+			cfg:  config.BalancingConfig{BandwidthClasses: testBalancing.BandwidthClasses},
+			in:   BalanceInput{Load: 50, Capacity: 100, BandwidthClass: "100m", ErrorRate: 1},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BalanceScore(tt.cfg, tt.in)
+			if math.IsInf(tt.want, 1) {
+				if !math.IsInf(got, 1) {
+					t.Fatalf("BalanceScore() = %v, want +Inf", got)
+				}
+				return
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("BalanceScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetOptimalServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers []*Server
+		country string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "least loaded",
+			servers: []*Server{
+				{ID: "a", Load: 60, Capacity: 100, BandwidthClass: "10g"},
+				{ID: "b", Load: 20, Capacity: 100, BandwidthClass: "10g"},
+			},
+			want: "b",
+		},
+		{
+			name: "load relative to capacity",
+			servers: []*Server{
+				{ID: "a", Load: 30, Capacity: 50, BandwidthClass: "10g"},
+				{ID: "b", Load: 40, Capacity: 100, BandwidthClass: "10g"},
+			},
+			want: "b",
+		},
+		{
+			name: "faster class wins at equal load",
+			servers: []*Server{
+				{ID: "a", Load: 10, Capacity: 100, BandwidthClass: "100m"},
+				{ID: "b", Load: 10, Capacity: 100, BandwidthClass: "10g"},
+				{ID: "c", Load: 10, Capacity: 100, BandwidthClass: "1g"},
+			},
+			want: "b",
+		},
+		{
+			name: "load outweighs class",
+			servers: []*Server{
+				{ID: "a", Load: 90, Capacity: 100, BandwidthClass: "10g"},
+				{ID: "b", Load: 10, Capacity: 100, BandwidthClass: "100m"},
+			},
+			want: "b",
+		},
+		{
+			name: "ties go to the lowest ID",
+			servers: []*Server{
+				{ID: "c", Load: 10, Capacity: 100, BandwidthClass: "1g"},
+				{ID: "a", Load: 10, Capacity: 100, BandwidthClass: "1g"},
+				{ID: "b", Load: 10, Capacity: 100, BandwidthClass: "1g"},
+			},
+			want: "a",
+		},
+		{
+			name: "full servers are skipped",
+			servers: []*Server{
+				{ID: "a", Load: 100, Capacity: 100, BandwidthClass: "10g"},
+				{ID: "b", Load: 90, Capacity: 100, BandwidthClass: "100m"},
+			},
+			want: "b",
+		},
+		{
+			name: "offline servers are skipped",
+			servers: []*Server{
+				{ID: "a", Load: 0, Capacity: 100, BandwidthClass: "10g", Status: "offline"},
+				{ID: "b", Load: 50, Capacity: 100, BandwidthClass: "10g"},
+			},
+			want: "b",
+		},
+		{
+			name: "country first",
+			servers: []*Server{
+				{ID: "a", Load: 0, Capacity: 100, BandwidthClass: "10g", Country: "Ireland"},
+				{ID: "b", Load: 50, Capacity: 100, BandwidthClass: "10g", Country: "Japan"},
+			},
+			country: "Japan",
+			want:    "b",
+		},
+		{
+			name: "all at capacity",
+			servers: []*Server{
+				{ID: "a", Load: 100, Capacity: 100},
+				{ID: "b", Load: 50, Capacity: 50},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Balancing: testBalancing}
+			sm := &ServerManager{
+				config:  cfg,
+				servers: make(map[string]*Server),
+				applies: newApplyErrors(cfg),
+			}
+			for _, server := range tt.servers {
+				if server.Status == "" {
+					server.Status = "online"
+				}
+				sm.servers[server.ID] = server
+			}
+
+			got, err := sm.GetOptimalServer(tt.country)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("GetOptimalServer() = %s, want an error", got.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetOptimalServer() failed: %v", err)
+			}
+			if got.ID != tt.want {
+				t.Fatalf("GetOptimalServer() = %s, want %s", got.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetOptimalServerErrorRate(t *testing.T) {
+	cfg := &config.Config{Balancing: testBalancing}
+	sm := &ServerManager{
+		config: cfg,
+		servers: map[string]*Server{
+			"a": {ID: "a", Load: 10, Capacity: 100, BandwidthClass: "10g", Status: "online"},
+			"b": {ID: "b", Load: 30, Capacity: 100, BandwidthClass: "10g", Status: "online"},
+		},
+		applies: newApplyErrors(cfg),
+	}
+
+	// Half of the applies on a failing costs it more than the extra load of b
+	sm.RecordApply("a", true)
+	sm.RecordApply("a", false)
+
+	got, err := sm.GetOptimalServer("")
+	if err != nil {
+		t.Fatalf("GetOptimalServer() failed: %v", err)
+	}
+	if got.ID != "b" {
+		t.Fatalf("GetOptimalServer() = %s, want b", got.ID)
+	}
+}
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/alerting"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Capacity alert rule types
+const (
+	AlertRegionLoad    = "region_load"    // load of a region's online servers above a threshold
+	AlertServerOffline = "server_offline" // a server offline
+)
+
+// maxCapacityAlerts is the number of recent alerts kept for the admin API
+const maxCapacityAlerts = 100
+
+// CapacityAlert represents an alert raised by a capacity alert rule for a
+// region or server
+type CapacityAlert struct {
+	Rule       string     `json:"rule"`
+	Type       string     `json:"type"`
+	Subject    string     `json:"subject"` // region or server ID
+	Message    string     `json:"message"`
+	Value      float64    `json:"value"` // percent load for region_load, minutes offline for server_offline
+	Threshold  float64    `json:"threshold,omitempty"`
+	Severity   string     `json:"severity"`
+	Since      time.Time  `json:"since"` // when the condition started to hold
+	FiredAt    time.Time  `json:"firedAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// alertCondition is a condition of a rule holding for a subject
+type alertCondition struct {
+	since time.Time
+	alert *CapacityAlert // open alert, sent once until the condition ends
+}
+
+// AlertMonitor evaluates the capacity alert rules in background and hands
+// alerts, and their resolution, to the alert dispatcher. Conditions are
+// tracked in memory, so after a restart they have to hold for the rule's
+// minutes again.
+type AlertMonitor struct {
+	config     *config.Config
+	servers    *ServerManager
+	conditions map[string]*alertCondition // by rule and subject
+	alerts     []*CapacityAlert
+	dispatcher *alerting.Dispatcher
+	mutex      sync.Mutex
+}
+
+// NewAlertMonitor creates a new capacity alert monitor
+func NewAlertMonitor(cfg *config.Config, servers *ServerManager) *AlertMonitor {
+	return &AlertMonitor{
+		config:     cfg,
+		servers:    servers,
+		conditions: make(map[string]*alertCondition),
+		alerts:     make([]*CapacityAlert, 0),
+		mutex:      sync.Mutex{},
+	}
+}
+
+// SetDispatcher sets the dispatcher alerts are sent through
+func (am *AlertMonitor) SetDispatcher(dispatcher *alerting.Dispatcher) {
+	am.dispatcher = dispatcher
+}
+
+// Alerts gets the recent alerts, open ones first, then most recent first
+func (am *AlertMonitor) Alerts() []*CapacityAlert {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	alerts := make([]*CapacityAlert, len(am.alerts))
+	for i, alert := range am.alerts {
+		copied := *alert
+		alerts[i] = &copied
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if (alerts[i].ResolvedAt == nil) != (alerts[j].ResolvedAt == nil) {
+			return alerts[i].ResolvedAt == nil
+		}
+		return alerts[i].FiredAt.After(alerts[j].FiredAt)
+	})
+
+	return alerts
+}
+
+// RunAlerts evaluates the rules every monitoring.alerts.interval seconds
+func (am *AlertMonitor) RunAlerts() {
+	cfg := am.config.Monitoring.Alerts
+	if !cfg.Enabled {
+		return
+	}
+	for _, rule := range cfg.Rules {
+		if rule.Type != AlertRegionLoad && rule.Type != AlertServerOffline {
+			utils.LogWarning("Alert rule %s has unknown type %q", rule.Name, rule.Type)
+		}
+	}
+
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, alert := range am.evaluate(now) {
+			am.send(alert)
+		}
+	}
+}
+
+// evaluate checks every rule against the servers and returns the alerts
+// that fired or resolved
+func (am *AlertMonitor) evaluate(now time.Time) []*CapacityAlert {
+	servers := am.servers.GetServers()
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	changed := make([]*CapacityAlert, 0)
+	holding := make(map[string]bool)
+	for _, rule := range am.config.Monitoring.Alerts.Rules {
+		for _, alert := range am.check(rule, servers, now) {
+			key := rule.Name + "/" + alert.Subject
+			holding[key] = true
+
+			condition, ok := am.conditions[key]
+			if !ok {
+				condition = &alertCondition{since: now}
+				am.conditions[key] = condition
+			}
+			if condition.alert != nil || now.Sub(condition.since) < time.Duration(rule.Minutes)*time.Minute {
+				continue
+			}
+
+			alert.Since = condition.since
+			alert.FiredAt = now
+			condition.alert = alert
+			am.alerts = append(am.alerts, alert)
+			if len(am.alerts) > maxCapacityAlerts {
+				am.alerts = am.alerts[len(am.alerts)-maxCapacityAlerts:]
+			}
+			copied := *alert
+			changed = append(changed, &copied)
+		}
+	}
+
+	// Resolve the alerts of conditions that no longer hold
+	for key, condition := range am.conditions {
+		if holding[key] {
+			continue
+		}
+		if condition.alert != nil {
+			resolvedAt := now
+			condition.alert.ResolvedAt = &resolvedAt
+			copied := *condition.alert
+			changed = append(changed, &copied)
+		}
+		delete(am.conditions, key)
+	}
+
+	return changed
+}
+
+// check returns an alert for every subject the condition of a rule holds
+// for right now. Regions are countries, as for autoscaling.
+func (am *AlertMonitor) check(rule config.AlertRule, servers []*Server, now time.Time) []*CapacityAlert {
+	inRegions := func(country string) bool {
+		if len(rule.Regions) == 0 {
+			return true
+		}
+		for _, region := range rule.Regions {
+			if strings.EqualFold(region, country) {
+				return true
+			}
+		}
+		return false
+	}
+
+	severity := rule.Severity
+	if severity == "" {
+		severity = alerting.SeverityWarning
+	}
+
+	alerts := make([]*CapacityAlert, 0)
+	switch rule.Type {
+	case AlertRegionLoad:
+		load := make(map[string]int)
+		capacity := make(map[string]int)
+		for _, server := range servers {
+			if server.Status != "online" || !inRegions(server.Country) {
+				continue
+			}
+			load[server.Country] += server.Load
+			capacity[server.Country] += server.Capacity
+		}
+		for region, total := range capacity {
+			if total <= 0 {
+				continue
+			}
+			percent := float64(load[region]) * 100 / float64(total)
+			if percent < rule.Threshold {
+				continue
+			}
+			alerts = append(alerts, &CapacityAlert{
+				Rule:      rule.Name,
+				Type:      rule.Type,
+				Subject:   region,
+				Message:   fmt.Sprintf("Load in %s is %.0f%% of capacity, above %.0f%% for %d minutes", region, percent, rule.Threshold, rule.Minutes),
+				Value:     percent,
+				Threshold: rule.Threshold,
+				Severity:  severity,
+			})
+		}
+
+	case AlertServerOffline:
+		for _, server := range servers {
+			if server.Status != "offline" || !inRegions(server.Country) {
+				continue
+			}
+			minutes := 0.0
+			if condition, ok := am.conditions[rule.Name+"/"+server.ID]; ok {
+				minutes = now.Sub(condition.since).Minutes()
+			}
+			alerts = append(alerts, &CapacityAlert{
+				Rule:     rule.Name,
+				Type:     rule.Type,
+				Subject:  server.ID,
+				Message:  fmt.Sprintf("Server %s (%s, %s) has been offline for %d minutes", server.Name, server.City, server.Country, rule.Minutes),
+				Value:    minutes,
+				Severity: severity,
+			})
+		}
+	}
+
+	return alerts
+}
+
+// send reports an alert, or its resolution, in the logs and through the
+// dispatcher
+func (am *AlertMonitor) send(alert *CapacityAlert) {
+	notification := &alerting.Notification{
+		Key:      alert.Rule + "/" + alert.Subject,
+		Rule:     alert.Rule,
+		Subject:  alert.Subject,
+		Summary:  alert.Message,
+		Severity: alert.Severity,
+		Time:     alert.FiredAt,
+		Details:  alert,
+	}
+	if alert.ResolvedAt != nil {
+		notification.Resolved = true
+		notification.Time = *alert.ResolvedAt
+		utils.LogInfo("Alert %s for %s resolved", alert.Rule, alert.Subject)
+	} else {
+		utils.LogError("Alert %s: %s", alert.Rule, alert.Message)
+	}
+
+	if am.dispatcher != nil {
+		am.dispatcher.Dispatch(notification)
+	}
+}
@@ -0,0 +1,422 @@
+package core
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/dnsprovider"
+	"github.com/vpn-service/backend/src/utils"
+	"golang.org/x/crypto/acme"
+)
+
+// Files kept in the certificate storage directory
+const (
+	accountKeyFile  = "account.key"
+	certificateFile = "node.crt"
+	privateKeyFile  = "node.key"
+)
+
+// NodeCertificate is the wildcard certificate distributed to node agents
+type NodeCertificate struct {
+	Version   string    `json:"version"` // fingerprint of the certificate
+	Domains   []string  `json:"domains"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	CertPEM   string    `json:"certPem,omitempty"`
+	KeyPEM    string    `json:"keyPem,omitempty"`
+}
+
+// CertificateManager issues and renews the wildcard node certificate over
+// ACME, answering DNS-01 challenges through the configured DNS provider.
+// Node agents pick up new certificates through their heartbeat.
+type CertificateManager struct {
+	config  *config.Config
+	dns     dnsprovider.Provider
+	current *NodeCertificate
+	renewal sync.Mutex // serializes issuance
+	mutex   sync.RWMutex
+}
+
+// NewCertificateManager creates a new certificate manager and loads the
+// certificate issued previously, if any
+func NewCertificateManager(cfg *config.Config) (*CertificateManager, error) {
+	certCfg := cfg.Certificates
+	if certCfg.Domain == "" {
+		return nil, fmt.Errorf("certificate domain is required")
+	}
+
+	dns, err := dnsprovider.New(context.Background(), certCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(certCfg.StorageDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %v", err)
+	}
+
+	cm := &CertificateManager{
+		config: cfg,
+		dns:    dns,
+	}
+
+	if err := cm.load(); err != nil {
+		utils.LogWarning("No usable node certificate stored: %v", err)
+	}
+
+	return cm, nil
+}
+
+// Current gets the current node certificate, or nil if none was issued yet
+func (cm *CertificateManager) Current() *NodeCertificate {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	return cm.current
+}
+
+// Info gets the current node certificate without its private key
+func (cm *CertificateManager) Info() *NodeCertificate {
+	current := cm.Current()
+	if current == nil {
+		return nil
+	}
+
+	info := *current
+	info.CertPEM = ""
+	info.KeyPEM = ""
+	return &info
+}
+
+// RunRenewals issues the certificate if none exists and renews it when it
+// gets close to expiry
+func (cm *CertificateManager) RunRenewals() {
+	ticker := time.NewTicker(12 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if cm.renewalDue() {
+			if _, err := cm.Renew(context.Background()); err != nil {
+				utils.LogError("Failed to renew node certificate: %v", err)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// renewalDue checks whether the certificate is missing or expires soon
+func (cm *CertificateManager) renewalDue() bool {
+	current := cm.Current()
+	if current == nil {
+		return true
+	}
+
+	renewBefore := time.Duration(cm.config.Certificates.RenewBefore) * 24 * time.Hour
+	return time.Until(current.NotAfter) < renewBefore
+}
+
+// Renew issues a new certificate for the node domain and its wildcard
+func (cm *CertificateManager) Renew(ctx context.Context) (*NodeCertificate, error) {
+	cm.renewal.Lock()
+	defer cm.renewal.Unlock()
+
+	domain := cm.config.Certificates.Domain
+	domains := []string{"*." + domain, domain}
+
+	client, err := cm.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create order
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %v", err)
+	}
+
+	// Both identifiers share the _acme-challenge name, so authorizations
+	// are answered one at a time
+	for _, authzURL := range order.AuthzURLs {
+		if err := cm.authorize(ctx, client, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("order failed: %v", err)
+	}
+
+	// Create certificate key and request
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate key: %v", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: domains}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %v", err)
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %v", err)
+	}
+
+	// Encode certificate chain and key
+	var certPEM strings.Builder
+	for _, der := range chain {
+		pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := parseNodeCertificate([]byte(certPEM.String()), keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	// Save certificate
+	dir := cm.config.Certificates.StorageDir
+	if err := os.WriteFile(filepath.Join(dir, privateKeyFile), keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save certificate key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, certificateFile), []byte(cert.CertPEM), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save certificate: %v", err)
+	}
+
+	cm.mutex.Lock()
+	cm.current = cert
+	cm.mutex.Unlock()
+
+	utils.LogInfo("Issued node certificate %s for %s, valid until %s", cert.Version, strings.Join(domains, ", "), cert.NotAfter.Format(time.RFC3339))
+
+	// Log analytics
+	utils.LogAnalytics("system", "node_certificate_issued", fmt.Sprintf("version=%s not_after=%s", cert.Version, cert.NotAfter.Format(time.RFC3339)))
+
+	return cert, nil
+}
+
+// authorize answers the DNS-01 challenge of a single authorization
+func (cm *CertificateManager) authorize(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %v", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return fmt.Errorf("failed to compute challenge record: %v", err)
+	}
+
+	// Wildcard identifiers are validated on their base domain
+	fqdn := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+	if err := cm.dns.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+	defer func() {
+		if err := cm.dns.CleanUp(context.Background(), fqdn, value); err != nil {
+			utils.LogWarning("Failed to remove challenge record %s: %v", fqdn, err)
+		}
+	}()
+
+	cm.waitForPropagation(ctx, fqdn, value)
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("failed to accept challenge: %v", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization of %s failed: %v", authz.Identifier.Value, err)
+	}
+
+	return nil
+}
+
+// waitForPropagation waits until a challenge record resolves or the
+// propagation timeout passes. The CA is asked to validate either way.
+func (cm *CertificateManager) waitForPropagation(ctx context.Context, fqdn, value string) {
+	deadline := time.Now().Add(time.Duration(cm.config.Certificates.PropagationTimeout) * time.Second)
+
+	for time.Now().Before(deadline) {
+		records, _ := net.DefaultResolver.LookupTXT(ctx, fqdn)
+		for _, record := range records {
+			if record == value {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	utils.LogWarning("Challenge record %s not visible after %ds, continuing", fqdn, cm.config.Certificates.PropagationTimeout)
+}
+
+// client creates an ACME client with the stored account key, registering
+// the account on first use
+func (cm *CertificateManager) client(ctx context.Context) (*acme.Client, error) {
+	key, err := cm.accountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: cm.config.Certificates.DirectoryURL,
+		UserAgent:    "vpn-service",
+	}
+
+	account := &acme.Account{}
+	if cm.config.Certificates.Email != "" {
+		account.Contact = []string{"mailto:" + cm.config.Certificates.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("failed to register ACME account: %v", err)
+	}
+
+	return client, nil
+}
+
+// accountKey loads the ACME account key, creating it on first use
+func (cm *CertificateManager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(cm.config.Certificates.StorageDir, accountKeyFile)
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid ACME account key")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read ACME account key: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ACME account key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save ACME account key: %v", err)
+	}
+
+	return key, nil
+}
+
+// load reads the stored node certificate
+func (cm *CertificateManager) load() error {
+	dir := cm.config.Certificates.StorageDir
+
+	certPEM, err := os.ReadFile(filepath.Join(dir, certificateFile))
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, privateKeyFile))
+	if err != nil {
+		return err
+	}
+
+	cert, err := parseNodeCertificate(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	cm.mutex.Lock()
+	cm.current = cert
+	cm.mutex.Unlock()
+
+	return nil
+}
+
+// InstallNodeCertificate saves a node certificate received with a heartbeat
+// response in dir, as node agents do
+func InstallNodeCertificate(dir string, cert *NodeCertificate) error {
+	if _, err := parseNodeCertificate([]byte(cert.CertPEM), []byte(cert.KeyPEM)); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, privateKeyFile), []byte(cert.KeyPEM), 0600); err != nil {
+		return fmt.Errorf("failed to save certificate key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, certificateFile), []byte(cert.CertPEM), 0644); err != nil {
+		return fmt.Errorf("failed to save certificate: %v", err)
+	}
+
+	return nil
+}
+
+// InstalledNodeCertificate reads the node certificate installed in dir
+func InstalledNodeCertificate(dir string) (*NodeCertificate, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, certificateFile))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, privateKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	return parseNodeCertificate(certPEM, keyPEM)
+}
+
+// parseNodeCertificate reads the leaf of a PEM certificate chain
+func parseNodeCertificate(certPEM, keyPEM []byte) (*NodeCertificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("invalid certificate")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	sum := sha256.Sum256(leaf.Raw)
+	return &NodeCertificate{
+		Version:   hex.EncodeToString(sum[:])[:16],
+		Domains:   leaf.DNSNames,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		CertPEM:   string(certPEM),
+		KeyPEM:    string(keyPEM),
+	}, nil
+}
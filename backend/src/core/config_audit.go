@@ -0,0 +1,281 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// ConfigRenderEvent records a client configuration being rendered for a peer
+type ConfigRenderEvent struct {
+	ID              string    `json:"id"`
+	UserID          string    `json:"userId"`
+	PeerID          string    `json:"peerId"`
+	ServerID        string    `json:"serverId"`
+	DeviceType      string    `json:"deviceType"`
+	Source          string    `json:"source"`
+	TemplateName    string    `json:"templateName"`
+	TemplateVersion string    `json:"templateVersion"`
+	ParamsHash      string    `json:"paramsHash"`
+	Endpoint        string    `json:"endpoint"`
+	RenderedAt      time.Time `json:"renderedAt"`
+}
+
+// ConfigAuditLog keeps the history of rendered client configurations
+type ConfigAuditLog struct {
+	config  *config.Config
+	events  []*ConfigRenderEvent
+	mutex   sync.RWMutex
+	logPath string
+}
+
+// NewConfigAuditLog creates a new config audit log, loading any previously
+// recorded render events from disk
+func NewConfigAuditLog(cfg *config.Config) *ConfigAuditLog {
+	al := &ConfigAuditLog{
+		config:  cfg,
+		events:  make([]*ConfigRenderEvent, 0),
+		mutex:   sync.RWMutex{},
+		logPath: filepath.Join(cfg.Monitoring.LogDir, "config_audit.log"),
+	}
+
+	if err := al.load(); err != nil {
+		utils.LogError("Failed to load config audit log: %v", err)
+	}
+
+	return al
+}
+
+// Record stores a render event for the given peer
+func (al *ConfigAuditLog) Record(peer *wireguard.PeerConfig, rendered *wireguard.RenderedConfig, source string) *ConfigRenderEvent {
+	event := &ConfigRenderEvent{
+		ID:              utils.GenerateUUID(),
+		UserID:          peer.UserID,
+		PeerID:          peer.ID,
+		ServerID:        peer.ServerID,
+		DeviceType:      peer.DeviceType,
+		Source:          source,
+		TemplateName:    rendered.TemplateName,
+		TemplateVersion: rendered.TemplateVersion,
+		ParamsHash:      rendered.ParamsHash,
+		Endpoint:        rendered.Endpoint,
+		RenderedAt:      time.Now(),
+	}
+
+	// Append under the lock so a concurrent rewrite cannot drop the event
+	al.mutex.Lock()
+	al.events = append(al.events, event)
+	if err := al.append(event); err != nil {
+		utils.LogError("Failed to persist config render event: %v", err)
+	}
+	al.mutex.Unlock()
+
+	return event
+}
+
+// GetPeerHistory gets all render events for a peer, oldest first
+func (al *ConfigAuditLog) GetPeerHistory(peerID string) []*ConfigRenderEvent {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	events := make([]*ConfigRenderEvent, 0)
+	for _, event := range al.events {
+		if event.PeerID == peerID {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// GetUserHistory gets all render events for a user, oldest first
+func (al *ConfigAuditLog) GetUserHistory(userID string) []*ConfigRenderEvent {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	events := make([]*ConfigRenderEvent, 0)
+	for _, event := range al.events {
+		if event.UserID == userID {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// GetLatest gets the most recent render event for a peer, which is the
+// configuration the client is expected to be running
+func (al *ConfigAuditLog) GetLatest(peerID string) (*ConfigRenderEvent, error) {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	for i := len(al.events) - 1; i >= 0; i-- {
+		if al.events[i].PeerID == peerID {
+			return al.events[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no config rendered for peer: %s", peerID)
+}
+
+// FindOutdated returns the latest render event of every peer whose
+// configuration points at an endpoint other than the given one
+func (al *ConfigAuditLog) FindOutdated(endpoint string) []*ConfigRenderEvent {
+	if endpoint == "" {
+		endpoint = wireguard.ServerEndpoint(al.config)
+	}
+
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	// Keep only the latest event per peer
+	latest := make(map[string]*ConfigRenderEvent)
+	for _, event := range al.events {
+		latest[event.PeerID] = event
+	}
+
+	outdated := make([]*ConfigRenderEvent, 0)
+	for _, event := range latest {
+		if event.Endpoint != endpoint {
+			outdated = append(outdated, event)
+		}
+	}
+
+	sort.Slice(outdated, func(i, j int) bool {
+		return outdated[i].RenderedAt.Before(outdated[j].RenderedAt)
+	})
+
+	return outdated
+}
+
+// Reassign moves the render history of the given peers to another user and
+// rewrites the log file. The history is left unchanged if the rewrite fails.
+func (al *ConfigAuditLog) Reassign(peerIDs []string, userID string) error {
+	moved := make(map[string]bool, len(peerIDs))
+	for _, peerID := range peerIDs {
+		moved[peerID] = true
+	}
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	// Copy changed events, since callers may hold the old ones
+	events := make([]*ConfigRenderEvent, len(al.events))
+	for i, event := range al.events {
+		if moved[event.PeerID] && event.UserID != userID {
+			copied := *event
+			copied.UserID = userID
+			event = &copied
+		}
+		events[i] = event
+	}
+
+	if err := al.rewrite(events); err != nil {
+		return err
+	}
+	al.events = events
+
+	return nil
+}
+
+// rewrite replaces the audit log file with the given events
+func (al *ConfigAuditLog) rewrite(events []*ConfigRenderEvent) error {
+	if err := os.MkdirAll(filepath.Dir(al.logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
+	}
+
+	// Write to a temporary file and rename it over the log, so readers never
+	// see a partial log
+	tmpPath := al.logPath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open config audit log: %v", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			file.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to marshal render event: %v", err)
+		}
+		writer.Write(append(data, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write config audit log: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write config audit log: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, al.logPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace config audit log: %v", err)
+	}
+
+	return nil
+}
+
+// append writes a render event to the audit log file
+func (al *ConfigAuditLog) append(event *ConfigRenderEvent) error {
+	if err := os.MkdirAll(filepath.Dir(al.logPath), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
+	}
+
+	file, err := os.OpenFile(al.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open config audit log: %v", err)
+	}
+	defer file.Close()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal render event: %v", err)
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write render event: %v", err)
+	}
+
+	return nil
+}
+
+// load reads previously recorded render events from the audit log file
+func (al *ConfigAuditLog) load() error {
+	file, err := os.Open(al.logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open config audit log: %v", err)
+	}
+	defer file.Close()
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event ConfigRenderEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			utils.LogWarning("Skipping malformed config audit entry: %v", err)
+			continue
+		}
+		al.events = append(al.events, &event)
+	}
+
+	return scanner.Err()
+}
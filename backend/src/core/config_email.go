@@ -0,0 +1,84 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/email"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// SetMailer sets the mailer used to email configs to users
+func (vm *VPNManager) SetMailer(mailer *email.Mailer) {
+	vm.mailer = mailer
+}
+
+// EmailConfig emails the configuration of a peer to the address of its owner
+func (vm *VPNManager) EmailConfig(ctx context.Context, userID, peerID string) error {
+	config, err := vm.GetConfig(ctx, userID, peerID)
+	if err != nil {
+		return err
+	}
+
+	peer, err := vm.peerManager.GetPeer(userID, peerID)
+	if err != nil {
+		return fmt.Errorf("peer not found: %s", peerID)
+	}
+
+	return vm.SendConfig(ctx, userID, peer, config)
+}
+
+// SendConfig emails a rendered configuration and its QR code to the address
+// of the peer's owner, so it can be set up on another device
+func (vm *VPNManager) SendConfig(ctx context.Context, userID string, peer *wireguard.PeerConfig, config string) error {
+	if vm.mailer == nil {
+		return fmt.Errorf("email delivery is not configured")
+	}
+	if vm.userManager == nil {
+		return fmt.Errorf("user management is not configured")
+	}
+
+	user, err := vm.userManager.GetUser(userID)
+	if err != nil {
+		return fmt.Errorf("user not found: %s", userID)
+	}
+	if user.Email == "" {
+		return fmt.Errorf("account has no email address")
+	}
+
+	attachments := []email.Attachment{
+		{Filename: "wg0.conf", ContentType: "text/plain", Data: []byte(config)},
+	}
+
+	// The config is still usable without the QR code
+	qrCode, err := configQRCode(config)
+	if err != nil {
+		utils.LogWarningContext(ctx, "Failed to generate QR code for peer %s: %v", peer.ID, err)
+	} else {
+		attachments = append(attachments, email.Attachment{Filename: "wg0.png", ContentType: "image/png", Data: qrCode})
+	}
+
+	server := peer.ServerID
+	if s, err := vm.serverManager.GetServer(peer.ServerID); err == nil {
+		server = s.Name
+	}
+
+	data := map[string]interface{}{
+		"DeviceName": peer.DeviceName,
+		"Server":     server,
+	}
+	if err := vm.mailer.Send(ctx, user.Email, email.TemplateConfig, data, attachments...); err != nil {
+		return err
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "vpn_config_emailed", fmt.Sprintf("peer_id=%s", peer.ID))
+
+	return nil
+}
+
+// configQRCode renders a configuration as a PNG QR code
+func configQRCode(config string) ([]byte, error) {
+	return wireguard.EncodeQRCode(config, wireguard.QROptions{Format: wireguard.QRFormatPNG})
+}
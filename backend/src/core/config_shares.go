@@ -0,0 +1,288 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// maxShareTTL limits how long a config share link can be requested for
+const maxShareTTL = 7 * 24 * time.Hour
+
+// ConfigShare represents a single-use link to download a peer's config on
+// another device. Only token hashes are kept, like invites.
+type ConfigShare struct {
+	ID        string     `json:"id" db:"id"`
+	UserID    string     `json:"-" db:"user_id"`
+	PeerID    string     `json:"peerId" db:"peer_id"`
+	URL       string     `json:"url,omitempty" db:"-"` // only returned when created
+	ExpiresAt time.Time  `json:"expiresAt" db:"expires_at"`
+	UsedAt    *time.Time `json:"usedAt,omitempty" db:"used_at"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" db:"revoked_at"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+
+	tokenHash string
+}
+
+// Active checks whether a share link can still be redeemed
+func (s *ConfigShare) Active(now time.Time) bool {
+	return s.UsedAt == nil && s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// ConfigShareManager issues, redeems and revokes config share links
+type ConfigShareManager struct {
+	config *config.Config
+	shares map[string]*ConfigShare // by ID
+	mutex  sync.Mutex
+}
+
+// NewConfigShareManager creates a new config share manager
+func NewConfigShareManager(cfg *config.Config) *ConfigShareManager {
+	return &ConfigShareManager{
+		config: cfg,
+		shares: make(map[string]*ConfigShare),
+		mutex:  sync.Mutex{},
+	}
+}
+
+// CreateShare issues a share link for a peer, valid for ttl or the
+// configured default when ttl is 0
+func (sm *ConfigShareManager) CreateShare(userID, peerID string, ttl time.Duration) (*ConfigShare, error) {
+	if ttl == 0 {
+		ttl = time.Duration(sm.config.API.ShareTTL) * time.Minute
+	}
+	if ttl <= 0 || ttl > maxShareTTL {
+		return nil, fmt.Errorf("share links must expire within %s", maxShareTTL)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate share token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	share := &ConfigShare{
+		ID:        utils.GenerateUUID(),
+		UserID:    userID,
+		PeerID:    peerID,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+		tokenHash: hashToken(token),
+	}
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`INSERT INTO config_shares (id, token_hash, user_id, peer_id, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+			share.ID, share.tokenHash, userID, peerID, share.ExpiresAt, share.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save share link: %v", err)
+		}
+	}
+
+	sm.mutex.Lock()
+	sm.shares[share.ID] = share
+	sm.mutex.Unlock()
+
+	// Log analytics
+	utils.LogAnalytics(userID, "config_share_created", fmt.Sprintf("peer_id=%s", peerID))
+
+	created := *share
+	created.URL = strings.TrimSuffix(sm.config.API.PublicURL, "/") + "/api/v1/config/shared/" + token
+	return &created, nil
+}
+
+// Redeem marks a share link as used and returns it. A link can only be
+// redeemed once, even across instances.
+func (sm *ConfigShareManager) Redeem(token string) (*ConfigShare, error) {
+	hash := hashToken(token)
+	now := time.Now()
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if db.DB != nil {
+		// Claim the link in one statement so concurrent downloads cannot
+		// both succeed
+		var share ConfigShare
+		err := db.DB.Get(&share,
+			`UPDATE config_shares SET used_at = $1
+			 WHERE token_hash = $2 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $1
+			 RETURNING id, user_id, peer_id, expires_at, used_at, revoked_at, created_at`,
+			now, hash,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("share link is invalid, used, revoked or expired")
+		}
+		share.tokenHash = hash
+		sm.shares[share.ID] = &share
+
+		// Log analytics
+		utils.LogAnalytics(share.UserID, "config_share_redeemed", fmt.Sprintf("peer_id=%s", share.PeerID))
+
+		return &share, nil
+	}
+
+	for _, share := range sm.shares {
+		if share.tokenHash != hash {
+			continue
+		}
+		if !share.Active(now) {
+			break
+		}
+		share.UsedAt = &now
+
+		// Log analytics
+		utils.LogAnalytics(share.UserID, "config_share_redeemed", fmt.Sprintf("peer_id=%s", share.PeerID))
+
+		redeemed := *share
+		return &redeemed, nil
+	}
+
+	return nil, fmt.Errorf("share link is invalid, used, revoked or expired")
+}
+
+// RevokeShare invalidates a user's share link before it is used
+func (sm *ConfigShareManager) RevokeShare(userID, shareID string) error {
+	now := time.Now()
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if db.DB != nil {
+		result, err := db.DB.Exec(
+			`UPDATE config_shares SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND used_at IS NULL AND revoked_at IS NULL`,
+			now, shareID, userID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to revoke share link: %v", err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return fmt.Errorf("share link not found or already used: %s", shareID)
+		}
+		if share, ok := sm.shares[shareID]; ok {
+			share.RevokedAt = &now
+		}
+	} else {
+		share, ok := sm.shares[shareID]
+		if !ok || share.UserID != userID || share.UsedAt != nil || share.RevokedAt != nil {
+			return fmt.Errorf("share link not found or already used: %s", shareID)
+		}
+		share.RevokedAt = &now
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "config_share_revoked", fmt.Sprintf("share_id=%s", shareID))
+
+	return nil
+}
+
+// ListShares lists a user's share links that can still be redeemed, newest
+// first
+func (sm *ConfigShareManager) ListShares(ctx context.Context, userID string) ([]*ConfigShare, error) {
+	now := time.Now()
+
+	if db.DB != nil {
+		shares := make([]*ConfigShare, 0)
+		err := db.DB.SelectContext(ctx, &shares,
+			`SELECT id, user_id, peer_id, expires_at, used_at, revoked_at, created_at FROM config_shares
+			 WHERE user_id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $2
+			 ORDER BY created_at DESC`,
+			userID, now,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list share links: %v", err)
+		}
+		return shares, nil
+	}
+
+	sm.mutex.Lock()
+	shares := make([]*ConfigShare, 0)
+	for _, share := range sm.shares {
+		if share.UserID == userID && share.Active(now) {
+			listed := *share
+			shares = append(shares, &listed)
+		}
+	}
+	sm.mutex.Unlock()
+
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].CreatedAt.After(shares[j].CreatedAt)
+	})
+
+	return shares, nil
+}
+
+// RunCleanup periodically forgets share links that can no longer be used
+func (sm *ConfigShareManager) RunCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+
+		sm.mutex.Lock()
+		for id, share := range sm.shares {
+			if !share.Active(now) {
+				delete(sm.shares, id)
+			}
+		}
+		sm.mutex.Unlock()
+	}
+}
+
+// ShareConfig issues a one-time link to download the config of a user's
+// peer on another device
+func (vm *VPNManager) ShareConfig(ctx context.Context, userID, peerID string, ttl time.Duration) (*ConfigShare, error) {
+	peer, err := vm.peerManager.GetPeer(userID, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("peer not found: %s", peerID)
+	}
+	if peer.Archived() {
+		return nil, fmt.Errorf("peer is archived, reactivate it first: %s", peerID)
+	}
+	if peer.Pending() {
+		return nil, fmt.Errorf("peer is pending approval: %s", peerID)
+	}
+
+	return vm.shares.CreateShare(userID, peerID, ttl)
+}
+
+// RedeemConfigShare uses up a share link and renders the config it grants
+func (vm *VPNManager) RedeemConfigShare(ctx context.Context, token string) (string, error) {
+	share, err := vm.shares.Redeem(token)
+	if err != nil {
+		return "", err
+	}
+
+	vm.mutex.RLock()
+	defer vm.mutex.RUnlock()
+
+	// Get peer
+	peer, err := vm.peerManager.GetPeer(share.UserID, share.PeerID)
+	if err != nil {
+		return "", fmt.Errorf("peer not found: %s", share.PeerID)
+	}
+	if peer.Archived() || peer.Pending() {
+		return "", fmt.Errorf("peer is archived or pending approval: %s", share.PeerID)
+	}
+
+	// Generate configuration
+	config, err := vm.renderConfig(peer, "share")
+	if err != nil {
+		utils.LogErrorContext(ctx, "Failed to render shared config for peer %s: %v", peer.ID, err)
+		return "", fmt.Errorf("failed to generate configuration: %v", err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// ConfigTemplate represents the client configuration template of a device
+// type. Overridden templates were uploaded by an admin and replace the
+// built-in template until they are reset.
+type ConfigTemplate struct {
+	Name       string    `json:"name"`
+	Content    string    `json:"content"`
+	Version    string    `json:"version"`
+	Overridden bool      `json:"overridden"`
+	UpdatedBy  string    `json:"updatedBy,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt,omitempty"`
+}
+
+// templateOverride is a template override as stored in the database
+type templateOverride struct {
+	Name      string    `db:"name"`
+	Content   string    `db:"content"`
+	UpdatedBy string    `db:"updated_by"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// ConfigTemplateManager manages the admin overrides of the built-in client
+// configuration templates
+type ConfigTemplateManager struct {
+	config    *config.Config
+	overrides map[string]*templateOverride
+	mutex     sync.RWMutex
+}
+
+// NewConfigTemplateManager creates a new config template manager
+func NewConfigTemplateManager(cfg *config.Config) *ConfigTemplateManager {
+	return &ConfigTemplateManager{
+		config:    cfg,
+		overrides: make(map[string]*templateOverride),
+		mutex:     sync.RWMutex{},
+	}
+}
+
+// ListTemplates gets the effective template of every device type
+func (tm *ConfigTemplateManager) ListTemplates(ctx context.Context) ([]*ConfigTemplate, error) {
+	templates := make([]*ConfigTemplate, 0, len(wireguard.TemplateNames()))
+	for _, name := range wireguard.TemplateNames() {
+		template, err := tm.GetTemplate(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+
+	return templates, nil
+}
+
+// GetTemplate gets the effective template of a device type
+func (tm *ConfigTemplateManager) GetTemplate(ctx context.Context, name string) (*ConfigTemplate, error) {
+	override, err := tm.getOverride(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if override != nil {
+		return &ConfigTemplate{
+			Name:       override.Name,
+			Content:    override.Content,
+			Version:    wireguard.TemplateVersion(override.Content),
+			Overridden: true,
+			UpdatedBy:  override.UpdatedBy,
+			UpdatedAt:  override.UpdatedAt,
+		}, nil
+	}
+
+	content, err := wireguard.DefaultTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigTemplate{
+		Name:    name,
+		Content: content,
+		Version: wireguard.TemplateVersion(content),
+	}, nil
+}
+
+// SetTemplate overrides the template of a device type. The template must
+// render to a valid configuration; problems are returned as a validation
+// error listing each of them.
+func (tm *ConfigTemplateManager) SetTemplate(ctx context.Context, actor, name, content string) (*ConfigTemplate, error) {
+	if !wireguard.IsTemplateName(name) {
+		return nil, fmt.Errorf("unknown config template: %s", name)
+	}
+
+	var v utils.Validator
+	for _, problem := range wireguard.ValidateTemplate(content) {
+		v.Check(false, "content", problem.Error())
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	override := &templateOverride{
+		Name:      name,
+		Content:   content,
+		UpdatedBy: actor,
+		UpdatedAt: time.Now(),
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	if db.DB != nil {
+		_, err := db.DB.ExecContext(ctx,
+			`INSERT INTO config_templates (name, content, updated_by, updated_at) VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (name) DO UPDATE SET content = $2, updated_by = $3, updated_at = $4`,
+			override.Name, override.Content, override.UpdatedBy, override.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save config template: %v", err)
+		}
+	}
+
+	tm.overrides[name] = override
+	wireguard.InvalidateTemplate(name)
+
+	utils.LogInfo("Config template %s overridden by %s", name, actor)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "config_template_updated", fmt.Sprintf("template=%s version=%s", name, wireguard.TemplateVersion(content)))
+
+	return &ConfigTemplate{
+		Name:       name,
+		Content:    content,
+		Version:    wireguard.TemplateVersion(content),
+		Overridden: true,
+		UpdatedBy:  actor,
+		UpdatedAt:  override.UpdatedAt,
+	}, nil
+}
+
+// ResetTemplate removes the override of a device type so the built-in
+// template applies again
+func (tm *ConfigTemplateManager) ResetTemplate(ctx context.Context, actor, name string) error {
+	if !wireguard.IsTemplateName(name) {
+		return fmt.Errorf("unknown config template: %s", name)
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	if db.DB != nil {
+		if _, err := db.DB.ExecContext(ctx, `DELETE FROM config_templates WHERE name = $1`, name); err != nil {
+			return fmt.Errorf("failed to reset config template: %v", err)
+		}
+	}
+
+	delete(tm.overrides, name)
+	wireguard.InvalidateTemplate(name)
+
+	utils.LogInfo("Config template %s reset to the built-in template by %s", name, actor)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "config_template_reset", fmt.Sprintf("template=%s", name))
+
+	return nil
+}
+
+// getOverride gets the override of a device template, or nil if there is none
+func (tm *ConfigTemplateManager) getOverride(ctx context.Context, name string) (*templateOverride, error) {
+	if !wireguard.IsTemplateName(name) {
+		return nil, fmt.Errorf("unknown config template: %s", name)
+	}
+
+	if db.DB != nil {
+		var override templateOverride
+		err := db.DB.GetContext(ctx, &override,
+			`SELECT name, content, updated_by, updated_at FROM config_templates WHERE name = $1`,
+			name,
+		)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get config template: %v", err)
+		}
+		return &override, nil
+	}
+
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	return tm.overrides[name], nil
+}
+
+// load looks up the override of a device template for rendering configs.
+// It is registered as the template loader of the wireguard package.
+func (tm *ConfigTemplateManager) load(name string) (string, bool, error) {
+	override, err := tm.getOverride(context.Background(), name)
+	if err != nil || override == nil {
+		return "", false, err
+	}
+
+	return override.Content, true, nil
+}
@@ -0,0 +1,351 @@
+package core
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"golang.org/x/crypto/argon2"
+)
+
+// DeviceBackupVersion is the version of the device backup format
+const DeviceBackupVersion = 1
+
+// Key derivation parameters of new backups. Restores accept other
+// parameters up to the limits below, so they can be raised later.
+const (
+	backupKDF        = "argon2id"
+	backupTime       = 3
+	backupMemory     = 64 * 1024 // in KiB
+	backupThreads    = 4
+	backupSaltSize   = 16
+	backupMaxTime    = 10
+	backupMaxMemory  = 256 * 1024
+	backupMaxThreads = 16
+	backupMaxDevices = 1000
+)
+
+// backupAAD binds the ciphertext to the backup format
+var backupAAD = []byte("vpn-service device backup v1")
+
+// Device restore statuses
+const (
+	RestoreStatusRestored = "restored"
+	RestoreStatusPending  = "pending"
+	RestoreStatusSkipped  = "skipped"
+	RestoreStatusFailed   = "failed"
+)
+
+// DeviceBackup represents an encrypted export of a user's device configs.
+// The key is derived from a passphrase with Argon2id and the devices are
+// sealed with AES-256-GCM; the passphrase is never stored.
+type DeviceBackup struct {
+	Version    int       `json:"version"`
+	KDF        string    `json:"kdf"`
+	Time       uint32    `json:"time"`
+	Memory     uint32    `json:"memory"` // in KiB
+	Threads    uint8     `json:"threads"`
+	Salt       string    `json:"salt"`
+	Nonce      string    `json:"nonce"`
+	Ciphertext string    `json:"ciphertext"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// BackupDevice represents a device inside a backup
+type BackupDevice struct {
+	DeviceType string                `json:"deviceType"`
+	DeviceName string                `json:"deviceName"`
+	ServerID   string                `json:"serverId"`
+	PublicKey  string                `json:"publicKey"`
+	PrivateKey string                `json:"privateKey,omitempty"` // unset for keys generated on the device
+	Options    wireguard.PeerOptions `json:"options"`
+	Config     string                `json:"config,omitempty"` // config as rendered at export time
+}
+
+// DeviceRestoreResult represents the outcome of restoring a single device
+type DeviceRestoreResult struct {
+	DeviceName string `json:"deviceName"`
+	PublicKey  string `json:"publicKey"`
+	Status     string `json:"status"`
+	PeerID     string `json:"peerId,omitempty"`
+	ServerID   string `json:"serverId,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DeviceRestoreSummary summarises a restore
+type DeviceRestoreSummary struct {
+	Total    int                    `json:"total"`
+	Restored int                    `json:"restored"`
+	Pending  int                    `json:"pending"`
+	Skipped  int                    `json:"skipped"`
+	Failed   int                    `json:"failed"`
+	Results  []*DeviceRestoreResult `json:"results"`
+}
+
+// ExportDevices exports the active devices of a user, with their keys and
+// configs, encrypted with a key derived from passphrase
+func (vm *VPNManager) ExportDevices(ctx context.Context, userID, passphrase string) (*DeviceBackup, error) {
+	peers, err := vm.peerManager.GetPeers(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peers: %v", err)
+	}
+
+	// Dynamic peers expire on their own, and pending and archived peers
+	// are not usable
+	devices := make([]BackupDevice, 0, len(peers))
+	for _, peer := range peers {
+		if peer.Dynamic || peer.Pending() || peer.Archived() {
+			continue
+		}
+
+		device := BackupDevice{
+			DeviceType: peer.DeviceType,
+			DeviceName: peer.DeviceName,
+			ServerID:   peer.ServerID,
+			PublicKey:  peer.PublicKey,
+			PrivateKey: peer.PrivateKey,
+			Options:    peer.PeerOptions,
+		}
+		if config, err := vm.peerManager.GenerateConfig(peer); err == nil {
+			device.Config = config
+		} else {
+			utils.LogWarningContext(ctx, "Failed to render config of peer %s for backup: %v", peer.ID, err)
+		}
+		devices = append(devices, device)
+	}
+
+	plaintext, err := json.Marshal(devices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode devices: %v", err)
+	}
+
+	backup := &DeviceBackup{
+		Version:   DeviceBackupVersion,
+		KDF:       backupKDF,
+		Time:      backupTime,
+		Memory:    backupMemory,
+		Threads:   backupThreads,
+		CreatedAt: time.Now(),
+	}
+
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	aead, err := backupCipher(passphrase, salt, backup.Time, backup.Memory, backup.Threads)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	backup.Salt = base64.StdEncoding.EncodeToString(salt)
+	backup.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	backup.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, backupAAD))
+
+	// Log analytics
+	utils.LogAnalytics(userID, "devices_exported", fmt.Sprintf("devices=%d", len(devices)))
+
+	return backup, nil
+}
+
+// RestoreDevices decrypts a backup and registers its devices on the user's
+// account with their original keys, so devices whose key pair was kept can
+// reconnect after downloading a config with their new address. Devices go
+// to serverID if set, or else to the server they were on. Every device is
+// processed independently and reported in the summary; devices whose key
+// is already in use are skipped. A wrong passphrase is returned as a
+// validation error.
+func (vm *VPNManager) RestoreDevices(ctx context.Context, userID, passphrase string, backup *DeviceBackup, serverID string) (*DeviceRestoreSummary, error) {
+	devices, err := openBackup(passphrase, backup)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &DeviceRestoreSummary{
+		Total:   len(devices),
+		Results: make([]*DeviceRestoreResult, 0, len(devices)),
+	}
+
+	for _, device := range devices {
+		if ctx.Err() != nil {
+			break
+		}
+
+		result := vm.restoreDevice(ctx, userID, device, serverID)
+		summary.Results = append(summary.Results, result)
+
+		switch result.Status {
+		case RestoreStatusRestored:
+			summary.Restored++
+		case RestoreStatusPending:
+			summary.Pending++
+		case RestoreStatusSkipped:
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+
+	utils.LogInfoContext(ctx, "Restored devices for user %s: total=%d restored=%d pending=%d skipped=%d failed=%d",
+		userID, summary.Total, summary.Restored, summary.Pending, summary.Skipped, summary.Failed)
+
+	// Log analytics
+	utils.LogAnalytics(userID, "devices_restored", fmt.Sprintf("restored=%d pending=%d skipped=%d failed=%d", summary.Restored, summary.Pending, summary.Skipped, summary.Failed))
+
+	return summary, nil
+}
+
+// restoreDevice registers a single device from a backup like a connect,
+// bounded by the connect timeout
+func (vm *VPNManager) restoreDevice(ctx context.Context, userID string, device BackupDevice, serverID string) *DeviceRestoreResult {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Connect)
+	defer cancel()
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	result := &DeviceRestoreResult{DeviceName: device.DeviceName, PublicKey: device.PublicKey}
+	fail := func(format string, args ...interface{}) *DeviceRestoreResult {
+		result.Status = RestoreStatusFailed
+		result.Error = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	// Backups are decrypted with the user's passphrase but written by
+	// anyone holding it, so devices are checked like on connect
+	var v utils.Validator
+	ValidateDevice(&v, device.DeviceType, device.DeviceName)
+	if err := v.Err(); err != nil {
+		return fail("invalid device: %v", err)
+	}
+
+	if serverID == "" {
+		serverID = device.ServerID
+	}
+	server, err := vm.serverManager.GetServer(serverID)
+	if err != nil {
+		return fail("server not found: %s", serverID)
+	}
+	if server.Status != "online" {
+		return fail("server is not online: %s", serverID)
+	}
+	result.ServerID = server.ID
+
+	// A key can only be used by one peer
+	existing, err := vm.peerManager.FindPeerByPublicKey(device.PublicKey)
+	if err != nil {
+		return fail("failed to check public key: %v", err)
+	}
+	if existing != nil {
+		result.Status = RestoreStatusSkipped
+		result.Error = "public key is already in use by another device"
+		return result
+	}
+
+	// Check plan entitlements
+	if err := vm.checkEntitlements(ctx, userID, device.Options); err != nil {
+		return fail("%v", err)
+	}
+
+	// Restored keys are enrolled like keys supplied on connect: keys not on
+	// the allow-list are held for approval
+	key, err := vm.clientKey(ctx, userID, device.PublicKey)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	// Routing presets and DNS profiles may not exist on this deployment;
+	// the resolved networks and servers are restored as they were
+	peer, err := vm.peerManager.RestorePeer(ctx, userID, server.ID, device.DeviceType, device.DeviceName, key, device.PrivateKey, device.Options)
+	if err != nil {
+		return fail("failed to restore peer: %v", err)
+	}
+	result.PeerID = peer.ID
+
+	if key.Pending {
+		utils.LogInfoContext(ctx, "Holding restored peer %s on server %s for approval", peer.ID, server.ID)
+		vm.events.Publish(EventPeerPendingApproval, PeerEvent{UserID: userID, PeerID: peer.ID, ServerID: server.ID, Device: device.DeviceName})
+		result.Status = RestoreStatusPending
+		return result
+	}
+
+	vm.serverManager.UpdateServerLoad(server.ID, server.Load+1)
+	vm.events.Publish(EventPeerConnected, PeerEvent{UserID: userID, PeerID: peer.ID, ServerID: server.ID, Dynamic: false, Device: device.DeviceName})
+
+	result.Status = RestoreStatusRestored
+	return result
+}
+
+// openBackup decrypts the devices of a backup
+func openBackup(passphrase string, backup *DeviceBackup) ([]BackupDevice, error) {
+	var v utils.Validator
+	v.Check(backup.Version == DeviceBackupVersion, "backup.version", fmt.Sprintf("must be %d", DeviceBackupVersion))
+	v.Check(backup.KDF == backupKDF, "backup.kdf", "must be "+backupKDF)
+	v.Check(backup.Time > 0 && backup.Time <= backupMaxTime, "backup.time", fmt.Sprintf("must be between 1 and %d", backupMaxTime))
+	v.Check(backup.Memory > 0 && backup.Memory <= backupMaxMemory, "backup.memory", fmt.Sprintf("must be between 1 and %d", backupMaxMemory))
+	v.Check(backup.Threads > 0 && backup.Threads <= backupMaxThreads, "backup.threads", fmt.Sprintf("must be between 1 and %d", backupMaxThreads))
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	salt, saltErr := base64.StdEncoding.DecodeString(backup.Salt)
+	nonce, nonceErr := base64.StdEncoding.DecodeString(backup.Nonce)
+	ciphertext, ciphertextErr := base64.StdEncoding.DecodeString(backup.Ciphertext)
+	v.Check(saltErr == nil && len(salt) > 0, "backup.salt", "must be base64 encoded")
+	v.Check(nonceErr == nil, "backup.nonce", "must be base64 encoded")
+	v.Check(ciphertextErr == nil, "backup.ciphertext", "must be base64 encoded")
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	aead, err := backupCipher(passphrase, salt, backup.Time, backup.Memory, backup.Threads)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		v.Check(false, "backup.nonce", fmt.Sprintf("must be %d bytes", aead.NonceSize()))
+		return nil, v.Err()
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, backupAAD)
+	if err != nil {
+		v.Check(false, "passphrase", "is wrong or the backup is damaged")
+		return nil, v.Err()
+	}
+
+	var devices []BackupDevice
+	if err := json.Unmarshal(plaintext, &devices); err != nil {
+		return nil, fmt.Errorf("failed to decode devices: %v", err)
+	}
+	if len(devices) > backupMaxDevices {
+		return nil, fmt.Errorf("backup is limited to %d devices", backupMaxDevices)
+	}
+
+	return devices, nil
+}
+
+// backupCipher derives the AES-256-GCM cipher of a backup from a passphrase
+func backupCipher(passphrase string, salt []byte, passes, memory uint32, threads uint8) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(passphrase), salt, passes, memory, threads, 32)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+
+	return aead, nil
+}
@@ -0,0 +1,409 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxDeviceKeyImportRows limits the size of a single allow-list import
+const maxDeviceKeyImportRows = 10000
+
+// Device key import row statuses
+const (
+	DeviceKeyStatusAdded   = "added"
+	DeviceKeyStatusSkipped = "skipped"
+	DeviceKeyStatusFailed  = "failed"
+)
+
+// DeviceKey represents a device public key pre-authorized by an admin.
+// Devices connecting with the key are applied without approval and get its
+// tag. A key bound to a user only matches for that user.
+type DeviceKey struct {
+	ID        string    `json:"id" db:"id"`
+	PublicKey string    `json:"publicKey" db:"public_key"`
+	Tag       string    `json:"tag,omitempty" db:"tag"`
+	UserID    string    `json:"userId,omitempty" db:"user_id"`
+	CreatedBy string    `json:"createdBy" db:"created_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// DeviceKeyRow represents a key to add to the allow-list
+type DeviceKeyRow struct {
+	PublicKey string `json:"publicKey"`
+	Tag       string `json:"tag,omitempty"`
+	UserID    string `json:"userId,omitempty"`
+}
+
+// DeviceKeyResult represents the outcome of importing a single key
+type DeviceKeyResult struct {
+	Row       int    `json:"row"`
+	PublicKey string `json:"publicKey"`
+	Status    string `json:"status"`
+	ID        string `json:"id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DeviceKeyImportSummary summarises an allow-list import
+type DeviceKeyImportSummary struct {
+	Total   int                `json:"total"`
+	Added   int                `json:"added"`
+	Skipped int                `json:"skipped"`
+	Failed  int                `json:"failed"`
+	Results []*DeviceKeyResult `json:"results"`
+}
+
+// DeviceKeyManager manages the allow-list of device keys for managed-device
+// enrollment
+type DeviceKeyManager struct {
+	config *config.Config
+	keys   map[string]*DeviceKey // by public key
+	mutex  sync.RWMutex
+}
+
+// NewDeviceKeyManager creates a new device key manager
+func NewDeviceKeyManager(cfg *config.Config) *DeviceKeyManager {
+	return &DeviceKeyManager{
+		config: cfg,
+		keys:   make(map[string]*DeviceKey),
+		mutex:  sync.RWMutex{},
+	}
+}
+
+// ImportKeys adds keys to the allow-list. Every row is processed
+// independently; invalid keys fail and keys already on the list are skipped.
+func (dm *DeviceKeyManager) ImportKeys(ctx context.Context, actor string, rows []DeviceKeyRow) (*DeviceKeyImportSummary, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("import must contain at least one key")
+	}
+	if len(rows) > maxDeviceKeyImportRows {
+		return nil, fmt.Errorf("import is limited to %d keys", maxDeviceKeyImportRows)
+	}
+
+	summary := &DeviceKeyImportSummary{
+		Total:   len(rows),
+		Results: make([]*DeviceKeyResult, 0, len(rows)),
+	}
+	seen := make(map[string]bool)
+
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	for i, row := range rows {
+		result := &DeviceKeyResult{Row: i + 1, PublicKey: strings.TrimSpace(row.PublicKey)}
+		summary.Results = append(summary.Results, result)
+
+		key, err := dm.addKey(ctx, actor, row, seen)
+		switch {
+		case err == errDeviceKeyExists:
+			result.Status = DeviceKeyStatusSkipped
+			result.Error = err.Error()
+			summary.Skipped++
+		case err != nil:
+			result.Status = DeviceKeyStatusFailed
+			result.Error = err.Error()
+			summary.Failed++
+		default:
+			result.Status = DeviceKeyStatusAdded
+			result.ID = key.ID
+			summary.Added++
+		}
+	}
+
+	// Log analytics
+	utils.LogAnalytics(actor, "device_keys_imported", fmt.Sprintf("added=%d skipped=%d failed=%d", summary.Added, summary.Skipped, summary.Failed))
+
+	return summary, nil
+}
+
+// errDeviceKeyExists is returned for keys already on the allow-list
+var errDeviceKeyExists = errors.New("key is already on the allow-list")
+
+// addKey validates and saves a single key. The caller must hold the lock.
+func (dm *DeviceKeyManager) addKey(ctx context.Context, actor string, row DeviceKeyRow, seen map[string]bool) (*DeviceKey, error) {
+	publicKey := strings.TrimSpace(row.PublicKey)
+	if !wireguard.ValidKey(publicKey) {
+		return nil, fmt.Errorf("invalid WireGuard public key")
+	}
+	if seen[publicKey] {
+		return nil, errDeviceKeyExists
+	}
+	seen[publicKey] = true
+
+	key := &DeviceKey{
+		ID:        utils.GenerateUUID(),
+		PublicKey: publicKey,
+		Tag:       strings.TrimSpace(row.Tag),
+		UserID:    strings.TrimSpace(row.UserID),
+		CreatedBy: actor,
+		CreatedAt: time.Now(),
+	}
+
+	if db.DB != nil {
+		result, err := db.DB.ExecContext(ctx,
+			`INSERT INTO device_keys (id, public_key, tag, user_id, created_by, created_at) VALUES ($1, $2, $3, $4, $5, $6)
+			 ON CONFLICT (public_key) DO NOTHING`,
+			key.ID, key.PublicKey, key.Tag, key.UserID, key.CreatedBy, key.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save device key: %v", err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return nil, errDeviceKeyExists
+		}
+	} else if _, ok := dm.keys[publicKey]; ok {
+		return nil, errDeviceKeyExists
+	}
+
+	dm.keys[publicKey] = key
+
+	return key, nil
+}
+
+// ListKeys gets the keys on the allow-list, newest first
+func (dm *DeviceKeyManager) ListKeys(ctx context.Context) ([]*DeviceKey, error) {
+	if db.DB != nil {
+		keys := make([]*DeviceKey, 0)
+		err := db.DB.SelectContext(ctx, &keys,
+			`SELECT id, public_key, tag, user_id, created_by, created_at FROM device_keys ORDER BY created_at DESC`,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list device keys: %v", err)
+		}
+		return keys, nil
+	}
+
+	dm.mutex.RLock()
+	keys := make([]*DeviceKey, 0, len(dm.keys))
+	for _, key := range dm.keys {
+		keys = append(keys, key)
+	}
+	dm.mutex.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// DeleteKey removes a key from the allow-list. Peers already using it keep
+// working.
+func (dm *DeviceKeyManager) DeleteKey(ctx context.Context, actor, id string) error {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	if db.DB != nil {
+		result, err := db.DB.ExecContext(ctx, `DELETE FROM device_keys WHERE id = $1`, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete device key: %v", err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return fmt.Errorf("device key not found: %s", id)
+		}
+	}
+
+	found := false
+	for publicKey, key := range dm.keys {
+		if key.ID == id {
+			delete(dm.keys, publicKey)
+			found = true
+		}
+	}
+	if !found && db.DB == nil {
+		return fmt.Errorf("device key not found: %s", id)
+	}
+
+	// Log analytics
+	utils.LogAnalytics(actor, "device_key_deleted", fmt.Sprintf("key_id=%s", id))
+
+	return nil
+}
+
+// Match gets the allow-list entry for a public key that applies to a user,
+// or nil if the key is unknown or bound to another user
+func (dm *DeviceKeyManager) Match(ctx context.Context, userID, publicKey string) (*DeviceKey, error) {
+	var key *DeviceKey
+
+	if db.DB != nil {
+		var found DeviceKey
+		err := db.DB.GetContext(ctx, &found,
+			`SELECT id, public_key, tag, user_id, created_by, created_at FROM device_keys WHERE public_key = $1`,
+			publicKey,
+		)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to look up device key: %v", err)
+		}
+		if err == nil {
+			key = &found
+		}
+	} else {
+		dm.mutex.RLock()
+		key = dm.keys[publicKey]
+		dm.mutex.RUnlock()
+	}
+
+	if key == nil || (key.UserID != "" && key.UserID != userID) {
+		return nil, nil
+	}
+
+	return key, nil
+}
+
+// clientKey decides how a device's own public key is enrolled: keys on the
+// allow-list are applied with their tag, unknown keys are held for approval
+func (vm *VPNManager) clientKey(ctx context.Context, userID, publicKey string) (wireguard.ClientKey, error) {
+	if !wireguard.ValidKey(publicKey) {
+		return wireguard.ClientKey{}, fmt.Errorf("invalid public key")
+	}
+
+	// A key can only be used by one peer
+	existing, err := vm.peerManager.FindPeerByPublicKey(publicKey)
+	if err != nil {
+		return wireguard.ClientKey{}, fmt.Errorf("failed to check public key: %v", err)
+	}
+	if existing != nil {
+		return wireguard.ClientKey{}, fmt.Errorf("public key is already in use by another device")
+	}
+
+	entry, err := vm.deviceKeys.Match(ctx, userID, publicKey)
+	if err != nil {
+		return wireguard.ClientKey{}, err
+	}
+	if entry == nil {
+		return wireguard.ClientKey{PublicKey: publicKey, Pending: true}, nil
+	}
+
+	return wireguard.ClientKey{PublicKey: publicKey, Tag: entry.Tag}, nil
+}
+
+// holdForApproval saves a peer with an unknown key until an admin approves
+// it. The caller must hold the lock.
+func (vm *VPNManager) holdForApproval(ctx context.Context, userID string, server *Server, deviceType, deviceName string, key wireguard.ClientKey, opts wireguard.PeerOptions) (*wireguard.PeerConfig, string, error) {
+	peer, err := vm.peerManager.CreateClientKeyPeer(ctx, userID, server.ID, deviceType, deviceName, key, opts)
+	if err != nil {
+		if ctxErr := contextError(ctx, "connect"); ctxErr != nil {
+			return nil, "", ctxErr
+		}
+		return nil, "", fmt.Errorf("failed to create peer: %v", err)
+	}
+
+	utils.LogInfoContext(ctx, "Holding peer %s on server %s for approval", peer.ID, server.ID)
+	vm.events.Publish(EventPeerPendingApproval, PeerEvent{UserID: userID, PeerID: peer.ID, ServerID: server.ID, Device: deviceName})
+
+	// Log analytics
+	utils.LogAnalytics(userID, "vpn_connect_pending", fmt.Sprintf("server=%s device=%s", server.ID, deviceType))
+
+	return peer, "", nil
+}
+
+// ListPendingPeers gets the peers of all users waiting for approval, oldest
+// first
+func (vm *VPNManager) ListPendingPeers() ([]*wireguard.PeerConfig, error) {
+	peers, err := vm.peerManager.ListPeers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list peers: %v", err)
+	}
+
+	pending := make([]*wireguard.PeerConfig, 0)
+	for _, peer := range peers {
+		if peer.Pending() {
+			pending = append(pending, peer)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+
+	return pending, nil
+}
+
+// ApprovePeer applies a peer held for approval on its server. The device
+// can then download its config.
+func (vm *VPNManager) ApprovePeer(ctx context.Context, actor, userID, peerID, tag string) (peer *wireguard.PeerConfig, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Connect)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.ApprovePeer", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "approve"); err != nil {
+		return nil, err
+	}
+
+	// Get peer
+	peer, err = vm.peerManager.GetPeer(userID, peerID)
+	if err != nil || peer.Dynamic {
+		return nil, fmt.Errorf("peer not found: %s", peerID)
+	}
+	if !peer.Pending() {
+		return nil, fmt.Errorf("peer is not pending approval: %s", peerID)
+	}
+
+	// Get server
+	server, err := vm.serverManager.GetServer(peer.ServerID)
+	if err != nil {
+		return nil, fmt.Errorf("server not found: %s", peer.ServerID)
+	}
+	if server.Status != "online" {
+		return nil, fmt.Errorf("server is not online: %s", peer.ServerID)
+	}
+
+	// Apply peer
+	peer, err = vm.peerManager.ApprovePeer(ctx, userID, peerID, tag)
+	if err != nil {
+		if ctxErr := contextError(ctx, "approve"); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("failed to approve peer: %v", err)
+	}
+
+	// Update server load
+	vm.serverManager.UpdateServerLoad(server.ID, server.Load+1)
+	utils.LogInfoContext(ctx, "Approved peer %s on server %s", peer.ID, server.ID)
+	vm.events.Publish(EventPeerApproved, PeerEvent{UserID: userID, PeerID: peer.ID, ServerID: server.ID, Device: peer.DeviceName})
+
+	// Record the first connect in the conversion funnel
+	vm.funnel.Record(userID, FunnelStageFirstConnect)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "vpn_peer_approved", fmt.Sprintf("user=%s peer=%s", userID, peer.ID))
+
+	return peer, nil
+}
+
+// RejectPeer deletes a peer held for approval
+func (vm *VPNManager) RejectPeer(ctx context.Context, actor, userID, peerID string) error {
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	peer, err := vm.peerManager.RejectPeer(userID, peerID)
+	if err != nil {
+		return err
+	}
+
+	utils.LogInfoContext(ctx, "Rejected peer %s on server %s", peer.ID, peer.ServerID)
+	vm.events.Publish(EventPeerRejected, PeerEvent{UserID: userID, PeerID: peer.ID, ServerID: peer.ServerID, Device: peer.DeviceName})
+
+	// Log analytics
+	utils.LogAnalytics(actor, "vpn_peer_rejected", fmt.Sprintf("user=%s peer=%s", userID, peer.ID))
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// maxDNSServers limits the DNS servers of a single device
+const maxDNSServers = 4
+
+// DNSChoice represents the DNS servers requested for a new device: either
+// explicit servers or the name of a DNS profile. The account default
+// applies when both are empty. LeakProtection adds the platform's rules
+// against DNS queries bypassing the tunnel to the config.
+type DNSChoice struct {
+	Servers        []string
+	Profile        string
+	LeakProtection bool
+}
+
+// DNSProfile represents a named set of DNS servers users can pick at
+// connect time. Enforced profiles are also applied on the node, so devices
+// cannot bypass the filtering with other DNS servers.
+type DNSProfile struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Servers     []string `json:"servers"`
+	Enforced    bool     `json:"enforced"`
+}
+
+// newDNSProfile builds a DNS profile from its config
+func newDNSProfile(name string, cfg config.DNSProfileConfig) DNSProfile {
+	return DNSProfile{
+		Name:        name,
+		Description: cfg.Description,
+		Servers:     splitDNS(cfg.Servers),
+		Enforced:    cfg.Enforce,
+	}
+}
+
+// DNSProfiles gets the DNS profiles from wireguard.dnsProfiles, by name
+func (vm *VPNManager) DNSProfiles() []DNSProfile {
+	profiles := make([]DNSProfile, 0, len(vm.config.WireGuard.DNSProfiles))
+	for name, cfg := range vm.config.WireGuard.DNSProfiles {
+		profiles = append(profiles, newDNSProfile(name, cfg))
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	return profiles
+}
+
+// GetDNSProfile gets a DNS profile by name
+func (vm *VPNManager) GetDNSProfile(name string) (*DNSProfile, error) {
+	cfg, ok := vm.config.WireGuard.DNSProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("DNS profile not found: %s", name)
+	}
+	profile := newDNSProfile(name, cfg)
+	return &profile, nil
+}
+
+// ValidateDNSServers checks a list of DNS servers requested for a device
+func ValidateDNSServers(servers []string) error {
+	if len(servers) > maxDNSServers {
+		return fmt.Errorf("at most %d DNS servers are allowed", maxDNSServers)
+	}
+	for _, server := range servers {
+		if net.ParseIP(strings.TrimSpace(server)) == nil {
+			return fmt.Errorf("invalid DNS server: %s", server)
+		}
+	}
+	return nil
+}
+
+// applyDNS sets the DNS servers of a new peer from the requested servers or
+// profile, in place of the account default, and its leak protection. The
+// servers are resolved now and kept on the peer together with the profile
+// name.
+func (vm *VPNManager) applyDNS(opts *wireguard.PeerOptions, choice DNSChoice) error {
+	opts.LeakProtection = choice.LeakProtection
+
+	switch {
+	case len(choice.Servers) > 0:
+		if err := ValidateDNSServers(choice.Servers); err != nil {
+			return err
+		}
+		servers := make([]string, 0, len(choice.Servers))
+		for _, server := range choice.Servers {
+			servers = append(servers, strings.TrimSpace(server))
+		}
+		opts.DNS = strings.Join(servers, ", ")
+		opts.DNSProfile = ""
+	case choice.Profile != "":
+		profile, err := vm.GetDNSProfile(choice.Profile)
+		if err != nil {
+			return err
+		}
+		opts.DNS = strings.Join(profile.Servers, ", ")
+		opts.DNSProfile = profile.Name
+	}
+
+	return nil
+}
+
+// splitDNS splits a comma separated list of DNS servers
+func splitDNS(servers string) []string {
+	list := make([]string, 0)
+	for _, server := range strings.Split(servers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			list = append(list, server)
+		}
+	}
+	return list
+}
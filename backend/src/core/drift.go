@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// DriftObserver is notified of the drift found on the WireGuard interfaces
+// of a server, including checks that found none
+type DriftObserver func(serverID string, drift wireguard.Drift)
+
+// DriftEvent is the data of peers found to differ from the desired state on
+// the WireGuard interfaces of a server
+type DriftEvent struct {
+	ServerID string `json:"serverId"`
+	wireguard.Drift
+}
+
+// SetDriftObserver sets the observer notified of drift found on servers
+func (sm *ServerManager) SetDriftObserver(observer DriftObserver) {
+	sm.drift = observer
+}
+
+// reportDrift records the drift found on a server, by the local reconciler
+// or reported by its node agent
+func (sm *ServerManager) reportDrift(serverID string, drift wireguard.Drift) {
+	if sm.drift != nil {
+		sm.drift(serverID, drift)
+	}
+	if drift.Total() == 0 {
+		return
+	}
+
+	outcome := "left in place"
+	if drift.Corrected {
+		outcome = "corrected"
+	}
+	utils.LogWarning("WireGuard drift on server %s %s: %d missing, %d unexpected and %d changed peers",
+		serverID, outcome, drift.Missing, drift.Unexpected, drift.Changed)
+
+	if sm.events != nil {
+		sm.events.Publish(EventWireGuardDrift, DriftEvent{ServerID: serverID, Drift: drift})
+	}
+}
+
+// RunDriftReconciler periodically compares the peers on the local WireGuard
+// interfaces with the stored ones, every wireguard.drift.interval seconds,
+// correcting them when wireguard.drift.autoCorrect is set
+func (vm *VPNManager) RunDriftReconciler() {
+	interval := time.Duration(vm.config.WireGuard.Drift.Interval) * time.Second
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		vm.reconcileDrift()
+	}
+}
+
+// reconcileDrift checks the local interfaces for drift once
+func (vm *VPNManager) reconcileDrift() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	check := vm.peerManager.DetectDrift
+	if vm.config.WireGuard.Drift.AutoCorrect {
+		check = vm.peerManager.Reconcile
+	}
+
+	drift, err := check(ctx)
+	if err != nil {
+		utils.LogError("Failed to check WireGuard drift: %v", err)
+		return
+	}
+	vm.serverManager.reportDrift(LocalServerID, drift)
+}
@@ -0,0 +1,405 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/cache"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// EntitlementError is returned when a user's plan does not allow an action
+type EntitlementError struct {
+	Message string
+}
+
+// Error implements the error interface
+func (e *EntitlementError) Error() string {
+	return e.Message
+}
+
+// EntitlementManager decides what each user may do based on their plan.
+// Plans are looked up on every check, so admin changes take effect without
+// reconnecting or restarting. Plan assignments read from the database are
+// cached for cache.userPlans seconds.
+type EntitlementManager struct {
+	config    *config.Config
+	plans     map[string]*models.Plan
+	userPlans map[string]string
+	planCache *cache.Cache[string, string]
+	funnel    *FunnelTracker
+	mutex     sync.RWMutex
+}
+
+// NewEntitlementManager creates a new entitlement manager. Moving a user to
+// a paid plan is recorded as a subscription in the given funnel.
+func NewEntitlementManager(cfg *config.Config, funnel *FunnelTracker) *EntitlementManager {
+	em := &EntitlementManager{
+		config:    cfg,
+		plans:     defaultPlans(),
+		userPlans: make(map[string]string),
+		planCache: cache.New[string, string]("user_plans", time.Duration(cfg.Cache.UserPlans)*time.Second),
+		funnel:    funnel,
+		mutex:     sync.RWMutex{},
+	}
+
+	if err := em.load(); err != nil {
+		utils.LogError("Failed to load plans: %v", err)
+	}
+
+	return em
+}
+
+// defaultPlans gets the built-in plans used when no database is available
+func defaultPlans() map[string]*models.Plan {
+	now := time.Now()
+	return map[string]*models.Plan{
+		"free": {
+			ID:   "free",
+			Name: "Free",
+			Entitlements: models.Entitlements{
+				Protocols:     []string{"wireguard"},
+				MaxDevices:    1,
+				BandwidthMbps: 50,
+			},
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		"premium": {
+			ID:   "premium",
+			Name: "Premium",
+			Entitlements: models.Entitlements{
+				Protocols:      []string{"wireguard"},
+				MaxDevices:     5,
+				MultiHop:       true,
+				DedicatedIP:    true,
+				PortForwarding: true,
+			},
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}
+}
+
+// ListPlans gets all plans
+func (em *EntitlementManager) ListPlans() []*models.Plan {
+	em.refresh()
+
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+
+	plans := make([]*models.Plan, 0, len(em.plans))
+	for _, plan := range em.plans {
+		plans = append(plans, plan)
+	}
+
+	return plans
+}
+
+// GetPlan gets a plan by ID
+func (em *EntitlementManager) GetPlan(id string) (*models.Plan, error) {
+	em.refresh()
+
+	em.mutex.RLock()
+	defer em.mutex.RUnlock()
+
+	plan, ok := em.plans[id]
+	if !ok {
+		return nil, fmt.Errorf("plan not found: %s", id)
+	}
+
+	return plan, nil
+}
+
+// CreatePlan creates a new plan
+func (em *EntitlementManager) CreatePlan(id, name string, entitlements models.Entitlements) (*models.Plan, error) {
+	if id == "" || name == "" {
+		return nil, fmt.Errorf("plan ID and name are required")
+	}
+	if err := validateEntitlements(&entitlements); err != nil {
+		return nil, err
+	}
+
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	if _, ok := em.plans[id]; ok {
+		return nil, fmt.Errorf("plan already exists: %s", id)
+	}
+
+	now := time.Now()
+	plan := &models.Plan{
+		ID:           id,
+		Name:         name,
+		Entitlements: entitlements,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`INSERT INTO plans (id, name, entitlements, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)`,
+			plan.ID, plan.Name, plan.Entitlements, plan.CreatedAt, plan.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save plan: %v", err)
+		}
+	}
+
+	em.plans[id] = plan
+
+	utils.LogInfo("Created plan %s", id)
+
+	return plan, nil
+}
+
+// UpdatePlan updates a plan's name and entitlements
+func (em *EntitlementManager) UpdatePlan(id, name string, entitlements models.Entitlements) (*models.Plan, error) {
+	if err := validateEntitlements(&entitlements); err != nil {
+		return nil, err
+	}
+
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	existing, ok := em.plans[id]
+	if !ok {
+		return nil, fmt.Errorf("plan not found: %s", id)
+	}
+
+	plan := *existing
+	if name != "" {
+		plan.Name = name
+	}
+	plan.Entitlements = entitlements
+	plan.UpdatedAt = time.Now()
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`UPDATE plans SET name = $1, entitlements = $2, updated_at = $3 WHERE id = $4`,
+			plan.Name, plan.Entitlements, plan.UpdatedAt, plan.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update plan: %v", err)
+		}
+	}
+
+	em.plans[id] = &plan
+
+	utils.LogInfo("Updated plan %s", id)
+
+	return &plan, nil
+}
+
+// DeletePlan deletes a plan. Users on the plan fall back to the default plan.
+func (em *EntitlementManager) DeletePlan(id string) error {
+	if id == models.DefaultPlanID {
+		return fmt.Errorf("the default plan cannot be deleted")
+	}
+
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	if _, ok := em.plans[id]; !ok {
+		return fmt.Errorf("plan not found: %s", id)
+	}
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`UPDATE users SET plan = $1 WHERE plan = $2`, models.DefaultPlanID, id); err != nil {
+			return fmt.Errorf("failed to move users off plan: %v", err)
+		}
+		if _, err := db.DB.Exec(`DELETE FROM plans WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to delete plan: %v", err)
+		}
+	}
+
+	delete(em.plans, id)
+	for userID, planID := range em.userPlans {
+		if planID == id {
+			delete(em.userPlans, userID)
+		}
+	}
+	em.planCache.Purge()
+
+	utils.LogInfo("Deleted plan %s", id)
+
+	return nil
+}
+
+// SetUserPlan assigns a plan to a user
+func (em *EntitlementManager) SetUserPlan(userID, planID string) error {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	if _, ok := em.plans[planID]; !ok {
+		return fmt.Errorf("plan not found: %s", planID)
+	}
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`UPDATE users SET plan = $1, updated_at = $2 WHERE id = $3`, planID, time.Now(), userID); err != nil {
+			return fmt.Errorf("failed to update user plan: %v", err)
+		}
+	}
+
+	em.assignUserPlan(userID, planID)
+
+	return nil
+}
+
+// assignUserPlan records a plan assignment already saved to the database.
+// The caller must hold the mutex.
+func (em *EntitlementManager) assignUserPlan(userID, planID string) {
+	em.userPlans[userID] = planID
+	em.planCache.Delete(userID)
+
+	// Record the subscription in the conversion funnel
+	if planID != models.DefaultPlanID && em.funnel != nil {
+		em.funnel.Record(userID, FunnelStageSubscribed)
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "user_plan_change", fmt.Sprintf("plan=%s", planID))
+}
+
+// GetUserPlan gets the plan of a user
+func (em *EntitlementManager) GetUserPlan(ctx context.Context, userID string) *models.Plan {
+	planID := models.DefaultPlanID
+
+	// Read the assignment from the database so changes made through other
+	// instances apply once the cached assignment expires
+	if db.DB != nil {
+		id, err := em.planCache.GetOrLoad(userID, func() (string, error) {
+			var id string
+			err := db.DB.GetContext(ctx, &id, `SELECT plan FROM users WHERE id = $1`, userID)
+			return id, err
+		})
+		if err == nil {
+			planID = id
+		}
+	} else {
+		em.mutex.RLock()
+		if id, ok := em.userPlans[userID]; ok {
+			planID = id
+		}
+		em.mutex.RUnlock()
+	}
+
+	plan, err := em.GetPlan(planID)
+	if err != nil {
+		utils.LogWarning("User %s has unknown plan %s, using default", utils.RedactUserID(userID), planID)
+		plan, _ = em.GetPlan(models.DefaultPlanID)
+	}
+
+	return plan
+}
+
+// Entitlements gets what a user's plan allows
+func (em *EntitlementManager) Entitlements(ctx context.Context, userID string) models.Entitlements {
+	plan := em.GetUserPlan(ctx, userID)
+	if plan == nil {
+		return models.Entitlements{}
+	}
+	return plan.Entitlements
+}
+
+// peerEntitlements gets what a user's plan allows the user's peers on their
+// nodes
+func (em *EntitlementManager) peerEntitlements(userID string) wireguard.PeerEntitlements {
+	entitlements := em.Entitlements(context.Background(), userID)
+	return wireguard.PeerEntitlements{
+		BandwidthMbps:  entitlements.BandwidthMbps,
+		PortForwarding: entitlements.PortForwarding,
+	}
+}
+
+// CheckFeature checks whether a user's plan includes a feature
+func (em *EntitlementManager) CheckFeature(ctx context.Context, userID, feature string) error {
+	if !em.Entitlements(ctx, userID).HasFeature(feature) {
+		return &EntitlementError{Message: fmt.Sprintf("your plan does not include %s", strings.ReplaceAll(feature, "_", " "))}
+	}
+	return nil
+}
+
+// CheckConnect checks whether a user may add a device using a protocol
+func (em *EntitlementManager) CheckConnect(ctx context.Context, userID, protocol string, devices int) error {
+	entitlements := em.Entitlements(ctx, userID)
+
+	if !entitlements.AllowsProtocol(protocol) {
+		return &EntitlementError{Message: fmt.Sprintf("your plan does not include the %s protocol", protocol)}
+	}
+	if entitlements.MaxDevices > 0 && devices >= entitlements.MaxDevices {
+		return &EntitlementError{Message: fmt.Sprintf("your plan allows at most %d devices", entitlements.MaxDevices)}
+	}
+
+	return nil
+}
+
+// validateEntitlements validates and normalizes plan entitlements
+func validateEntitlements(entitlements *models.Entitlements) error {
+	if len(entitlements.Protocols) == 0 {
+		return fmt.Errorf("at least one protocol is required")
+	}
+	for i, protocol := range entitlements.Protocols {
+		protocol = strings.ToLower(protocol)
+		supported := false
+		for _, p := range models.SupportedProtocols {
+			if protocol == p {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("unsupported protocol: %s", protocol)
+		}
+		entitlements.Protocols[i] = protocol
+	}
+
+	if entitlements.MaxDevices < 0 {
+		return fmt.Errorf("max devices cannot be negative")
+	}
+	if entitlements.BandwidthMbps < 0 {
+		return fmt.Errorf("bandwidth cannot be negative")
+	}
+
+	return nil
+}
+
+// refresh reloads plans from the database so changes made through other
+// instances apply immediately
+func (em *EntitlementManager) refresh() {
+	if db.DB == nil {
+		return
+	}
+	if err := em.load(); err != nil {
+		utils.LogError("Failed to refresh plans: %v", err)
+	}
+}
+
+// load reads plans from the database
+func (em *EntitlementManager) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	plans := []*models.Plan{}
+	if err := db.DB.Select(&plans, `SELECT id, name, entitlements, created_at, updated_at FROM plans`); err != nil {
+		return fmt.Errorf("failed to query plans: %v", err)
+	}
+
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	em.plans = make(map[string]*models.Plan, len(plans))
+	for _, plan := range plans {
+		em.plans[plan.ID] = plan
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// System event types
+const (
+	EventServerStatus        = "server.status"
+	EventPeerConnected       = "peer.connected"
+	EventPeerDisconnected    = "peer.disconnected"
+	EventPeerInactive        = "peer.inactive"
+	EventPeerArchived        = "peer.archived"
+	EventPeerReactivated     = "peer.reactivated"
+	EventPeerPendingApproval = "peer.pending_approval"
+	EventPeerApproved        = "peer.approved"
+	EventPeerRejected        = "peer.rejected"
+	EventPeerMigrated        = "peer.migrated"
+	EventMeshUpdated         = "mesh.updated"
+	EventWireGuardDrift      = "wireguard.drift"
+	EventError               = "error"
+)
+
+const (
+	// eventHistory is the number of recent events kept for subscribers
+	// resuming after a dropped connection
+	eventHistory = 100
+	// eventBuffer is the number of events queued per subscriber; events
+	// for subscribers that fall further behind are dropped
+	eventBuffer = 64
+)
+
+// Event represents a system event
+type Event struct {
+	ID   int64       `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+	Time time.Time   `json:"time"`
+}
+
+// ServerStatusEvent is the data of a server status change
+type ServerStatusEvent struct {
+	ServerID string `json:"serverId"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Previous string `json:"previous"`
+}
+
+// Reasons a peer was disconnected
+const (
+	DisconnectUser    = "user"    // by the user's own request
+	DisconnectAdmin   = "admin"   // removed by an admin
+	DisconnectExpired = "expired" // dynamic session reached its TTL
+)
+
+// Reasons a peer was moved to another server
+const (
+	MigrateOffline = "offline" // its server went offline
+	MigrateDrain   = "drain"   // its server is drained for maintenance
+)
+
+// PeerEvent is the data of a peer connecting, disconnecting, going idle,
+// being archived, awaiting approval or moving off an offline server
+type PeerEvent struct {
+	UserID       string `json:"userId"`
+	PeerID       string `json:"peerId"`
+	ServerID     string `json:"serverId"`
+	Dynamic      bool   `json:"dynamic"`
+	Device       string `json:"device,omitempty"`
+	Reason       string `json:"reason,omitempty"`       // disconnects and migrations only
+	InactiveDays int    `json:"inactiveDays,omitempty"` // days without a handshake, idle and archived peers only
+	FromServerID string `json:"fromServerId,omitempty"` // server the peer left, migrated peers only
+}
+
+// ErrorEvent is the data of a logged error
+type ErrorEvent struct {
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// EventBus fans system events out to subscribers such as the admin
+// dashboard feed. Publishing never blocks on slow subscribers.
+type EventBus struct {
+	subscribers map[chan *Event]struct{}
+	history     []*Event
+	nextID      int64
+	mutex       sync.Mutex
+}
+
+// NewEventBus creates a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan *Event]struct{}),
+		history:     make([]*Event, 0, eventHistory),
+		nextID:      1,
+		mutex:       sync.Mutex{},
+	}
+}
+
+// Publish sends an event to every subscriber. A nil bus drops the event.
+func (b *EventBus) Publish(eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	event := &Event{
+		ID:   b.nextID,
+		Type: eventType,
+		Data: data,
+		Time: time.Now().UTC(),
+	}
+	b.nextID++
+
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistory {
+		b.history = b.history[len(b.history)-eventHistory:]
+	}
+
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// Subscriber is behind; it will miss this event
+		}
+	}
+}
+
+// Backlog counts the events buffered for subscribers that have not read
+// them yet
+func (b *EventBus) Backlog() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	backlog := 0
+	for subscriber := range b.subscribers {
+		backlog += len(subscriber)
+	}
+	return backlog
+}
+
+// Subscribe starts receiving events. Recent events after lastID are
+// returned for replay, so a client reconnecting with the last ID it saw
+// misses nothing still in the history. The returned function ends the
+// subscription.
+func (b *EventBus) Subscribe(lastID int64) (<-chan *Event, []*Event, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	missed := make([]*Event, 0)
+	if lastID > 0 {
+		for _, event := range b.history {
+			if event.ID > lastID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	subscriber := make(chan *Event, eventBuffer)
+	b.subscribers[subscriber] = struct{}{}
+
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		if _, ok := b.subscribers[subscriber]; ok {
+			delete(b.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+
+	return subscriber, missed, unsubscribe
+}
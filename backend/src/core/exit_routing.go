@@ -0,0 +1,158 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ExitChoices are the exits and internal networks a peer can pick from,
+// with its current picks
+type ExitChoices struct {
+	ExitServer string    `json:"exitServer"` // empty for the peer's own server
+	Routes     []string  `json:"routes"`
+	Exits      []*Server `json:"exits"`   // servers linked to the peer's server
+	Subnets    []string  `json:"subnets"` // networks behind gateways on the peer's server
+}
+
+// PeerExits gets the exits and internal networks a peer can pick from
+func (vm *VPNManager) PeerExits(ctx context.Context, userID, peerID string) (*ExitChoices, error) {
+	vm.mutex.RLock()
+	defer vm.mutex.RUnlock()
+
+	peer, err := vm.peerManager.GetPeer(userID, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("peer not found: %s", peerID)
+	}
+
+	subnets, err := vm.peerManager.RoutableSubnets(peer.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	choices := &ExitChoices{
+		ExitServer: peer.ExitServer,
+		Routes:     peer.Routes,
+		Exits:      make([]*Server, 0),
+		Subnets:    subnets,
+	}
+	if choices.Routes == nil {
+		choices.Routes = []string{}
+	}
+	for _, tunnel := range vm.config.WireGuard.ExitTunnels {
+		if server, err := vm.serverManager.GetServer(tunnel.ServerID); err == nil && server.ID != peer.ServerID {
+			choices.Exits = append(choices.Exits, server)
+		}
+	}
+
+	return choices, nil
+}
+
+// SetPeerExit sets the server a peer's internet traffic exits at, empty for
+// its own server, and the internal networks behind gateways it routes to,
+// and returns the peer with its new config. Exiting at another server is
+// multi-hop and needs a plan that includes it. Pending peers are returned
+// without a config.
+func (vm *VPNManager) SetPeerExit(ctx context.Context, userID, peerID, exitServer string, routes []string) (peer *wireguard.PeerConfig, config string, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.SetPeerExit",
+		attribute.String("peer.id", peerID),
+		attribute.String("exit.server_id", exitServer),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "exit"); err != nil {
+		return nil, "", err
+	}
+
+	// Get peer
+	peer, err = vm.peerManager.GetPeer(userID, peerID)
+	if err != nil {
+		return nil, "", fmt.Errorf("peer not found: %s", peerID)
+	}
+	if peer.Gateway() && (exitServer != "" || len(routes) > 0) {
+		return nil, "", fmt.Errorf("gateways route by their own networks")
+	}
+
+	if exitServer == peer.ServerID {
+		exitServer = ""
+	}
+	if exitServer != "" {
+		if err := vm.checkExit(ctx, userID, peer, exitServer); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if len(routes) > 0 {
+		routes, err = normalizeCIDRs(routes)
+		if err != nil {
+			return nil, "", err
+		}
+		subnets, err := vm.peerManager.RoutableSubnets(peer.ServerID)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, route := range routes {
+			if !containsNetwork(subnets, route) {
+				return nil, "", fmt.Errorf("network %s is not behind a gateway on this server", route)
+			}
+		}
+	}
+
+	peer, err = vm.peerManager.SetExit(ctx, userID, peerID, exitServer, routes)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to save exit: %v", err)
+	}
+
+	utils.LogInfoContext(ctx, "Set exit of peer %s to %q with %d routes", peer.ID, exitServer, len(routes))
+
+	// Log analytics
+	utils.LogAnalytics(userID, "peer_exit_update", fmt.Sprintf("peer=%s exit=%s routes=%d", peer.ID, exitServer, len(routes)))
+
+	if peer.Pending() || peer.Archived() {
+		return peer, "", nil
+	}
+
+	// Generate configuration
+	config, err = vm.renderConfig(peer, "exit")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate configuration: %v", err)
+	}
+
+	return peer, config, nil
+}
+
+// checkExit checks a peer can exit at another server: the user's plan must
+// include multi-hop, the server must be online and linked to the peer's,
+// and the peer must send internet traffic through the tunnel
+func (vm *VPNManager) checkExit(ctx context.Context, userID string, peer *wireguard.PeerConfig, exitServer string) error {
+	if err := vm.entitlements.CheckFeature(ctx, userID, models.FeatureMultiHop); err != nil {
+		return err
+	}
+
+	server, err := vm.serverManager.GetServer(exitServer)
+	if err != nil {
+		return fmt.Errorf("server not found: %s", exitServer)
+	}
+	if server.Status != "online" {
+		return fmt.Errorf("server %s is %s", server.Name, server.Status)
+	}
+	if _, ok := wireguard.ExitTunnel(vm.config, exitServer); !ok {
+		return fmt.Errorf("server %s is not linked to this server", server.Name)
+	}
+	if peer.Routing == RoutingModeSubnets {
+		return fmt.Errorf("an exit needs a routing mode that sends internet traffic through the tunnel")
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Funnel stages, in order
+const (
+	FunnelStageRegistered   = "registered"
+	FunnelStageVerified     = "verified"
+	FunnelStageFirstConnect = "first_connect"
+	FunnelStageSubscribed   = "subscribed"
+)
+
+// FunnelStages lists the conversion funnel stages in order
+var FunnelStages = []string{
+	FunnelStageRegistered,
+	FunnelStageVerified,
+	FunnelStageFirstConnect,
+	FunnelStageSubscribed,
+}
+
+// Default acquisition segments
+const (
+	defaultFunnelChannel  = "direct"
+	defaultFunnelPlatform = "unknown"
+)
+
+// funnelUser represents the funnel progress of a single user
+type funnelUser struct {
+	Channel  string
+	Platform string
+	Stages   map[string]time.Time
+}
+
+// FunnelSegment represents funnel counts for one acquisition segment
+type FunnelSegment struct {
+	Channel    string             `json:"channel,omitempty"`
+	Platform   string             `json:"platform,omitempty"`
+	Counts     map[string]int     `json:"counts"`
+	Conversion map[string]float64 `json:"conversion"` // share of registered users reaching each stage
+}
+
+// FunnelReport represents the conversion funnel of users registered in a period
+type FunnelReport struct {
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Stages   []string         `json:"stages"`
+	Total    *FunnelSegment   `json:"total"`
+	Segments []*FunnelSegment `json:"segments"`
+}
+
+// FunnelTracker derives trial-to-paid funnel events from user activity.
+// Each stage is recorded once per user, tagged with the acquisition channel
+// and platform captured at registration.
+type FunnelTracker struct {
+	config *config.Config
+	users  map[string]*funnelUser
+	mutex  sync.RWMutex
+}
+
+// NewFunnelTracker creates a new funnel tracker
+func NewFunnelTracker(cfg *config.Config) *FunnelTracker {
+	ft := &FunnelTracker{
+		config: cfg,
+		users:  make(map[string]*funnelUser),
+		mutex:  sync.RWMutex{},
+	}
+
+	if err := ft.load(); err != nil {
+		utils.LogError("Failed to load funnel events: %v", err)
+	}
+
+	return ft
+}
+
+// Register records the registered stage with the user's acquisition segment
+func (ft *FunnelTracker) Register(userID, channel, platform string) {
+	if channel == "" {
+		channel = defaultFunnelChannel
+	}
+	if platform == "" {
+		platform = defaultFunnelPlatform
+	}
+
+	key := funnelKey(userID)
+
+	ft.mutex.Lock()
+	if _, ok := ft.users[key]; !ok {
+		ft.users[key] = &funnelUser{Stages: make(map[string]time.Time)}
+	}
+	ft.users[key].Channel = channel
+	ft.users[key].Platform = platform
+	ft.mutex.Unlock()
+
+	ft.Record(userID, FunnelStageRegistered)
+}
+
+// Record records a funnel stage for a user. Only the first occurrence of
+// each stage is kept, so callers can record on every matching action.
+func (ft *FunnelTracker) Record(userID, stage string) {
+	key := funnelKey(userID)
+	now := time.Now()
+
+	ft.mutex.Lock()
+	user, ok := ft.users[key]
+	if !ok {
+		user = &funnelUser{
+			Channel:  defaultFunnelChannel,
+			Platform: defaultFunnelPlatform,
+			Stages:   make(map[string]time.Time),
+		}
+		ft.users[key] = user
+	}
+	if _, recorded := user.Stages[stage]; recorded {
+		ft.mutex.Unlock()
+		return
+	}
+	user.Stages[stage] = now
+	channel, platform := user.Channel, user.Platform
+	ft.mutex.Unlock()
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`INSERT INTO funnel_events (user_key, stage, channel, platform, occurred_at) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (user_key, stage) DO NOTHING`,
+			key, stage, channel, platform, now,
+		)
+		if err != nil {
+			utils.LogError("Failed to save funnel event: %v", err)
+		}
+	}
+
+	// Emit the derived event into the analytics pipeline
+	utils.LogAnalytics(userID, "funnel_"+stage, fmt.Sprintf("channel=%s platform=%s", channel, platform))
+}
+
+// Report builds the funnel for users registered between from and to,
+// segmented by channel, platform or both
+func (ft *FunnelTracker) Report(from, to time.Time, segmentBy string) *FunnelReport {
+	if db.DB != nil {
+		if err := ft.load(); err != nil {
+			utils.LogError("Failed to refresh funnel events: %v", err)
+		}
+	}
+
+	ft.mutex.RLock()
+	defer ft.mutex.RUnlock()
+
+	report := &FunnelReport{
+		From:   from,
+		To:     to,
+		Stages: FunnelStages,
+		Total:  newFunnelSegment("", ""),
+	}
+
+	segments := make(map[string]*FunnelSegment)
+	for _, user := range ft.users {
+		registered, ok := user.Stages[FunnelStageRegistered]
+		if !ok || registered.Before(from) || !registered.Before(to) {
+			continue
+		}
+
+		channel, platform := user.Channel, user.Platform
+		switch segmentBy {
+		case "channel":
+			platform = ""
+		case "platform":
+			channel = ""
+		}
+
+		segmentKey := channel + "|" + platform
+		segment, ok := segments[segmentKey]
+		if !ok {
+			segment = newFunnelSegment(channel, platform)
+			segments[segmentKey] = segment
+		}
+
+		for _, stage := range FunnelStages {
+			if _, ok := user.Stages[stage]; ok {
+				segment.Counts[stage]++
+				report.Total.Counts[stage]++
+			}
+		}
+	}
+
+	report.Segments = make([]*FunnelSegment, 0, len(segments))
+	for _, segment := range segments {
+		segment.computeConversion()
+		report.Segments = append(report.Segments, segment)
+	}
+	report.Total.computeConversion()
+
+	// Largest segments first
+	sort.Slice(report.Segments, func(i, j int) bool {
+		return report.Segments[i].Counts[FunnelStageRegistered] > report.Segments[j].Counts[FunnelStageRegistered]
+	})
+
+	return report
+}
+
+// newFunnelSegment creates an empty funnel segment
+func newFunnelSegment(channel, platform string) *FunnelSegment {
+	return &FunnelSegment{
+		Channel:    channel,
+		Platform:   platform,
+		Counts:     make(map[string]int),
+		Conversion: make(map[string]float64),
+	}
+}
+
+// computeConversion computes the share of registered users reaching each stage
+func (s *FunnelSegment) computeConversion() {
+	registered := s.Counts[FunnelStageRegistered]
+	for _, stage := range FunnelStages {
+		if registered > 0 {
+			s.Conversion[stage] = float64(s.Counts[stage]) / float64(registered)
+		} else {
+			s.Conversion[stage] = 0
+		}
+	}
+}
+
+// funnelKey gets the key a user's funnel events are stored under. Users who
+// opted out of telemetry are only tracked by their anonymous ID.
+func funnelKey(userID string) string {
+	if !utils.TelemetryEnabled(userID) {
+		return utils.RedactUserID(userID)
+	}
+	return userID
+}
+
+// load reads funnel events from the database
+func (ft *FunnelTracker) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	rows := []struct {
+		UserKey    string    `db:"user_key"`
+		Stage      string    `db:"stage"`
+		Channel    string    `db:"channel"`
+		Platform   string    `db:"platform"`
+		OccurredAt time.Time `db:"occurred_at"`
+	}{}
+	if err := db.DB.Select(&rows, `SELECT user_key, stage, channel, platform, occurred_at FROM funnel_events`); err != nil {
+		return fmt.Errorf("failed to query funnel events: %v", err)
+	}
+
+	users := make(map[string]*funnelUser)
+	for _, row := range rows {
+		user, ok := users[row.UserKey]
+		if !ok {
+			user = &funnelUser{Stages: make(map[string]time.Time)}
+			users[row.UserKey] = user
+		}
+		user.Channel = row.Channel
+		user.Platform = row.Platform
+		user.Stages[row.Stage] = row.OccurredAt
+	}
+
+	ft.mutex.Lock()
+	ft.users = users
+	ft.mutex.Unlock()
+
+	return nil
+}
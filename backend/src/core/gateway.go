@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// checkGateway checks a gateway device advertises networks that can be
+// routed to it. Gateways are routers that stay connected, so they need a
+// static peer.
+func (vm *VPNManager) checkGateway(deviceType string, opts wireguard.PeerOptions, dynamic bool) error {
+	gateway := deviceType == wireguard.DeviceTypeGateway
+	switch {
+	case !gateway && len(opts.RoutedSubnets) > 0:
+		return fmt.Errorf("routed subnets need the %s device type", wireguard.DeviceTypeGateway)
+	case !gateway:
+		return nil
+	case len(opts.RoutedSubnets) == 0:
+		return fmt.Errorf("gateways need the networks behind them in routedSubnets")
+	case dynamic:
+		return fmt.Errorf("gateways cannot connect with a dynamic session")
+	}
+
+	return vm.peerManager.CheckRoutedSubnets(opts.RoutedSubnets)
+}
+
+// GatewaySetup gets the router config of a gateway device together with the
+// server side peer entry and routes of the networks behind it
+func (vm *VPNManager) GatewaySetup(ctx context.Context, userID, peerID string) (*wireguard.GatewaySetup, error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	peer, config, err := vm.peerConfig(ctx, userID, peerID, "gateway")
+	if err != nil {
+		return nil, err
+	}
+
+	setup, err := wireguard.GatewayServerSide(vm.config, peer)
+	if err != nil {
+		return nil, err
+	}
+	setup.RouterConfig = config
+
+	return setup, nil
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// selectInterface puts a new device on the server interface of its plan,
+// or on the obfuscated interface if the device is obfuscated
+func (vm *VPNManager) selectInterface(ctx context.Context, userID string, opts *wireguard.PeerOptions) {
+	if len(vm.config.WireGuard.Interfaces) == 0 {
+		return
+	}
+
+	planID := ""
+	if plan := vm.entitlements.GetUserPlan(ctx, userID); plan != nil {
+		planID = plan.ID
+	}
+	opts.Interface = wireguard.SelectInterface(vm.config, planID, opts.Obfuscated)
+}
+
+// Interfaces gets the state of the WireGuard interfaces of the server
+func (vm *VPNManager) Interfaces(ctx context.Context) ([]wireguard.InterfaceStatus, error) {
+	return vm.peerManager.InterfaceStatuses(ctx)
+}
+
+// ApplyInterface applies the peers of a WireGuard interface again
+func (vm *VPNManager) ApplyInterface(ctx context.Context, actor, name string) error {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Connect)
+	defer cancel()
+
+	if err := vm.peerManager.ApplyInterface(ctx, name); err != nil {
+		if ctxErr := contextError(ctx, "apply"); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("failed to apply interface %s: %w", name, err)
+	}
+
+	utils.LogInfo("WireGuard interface %s applied by %s", name, actor)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "interface_applied", fmt.Sprintf("interface=%s", name))
+
+	return nil
+}
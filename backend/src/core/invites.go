@@ -0,0 +1,145 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/email"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// inviteTTL is how long a set-password invite stays valid
+const inviteTTL = 7 * 24 * time.Hour
+
+// Invite represents a set-password invite for an account
+type Invite struct {
+	UserID    string    `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Used      bool      `json:"used"`
+}
+
+// InviteManager issues and redeems set-password invites. Only token hashes
+// are kept so a leaked store cannot be used to take over accounts.
+type InviteManager struct {
+	config  *config.Config
+	invites map[string]*Invite
+	mailer  *email.Mailer
+	mutex   sync.Mutex
+}
+
+// NewInviteManager creates a new invite manager
+func NewInviteManager(cfg *config.Config) *InviteManager {
+	return &InviteManager{
+		config:  cfg,
+		invites: make(map[string]*Invite),
+		mutex:   sync.Mutex{},
+	}
+}
+
+// CreateInvite issues a new invite token for a user
+func (im *InviteManager) CreateInvite(userID string) (string, *Invite, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, fmt.Errorf("failed to generate invite token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	hash := hashToken(token)
+
+	invite := &Invite{
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(inviteTTL),
+	}
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`INSERT INTO user_invites (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+			hash, userID, invite.ExpiresAt,
+		)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to save invite: %v", err)
+		}
+	}
+
+	im.mutex.Lock()
+	im.invites[hash] = invite
+	im.mutex.Unlock()
+
+	// Log analytics
+	utils.LogAnalytics(userID, "user_invite_created", "")
+
+	return token, invite, nil
+}
+
+// Redeem marks an invite as used and returns the invited user's ID
+func (im *InviteManager) Redeem(token string) (string, error) {
+	hash := hashToken(token)
+
+	im.mutex.Lock()
+	defer im.mutex.Unlock()
+
+	invite, ok := im.invites[hash]
+	if !ok && db.DB != nil {
+		var row struct {
+			UserID    string     `db:"user_id"`
+			ExpiresAt time.Time  `db:"expires_at"`
+			UsedAt    *time.Time `db:"used_at"`
+		}
+		if err := db.DB.Get(&row, `SELECT user_id, expires_at, used_at FROM user_invites WHERE token_hash = $1`, hash); err == nil {
+			invite = &Invite{UserID: row.UserID, ExpiresAt: row.ExpiresAt, Used: row.UsedAt != nil}
+			ok = true
+		}
+	}
+	if !ok {
+		return "", fmt.Errorf("invite not found")
+	}
+	if invite.Used {
+		return "", fmt.Errorf("invite has already been used")
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		return "", fmt.Errorf("invite has expired")
+	}
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`UPDATE user_invites SET used_at = $1 WHERE token_hash = $2`, time.Now(), hash); err != nil {
+			return "", fmt.Errorf("failed to redeem invite: %v", err)
+		}
+	}
+
+	invite.Used = true
+	im.invites[hash] = invite
+
+	// Log analytics
+	utils.LogAnalytics(invite.UserID, "user_invite_redeemed", "")
+
+	return invite.UserID, nil
+}
+
+// InviteURL gets the set-password link of an invite token, for invites an
+// admin hands out instead of emailing
+func (im *InviteManager) InviteURL(token string) string {
+	return strings.TrimSuffix(im.config.Email.BaseURL, "/") + "/invite/accept?token=" + token
+}
+
+// SendInvite emails a set-password link to a user
+func (im *InviteManager) SendInvite(address, token string) error {
+	if im.mailer == nil {
+		return fmt.Errorf("email delivery is not configured")
+	}
+
+	data := map[string]interface{}{
+		"URL": im.InviteURL(token),
+	}
+	if err := im.mailer.Send(context.Background(), address, email.TemplateInvite, data); err != nil {
+		return err
+	}
+
+	utils.LogInfo("Sent set-password invite to %s", address)
+	return nil
+}
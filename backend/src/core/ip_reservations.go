@@ -0,0 +1,276 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// IPReservation represents a dedicated tunnel address of a user. The
+// user's devices get the address whenever it is free across reconnects,
+// and no other user's device is given it. With a public address, traffic
+// from the tunnel address leaves the server from that address.
+type IPReservation struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"userId" db:"user_id"`
+	TunnelIP  string    `json:"tunnelIp" db:"tunnel_ip"`
+	PublicIP  string    `json:"publicIp,omitempty" db:"public_ip"`
+	ServerID  string    `json:"serverId,omitempty" db:"server_id"` // server the public address is on
+	Note      string    `json:"note,omitempty" db:"note"`
+	CreatedBy string    `json:"createdBy" db:"created_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// IPReservationManager keeps the IPAM reservation records of dedicated
+// addresses
+type IPReservationManager struct {
+	config       *config.Config
+	reservations map[string]*IPReservation // by ID
+	mutex        sync.RWMutex
+}
+
+// NewIPReservationManager creates a new IP reservation manager
+func NewIPReservationManager(cfg *config.Config) *IPReservationManager {
+	return &IPReservationManager{
+		config:       cfg,
+		reservations: make(map[string]*IPReservation),
+		mutex:        sync.RWMutex{},
+	}
+}
+
+// List gets the reservations, of a single user if userID is set, by
+// tunnel address
+func (rm *IPReservationManager) List(ctx context.Context, userID string) ([]*IPReservation, error) {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	return rm.list(ctx, userID)
+}
+
+// list gets the reservations. The caller must hold the lock.
+func (rm *IPReservationManager) list(ctx context.Context, userID string) ([]*IPReservation, error) {
+	reservations := make([]*IPReservation, 0)
+
+	if db.DB != nil {
+		query := `SELECT id, user_id, tunnel_ip, public_ip, server_id, note, created_by, created_at FROM ip_reservations`
+		args := []interface{}{}
+		if userID != "" {
+			query += ` WHERE user_id = $1`
+			args = append(args, userID)
+		}
+		if err := db.DB.SelectContext(ctx, &reservations, query, args...); err != nil {
+			return nil, fmt.Errorf("failed to list IP reservations: %v", err)
+		}
+	} else {
+		for _, reservation := range rm.reservations {
+			if userID == "" || reservation.UserID == userID {
+				reservations = append(reservations, reservation)
+			}
+		}
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		return ipLess(reservations[i].TunnelIP, reservations[j].TunnelIP)
+	})
+
+	return reservations, nil
+}
+
+// add saves a reservation, rejecting addresses that are already reserved
+func (rm *IPReservationManager) add(ctx context.Context, reservation *IPReservation) error {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	existing, err := rm.list(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, other := range existing {
+		if other.TunnelIP == reservation.TunnelIP {
+			return fmt.Errorf("address %s is already reserved", reservation.TunnelIP)
+		}
+		if reservation.PublicIP != "" && other.PublicIP == reservation.PublicIP {
+			return fmt.Errorf("public address %s is already reserved", reservation.PublicIP)
+		}
+	}
+
+	if db.DB != nil {
+		_, err := db.DB.ExecContext(ctx,
+			`INSERT INTO ip_reservations (id, user_id, tunnel_ip, public_ip, server_id, note, created_by, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			reservation.ID, reservation.UserID, reservation.TunnelIP, reservation.PublicIP, reservation.ServerID, reservation.Note, reservation.CreatedBy, reservation.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save IP reservation: %v", err)
+		}
+		return nil
+	}
+
+	rm.reservations[reservation.ID] = reservation
+	return nil
+}
+
+// remove deletes a reservation
+func (rm *IPReservationManager) remove(ctx context.Context, id string) (*IPReservation, error) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	if db.DB != nil {
+		var reservation IPReservation
+		err := db.DB.GetContext(ctx, &reservation,
+			`DELETE FROM ip_reservations WHERE id = $1 RETURNING id, user_id, tunnel_ip, public_ip, server_id, note, created_by, created_at`,
+			id,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("IP reservation not found: %s", id)
+		}
+		return &reservation, nil
+	}
+
+	reservation, ok := rm.reservations[id]
+	if !ok {
+		return nil, fmt.Errorf("IP reservation not found: %s", id)
+	}
+	delete(rm.reservations, id)
+	return reservation, nil
+}
+
+// addressReservations gets the reservations for address allocation
+func (rm *IPReservationManager) addressReservations() ([]wireguard.AddressReservation, error) {
+	reservations, err := rm.List(context.Background(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]wireguard.AddressReservation, len(reservations))
+	for i, reservation := range reservations {
+		addresses[i] = wireguard.AddressReservation{
+			UserID:   reservation.UserID,
+			IP:       reservation.TunnelIP,
+			PublicIP: reservation.PublicIP,
+			ServerID: reservation.ServerID,
+		}
+	}
+	return addresses, nil
+}
+
+// IPReservations gets the reservations of dedicated addresses
+func (vm *VPNManager) IPReservations() *IPReservationManager {
+	return vm.reservations
+}
+
+// ReserveIP reserves a dedicated tunnel address to a user, the lowest free
+// address if none is given, and optionally a public address on a server.
+// A device of the user already on the address keeps it; other devices get
+// it the next time they connect while it is free. The user's plan must
+// include dedicated IPs.
+func (vm *VPNManager) ReserveIP(ctx context.Context, actor string, reservation IPReservation) (*IPReservation, error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	// Hold off connects so the address is not handed out meanwhile
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	if vm.userManager != nil {
+		if _, err := vm.userManager.GetUser(reservation.UserID); err != nil {
+			return nil, fmt.Errorf("user not found: %s", reservation.UserID)
+		}
+	}
+
+	// Dedicated addresses are a plan feature
+	if err := vm.entitlements.CheckFeature(ctx, reservation.UserID, models.FeatureDedicatedIP); err != nil {
+		return nil, err
+	}
+
+	if reservation.PublicIP != "" {
+		ip := net.ParseIP(reservation.PublicIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid public address: %s", reservation.PublicIP)
+		}
+		reservation.PublicIP = ip.String()
+		if _, err := vm.serverManager.GetServer(reservation.ServerID); err != nil {
+			return nil, fmt.Errorf("server not found: %s", reservation.ServerID)
+		}
+	}
+
+	if reservation.TunnelIP == "" {
+		ip, err := vm.peerManager.FreeIP()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a free address: %v", err)
+		}
+		reservation.TunnelIP = ip
+	}
+	if ip := net.ParseIP(reservation.TunnelIP); ip != nil {
+		reservation.TunnelIP = ip.String()
+	}
+	if err := vm.peerManager.CheckReservation(reservation.UserID, reservation.TunnelIP); err != nil {
+		return nil, err
+	}
+
+	reservation.ID = utils.GenerateUUID()
+	reservation.Note = strings.TrimSpace(reservation.Note)
+	reservation.CreatedBy = actor
+	reservation.CreatedAt = time.Now()
+	if err := vm.reservations.add(ctx, &reservation); err != nil {
+		return nil, err
+	}
+
+	// Route a device already on the address out from its public address
+	if reservation.PublicIP != "" {
+		if err := vm.peerManager.ApplyReservations(ctx); err != nil {
+			utils.LogErrorContext(ctx, "Failed to apply egress of reservation %s: %v", reservation.ID, err)
+		}
+	}
+
+	utils.LogInfoContext(ctx, "Reserved %s to user %s", reservation.TunnelIP, reservation.UserID)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "ip_reserved", fmt.Sprintf("user=%s ip=%s public_ip=%s", reservation.UserID, reservation.TunnelIP, reservation.PublicIP))
+
+	return &reservation, nil
+}
+
+// ReleaseIP deletes a reservation. A device on the address keeps it until
+// it reconnects, but loses its public address right away.
+func (vm *VPNManager) ReleaseIP(ctx context.Context, actor, id string) error {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	reservation, err := vm.reservations.remove(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if reservation.PublicIP != "" {
+		if err := vm.peerManager.ApplyReservations(ctx); err != nil {
+			utils.LogErrorContext(ctx, "Failed to remove egress of reservation %s: %v", reservation.ID, err)
+		}
+	}
+
+	// Log analytics
+	utils.LogAnalytics(actor, "ip_released", fmt.Sprintf("user=%s ip=%s", reservation.UserID, reservation.TunnelIP))
+
+	return nil
+}
+
+// ipLess orders addresses numerically, falling back to string order
+func ipLess(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a < b
+	}
+	return string(ipA.To16()) < string(ipB.To16())
+}
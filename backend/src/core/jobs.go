@@ -0,0 +1,295 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Job types
+const (
+	JobTypeUserImport         = "user_import"
+	JobTypeCertificateRenewal = "certificate_renewal"
+)
+
+// Job statuses
+const (
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+const (
+	// jobRetention is how long finished jobs can still be looked up
+	jobRetention = 24 * time.Hour
+	// maxJobErrors limits the per-item errors kept for a job
+	maxJobErrors = 1000
+)
+
+// JobItemError represents an item a job failed to process
+type JobItemError struct {
+	Item  string `json:"item"`
+	Error string `json:"error"`
+}
+
+// Job represents a long-running admin operation run in the background
+type Job struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	Status     string         `json:"status"`
+	CreatedBy  string         `json:"createdBy,omitempty"`
+	Total      int            `json:"total"`     // items to process, 0 while unknown
+	Completed  int            `json:"completed"` // items processed, including failed ones
+	Failed     int            `json:"failed"`
+	Progress   int            `json:"progress"` // percent
+	Errors     []JobItemError `json:"errors,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Result     interface{}    `json:"result,omitempty"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	FinishedAt *time.Time     `json:"finishedAt,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// Finished checks whether a job is no longer running
+func (j *Job) Finished() bool {
+	return j.Status != JobStatusRunning
+}
+
+// JobFilter selects jobs to list; empty fields match any job
+type JobFilter struct {
+	Type   string
+	Status string
+}
+
+// JobFunc runs a job. It should stop when ctx is cancelled and report
+// progress through the reporter. The result is kept with the job.
+type JobFunc func(ctx context.Context, reporter *JobReporter) (interface{}, error)
+
+// JobManager runs admin operations in the background and tracks their
+// progress so clients can poll them, instead of holding requests open.
+// Jobs are kept in memory for a day after they finish.
+type JobManager struct {
+	config *config.Config
+	jobs   map[string]*Job
+	mutex  sync.RWMutex
+}
+
+// NewJobManager creates a new job manager
+func NewJobManager(cfg *config.Config) *JobManager {
+	return &JobManager{
+		config: cfg,
+		jobs:   make(map[string]*Job),
+		mutex:  sync.RWMutex{},
+	}
+}
+
+// Start runs a job in the background and returns it
+func (jm *JobManager) Start(jobType, createdBy string, run JobFunc) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := &Job{
+		ID:        utils.GenerateUUID(),
+		Type:      jobType,
+		Status:    JobStatusRunning,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+		cancel:    cancel,
+	}
+
+	jm.mutex.Lock()
+	jm.jobs[job.ID] = job
+	snapshot := job.snapshot()
+	jm.mutex.Unlock()
+
+	go jm.run(ctx, job, run)
+
+	// Log analytics
+	utils.LogAnalytics(createdBy, "job_start", fmt.Sprintf("job_id=%s type=%s", job.ID, jobType))
+
+	return snapshot
+}
+
+// run runs a job and records its outcome
+func (jm *JobManager) run(ctx context.Context, job *Job, run JobFunc) {
+	defer job.cancel()
+
+	result, err := run(ctx, &JobReporter{manager: jm, job: job})
+
+	jm.mutex.Lock()
+	defer jm.mutex.Unlock()
+
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Result = result
+	switch {
+	case ctx.Err() != nil:
+		job.Status = JobStatusCancelled
+	case err != nil:
+		job.Status = JobStatusFailed
+		job.Error = err.Error()
+		utils.LogError("Job %s (%s) failed: %v", job.ID, job.Type, err)
+	default:
+		job.Status = JobStatusSucceeded
+		job.Progress = 100
+	}
+}
+
+// GetJob gets a job by ID
+func (jm *JobManager) GetJob(id string) (*Job, error) {
+	jm.mutex.RLock()
+	defer jm.mutex.RUnlock()
+
+	job, ok := jm.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("job not found: %s", id)
+	}
+
+	return job.snapshot(), nil
+}
+
+// ListJobs lists the jobs matching a filter, newest first
+func (jm *JobManager) ListJobs(filter JobFilter) []*Job {
+	jm.mutex.RLock()
+	jobs := make([]*Job, 0, len(jm.jobs))
+	for _, job := range jm.jobs {
+		if filter.Type != "" && job.Type != filter.Type {
+			continue
+		}
+		if filter.Status != "" && job.Status != filter.Status {
+			continue
+		}
+
+		// Lists leave out per-item errors and results, which can be large
+		snapshot := job.snapshot()
+		snapshot.Errors = nil
+		snapshot.Result = nil
+		jobs = append(jobs, snapshot)
+	}
+	jm.mutex.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+
+	return jobs
+}
+
+// CancelJob asks a running job to stop. Items already processed are not
+// rolled back.
+func (jm *JobManager) CancelJob(id, actor string) error {
+	jm.mutex.RLock()
+	job, ok := jm.jobs[id]
+	var finished bool
+	if ok {
+		finished = job.Finished()
+	}
+	jm.mutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("job not found: %s", id)
+	}
+	if finished {
+		return fmt.Errorf("job is not running: %s", id)
+	}
+
+	job.cancel()
+
+	// Log analytics
+	utils.LogAnalytics(actor, "job_cancel", fmt.Sprintf("job_id=%s type=%s", id, job.Type))
+
+	return nil
+}
+
+// RunCleanup periodically removes jobs that finished over a day ago
+func (jm *JobManager) RunCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		jm.purgeFinished()
+	}
+}
+
+// purgeFinished removes jobs past their retention
+func (jm *JobManager) purgeFinished() {
+	cutoff := time.Now().Add(-jobRetention)
+
+	jm.mutex.Lock()
+	for id, job := range jm.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(jm.jobs, id)
+		}
+	}
+	jm.mutex.Unlock()
+}
+
+// snapshot copies a job so it can be returned while it keeps running. The
+// caller must hold the manager's lock.
+func (j *Job) snapshot() *Job {
+	snapshot := *j
+	snapshot.Errors = append([]JobItemError(nil), j.Errors...)
+	snapshot.cancel = nil
+	return &snapshot
+}
+
+// JobReporter reports the progress of a running job. A nil reporter
+// discards reports, for operations run outside a job.
+type JobReporter struct {
+	manager *JobManager
+	job     *Job
+}
+
+// SetTotal sets the number of items the job will process
+func (r *JobReporter) SetTotal(total int) {
+	if r == nil {
+		return
+	}
+
+	r.manager.mutex.Lock()
+	r.job.Total = total
+	r.updateProgress()
+	r.manager.mutex.Unlock()
+}
+
+// ItemDone records a successfully processed item
+func (r *JobReporter) ItemDone() {
+	if r == nil {
+		return
+	}
+
+	r.manager.mutex.Lock()
+	r.job.Completed++
+	r.updateProgress()
+	r.manager.mutex.Unlock()
+}
+
+// ItemFailed records an item that could not be processed
+func (r *JobReporter) ItemFailed(item string, err error) {
+	if r == nil {
+		return
+	}
+
+	r.manager.mutex.Lock()
+	r.job.Completed++
+	r.job.Failed++
+	if len(r.job.Errors) < maxJobErrors {
+		r.job.Errors = append(r.job.Errors, JobItemError{Item: item, Error: err.Error()})
+	}
+	r.updateProgress()
+	r.manager.mutex.Unlock()
+}
+
+// updateProgress recomputes the progress percentage. The caller must hold
+// the manager's lock.
+func (r *JobReporter) updateProgress() {
+	if r.job.Total > 0 {
+		r.job.Progress = r.job.Completed * 100 / r.job.Total
+	}
+}
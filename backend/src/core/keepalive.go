@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// validateKeepalive checks a keepalive interval chosen for a device, where
+// zero turns keepalives off
+func validateKeepalive(seconds int) error {
+	if seconds != 0 && (seconds < wireguard.MinKeepalive || seconds > wireguard.MaxKeepalive) {
+		return fmt.Errorf("keepalive must be 0 (off) or between %d and %d seconds", wireguard.MinKeepalive, wireguard.MaxKeepalive)
+	}
+	return nil
+}
+
+// keepaliveOption converts a chosen keepalive interval to its stored form
+func keepaliveOption(seconds int) int {
+	if seconds == 0 {
+		return wireguard.KeepaliveOff
+	}
+	return seconds
+}
+
+// SetPeerKeepalive sets the persistent keepalive of a peer and returns the
+// peer with its new config. A nil keepalive returns the peer to the server
+// default and zero turns keepalives off, e.g. for desktops behind NATs
+// that keep mappings alive on their own. Pending peers are returned
+// without a config.
+func (vm *VPNManager) SetPeerKeepalive(ctx context.Context, userID, peerID string, keepalive *int) (peer *wireguard.PeerConfig, config string, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.SetPeerKeepalive", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	option := 0
+	if keepalive != nil {
+		if err := validateKeepalive(*keepalive); err != nil {
+			return nil, "", err
+		}
+		option = keepaliveOption(*keepalive)
+	}
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "keepalive"); err != nil {
+		return nil, "", err
+	}
+
+	// Get peer
+	if _, err := vm.peerManager.GetPeer(userID, peerID); err != nil {
+		return nil, "", fmt.Errorf("peer not found: %s", peerID)
+	}
+
+	peer, err = vm.peerManager.SetKeepalive(userID, peerID, option)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to save keepalive: %v", err)
+	}
+
+	effective := wireguard.PeerKeepalive(vm.config, peer)
+	utils.LogInfoContext(ctx, "Set keepalive of peer %s to %d seconds", peer.ID, effective)
+
+	// Log analytics
+	utils.LogAnalytics(userID, "peer_keepalive_update", fmt.Sprintf("peer=%s keepalive=%d", peer.ID, effective))
+
+	if peer.Pending() || peer.Archived() {
+		return peer, "", nil
+	}
+
+	// Generate configuration
+	config, err = vm.renderConfig(peer, "keepalive")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate configuration: %v", err)
+	}
+
+	return peer, config, nil
+}
+
+// PeerKeepalive gets the persistent keepalive rendered into a peer's
+// config in seconds, or zero when it is off
+func (vm *VPNManager) PeerKeepalive(peer *wireguard.PeerConfig) int {
+	return wireguard.PeerKeepalive(vm.config, peer)
+}
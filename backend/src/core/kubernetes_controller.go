@@ -0,0 +1,344 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/discovery"
+	"github.com/vpn-service/backend/src/kubernetes"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// kubernetesFinalizer keeps VPNPeers until their peer is removed
+const kubernetesFinalizer = "vpn-service.io/peer"
+
+// kubernetesActor is who changes made for custom resources are logged as
+const kubernetesActor = "kubernetes"
+
+// KubernetesController reconciles VPNServer and VPNPeer custom resources.
+// Servers mirror the VPNServers, whose status reports the server's status
+// and load; each VPNPeer gets a peer on its server, with the client config
+// kept in a Secret next to it, and the peer is removed with the resource.
+type KubernetesController struct {
+	config  *config.Config
+	vpn     *VPNManager
+	client  *kubernetes.Client
+	trigger chan struct{}
+}
+
+// NewKubernetesController creates a new Kubernetes controller
+func NewKubernetesController(cfg *config.Config, vm *VPNManager, client *kubernetes.Client) *KubernetesController {
+	return &KubernetesController{
+		config:  cfg,
+		vpn:     vm,
+		client:  client,
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// Run watches the custom resources and reconciles them on every change and
+// every kubernetes.resync seconds
+func (kc *KubernetesController) Run() {
+	ctx := context.Background()
+	go kc.watch(ctx, kubernetes.VPNServers)
+	go kc.watch(ctx, kubernetes.VPNPeers)
+
+	resync := time.Duration(kc.config.Kubernetes.Resync) * time.Second
+	if resync <= 0 {
+		resync = 30 * time.Second
+	}
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+
+	for {
+		kc.reconcile(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-kc.trigger:
+		}
+	}
+}
+
+// notify requests a reconciliation, merging requests made meanwhile
+func (kc *KubernetesController) notify() {
+	select {
+	case kc.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// watch requests reconciliations on changes of a kind of resource, starting
+// over from a fresh list whenever the watch ends
+func (kc *KubernetesController) watch(ctx context.Context, resource string) {
+	for {
+		var items []json.RawMessage
+		version, err := kc.client.List(ctx, resource, &items)
+		if err == nil {
+			err = kc.client.Watch(ctx, resource, version, kc.notify)
+		}
+		if err != nil && err != kubernetes.ErrExpired && !errors.Is(err, io.EOF) {
+			utils.LogWarning("Watch of %s failed: %v", resource, err)
+			time.Sleep(5 * time.Second)
+		}
+
+		// Catch up on changes made while not watching
+		kc.notify()
+	}
+}
+
+// reconcile brings servers and peers in line with the custom resources
+func (kc *KubernetesController) reconcile(ctx context.Context) {
+	var servers []kubernetes.VPNServer
+	if _, err := kc.client.List(ctx, kubernetes.VPNServers, &servers); err != nil {
+		utils.LogError("Failed to reconcile VPN servers: %v", err)
+		return
+	}
+	serverIDs := kc.reconcileServers(ctx, servers)
+
+	var peers []kubernetes.VPNPeer
+	if _, err := kc.client.List(ctx, kubernetes.VPNPeers, &peers); err != nil {
+		utils.LogError("Failed to reconcile VPN peers: %v", err)
+		return
+	}
+	for i := range peers {
+		kc.reconcilePeer(ctx, &peers[i], serverIDs)
+	}
+}
+
+// reconcileServers applies the VPNServers to the server inventory like
+// service discovery does, and writes the servers' state back to their
+// status. Server IDs are the resources' UIDs; it returns them by
+// namespace/name.
+func (kc *KubernetesController) reconcileServers(ctx context.Context, servers []kubernetes.VPNServer) map[string]string {
+	nodes := make([]discovery.Node, 0, len(servers))
+	ids := make(map[string]string, len(servers))
+	for _, resource := range servers {
+		if resource.Metadata.DeletionTimestamp != nil {
+			continue
+		}
+
+		// Servers are offline until their agent reports a heartbeat
+		status := "offline"
+		if resource.Spec.Maintenance {
+			status = "maintenance"
+		}
+		name := resource.Spec.DisplayName
+		if name == "" {
+			name = resource.Metadata.Name
+		}
+		ip, _, _ := net.SplitHostPort(resource.Spec.Endpoint)
+		if net.ParseIP(ip) == nil {
+			ip = ""
+		}
+
+		nodes = append(nodes, discovery.Node{
+			ID:             resource.Metadata.UID,
+			Name:           name,
+			IP:             ip,
+			Endpoint:       resource.Spec.Endpoint,
+			PublicKey:      resource.Spec.PublicKey,
+			Country:        resource.Spec.Country,
+			City:           resource.Spec.City,
+			Capacity:       resource.Spec.Capacity,
+			BandwidthClass: resource.Spec.BandwidthClass,
+			Status:         status,
+		})
+		ids[resource.Metadata.Namespace+"/"+resource.Metadata.Name] = resource.Metadata.UID
+	}
+	kc.vpn.serverManager.syncDiscovered(nodes)
+
+	for _, resource := range servers {
+		id, ok := ids[resource.Metadata.Namespace+"/"+resource.Metadata.Name]
+		if !ok {
+			continue
+		}
+		server, err := kc.vpn.serverManager.GetServer(id)
+		if err != nil {
+			continue
+		}
+
+		status := kubernetes.VPNServerStatus{
+			ServerID:           id,
+			Status:             server.Status,
+			Load:               server.Load,
+			ObservedGeneration: resource.Metadata.Generation,
+		}
+		if status != resource.Status {
+			if err := kc.client.UpdateStatus(ctx, kubernetes.VPNServers, resource.Metadata, status); err != nil {
+				utils.LogWarning("%v", err)
+			}
+		}
+	}
+
+	return ids
+}
+
+// reconcilePeer creates the peer of a VPNPeer, recreates it when the spec
+// changed and removes it when the resource is deleted
+func (kc *KubernetesController) reconcilePeer(ctx context.Context, resource *kubernetes.VPNPeer, serverIDs map[string]string) {
+	meta := resource.Metadata
+	finalized := hasFinalizer(meta.Finalizers)
+
+	// Remove the peer of a deleted resource, then let the resource go
+	if meta.DeletionTimestamp != nil {
+		if !finalized {
+			return
+		}
+		if err := kc.removePeer(ctx, resource); err != nil {
+			utils.LogError("Failed to remove peer of VPNPeer %s/%s: %v", meta.Namespace, meta.Name, err)
+			return
+		}
+		finalizers := make([]string, 0, len(meta.Finalizers))
+		for _, finalizer := range meta.Finalizers {
+			if finalizer != kubernetesFinalizer {
+				finalizers = append(finalizers, finalizer)
+			}
+		}
+		if err := kc.client.SetFinalizers(ctx, kubernetes.VPNPeers, meta, finalizers); err != nil {
+			utils.LogWarning("%v", err)
+		}
+		return
+	}
+
+	if !finalized {
+		if err := kc.client.SetFinalizers(ctx, kubernetes.VPNPeers, meta, append(meta.Finalizers, kubernetesFinalizer)); err != nil {
+			utils.LogWarning("%v", err)
+			return
+		}
+	}
+
+	status := resource.Status
+	status.ObservedGeneration = meta.Generation
+
+	// Recreate the peer when the spec changed, or when it was removed
+	if status.PeerID != "" {
+		if _, err := kc.vpn.peerManager.GetPeer(resource.Spec.UserID, status.PeerID); err != nil {
+			status.PeerID = ""
+		} else if meta.Generation != resource.Status.ObservedGeneration {
+			if err := kc.removePeer(ctx, resource); err != nil {
+				utils.LogError("Failed to replace peer of VPNPeer %s/%s: %v", meta.Namespace, meta.Name, err)
+				return
+			}
+			status.PeerID = ""
+		}
+	}
+
+	if status.PeerID == "" {
+		serverID, ok := serverIDs[meta.Namespace+"/"+resource.Spec.Server]
+		if !ok {
+			kc.setPeerStatus(ctx, resource, status, kubernetes.PeerPending, fmt.Sprintf("VPNServer %s not found", resource.Spec.Server))
+			return
+		}
+
+		deviceType := resource.Spec.DeviceType
+		if deviceType == "" {
+			deviceType = "generic"
+		}
+		deviceName := resource.Spec.DeviceName
+		if deviceName == "" {
+			deviceName = meta.Name
+		}
+
+		peer, _, err := kc.vpn.Connect(ctx, resource.Spec.UserID, serverID, deviceType, deviceName, "", resource.Spec.PublicKey, DNSChoice{}, TunnelChoice{})
+		if err != nil {
+			status.ServerID, status.Address, status.ConfigSecret = "", "", ""
+			kc.setPeerStatus(ctx, resource, status, kubernetes.PeerFailed, err.Error())
+			return
+		}
+		status.PeerID = peer.ID
+		status.ServerID = peer.ServerID
+		status.Address = peer.IP
+		status.ConfigSecret = ""
+
+		utils.LogInfo("Created peer %s for VPNPeer %s/%s", peer.ID, meta.Namespace, meta.Name)
+
+		// Log analytics
+		utils.LogAnalytics(kubernetesActor, "kubernetes_peer_created", fmt.Sprintf("peer=%s resource=%s/%s", peer.ID, meta.Namespace, meta.Name))
+	}
+
+	peer, err := kc.vpn.peerManager.GetPeer(resource.Spec.UserID, status.PeerID)
+	if err != nil {
+		kc.setPeerStatus(ctx, resource, status, kubernetes.PeerFailed, err.Error())
+		return
+	}
+	if peer.PendingApproval {
+		kc.setPeerStatus(ctx, resource, status, kubernetes.PeerPending, "waiting for an admin to approve the public key")
+		return
+	}
+
+	// Keep the client config in a Secret owned by the resource
+	if status.ConfigSecret == "" {
+		rendered, err := kc.vpn.GetConfig(ctx, resource.Spec.UserID, status.PeerID)
+		if err != nil {
+			kc.setPeerStatus(ctx, resource, status, kubernetes.PeerFailed, err.Error())
+			return
+		}
+		secret := meta.Name + "-wireguard"
+		owner := kubernetes.OwnerReference{
+			APIVersion: kubernetes.Group + "/" + kubernetes.Version,
+			Kind:       "VPNPeer",
+			Name:       meta.Name,
+			UID:        meta.UID,
+			Controller: true,
+		}
+		if err := kc.client.ApplySecret(ctx, meta.Namespace, secret, owner, map[string][]byte{"wg0.conf": []byte(rendered)}); err != nil {
+			kc.setPeerStatus(ctx, resource, status, kubernetes.PeerFailed, err.Error())
+			return
+		}
+		status.ConfigSecret = secret
+	}
+
+	status.ServerID = peer.ServerID
+	status.Address = peer.IP
+	kc.setPeerStatus(ctx, resource, status, kubernetes.PeerReady, "")
+}
+
+// removePeer removes the peer of a VPNPeer, if it still exists
+func (kc *KubernetesController) removePeer(ctx context.Context, resource *kubernetes.VPNPeer) error {
+	peerID := resource.Status.PeerID
+	if peerID == "" {
+		return nil
+	}
+	if _, err := kc.vpn.peerManager.GetPeer(resource.Spec.UserID, peerID); err != nil {
+		return nil
+	}
+	if err := kc.vpn.Disconnect(ctx, resource.Spec.UserID, peerID); err != nil {
+		return err
+	}
+
+	utils.LogInfo("Removed peer %s of VPNPeer %s/%s", peerID, resource.Metadata.Namespace, resource.Metadata.Name)
+
+	// Log analytics
+	utils.LogAnalytics(kubernetesActor, "kubernetes_peer_removed", fmt.Sprintf("peer=%s resource=%s/%s", peerID, resource.Metadata.Namespace, resource.Metadata.Name))
+
+	return nil
+}
+
+// setPeerStatus writes the status of a VPNPeer when it changed
+func (kc *KubernetesController) setPeerStatus(ctx context.Context, resource *kubernetes.VPNPeer, status kubernetes.VPNPeerStatus, phase, message string) {
+	status.Phase = phase
+	status.Message = message
+	if status == resource.Status {
+		return
+	}
+	if err := kc.client.UpdateStatus(ctx, kubernetes.VPNPeers, resource.Metadata, status); err != nil {
+		utils.LogWarning("%v", err)
+	}
+}
+
+// hasFinalizer checks whether the controller's finalizer is set
+func hasFinalizer(finalizers []string) bool {
+	for _, finalizer := range finalizers {
+		if finalizer == kubernetesFinalizer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,349 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// latencySmoothing is the weight of a new measurement in a server's
+// smoothed latency to a probe, so a single slow ping does not reorder
+// servers
+const latencySmoothing = 0.3
+
+// maxProbeLatencies is the most probe measurements a heartbeat may carry
+const maxProbeLatencies = 100
+
+// maxClientLatencies is the most server measurements a client may report
+// at once
+const maxClientLatencies = 100
+
+// LatencyProbe represents a reference point node agents ping
+type LatencyProbe struct {
+	ID      string `json:"id"`
+	Country string `json:"country"`
+	City    string `json:"city,omitempty"`
+	Host    string `json:"host"`
+}
+
+// ProbeLatency represents a node agent's measurement of a probe
+type ProbeLatency struct {
+	Probe string  `json:"probe"`
+	RTT   float64 `json:"rtt"`  // in milliseconds
+	Loss  float64 `json:"loss"` // share of pings lost, 0 to 1
+}
+
+// ClientLatency represents a client's ping of a server
+type ClientLatency struct {
+	ServerID string  `json:"serverId"`
+	RTT      float64 `json:"rtt"`  // in milliseconds
+	Loss     float64 `json:"loss"` // share of pings lost, 0 to 1
+}
+
+// LatencyMeasurement represents a server's smoothed latency to a probe
+type LatencyMeasurement struct {
+	RTT        float64   `json:"rtt"` // in milliseconds
+	Loss       float64   `json:"loss"`
+	Samples    int       `json:"samples"`
+	MeasuredAt time.Time `json:"measuredAt"`
+}
+
+// ServerLatencies represents the latencies of a server to every probe, by
+// probe ID
+type ServerLatencies struct {
+	ServerID  string                         `json:"serverId"`
+	Name      string                         `json:"name"`
+	Country   string                         `json:"country"`
+	City      string                         `json:"city"`
+	Load      int                            `json:"load"`
+	Capacity  int                            `json:"capacity"`
+	Latencies map[string]*LatencyMeasurement `json:"latencies"`
+}
+
+// CountryLatency represents the best latency from the servers in a country
+// to the probes in a country
+type CountryLatency struct {
+	ServerCountry string  `json:"serverCountry"`
+	ProbeCountry  string  `json:"probeCountry"`
+	RTT           float64 `json:"rtt"` // in milliseconds
+	Servers       int     `json:"servers"`
+}
+
+// LatencyMatrixView represents the latency matrix as served to clients.
+// Measurements older than a few probe intervals are left out.
+type LatencyMatrixView struct {
+	Probes      []LatencyProbe     `json:"probes"`
+	Servers     []*ServerLatencies `json:"servers"`
+	Countries   []*CountryLatency  `json:"countries"`
+	Interval    int                `json:"interval"` // in seconds between measurements
+	GeneratedAt time.Time          `json:"generatedAt"`
+}
+
+// LatencyMatrix keeps the latencies node agents measure to the configured
+// probes, so clients can weigh server-side data with their own
+// measurements when picking a server, and the latencies clients report to
+// servers, which server recommendations weigh per client region
+type LatencyMatrix struct {
+	config       *config.Config
+	measurements map[string]map[string]*LatencyMeasurement // by server ID, then probe ID
+	clients      map[string]map[string]*LatencyMeasurement // by client region, then server ID
+	clientsSeen  uint64                                    // client reports recorded, a version of the client measurements
+	mutex        sync.RWMutex
+}
+
+// NewLatencyMatrix creates a new latency matrix
+func NewLatencyMatrix(cfg *config.Config) *LatencyMatrix {
+	return &LatencyMatrix{
+		config:       cfg,
+		measurements: make(map[string]map[string]*LatencyMeasurement),
+		clients:      make(map[string]map[string]*LatencyMeasurement),
+	}
+}
+
+// Probes gets the probes node agents should ping
+func (lm *LatencyMatrix) Probes() []LatencyProbe {
+	probes := make([]LatencyProbe, len(lm.config.Nodes.Probes))
+	for i, probe := range lm.config.Nodes.Probes {
+		probes[i] = LatencyProbe{ID: probe.ID, Country: probe.Country, City: probe.City, Host: probe.Host}
+	}
+	return probes
+}
+
+// validateLatencies checks the probe measurements of a heartbeat
+func validateLatencies(v *utils.Validator, latencies []ProbeLatency) {
+	v.Check(len(latencies) <= maxProbeLatencies, "latencies", fmt.Sprintf("must have at most %d entries", maxProbeLatencies))
+	for i, latency := range latencies {
+		field := fmt.Sprintf("latencies[%d]", i)
+		v.Required(field+".probe", latency.Probe)
+		v.Check(latency.RTT >= 0 && !math.IsInf(latency.RTT, 0) && !math.IsNaN(latency.RTT), field+".rtt", "must be a non-negative number")
+		v.Check(latency.Loss >= 0 && latency.Loss <= 1, field+".loss", "must be between 0 and 1")
+	}
+}
+
+// Record records the probe measurements of a server. Measurements of
+// probes that are no longer configured are ignored. Probes that lost every
+// ping keep their latency and only update the loss.
+func (lm *LatencyMatrix) Record(serverID string, latencies []ProbeLatency) {
+	if len(latencies) == 0 {
+		return
+	}
+
+	known := make(map[string]bool, len(lm.config.Nodes.Probes))
+	for _, probe := range lm.config.Nodes.Probes {
+		known[probe.ID] = true
+	}
+
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	measurements, ok := lm.measurements[serverID]
+	if !ok {
+		measurements = make(map[string]*LatencyMeasurement)
+		lm.measurements[serverID] = measurements
+	}
+
+	now := time.Now()
+	for _, latency := range latencies {
+		if !known[latency.Probe] {
+			continue
+		}
+
+		measurement, ok := measurements[latency.Probe]
+		if !ok || time.Since(measurement.MeasuredAt) > lm.maxAge() {
+			if latency.Loss >= 1 {
+				continue
+			}
+			measurements[latency.Probe] = &LatencyMeasurement{RTT: latency.RTT, Loss: latency.Loss, Samples: 1, MeasuredAt: now}
+			continue
+		}
+
+		if latency.Loss < 1 {
+			measurement.RTT += latencySmoothing * (latency.RTT - measurement.RTT)
+		}
+		measurement.Loss += latencySmoothing * (latency.Loss - measurement.Loss)
+		measurement.Samples++
+		measurement.MeasuredAt = now
+	}
+}
+
+// ValidateClientLatencies checks the server measurements of a client
+func ValidateClientLatencies(v *utils.Validator, latencies []ClientLatency) {
+	v.Check(len(latencies) > 0, "results", "must not be empty")
+	v.Check(len(latencies) <= maxClientLatencies, "results", fmt.Sprintf("must have at most %d entries", maxClientLatencies))
+	for i, latency := range latencies {
+		field := fmt.Sprintf("results[%d]", i)
+		v.Required(field+".serverId", latency.ServerID)
+		v.Check(latency.RTT >= 0 && !math.IsInf(latency.RTT, 0) && !math.IsNaN(latency.RTT), field+".rtt", "must be a non-negative number")
+		v.Check(latency.Loss >= 0 && latency.Loss <= 1, field+".loss", "must be between 0 and 1")
+	}
+}
+
+// RecordClient records the server measurements of a client in a region,
+// smoothed with earlier ones from the region like probe measurements
+func (lm *LatencyMatrix) RecordClient(region string, latencies []ClientLatency) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	measurements, ok := lm.clients[region]
+	if !ok {
+		measurements = make(map[string]*LatencyMeasurement)
+		lm.clients[region] = measurements
+	}
+	lm.clientsSeen++
+
+	now := time.Now()
+	for _, latency := range latencies {
+		measurement, ok := measurements[latency.ServerID]
+		if !ok || time.Since(measurement.MeasuredAt) > lm.clientMaxAge() {
+			if latency.Loss >= 1 {
+				continue
+			}
+			measurements[latency.ServerID] = &LatencyMeasurement{RTT: latency.RTT, Loss: latency.Loss, Samples: 1, MeasuredAt: now}
+			continue
+		}
+
+		if latency.Loss < 1 {
+			measurement.RTT += latencySmoothing * (latency.RTT - measurement.RTT)
+		}
+		measurement.Loss += latencySmoothing * (latency.Loss - measurement.Loss)
+		measurement.Samples++
+		measurement.MeasuredAt = now
+	}
+}
+
+// ClientLatency gets the smoothed latency clients in a region observed to
+// a server, unless it is stale
+func (lm *LatencyMatrix) ClientLatency(region, serverID string) (LatencyMeasurement, bool) {
+	lm.mutex.RLock()
+	defer lm.mutex.RUnlock()
+
+	measurement, ok := lm.clients[region][serverID]
+	if !ok || time.Since(measurement.MeasuredAt) > lm.clientMaxAge() {
+		return LatencyMeasurement{}, false
+	}
+	return *measurement, true
+}
+
+// ClientVersion gets the version of the client measurements, which changes
+// whenever a client reports
+func (lm *LatencyMatrix) ClientVersion() uint64 {
+	lm.mutex.RLock()
+	defer lm.mutex.RUnlock()
+
+	return lm.clientsSeen
+}
+
+// Forget drops the measurements of a server, e.g. when it is removed
+func (lm *LatencyMatrix) Forget(serverID string) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	delete(lm.measurements, serverID)
+	for _, measurements := range lm.clients {
+		delete(measurements, serverID)
+	}
+}
+
+// maxAge is the age after which a measurement is stale
+func (lm *LatencyMatrix) maxAge() time.Duration {
+	return 3 * time.Duration(lm.config.Nodes.ProbeInterval) * time.Second
+}
+
+// clientMaxAge is the age after which a client measurement is stale
+func (lm *LatencyMatrix) clientMaxAge() time.Duration {
+	return time.Duration(lm.config.Recommend.MaxAge) * time.Minute
+}
+
+// View gets the current measurements of the given servers and the best
+// latency between every server country and probe country
+func (lm *LatencyMatrix) View(servers []*Server) *LatencyMatrixView {
+	view := &LatencyMatrixView{
+		Probes:      lm.Probes(),
+		Servers:     make([]*ServerLatencies, 0, len(servers)),
+		Countries:   make([]*CountryLatency, 0),
+		Interval:    lm.config.Nodes.ProbeInterval,
+		GeneratedAt: time.Now(),
+	}
+
+	probeCountries := make(map[string]string, len(view.Probes))
+	for _, probe := range view.Probes {
+		probeCountries[probe.ID] = probe.Country
+	}
+
+	type countryPair struct{ server, probe string }
+	countries := make(map[countryPair]*CountryLatency)
+	counted := make(map[countryPair]map[string]bool)
+
+	lm.mutex.RLock()
+	for _, server := range servers {
+		entry := &ServerLatencies{
+			ServerID:  server.ID,
+			Name:      server.Name,
+			Country:   server.Country,
+			City:      server.City,
+			Load:      server.Load,
+			Capacity:  server.Capacity,
+			Latencies: make(map[string]*LatencyMeasurement),
+		}
+
+		for probeID, measurement := range lm.measurements[server.ID] {
+			probeCountry, ok := probeCountries[probeID]
+			if !ok || time.Since(measurement.MeasuredAt) > lm.maxAge() {
+				continue
+			}
+			copied := *measurement
+			entry.Latencies[probeID] = &copied
+
+			pair := countryPair{server: server.Country, probe: probeCountry}
+			country, ok := countries[pair]
+			if !ok {
+				country = &CountryLatency{ServerCountry: server.Country, ProbeCountry: probeCountry, RTT: measurement.RTT}
+				countries[pair] = country
+				counted[pair] = make(map[string]bool)
+				view.Countries = append(view.Countries, country)
+			}
+			country.RTT = math.Min(country.RTT, measurement.RTT)
+			if !counted[pair][server.ID] {
+				counted[pair][server.ID] = true
+				country.Servers++
+			}
+		}
+
+		view.Servers = append(view.Servers, entry)
+	}
+	lm.mutex.RUnlock()
+
+	sort.Slice(view.Servers, func(i, j int) bool {
+		return view.Servers[i].ServerID < view.Servers[j].ServerID
+	})
+	sort.Slice(view.Countries, func(i, j int) bool {
+		if view.Countries[i].ServerCountry != view.Countries[j].ServerCountry {
+			return view.Countries[i].ServerCountry < view.Countries[j].ServerCountry
+		}
+		return view.Countries[i].ProbeCountry < view.Countries[j].ProbeCountry
+	})
+
+	return view
+}
+
+// LatencyMatrix gets the latencies of the online servers to the probes,
+// optionally only of servers in a country
+func (vm *VPNManager) LatencyMatrix(country string) *LatencyMatrixView {
+	servers := make([]*Server, 0)
+	for _, server := range vm.serverManager.GetServers() {
+		if server.Status != "online" {
+			continue
+		}
+		if country != "" && server.Country != country && server.CountryCode != country {
+			continue
+		}
+		servers = append(servers, server)
+	}
+
+	return vm.serverManager.Latency().View(servers)
+}
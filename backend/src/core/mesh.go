@@ -0,0 +1,381 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// maxMeshMembers limits the users in one mesh, as every config carries a
+// peer per device of the other members
+const maxMeshMembers = 100
+
+// Mesh puts the devices of a group of users, such as the staff of an
+// organization, in a mesh: their configs carry each other as peers, either
+// all of them (full) or a hub device that forwards between the others
+// (hub). A user is in at most one mesh.
+type Mesh struct {
+	ID        string    `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Topology  string    `json:"topology" db:"topology"`
+	HubPeerID string    `json:"hubPeerId,omitempty" db:"hub_peer_id"`
+	Members   []string  `json:"members" db:"-"` // user IDs
+	CreatedBy string    `json:"createdBy" db:"created_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// Validate checks the fields of a mesh
+func (mesh *Mesh) Validate() error {
+	var v utils.Validator
+	v.Required("name", mesh.Name)
+	v.MaxLength("name", mesh.Name, 64)
+	v.OneOf("topology", mesh.Topology, wireguard.MeshFull, wireguard.MeshHub)
+	v.Check(mesh.Topology != wireguard.MeshHub || mesh.HubPeerID != "", "hubPeerId", "is required for the hub topology")
+	v.Check(mesh.Topology == wireguard.MeshHub || mesh.HubPeerID == "", "hubPeerId", "is only used with the hub topology")
+	v.Check(len(mesh.Members) > 0, "members", "is required")
+	v.Check(len(mesh.Members) <= maxMeshMembers, "members", fmt.Sprintf("must have at most %d users", maxMeshMembers))
+	seen := make(map[string]bool)
+	for _, member := range mesh.Members {
+		v.Check(member != "" && !seen[member], "members", "must be distinct user IDs")
+		seen[member] = true
+	}
+	return v.Err()
+}
+
+// MeshEvent is the data of the configs of a mesh member changing, as a
+// device of the mesh was added or removed or the mesh itself changed
+type MeshEvent struct {
+	MeshID string `json:"meshId"`
+	UserID string `json:"userId"`
+	PeerID string `json:"peerId,omitempty"` // device added or removed, if any
+}
+
+// MeshManager keeps the meshes of users' devices
+type MeshManager struct {
+	config *config.Config
+	meshes map[string]*Mesh // by ID
+	mutex  sync.RWMutex
+}
+
+// NewMeshManager creates a new mesh manager
+func NewMeshManager(cfg *config.Config) *MeshManager {
+	return &MeshManager{
+		config: cfg,
+		meshes: make(map[string]*Mesh),
+		mutex:  sync.RWMutex{},
+	}
+}
+
+// List gets the meshes by name
+func (mm *MeshManager) List(ctx context.Context) ([]*Mesh, error) {
+	mm.mutex.RLock()
+	defer mm.mutex.RUnlock()
+
+	meshes := make([]*Mesh, 0)
+
+	if db.DB != nil {
+		err := db.DB.SelectContext(ctx, &meshes,
+			`SELECT id, name, topology, hub_peer_id, created_by, created_at, updated_at FROM meshes`,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list meshes: %v", err)
+		}
+
+		var members []struct {
+			MeshID string `db:"mesh_id"`
+			UserID string `db:"user_id"`
+		}
+		if err := db.DB.SelectContext(ctx, &members, `SELECT mesh_id, user_id FROM mesh_members ORDER BY user_id`); err != nil {
+			return nil, fmt.Errorf("failed to list mesh members: %v", err)
+		}
+		byID := make(map[string]*Mesh, len(meshes))
+		for _, mesh := range meshes {
+			mesh.Members = make([]string, 0)
+			byID[mesh.ID] = mesh
+		}
+		for _, member := range members {
+			if mesh, ok := byID[member.MeshID]; ok {
+				mesh.Members = append(mesh.Members, member.UserID)
+			}
+		}
+	} else {
+		for _, mesh := range mm.meshes {
+			meshes = append(meshes, mesh)
+		}
+	}
+
+	sort.Slice(meshes, func(i, j int) bool { return meshes[i].Name < meshes[j].Name })
+	return meshes, nil
+}
+
+// Get gets a mesh
+func (mm *MeshManager) Get(ctx context.Context, id string) (*Mesh, error) {
+	meshes, err := mm.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, mesh := range meshes {
+		if mesh.ID == id {
+			return mesh, nil
+		}
+	}
+	return nil, fmt.Errorf("mesh not found: %s", id)
+}
+
+// save inserts or updates a mesh and replaces its members
+func (mm *MeshManager) save(ctx context.Context, mesh *Mesh) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	if db.DB != nil {
+		tx, err := db.DB.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO meshes (id, name, topology, hub_peer_id, created_by, created_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)
+			 ON CONFLICT (id) DO UPDATE SET name = $2, topology = $3, hub_peer_id = $4, updated_at = $7`,
+			mesh.ID, mesh.Name, mesh.Topology, mesh.HubPeerID, mesh.CreatedBy, mesh.CreatedAt, mesh.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save mesh: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM mesh_members WHERE mesh_id = $1`, mesh.ID); err != nil {
+			return fmt.Errorf("failed to save mesh members: %v", err)
+		}
+		for _, member := range mesh.Members {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO mesh_members (user_id, mesh_id) VALUES ($1, $2)`, member, mesh.ID); err != nil {
+				return fmt.Errorf("failed to save mesh members: %v", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to save mesh: %v", err)
+		}
+		return nil
+	}
+
+	mm.meshes[mesh.ID] = mesh
+	return nil
+}
+
+// remove deletes a mesh
+func (mm *MeshManager) remove(ctx context.Context, id string) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	if db.DB != nil {
+		result, err := db.DB.ExecContext(ctx, `DELETE FROM meshes WHERE id = $1`, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete mesh: %v", err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return fmt.Errorf("mesh not found: %s", id)
+		}
+		return nil
+	}
+
+	if _, ok := mm.meshes[id]; !ok {
+		return fmt.Errorf("mesh not found: %s", id)
+	}
+	delete(mm.meshes, id)
+	return nil
+}
+
+// policies gets the meshes for rendering configs
+func (mm *MeshManager) policies() ([]wireguard.MeshPolicy, error) {
+	meshes, err := mm.List(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make([]wireguard.MeshPolicy, len(meshes))
+	for i, mesh := range meshes {
+		policies[i] = wireguard.MeshPolicy{
+			ID:        mesh.ID,
+			Topology:  mesh.Topology,
+			HubPeerID: mesh.HubPeerID,
+			UserIDs:   mesh.Members,
+		}
+	}
+	return policies, nil
+}
+
+// Mesh gets the meshes of users' devices
+func (vm *VPNManager) Mesh() *MeshManager {
+	return vm.mesh
+}
+
+// CreateMesh adds a mesh of the devices of the given users
+func (vm *VPNManager) CreateMesh(ctx context.Context, actor string, mesh Mesh) (*Mesh, error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	now := time.Now()
+	mesh.ID = utils.GenerateUUID()
+	mesh.CreatedBy = actor
+	mesh.CreatedAt = now
+	mesh.UpdatedAt = now
+	if err := vm.checkMesh(ctx, &mesh); err != nil {
+		return nil, err
+	}
+	if err := vm.mesh.save(ctx, &mesh); err != nil {
+		return nil, err
+	}
+	vm.publishMesh(&mesh, mesh.Members, "")
+
+	// Log analytics
+	utils.LogAnalytics(actor, "mesh_created", fmt.Sprintf("mesh=%s topology=%s members=%d", mesh.ID, mesh.Topology, len(mesh.Members)))
+
+	return &mesh, nil
+}
+
+// UpdateMesh replaces the topology and members of a mesh
+func (vm *VPNManager) UpdateMesh(ctx context.Context, actor, id string, update Mesh) (*Mesh, error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	existing, err := vm.mesh.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	mesh := *existing
+	mesh.Name = update.Name
+	mesh.Topology = update.Topology
+	mesh.HubPeerID = update.HubPeerID
+	mesh.Members = update.Members
+	mesh.UpdatedAt = time.Now()
+	if err := vm.checkMesh(ctx, &mesh); err != nil {
+		return nil, err
+	}
+	if err := vm.mesh.save(ctx, &mesh); err != nil {
+		return nil, err
+	}
+
+	// Users that left the mesh lose its peers too
+	vm.publishMesh(&mesh, append(existing.Members, mesh.Members...), "")
+
+	// Log analytics
+	utils.LogAnalytics(actor, "mesh_updated", fmt.Sprintf("mesh=%s topology=%s members=%d", mesh.ID, mesh.Topology, len(mesh.Members)))
+
+	return &mesh, nil
+}
+
+// DeleteMesh removes a mesh; the configs of its members go back to the
+// server alone
+func (vm *VPNManager) DeleteMesh(ctx context.Context, actor, id string) error {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	mesh, err := vm.mesh.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := vm.mesh.remove(ctx, id); err != nil {
+		return err
+	}
+	vm.publishMesh(mesh, mesh.Members, "")
+
+	// Log analytics
+	utils.LogAnalytics(actor, "mesh_deleted", fmt.Sprintf("mesh=%s", id))
+
+	return nil
+}
+
+// checkMesh validates a mesh and checks its members are in no other mesh
+// and its hub is a device of a member
+func (vm *VPNManager) checkMesh(ctx context.Context, mesh *Mesh) error {
+	mesh.Name = strings.TrimSpace(mesh.Name)
+	if err := mesh.Validate(); err != nil {
+		return err
+	}
+
+	meshes, err := vm.mesh.List(ctx)
+	if err != nil {
+		return err
+	}
+	members := make(map[string]bool, len(mesh.Members))
+	for _, member := range mesh.Members {
+		members[member] = true
+	}
+
+	var v utils.Validator
+	for _, other := range meshes {
+		if other.ID == mesh.ID {
+			continue
+		}
+		for _, member := range other.Members {
+			v.Check(!members[member], "members", fmt.Sprintf("user %s is already in mesh %s", member, other.Name))
+		}
+	}
+	if mesh.HubPeerID != "" {
+		found := false
+		for _, member := range mesh.Members {
+			if _, err := vm.peerManager.GetPeer(member, mesh.HubPeerID); err == nil {
+				found = true
+				break
+			}
+		}
+		v.Check(found, "hubPeerId", "must be a device of a member")
+	}
+	return v.Err()
+}
+
+// publishMesh announces that the configs of the given users changed with a
+// mesh, so their apps download them again
+func (vm *VPNManager) publishMesh(mesh *Mesh, userIDs []string, peerID string) {
+	seen := make(map[string]bool)
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		vm.events.Publish(EventMeshUpdated, MeshEvent{MeshID: mesh.ID, UserID: userID, PeerID: peerID})
+	}
+}
+
+// RunMeshUpdates announces the config changes of mesh members as their
+// devices are added and removed, until the subscription ends
+func (vm *VPNManager) RunMeshUpdates(events *EventBus) {
+	subscription, _, unsubscribe := events.Subscribe(0)
+	defer unsubscribe()
+
+	for event := range subscription {
+		peer, ok := event.Data.(PeerEvent)
+		if !ok || peer.Dynamic {
+			continue
+		}
+
+		switch event.Type {
+		case EventPeerConnected, EventPeerDisconnected, EventPeerArchived, EventPeerReactivated, EventPeerApproved, EventPeerMigrated:
+		default:
+			continue
+		}
+
+		meshes, err := vm.mesh.List(context.Background())
+		if err != nil {
+			utils.LogError("Failed to get meshes: %v", err)
+			continue
+		}
+		for _, mesh := range meshes {
+			for _, member := range mesh.Members {
+				if member == peer.UserID {
+					vm.publishMesh(mesh, mesh.Members, peer.PeerID)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// TOTP parameters (RFC 6238), matching the defaults of authenticator apps
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	totpSkew   = 1 // steps accepted either side of the current one
+)
+
+// totpEncoding encodes TOTP secrets as authenticator apps expect them
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAEnrollment is returned when a user starts two-factor enrollment
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"` // otpauth:// URL for QR codes
+}
+
+// mfaSecret is a user's TOTP secret
+type mfaSecret struct {
+	Secret    string
+	Confirmed bool
+	LastStep  int64 // last accepted step, so codes cannot be replayed
+}
+
+// MFAManager enrolls users in TOTP two-factor authentication and verifies
+// their codes
+type MFAManager struct {
+	config  *config.Config
+	secrets map[string]*mfaSecret
+	mutex   sync.Mutex
+}
+
+// NewMFAManager creates a new MFA manager
+func NewMFAManager(cfg *config.Config) *MFAManager {
+	mm := &MFAManager{
+		config:  cfg,
+		secrets: make(map[string]*mfaSecret),
+		mutex:   sync.Mutex{},
+	}
+
+	if err := mm.load(); err != nil {
+		utils.LogError("Failed to load MFA secrets: %v", err)
+	}
+
+	return mm
+}
+
+// Enroll generates a new TOTP secret for a user. It replaces any previous
+// secret once confirmed with a code from the authenticator app.
+func (mm *MFAManager) Enroll(userID, account string) (*MFAEnrollment, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate MFA secret: %v", err)
+	}
+	secret := totpEncoding.EncodeToString(buf)
+
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	if existing, ok := mm.secrets[userID]; ok && existing.Confirmed {
+		return nil, fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`UPDATE users SET mfa_secret = $1, mfa_enabled = FALSE, updated_at = $2 WHERE id = $3`, secret, time.Now(), userID); err != nil {
+			return nil, fmt.Errorf("failed to save MFA secret: %v", err)
+		}
+	}
+
+	mm.secrets[userID] = &mfaSecret{Secret: secret}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "user_mfa_enroll", "")
+
+	return &MFAEnrollment{
+		Secret: secret,
+		URL:    totpURL(mm.config.MFA.Issuer, account, secret),
+	}, nil
+}
+
+// Confirm enables two-factor authentication once the user proves they
+// have set up the secret
+func (mm *MFAManager) Confirm(userID, code string) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	secret, ok := mm.secrets[userID]
+	if !ok {
+		return fmt.Errorf("two-factor enrollment has not been started")
+	}
+	if secret.Confirmed {
+		return fmt.Errorf("two-factor authentication is already enabled")
+	}
+	if err := secret.verify(code, time.Now()); err != nil {
+		return err
+	}
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`UPDATE users SET mfa_enabled = TRUE, updated_at = $1 WHERE id = $2`, time.Now(), userID); err != nil {
+			return fmt.Errorf("failed to enable MFA: %v", err)
+		}
+	}
+
+	secret.Confirmed = true
+
+	// Log analytics
+	utils.LogAnalytics(userID, "user_mfa_enabled", "")
+
+	return nil
+}
+
+// Verify checks a code from a user's authenticator app
+func (mm *MFAManager) Verify(userID, code string) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	secret, ok := mm.secrets[userID]
+	if !ok || !secret.Confirmed {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	return secret.verify(code, time.Now())
+}
+
+// Enabled checks whether a user has confirmed two-factor authentication
+func (mm *MFAManager) Enabled(userID string) bool {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	secret, ok := mm.secrets[userID]
+	return ok && secret.Confirmed
+}
+
+// verify checks a code against the secret and rejects reuse of a code
+// that was already accepted
+func (s *mfaSecret) verify(code string, now time.Time) error {
+	key, err := totpEncoding.DecodeString(s.Secret)
+	if err != nil {
+		return fmt.Errorf("invalid MFA secret: %v", err)
+	}
+
+	current := now.Unix() / int64(totpPeriod/time.Second)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= s.LastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			s.LastStep = step
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid verification code")
+}
+
+// load reads MFA secrets from the database
+func (mm *MFAManager) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	rows := []struct {
+		ID      string `db:"id"`
+		Secret  string `db:"mfa_secret"`
+		Enabled bool   `db:"mfa_enabled"`
+	}{}
+	if err := db.DB.Select(&rows, `SELECT id, mfa_secret, mfa_enabled FROM users WHERE mfa_secret IS NOT NULL`); err != nil {
+		return fmt.Errorf("failed to query MFA secrets: %v", err)
+	}
+
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	for _, row := range rows {
+		mm.secrets[row.ID] = &mfaSecret{Secret: row.Secret, Confirmed: row.Enabled}
+	}
+
+	return nil
+}
+
+// totpCode computes the TOTP code for a time step
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// totpURL builds the otpauth:// URL authenticator apps import secrets from
+func totpURL(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("period", fmt.Sprintf("%d", int(totpPeriod/time.Second)))
+	query.Set("digits", fmt.Sprintf("%d", totpDigits))
+
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}).String()
+}
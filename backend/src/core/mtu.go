@@ -0,0 +1,159 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// TunnelChoice represents the tunnel settings requested for a new device.
+// Zero values keep the defaults.
+type TunnelChoice struct {
+	MTU         int
+	NetworkType string
+	Keepalive   *int // in seconds, 0 for off; nil keeps the account default
+
+	// Obfuscated wraps WireGuard in the TCP transport of the server's
+	// obfuscation endpoint
+	Obfuscated bool
+
+	// RoutedSubnets are the networks behind a gateway device
+	RoutedSubnets []string
+}
+
+// MTUSuggestion represents the MTU suggested for a measured path MTU
+type MTUSuggestion struct {
+	PathMTU  int    `json:"pathMtu"`
+	Overhead int    `json:"overhead"` // bytes WireGuard adds to every packet
+	MTU      int    `json:"mtu"`
+	Warning  string `json:"warning,omitempty"`
+}
+
+// validateTunnel checks requested tunnel settings
+func validateTunnel(mtu int, networkType string) error {
+	if mtu != 0 && (mtu < wireguard.MinMTU || mtu > wireguard.MaxMTU) {
+		return fmt.Errorf("MTU must be between %d and %d", wireguard.MinMTU, wireguard.MaxMTU)
+	}
+	if networkType != "" {
+		known := false
+		for _, name := range wireguard.NetworkTypes() {
+			known = known || name == networkType
+		}
+		if !known {
+			return fmt.Errorf("unknown network type: %s", networkType)
+		}
+	}
+	return nil
+}
+
+// applyTunnel sets the requested tunnel settings on the options of a new
+// peer
+func applyTunnel(opts *wireguard.PeerOptions, choice TunnelChoice) error {
+	if err := validateTunnel(choice.MTU, choice.NetworkType); err != nil {
+		return err
+	}
+
+	opts.MTU = choice.MTU
+	opts.NetworkType = choice.NetworkType
+	opts.Obfuscated = choice.Obfuscated
+
+	if len(choice.RoutedSubnets) > 0 {
+		subnets, err := wireguard.NormalizeSubnets(choice.RoutedSubnets)
+		if err != nil {
+			return err
+		}
+		opts.RoutedSubnets = subnets
+	}
+
+	if choice.Keepalive != nil {
+		if err := validateKeepalive(*choice.Keepalive); err != nil {
+			return err
+		}
+		opts.Keepalive = keepaliveOption(*choice.Keepalive)
+	}
+	return nil
+}
+
+// SuggestMTU suggests the MTU for a client that measured pathMTU bytes to
+// its server, over IPv6 if ipv6 is set. The tunnel gives up the WireGuard
+// overhead; paths too small for the IPv6 minimum get the minimum and a
+// warning, since the client then relies on fragmentation.
+func SuggestMTU(pathMTU int, ipv6 bool) *MTUSuggestion {
+	suggestion := &MTUSuggestion{
+		PathMTU:  pathMTU,
+		Overhead: wireguard.TunnelOverhead(ipv6),
+	}
+
+	suggestion.MTU = pathMTU - suggestion.Overhead
+	switch {
+	case suggestion.MTU < wireguard.MinMTU:
+		suggestion.MTU = wireguard.MinMTU
+		suggestion.Warning = fmt.Sprintf("path MTU is too small for a %d byte tunnel; packets will be fragmented", wireguard.MinMTU)
+	case suggestion.MTU > wireguard.MaxMTU:
+		suggestion.MTU = wireguard.MaxMTU
+	}
+
+	return suggestion
+}
+
+// SetPeerMTU sets the MTU override and network type of a peer and returns
+// the peer with its new config. Zero and empty return them to the
+// defaults. Pending peers are returned without a config.
+func (vm *VPNManager) SetPeerMTU(ctx context.Context, userID, peerID string, mtu int, networkType string) (peer *wireguard.PeerConfig, config string, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.SetPeerMTU",
+		attribute.String("peer.id", peerID),
+		attribute.Int("mtu", mtu),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateTunnel(mtu, networkType); err != nil {
+		return nil, "", err
+	}
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "mtu"); err != nil {
+		return nil, "", err
+	}
+
+	// Get peer
+	if _, err := vm.peerManager.GetPeer(userID, peerID); err != nil {
+		return nil, "", fmt.Errorf("peer not found: %s", peerID)
+	}
+
+	peer, err = vm.peerManager.SetMTU(userID, peerID, mtu, networkType)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to save MTU: %v", err)
+	}
+
+	utils.LogInfoContext(ctx, "Set MTU of peer %s to %d (network %q)", peer.ID, mtu, networkType)
+
+	// Log analytics
+	utils.LogAnalytics(userID, "peer_mtu_update", fmt.Sprintf("peer=%s mtu=%d network=%s", peer.ID, mtu, networkType))
+
+	if peer.Pending() || peer.Archived() {
+		return peer, "", nil
+	}
+
+	// Generate configuration
+	config, err = vm.renderConfig(peer, "mtu")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate configuration: %v", err)
+	}
+
+	return peer, config, nil
+}
+
+// PeerMTU gets the MTU rendered into a peer's config
+func (vm *VPNManager) PeerMTU(peer *wireguard.PeerConfig) int {
+	return wireguard.PeerMTU(vm.config, peer)
+}
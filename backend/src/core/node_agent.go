@@ -0,0 +1,405 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// Commands node agents run on their server
+const (
+	NodeCommandSync    = "sync"    // fetch and apply the node state right away
+	NodeCommandRestart = "restart" // recreate the WireGuard interfaces
+	NodeCommandUpgrade = "upgrade" // install another agent version
+)
+
+// Node command statuses
+const (
+	NodeCommandPending = "pending" // waiting for the next heartbeat of the agent
+	NodeCommandSent    = "sent"
+	NodeCommandDone    = "done"
+	NodeCommandFailed  = "failed"
+)
+
+// nodeCommandRetention is how long finished commands are listed
+const nodeCommandRetention = 24 * time.Hour
+
+// agentVersionPattern matches the agent versions upgrades install, which
+// agents substitute into a shell command
+var agentVersionPattern = regexp.MustCompile(`^[0-9A-Za-z.+~_-]+$`)
+
+// NodeHealth represents the health of its server a node agent reports with
+// every heartbeat
+type NodeHealth struct {
+	Up            bool   `json:"up"` // every WireGuard interface exists
+	Peers         int    `json:"peers"`
+	ActivePeers   int    `json:"activePeers"` // peers with a recent handshake
+	ReceiveBytes  int64  `json:"receiveBytes"`
+	TransmitBytes int64  `json:"transmitBytes"`
+	StateVersion  string `json:"stateVersion,omitempty"` // node state last applied
+	Error         string `json:"error,omitempty"`        // why the last apply failed
+
+	// Load of the host since the last heartbeat
+	CPU          float64 `json:"cpu"`          // percent of CPU time busy
+	Memory       float64 `json:"memory"`       // percent of memory in use
+	ReceiveRate  int64   `json:"receiveRate"`  // in bytes per second
+	TransmitRate int64   `json:"transmitRate"` // in bytes per second
+
+	Interfaces []wireguard.InterfaceStatus `json:"interfaces,omitempty"`
+
+	// Drift found by the last reconcile against the node state
+	Drift *wireguard.Drift `json:"drift,omitempty"`
+}
+
+// NodeCommand represents a command queued for a node agent. Agents receive
+// pending commands with a heartbeat response and report the result with
+// their next heartbeat.
+type NodeCommand struct {
+	ID          string     `json:"id" db:"id"`
+	ServerID    string     `json:"serverId" db:"server_id"`
+	Type        string     `json:"type" db:"type"`
+	Version     string     `json:"version,omitempty" db:"version"` // agent version to install, upgrades only
+	Status      string     `json:"status" db:"status"`
+	Error       string     `json:"error,omitempty" db:"error"`
+	CreatedBy   string     `json:"createdBy,omitempty" db:"created_by"`
+	CreatedAt   time.Time  `json:"createdAt" db:"created_at"`
+	SentAt      *time.Time `json:"sentAt,omitempty" db:"sent_at"`
+	CompletedAt *time.Time `json:"completedAt,omitempty" db:"completed_at"`
+}
+
+// NodeCommandResult represents the outcome of a command a node agent ran
+type NodeCommandResult struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"` // empty when the command succeeded
+}
+
+// NodeCommandQueue keeps the commands queued for node agents
+type NodeCommandQueue struct {
+	config   *config.Config
+	commands map[string]*NodeCommand
+	mutex    sync.RWMutex
+}
+
+// NewNodeCommandQueue creates a new node command queue
+func NewNodeCommandQueue(cfg *config.Config) *NodeCommandQueue {
+	q := &NodeCommandQueue{
+		config:   cfg,
+		commands: make(map[string]*NodeCommand),
+		mutex:    sync.RWMutex{},
+	}
+
+	if err := q.load(); err != nil {
+		utils.LogError("Failed to load node commands: %v", err)
+	}
+
+	return q
+}
+
+// Queue queues a command for the agent of a server, with the agent version
+// to install for upgrades. A command of the same type and version still
+// waiting for the agent is returned instead of a new one.
+func (q *NodeCommandQueue) Queue(serverID, commandType, version, actor string) (*NodeCommand, error) {
+	var v utils.Validator
+	v.Required("type", commandType)
+	v.OneOf("type", commandType, NodeCommandSync, NodeCommandRestart, NodeCommandUpgrade)
+	ValidateAgentVersion(&v, commandType, version)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, command := range q.commands {
+		if command.ServerID == serverID && command.Type == commandType && command.Version == version && command.Status == NodeCommandPending {
+			copied := *command
+			return &copied, nil
+		}
+	}
+
+	command := &NodeCommand{
+		ID:        utils.GenerateUUID(),
+		ServerID:  serverID,
+		Type:      commandType,
+		Version:   version,
+		Status:    NodeCommandPending,
+		CreatedBy: actor,
+		CreatedAt: time.Now(),
+	}
+	if err := q.save(command); err != nil {
+		return nil, err
+	}
+	q.commands[command.ID] = command
+
+	utils.LogInfo("Queued %s command %s for node %s", commandType, command.ID, serverID)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "node_command_queue", fmt.Sprintf("server=%s command=%s type=%s", serverID, command.ID, commandType))
+
+	copied := *command
+	return &copied, nil
+}
+
+// ValidateAgentVersion checks the agent version of a command: upgrades need
+// one, which agents pass to their upgrade command, and other commands none
+func ValidateAgentVersion(v *utils.Validator, commandType, version string) {
+	if commandType != NodeCommandUpgrade {
+		v.Check(version == "", "version", "is only used by upgrades")
+		return
+	}
+	v.Required("version", version)
+	v.MaxLength("version", version, 64)
+	v.Check(agentVersionPattern.MatchString(version), "version", "may only contain letters, digits and . + ~ _ -")
+}
+
+// Get gets a command by ID
+func (q *NodeCommandQueue) Get(id string) (*NodeCommand, bool) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	command, ok := q.commands[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *command
+	return &copied, true
+}
+
+// List gets the recent commands of a server, most recent first
+func (q *NodeCommandQueue) List(serverID string) []*NodeCommand {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	commands := make([]*NodeCommand, 0)
+	for _, command := range q.commands {
+		if command.ServerID != serverID {
+			continue
+		}
+		copied := *command
+		commands = append(commands, &copied)
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].CreatedAt.After(commands[j].CreatedAt)
+	})
+
+	return commands
+}
+
+// Pending counts the commands waiting for their agent
+func (q *NodeCommandQueue) Pending() int {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	pending := 0
+	for _, command := range q.commands {
+		if command.Status == NodeCommandPending {
+			pending++
+		}
+	}
+	return pending
+}
+
+// take marks the pending commands of a server as sent and returns them.
+// Commands sent earlier without a result within timeout have failed, as
+// the agent restarted or lost them.
+func (q *NodeCommandQueue) take(serverID string, timeout time.Duration) []*NodeCommand {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	taken := make([]*NodeCommand, 0)
+	for id, command := range q.commands {
+		if command.ServerID != serverID {
+			continue
+		}
+
+		switch {
+		case command.Status == NodeCommandPending:
+			command.Status = NodeCommandSent
+			command.SentAt = &now
+			if err := q.save(command); err != nil {
+				utils.LogError("Failed to save node command %s: %v", command.ID, err)
+			}
+			copied := *command
+			taken = append(taken, &copied)
+		case command.Status == NodeCommandSent && now.Sub(*command.SentAt) > timeout:
+			q.finish(command, "no result from the agent")
+		case command.CompletedAt != nil && now.Sub(*command.CompletedAt) > nodeCommandRetention:
+			delete(q.commands, id)
+		}
+	}
+
+	sort.Slice(taken, func(i, j int) bool {
+		return taken[i].CreatedAt.Before(taken[j].CreatedAt)
+	})
+
+	return taken
+}
+
+// complete records the results a node agent reported for its commands
+func (q *NodeCommandQueue) complete(serverID string, results []NodeCommandResult) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, result := range results {
+		command, ok := q.commands[result.ID]
+		if !ok || command.ServerID != serverID || command.Status != NodeCommandSent {
+			continue
+		}
+		q.finish(command, result.Error)
+	}
+}
+
+// finish marks a command done, or failed with an error
+func (q *NodeCommandQueue) finish(command *NodeCommand, errMessage string) {
+	now := time.Now()
+	command.Status = NodeCommandDone
+	command.Error = errMessage
+	command.CompletedAt = &now
+	if errMessage != "" {
+		command.Status = NodeCommandFailed
+		utils.LogWarning("Node %s failed %s command %s: %s", command.ServerID, command.Type, command.ID, errMessage)
+	}
+
+	if err := q.save(command); err != nil {
+		utils.LogError("Failed to save node command %s: %v", command.ID, err)
+	}
+}
+
+// save saves a command to the database
+func (q *NodeCommandQueue) save(command *NodeCommand) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	_, err := db.DB.Exec(
+		`INSERT INTO node_commands (id, server_id, type, status, error, created_by, created_at, sent_at, completed_at, version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET status = $4, error = $5, sent_at = $8, completed_at = $9`,
+		command.ID, command.ServerID, command.Type, command.Status, command.Error, command.CreatedBy,
+		command.CreatedAt, command.SentAt, command.CompletedAt, command.Version,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save node command: %v", err)
+	}
+
+	return nil
+}
+
+// load reads the recent commands from the database
+func (q *NodeCommandQueue) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	commands := []*NodeCommand{}
+	err := db.DB.Select(&commands,
+		`SELECT id, server_id, type, version, status, error, created_by, created_at, sent_at, completed_at FROM node_commands
+		WHERE completed_at IS NULL OR completed_at > $1`,
+		time.Now().Add(-nodeCommandRetention),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query node commands: %v", err)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, command := range commands {
+		q.commands[command.ID] = command
+	}
+
+	return nil
+}
+
+// recordHealth records the health a node agent reported. The server load
+// follows its peer count, and its status follows its WireGuard interfaces
+// unless it is in maintenance.
+func (sm *ServerManager) recordHealth(serverID string, health *NodeHealth) {
+	sm.mutex.Lock()
+	sm.health[serverID] = health
+	server, ok := sm.servers[serverID]
+	status := ""
+	if ok {
+		if server.Load != health.Peers {
+			server.Load = health.Peers
+			sm.changed()
+		}
+		server.LastUpdated = time.Now()
+		status = server.Status
+	}
+	sm.mutex.Unlock()
+
+	switch {
+	case status == "online" && !health.Up:
+		utils.LogWarning("Node %s reports its WireGuard interfaces down", serverID)
+		sm.UpdateServerStatus(serverID, "offline")
+	case status == "offline" && health.Up:
+		utils.LogInfo("Node %s reports its WireGuard interfaces up", serverID)
+		sm.UpdateServerStatus(serverID, "online")
+	}
+	if health.Error != "" {
+		utils.LogWarning("Node %s failed to apply state %s: %s", serverID, health.StateVersion, health.Error)
+	}
+	if health.Drift != nil {
+		sm.reportDrift(serverID, *health.Drift)
+	}
+}
+
+// NodeHealth gets the health last reported by the agent of a server
+func (sm *ServerManager) NodeHealth(serverID string) (NodeHealth, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	health, ok := sm.health[serverID]
+	if !ok {
+		return NodeHealth{}, false
+	}
+	return *health, true
+}
+
+// NodeCommands gets the command queue of node agents
+func (sm *ServerManager) NodeCommands() *NodeCommandQueue {
+	return sm.commands
+}
+
+// TakeNodeCommands gets the commands queued for the agent of a server since
+// it last took them, marking them sent
+func (sm *ServerManager) TakeNodeCommands(serverID string) []*NodeCommand {
+	return sm.commands.take(serverID, sm.heartbeatTimeout())
+}
+
+// CompleteNodeCommands records the results the agent of a server reported
+// for its commands
+func (sm *ServerManager) CompleteNodeCommands(serverID string, results []NodeCommandResult) {
+	sm.commands.complete(serverID, results)
+}
+
+// QueueNodeCommand queues a command for the agent of a server, with the
+// agent version to install for upgrades
+func (sm *ServerManager) QueueNodeCommand(serverID, commandType, version, actor string) (*NodeCommand, error) {
+	if _, err := sm.GetServer(serverID); err != nil {
+		return nil, err
+	}
+	return sm.commands.Queue(serverID, commandType, version, actor)
+}
+
+// NodeState gets the peers and policies the agent of a server applies
+func (vm *VPNManager) NodeState(serverID string) (*wireguard.NodeState, error) {
+	if _, err := vm.serverManager.GetServer(serverID); err != nil {
+		return nil, err
+	}
+
+	vm.mutex.RLock()
+	defer vm.mutex.RUnlock()
+
+	return vm.peerManager.NodeState(serverID)
+}
@@ -0,0 +1,356 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// NodeHeartbeat represents the state a node agent reports on every heartbeat
+type NodeHeartbeat struct {
+	ServerID           string `json:"serverId"`
+	AgentVersion       string `json:"agentVersion"`
+	WireGuardVersion   string `json:"wireguardVersion"`
+	CertificateVersion string `json:"certificateVersion,omitempty"` // node certificate the agent has installed
+
+	// Measurements of the probes in the last heartbeat response, if due
+	Latencies []ProbeLatency `json:"latencies,omitempty"`
+
+	// WireGuardImplementation is kernel or userspace (wireguard-go), as
+	// detected by the agent; agents that do not detect it leave it empty
+	WireGuardImplementation string `json:"wireguardImplementation,omitempty"`
+
+	// Obfuscation is the transport of the node's obfuscation endpoint,
+	// empty without one
+	Obfuscation string `json:"obfuscation,omitempty"`
+
+	// Health of the server, reported by agents that run it (cmd/agent)
+	Health *NodeHealth `json:"health,omitempty"`
+
+	// Results of the commands in earlier heartbeat responses
+	Results []NodeCommandResult `json:"results,omitempty"`
+}
+
+// Validate checks the fields of a heartbeat
+func (hb *NodeHeartbeat) Validate() error {
+	var v utils.Validator
+	v.Required("serverId", hb.ServerID)
+	v.Required("agentVersion", hb.AgentVersion)
+	v.MaxLength("agentVersion", hb.AgentVersion, 64)
+	v.MaxLength("wireguardVersion", hb.WireGuardVersion, 64)
+	v.MaxLength("certificateVersion", hb.CertificateVersion, 64)
+	v.OneOf("wireguardImplementation", hb.WireGuardImplementation, wireguard.ImplementationKernel, wireguard.ImplementationUserspace)
+	v.OneOf("obfuscation", hb.Obfuscation, wireguard.TransportWebSocket, wireguard.TransportTCP)
+	validateLatencies(&v, hb.Latencies)
+	if hb.Health != nil {
+		v.MaxLength("health.stateVersion", hb.Health.StateVersion, 64)
+		v.MaxLength("health.error", hb.Health.Error, 1024)
+		v.Check(hb.Health.Peers >= 0 && hb.Health.ActivePeers >= 0, "health", "peer counts must not be negative")
+		v.Check(hb.Health.CPU >= 0 && hb.Health.CPU <= 100, "health.cpu", "must be a percentage")
+		v.Check(hb.Health.Memory >= 0 && hb.Health.Memory <= 100, "health.memory", "must be a percentage")
+		v.Check(hb.Health.ReceiveRate >= 0 && hb.Health.TransmitRate >= 0, "health", "bandwidth must not be negative")
+	}
+	for i, result := range hb.Results {
+		field := fmt.Sprintf("results[%d]", i)
+		v.Required(field+".id", result.ID)
+		v.MaxLength(field+".error", result.Error, 1024)
+	}
+	return v.Err()
+}
+
+// ServerCapabilities describes what the node of a server supports
+type ServerCapabilities struct {
+	WireGuardImplementation string `json:"wireguardImplementation,omitempty"` // kernel or userspace
+	Obfuscation             string `json:"obfuscation,omitempty"`             // transport of the obfuscation endpoint
+}
+
+// HeartbeatResponse tells a node agent which agent version it should run.
+// An empty target means the agent should keep its current version. A
+// certificate is included when the node has an outdated one installed.
+// Agents ping the probes every probe interval and report the results with
+// their next heartbeat, as they do for the commands they run.
+type HeartbeatResponse struct {
+	TargetAgentVersion string           `json:"targetAgentVersion,omitempty"`
+	RolloutID          string           `json:"rolloutId,omitempty"`
+	Certificate        *NodeCertificate `json:"certificate,omitempty"`
+
+	Probes        []LatencyProbe `json:"probes,omitempty"`
+	ProbeInterval int            `json:"probeInterval,omitempty"` // in seconds
+
+	Commands []*NodeCommand `json:"commands,omitempty"`
+}
+
+// NodeVersion represents the software versions last reported by a node
+type NodeVersion struct {
+	ServerID           string    `json:"serverId" db:"server_id"`
+	AgentVersion       string    `json:"agentVersion" db:"agent_version"`
+	WireGuardVersion   string    `json:"wireguardVersion" db:"wireguard_version"`
+	CertificateVersion string    `json:"certificateVersion" db:"certificate_version"`
+	LastHeartbeat      time.Time `json:"lastHeartbeat" db:"last_heartbeat"`
+}
+
+// NodeInventoryEntry represents the software state of a single node
+type NodeInventoryEntry struct {
+	ServerID           string     `json:"serverId"`
+	Name               string     `json:"name"`
+	Status             string     `json:"status"`
+	AgentVersion       string     `json:"agentVersion,omitempty"`
+	WireGuardVersion   string     `json:"wireguardVersion,omitempty"`
+	TargetAgentVersion string     `json:"targetAgentVersion,omitempty"`
+	CertificateVersion string     `json:"certificateVersion,omitempty"`
+	LastHeartbeat      *time.Time `json:"lastHeartbeat,omitempty"`
+	Responsive         bool       `json:"responsive"`
+
+	// WireGuardImplementation is kernel or userspace, once reported
+	WireGuardImplementation string `json:"wireguardImplementation,omitempty"`
+	Obfuscation             string `json:"obfuscation,omitempty"` // transport of the obfuscation endpoint
+
+	Health *NodeHealth `json:"health,omitempty"` // reported by agents that run the server
+}
+
+// NodeInventory represents the software versions running across the fleet
+type NodeInventory struct {
+	Nodes             []*NodeInventoryEntry `json:"nodes"`
+	AgentVersions     map[string]int        `json:"agentVersions"`
+	WireGuardVersions map[string]int        `json:"wireguardVersions"`
+}
+
+// Heartbeat records the versions reported by a node agent and returns the
+// agent version the node should be running
+func (sm *ServerManager) Heartbeat(heartbeat NodeHeartbeat) (*HeartbeatResponse, error) {
+	heartbeat.AgentVersion = strings.TrimSpace(heartbeat.AgentVersion)
+	heartbeat.WireGuardVersion = strings.TrimSpace(heartbeat.WireGuardVersion)
+	heartbeat.CertificateVersion = strings.TrimSpace(heartbeat.CertificateVersion)
+	if heartbeat.AgentVersion == "" {
+		return nil, fmt.Errorf("agent version is required")
+	}
+
+	if _, err := sm.GetServer(heartbeat.ServerID); err != nil {
+		return nil, err
+	}
+
+	version := &NodeVersion{
+		ServerID:           heartbeat.ServerID,
+		AgentVersion:       heartbeat.AgentVersion,
+		WireGuardVersion:   heartbeat.WireGuardVersion,
+		CertificateVersion: heartbeat.CertificateVersion,
+		LastHeartbeat:      time.Now(),
+	}
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`INSERT INTO node_versions (server_id, agent_version, wireguard_version, certificate_version, last_heartbeat) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (server_id) DO UPDATE SET agent_version = $2, wireguard_version = $3, certificate_version = $4, last_heartbeat = $5`,
+			version.ServerID, version.AgentVersion, version.WireGuardVersion, version.CertificateVersion, version.LastHeartbeat,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save node version: %v", err)
+		}
+	}
+
+	sm.mutex.Lock()
+	previous := sm.versions[heartbeat.ServerID]
+	sm.versions[heartbeat.ServerID] = version
+	sm.mutex.Unlock()
+
+	// Log version changes
+	if previous != nil && (previous.AgentVersion != version.AgentVersion || previous.WireGuardVersion != version.WireGuardVersion) {
+		utils.LogInfo("Node %s now runs agent %s, WireGuard %s", version.ServerID, version.AgentVersion, version.WireGuardVersion)
+
+		// Log analytics
+		utils.LogAnalytics("system", "node_version_change", fmt.Sprintf("server=%s agent=%s wireguard=%s", version.ServerID, version.AgentVersion, version.WireGuardVersion))
+	}
+
+	// Record what the node supports
+	if heartbeat.WireGuardImplementation != "" {
+		sm.setCapabilities(heartbeat.ServerID, ServerCapabilities{
+			WireGuardImplementation: heartbeat.WireGuardImplementation,
+			Obfuscation:             heartbeat.Obfuscation,
+		})
+	}
+
+	// Record probe latencies for the latency matrix
+	sm.latency.Record(heartbeat.ServerID, heartbeat.Latencies)
+
+	// Record the health of the server and the outcome of commands. Agents
+	// that do not report health bring their server back online with any
+	// heartbeat.
+	if heartbeat.Health != nil {
+		sm.recordHealth(heartbeat.ServerID, heartbeat.Health)
+	} else if sm.serverStatus(heartbeat.ServerID) == "offline" {
+		utils.LogInfo("Node %s sends heartbeats again", heartbeat.ServerID)
+		sm.UpdateServerStatus(heartbeat.ServerID, "online")
+	}
+	sm.CompleteNodeCommands(heartbeat.ServerID, heartbeat.Results)
+
+	target, rolloutID := sm.rollouts.TargetVersion(heartbeat.ServerID)
+	response := &HeartbeatResponse{}
+	if target != "" && target != version.AgentVersion {
+		response.TargetAgentVersion = target
+		response.RolloutID = rolloutID
+	}
+
+	// Tell the agent which probes to measure
+	if probes := sm.latency.Probes(); len(probes) > 0 {
+		response.Probes = probes
+		response.ProbeInterval = sm.config.Nodes.ProbeInterval
+	}
+
+	// Send the current node certificate to nodes without it
+	if sm.certificates != nil {
+		if cert := sm.certificates.Current(); cert != nil && cert.Version != version.CertificateVersion {
+			response.Certificate = cert
+		}
+	}
+
+	return response, nil
+}
+
+// setCapabilities records the capabilities a node agent reported, logging
+// changes
+func (sm *ServerManager) setCapabilities(serverID string, capabilities ServerCapabilities) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	server, ok := sm.servers[serverID]
+	if !ok || server.Capabilities == capabilities {
+		return
+	}
+	server.Capabilities = capabilities
+	sm.changed()
+
+	utils.LogInfo("Node %s uses the %s WireGuard implementation", serverID, capabilities.WireGuardImplementation)
+	if capabilities.Obfuscation != "" {
+		utils.LogInfo("Node %s has a %s obfuscation endpoint", serverID, capabilities.Obfuscation)
+	}
+}
+
+// Inventory gets the software versions last reported by every node
+func (sm *ServerManager) Inventory() *NodeInventory {
+	inventory := &NodeInventory{
+		Nodes:             make([]*NodeInventoryEntry, 0),
+		AgentVersions:     make(map[string]int),
+		WireGuardVersions: make(map[string]int),
+	}
+
+	sm.mutex.RLock()
+	for id, server := range sm.servers {
+		entry := &NodeInventoryEntry{
+			ServerID: id,
+			Name:     server.Name,
+			Status:   server.Status,
+
+			WireGuardImplementation: server.Capabilities.WireGuardImplementation,
+			Obfuscation:             server.Capabilities.Obfuscation,
+		}
+		if health, ok := sm.health[id]; ok {
+			copied := *health
+			entry.Health = &copied
+		}
+		if version, ok := sm.versions[id]; ok {
+			lastHeartbeat := version.LastHeartbeat
+			entry.AgentVersion = version.AgentVersion
+			entry.WireGuardVersion = version.WireGuardVersion
+			entry.CertificateVersion = version.CertificateVersion
+			entry.LastHeartbeat = &lastHeartbeat
+			entry.Responsive = time.Since(lastHeartbeat) < sm.heartbeatTimeout()
+
+			inventory.AgentVersions[version.AgentVersion]++
+			if version.WireGuardVersion != "" {
+				inventory.WireGuardVersions[version.WireGuardVersion]++
+			}
+		}
+		inventory.Nodes = append(inventory.Nodes, entry)
+	}
+	sm.mutex.RUnlock()
+
+	// Add the version each node is being moved to
+	for _, entry := range inventory.Nodes {
+		entry.TargetAgentVersion, _ = sm.rollouts.TargetVersion(entry.ServerID)
+	}
+
+	sort.Slice(inventory.Nodes, func(i, j int) bool {
+		return inventory.Nodes[i].ServerID < inventory.Nodes[j].ServerID
+	})
+
+	return inventory
+}
+
+// NodeVersion gets the versions last reported by a node
+func (sm *ServerManager) NodeVersion(serverID string) (NodeVersion, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	version, ok := sm.versions[serverID]
+	if !ok {
+		return NodeVersion{}, false
+	}
+	return *version, true
+}
+
+// nodeResponsive checks whether a node is online and sent a recent heartbeat
+func (sm *ServerManager) nodeResponsive(serverID string) bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	server, ok := sm.servers[serverID]
+	if !ok || server.Status != "online" {
+		return false
+	}
+
+	version, ok := sm.versions[serverID]
+	return ok && time.Since(version.LastHeartbeat) < sm.heartbeatTimeout()
+}
+
+// heartbeatTimeout gets how long a node may go without a heartbeat
+func (sm *ServerManager) heartbeatTimeout() time.Duration {
+	if sm.config.Nodes.HeartbeatTimeout <= 0 {
+		return 90 * time.Second
+	}
+	return time.Duration(sm.config.Nodes.HeartbeatTimeout) * time.Second
+}
+
+// serverStatus gets the status of a server, empty when it does not exist
+func (sm *ServerManager) serverStatus(serverID string) string {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if server, ok := sm.servers[serverID]; ok {
+		return server.Status
+	}
+	return ""
+}
+
+// heartbeatInterval gets how often node agents send heartbeats
+func (sm *ServerManager) heartbeatInterval() time.Duration {
+	if sm.config.Nodes.HeartbeatInterval <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(sm.config.Nodes.HeartbeatInterval) * time.Second
+}
+
+// loadVersions reads the last reported node versions from the database
+func (sm *ServerManager) loadVersions() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	versions := []*NodeVersion{}
+	if err := db.DB.Select(&versions, `SELECT server_id, agent_version, wireguard_version, certificate_version, last_heartbeat FROM node_versions`); err != nil {
+		return fmt.Errorf("failed to query node versions: %v", err)
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	for _, version := range versions {
+		sm.versions[version.ServerID] = version
+	}
+
+	return nil
+}
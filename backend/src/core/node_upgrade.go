@@ -0,0 +1,444 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Node upgrade statuses
+const (
+	UpgradeStatusRunning   = "running"
+	UpgradeStatusCompleted = "completed"
+	UpgradeStatusFailed    = "failed"
+	UpgradeStatusAborted   = "aborted"
+)
+
+// Steps of a server in a node upgrade
+const (
+	UpgradeStepPending   = "pending"
+	UpgradeStepDraining  = "draining"  // peers are moving to other servers
+	UpgradeStepUpgrading = "upgrading" // the agent runs the upgrade command
+	UpgradeStepChecking  = "checking"  // the node must stay healthy on the new version
+	UpgradeStepDone      = "done"
+	UpgradeStepSkipped   = "skipped" // not online, or already on the version
+	UpgradeStepFailed    = "failed"
+)
+
+// upgradeInterval is how often the upgrade in progress advances
+const upgradeInterval = 30 * time.Second
+
+// NodeUpgrade represents a rolling upgrade of the node agent across regions.
+// Regions are upgraded one at a time and, within a region, one server at a
+// time: the server is drained, upgraded with an agent command, checked to
+// stay healthy on the new version and then takes connects again.
+type NodeUpgrade struct {
+	ID          string           `json:"id"`
+	Version     string           `json:"version"`
+	Status      string           `json:"status"`
+	Error       string           `json:"error,omitempty"`
+	DrainWindow int              `json:"drainWindow"` // in minutes active sessions keep their server
+	CreatedBy   string           `json:"createdBy,omitempty"`
+	StartedAt   time.Time        `json:"startedAt"`
+	FinishedAt  *time.Time       `json:"finishedAt,omitempty"`
+	Upgraded    int              `json:"upgraded"` // servers done or skipped
+	Total       int              `json:"total"`
+	Regions     []*UpgradeRegion `json:"regions"`
+}
+
+// UpgradeRegion represents the servers of a country in a node upgrade
+type UpgradeRegion struct {
+	Country string           `json:"country"`
+	Status  string           `json:"status"` // pending, running, done or failed
+	Servers []*UpgradeServer `json:"servers"`
+}
+
+// UpgradeServer represents the progress of a server in a node upgrade
+type UpgradeServer struct {
+	ServerID      string     `json:"serverId"`
+	Name          string     `json:"name"`
+	Step          string     `json:"step"`
+	CommandID     string     `json:"commandId,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	StepStartedAt *time.Time `json:"stepStartedAt,omitempty"`
+	HealthySince  *time.Time `json:"healthySince,omitempty"`
+}
+
+// finished checks whether a server is through the upgrade
+func (s *UpgradeServer) finished() bool {
+	return s.Step == UpgradeStepDone || s.Step == UpgradeStepSkipped || s.Step == UpgradeStepFailed
+}
+
+// NodeUpgradeManager orchestrates rolling node upgrades. Upgrades are kept
+// in memory, like server drains.
+type NodeUpgradeManager struct {
+	config   *config.Config
+	servers  *ServerManager
+	upgrades map[string]*NodeUpgrade
+	active   *NodeUpgrade
+	mutex    sync.RWMutex
+}
+
+// NewNodeUpgradeManager creates a new node upgrade manager
+func NewNodeUpgradeManager(cfg *config.Config, servers *ServerManager) *NodeUpgradeManager {
+	return &NodeUpgradeManager{
+		config:   cfg,
+		servers:  servers,
+		upgrades: make(map[string]*NodeUpgrade),
+		mutex:    sync.RWMutex{},
+	}
+}
+
+// StartUpgrade starts upgrading the servers of regions to an agent version,
+// region by region in the order given. Without regions every country is
+// upgraded, in alphabetical order.
+func (um *NodeUpgradeManager) StartUpgrade(version string, regions []string, drainWindow int, actor string) (*NodeUpgrade, error) {
+	var v utils.Validator
+	ValidateAgentVersion(&v, NodeCommandUpgrade, version)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	byCountry := make(map[string][]*UpgradeServer)
+	for _, server := range um.servers.GetServers() {
+		byCountry[server.Country] = append(byCountry[server.Country], &UpgradeServer{
+			ServerID: server.ID,
+			Name:     server.Name,
+			Step:     UpgradeStepPending,
+		})
+	}
+
+	if len(regions) == 0 {
+		for country := range byCountry {
+			regions = append(regions, country)
+		}
+		sort.Strings(regions)
+	}
+
+	upgrade := &NodeUpgrade{
+		ID:          utils.GenerateUUID(),
+		Version:     version,
+		Status:      UpgradeStatusRunning,
+		DrainWindow: drainWindow,
+		CreatedBy:   actor,
+		StartedAt:   time.Now(),
+		Regions:     make([]*UpgradeRegion, 0, len(regions)),
+	}
+
+	seen := make(map[string]bool)
+	for _, country := range regions {
+		servers, ok := byCountry[country]
+		if !ok {
+			return nil, fmt.Errorf("no servers in region %s", country)
+		}
+		if seen[country] {
+			continue
+		}
+		seen[country] = true
+
+		sort.Slice(servers, func(i, j int) bool {
+			return servers[i].ServerID < servers[j].ServerID
+		})
+		upgrade.Regions = append(upgrade.Regions, &UpgradeRegion{Country: country, Servers: servers})
+	}
+	if len(upgrade.Regions) == 0 {
+		return nil, fmt.Errorf("no servers to upgrade")
+	}
+
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+
+	if um.active != nil {
+		return nil, fmt.Errorf("upgrade %s is still in progress", um.active.ID)
+	}
+
+	um.upgrades[upgrade.ID] = upgrade
+	um.active = upgrade
+
+	utils.LogInfo("Started upgrade %s of %d regions to agent %s", upgrade.ID, len(upgrade.Regions), version)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "node_upgrade_start", fmt.Sprintf("upgrade=%s version=%s regions=%d", upgrade.ID, version, len(upgrade.Regions)))
+
+	// Drain the first server right away
+	um.advance(upgrade)
+
+	return um.snapshot(upgrade), nil
+}
+
+// AbortUpgrade stops an upgrade in progress. The server being upgraded
+// takes connects again; an upgrade command it was already sent still runs.
+func (um *NodeUpgradeManager) AbortUpgrade(id, actor string) (*NodeUpgrade, error) {
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+
+	upgrade, ok := um.upgrades[id]
+	if !ok {
+		return nil, fmt.Errorf("upgrade not found: %s", id)
+	}
+	if upgrade != um.active {
+		return nil, fmt.Errorf("upgrade is not in progress: %s", id)
+	}
+
+	if server := upgrade.current(); server != nil && server.Step != UpgradeStepPending {
+		if err := um.servers.StopDrain(server.ServerID, actor); err != nil {
+			utils.LogWarning("Failed to re-enable server %s after aborting upgrade %s: %v", server.ServerID, id, err)
+		}
+		server.Error = "aborted during " + server.Step
+	}
+
+	um.finish(upgrade, UpgradeStatusAborted, "aborted by "+actor)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "node_upgrade_abort", fmt.Sprintf("upgrade=%s version=%s", upgrade.ID, upgrade.Version))
+
+	return um.snapshot(upgrade), nil
+}
+
+// GetUpgrade gets an upgrade by ID
+func (um *NodeUpgradeManager) GetUpgrade(id string) (*NodeUpgrade, error) {
+	um.mutex.RLock()
+	defer um.mutex.RUnlock()
+
+	upgrade, ok := um.upgrades[id]
+	if !ok {
+		return nil, fmt.Errorf("upgrade not found: %s", id)
+	}
+
+	return um.snapshot(upgrade), nil
+}
+
+// ListUpgrades gets all upgrades, most recent first
+func (um *NodeUpgradeManager) ListUpgrades() []*NodeUpgrade {
+	um.mutex.RLock()
+	defer um.mutex.RUnlock()
+
+	upgrades := make([]*NodeUpgrade, 0, len(um.upgrades))
+	for _, upgrade := range um.upgrades {
+		upgrades = append(upgrades, um.snapshot(upgrade))
+	}
+
+	sort.Slice(upgrades, func(i, j int) bool {
+		return upgrades[i].StartedAt.After(upgrades[j].StartedAt)
+	})
+
+	return upgrades
+}
+
+// RunUpgrades periodically advances the upgrade in progress
+func (um *NodeUpgradeManager) RunUpgrades() {
+	ticker := time.NewTicker(upgradeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		um.mutex.Lock()
+		if um.active != nil {
+			um.advance(um.active)
+		}
+		um.mutex.Unlock()
+	}
+}
+
+// current gets the server being upgraded, nil when all are through
+func (upgrade *NodeUpgrade) current() *UpgradeServer {
+	for _, region := range upgrade.Regions {
+		for _, server := range region.Servers {
+			if !server.finished() {
+				return server
+			}
+		}
+	}
+	return nil
+}
+
+// advance moves the server being upgraded through its steps, completing
+// the upgrade once every server is through and failing it when a server
+// does not upgrade. A failed server is left draining. The caller holds
+// um.mutex.
+func (um *NodeUpgradeManager) advance(upgrade *NodeUpgrade) {
+	for {
+		server := upgrade.current()
+		if server == nil {
+			um.finish(upgrade, UpgradeStatusCompleted, "")
+			return
+		}
+
+		step := server.Step
+		if err := um.step(upgrade, server); err != nil {
+			server.Step = UpgradeStepFailed
+			server.Error = err.Error()
+			um.finish(upgrade, UpgradeStatusFailed, fmt.Sprintf("server %s: %v", server.ServerID, err))
+			return
+		}
+
+		// Servers skipped do not hold up the next one
+		if server.Step != UpgradeStepSkipped || step != UpgradeStepPending {
+			return
+		}
+	}
+}
+
+// step moves a server to its next step when the current one is through
+func (um *NodeUpgradeManager) step(upgrade *NodeUpgrade, server *UpgradeServer) error {
+	now := time.Now()
+	timeout := time.Duration(um.config.Nodes.RolloutTimeout) * time.Minute
+
+	switch server.Step {
+	case UpgradeStepPending:
+		if status := um.servers.serverStatus(server.ServerID); status != "online" {
+			server.Step = UpgradeStepSkipped
+			server.Error = "server is " + status
+			return nil
+		}
+		if version, _ := um.servers.NodeVersion(server.ServerID); version.AgentVersion == upgrade.Version {
+			server.Step = UpgradeStepSkipped
+			return nil
+		}
+
+		window := time.Duration(upgrade.DrainWindow) * time.Minute
+		if _, err := um.servers.StartDrain(server.ServerID, window, upgrade.CreatedBy); err != nil {
+			return err
+		}
+		um.enter(server, UpgradeStepDraining, now)
+
+	case UpgradeStepDraining:
+		drain, ok := um.servers.Drain(server.ServerID)
+		if !ok {
+			return fmt.Errorf("drain was cancelled")
+		}
+		// Active peers move on the first drain pass after the deadline
+		if !drain.Empty && now.Before(drain.Deadline.Add(drainInterval)) {
+			return nil
+		}
+		if !drain.Empty {
+			utils.LogWarning("Upgrading server %s with %d peers left that found no other server", server.ServerID, drain.Peers)
+		}
+
+		command, err := um.servers.QueueNodeCommand(server.ServerID, NodeCommandUpgrade, upgrade.Version, upgrade.CreatedBy)
+		if err != nil {
+			return err
+		}
+		server.CommandID = command.ID
+		um.enter(server, UpgradeStepUpgrading, now)
+
+	case UpgradeStepUpgrading:
+		if version, _ := um.servers.NodeVersion(server.ServerID); version.AgentVersion == upgrade.Version {
+			um.enter(server, UpgradeStepChecking, now)
+			return nil
+		}
+		if command, ok := um.servers.NodeCommands().Get(server.CommandID); ok && command.Status == NodeCommandFailed {
+			return fmt.Errorf("upgrade command failed: %s", command.Error)
+		}
+		if now.Sub(*server.StepStartedAt) > timeout {
+			return fmt.Errorf("did not report version %s in time", upgrade.Version)
+		}
+
+	case UpgradeStepChecking:
+		if !um.healthy(server.ServerID, upgrade.Version) {
+			server.HealthySince = nil
+			if now.Sub(*server.StepStartedAt) > timeout {
+				return fmt.Errorf("did not become healthy on version %s in time", upgrade.Version)
+			}
+			return nil
+		}
+		if server.HealthySince == nil {
+			server.HealthySince = &now
+		}
+		if now.Sub(*server.HealthySince) < time.Duration(um.config.Nodes.UpgradeCheck)*time.Minute {
+			return nil
+		}
+
+		// Take connects again
+		if err := um.servers.StopDrain(server.ServerID, upgrade.CreatedBy); err != nil {
+			return err
+		}
+		um.enter(server, UpgradeStepDone, now)
+		utils.LogInfo("Upgraded server %s to agent %s", server.ServerID, upgrade.Version)
+	}
+
+	return nil
+}
+
+// enter moves a server to a step
+func (um *NodeUpgradeManager) enter(server *UpgradeServer, step string, now time.Time) {
+	server.Step = step
+	server.StepStartedAt = &now
+}
+
+// healthy checks whether a node sends heartbeats on a version with its
+// WireGuard interfaces up and its node state applied
+func (um *NodeUpgradeManager) healthy(serverID, version string) bool {
+	nodeVersion, ok := um.servers.NodeVersion(serverID)
+	if !ok || nodeVersion.AgentVersion != version || time.Since(nodeVersion.LastHeartbeat) >= um.servers.heartbeatTimeout() {
+		return false
+	}
+
+	health, ok := um.servers.NodeHealth(serverID)
+	return ok && health.Up && health.Error == ""
+}
+
+// finish ends an upgrade with a final status. The caller holds um.mutex.
+func (um *NodeUpgradeManager) finish(upgrade *NodeUpgrade, status, reason string) {
+	now := time.Now()
+	upgrade.Status = status
+	upgrade.Error = reason
+	upgrade.FinishedAt = &now
+	um.active = nil
+
+	if status == UpgradeStatusFailed {
+		utils.LogError("Upgrade %s to agent %s failed: %s", upgrade.ID, upgrade.Version, reason)
+	} else {
+		utils.LogInfo("Upgrade %s to agent %s %s", upgrade.ID, upgrade.Version, status)
+	}
+
+	// Log analytics
+	utils.LogAnalytics("system", "node_upgrade_"+status, fmt.Sprintf("upgrade=%s version=%s", upgrade.ID, upgrade.Version))
+}
+
+// snapshot copies an upgrade with the progress of its regions
+func (um *NodeUpgradeManager) snapshot(upgrade *NodeUpgrade) *NodeUpgrade {
+	copied := *upgrade
+	copied.Regions = make([]*UpgradeRegion, 0, len(upgrade.Regions))
+	copied.Upgraded, copied.Total = 0, 0
+
+	for _, region := range upgrade.Regions {
+		regionCopy := &UpgradeRegion{Country: region.Country, Servers: make([]*UpgradeServer, 0, len(region.Servers))}
+		finished, started, failed := 0, false, false
+		for _, server := range region.Servers {
+			serverCopy := *server
+			regionCopy.Servers = append(regionCopy.Servers, &serverCopy)
+
+			copied.Total++
+			if server.Step == UpgradeStepDone || server.Step == UpgradeStepSkipped {
+				copied.Upgraded++
+			}
+			if server.finished() {
+				finished++
+			}
+			if server.Step != UpgradeStepPending {
+				started = true
+			}
+			if server.Step == UpgradeStepFailed {
+				failed = true
+			}
+		}
+
+		switch {
+		case failed:
+			regionCopy.Status = "failed"
+		case finished == len(region.Servers):
+			regionCopy.Status = "done"
+		case started:
+			regionCopy.Status = "running"
+		default:
+			regionCopy.Status = "pending"
+		}
+		copied.Regions = append(copied.Regions, regionCopy)
+	}
+
+	return &copied
+}
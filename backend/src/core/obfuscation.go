@@ -0,0 +1,25 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// checkObfuscation checks the server of an obfuscated device runs an
+// obfuscation endpoint
+func checkObfuscation(server *Server, opts wireguard.PeerOptions) error {
+	if opts.Obfuscated && server.Capabilities.Obfuscation == "" {
+		return fmt.Errorf("server %s has no obfuscation endpoint", server.ID)
+	}
+	return nil
+}
+
+// Obfuscation gets how an obfuscated device reaches its server through the
+// obfuscation endpoint, or nil for other devices
+func (vm *VPNManager) Obfuscation(peer *wireguard.PeerConfig) *wireguard.ObfuscationEndpoint {
+	if !peer.Obfuscated {
+		return nil
+	}
+	return wireguard.ClientObfuscation(vm.config)
+}
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// UnassignedPeerOwner owns imported peers that are not mapped to a user.
+// Importing the config again with a mapping moves them to their users.
+const UnassignedPeerOwner = "unassigned"
+
+// Peer import statuses
+const (
+	PeerImportStatusImported = "imported"
+	PeerImportStatusMoved    = "moved"
+	PeerImportStatusSkipped  = "skipped"
+	PeerImportStatusFailed   = "failed"
+)
+
+// PeerImportResult represents the outcome of importing a single [Peer]
+// section
+type PeerImportResult struct {
+	Line      int    `json:"line"`
+	Name      string `json:"name,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	UserID    string `json:"userId,omitempty"`
+	PeerID    string `json:"peerId,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PeerImportSummary summarises a peer import
+type PeerImportSummary struct {
+	ServerID string              `json:"serverId"`
+	Total    int                 `json:"total"`
+	Imported int                 `json:"imported"`
+	Moved    int                 `json:"moved"`
+	Skipped  int                 `json:"skipped"`
+	Failed   int                 `json:"failed"`
+	Results  []*PeerImportResult `json:"results"`
+}
+
+// ImportPeers imports the peers of an existing server config onto a
+// server, easing migration from hand-managed servers. Each peer goes to the
+// user its public key or name is mapped to in users, then to
+// defaultUserID, then to UnassignedPeerOwner; peers imported unassigned
+// before are moved once they are mapped. Peers already known are skipped.
+// Mapped users must exist; problems with them are returned as a validation
+// error.
+func (vm *VPNManager) ImportPeers(ctx context.Context, adminID, serverID, content string, users map[string]string, defaultUserID string) (*PeerImportSummary, error) {
+	server, err := vm.serverManager.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the mapped users exist
+	var v utils.Validator
+	if vm.userManager != nil {
+		checked := make(map[string]bool)
+		check := func(field, userID string) {
+			if userID == "" || checked[userID] {
+				return
+			}
+			checked[userID] = true
+			if _, err := vm.userManager.GetUser(userID); err != nil {
+				v.Check(false, field, fmt.Sprintf("user not found: %s", userID))
+			}
+		}
+		check("defaultUserId", defaultUserID)
+		for key, userID := range users {
+			check("users."+key, userID)
+		}
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	owner := func(peer wireguard.ImportedPeer) string {
+		if userID := users[peer.PublicKey]; userID != "" {
+			return userID
+		}
+		if userID := users[peer.Name]; peer.Name != "" && userID != "" {
+			return userID
+		}
+		if defaultUserID != "" {
+			return defaultUserID
+		}
+		return UnassignedPeerOwner
+	}
+
+	summary := &PeerImportSummary{ServerID: server.ID, Results: make([]*PeerImportResult, 0)}
+
+	parsed, problems := wireguard.ParseServerConfig(content)
+	for _, problem := range problems {
+		summary.Results = append(summary.Results, &PeerImportResult{
+			Line:   problem.Line,
+			Status: PeerImportStatusFailed,
+			Error:  problem.Message,
+		})
+	}
+
+	// Move peers imported unassigned before and skip other known keys
+	pending := make([]wireguard.ImportedPeer, 0, len(parsed))
+	pendingResults := make([]*PeerImportResult, 0, len(parsed))
+	for _, peer := range parsed {
+		peer.UserID = owner(peer)
+		result := &PeerImportResult{
+			Line:      peer.Line,
+			Name:      peer.Name,
+			PublicKey: peer.PublicKey,
+			IP:        peer.IP,
+			UserID:    peer.UserID,
+		}
+		summary.Results = append(summary.Results, result)
+
+		existing, err := vm.peerManager.FindPeerByPublicKey(peer.PublicKey)
+		switch {
+		case err != nil:
+			result.Status = PeerImportStatusFailed
+			result.Error = fmt.Sprintf("failed to check public key: %v", err)
+		case existing == nil:
+			pending = append(pending, peer)
+			pendingResults = append(pendingResults, result)
+		case existing.UserID == UnassignedPeerOwner && peer.UserID != UnassignedPeerOwner:
+			moved, err := vm.peerManager.MovePeer(UnassignedPeerOwner, existing.ID, peer.UserID)
+			if err != nil {
+				result.Status = PeerImportStatusFailed
+				result.Error = fmt.Sprintf("failed to move peer: %v", err)
+				continue
+			}
+			result.PeerID = moved.ID
+			result.Status = PeerImportStatusMoved
+		default:
+			result.PeerID = existing.ID
+			result.UserID = existing.UserID
+			result.Status = PeerImportStatusSkipped
+			result.Error = "public key is already in use"
+		}
+	}
+
+	// Save and apply the new peers
+	if len(pending) > 0 {
+		imported, errs, err := vm.peerManager.ImportPeers(ctx, server.ID, pending)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import peers: %v", err)
+		}
+		for i, result := range pendingResults {
+			if errs[i] != nil {
+				result.Status = PeerImportStatusFailed
+				result.Error = errs[i].Error()
+				continue
+			}
+			result.PeerID = imported[i].ID
+			result.Status = PeerImportStatusImported
+		}
+	}
+
+	sort.SliceStable(summary.Results, func(i, j int) bool {
+		return summary.Results[i].Line < summary.Results[j].Line
+	})
+	for _, result := range summary.Results {
+		summary.Total++
+		switch result.Status {
+		case PeerImportStatusImported:
+			summary.Imported++
+		case PeerImportStatusMoved:
+			summary.Moved++
+		case PeerImportStatusSkipped:
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+
+	// Update server load
+	if summary.Imported > 0 {
+		vm.serverManager.UpdateServerLoad(server.ID, server.Load+summary.Imported)
+	}
+
+	utils.LogInfoContext(ctx, "Imported peers on server %s: total=%d imported=%d moved=%d skipped=%d failed=%d",
+		server.ID, summary.Total, summary.Imported, summary.Moved, summary.Skipped, summary.Failed)
+
+	// Log analytics
+	utils.LogAnalytics(adminID, "peers_imported", fmt.Sprintf("server=%s imported=%d moved=%d", server.ID, summary.Imported, summary.Moved))
+
+	return summary, nil
+}
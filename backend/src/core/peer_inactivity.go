@@ -0,0 +1,175 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// inactivitySweepInterval is how often static peers are checked for inactivity
+const inactivitySweepInterval = time.Hour
+
+// RunInactivitySweeper periodically tells users about devices that have not
+// connected for inactivity.notifyAfter days and archives devices idle for
+// inactivity.archiveAfter days
+func (vm *VPNManager) RunInactivitySweeper() {
+	if vm.config.Inactivity.NotifyAfter <= 0 && vm.config.Inactivity.ArchiveAfter <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(inactivitySweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		vm.sweepInactivePeers()
+	}
+}
+
+// sweepInactivePeers records handshakes of static peers and notifies about
+// or archives the idle ones
+func (vm *VPNManager) sweepInactivePeers() {
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	peers, err := vm.peerManager.ListPeers()
+	if err != nil {
+		utils.LogError("Failed to list peers: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	handshakes := vm.peerManager.LatestHandshakes(ctx)
+	now := time.Now()
+	notifyAfter := days(vm.config.Inactivity.NotifyAfter)
+	archiveAfter := days(vm.config.Inactivity.ArchiveAfter)
+
+	for _, peer := range peers {
+		if peer.Archived() || peer.Pending() {
+			continue
+		}
+
+		// Peers that never connected count from their creation
+		lastActive := peer.LastActive
+		if lastActive.IsZero() {
+			lastActive = peer.CreatedAt
+		}
+		notified := peer.InactiveNotified
+
+		// A new handshake resets the inactivity notice
+		if handshake, ok := handshakes[peer.PublicKey]; ok && handshake.After(lastActive) {
+			if err := vm.peerManager.RecordActivity(peer, handshake, time.Time{}); err != nil {
+				utils.LogError("Failed to record activity of peer %s: %v", peer.ID, err)
+			}
+			continue
+		}
+
+		idle := now.Sub(lastActive)
+		event := PeerEvent{
+			UserID:       peer.UserID,
+			PeerID:       peer.ID,
+			ServerID:     peer.ServerID,
+			Device:       peer.DeviceName,
+			InactiveDays: int(idle / (24 * time.Hour)),
+		}
+
+		if archiveAfter > 0 && idle >= archiveAfter {
+			if _, err := vm.peerManager.ArchivePeer(ctx, peer.UserID, peer.ID); err != nil {
+				utils.LogError("Failed to archive inactive peer %s: %v", peer.ID, err)
+				continue
+			}
+			vm.serverManager.UpdateServerLoad(peer.ServerID, 0)
+			vm.events.Publish(EventPeerArchived, event)
+
+			// Log analytics
+			utils.LogAnalytics(peer.UserID, "vpn_peer_archived", fmt.Sprintf("peer=%s days=%d", peer.ID, event.InactiveDays))
+			continue
+		}
+
+		if notifyAfter > 0 && idle >= notifyAfter && notified.IsZero() {
+			if err := vm.peerManager.RecordActivity(peer, lastActive, now); err != nil {
+				utils.LogError("Failed to record inactivity notice of peer %s: %v", peer.ID, err)
+				continue
+			}
+			vm.events.Publish(EventPeerInactive, event)
+
+			// Log analytics
+			utils.LogAnalytics(peer.UserID, "vpn_peer_inactive", fmt.Sprintf("peer=%s days=%d", peer.ID, event.InactiveDays))
+		}
+	}
+}
+
+// ReactivatePeer restores a peer archived for inactivity and returns its
+// new configuration. The device counts against the user's plan again.
+func (vm *VPNManager) ReactivatePeer(ctx context.Context, userID, peerID string) (peer *wireguard.PeerConfig, config string, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Connect)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.ReactivatePeer", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "reactivate"); err != nil {
+		return nil, "", err
+	}
+
+	// Get peer
+	peer, err = vm.peerManager.GetPeer(userID, peerID)
+	if err != nil || peer.Dynamic {
+		return nil, "", fmt.Errorf("peer not found: %s", peerID)
+	}
+	if !peer.Archived() {
+		return nil, "", fmt.Errorf("peer is not archived: %s", peerID)
+	}
+
+	// Get server
+	server, err := vm.serverManager.GetServer(peer.ServerID)
+	if err != nil {
+		return nil, "", fmt.Errorf("server not found: %s", peer.ServerID)
+	}
+	if server.Status != "online" {
+		return nil, "", fmt.Errorf("server is not online: %s", peer.ServerID)
+	}
+
+	// Check plan entitlements
+	if err := vm.checkEntitlements(ctx, userID, peer.PeerOptions); err != nil {
+		return nil, "", err
+	}
+
+	// Restore peer
+	peer, err = vm.peerManager.ReactivatePeer(ctx, userID, peerID)
+	if err != nil {
+		if ctxErr := contextError(ctx, "reactivate"); ctxErr != nil {
+			return nil, "", ctxErr
+		}
+		return nil, "", fmt.Errorf("failed to reactivate peer: %v", err)
+	}
+
+	// Generate configuration
+	config, err = vm.renderConfig(peer, "reactivate")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate configuration: %v", err)
+	}
+
+	// Update server load
+	vm.serverManager.UpdateServerLoad(server.ID, server.Load+1)
+	utils.LogInfoContext(ctx, "Reactivated peer %s on server %s", peer.ID, server.ID)
+	vm.events.Publish(EventPeerReactivated, PeerEvent{UserID: userID, PeerID: peer.ID, ServerID: server.ID, Device: peer.DeviceName})
+
+	// Log analytics
+	utils.LogAnalytics(userID, "vpn_peer_reactivated", fmt.Sprintf("peer=%s", peer.ID))
+
+	return peer, config, nil
+}
+
+// days converts a number of days to a duration
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// RunPeerMigration moves the peers of servers that stay offline for
+// wireguard.migration.grace seconds to healthy servers in the same country,
+// until the subscription ends
+func (vm *VPNManager) RunPeerMigration(events *EventBus) {
+	if !vm.config.WireGuard.Migration.Enabled {
+		return
+	}
+	grace := time.Duration(vm.config.WireGuard.Migration.Grace) * time.Second
+
+	subscription, _, unsubscribe := events.Subscribe(0)
+	defer unsubscribe()
+
+	for event := range subscription {
+		status, ok := event.Data.(ServerStatusEvent)
+		if !ok || status.Status != "offline" {
+			continue
+		}
+
+		serverID := status.ServerID
+		time.AfterFunc(grace, func() {
+			vm.migratePeers(serverID)
+		})
+	}
+}
+
+// migratePeers moves the peers of a server that is still offline to the
+// least loaded online servers in its country. Peers stay put when no
+// server in the country has room.
+func (vm *VPNManager) migratePeers(serverID string) {
+	server, err := vm.serverManager.GetServer(serverID)
+	if err != nil || vm.serverManager.serverStatus(serverID) != "offline" {
+		return
+	}
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	peers, err := vm.serverPeers(serverID)
+	if err != nil {
+		utils.LogError("Failed to list peers of offline server %s: %v", serverID, err)
+		return
+	}
+
+	ctx := context.Background()
+	migrated := 0
+	for _, peer := range peers {
+		target := vm.migrationTarget(server)
+		if target == nil {
+			utils.LogWarning("No online server in %s has room for the peers of offline server %s", server.Country, serverID)
+			break
+		}
+
+		if err := vm.migratePeer(ctx, peer, target, MigrateOffline); err != nil {
+			utils.LogError("Failed to migrate peer %s off offline server %s: %v", peer.ID, serverID, err)
+			continue
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		utils.LogInfo("Migrated %d peers off offline server %s", migrated, serverID)
+
+		// Log analytics
+		utils.LogAnalytics("system", "server_peer_migration", fmt.Sprintf("server=%s peers=%d", serverID, migrated))
+	}
+}
+
+// serverPeers gets the static and dynamic peers of a server. The caller
+// holds vm.mutex.
+func (vm *VPNManager) serverPeers(serverID string) ([]*wireguard.PeerConfig, error) {
+	peers, err := vm.peerManager.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+	dynamicPeers, err := vm.peerManager.ListDynamicPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	onServer := make([]*wireguard.PeerConfig, 0)
+	for _, peer := range append(peers, dynamicPeers...) {
+		if peer.ServerID == serverID {
+			onServer = append(onServer, peer)
+		}
+	}
+	return onServer, nil
+}
+
+// migrationTarget gets the least loaded online server with room in the
+// country of a server, nil when there is none
+func (vm *VPNManager) migrationTarget(server *Server) *Server {
+	for _, candidate := range vm.serverManager.GetFailoverServers(server.Country, server.ID) {
+		if candidate.Country == server.Country {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// migratePeer moves a peer to another server for a reason and announces
+// it, so the user's devices pick up the new config. The caller holds
+// vm.mutex.
+func (vm *VPNManager) migratePeer(ctx context.Context, peer *wireguard.PeerConfig, target *Server, reason string) error {
+	from := peer.ServerID
+	migrated, err := vm.peerManager.MigratePeer(ctx, peer.UserID, peer.ID, target.ID)
+	if migrated == nil {
+		return err
+	}
+	if err != nil {
+		utils.LogWarning("Peer %s moved to server %s, which has yet to apply it: %v", peer.ID, target.ID, err)
+	}
+
+	vm.serverManager.UpdateServerLoad(target.ID, target.Load+1)
+
+	// Regenerate the config, recording it in the config history
+	if !migrated.Pending() && !migrated.Archived() {
+		if _, err := vm.renderConfig(migrated, "migration"); err != nil {
+			utils.LogError("Failed to generate configuration of migrated peer %s: %v", peer.ID, err)
+		}
+	}
+
+	vm.events.Publish(EventPeerMigrated, PeerEvent{
+		UserID:       migrated.UserID,
+		PeerID:       migrated.ID,
+		ServerID:     migrated.ServerID,
+		Dynamic:      migrated.Dynamic,
+		Device:       migrated.DeviceName,
+		Reason:       reason,
+		FromServerID: from,
+	})
+
+	// Log analytics
+	utils.LogAnalytics(peer.UserID, "vpn_peer_migrated", fmt.Sprintf("peer=%s from=%s to=%s reason=%s", peer.ID, from, target.ID, reason))
+
+	return nil
+}
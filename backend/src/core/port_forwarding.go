@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SetPortForward forwards a port of a peer's server to the peer, or stops
+// forwarding it, and returns the peer. Forwarding needs a plan that includes
+// port forwarding; pending and archived peers cannot be forwarded to.
+func (vm *VPNManager) SetPortForward(ctx context.Context, userID, peerID string, enabled bool) (peer *wireguard.PeerConfig, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.SetPortForward", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	if enabled {
+		if err := vm.entitlements.CheckFeature(ctx, userID, models.FeaturePortForwarding); err != nil {
+			return nil, err
+		}
+	}
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "port forward"); err != nil {
+		return nil, err
+	}
+
+	// Get peer
+	peer, err = vm.peerManager.GetPeer(userID, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("peer not found: %s", peerID)
+	}
+	if enabled && (peer.Pending() || peer.Archived()) {
+		return nil, fmt.Errorf("peer is not active: %s", peerID)
+	}
+
+	peer, err = vm.peerManager.SetPortForward(ctx, userID, peerID, enabled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set port forward: %v", err)
+	}
+
+	utils.LogInfoContext(ctx, "Set forwarded port of peer %s to %d", peer.ID, peer.ForwardedPort)
+
+	// Log analytics
+	utils.LogAnalytics(userID, "peer_port_forward_update", fmt.Sprintf("peer=%s port=%d", peer.ID, peer.ForwardedPort))
+
+	return peer, nil
+}
@@ -0,0 +1,525 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/cloudprovider"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Provision statuses
+const (
+	ProvisionCreating   = "creating"   // the machine is being requested from the provider
+	ProvisionBooting    = "booting"    // the machine bootstraps the node agent and registers its server
+	ProvisionRegistered = "registered" // the server is registered, its agent has not reported yet
+	ProvisionReady      = "ready"      // the server is online
+	ProvisionFailed     = "failed"
+	ProvisionDeleting   = "deleting" // the machine is being deleted and its server removed
+	ProvisionDeleted    = "deleted"
+)
+
+// provisionInterval is how often provisions are advanced
+const provisionInterval = 15 * time.Second
+
+// provisionNamePattern matches the names machines can take at every provider
+var provisionNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Provision represents a server created on demand at a cloud provider
+type Provision struct {
+	ID        string    `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Template  string    `json:"template" db:"template"`
+	Provider  string    `json:"provider" db:"provider"`
+	Region    string    `json:"region" db:"region"`
+	MachineID string    `json:"machineId,omitempty" db:"machine_id"`
+	IP        string    `json:"ip,omitempty" db:"ip"`
+	ServerID  string    `json:"serverId,omitempty" db:"server_id"` // once the machine registered its server
+	Status    string    `json:"status" db:"status"`
+	Error     string    `json:"error,omitempty" db:"error"`
+	CreatedBy string    `json:"createdBy" db:"created_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// ProvisioningManager creates servers on cloud providers from the
+// configured templates and tears them down. A machine boots a script that
+// installs the node agent and registers its server; the provision is
+// ready once the server comes online.
+type ProvisioningManager struct {
+	config     *config.Config
+	servers    *ServerManager
+	providers  map[string]cloudprovider.Provider
+	provisions map[string]*Provision
+	mutex      sync.Mutex
+}
+
+// NewProvisioningManager creates a new provisioning manager
+func NewProvisioningManager(cfg *config.Config, servers *ServerManager) *ProvisioningManager {
+	pm := &ProvisioningManager{
+		config:     cfg,
+		servers:    servers,
+		providers:  make(map[string]cloudprovider.Provider),
+		provisions: make(map[string]*Provision),
+		mutex:      sync.Mutex{},
+	}
+
+	if err := pm.load(); err != nil {
+		utils.LogError("Failed to load provisions: %v", err)
+	}
+
+	return pm
+}
+
+// ValidateProvisionName checks the name of a machine to provision, which
+// may be empty to have one generated
+func ValidateProvisionName(v *utils.Validator, name string) {
+	v.Check(name == "" || provisionNamePattern.MatchString(name), "name", "must be lowercase letters, digits and dashes, up to 63 characters")
+}
+
+// StartProvision starts creating a server from a template
+func (pm *ProvisioningManager) StartProvision(templateName, name, actor string) (*Provision, error) {
+	tmpl, ok := pm.config.Provisioning.Templates[templateName]
+	if !ok {
+		return nil, fmt.Errorf("provisioning template not found: %s", templateName)
+	}
+	if pm.config.Provisioning.ControlPlane == "" || pm.config.Provisioning.AgentURL == "" {
+		return nil, fmt.Errorf("provisioning.controlPlane and provisioning.agentUrl are required")
+	}
+	if pm.config.Nodes.RegisterToken == "" || pm.config.Nodes.AgentToken == "" {
+		return nil, fmt.Errorf("nodes.registerToken and nodes.agentToken are required for provisioned nodes")
+	}
+	if _, err := pm.provider(tmpl.Provider); err != nil {
+		return nil, err
+	}
+
+	id := utils.GenerateUUID()
+	if name == "" {
+		name = templateName + "-" + id[:8]
+		if !provisionNamePattern.MatchString(name) {
+			name = "vpn-" + id[:8]
+		}
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	for _, existing := range pm.provisions {
+		if existing.Name == name && existing.Status != ProvisionDeleted {
+			return nil, fmt.Errorf("a provision named %s already exists", name)
+		}
+	}
+
+	now := time.Now()
+	provision := &Provision{
+		ID:        id,
+		Name:      name,
+		Template:  templateName,
+		Provider:  tmpl.Provider,
+		Region:    tmpl.Region,
+		Status:    ProvisionCreating,
+		CreatedBy: actor,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := pm.save(provision); err != nil {
+		return nil, err
+	}
+	pm.provisions[id] = provision
+
+	utils.LogInfo("Provisioning server %s from template %s on %s", name, templateName, tmpl.Provider)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "server_provision", fmt.Sprintf("provision=%s template=%s provider=%s", id, templateName, tmpl.Provider))
+
+	copied := *provision
+	return &copied, nil
+}
+
+// TeardownProvision starts deleting the machine of a provision and removing
+// its server
+func (pm *ProvisioningManager) TeardownProvision(id, actor string) (*Provision, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	existing, ok := pm.provisions[id]
+	if !ok {
+		return nil, fmt.Errorf("provision not found: %s", id)
+	}
+	if existing.Status == ProvisionDeleting || existing.Status == ProvisionDeleted {
+		return nil, fmt.Errorf("provision %s is already %s", id, existing.Status)
+	}
+
+	provision := *existing
+	provision.Status = ProvisionDeleting
+	provision.Error = ""
+	provision.UpdatedAt = time.Now()
+	if err := pm.save(&provision); err != nil {
+		return nil, err
+	}
+	pm.provisions[id] = &provision
+
+	// Log analytics
+	utils.LogAnalytics(actor, "server_teardown", fmt.Sprintf("provision=%s server=%s", id, provision.ServerID))
+
+	copied := provision
+	return &copied, nil
+}
+
+// GetProvision gets a provision by ID
+func (pm *ProvisioningManager) GetProvision(id string) (*Provision, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	provision, ok := pm.provisions[id]
+	if !ok {
+		return nil, fmt.Errorf("provision not found: %s", id)
+	}
+
+	copied := *provision
+	return &copied, nil
+}
+
+// ListProvisions gets all provisions, newest first
+func (pm *ProvisioningManager) ListProvisions() []*Provision {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	provisions := make([]*Provision, 0, len(pm.provisions))
+	for _, provision := range pm.provisions {
+		copied := *provision
+		provisions = append(provisions, &copied)
+	}
+
+	sort.Slice(provisions, func(i, j int) bool {
+		return provisions[i].CreatedAt.After(provisions[j].CreatedAt)
+	})
+
+	return provisions
+}
+
+// RunProvisioning advances provisions in background
+func (pm *ProvisioningManager) RunProvisioning() {
+	ticker := time.NewTicker(provisionInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, provision := range pm.ListProvisions() {
+			switch provision.Status {
+			case ProvisionCreating, ProvisionBooting, ProvisionRegistered, ProvisionDeleting:
+				pm.advance(provision)
+			}
+		}
+	}
+}
+
+// advance moves a provision on a step when it can
+func (pm *ProvisioningManager) advance(provision *Provision) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	provider, err := pm.provider(provision.Provider)
+	if err != nil {
+		pm.fail(provision, err)
+		return
+	}
+
+	timeout := time.Duration(pm.config.Provisioning.Timeout) * time.Minute
+	if timeout <= 0 {
+		timeout = 20 * time.Minute
+	}
+	expired := time.Since(provision.CreatedAt) > timeout
+
+	switch provision.Status {
+	case ProvisionCreating:
+		userData, err := pm.bootstrap(provision, provider)
+		if err != nil {
+			pm.fail(provision, err)
+			return
+		}
+		tmpl := pm.config.Provisioning.Templates[provision.Template]
+		machine, err := provider.Create(ctx, cloudprovider.MachineSpec{
+			Name:     provision.Name,
+			Region:   tmpl.Region,
+			Size:     tmpl.Size,
+			Image:    tmpl.Image,
+			UserData: userData,
+		})
+		if err != nil {
+			pm.fail(provision, err)
+			return
+		}
+		provision.MachineID = machine.ID
+		provision.IP = machine.IP
+		provision.Status = ProvisionBooting
+
+	case ProvisionBooting:
+		if provision.IP == "" {
+			machine, err := provider.Get(ctx, provision.Region, provision.MachineID)
+			if err != nil {
+				utils.LogWarning("Failed to get machine of provision %s: %v", provision.ID, err)
+				return
+			}
+			provision.IP = machine.IP
+		}
+		if server := pm.serverByIP(provision.IP); server != nil {
+			provision.ServerID = server.ID
+			provision.Status = ProvisionRegistered
+		} else if expired {
+			pm.fail(provision, fmt.Errorf("server did not register within %s", timeout))
+			return
+		}
+
+	case ProvisionRegistered:
+		switch pm.servers.serverStatus(provision.ServerID) {
+		case "online":
+			provision.Status = ProvisionReady
+			utils.LogInfo("Provisioned server %s (%s) is online", provision.ServerID, provision.Name)
+		case "":
+			pm.fail(provision, fmt.Errorf("server %s was removed", provision.ServerID))
+			return
+		default:
+			if expired {
+				pm.fail(provision, fmt.Errorf("server did not come online within %s", timeout))
+				return
+			}
+		}
+
+	case ProvisionDeleting:
+		if provision.MachineID != "" {
+			if err := provider.Delete(ctx, provision.Region, provision.MachineID); err != nil {
+				provision.Error = err.Error()
+				pm.update(provision)
+				return
+			}
+		}
+		if provision.ServerID != "" {
+			if err := pm.servers.RemoveServer(provision.ServerID); err != nil {
+				utils.LogWarning("Server %s of provision %s was already removed", provision.ServerID, provision.ID)
+			}
+		}
+		provision.Error = ""
+		provision.Status = ProvisionDeleted
+		utils.LogInfo("Tore down provisioned server %s", provision.Name)
+	}
+
+	pm.update(provision)
+}
+
+// fail marks a provision failed, leaving its machine for admins to inspect
+// and tear down
+func (pm *ProvisioningManager) fail(provision *Provision, err error) {
+	utils.LogError("Provisioning of %s failed: %v", provision.Name, err)
+	provision.Status = ProvisionFailed
+	provision.Error = err.Error()
+	pm.update(provision)
+}
+
+// update saves a provision advanced in background. Provisions torn down
+// meanwhile stay deleting, keeping the machine the step created.
+func (pm *ProvisioningManager) update(provision *Provision) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if current, ok := pm.provisions[provision.ID]; ok && current.Status == ProvisionDeleting && provision.Status != ProvisionDeleted {
+		provision.Status = ProvisionDeleting
+	}
+	provision.UpdatedAt = time.Now()
+
+	if err := pm.save(provision); err != nil {
+		utils.LogError("Failed to save provision %s: %v", provision.ID, err)
+	}
+	pm.provisions[provision.ID] = provision
+}
+
+// serverByIP gets the server registered from an IP, nil if none is
+func (pm *ProvisioningManager) serverByIP(ip string) *Server {
+	if ip == "" {
+		return nil
+	}
+	for _, server := range pm.servers.GetServers() {
+		if server.IP == ip {
+			return server
+		}
+	}
+	return nil
+}
+
+// provider gets the driver of a cloud provider, creating it on first use
+func (pm *ProvisioningManager) provider(name string) (cloudprovider.Provider, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if provider, ok := pm.providers[name]; ok {
+		return provider, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider, err := cloudprovider.New(ctx, name, pm.config.Provisioning)
+	if err != nil {
+		return nil, err
+	}
+	pm.providers[name] = provider
+
+	return provider, nil
+}
+
+// provisionBootstrap is the script provisioned machines run on first boot.
+// It installs WireGuard and the node agent, generates the server's key,
+// registers the server and runs the agent as it. Images are expected to be
+// Debian or Ubuntu.
+var provisionBootstrap = template.Must(template.New("bootstrap").Funcs(template.FuncMap{
+	"quote": shellQuote,
+}).Parse(`#!/bin/sh
+set -eu
+
+export DEBIAN_FRONTEND=noninteractive
+apt-get update -q
+apt-get install -y -q wireguard-tools curl
+
+curl -fsSL {{quote .AgentURL}} -o /usr/local/bin/vpn-agent
+chmod 755 /usr/local/bin/vpn-agent
+
+umask 077
+mkdir -p /etc/vpn-agent
+PRIVATE_KEY=$(wg genkey)
+PUBLIC_KEY=$(printf '%s' "$PRIVATE_KEY" | wg pubkey)
+IP=$({{.PublicIPCommand}})
+
+SERVER_ID=$(curl -fsS -X POST {{quote .RegisterURL}} \
+  -H {{quote .RegisterAuth}} -H 'Content-Type: application/json' \
+  -d "{\"name\":{{quote .NameJSON}},\"publicKey\":\"$PUBLIC_KEY\",\"endpoint\":\"$IP:{{.ListenPort}}\",\"capacity\":{{.Capacity}},\"bandwidthClass\":{{quote .BandwidthClassJSON}}}" \
+  | grep -o '"id":"[^"]*"' | head -n 1 | cut -d '"' -f 4)
+
+cat > /etc/vpn-agent/config.json <<EOF
+{
+  "agent": {"serverId": "$SERVER_ID", "controlPlane": {{.ControlPlaneJSON}}},
+  "nodes": {"agentToken": {{.AgentTokenJSON}}},
+  "wireguard": {"listenPort": {{.ListenPort}}, "privateKey": "$PRIVATE_KEY", "publicKey": "$PUBLIC_KEY"}
+}
+EOF
+
+cat > /etc/systemd/system/vpn-agent.service <<'EOF'
+[Unit]
+Description=VPN node agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Environment=VPN_CONFIG_PATH=/etc/vpn-agent/config.json
+ExecStart=/usr/local/bin/vpn-agent
+Restart=always
+
+[Install]
+WantedBy=multi-user.target
+EOF
+
+systemctl daemon-reload
+systemctl enable --now vpn-agent
+`))
+
+// bootstrap renders the first boot script of a provision's machine
+func (pm *ProvisioningManager) bootstrap(provision *Provision, provider cloudprovider.Provider) (string, error) {
+	tmpl := pm.config.Provisioning.Templates[provision.Template]
+	controlPlane := strings.TrimRight(pm.config.Provisioning.ControlPlane, "/")
+	listenPort := pm.config.WireGuard.ListenPort
+	if listenPort <= 0 {
+		listenPort = 51820
+	}
+
+	var buf bytes.Buffer
+	err := provisionBootstrap.Execute(&buf, map[string]interface{}{
+		"AgentURL":           pm.config.Provisioning.AgentURL,
+		"PublicIPCommand":    provider.PublicIPCommand(),
+		"RegisterURL":        controlPlane + "/api/v1/admin/servers/register",
+		"RegisterAuth":       "Authorization: Bearer " + pm.config.Nodes.RegisterToken,
+		"NameJSON":           jsonString(provision.Name),
+		"ListenPort":         listenPort,
+		"Capacity":           tmpl.Capacity,
+		"BandwidthClassJSON": jsonString(tmpl.BandwidthClass),
+		"ControlPlaneJSON":   jsonString(controlPlane),
+		"AgentTokenJSON":     jsonString(pm.config.Nodes.AgentToken),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render bootstrap script: %v", err)
+	}
+
+	return buf.String(), nil
+}
+
+// shellQuote quotes a string as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// jsonString renders a string as a JSON string literal
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r < 0x20:
+			fmt.Fprintf(&buf, `\u%04x`, r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// save writes a provision to the database. The caller holds pm.mutex.
+func (pm *ProvisioningManager) save(provision *Provision) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	_, err := db.DB.Exec(
+		`INSERT INTO provisions (id, name, template, provider, region, machine_id, ip, server_id, status, error, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (id) DO UPDATE SET machine_id = $6, ip = $7, server_id = $8, status = $9, error = $10, updated_at = $13`,
+		provision.ID, provision.Name, provision.Template, provision.Provider, provision.Region, provision.MachineID, provision.IP,
+		provision.ServerID, provision.Status, provision.Error, provision.CreatedBy, provision.CreatedAt, provision.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save provision: %v", err)
+	}
+
+	return nil
+}
+
+// load reads provisions from the database
+func (pm *ProvisioningManager) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	provisions := []*Provision{}
+	err := db.DB.Select(&provisions, `SELECT id, name, template, provider, region, machine_id, ip, server_id, status, error, created_by, created_at, updated_at FROM provisions`)
+	if err != nil {
+		return fmt.Errorf("failed to query provisions: %v", err)
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	for _, provision := range provisions {
+		pm.provisions[provision.ID] = provision
+	}
+
+	return nil
+}
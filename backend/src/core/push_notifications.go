@@ -0,0 +1,306 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/push"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Push notification kinds, passed to apps in the "kind" data field
+const (
+	PushSessionDisconnected = "session_disconnected"
+	PushDataCap             = "data_cap"
+	PushNewDevice           = "new_device"
+	PushPeerInactive        = "peer_inactive"
+	PushPeerArchived        = "peer_archived"
+	PushMeshUpdated         = "mesh_updated"
+	PushPeerMigrated        = "peer_migrated"
+)
+
+const (
+	// maxPushDevices limits the devices a user can register for notifications
+	maxPushDevices = 10
+	// pushTimeout bounds the delivery of a notification to one device
+	pushTimeout = 10 * time.Second
+)
+
+// PushNotifier notifies users' mobile devices of session events: remote
+// disconnects, new devices on their account, idle or archived devices,
+// changes to their mesh and nearing their data cap
+type PushNotifier struct {
+	config  *config.Config
+	senders map[string]push.Sender
+	devices map[string]*models.PushDevice
+	mutex   sync.RWMutex
+}
+
+// NewPushNotifier creates a new push notifier
+func NewPushNotifier(cfg *config.Config) *PushNotifier {
+	pn := &PushNotifier{
+		config:  cfg,
+		senders: make(map[string]push.Sender),
+		devices: make(map[string]*models.PushDevice),
+		mutex:   sync.RWMutex{},
+	}
+
+	if cfg.Push.Enabled {
+		senders, err := push.New(cfg.Push)
+		if err != nil {
+			utils.LogError("Push notifications disabled: %v", err)
+		} else {
+			pn.senders = senders
+		}
+	}
+
+	return pn
+}
+
+// RegisterDevice registers a device token for a user's notifications. A
+// token registered before, e.g. under another account, moves to the user.
+func (pn *PushNotifier) RegisterDevice(userID, platform, token, name string) (*models.PushDevice, error) {
+	if _, ok := pn.senders[platform]; !ok {
+		return nil, fmt.Errorf("push notifications are not available for %s", platform)
+	}
+
+	devices, err := pn.ListDevices(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	device := &models.PushDevice{
+		ID:        utils.GenerateUUID(),
+		UserID:    userID,
+		Platform:  platform,
+		Token:     token,
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	registered := false
+	for _, existing := range devices {
+		if existing.Token == token {
+			device.ID = existing.ID
+			device.CreatedAt = existing.CreatedAt
+			registered = true
+		}
+	}
+	if !registered && len(devices) >= maxPushDevices {
+		return nil, fmt.Errorf("at most %d devices can be registered", maxPushDevices)
+	}
+
+	pn.mutex.Lock()
+	defer pn.mutex.Unlock()
+
+	if db.DB != nil {
+		// A token known under another account keeps its row
+		err := db.DB.QueryRow(
+			`INSERT INTO push_devices (id, user_id, platform, token, name, created_at) VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (token) DO UPDATE SET user_id = EXCLUDED.user_id, platform = EXCLUDED.platform, name = EXCLUDED.name
+			RETURNING id, created_at`,
+			device.ID, device.UserID, device.Platform, device.Token, device.Name, device.CreatedAt,
+		).Scan(&device.ID, &device.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save push device: %v", err)
+		}
+	}
+
+	for id, existing := range pn.devices {
+		if existing.Token == token {
+			delete(pn.devices, id)
+		}
+	}
+	pn.devices[device.ID] = device
+
+	// Log analytics
+	utils.LogAnalytics(userID, "push_device_registered", fmt.Sprintf("platform=%s", platform))
+
+	return device, nil
+}
+
+// UnregisterDevice removes one of a user's devices
+func (pn *PushNotifier) UnregisterDevice(userID, id string) error {
+	pn.mutex.Lock()
+	defer pn.mutex.Unlock()
+
+	if db.DB != nil {
+		result, err := db.DB.Exec(`DELETE FROM push_devices WHERE id = $1 AND user_id = $2`, id, userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete push device: %v", err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return fmt.Errorf("push device not found: %s", id)
+		}
+	} else if device, ok := pn.devices[id]; !ok || device.UserID != userID {
+		return fmt.Errorf("push device not found: %s", id)
+	}
+
+	delete(pn.devices, id)
+
+	return nil
+}
+
+// ListDevices gets the devices a user registered
+func (pn *PushNotifier) ListDevices(userID string) ([]*models.PushDevice, error) {
+	devices := []*models.PushDevice{}
+
+	if db.DB != nil {
+		err := db.DB.Select(&devices,
+			`SELECT id, user_id, platform, token, name, created_at FROM push_devices WHERE user_id = $1 ORDER BY created_at`,
+			userID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query push devices: %v", err)
+		}
+		return devices, nil
+	}
+
+	pn.mutex.RLock()
+	defer pn.mutex.RUnlock()
+
+	for _, device := range pn.devices {
+		if device.UserID == userID {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices, nil
+}
+
+// Run notifies users of the session events published on the bus until the
+// subscription ends
+func (pn *PushNotifier) Run(events *EventBus) {
+	if len(pn.senders) == 0 {
+		return
+	}
+
+	subscription, _, unsubscribe := events.Subscribe(0)
+	defer unsubscribe()
+
+	for event := range subscription {
+		if mesh, ok := event.Data.(MeshEvent); ok {
+			pn.notify(mesh.UserID, PushMeshUpdated, push.Message{
+				Title: "Network updated",
+				Body:  "Devices in your network changed. Download your config again to reach them directly.",
+				Data:  map[string]string{"meshId": mesh.MeshID},
+			})
+			continue
+		}
+
+		peer, ok := event.Data.(PeerEvent)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case event.Type == EventPeerConnected && !peer.Dynamic:
+			// Every static connect provisions a new device
+			pn.notify(peer.UserID, PushNewDevice, push.Message{
+				Title: "New device connected",
+				Body:  fmt.Sprintf("%s was added to your account. If this wasn't you, change your password.", deviceName(peer)),
+				Data:  map[string]string{"peerId": peer.PeerID},
+			})
+		case event.Type == EventPeerDisconnected && peer.Reason == DisconnectAdmin:
+			pn.notify(peer.UserID, PushSessionDisconnected, push.Message{
+				Title: "VPN disconnected",
+				Body:  "Your device was removed from your account by an administrator.",
+				Data:  map[string]string{"peerId": peer.PeerID, "reason": peer.Reason},
+			})
+		case event.Type == EventPeerDisconnected && peer.Reason == DisconnectExpired:
+			pn.notify(peer.UserID, PushSessionDisconnected, push.Message{
+				Title: "VPN session ended",
+				Body:  "Your session expired. Reconnect to stay protected.",
+				Data:  map[string]string{"peerId": peer.PeerID, "reason": peer.Reason},
+			})
+		case event.Type == EventPeerInactive:
+			pn.notify(peer.UserID, PushPeerInactive, push.Message{
+				Title: "Device not connected recently",
+				Body:  fmt.Sprintf("%s hasn't connected in %d days. Remove it if you no longer use it.", deviceName(peer), peer.InactiveDays),
+				Data:  map[string]string{"peerId": peer.PeerID},
+			})
+		case event.Type == EventPeerArchived:
+			pn.notify(peer.UserID, PushPeerArchived, push.Message{
+				Title: "Device archived",
+				Body:  fmt.Sprintf("%s was archived after %d days without connecting. Reactivate it to use it again.", deviceName(peer), peer.InactiveDays),
+				Data:  map[string]string{"peerId": peer.PeerID},
+			})
+		case event.Type == EventPeerMigrated:
+			why := "its server went offline"
+			if peer.Reason == MigrateDrain {
+				why = "its server is going down for maintenance"
+			}
+			pn.notify(peer.UserID, PushPeerMigrated, push.Message{
+				Title: "Server changed",
+				Body:  fmt.Sprintf("%s was moved to another server because %s. Download your config again to reconnect.", deviceName(peer), why),
+				Data:  map[string]string{"peerId": peer.PeerID, "serverId": peer.ServerID, "reason": peer.Reason},
+			})
+		}
+	}
+}
+
+// deviceName gets the name of a peer's device for notifications
+func deviceName(peer PeerEvent) string {
+	if peer.Device == "" {
+		return "A device"
+	}
+	return peer.Device
+}
+
+// NotifyDataCap tells a user they have used most of their data allowance.
+// It is meant for usage accounting to call once per threshold crossed.
+func (pn *PushNotifier) NotifyDataCap(userID string, used, limit int64) {
+	if limit <= 0 {
+		return
+	}
+
+	percent := used * 100 / limit
+	pn.notify(userID, PushDataCap, push.Message{
+		Title: "Data cap almost reached",
+		Body:  fmt.Sprintf("You have used %d%% of your data allowance.", percent),
+		Data:  map[string]string{"used": fmt.Sprint(used), "limit": fmt.Sprint(limit)},
+	})
+}
+
+// notify sends a message to every device of a user, forgetting devices the
+// push service no longer knows
+func (pn *PushNotifier) notify(userID, kind string, msg push.Message) {
+	devices, err := pn.ListDevices(userID)
+	if err != nil {
+		utils.LogError("Failed to get push devices of user %s: %v", utils.RedactUserID(userID), err)
+		return
+	}
+
+	if msg.Data == nil {
+		msg.Data = make(map[string]string)
+	}
+	msg.Data["kind"] = kind
+
+	for _, device := range devices {
+		sender, ok := pn.senders[device.Platform]
+		if !ok {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+		err := sender.Send(ctx, device.Token, msg)
+		cancel()
+
+		if err == push.ErrUnregistered {
+			if err := pn.UnregisterDevice(userID, device.ID); err != nil {
+				utils.LogError("Failed to remove stale push device %s: %v", device.ID, err)
+			}
+			continue
+		}
+		if err != nil {
+			utils.LogError("Failed to send %s notification to device %s: %v", kind, device.ID, err)
+		}
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "push_notification_sent", fmt.Sprintf("kind=%s devices=%d", kind, len(devices)))
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// tokenBucketScript atomically refills and takes a token from a bucket.
+// Redis server time is used so every API instance shares the same clock.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+
+return {allowed, math.floor(tokens), retry}
+`)
+
+// RateLimitResult represents the outcome of a rate limit check
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// bucket represents an in-memory token bucket
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter enforces token bucket limits per route group and client.
+// Buckets live in Redis when configured so limits hold across instances,
+// and in memory otherwise.
+type RateLimiter struct {
+	config  *config.Config
+	redis   *redis.Client
+	buckets map[string]*bucket
+	mutex   sync.Mutex
+}
+
+// NewRateLimiter creates a new rate limiter
+func NewRateLimiter(cfg *config.Config) *RateLimiter {
+	rl := &RateLimiter{
+		config:  cfg,
+		buckets: make(map[string]*bucket),
+		mutex:   sync.Mutex{},
+	}
+
+	if cfg.RateLimit.RedisAddr != "" {
+		rl.redis = redis.NewClient(&redis.Options{
+			Addr:     cfg.RateLimit.RedisAddr,
+			Password: cfg.RateLimit.RedisPassword,
+			DB:       cfg.RateLimit.RedisDB,
+		})
+	}
+
+	return rl
+}
+
+// Rule gets the limit configured for a route group
+func (rl *RateLimiter) Rule(name string) (config.RateLimitRule, bool) {
+	rule, ok := rl.config.RateLimit.Rules[name]
+	if !ok || !rl.config.RateLimit.Enabled || rule.Rate <= 0 || rule.Burst <= 0 {
+		return config.RateLimitRule{}, false
+	}
+	return rule, true
+}
+
+// Allow takes a token from the bucket of a client for a route group
+func (rl *RateLimiter) Allow(ctx context.Context, name, client string) (*RateLimitResult, error) {
+	rule, ok := rl.Rule(name)
+	if !ok {
+		return &RateLimitResult{Allowed: true}, nil
+	}
+
+	key := fmt.Sprintf("ratelimit:%s:%s", name, client)
+	if rl.redis == nil {
+		return rl.allowLocal(key, rule), nil
+	}
+
+	values, err := tokenBucketScript.Run(ctx, rl.redis, []string{key}, rule.Rate, rule.Burst).Int64Slice()
+	if err != nil {
+		// Fall back to the local bucket so an outage does not disable limits
+		utils.LogError("Rate limiter Redis error, using local bucket: %v", err)
+		return rl.allowLocal(key, rule), nil
+	}
+
+	return &RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      rule.Burst,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+// allowLocal takes a token from an in-memory bucket
+func (rl *RateLimiter) allowLocal(key string, rule config.RateLimitRule) *RateLimitResult {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := time.Now()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), updated: now}
+		rl.buckets[key] = b
+	}
+
+	// Refill
+	b.tokens = math.Min(float64(rule.Burst), b.tokens+now.Sub(b.updated).Seconds()*rule.Rate)
+	b.updated = now
+
+	result := &RateLimitResult{Limit: rule.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+	}
+	result.Remaining = int(b.tokens)
+
+	return result
+}
+
+// RunCleanup periodically removes idle in-memory buckets
+func (rl *RateLimiter) RunCleanup() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		rl.mutex.Lock()
+		for key, b := range rl.buckets {
+			if time.Since(b.updated) > 10*time.Minute {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.mutex.Unlock()
+	}
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// ServerRecommendation represents a server scored for a client
+type ServerRecommendation struct {
+	ServerID    string   `json:"serverId"`
+	Name        string   `json:"name"`
+	Country     string   `json:"country"`
+	City        string   `json:"city"`
+	Load        int      `json:"load"`
+	Capacity    int      `json:"capacity"`
+	Utilization int      `json:"utilization"`    // percent of capacity in use
+	RTT         *float64 `json:"rtt,omitempty"`  // in milliseconds, as observed from the client's region
+	Loss        *float64 `json:"loss,omitempty"` // share of pings lost, as observed from the client's region
+	Score       float64  `json:"score"`          // 0 to 100, higher is better
+}
+
+// ServerRecommendations represents the servers recommended to a client,
+// best first
+type ServerRecommendations struct {
+	Region      string                  `json:"region,omitempty"` // country code of the client, empty when unknown
+	Servers     []*ServerRecommendation `json:"servers"`
+	GeneratedAt time.Time               `json:"generatedAt"`
+}
+
+// clientRegion gets the country code of a client IP, empty when it cannot
+// be located. Clients that cannot be located share their measurements.
+func (vm *VPNManager) clientRegion(ip string) string {
+	if vm.serverManager.geo == nil || ip == "" {
+		return ""
+	}
+	location, err := vm.serverManager.geo.Lookup(ip)
+	if err != nil {
+		return ""
+	}
+	return location.CountryCode
+}
+
+// RecordClientLatency records the pings a client reported to servers for
+// its region, gets the region and how many measurements were of known
+// servers
+func (vm *VPNManager) RecordClientLatency(userID, ip string, latencies []ClientLatency) (string, int) {
+	known := make([]ClientLatency, 0, len(latencies))
+	for _, latency := range latencies {
+		if _, err := vm.serverManager.GetServer(latency.ServerID); err == nil {
+			known = append(known, latency)
+		}
+	}
+
+	region := vm.clientRegion(ip)
+	if len(known) > 0 {
+		vm.serverManager.Latency().RecordClient(region, known)
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "vpn_latency_report", fmt.Sprintf("region=%s servers=%d", region, len(known)))
+
+	return region, len(known)
+}
+
+// RecommendServers scores the online servers with room, optionally only in
+// a country, for a client. The score weighs the latency clients in the
+// client's region observed to a server against its load; servers nobody
+// in the region pinged yet get the average latency score of those they did.
+func (vm *VPNManager) RecommendServers(ip, country string) *ServerRecommendations {
+	region := vm.clientRegion(ip)
+	latency := vm.serverManager.Latency()
+
+	weight := math.Max(0, math.Min(1, vm.config.Recommend.LatencyWeight))
+	maxLatency := float64(vm.config.Recommend.MaxLatency)
+	if maxLatency <= 0 {
+		maxLatency = 300
+	}
+
+	recommendations := &ServerRecommendations{
+		Region:      region,
+		Servers:     make([]*ServerRecommendation, 0),
+		GeneratedAt: time.Now(),
+	}
+
+	// Latency scores run from 0 for an instant round trip to 100 for one
+	// of maxLatency or slower, with lost pings counting as slow ones
+	latencyScores := make([]float64, 0)
+	observed := make(map[string]float64)
+	for _, server := range vm.serverManager.GetServers() {
+		if server.Status != "online" || server.Load >= server.Capacity {
+			continue
+		}
+		if country != "" && server.Country != country && server.CountryCode != country {
+			continue
+		}
+
+		recommendation := &ServerRecommendation{
+			ServerID: server.ID,
+			Name:     server.Name,
+			Country:  server.Country,
+			City:     server.City,
+			Load:     server.Load,
+			Capacity: server.Capacity,
+		}
+		if server.Capacity > 0 {
+			recommendation.Utilization = server.Load * 100 / server.Capacity
+		}
+
+		if measurement, ok := latency.ClientLatency(region, server.ID); ok {
+			rtt, loss := measurement.RTT, measurement.Loss
+			recommendation.RTT, recommendation.Loss = &rtt, &loss
+
+			score := math.Min(rtt/maxLatency, 1)*100*(1-loss) + loss*100
+			observed[server.ID] = score
+			latencyScores = append(latencyScores, score)
+		}
+
+		recommendations.Servers = append(recommendations.Servers, recommendation)
+	}
+
+	unobserved := 0.0
+	for _, score := range latencyScores {
+		unobserved += score / float64(len(latencyScores))
+	}
+
+	for _, recommendation := range recommendations.Servers {
+		cost := float64(recommendation.Utilization)
+		if len(latencyScores) > 0 {
+			latencyScore, ok := observed[recommendation.ServerID]
+			if !ok {
+				latencyScore = unobserved
+			}
+			cost = (1-weight)*cost + weight*latencyScore
+		}
+		recommendation.Score = math.Round((100-cost)*10) / 10
+	}
+
+	sort.SliceStable(recommendations.Servers, func(i, j int) bool {
+		a, b := recommendations.Servers[i], recommendations.Servers[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.ServerID < b.ServerID
+	})
+
+	return recommendations
+}
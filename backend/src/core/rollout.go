@@ -0,0 +1,381 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Rollout stages
+const (
+	RolloutStageCanary    = "canary"
+	RolloutStageFleet     = "fleet"
+	RolloutStageCompleted = "completed"
+	RolloutStageFailed    = "failed"
+	RolloutStageAborted   = "aborted"
+)
+
+// AgentRollout represents a staged upgrade of the node agent to a version
+type AgentRollout struct {
+	ID               string     `json:"id" db:"id"`
+	Version          string     `json:"version" db:"version"`
+	CanaryID         string     `json:"canaryId" db:"canary_id"`
+	Stage            string     `json:"stage" db:"stage"`
+	Error            string     `json:"error,omitempty" db:"error"`
+	CreatedBy        string     `json:"createdBy,omitempty" db:"created_by"`
+	StartedAt        time.Time  `json:"startedAt" db:"started_at"`
+	StageStartedAt   time.Time  `json:"stageStartedAt" db:"stage_started_at"`
+	CanaryUpgradedAt *time.Time `json:"canaryUpgradedAt,omitempty" db:"canary_upgraded_at"`
+	FinishedAt       *time.Time `json:"finishedAt,omitempty" db:"finished_at"`
+	Pending          []string   `json:"pending,omitempty" db:"-"`
+}
+
+// RolloutManager orchestrates staged agent upgrades. A new version is first
+// offered to a single canary node; once the canary reports the version and
+// stays healthy for the soak period it is offered to the rest of the fleet.
+// Nodes pick up their target version from heartbeat responses.
+type RolloutManager struct {
+	config       *config.Config
+	servers      *ServerManager
+	rollouts     map[string]*AgentRollout
+	active       *AgentRollout
+	fleetVersion string // version of the last completed rollout
+	mutex        sync.RWMutex
+}
+
+// NewRolloutManager creates a new rollout manager
+func NewRolloutManager(cfg *config.Config, servers *ServerManager) *RolloutManager {
+	rm := &RolloutManager{
+		config:   cfg,
+		servers:  servers,
+		rollouts: make(map[string]*AgentRollout),
+		mutex:    sync.RWMutex{},
+	}
+
+	if err := rm.load(); err != nil {
+		utils.LogError("Failed to load agent rollouts: %v", err)
+	}
+
+	return rm
+}
+
+// StartRollout starts rolling out an agent version, beginning with the
+// canary node. If no canary is given the least loaded healthy node is used.
+func (rm *RolloutManager) StartRollout(version, canaryID, actor string) (*AgentRollout, error) {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return nil, fmt.Errorf("version is required")
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	if rm.active != nil {
+		return nil, fmt.Errorf("rollout %s is still in progress", rm.active.ID)
+	}
+	if version == rm.fleetVersion {
+		return nil, fmt.Errorf("version %s is already rolled out", version)
+	}
+
+	// Select canary
+	if canaryID == "" {
+		canaryID = rm.pickCanary()
+		if canaryID == "" {
+			return nil, fmt.Errorf("no healthy node available as canary")
+		}
+	} else if !rm.servers.nodeResponsive(canaryID) {
+		return nil, fmt.Errorf("canary node is not online or not sending heartbeats: %s", canaryID)
+	}
+
+	now := time.Now()
+	rollout := &AgentRollout{
+		ID:             utils.GenerateUUID(),
+		Version:        version,
+		CanaryID:       canaryID,
+		Stage:          RolloutStageCanary,
+		CreatedBy:      actor,
+		StartedAt:      now,
+		StageStartedAt: now,
+	}
+
+	if err := rm.save(rollout); err != nil {
+		return nil, err
+	}
+
+	rm.rollouts[rollout.ID] = rollout
+	rm.active = rollout
+
+	utils.LogInfo("Started agent rollout %s of version %s with canary %s", rollout.ID, version, canaryID)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "agent_rollout_start", fmt.Sprintf("rollout=%s version=%s canary=%s", rollout.ID, version, canaryID))
+
+	return rm.snapshot(rollout), nil
+}
+
+// AbortRollout stops a rollout in progress. Nodes that were not upgraded
+// yet keep their current version.
+func (rm *RolloutManager) AbortRollout(id, actor string) (*AgentRollout, error) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rollout, ok := rm.rollouts[id]
+	if !ok {
+		return nil, fmt.Errorf("rollout not found: %s", id)
+	}
+	if rollout != rm.active {
+		return nil, fmt.Errorf("rollout is not in progress: %s", id)
+	}
+
+	if err := rm.finish(rollout, RolloutStageAborted, "aborted by "+actor); err != nil {
+		return nil, err
+	}
+
+	// Log analytics
+	utils.LogAnalytics(actor, "agent_rollout_abort", fmt.Sprintf("rollout=%s version=%s", rollout.ID, rollout.Version))
+
+	return rm.snapshot(rollout), nil
+}
+
+// GetRollout gets a rollout by ID
+func (rm *RolloutManager) GetRollout(id string) (*AgentRollout, error) {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	rollout, ok := rm.rollouts[id]
+	if !ok {
+		return nil, fmt.Errorf("rollout not found: %s", id)
+	}
+
+	return rm.snapshot(rollout), nil
+}
+
+// ListRollouts gets all rollouts, most recent first
+func (rm *RolloutManager) ListRollouts() []*AgentRollout {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	rollouts := make([]*AgentRollout, 0, len(rm.rollouts))
+	for _, rollout := range rm.rollouts {
+		rollouts = append(rollouts, rm.snapshot(rollout))
+	}
+
+	sort.Slice(rollouts, func(i, j int) bool {
+		return rollouts[i].StartedAt.After(rollouts[j].StartedAt)
+	})
+
+	return rollouts
+}
+
+// TargetVersion gets the agent version a node should run and the rollout
+// that set it. During the canary stage only the canary gets the new version.
+func (rm *RolloutManager) TargetVersion(serverID string) (string, string) {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	if rm.active != nil {
+		if rm.active.Stage == RolloutStageFleet || serverID == rm.active.CanaryID {
+			return rm.active.Version, rm.active.ID
+		}
+	}
+
+	return rm.fleetVersion, ""
+}
+
+// RunRollouts periodically advances the rollout in progress
+func (rm *RolloutManager) RunRollouts() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		rm.advance()
+	}
+}
+
+// advance moves the rollout in progress to its next stage, or fails it if
+// the canary breaks or nodes do not upgrade in time
+func (rm *RolloutManager) advance() {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rollout := rm.active
+	if rollout == nil {
+		return
+	}
+
+	now := time.Now()
+	timeout := time.Duration(rm.config.Nodes.RolloutTimeout) * time.Minute
+	soak := time.Duration(rm.config.Nodes.CanarySoak) * time.Minute
+
+	var err error
+	switch rollout.Stage {
+	case RolloutStageCanary:
+		version, _ := rm.servers.NodeVersion(rollout.CanaryID)
+		if version.AgentVersion != rollout.Version {
+			if now.Sub(rollout.StageStartedAt) > timeout {
+				err = rm.finish(rollout, RolloutStageFailed, fmt.Sprintf("canary %s did not report version %s in time", rollout.CanaryID, rollout.Version))
+			}
+			break
+		}
+
+		if !rm.servers.nodeResponsive(rollout.CanaryID) {
+			err = rm.finish(rollout, RolloutStageFailed, fmt.Sprintf("canary %s stopped responding after upgrading", rollout.CanaryID))
+			break
+		}
+
+		if rollout.CanaryUpgradedAt == nil {
+			rollout.CanaryUpgradedAt = &now
+			err = rm.save(rollout)
+			utils.LogInfo("Canary %s upgraded to agent %s, soaking", rollout.CanaryID, rollout.Version)
+			break
+		}
+
+		// Offer the version to the fleet once the canary has soaked
+		if now.Sub(*rollout.CanaryUpgradedAt) >= soak {
+			rollout.Stage = RolloutStageFleet
+			rollout.StageStartedAt = now
+			err = rm.save(rollout)
+			utils.LogInfo("Agent rollout %s passed canary, upgrading fleet", rollout.ID)
+		}
+
+	case RolloutStageFleet:
+		pending := rm.pendingNodes(rollout.Version)
+		if len(pending) == 0 {
+			err = rm.finish(rollout, RolloutStageCompleted, "")
+			break
+		}
+
+		if now.Sub(rollout.StageStartedAt) > timeout {
+			err = rm.finish(rollout, RolloutStageFailed, fmt.Sprintf("nodes did not report version %s in time: %s", rollout.Version, strings.Join(pending, ", ")))
+		}
+	}
+
+	if err != nil {
+		utils.LogError("Failed to advance agent rollout %s: %v", rollout.ID, err)
+	}
+}
+
+// finish ends a rollout with a final stage
+func (rm *RolloutManager) finish(rollout *AgentRollout, stage, reason string) error {
+	now := time.Now()
+	rollout.Stage = stage
+	rollout.Error = reason
+	rollout.FinishedAt = &now
+
+	if err := rm.save(rollout); err != nil {
+		return err
+	}
+
+	rm.active = nil
+	if stage == RolloutStageCompleted {
+		rm.fleetVersion = rollout.Version
+	}
+
+	if stage == RolloutStageFailed {
+		utils.LogError("Agent rollout %s of version %s failed: %s", rollout.ID, rollout.Version, reason)
+	} else {
+		utils.LogInfo("Agent rollout %s of version %s %s", rollout.ID, rollout.Version, stage)
+	}
+
+	// Log analytics
+	utils.LogAnalytics("system", "agent_rollout_"+stage, fmt.Sprintf("rollout=%s version=%s", rollout.ID, rollout.Version))
+
+	return nil
+}
+
+// pendingNodes gets the online nodes that do not run a version yet
+func (rm *RolloutManager) pendingNodes(version string) []string {
+	pending := make([]string, 0)
+	for _, server := range rm.servers.GetServers() {
+		if server.Status != "online" {
+			continue
+		}
+		if nodeVersion, _ := rm.servers.NodeVersion(server.ID); nodeVersion.AgentVersion != version {
+			pending = append(pending, server.ID)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// pickCanary gets the least loaded healthy node
+func (rm *RolloutManager) pickCanary() string {
+	var canary *Server
+	for _, server := range rm.servers.GetServers() {
+		if !rm.servers.nodeResponsive(server.ID) {
+			continue
+		}
+		if canary == nil || server.Load < canary.Load || (server.Load == canary.Load && server.ID < canary.ID) {
+			canary = server
+		}
+	}
+
+	if canary == nil {
+		return ""
+	}
+	return canary.ID
+}
+
+// snapshot copies a rollout, adding the nodes still to upgrade if it is in
+// progress
+func (rm *RolloutManager) snapshot(rollout *AgentRollout) *AgentRollout {
+	copied := *rollout
+	if rollout == rm.active && rollout.Stage == RolloutStageFleet {
+		copied.Pending = rm.pendingNodes(rollout.Version)
+	}
+	return &copied
+}
+
+// save persists a rollout
+func (rm *RolloutManager) save(rollout *AgentRollout) error {
+	if db.DB == nil {
+		return nil
+	}
+
+	_, err := db.DB.Exec(
+		`INSERT INTO agent_rollouts (id, version, canary_id, stage, error, created_by, started_at, stage_started_at, canary_upgraded_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET stage = $4, error = $5, stage_started_at = $8, canary_upgraded_at = $9, finished_at = $10`,
+		rollout.ID, rollout.Version, rollout.CanaryID, rollout.Stage, rollout.Error, rollout.CreatedBy,
+		rollout.StartedAt, rollout.StageStartedAt, rollout.CanaryUpgradedAt, rollout.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save rollout: %v", err)
+	}
+
+	return nil
+}
+
+// load reads rollouts from the database
+func (rm *RolloutManager) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	rollouts := []*AgentRollout{}
+	err := db.DB.Select(&rollouts, `SELECT id, version, canary_id, stage, error, created_by, started_at, stage_started_at, canary_upgraded_at, finished_at FROM agent_rollouts ORDER BY started_at`)
+	if err != nil {
+		return fmt.Errorf("failed to query rollouts: %v", err)
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	for _, rollout := range rollouts {
+		rm.rollouts[rollout.ID] = rollout
+		switch rollout.Stage {
+		case RolloutStageCanary, RolloutStageFleet:
+			rm.active = rollout
+		case RolloutStageCompleted:
+			rm.fleetVersion = rollout.Version
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// maxPresetCIDRs limits the networks in a routing preset
+const maxPresetCIDRs = 1000
+
+// RoutingPresetManager manages the routing presets maintained by admins.
+// Peers keep the preset they were created with, and changing a preset
+// rewrites the AllowedIPs of every such peer, so the next config each
+// device fetches routes the new networks.
+type RoutingPresetManager struct {
+	config  *config.Config
+	vpn     *VPNManager
+	presets map[string]*models.RoutingPreset
+	mutex   sync.RWMutex
+}
+
+// NewRoutingPresetManager creates a new routing preset manager
+func NewRoutingPresetManager(cfg *config.Config, vpn *VPNManager) *RoutingPresetManager {
+	rm := &RoutingPresetManager{
+		config:  cfg,
+		vpn:     vpn,
+		presets: defaultRoutingPresets(),
+		mutex:   sync.RWMutex{},
+	}
+
+	if err := rm.load(); err != nil {
+		utils.LogError("Failed to load routing presets: %v", err)
+	}
+
+	return rm
+}
+
+// defaultRoutingPresets gets the built-in presets used when no database is
+// available
+func defaultRoutingPresets() map[string]*models.RoutingPreset {
+	now := time.Now()
+	return map[string]*models.RoutingPreset{
+		"full": {
+			ID:          "full",
+			Name:        "Full tunnel",
+			Description: "All traffic goes through the VPN",
+			CIDRs:       []string{"0.0.0.0/0", "::/0"},
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+		"corporate": {
+			ID:          "corporate",
+			Name:        "Corporate networks",
+			Description: "Only private network ranges go through the VPN",
+			CIDRs:       []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+	}
+}
+
+// ListPresets gets all routing presets, sorted by ID
+func (rm *RoutingPresetManager) ListPresets() []*models.RoutingPreset {
+	rm.refresh()
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	presets := make([]*models.RoutingPreset, 0, len(rm.presets))
+	for _, preset := range rm.presets {
+		presets = append(presets, preset)
+	}
+
+	sort.Slice(presets, func(i, j int) bool {
+		return presets[i].ID < presets[j].ID
+	})
+
+	return presets
+}
+
+// GetPreset gets a routing preset by ID
+func (rm *RoutingPresetManager) GetPreset(id string) (*models.RoutingPreset, error) {
+	rm.refresh()
+
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	preset, ok := rm.presets[id]
+	if !ok {
+		return nil, fmt.Errorf("routing preset not found: %s", id)
+	}
+
+	return preset, nil
+}
+
+// CreatePreset creates a new routing preset
+func (rm *RoutingPresetManager) CreatePreset(id, name, description string, cidrs []string) (*models.RoutingPreset, error) {
+	if id == "" || name == "" {
+		return nil, fmt.Errorf("routing preset ID and name are required")
+	}
+	cidrs, err := normalizeCIDRs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	if _, ok := rm.presets[id]; ok {
+		return nil, fmt.Errorf("routing preset already exists: %s", id)
+	}
+
+	now := time.Now()
+	preset := &models.RoutingPreset{
+		ID:          id,
+		Name:        name,
+		Description: description,
+		CIDRs:       cidrs,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`INSERT INTO routing_presets (id, name, description, cidrs, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+			preset.ID, preset.Name, preset.Description, preset.CIDRs, preset.CreatedAt, preset.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save routing preset: %v", err)
+		}
+	}
+
+	rm.presets[id] = preset
+
+	utils.LogInfo("Created routing preset %s", id)
+
+	return preset, nil
+}
+
+// UpdatePreset updates a routing preset and the AllowedIPs of every peer
+// using it
+func (rm *RoutingPresetManager) UpdatePreset(id, name, description string, cidrs []string) (*models.RoutingPreset, error) {
+	cidrs, err := normalizeCIDRs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	preset, err := rm.update(id, name, description, cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Roll the new networks out to the peers on the preset
+	peers, err := rm.vpn.updateRouting(id, preset.AllowedIPs())
+	if err != nil {
+		return nil, fmt.Errorf("routing preset saved but failed to update peers: %v", err)
+	}
+
+	utils.LogInfo("Updated routing preset %s on %d peers", id, peers)
+	utils.LogAnalytics("system", "routing_preset_update", fmt.Sprintf("preset=%s peers=%d", id, peers))
+
+	return preset, nil
+}
+
+// update saves a changed routing preset
+func (rm *RoutingPresetManager) update(id, name, description string, cidrs []string) (*models.RoutingPreset, error) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	existing, ok := rm.presets[id]
+	if !ok {
+		return nil, fmt.Errorf("routing preset not found: %s", id)
+	}
+
+	preset := *existing
+	if name != "" {
+		preset.Name = name
+	}
+	preset.Description = description
+	preset.CIDRs = cidrs
+	preset.UpdatedAt = time.Now()
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`UPDATE routing_presets SET name = $1, description = $2, cidrs = $3, updated_at = $4 WHERE id = $5`,
+			preset.Name, preset.Description, preset.CIDRs, preset.UpdatedAt, preset.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update routing preset: %v", err)
+		}
+	}
+
+	rm.presets[id] = &preset
+
+	return &preset, nil
+}
+
+// DeletePreset deletes a routing preset. Peers on the preset fall back to
+// the server's default AllowedIPs.
+func (rm *RoutingPresetManager) DeletePreset(id string) error {
+	if err := rm.delete(id); err != nil {
+		return err
+	}
+
+	peers, err := rm.vpn.updateRouting(id, "")
+	if err != nil {
+		return fmt.Errorf("routing preset deleted but failed to update peers: %v", err)
+	}
+
+	utils.LogInfo("Deleted routing preset %s, %d peers moved to the default routes", id, peers)
+
+	return nil
+}
+
+// delete removes a routing preset
+func (rm *RoutingPresetManager) delete(id string) error {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	if _, ok := rm.presets[id]; !ok {
+		return fmt.Errorf("routing preset not found: %s", id)
+	}
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`DELETE FROM routing_presets WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to delete routing preset: %v", err)
+		}
+	}
+
+	delete(rm.presets, id)
+
+	return nil
+}
+
+// apply sets the routes of a new peer from a preset. An empty ID keeps the
+// server's default AllowedIPs.
+func (rm *RoutingPresetManager) apply(opts *wireguard.PeerOptions, id string) error {
+	if id == "" {
+		return nil
+	}
+
+	preset, err := rm.GetPreset(id)
+	if err != nil {
+		return err
+	}
+
+	opts.RoutingPreset = preset.ID
+	opts.AllowedIPs = preset.AllowedIPs()
+
+	return nil
+}
+
+// normalizeCIDRs validates networks and returns them in canonical form,
+// without duplicates
+func normalizeCIDRs(cidrs []string) ([]string, error) {
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("at least one CIDR is required")
+	}
+	if len(cidrs) > maxPresetCIDRs {
+		return nil, fmt.Errorf("at most %d CIDRs are allowed", maxPresetCIDRs)
+	}
+
+	seen := make(map[string]bool, len(cidrs))
+	normalized := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", cidr)
+		}
+		if !seen[network.String()] {
+			seen[network.String()] = true
+			normalized = append(normalized, network.String())
+		}
+	}
+
+	return normalized, nil
+}
+
+// refresh reloads routing presets from the database so changes made
+// through other instances are listed
+func (rm *RoutingPresetManager) refresh() {
+	if db.DB == nil {
+		return
+	}
+	if err := rm.load(); err != nil {
+		utils.LogError("Failed to refresh routing presets: %v", err)
+	}
+}
+
+// load reads routing presets from the database
+func (rm *RoutingPresetManager) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	presets := []*models.RoutingPreset{}
+	if err := db.DB.Select(&presets, `SELECT id, name, description, cidrs, created_at, updated_at FROM routing_presets`); err != nil {
+		return fmt.Errorf("failed to query routing presets: %v", err)
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rm.presets = make(map[string]*models.RoutingPreset, len(presets))
+	for _, preset := range presets {
+		rm.presets[preset.ID] = preset
+	}
+
+	return nil
+}
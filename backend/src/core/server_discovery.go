@@ -0,0 +1,137 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vpn-service/backend/src/discovery"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// RunDiscovery sources servers from a service discovery backend, applying
+// every change to the inventory as the backend reports it
+func (sm *ServerManager) RunDiscovery(backend discovery.Backend) {
+	if err := backend.Watch(context.Background(), sm.syncDiscovered); err != nil {
+		utils.LogError("Service discovery stopped: %v", err)
+	}
+}
+
+// syncDiscovered applies the nodes reported by service discovery: new ones
+// are added, changed ones updated and servers discovered earlier that are
+// gone removed. Servers registered otherwise are left alone. The status a
+// node has in discovery is applied when it changes there, so heartbeats
+// and drains keep working in between.
+func (sm *ServerManager) syncDiscovered(nodes []discovery.Node) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	now := time.Now()
+	changed := false
+	seen := make(map[string]bool, len(nodes))
+
+	for _, node := range nodes {
+		if node.ID == "" {
+			continue
+		}
+		seen[node.ID] = true
+
+		server, ok := sm.servers[node.ID]
+		if !ok {
+			server = &Server{
+				ID:          node.ID,
+				Status:      node.Status,
+				LastUpdated: now,
+			}
+			applyNode(server, node)
+			if err := sm.LocateServer(server); err != nil {
+				utils.LogWarning("Failed to locate server %s: %v", server.IP, err)
+			}
+			sm.servers[node.ID] = server
+			sm.discovered[node.ID] = node.Status
+			changed = true
+
+			utils.LogInfo("Discovered server %s (%s) at %s", server.ID, server.Name, server.Endpoint)
+
+			// Log analytics
+			utils.LogAnalytics("system", "server_discovered", fmt.Sprintf("server=%s endpoint=%s", server.ID, server.Endpoint))
+			continue
+		}
+
+		ip := server.IP
+		if applyNode(server, node) {
+			if server.IP != ip && node.Country == "" && node.City == "" {
+				server.Country, server.City = "", ""
+				if err := sm.LocateServer(server); err != nil {
+					utils.LogWarning("Failed to locate server %s: %v", server.IP, err)
+				}
+			}
+			server.LastUpdated = now
+			changed = true
+		}
+
+		if previous, ok := sm.discovered[node.ID]; !ok || previous != node.Status {
+			sm.discovered[node.ID] = node.Status
+			// A drain outlasts the node being healthy
+			if server.Status != node.Status && !(server.Status == "draining" && node.Status == "online") {
+				previous := server.Status
+				server.Status = node.Status
+				server.LastUpdated = now
+				changed = true
+				sm.publishStatus(server, previous)
+			}
+		}
+	}
+
+	for id := range sm.discovered {
+		if seen[id] {
+			continue
+		}
+		delete(sm.discovered, id)
+		if _, ok := sm.servers[id]; !ok {
+			continue
+		}
+		delete(sm.servers, id)
+		sm.latency.Forget(id)
+		sm.applies.forget(id)
+		changed = true
+
+		utils.LogInfo("Server %s is gone from service discovery", id)
+
+		// Log analytics
+		utils.LogAnalytics("system", "server_removed", fmt.Sprintf("server=%s", id))
+	}
+
+	if changed {
+		sm.changed()
+	}
+}
+
+// applyNode copies the fields service discovery reports onto a server,
+// keeping those a node leaves empty. It reports whether any changed.
+func applyNode(server *Server, node discovery.Node) bool {
+	changed := false
+	set := func(field *string, value string) {
+		if value != "" && *field != value {
+			*field = value
+			changed = true
+		}
+	}
+
+	set(&server.Name, node.Name)
+	set(&server.IP, node.IP)
+	set(&server.Endpoint, node.Endpoint)
+	set(&server.PublicKey, node.PublicKey)
+	set(&server.Country, node.Country)
+	set(&server.City, node.City)
+	set(&server.BandwidthClass, node.BandwidthClass)
+	if node.Capacity > 0 && server.Capacity != node.Capacity {
+		server.Capacity = node.Capacity
+		changed = true
+	}
+	if server.Name == "" {
+		set(&server.Name, node.ID)
+	}
+
+	return changed
+}
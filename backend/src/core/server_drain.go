@@ -0,0 +1,251 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// drainInterval is how often draining servers move their peers
+const drainInterval = 30 * time.Second
+
+// ServerDrain represents a server drained ahead of maintenance. Draining
+// servers take no new connects; their idle peers are moved to other
+// servers in the same country right away, and peers with an active
+// session once the window ends.
+type ServerDrain struct {
+	ServerID    string     `json:"serverId"`
+	Previous    string     `json:"previous"` // status restored when the drain is cancelled
+	StartedBy   string     `json:"startedBy,omitempty"`
+	StartedAt   time.Time  `json:"startedAt"`
+	Deadline    time.Time  `json:"deadline"`    // active peers are moved from then on
+	Moved       int        `json:"moved"`       // peers moved to other servers so far
+	Peers       int        `json:"peers"`       // peers still on the server
+	ActivePeers int        `json:"activePeers"` // of those, peers with an active session
+	Empty       bool       `json:"empty"`       // no peers left, safe to take down
+	EmptyAt     *time.Time `json:"emptyAt,omitempty"`
+}
+
+// StartDrain puts a server into the draining state for a window
+func (sm *ServerManager) StartDrain(serverID string, window time.Duration, actor string) (*ServerDrain, error) {
+	sm.mutex.Lock()
+	server, ok := sm.servers[serverID]
+	if !ok {
+		sm.mutex.Unlock()
+		return nil, fmt.Errorf("server not found: %s", serverID)
+	}
+	if server.Status == "draining" {
+		sm.mutex.Unlock()
+		return nil, fmt.Errorf("server %s is already draining", serverID)
+	}
+
+	now := time.Now()
+	drain := &ServerDrain{
+		ServerID:  serverID,
+		Previous:  server.Status,
+		StartedBy: actor,
+		StartedAt: now,
+		Deadline:  now.Add(window),
+	}
+	sm.drains[serverID] = drain
+	copied := *drain
+	sm.mutex.Unlock()
+
+	if err := sm.UpdateServerStatus(serverID, "draining"); err != nil {
+		return nil, err
+	}
+
+	utils.LogInfo("Draining server %s until %s", serverID, drain.Deadline.Format(time.RFC3339))
+
+	// Log analytics
+	utils.LogAnalytics(actor, "server_drain_start", fmt.Sprintf("server=%s window=%s", serverID, window))
+
+	return &copied, nil
+}
+
+// StopDrain cancels the drain of a server, restoring the status it had
+// before. Peers already moved stay on their new server.
+func (sm *ServerManager) StopDrain(serverID, actor string) error {
+	sm.mutex.Lock()
+	drain, ok := sm.drains[serverID]
+	delete(sm.drains, serverID)
+	sm.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("server %s is not draining", serverID)
+	}
+
+	if sm.serverStatus(serverID) == "draining" {
+		if err := sm.UpdateServerStatus(serverID, drain.Previous); err != nil {
+			return err
+		}
+	}
+
+	utils.LogInfo("Stopped draining server %s after moving %d peers", serverID, drain.Moved)
+
+	// Log analytics
+	utils.LogAnalytics(actor, "server_drain_stop", fmt.Sprintf("server=%s moved=%d", serverID, drain.Moved))
+
+	return nil
+}
+
+// Drain gets the drain of a server
+func (sm *ServerManager) Drain(serverID string) (ServerDrain, bool) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	drain, ok := sm.drains[serverID]
+	if !ok {
+		return ServerDrain{}, false
+	}
+	return *drain, true
+}
+
+// activeDrains gets the drains of servers still draining. Drains of
+// servers whose status was changed since are dropped.
+func (sm *ServerManager) activeDrains() []ServerDrain {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	drains := make([]ServerDrain, 0, len(sm.drains))
+	for id, drain := range sm.drains {
+		server, ok := sm.servers[id]
+		if !ok || server.Status != "draining" {
+			delete(sm.drains, id)
+			continue
+		}
+		drains = append(drains, *drain)
+	}
+
+	sort.Slice(drains, func(i, j int) bool {
+		return drains[i].StartedAt.Before(drains[j].StartedAt)
+	})
+
+	return drains
+}
+
+// recordDrain records the progress of a drain, logging when the server
+// becomes empty
+func (sm *ServerManager) recordDrain(serverID string, moved, peers, activePeers int) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	drain, ok := sm.drains[serverID]
+	if !ok {
+		return
+	}
+	drain.Moved += moved
+	drain.Peers = peers
+	drain.ActivePeers = activePeers
+
+	empty := peers == 0
+	if empty && !drain.Empty {
+		now := time.Now()
+		drain.EmptyAt = &now
+		utils.LogInfo("Server %s is drained and safe to take down", serverID)
+
+		// Log analytics
+		utils.LogAnalytics("system", "server_drained", fmt.Sprintf("server=%s moved=%d", serverID, drain.Moved))
+	}
+	if !empty {
+		drain.EmptyAt = nil
+	}
+	drain.Empty = empty
+}
+
+// DrainServer drains a server over a window: it takes no new connects, its
+// idle peers move to other servers in the same country right away and its
+// active ones once the window ends
+func (vm *VPNManager) DrainServer(serverID string, window time.Duration, actor string) (*ServerDrain, error) {
+	if _, err := vm.serverManager.StartDrain(serverID, window, actor); err != nil {
+		return nil, err
+	}
+
+	if drain, ok := vm.serverManager.Drain(serverID); ok {
+		vm.drainServer(drain)
+	}
+	return vm.ServerDrain(serverID)
+}
+
+// ServerDrain gets the drain of a server with the peers left on it
+func (vm *VPNManager) ServerDrain(serverID string) (*ServerDrain, error) {
+	drain, ok := vm.serverManager.Drain(serverID)
+	if !ok {
+		return nil, fmt.Errorf("server %s is not draining", serverID)
+	}
+	return &drain, nil
+}
+
+// RunDrains moves the peers of draining servers every drain interval
+func (vm *VPNManager) RunDrains() {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, drain := range vm.serverManager.activeDrains() {
+			vm.drainServer(drain)
+		}
+	}
+}
+
+// drainServer moves the peers of a draining server that are due: idle ones,
+// and active ones once the window ended. Peers stay when no server in the
+// country has room.
+func (vm *VPNManager) drainServer(drain ServerDrain) {
+	server, err := vm.serverManager.GetServer(drain.ServerID)
+	if err != nil {
+		return
+	}
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	peers, err := vm.serverPeers(drain.ServerID)
+	if err != nil {
+		utils.LogError("Failed to list peers of draining server %s: %v", drain.ServerID, err)
+		return
+	}
+
+	ctx := context.Background()
+	handshakes := vm.peerManager.LatestHandshakes(ctx)
+	windowEnded := !time.Now().Before(drain.Deadline)
+
+	moved, remaining, active := 0, 0, 0
+	warned := false
+	for _, peer := range peers {
+		lastHandshake := peer.LastHandshake
+		if handshake, ok := handshakes[peer.PublicKey]; ok {
+			lastHandshake = handshake
+		}
+		sessionActive := wireguard.PeerStatus(lastHandshake) == wireguard.PeerStatusSessionActive
+
+		if sessionActive && !windowEnded {
+			remaining++
+			active++
+			continue
+		}
+
+		target := vm.migrationTarget(server)
+		if target == nil {
+			if !warned {
+				utils.LogWarning("No online server in %s has room for the peers of draining server %s", server.Country, drain.ServerID)
+				warned = true
+			}
+		} else if err := vm.migratePeer(ctx, peer, target, MigrateDrain); err != nil {
+			utils.LogError("Failed to migrate peer %s off draining server %s: %v", peer.ID, drain.ServerID, err)
+		} else {
+			moved++
+			continue
+		}
+
+		remaining++
+		if sessionActive {
+			active++
+		}
+	}
+
+	vm.serverManager.recordDrain(drain.ServerID, moved, remaining, active)
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Server features clients can filter the server list by
+const (
+	ServerFeatureObfuscation = "obfuscation" // runs a TCP or WebSocket obfuscation endpoint
+)
+
+// Server list orders
+const (
+	ServerSortName    = "name"
+	ServerSortLoad    = "load"    // share of capacity in use, least first
+	ServerSortLatency = "latency" // as observed from the client's region, fastest first
+)
+
+// ServerFilter represents the servers a client lists and their order
+type ServerFilter struct {
+	Country  string // name or country code
+	Region   string // continent, case-insensitive
+	Status   string
+	Features []string
+	Sort     string // defaults to name
+}
+
+// Validate checks the fields of a server filter
+func (f *ServerFilter) Validate() error {
+	var v utils.Validator
+	v.OneOf("status", f.Status, "online", "offline", "maintenance", "draining")
+	for _, feature := range f.Features {
+		v.OneOf("features", feature, ServerFeatureObfuscation)
+	}
+	v.OneOf("sort", f.Sort, ServerSortName, ServerSortLoad, ServerSortLatency)
+	return v.Err()
+}
+
+// matches checks whether a server passes the filter
+func (f *ServerFilter) matches(server *Server) bool {
+	if f.Country != "" && !strings.EqualFold(server.Country, f.Country) && !strings.EqualFold(server.CountryCode, f.Country) {
+		return false
+	}
+	if f.Region != "" && !strings.EqualFold(server.Region, f.Region) {
+		return false
+	}
+	if f.Status != "" && server.Status != f.Status {
+		return false
+	}
+	for _, feature := range f.Features {
+		if feature == ServerFeatureObfuscation && server.Capabilities.Obfuscation == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ListServers gets the servers passing a filter in its order, with the
+// latency clients in the region of an IP observed to them by server ID.
+// Servers nobody in the region pinged come last when sorting by latency.
+func (vm *VPNManager) ListServers(filter ServerFilter, ip string) ([]*Server, map[string]LatencyMeasurement) {
+	region := vm.clientRegion(ip)
+	latency := vm.serverManager.Latency()
+
+	servers := make([]*Server, 0)
+	latencies := make(map[string]LatencyMeasurement)
+	for _, server := range vm.serverManager.GetServers() {
+		if !filter.matches(server) {
+			continue
+		}
+		servers = append(servers, server)
+		if measurement, ok := latency.ClientLatency(region, server.ID); ok {
+			latencies[server.ID] = measurement
+		}
+	}
+
+	utilization := func(server *Server) float64 {
+		if server.Capacity <= 0 {
+			return 1
+		}
+		return float64(server.Load) / float64(server.Capacity)
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		a, b := servers[i], servers[j]
+		switch filter.Sort {
+		case ServerSortLoad:
+			if utilization(a) != utilization(b) {
+				return utilization(a) < utilization(b)
+			}
+		case ServerSortLatency:
+			aLatency, aOK := latencies[a.ID]
+			bLatency, bOK := latencies[b.ID]
+			if aOK != bOK {
+				return aOK
+			}
+			if aOK && aLatency.RTT != bLatency.RTT {
+				return aLatency.RTT < bLatency.RTT
+			}
+			if utilization(a) != utilization(b) {
+				return utilization(a) < utilization(b)
+			}
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+
+	return servers, latencies
+}
+
+// ServerListTag identifies the server list a filter gets for a client. It
+// changes whenever a server does or clients report latencies, so clients
+// can revalidate their copy without the list being built again.
+func (vm *VPNManager) ServerListTag(filter ServerFilter, ip string) string {
+	key := fmt.Sprintf("%d/%d/%s/%s/%s/%s/%s/%s",
+		vm.serverManager.ServersVersion(), vm.serverManager.Latency().ClientVersion(), vm.clientRegion(ip),
+		filter.Country, filter.Region, filter.Status, strings.Join(filter.Features, ","), filter.Sort)
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:12])
+}
@@ -2,47 +2,218 @@ package core
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/vpn-service/backend/src/cache"
 	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/geo"
 	"github.com/vpn-service/backend/src/utils"
 )
 
 // Server represents a VPN server
 type Server struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Country     string    `json:"country"`
-	City        string    `json:"city"`
-	IP          string    `json:"ip"`
-	Load        int       `json:"load"`
-	Capacity    int       `json:"capacity"`
-	Status      string    `json:"status"`
-	LastUpdated time.Time `json:"lastUpdated"`
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	PublicKey      string    `json:"publicKey,omitempty"` // WireGuard key of a registered server
+	Endpoint       string    `json:"endpoint,omitempty"`  // host:port clients reach a registered server on
+	Country        string    `json:"country"`
+	CountryCode    string    `json:"countryCode,omitempty"`
+	City           string    `json:"city"`
+	Region         string    `json:"region,omitempty"`         // continent, from the geo database
+	LocationSource string    `json:"locationSource,omitempty"` // geo or manual
+	ASN            uint      `json:"asn,omitempty"`
+	ASOrg          string    `json:"asOrg,omitempty"`
+	IP             string    `json:"ip"`
+	Load           int       `json:"load"`
+	Capacity       int       `json:"capacity"`
+	BandwidthClass string    `json:"bandwidthClass,omitempty"` // one of balancing.bandwidthClasses
+	Status         string    `json:"status"`
+	LastUpdated    time.Time `json:"lastUpdated"`
+
+	// Capabilities are reported by the server's node agent
+	Capabilities ServerCapabilities `json:"capabilities"`
 }
 
 // ServerManager manages VPN servers
 type ServerManager struct {
-	config  *config.Config
-	servers map[string]*Server
-	mutex   sync.RWMutex
+	config       *config.Config
+	servers      map[string]*Server
+	versions     map[string]*NodeVersion
+	health       map[string]*NodeHealth
+	drains       map[string]*ServerDrain
+	discovered   map[string]string // status of servers from service discovery, by ID
+	commands     *NodeCommandQueue
+	rollouts     *RolloutManager
+	upgrades     *NodeUpgradeManager
+	statusPage   *StatusPage
+	uptime       *UptimeTracker
+	provisioning *ProvisioningManager
+	autoscaler   *Autoscaler
+	alerts       *AlertMonitor
+	certificates *CertificateManager
+	agentCA      *AgentCA
+	latency      *LatencyMatrix
+	applies      *applyErrors
+	geo          *geo.Locator
+	lists        *cache.Cache[string, []*Server]
+	version      uint64 // of the server set, bumped on every change
+	events       *EventBus
+	drift        DriftObserver
+	mutex        sync.RWMutex
 }
 
 // NewServerManager creates a new server manager
 func NewServerManager(cfg *config.Config) *ServerManager {
 	sm := &ServerManager{
-		config:  cfg,
-		servers: make(map[string]*Server),
-		mutex:   sync.RWMutex{},
+		config:     cfg,
+		servers:    make(map[string]*Server),
+		versions:   make(map[string]*NodeVersion),
+		health:     make(map[string]*NodeHealth),
+		drains:     make(map[string]*ServerDrain),
+		discovered: make(map[string]string),
+		commands:   NewNodeCommandQueue(cfg),
+		latency:    NewLatencyMatrix(cfg),
+		applies:    newApplyErrors(cfg),
+		lists:      cache.New[string, []*Server]("server_lists", time.Duration(cfg.Cache.ServerLists)*time.Second),
+		version:    uint64(time.Now().UnixNano()), // so versions of other instances differ
+		mutex:      sync.RWMutex{},
 	}
 
-	// Initialize with default servers
-	sm.initializeServers()
+	// Initialize with default servers, unless they come from service
+	// discovery or custom resources
+	if cfg.Discovery.Backend == "" && !cfg.Kubernetes.Enabled {
+		sm.initializeServers()
+	}
+
+	// Load the last reported node versions
+	if err := sm.loadVersions(); err != nil {
+		utils.LogError("Failed to load node versions: %v", err)
+	}
+
+	sm.rollouts = NewRolloutManager(cfg, sm)
+	sm.upgrades = NewNodeUpgradeManager(cfg, sm)
+	sm.uptime = NewUptimeTracker(sm)
+	sm.statusPage = NewStatusPage(cfg, sm)
+	sm.provisioning = NewProvisioningManager(cfg, sm)
+	sm.autoscaler = NewAutoscaler(cfg, sm)
+	sm.alerts = NewAlertMonitor(cfg, sm)
 
 	return sm
 }
 
+// SetCertificateManager sets the manager whose node certificate is sent
+// to node agents with their heartbeat responses
+func (sm *ServerManager) SetCertificateManager(certificates *CertificateManager) {
+	sm.certificates = certificates
+}
+
+// SetAgentCA sets the CA node agents enroll with on the agent listener
+func (sm *ServerManager) SetAgentCA(ca *AgentCA) {
+	sm.agentCA = ca
+}
+
+// AgentCA gets the CA node agents enroll with, nil when the agent listener
+// is disabled
+func (sm *ServerManager) AgentCA() *AgentCA {
+	return sm.agentCA
+}
+
+// SetGeoLocator sets the geo database used to locate servers by IP
+func (sm *ServerManager) SetGeoLocator(locator *geo.Locator) {
+	sm.geo = locator
+}
+
+// SetEventBus sets the bus server status changes are published on
+func (sm *ServerManager) SetEventBus(events *EventBus) {
+	sm.events = events
+}
+
+// LocateServer fills the location and network of a server from its IP.
+// A country or city already set on the server is a manual override and is
+// kept; otherwise both come from the geo database.
+func (sm *ServerManager) LocateServer(server *Server) error {
+	manual := server.Country != "" || server.City != ""
+	if manual {
+		server.LocationSource = "manual"
+	}
+
+	if sm.geo == nil {
+		if manual {
+			return nil
+		}
+		return fmt.Errorf("geo lookups are not configured")
+	}
+
+	location, err := sm.geo.Lookup(server.IP)
+	if err != nil {
+		if manual {
+			return nil
+		}
+		return err
+	}
+
+	server.ASN = location.ASN
+	server.ASOrg = location.ASOrg
+	if manual {
+		if server.Country == location.Country {
+			server.CountryCode = location.CountryCode
+			server.Region = location.Continent
+		}
+		return nil
+	}
+
+	server.Country = location.Country
+	server.CountryCode = location.CountryCode
+	server.City = location.City
+	server.Region = location.Continent
+	server.LocationSource = "geo"
+
+	return nil
+}
+
+// Latency gets the matrix of latencies measured by node agents
+func (sm *ServerManager) Latency() *LatencyMatrix {
+	return sm.latency
+}
+
+// Rollouts gets the agent rollout manager
+func (sm *ServerManager) Rollouts() *RolloutManager {
+	return sm.rollouts
+}
+
+// Upgrades gets the rolling node upgrade manager
+func (sm *ServerManager) Upgrades() *NodeUpgradeManager {
+	return sm.upgrades
+}
+
+// Uptime gets the tracker of server status transitions and uptime
+func (sm *ServerManager) Uptime() *UptimeTracker {
+	return sm.uptime
+}
+
+// Provisioning gets the manager of servers provisioned on cloud providers
+func (sm *ServerManager) Provisioning() *ProvisioningManager {
+	return sm.provisioning
+}
+
+// Autoscaler gets the autoscaler of regions
+func (sm *ServerManager) Autoscaler() *Autoscaler {
+	return sm.autoscaler
+}
+
+// Alerts gets the monitor of capacity alert rules
+func (sm *ServerManager) Alerts() *AlertMonitor {
+	return sm.alerts
+}
+
+// StatusPage gets the public status page
+func (sm *ServerManager) StatusPage() *StatusPage {
+	return sm.statusPage
+}
+
 // initializeServers initializes the server list
 func (sm *ServerManager) initializeServers() {
 	// In a real implementation, this would load servers from a database
@@ -113,30 +284,38 @@ func (sm *ServerManager) GetServer(id string) (*Server, error) {
 	return server, nil
 }
 
-// GetServers gets all servers
+// GetServers gets all servers. The returned slice is shared and must not
+// be modified.
 func (sm *ServerManager) GetServers() []*Server {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
+	servers, _ := sm.lists.GetOrLoad("all", func() ([]*Server, error) {
+		sm.mutex.RLock()
+		defer sm.mutex.RUnlock()
 
-	servers := make([]*Server, 0, len(sm.servers))
-	for _, server := range sm.servers {
-		servers = append(servers, server)
-	}
+		servers := make([]*Server, 0, len(sm.servers))
+		for _, server := range sm.servers {
+			servers = append(servers, server)
+		}
+		return servers, nil
+	})
 
 	return servers
 }
 
-// GetServersByCountry gets servers by country
+// GetServersByCountry gets servers by country. The returned slice is shared
+// and must not be modified.
 func (sm *ServerManager) GetServersByCountry(country string) []*Server {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
+	servers, _ := sm.lists.GetOrLoad("country:"+country, func() ([]*Server, error) {
+		sm.mutex.RLock()
+		defer sm.mutex.RUnlock()
 
-	servers := make([]*Server, 0)
-	for _, server := range sm.servers {
-		if server.Country == country {
-			servers = append(servers, server)
+		servers := make([]*Server, 0)
+		for _, server := range sm.servers {
+			if server.Country == country {
+				servers = append(servers, server)
+			}
 		}
-	}
+		return servers, nil
+	})
 
 	return servers
 }
@@ -151,8 +330,14 @@ func (sm *ServerManager) UpdateServerStatus(id, status string) error {
 		return fmt.Errorf("server not found: %s", id)
 	}
 
+	previous := server.Status
 	server.Status = status
 	server.LastUpdated = time.Now()
+	sm.changed()
+
+	if previous != status {
+		sm.publishStatus(server, previous)
+	}
 
 	// Log analytics
 	utils.LogAnalytics("system", "server_status_update", fmt.Sprintf("server=%s status=%s", id, status))
@@ -170,37 +355,55 @@ func (sm *ServerManager) UpdateServerLoad(id string, load int) error {
 		return fmt.Errorf("server not found: %s", id)
 	}
 
-	server.Load = load
+	if server.Load != load {
+		server.Load = load
+		sm.changed()
+	}
 	server.LastUpdated = time.Now()
 
 	return nil
 }
 
-// GetOptimalServer gets the optimal server for a user
+// ServersVersion gets the version of the server set, which changes
+// whenever a server is added, changed or removed
+func (sm *ServerManager) ServersVersion() uint64 {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	return sm.version
+}
+
+// changed marks the server set changed, dropping cached server lists. The
+// caller holds sm.mutex.
+func (sm *ServerManager) changed() {
+	sm.version++
+	sm.lists.Purge()
+}
+
+// GetOptimalServer gets the online server with room and the best balance
+// score, in a country if given and it has any
 func (sm *ServerManager) GetOptimalServer(country string) (*Server, error) {
 	sm.mutex.RLock()
 	defer sm.mutex.RUnlock()
 
-	var candidates []*Server
+	candidates := make([]*Server, 0)
+	local := make([]*Server, 0)
+	for _, server := range sm.servers {
+		if server.Status != "online" {
+			continue
+		}
+		candidates = append(candidates, server)
+		if server.Country == country {
+			local = append(local, server)
+		}
+	}
 
-	// If country is specified, filter by country
+	// If no servers in the requested country, fall back to all servers
 	if country != "" {
-		candidates = sm.GetServersByCountry(country)
-		if len(candidates) == 0 {
-			// If no servers in the requested country, fall back to all servers
+		if len(local) > 0 {
+			candidates = local
+		} else {
 			utils.LogWarning("No servers found in country %s, falling back to all servers", country)
-			for _, server := range sm.servers {
-				if server.Status == "online" {
-					candidates = append(candidates, server)
-				}
-			}
-		}
-	} else {
-		// Otherwise, consider all online servers
-		for _, server := range sm.servers {
-			if server.Status == "online" {
-				candidates = append(candidates, server)
-			}
 		}
 	}
 
@@ -208,20 +411,17 @@ func (sm *ServerManager) GetOptimalServer(country string) (*Server, error) {
 		return nil, fmt.Errorf("no available servers")
 	}
 
-	// Find the server with the lowest load
+	// Find the server with the lowest score, skipping servers at capacity
 	var optimalServer *Server
-	lowestLoad := -1
-
+	lowestScore := math.Inf(1)
 	for _, server := range candidates {
-		// Skip servers at capacity
-		if server.Load >= server.Capacity {
+		score := sm.balanceScore(server)
+		if math.IsInf(score, 1) {
 			continue
 		}
-
-		// Initialize or update if we find a server with lower load
-		if lowestLoad == -1 || server.Load < lowestLoad {
+		if optimalServer == nil || score < lowestScore || (score == lowestScore && server.ID < optimalServer.ID) {
 			optimalServer = server
-			lowestLoad = server.Load
+			lowestScore = score
 		}
 	}
 
@@ -232,6 +432,37 @@ func (sm *ServerManager) GetOptimalServer(country string) (*Server, error) {
 	return optimalServer, nil
 }
 
+// GetFailoverServers gets online servers with spare capacity to retry a
+// connect on, excluding the given servers. Servers in the preferred country
+// come first, then servers are ordered by load.
+func (sm *ServerManager) GetFailoverServers(country string, exclude ...string) []*Server {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	excluded := make(map[string]bool, len(exclude))
+	for _, id := range exclude {
+		excluded[id] = true
+	}
+
+	candidates := make([]*Server, 0)
+	for _, server := range sm.servers {
+		if excluded[server.ID] || server.Status != "online" || server.Load >= server.Capacity {
+			continue
+		}
+		candidates = append(candidates, server)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		iLocal, jLocal := candidates[i].Country == country, candidates[j].Country == country
+		if iLocal != jLocal {
+			return iLocal
+		}
+		return candidates[i].Load < candidates[j].Load
+	})
+
+	return candidates
+}
+
 // AddServer adds a new server
 func (sm *ServerManager) AddServer(server *Server) error {
 	sm.mutex.Lock()
@@ -247,6 +478,7 @@ func (sm *ServerManager) AddServer(server *Server) error {
 
 	// Add server
 	sm.servers[server.ID] = server
+	sm.changed()
 
 	// Log analytics
 	utils.LogAnalytics("system", "server_added", fmt.Sprintf("server=%s", server.ID))
@@ -254,6 +486,92 @@ func (sm *ServerManager) AddServer(server *Server) error {
 	return nil
 }
 
+// UpdateServer replaces the name, address and location of a server
+func (sm *ServerManager) UpdateServer(server *Server) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	// Check if server exists
+	existing, ok := sm.servers[server.ID]
+	if !ok {
+		return fmt.Errorf("server not found: %s", server.ID)
+	}
+
+	// Keep the state reported by the node itself
+	server.Status = existing.Status
+	server.Load = existing.Load
+	server.Capacity = existing.Capacity
+	server.LastUpdated = time.Now()
+
+	// Update server
+	sm.servers[server.ID] = server
+	sm.changed()
+
+	// Log analytics
+	utils.LogAnalytics("system", "server_updated", fmt.Sprintf("server=%s", server.ID))
+
+	return nil
+}
+
+// RegisterServer adds a server that registered itself, or updates the one
+// registered earlier with the same public key, as nodes register on every
+// start. It reports whether the server is new.
+func (sm *ServerManager) RegisterServer(server *Server) (*Server, bool, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	for _, existing := range sm.servers {
+		if existing.PublicKey == "" || existing.PublicKey != server.PublicKey {
+			continue
+		}
+
+		// Keep the state reported by the node itself
+		server.ID = existing.ID
+		server.Status = existing.Status
+		server.Load = existing.Load
+		server.Capabilities = existing.Capabilities
+		server.LastUpdated = time.Now()
+
+		// Keep what admins set on the server: its bandwidth class, and its
+		// location when set by hand or when it cannot be resolved again
+		if existing.BandwidthClass != "" {
+			server.BandwidthClass = existing.BandwidthClass
+		}
+		if existing.LocationSource == "manual" || server.LocationSource == "" {
+			server.Country = existing.Country
+			server.CountryCode = existing.CountryCode
+			server.City = existing.City
+			server.Region = existing.Region
+			server.LocationSource = existing.LocationSource
+		}
+
+		sm.servers[server.ID] = server
+		sm.changed()
+
+		utils.LogInfo("Server %s registered again from %s", server.ID, server.Endpoint)
+
+		// Log analytics
+		utils.LogAnalytics("system", "server_registered", fmt.Sprintf("server=%s endpoint=%s new=false", server.ID, server.Endpoint))
+
+		copied := *server
+		return &copied, false, nil
+	}
+
+	server.ID = utils.GenerateUUID()
+	server.Status = "offline" // until its agent reports a heartbeat
+	server.LastUpdated = time.Now()
+	sm.servers[server.ID] = server
+	sm.changed()
+
+	utils.LogInfo("Registered server %s (%s) at %s", server.ID, server.Name, server.Endpoint)
+
+	// Log analytics
+	utils.LogAnalytics("system", "server_registered", fmt.Sprintf("server=%s endpoint=%s new=true", server.ID, server.Endpoint))
+
+	copied := *server
+	return &copied, true, nil
+}
+
 // RemoveServer removes a server
 func (sm *ServerManager) RemoveServer(id string) error {
 	sm.mutex.Lock()
@@ -266,6 +584,9 @@ func (sm *ServerManager) RemoveServer(id string) error {
 
 	// Remove server
 	delete(sm.servers, id)
+	sm.changed()
+	sm.latency.Forget(id)
+	sm.applies.forget(id)
 
 	// Log analytics
 	utils.LogAnalytics("system", "server_removed", fmt.Sprintf("server=%s", id))
@@ -273,9 +594,10 @@ func (sm *ServerManager) RemoveServer(id string) error {
 	return nil
 }
 
-// MonitorServers periodically checks server status
+// MonitorServers periodically marks servers whose agent stopped sending
+// heartbeats offline
 func (sm *ServerManager) MonitorServers() {
-	ticker := time.NewTicker(1 * time.Minute)
+	ticker := time.NewTicker(sm.heartbeatInterval())
 	defer ticker.Stop()
 
 	for range ticker.C {
@@ -283,26 +605,41 @@ func (sm *ServerManager) MonitorServers() {
 	}
 }
 
-// checkServerStatus checks the status of all servers
+// checkServerStatus marks online servers that missed nodes.missedHeartbeats
+// heartbeats offline. Servers whose agent never reported a heartbeat are
+// left alone, and a heartbeat brings a server back online.
 func (sm *ServerManager) checkServerStatus() {
+	missed := sm.config.Nodes.MissedHeartbeats
+	if missed <= 0 {
+		missed = 3
+	}
+	deadline := time.Duration(missed) * sm.heartbeatInterval()
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
 	for id, server := range sm.servers {
-		// In a real implementation, this would ping the server or check its health endpoint
-		// For now, we'll just simulate a check
-		if utils.RandomBool(0.95) { // 95% chance of being online
-			if server.Status != "online" {
-				server.Status = "online"
-				server.LastUpdated = time.Now()
-				utils.LogInfo("Server %s is now online", id)
-			}
-		} else {
-			if server.Status != "offline" {
-				server.Status = "offline"
-				server.LastUpdated = time.Now()
-				utils.LogWarning("Server %s is now offline", id)
-			}
+		version, ok := sm.versions[id]
+		if !ok || server.Status != "online" || time.Since(version.LastHeartbeat) < deadline {
+			continue
 		}
+
+		previous := server.Status
+		server.Status = "offline"
+		server.LastUpdated = time.Now()
+		sm.changed()
+		utils.LogWarning("Server %s is now offline, no heartbeat since %s", id, version.LastHeartbeat.Format(time.RFC3339))
+		sm.publishStatus(server, previous)
 	}
 }
+
+// publishStatus publishes a server status change
+func (sm *ServerManager) publishStatus(server *Server, previous string) {
+	sm.uptime.record(server.ID, previous, server.Status, server.LastUpdated)
+	sm.events.Publish(EventServerStatus, ServerStatusEvent{
+		ServerID: server.ID,
+		Name:     server.Name,
+		Status:   server.Status,
+		Previous: previous,
+	})
+}
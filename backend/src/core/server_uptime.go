@@ -0,0 +1,309 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// UptimeWindow is a span uptime is reported over, ending now
+type UptimeWindow struct {
+	Name     string
+	Duration time.Duration
+}
+
+// UptimeWindows are the windows server uptime is reported over. The last
+// is the longest, and how long status transitions are kept.
+var UptimeWindows = []UptimeWindow{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// uptimeHistory is how long status transitions are kept
+var uptimeHistory = UptimeWindows[len(UptimeWindows)-1].Duration
+
+// ServerTransition represents a change of a server's status
+type ServerTransition struct {
+	ID       string    `json:"id" db:"id"`
+	ServerID string    `json:"serverId" db:"server_id"`
+	Status   string    `json:"status" db:"status"`
+	Previous string    `json:"previous" db:"previous"`
+	At       time.Time `json:"at" db:"at"`
+}
+
+// ServerUptime represents the uptime of a server over each window
+type ServerUptime struct {
+	ServerID    string              `json:"serverId"`
+	Name        string              `json:"name"`
+	Status      string              `json:"status"`
+	Since       *time.Time          `json:"since,omitempty"`       // of the current status, when it changed in the last 30 days
+	Uptime      map[string]float64  `json:"uptime"`                // percent of each window the server was not offline, time in maintenance left out
+	Transitions []*ServerTransition `json:"transitions,omitempty"` // of the last 30 days, oldest first; single servers only
+}
+
+// UptimeObserver is notified of the uptime ratio, 0 to 1, of every server
+// over each window by server ID and window name
+type UptimeObserver func(uptime map[string]map[string]float64)
+
+// UptimeTracker records the status transitions of servers to report how
+// long each was up
+type UptimeTracker struct {
+	servers     *ServerManager
+	transitions map[string][]*ServerTransition // by server ID, oldest first
+	observer    UptimeObserver
+	mutex       sync.RWMutex
+}
+
+// NewUptimeTracker creates a new uptime tracker. Servers whose status
+// changed while the API was down get a transition to it now.
+func NewUptimeTracker(servers *ServerManager) *UptimeTracker {
+	ut := &UptimeTracker{
+		servers:     servers,
+		transitions: make(map[string][]*ServerTransition),
+		mutex:       sync.RWMutex{},
+	}
+
+	if err := ut.load(); err != nil {
+		utils.LogError("Failed to load server transitions: %v", err)
+	}
+
+	for _, server := range servers.GetServers() {
+		if last := ut.last(server.ID); last != nil && last.Status != server.Status {
+			ut.record(server.ID, last.Status, server.Status, time.Now())
+		}
+	}
+
+	return ut
+}
+
+// SetObserver sets the observer notified of server uptime, e.g. for metrics
+func (ut *UptimeTracker) SetObserver(observer UptimeObserver) {
+	ut.observer = observer
+}
+
+// record records a server changing status, and forgets the transitions of
+// the server that no longer matter for the longest window
+func (ut *UptimeTracker) record(serverID, previous, status string, at time.Time) {
+	transition := &ServerTransition{
+		ID:       utils.GenerateUUID(),
+		ServerID: serverID,
+		Status:   status,
+		Previous: previous,
+		At:       at,
+	}
+
+	ut.mutex.Lock()
+	transitions := append(ut.transitions[serverID], transition)
+	ut.transitions[serverID] = prune(transitions, at.Add(-uptimeHistory))
+	ut.mutex.Unlock()
+
+	// Servers change status under the server manager's lock, so the
+	// database is written without holding it up
+	go ut.save(transition)
+}
+
+// prune drops the transitions before a cutoff, keeping the last of them
+// since it gives the status at the cutoff
+func prune(transitions []*ServerTransition, cutoff time.Time) []*ServerTransition {
+	keep := 0
+	for keep < len(transitions)-1 && !transitions[keep+1].At.After(cutoff) {
+		keep++
+	}
+	return transitions[keep:]
+}
+
+// save writes a transition to the database and deletes those older than
+// the longest window
+func (ut *UptimeTracker) save(transition *ServerTransition) {
+	if db.DB == nil {
+		return
+	}
+
+	_, err := db.DB.Exec(
+		`INSERT INTO server_transitions (id, server_id, status, previous, at) VALUES ($1, $2, $3, $4, $5)`,
+		transition.ID, transition.ServerID, transition.Status, transition.Previous, transition.At,
+	)
+	if err != nil {
+		utils.LogError("Failed to save status transition of server %s: %v", transition.ServerID, err)
+	}
+
+	if _, err := db.DB.Exec(`DELETE FROM server_transitions WHERE at < $1`, time.Now().Add(-uptimeHistory)); err != nil {
+		utils.LogError("Failed to delete old server transitions: %v", err)
+	}
+}
+
+// last gets the latest transition of a server, nil without any
+func (ut *UptimeTracker) last(serverID string) *ServerTransition {
+	ut.mutex.RLock()
+	defer ut.mutex.RUnlock()
+
+	transitions := ut.transitions[serverID]
+	if len(transitions) == 0 {
+		return nil
+	}
+	return transitions[len(transitions)-1]
+}
+
+// ratio gets the share of a window ending now a server was not offline,
+// leaving time in maintenance out. The server had the status before its
+// first known transition for the rest of the window, or its current status
+// throughout without transitions.
+func (ut *UptimeTracker) ratio(server *Server, window time.Duration, now time.Time) float64 {
+	ut.mutex.RLock()
+	defer ut.mutex.RUnlock()
+
+	start := now.Add(-window)
+	transitions := ut.transitions[server.ID]
+
+	status := server.Status
+	if len(transitions) > 0 {
+		status = transitions[0].Previous
+	}
+
+	var offline, maintenance time.Duration
+	from := start
+	for _, transition := range transitions {
+		if transition.At.After(start) {
+			switch status {
+			case "offline":
+				offline += transition.At.Sub(from)
+			case "maintenance":
+				maintenance += transition.At.Sub(from)
+			}
+			from = transition.At
+		}
+		status = transition.Status
+	}
+	switch status {
+	case "offline":
+		offline += now.Sub(from)
+	case "maintenance":
+		maintenance += now.Sub(from)
+	}
+
+	tracked := window - maintenance
+	if tracked <= 0 {
+		return 1
+	}
+	return math.Max(0, 1-float64(offline)/float64(tracked))
+}
+
+// serverUptime gets the uptime of a server over each window
+func (ut *UptimeTracker) serverUptime(server *Server, now time.Time) *ServerUptime {
+	uptime := &ServerUptime{
+		ServerID: server.ID,
+		Name:     server.Name,
+		Status:   server.Status,
+		Uptime:   make(map[string]float64, len(UptimeWindows)),
+	}
+	for _, window := range UptimeWindows {
+		uptime.Uptime[window.Name] = math.Round(ut.ratio(server, window.Duration, now)*10000) / 100
+	}
+	if last := ut.last(server.ID); last != nil && last.At.After(now.Add(-uptimeHistory)) {
+		since := last.At
+		uptime.Since = &since
+	}
+	return uptime
+}
+
+// ListUptime gets the uptime of every server, sorted by ID
+func (ut *UptimeTracker) ListUptime() []*ServerUptime {
+	now := time.Now()
+	servers := append([]*Server{}, ut.servers.GetServers()...)
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].ID < servers[j].ID
+	})
+
+	uptimes := make([]*ServerUptime, 0, len(servers))
+	for _, server := range servers {
+		uptimes = append(uptimes, ut.serverUptime(server, now))
+	}
+
+	return uptimes
+}
+
+// GetUptime gets the uptime of a server with its transitions of the
+// longest window
+func (ut *UptimeTracker) GetUptime(serverID string) (*ServerUptime, error) {
+	server, err := ut.servers.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	uptime := ut.serverUptime(server, now)
+
+	ut.mutex.RLock()
+	defer ut.mutex.RUnlock()
+
+	cutoff := now.Add(-uptimeHistory)
+	uptime.Transitions = make([]*ServerTransition, 0)
+	for _, transition := range ut.transitions[serverID] {
+		if transition.At.After(cutoff) {
+			uptime.Transitions = append(uptime.Transitions, transition)
+		}
+	}
+
+	return uptime, nil
+}
+
+// RunMetrics reports the uptime of every server to the observer every
+// minute
+func (ut *UptimeTracker) RunMetrics() {
+	if ut.observer == nil {
+		return
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	ut.reportMetrics()
+	for range ticker.C {
+		ut.reportMetrics()
+	}
+}
+
+// reportMetrics reports the uptime of every server to the observer
+func (ut *UptimeTracker) reportMetrics() {
+	now := time.Now()
+	uptime := make(map[string]map[string]float64)
+	for _, server := range ut.servers.GetServers() {
+		ratios := make(map[string]float64, len(UptimeWindows))
+		for _, window := range UptimeWindows {
+			ratios[window.Name] = ut.ratio(server, window.Duration, now)
+		}
+		uptime[server.ID] = ratios
+	}
+	ut.observer(uptime)
+}
+
+// load reads the transitions of the longest window from the database
+func (ut *UptimeTracker) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	transitions := []*ServerTransition{}
+	err := db.DB.Select(&transitions,
+		`SELECT id, server_id, status, previous, at FROM server_transitions WHERE at > $1 ORDER BY at`,
+		time.Now().Add(-uptimeHistory),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query server transitions: %v", err)
+	}
+
+	ut.mutex.Lock()
+	defer ut.mutex.Unlock()
+
+	for _, transition := range transitions {
+		ut.transitions[transition.ServerID] = append(ut.transitions[transition.ServerID], transition)
+	}
+
+	return nil
+}
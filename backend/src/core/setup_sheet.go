@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GetSetupSheet renders a printable setup sheet for a peer, with its config
+// as a QR code and the setup steps of its device type, for handing to
+// people who set up their device on paper
+func (vm *VPNManager) GetSetupSheet(ctx context.Context, userID, peerID string) (pdf []byte, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.GetSetupSheet", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	peer, config, err := vm.peerConfig(ctx, userID, peerID, "setup_sheet")
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := &wireguard.SetupSheet{
+		DeviceName: peer.DeviceName,
+		DeviceType: peer.DeviceType,
+		Config:     config,
+		CreatedAt:  time.Now(),
+	}
+	if server, err := vm.serverManager.GetServer(peer.ServerID); err == nil {
+		sheet.ServerName = server.Name
+	}
+
+	pdf, err = sheet.RenderPDF()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render setup sheet: %v", err)
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "setup_sheet", fmt.Sprintf("peer=%s device=%s", peer.ID, peer.DeviceType))
+
+	return pdf, nil
+}
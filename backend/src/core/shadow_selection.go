@@ -0,0 +1,398 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// maxShadowDisagreements limits the disagreements returned per algorithm
+// in a comparison report
+const maxShadowDisagreements = 100
+
+// SelectionRequest describes a connect to a server selection algorithm
+type SelectionRequest struct {
+	RequestedServerID string
+	Country           string // country of the requested server
+	DeviceType        string
+	Dynamic           bool
+}
+
+// SelectionAlgorithm picks a server for a connect from a snapshot of the
+// fleet. Algorithms must not keep or modify the servers they are given.
+type SelectionAlgorithm func(req SelectionRequest, servers []*Server) (*Server, error)
+
+var (
+	// selectionAlgorithms are the algorithms available for shadow evaluation
+	selectionAlgorithms = map[string]SelectionAlgorithm{
+		"least_loaded":         selectLeastLoaded,
+		"least_loaded_country": selectLeastLoadedInCountry,
+		"most_headroom":        selectMostHeadroom,
+	}
+	selectionMutex sync.RWMutex
+)
+
+// RegisterSelectionAlgorithm makes a server selection algorithm available
+// for shadow evaluation under a name
+func RegisterSelectionAlgorithm(name string, algorithm SelectionAlgorithm) {
+	selectionMutex.Lock()
+	defer selectionMutex.Unlock()
+
+	selectionAlgorithms[name] = algorithm
+}
+
+// SelectionAlgorithms gets the names of the registered selection algorithms
+func SelectionAlgorithms() []string {
+	selectionMutex.RLock()
+	defer selectionMutex.RUnlock()
+
+	names := make([]string, 0, len(selectionAlgorithms))
+	for name := range selectionAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ShadowDecision represents what an algorithm would have picked for a
+// connect, next to the server actually used
+type ShadowDecision struct {
+	Algorithm         string    `json:"algorithm"`
+	RequestedServerID string    `json:"requestedServerId"`
+	ActualServerID    string    `json:"actualServerId"`
+	ChosenServerID    string    `json:"chosenServerId,omitempty"`
+	ActualUtilization int       `json:"actualUtilization"`           // percent of capacity in use before the connect
+	ChosenUtilization int       `json:"chosenUtilization,omitempty"` // percent of capacity in use before the connect
+	Agreed            bool      `json:"agreed"`
+	Error             string    `json:"error,omitempty"`
+	DurationMs        float64   `json:"durationMs"`
+	Time              time.Time `json:"time"`
+}
+
+// ShadowAlgorithmReport compares an algorithm's decisions with the servers
+// actually used
+type ShadowAlgorithmReport struct {
+	Algorithm            string            `json:"algorithm"`
+	Evaluations          int               `json:"evaluations"`
+	Agreements           int               `json:"agreements"`
+	Errors               int               `json:"errors"`
+	AgreementRate        float64           `json:"agreementRate"`        // of evaluations without errors
+	CountryMatchRate     float64           `json:"countryMatchRate"`     // picks in the requested server's country
+	AvgChosenUtilization float64           `json:"avgChosenUtilization"` // percent
+	AvgActualUtilization float64           `json:"avgActualUtilization"` // percent
+	AvgDurationMs        float64           `json:"avgDurationMs"`
+	Disagreements        []*ShadowDecision `json:"disagreements"` // most recent first
+}
+
+// ShadowReport compares shadow algorithms with the production selection
+// over the decisions kept in memory
+type ShadowReport struct {
+	Enabled    bool                     `json:"enabled"`
+	Since      *time.Time               `json:"since,omitempty"` // oldest decision covered
+	Algorithms []*ShadowAlgorithmReport `json:"algorithms"`
+}
+
+// ShadowEvaluator runs selection algorithms in the background on sampled
+// connects and keeps their decisions for comparison. Users are always
+// connected by the production selection.
+type ShadowEvaluator struct {
+	config    *config.Config
+	servers   *ServerManager
+	decisions []*ShadowDecision // oldest first
+	mutex     sync.Mutex
+}
+
+// NewShadowEvaluator creates a new shadow evaluator
+func NewShadowEvaluator(cfg *config.Config, servers *ServerManager) *ShadowEvaluator {
+	for _, name := range cfg.Shadow.Algorithms {
+		if _, ok := lookupSelectionAlgorithm(name); !ok {
+			utils.LogWarning("Unknown shadow selection algorithm %s is ignored", name)
+		}
+	}
+
+	return &ShadowEvaluator{
+		config:    cfg,
+		servers:   servers,
+		decisions: make([]*ShadowDecision, 0),
+	}
+}
+
+// ShadowRun is a sampled connect whose selection is being shadowed. A nil
+// run discards its outcome, for connects that are not sampled.
+type ShadowRun struct {
+	evaluator *ShadowEvaluator
+	request   SelectionRequest
+	servers   []*Server
+}
+
+// Begin samples a connect for shadow evaluation, capturing the fleet as the
+// production selection sees it. It returns nil when the connect is not
+// sampled.
+func (se *ShadowEvaluator) Begin(req SelectionRequest) *ShadowRun {
+	if se == nil || !se.config.Shadow.Enabled || rand.Float64() >= se.config.Shadow.SampleRate {
+		return nil
+	}
+
+	servers := se.servers.GetServers()
+	snapshot := make([]*Server, len(servers))
+	for i, server := range servers {
+		copied := *server
+		snapshot[i] = &copied
+	}
+
+	return &ShadowRun{evaluator: se, request: req, servers: snapshot}
+}
+
+// Finish evaluates the shadow algorithms in the background against the
+// server the connect actually used
+func (run *ShadowRun) Finish(actual *Server) {
+	if run == nil || actual == nil {
+		return
+	}
+
+	go run.evaluator.evaluate(run, actual.ID)
+}
+
+// evaluate runs every configured algorithm on a sampled connect
+func (se *ShadowEvaluator) evaluate(run *ShadowRun, actualID string) {
+	byID := make(map[string]*Server, len(run.servers))
+	for _, server := range run.servers {
+		byID[server.ID] = server
+	}
+
+	decisions := make([]*ShadowDecision, 0)
+	for _, name := range se.algorithms() {
+		algorithm, _ := lookupSelectionAlgorithm(name)
+
+		decision := &ShadowDecision{
+			Algorithm:         name,
+			RequestedServerID: run.request.RequestedServerID,
+			ActualServerID:    actualID,
+			ActualUtilization: utilization(byID[actualID]),
+			Time:              time.Now(),
+		}
+
+		started := time.Now()
+		chosen, err := runSelection(algorithm, run.request, run.servers)
+		decision.DurationMs = float64(time.Since(started).Microseconds()) / 1000
+		switch {
+		case err != nil:
+			decision.Error = err.Error()
+		case chosen == nil:
+			decision.Error = "no server selected"
+		default:
+			decision.ChosenServerID = chosen.ID
+			decision.ChosenUtilization = utilization(byID[chosen.ID])
+			decision.Agreed = chosen.ID == actualID
+		}
+
+		decisions = append(decisions, decision)
+	}
+
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	se.decisions = append(se.decisions, decisions...)
+	if history := se.config.Shadow.History; history > 0 && len(se.decisions) > history {
+		se.decisions = append([]*ShadowDecision(nil), se.decisions[len(se.decisions)-history:]...)
+	}
+}
+
+// Report compares the decisions of each algorithm, or of a single one if
+// named, with the servers actually used
+func (se *ShadowEvaluator) Report(algorithm string) *ShadowReport {
+	se.mutex.Lock()
+	decisions := append([]*ShadowDecision(nil), se.decisions...)
+	se.mutex.Unlock()
+
+	report := &ShadowReport{
+		Enabled:    se.config.Shadow.Enabled,
+		Algorithms: make([]*ShadowAlgorithmReport, 0),
+	}
+	if len(decisions) > 0 {
+		report.Since = &decisions[0].Time
+	}
+
+	// Servers are looked up once for country matches
+	countries := make(map[string]string)
+	for _, server := range se.servers.GetServers() {
+		countries[server.ID] = server.Country
+	}
+
+	byAlgorithm := make(map[string]*ShadowAlgorithmReport)
+	totals := make(map[string]*struct{ chosen, actual, duration float64 })
+	countryMatches := make(map[string]int)
+	for i := len(decisions) - 1; i >= 0; i-- {
+		decision := decisions[i]
+		if algorithm != "" && decision.Algorithm != algorithm {
+			continue
+		}
+
+		entry, ok := byAlgorithm[decision.Algorithm]
+		if !ok {
+			entry = &ShadowAlgorithmReport{Algorithm: decision.Algorithm, Disagreements: make([]*ShadowDecision, 0)}
+			byAlgorithm[decision.Algorithm] = entry
+			totals[decision.Algorithm] = &struct{ chosen, actual, duration float64 }{}
+			report.Algorithms = append(report.Algorithms, entry)
+		}
+		total := totals[decision.Algorithm]
+
+		entry.Evaluations++
+		total.duration += decision.DurationMs
+		if decision.Error != "" {
+			entry.Errors++
+			continue
+		}
+
+		total.chosen += float64(decision.ChosenUtilization)
+		total.actual += float64(decision.ActualUtilization)
+		if country, ok := countries[decision.RequestedServerID]; ok && countries[decision.ChosenServerID] == country {
+			countryMatches[decision.Algorithm]++
+		}
+		if decision.Agreed {
+			entry.Agreements++
+		} else if len(entry.Disagreements) < maxShadowDisagreements {
+			entry.Disagreements = append(entry.Disagreements, decision)
+		}
+	}
+
+	for _, entry := range report.Algorithms {
+		total := totals[entry.Algorithm]
+		entry.AvgDurationMs = total.duration / float64(entry.Evaluations)
+		if decided := entry.Evaluations - entry.Errors; decided > 0 {
+			entry.AgreementRate = float64(entry.Agreements) / float64(decided)
+			entry.CountryMatchRate = float64(countryMatches[entry.Algorithm]) / float64(decided)
+			entry.AvgChosenUtilization = total.chosen / float64(decided)
+			entry.AvgActualUtilization = total.actual / float64(decided)
+		}
+	}
+
+	sort.Slice(report.Algorithms, func(i, j int) bool {
+		return report.Algorithms[i].Algorithm < report.Algorithms[j].Algorithm
+	})
+
+	return report
+}
+
+// algorithms gets the names of the algorithms to evaluate
+func (se *ShadowEvaluator) algorithms() []string {
+	if len(se.config.Shadow.Algorithms) == 0 {
+		return SelectionAlgorithms()
+	}
+
+	names := make([]string, 0, len(se.config.Shadow.Algorithms))
+	for _, name := range se.config.Shadow.Algorithms {
+		if _, ok := lookupSelectionAlgorithm(name); ok {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// lookupSelectionAlgorithm gets a registered selection algorithm by name
+func lookupSelectionAlgorithm(name string) (SelectionAlgorithm, bool) {
+	selectionMutex.RLock()
+	defer selectionMutex.RUnlock()
+
+	algorithm, ok := selectionAlgorithms[name]
+	return algorithm, ok
+}
+
+// runSelection runs an algorithm, turning a panic into an error so a faulty
+// algorithm cannot take the service down
+func runSelection(algorithm SelectionAlgorithm, req SelectionRequest, servers []*Server) (chosen *Server, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("algorithm panicked: %v", r)
+		}
+	}()
+
+	return algorithm(req, servers)
+}
+
+// utilization gets the percentage of a server's capacity in use
+func utilization(server *Server) int {
+	if server == nil || server.Capacity <= 0 {
+		return 0
+	}
+	return server.Load * 100 / server.Capacity
+}
+
+// availableServers filters online servers with spare capacity
+func availableServers(servers []*Server) []*Server {
+	available := make([]*Server, 0, len(servers))
+	for _, server := range servers {
+		if server.Status == "online" && server.Load < server.Capacity {
+			available = append(available, server)
+		}
+	}
+	return available
+}
+
+// selectLeastLoaded picks the server with the lowest load anywhere
+func selectLeastLoaded(req SelectionRequest, servers []*Server) (*Server, error) {
+	var best *Server
+	for _, server := range availableServers(servers) {
+		if best == nil || server.Load < best.Load {
+			best = server
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no available servers")
+	}
+
+	return best, nil
+}
+
+// selectLeastLoadedInCountry picks the server with the lowest load in the
+// requested country, falling back to anywhere
+func selectLeastLoadedInCountry(req SelectionRequest, servers []*Server) (*Server, error) {
+	local := make([]*Server, 0)
+	for _, server := range servers {
+		if server.Country == req.Country {
+			local = append(local, server)
+		}
+	}
+
+	if server, err := selectLeastLoaded(req, local); err == nil {
+		return server, nil
+	}
+	return selectLeastLoaded(req, servers)
+}
+
+// selectMostHeadroom picks the server in the requested country with the
+// most spare capacity relative to its size, falling back to anywhere
+func selectMostHeadroom(req SelectionRequest, servers []*Server) (*Server, error) {
+	pick := func(candidates []*Server) *Server {
+		var best *Server
+		for _, server := range availableServers(candidates) {
+			if best == nil || utilization(server) < utilization(best) {
+				best = server
+			}
+		}
+		return best
+	}
+
+	local := make([]*Server, 0)
+	for _, server := range servers {
+		if server.Country == req.Country {
+			local = append(local, server)
+		}
+	}
+
+	if best := pick(local); best != nil {
+		return best, nil
+	}
+	if best := pick(servers); best != nil {
+		return best, nil
+	}
+
+	return nil, fmt.Errorf("no available servers")
+}
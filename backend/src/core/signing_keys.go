@@ -0,0 +1,324 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// SigningKey represents an asymmetric key used to sign JWT tokens
+type SigningKey struct {
+	ID         string    `json:"kid"`
+	Algorithm  string    `json:"alg"`
+	CreatedAt  time.Time `json:"createdAt"`
+	privateKey crypto.Signer
+}
+
+// JWK represents a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet represents a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// SigningKeyManager manages the keys used to sign and verify JWT tokens.
+// Tokens are signed with the newest key; every key in the set stays valid
+// for verification so tokens survive a rotation until they expire.
+type SigningKeyManager struct {
+	config  *config.Config
+	keys    map[string]*SigningKey
+	current string
+	mutex   sync.RWMutex
+}
+
+// NewSigningKeyManager creates a new signing key manager, loading existing
+// keys from the key directory and generating one if none exist
+func NewSigningKeyManager(cfg *config.Config) (*SigningKeyManager, error) {
+	km := &SigningKeyManager{
+		config: cfg,
+		keys:   make(map[string]*SigningKey),
+		mutex:  sync.RWMutex{},
+	}
+
+	if err := os.MkdirAll(cfg.JWT.KeyDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %v", err)
+	}
+
+	if err := km.load(); err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %v", err)
+	}
+
+	if len(km.keys) == 0 {
+		if _, err := km.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return km, nil
+}
+
+// Sign signs the given claims with the current key
+func (km *SigningKeyManager) Sign(claims jwt.MapClaims) (string, error) {
+	km.mutex.RLock()
+	key, ok := km.keys[km.current]
+	km.mutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("no signing key available")
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.privateKey)
+}
+
+// Keyfunc resolves the public key for a token from its kid header
+func (km *SigningKeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token has no key ID")
+	}
+
+	km.mutex.RLock()
+	key, ok := km.keys[kid]
+	km.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID: %s", kid)
+	}
+
+	// Reject tokens claiming a different algorithm than the key's
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+	}
+
+	return key.privateKey.Public(), nil
+}
+
+// Rotate generates a new key and makes it the current signing key
+func (km *SigningKeyManager) Rotate() (*SigningKey, error) {
+	key, err := generateSigningKey(km.config.JWT.Algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+
+	if err := km.save(key); err != nil {
+		return nil, fmt.Errorf("failed to save signing key: %v", err)
+	}
+
+	km.mutex.Lock()
+	km.keys[key.ID] = key
+	km.current = key.ID
+	km.mutex.Unlock()
+
+	utils.LogInfo("Rotated JWT signing key: kid=%s alg=%s", key.ID, key.Algorithm)
+
+	return key, nil
+}
+
+// Retire removes a key so tokens signed with it are no longer accepted
+func (km *SigningKeyManager) Retire(kid string) error {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	if _, ok := km.keys[kid]; !ok {
+		return fmt.Errorf("signing key not found: %s", kid)
+	}
+	if kid == km.current {
+		return fmt.Errorf("cannot retire the current signing key")
+	}
+
+	if err := os.Remove(filepath.Join(km.config.JWT.KeyDir, kid+".pem")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove signing key: %v", err)
+	}
+	delete(km.keys, kid)
+
+	utils.LogInfo("Retired JWT signing key: kid=%s", kid)
+
+	return nil
+}
+
+// ListKeys lists all keys accepted for verification, newest first
+func (km *SigningKeyManager) ListKeys() []*SigningKey {
+	km.mutex.RLock()
+	defer km.mutex.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(km.keys))
+	for _, key := range km.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys
+}
+
+// JWTConfig returns the JWT configuration the keys were loaded with
+func (km *SigningKeyManager) JWTConfig() config.JWTConfig {
+	return km.config.JWT
+}
+
+// JWKS returns the public keys in JSON Web Key Set format
+func (km *SigningKeyManager) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0)}
+	for _, key := range km.ListKeys() {
+		jwk, err := key.jwk()
+		if err != nil {
+			utils.LogError("Failed to encode signing key %s: %v", key.ID, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// load reads all PEM encoded keys from the key directory
+func (km *SigningKeyManager) load() error {
+	files, err := filepath.Glob(filepath.Join(km.config.JWT.KeyDir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read key file %s: %v", file, err)
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to stat key file %s: %v", file, err)
+		}
+
+		key, err := parseSigningKey(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse key file %s: %v", file, err)
+		}
+		key.ID = strings.TrimSuffix(filepath.Base(file), ".pem")
+		key.CreatedAt = info.ModTime()
+
+		km.keys[key.ID] = key
+		if current, ok := km.keys[km.current]; !ok || key.CreatedAt.After(current.CreatedAt) {
+			km.current = key.ID
+		}
+	}
+
+	return nil
+}
+
+// save writes a key to the key directory in PEM format
+func (km *SigningKeyManager) save(key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.privateKey)
+	if err != nil {
+		return err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return os.WriteFile(filepath.Join(km.config.JWT.KeyDir, key.ID+".pem"), data, 0600)
+}
+
+// generateSigningKey generates a new key for the given algorithm
+func generateSigningKey(algorithm string) (*SigningKey, error) {
+	var (
+		privateKey crypto.Signer
+		err        error
+	)
+
+	switch algorithm {
+	case "", "RS256":
+		algorithm = "RS256"
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:         utils.GenerateUUID(),
+		Algorithm:  algorithm,
+		CreatedAt:  time.Now(),
+		privateKey: privateKey,
+	}, nil
+}
+
+// parseSigningKey parses a PKCS8 PEM encoded private key
+func parseSigningKey(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM data")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch privateKey := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{Algorithm: "RS256", privateKey: privateKey}, nil
+	case *ecdsa.PrivateKey:
+		if privateKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve: %s", privateKey.Curve.Params().Name)
+		}
+		return &SigningKey{Algorithm: "ES256", privateKey: privateKey}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", parsed)
+	}
+}
+
+// jwk encodes the public part of a key as a JWK
+func (k *SigningKey) jwk() (JWK, error) {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Algorithm}
+
+	switch publicKey := k.privateKey.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, 32)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, 32)))
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type: %T", publicKey)
+	}
+
+	return jwk, nil
+}
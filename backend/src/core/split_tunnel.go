@@ -0,0 +1,169 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Split tunnel modes of a peer's AllowedIPs
+const (
+	RoutingModeDefault    = ""            // server default, wireguard.allowedIps
+	RoutingModeFull       = "full"        // all traffic
+	RoutingModeSubnets    = "subnets"     // only the given networks
+	RoutingModeExcludeLAN = "exclude-lan" // all traffic except local networks
+)
+
+// fullTunnelNetworks route all traffic through the tunnel
+var fullTunnelNetworks = []string{"0.0.0.0/0", "::/0"}
+
+// lanNetworks are the private and link-local ranges left out of the tunnel
+// in exclude-lan mode, so printers and file shares stay reachable
+var lanNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// SetPeerRouting sets the AllowedIPs of a peer from a split tunnel mode and
+// returns the peer with its new config. Subnets are only used in subnets
+// mode. The peer leaves its routing preset; the default mode returns it to
+// the server default. Pending peers are returned without a config.
+func (vm *VPNManager) SetPeerRouting(ctx context.Context, userID, peerID, mode string, subnets []string) (peer *wireguard.PeerConfig, config string, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.SetPeerRouting",
+		attribute.String("peer.id", peerID),
+		attribute.String("routing.mode", mode),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "routing"); err != nil {
+		return nil, "", err
+	}
+
+	// Get peer
+	peer, err = vm.peerManager.GetPeer(userID, peerID)
+	if err != nil {
+		return nil, "", fmt.Errorf("peer not found: %s", peerID)
+	}
+
+	if mode == RoutingModeSubnets && peer.ExitServer != "" {
+		return nil, "", fmt.Errorf("a peer with an exit must send internet traffic through the tunnel")
+	}
+
+	allowedIPs, err := vm.routingAllowedIPs(peer, mode, subnets)
+	if err != nil {
+		return nil, "", err
+	}
+
+	peer, err = vm.peerManager.SetRouting(userID, peerID, mode, allowedIPs)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to save routing: %v", err)
+	}
+
+	utils.LogInfoContext(ctx, "Set routing of peer %s to %q", peer.ID, mode)
+
+	// Log analytics
+	utils.LogAnalytics(userID, "peer_routing_update", fmt.Sprintf("peer=%s mode=%s", peer.ID, mode))
+
+	if peer.Pending() || peer.Archived() {
+		return peer, "", nil
+	}
+
+	// Generate configuration
+	config, err = vm.renderConfig(peer, "routing")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate configuration: %v", err)
+	}
+
+	return peer, config, nil
+}
+
+// routingAllowedIPs builds the AllowedIPs of a split tunnel mode for a peer
+func (vm *VPNManager) routingAllowedIPs(peer *wireguard.PeerConfig, mode string, subnets []string) (string, error) {
+	if mode != RoutingModeSubnets && len(subnets) > 0 {
+		return "", fmt.Errorf("subnets are only used in %s mode", RoutingModeSubnets)
+	}
+
+	switch mode {
+	case RoutingModeDefault:
+		return "", nil
+	case RoutingModeFull:
+		return strings.Join(fullTunnelNetworks, ", "), nil
+	case RoutingModeSubnets:
+		networks, err := normalizeCIDRs(subnets)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(networks, ", "), nil
+	case RoutingModeExcludeLAN:
+		// The tunnel subnet and DNS servers inside the excluded ranges must
+		// stay reachable through the tunnel
+		keep := make([]string, 0)
+		if _, subnet, err := net.ParseCIDR(vm.config.WireGuard.Address); err == nil {
+			keep = append(keep, subnet.String())
+		}
+		dns := peer.DNS
+		if dns == "" {
+			dns = vm.config.WireGuard.DNS
+		}
+		for _, server := range splitDNS(dns) {
+			if ip := net.ParseIP(server); ip != nil {
+				keep = append(keep, hostNetwork(ip).String())
+			}
+		}
+
+		networks := wireguard.ExcludeNetworks(fullTunnelNetworks, lanNetworks)
+		for _, network := range keep {
+			if !containsNetwork(networks, network) {
+				networks = append(networks, network)
+			}
+		}
+		return strings.Join(networks, ", "), nil
+	default:
+		return "", fmt.Errorf("unknown routing mode: %s", mode)
+	}
+}
+
+// containsNetwork reports whether a network lies within one of networks
+func containsNetwork(networks []string, cidr string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, bits := network.Mask.Size()
+	for _, existing := range networks {
+		_, outer, err := net.ParseCIDR(existing)
+		if err != nil {
+			continue
+		}
+		outerOnes, outerBits := outer.Mask.Size()
+		if outerBits == bits && outerOnes <= ones && outer.Contains(network.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostNetwork returns the single-address network of an IP
+func hostNetwork(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
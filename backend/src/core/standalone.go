@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/wireguard"
+)
+
+// LocalServerID is the ID of the host itself in standalone mode
+const LocalServerID = "local"
+
+// LocalAgentVersion is the agent version the built-in node agent reports
+const LocalAgentVersion = "standalone"
+
+// UseLocalServer replaces the server list with the host the API runs on,
+// for standalone mode
+func (sm *ServerManager) UseLocalServer() *Server {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	host := sm.config.WireGuard.ServerEndpoint
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	server := &Server{
+		ID:             LocalServerID,
+		Name:           sm.config.Standalone.ServerName,
+		Country:        sm.config.Standalone.Country,
+		City:           sm.config.Standalone.City,
+		LocationSource: "manual",
+		IP:             host,
+		Capacity:       100,
+		Status:         "offline",
+		LastUpdated:    time.Now(),
+	}
+
+	sm.servers = map[string]*Server{server.ID: server}
+	sm.changed()
+
+	return server
+}
+
+// SetupLocalInterface brings up the WireGuard interface on this host and
+// applies peers to it directly, for standalone mode
+func (vm *VPNManager) SetupLocalInterface(ctx context.Context) error {
+	if err := vm.peerManager.SetupLocalInterface(ctx); err != nil {
+		return err
+	}
+
+	// Count the peers restored on the interface towards the server load
+	peers, err := vm.peerManager.ListPeers()
+	if err != nil {
+		return fmt.Errorf("failed to list peers: %v", err)
+	}
+	load := 0
+	for _, peer := range peers {
+		if !peer.Pending() && !peer.Archived() {
+			load++
+		}
+	}
+	vm.serverManager.UpdateServerLoad(LocalServerID, load)
+
+	return nil
+}
+
+// TeardownLocalInterface removes the WireGuard interface of this host
+func (vm *VPNManager) TeardownLocalInterface(ctx context.Context) error {
+	return vm.peerManager.TeardownLocalInterface(ctx)
+}
+
+// RunLocalAgent does the work of a node agent for the host in standalone
+// mode: it reports heartbeats and keeps the server online only while its
+// WireGuard interface is up
+func (vm *VPNManager) RunLocalAgent() {
+	interval := time.Duration(vm.config.Standalone.Heartbeat) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		vm.localHeartbeat()
+		<-ticker.C
+	}
+}
+
+// localHeartbeat reports a heartbeat for this host and updates its status
+func (vm *VPNManager) localHeartbeat() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status := "online"
+	if !vm.peerManager.LocalInterfaceUp(ctx) {
+		status = "offline"
+	}
+
+	server, err := vm.serverManager.GetServer(LocalServerID)
+	if err != nil {
+		utils.LogError("Local server is missing: %v", err)
+		return
+	}
+	if server.Status != status {
+		if status == "offline" {
+			utils.LogWarning("WireGuard interface %s is down", vm.config.WireGuard.Interface)
+		}
+		vm.serverManager.UpdateServerStatus(LocalServerID, status)
+	}
+
+	_, err = vm.serverManager.Heartbeat(NodeHeartbeat{
+		ServerID:         LocalServerID,
+		AgentVersion:     LocalAgentVersion,
+		WireGuardVersion: wireguard.LocalVersion(ctx),
+
+		WireGuardImplementation: vm.peerManager.Implementation(),
+		Obfuscation:             vm.peerManager.ObfuscationTransport(),
+	})
+	if err != nil {
+		utils.LogError("Failed to record local heartbeat: %v", err)
+	}
+}
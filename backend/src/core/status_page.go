@@ -0,0 +1,345 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Incident statuses, in the order incidents usually go through them
+const (
+	IncidentInvestigating = "investigating"
+	IncidentIdentified    = "identified"
+	IncidentMonitoring    = "monitoring"
+	IncidentResolved      = "resolved"
+)
+
+// Public states of a region and of the whole service, best first
+const (
+	StatusOperational = "operational"
+	StatusMaintenance = "maintenance" // no server is online, but none is down unplanned
+	StatusDegraded    = "degraded"    // some servers are offline or an incident is open
+	StatusOutage      = "outage"      // no server is online
+)
+
+// statusRanks orders public states from best to worst
+var statusRanks = map[string]int{
+	StatusOperational: 0,
+	StatusMaintenance: 1,
+	StatusDegraded:    2,
+	StatusOutage:      3,
+}
+
+// otherRegion names the region of servers the geo database did not place
+const otherRegion = "Other"
+
+// Incident represents a service incident posted by admins
+type Incident struct {
+	ID         string         `json:"id" db:"id"`
+	Title      string         `json:"title" db:"title"`
+	Message    string         `json:"message" db:"message"`
+	Status     string         `json:"status" db:"status"`
+	Regions    pq.StringArray `json:"regions" db:"regions"` // affected regions, empty when the whole service is
+	CreatedBy  string         `json:"createdBy,omitempty" db:"created_by"`
+	CreatedAt  time.Time      `json:"createdAt" db:"created_at"`
+	UpdatedAt  time.Time      `json:"updatedAt" db:"updated_at"`
+	ResolvedAt *time.Time     `json:"resolvedAt,omitempty" db:"resolved_at"`
+}
+
+// RegionStatus represents the availability of the servers in a region
+type RegionStatus struct {
+	Region    string  `json:"region"`
+	Status    string  `json:"status"`
+	Servers   int     `json:"servers"`
+	Online    int     `json:"online"`
+	Uptime24h float64 `json:"uptime24h"` // average percent of the last day servers were not offline
+	Uptime30d float64 `json:"uptime30d"` // average percent of the last 30 days servers were not offline
+}
+
+// ServiceStatus represents the public status of the service
+type ServiceStatus struct {
+	Status    string          `json:"status"`
+	Regions   []*RegionStatus `json:"regions"`
+	Incidents []*Incident     `json:"incidents"` // open ones and those resolved in the last statusPage.incidentDays days, newest first
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// StatusPage tracks the incidents admins post, and reports the public
+// status of the service from them and the uptime of servers
+type StatusPage struct {
+	config    *config.Config
+	servers   *ServerManager
+	incidents map[string]*Incident
+	mutex     sync.RWMutex
+}
+
+// NewStatusPage creates a new status page
+func NewStatusPage(cfg *config.Config, servers *ServerManager) *StatusPage {
+	sp := &StatusPage{
+		config:    cfg,
+		servers:   servers,
+		incidents: make(map[string]*Incident),
+		mutex:     sync.RWMutex{},
+	}
+
+	if err := sp.load(); err != nil {
+		utils.LogError("Failed to load incidents: %v", err)
+	}
+
+	return sp
+}
+
+// ValidateIncident checks the fields of an incident
+func ValidateIncident(v *utils.Validator, title, status string, regions []string) {
+	v.Required("title", title)
+	v.MaxLength("title", title, 200)
+	v.OneOf("status", status, IncidentInvestigating, IncidentIdentified, IncidentMonitoring, IncidentResolved)
+	for _, region := range regions {
+		v.Check(region != "", "regions", "must not be empty")
+		v.MaxLength("regions", region, 100)
+	}
+}
+
+// CreateIncident posts a new incident, investigating unless a status is
+// given
+func (sp *StatusPage) CreateIncident(title, message, status string, regions []string, actor string) (*Incident, error) {
+	if status == "" {
+		status = IncidentInvestigating
+	}
+
+	now := time.Now()
+	incident := &Incident{
+		ID:        utils.GenerateUUID(),
+		Title:     title,
+		Message:   message,
+		Status:    status,
+		Regions:   pq.StringArray(append([]string{}, regions...)),
+		CreatedBy: actor,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if status == IncidentResolved {
+		incident.ResolvedAt = &now
+	}
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`INSERT INTO incidents (id, title, message, status, regions, created_by, created_at, updated_at, resolved_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			incident.ID, incident.Title, incident.Message, incident.Status, incident.Regions, incident.CreatedBy, incident.CreatedAt, incident.UpdatedAt, incident.ResolvedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save incident: %v", err)
+		}
+	}
+
+	sp.mutex.Lock()
+	sp.incidents[incident.ID] = incident
+	sp.mutex.Unlock()
+
+	// Log analytics
+	utils.LogAnalytics(actor, "incident_create", fmt.Sprintf("incident=%s status=%s", incident.ID, status))
+
+	return incident, nil
+}
+
+// UpdateIncident updates an incident. Resolving it records when, and
+// moving it back to another status reopens it.
+func (sp *StatusPage) UpdateIncident(id, title, message, status string, regions []string, actor string) (*Incident, error) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	existing, ok := sp.incidents[id]
+	if !ok {
+		return nil, fmt.Errorf("incident not found: %s", id)
+	}
+
+	now := time.Now()
+	incident := *existing
+	incident.Title = title
+	incident.Message = message
+	if status != "" {
+		incident.Status = status
+	}
+	incident.Regions = pq.StringArray(append([]string{}, regions...))
+	incident.UpdatedAt = now
+	switch {
+	case incident.Status == IncidentResolved && incident.ResolvedAt == nil:
+		incident.ResolvedAt = &now
+	case incident.Status != IncidentResolved:
+		incident.ResolvedAt = nil
+	}
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`UPDATE incidents SET title = $1, message = $2, status = $3, regions = $4, updated_at = $5, resolved_at = $6 WHERE id = $7`,
+			incident.Title, incident.Message, incident.Status, incident.Regions, incident.UpdatedAt, incident.ResolvedAt, incident.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update incident: %v", err)
+		}
+	}
+
+	sp.incidents[id] = &incident
+
+	// Log analytics
+	utils.LogAnalytics(actor, "incident_update", fmt.Sprintf("incident=%s status=%s", id, incident.Status))
+
+	return &incident, nil
+}
+
+// ListIncidents gets the incidents of the last 30 days and those still
+// open, newest first
+func (sp *StatusPage) ListIncidents() []*Incident {
+	return sp.recentIncidents(uptimeHistory)
+}
+
+// recentIncidents gets the open incidents and those resolved within a
+// window, newest first
+func (sp *StatusPage) recentIncidents(window time.Duration) []*Incident {
+	sp.mutex.RLock()
+	defer sp.mutex.RUnlock()
+
+	since := time.Now().Add(-window)
+	incidents := make([]*Incident, 0)
+	for _, incident := range sp.incidents {
+		if incident.ResolvedAt == nil || incident.ResolvedAt.After(since) {
+			incidents = append(incidents, incident)
+		}
+	}
+
+	sort.Slice(incidents, func(i, j int) bool {
+		return incidents[i].CreatedAt.After(incidents[j].CreatedAt)
+	})
+
+	return incidents
+}
+
+// Status gets the public status of the service: the availability and
+// uptime of each region, and the recent incidents
+func (sp *StatusPage) Status() *ServiceStatus {
+	now := time.Now()
+	status := &ServiceStatus{
+		Status:    StatusOperational,
+		Regions:   make([]*RegionStatus, 0),
+		Incidents: make([]*Incident, 0),
+		UpdatedAt: now,
+	}
+
+	// Count the servers of each region and sum their uptime
+	uptime := sp.servers.Uptime()
+	regions := make(map[string]*RegionStatus)
+	maintenance := make(map[string]int)
+	for _, server := range sp.servers.GetServers() {
+		name := server.Region
+		if name == "" {
+			name = otherRegion
+		}
+		region, ok := regions[name]
+		if !ok {
+			region = &RegionStatus{Region: name}
+			regions[name] = region
+		}
+
+		region.Servers++
+		switch server.Status {
+		case "online", "draining":
+			region.Online++
+		case "maintenance":
+			maintenance[name]++
+		}
+		region.Uptime24h += uptime.ratio(server, 24*time.Hour, now)
+		region.Uptime30d += uptime.ratio(server, uptimeHistory, now)
+	}
+
+	for name, region := range regions {
+		region.Uptime24h = math.Round(region.Uptime24h/float64(region.Servers)*10000) / 100
+		region.Uptime30d = math.Round(region.Uptime30d/float64(region.Servers)*10000) / 100
+
+		down := region.Servers - region.Online - maintenance[name]
+		switch {
+		case region.Online == 0 && down == 0:
+			region.Status = StatusMaintenance
+		case region.Online == 0:
+			region.Status = StatusOutage
+		case down > 0:
+			region.Status = StatusDegraded
+		default:
+			region.Status = StatusOperational
+		}
+	}
+
+	// Open incidents degrade the regions they affect, or the whole service
+	// when they name none
+	days := sp.config.StatusPage.IncidentDays
+	if days <= 0 {
+		days = 7
+	}
+	for _, incident := range sp.recentIncidents(time.Duration(days) * 24 * time.Hour) {
+		public := *incident
+		public.CreatedBy = ""
+		status.Incidents = append(status.Incidents, &public)
+
+		if incident.Status == IncidentResolved {
+			continue
+		}
+		if len(incident.Regions) == 0 {
+			status.Status = worseStatus(status.Status, StatusDegraded)
+		}
+		for _, name := range incident.Regions {
+			if region, ok := regions[name]; ok {
+				region.Status = worseStatus(region.Status, StatusDegraded)
+			}
+		}
+	}
+
+	for _, region := range regions {
+		status.Status = worseStatus(status.Status, region.Status)
+		status.Regions = append(status.Regions, region)
+	}
+	sort.Slice(status.Regions, func(i, j int) bool {
+		return status.Regions[i].Region < status.Regions[j].Region
+	})
+
+	return status
+}
+
+// worseStatus gets the worse of two public states
+func worseStatus(a, b string) string {
+	if statusRanks[b] > statusRanks[a] {
+		return b
+	}
+	return a
+}
+
+// load reads the open incidents and those of the last 30 days from the
+// database
+func (sp *StatusPage) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	incidents := []*Incident{}
+	err := db.DB.Select(&incidents,
+		`SELECT id, title, message, status, regions, created_by, created_at, updated_at, resolved_at FROM incidents WHERE resolved_at IS NULL OR resolved_at > $1`,
+		time.Now().Add(-uptimeHistory),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query incidents: %v", err)
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	for _, incident := range incidents {
+		sp.incidents[incident.ID] = incident
+	}
+
+	return nil
+}
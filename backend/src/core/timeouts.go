@@ -0,0 +1,25 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// withTimeout derives a context that expires after the given number of
+// seconds. Without a positive timeout ctx only ends with its parent.
+func withTimeout(ctx context.Context, seconds int) (context.Context, context.CancelFunc) {
+	if seconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+}
+
+// contextError returns the error of an expired or cancelled ctx, wrapped with
+// the operation it interrupted, or nil while ctx is still live
+func contextError(ctx context.Context, operation string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s interrupted: %w", operation, err)
+	}
+	return nil
+}
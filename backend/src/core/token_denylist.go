@@ -0,0 +1,166 @@
+package core
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/db"
+	"github.com/vpn-service/backend/src/cache"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// TokenDenylist tracks revoked tokens until they would have expired naturally.
+// Revocations are kept in memory and, when a database is available, in the
+// revoked_tokens table so that every API instance honours them. Tokens found
+// not revoked are remembered for cache.tokenRevocations seconds, so requests
+// do not each query the database.
+type TokenDenylist struct {
+	config  *config.Config
+	entries map[string]time.Time // token hash -> expiry of the revoked token
+	checked *cache.Cache[string, bool]
+	mutex   sync.RWMutex
+}
+
+// NewTokenDenylist creates a new token denylist
+func NewTokenDenylist(cfg *config.Config) *TokenDenylist {
+	td := &TokenDenylist{
+		config:  cfg,
+		entries: make(map[string]time.Time),
+		checked: cache.New[string, bool]("token_revocations", time.Duration(cfg.Cache.TokenRevocations)*time.Second),
+		mutex:   sync.RWMutex{},
+	}
+
+	if err := td.load(); err != nil {
+		utils.LogError("Failed to load revoked tokens: %v", err)
+	}
+
+	return td
+}
+
+// Revoke adds a token to the denylist until its expiry time
+func (td *TokenDenylist) Revoke(token, userID string, expiresAt time.Time) error {
+	hash := hashToken(token)
+
+	td.mutex.Lock()
+	td.entries[hash] = expiresAt
+	td.mutex.Unlock()
+	td.checked.Delete(hash)
+
+	if db.DB != nil {
+		_, err := db.DB.Exec(
+			`INSERT INTO revoked_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (token_hash) DO NOTHING`,
+			hash, userID, expiresAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to persist revoked token: %v", err)
+		}
+	}
+
+	// Log analytics
+	utils.LogAnalytics(userID, "token_revoked", "")
+
+	return nil
+}
+
+// IsRevoked checks whether a token has been revoked. A token whose
+// revocation cannot be looked up is reported revoked, with the error.
+func (td *TokenDenylist) IsRevoked(token string) (bool, error) {
+	hash := hashToken(token)
+
+	td.mutex.RLock()
+	_, ok := td.entries[hash]
+	td.mutex.RUnlock()
+	if ok {
+		return true, nil
+	}
+
+	// Tokens may have been revoked through another instance
+	if db.DB == nil {
+		return false, nil
+	}
+	revoked, err := td.checked.GetOrLoad(hash, func() (bool, error) {
+		var expiresAt time.Time
+		err := db.DB.Get(&expiresAt, `SELECT expires_at FROM revoked_tokens WHERE token_hash = $1`, hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// Kept until the token expires, like local revocations
+		td.mutex.Lock()
+		td.entries[hash] = expiresAt
+		td.mutex.Unlock()
+		return true, nil
+	})
+	if err != nil {
+		return true, fmt.Errorf("failed to look up revoked token: %v", err)
+	}
+
+	return revoked, nil
+}
+
+// RunCleanup periodically removes revoked tokens that have expired anyway
+func (td *TokenDenylist) RunCleanup() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		td.purgeExpired()
+	}
+}
+
+// purgeExpired removes expired entries from the denylist
+func (td *TokenDenylist) purgeExpired() {
+	now := time.Now()
+
+	td.mutex.Lock()
+	for hash, expiresAt := range td.entries {
+		if expiresAt.Before(now) {
+			delete(td.entries, hash)
+		}
+	}
+	td.mutex.Unlock()
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`DELETE FROM revoked_tokens WHERE expires_at < $1`, now); err != nil {
+			utils.LogError("Failed to purge revoked tokens: %v", err)
+		}
+	}
+}
+
+// load loads unexpired revoked tokens from the database
+func (td *TokenDenylist) load() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	rows := []struct {
+		TokenHash string    `db:"token_hash"`
+		ExpiresAt time.Time `db:"expires_at"`
+	}{}
+	if err := db.DB.Select(&rows, `SELECT token_hash, expires_at FROM revoked_tokens WHERE expires_at > $1`, time.Now()); err != nil {
+		return err
+	}
+
+	td.mutex.Lock()
+	defer td.mutex.Unlock()
+	for _, row := range rows {
+		td.entries[row.TokenHash] = row.ExpiresAt
+	}
+
+	return nil
+}
+
+// hashToken hashes a token so raw tokens are never stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,12 +1,17 @@
 package core
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/vpn-service/backend/db"
 	"github.com/vpn-service/backend/db/models"
 	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/email"
 	"github.com/vpn-service/backend/src/utils"
 	"github.com/vpn-service/backend/vpn/wireguard"
 	"golang.org/x/crypto/bcrypt"
@@ -14,14 +19,34 @@ import (
 
 // UserManager manages user operations
 type UserManager struct {
-	config *config.Config
+	config         *config.Config
+	deviceDefaults map[string]models.DeviceDefaults
+	invites        *InviteManager
+	mfa            *MFAManager
+	funnel         *FunnelTracker
+	mutex          sync.RWMutex
 }
 
 // NewUserManager creates a new user manager
 func NewUserManager(cfg *config.Config) *UserManager {
-	return &UserManager{
-		config: cfg,
+	um := &UserManager{
+		config:         cfg,
+		deviceDefaults: make(map[string]models.DeviceDefaults),
+		invites:        NewInviteManager(cfg),
+		mfa:            NewMFAManager(cfg),
+		mutex:          sync.RWMutex{},
 	}
+
+	if err := um.loadTelemetryPreferences(); err != nil {
+		utils.LogError("Failed to load telemetry preferences: %v", err)
+	}
+
+	return um
+}
+
+// MFA gets the two-factor authentication manager
+func (um *UserManager) MFA() *MFAManager {
+	return um.mfa
 }
 
 // RegisterUser registers a new user
@@ -172,7 +197,7 @@ func (um *UserManager) GetAllUsers() ([]*models.User, error) {
 func (um *UserManager) DeleteUser(id string) error {
 	// In a real implementation, this would delete the user from the database
 	// For now, we'll just log it
-	utils.LogInfo("Deleting user: %s", id)
+	utils.LogInfo("Deleting user: %s", utils.RedactUserID(id))
 	return nil
 }
 
@@ -205,6 +230,164 @@ func (um *UserManager) SetUserPassword(id, password string) error {
 	return nil
 }
 
+// SetFunnelTracker sets the tracker used to record conversion funnel stages
+func (um *UserManager) SetFunnelTracker(funnel *FunnelTracker) {
+	um.funnel = funnel
+}
+
+// SetMailer sets the mailer used to deliver invites
+func (um *UserManager) SetMailer(mailer *email.Mailer) {
+	um.invites.mailer = mailer
+}
+
+// AcceptInvite redeems a set-password invite and sets the user's password
+func (um *UserManager) AcceptInvite(token, password string) error {
+	userID, err := um.invites.Redeem(token)
+	if err != nil {
+		return fmt.Errorf("failed to redeem invite: %v", err)
+	}
+
+	if err := um.SetUserPassword(userID, password); err != nil {
+		return err
+	}
+
+	// Redeeming an emailed invite proves ownership of the address
+	if um.funnel != nil {
+		um.funnel.Record(userID, FunnelStageVerified)
+	}
+
+	return nil
+}
+
+// GetDeviceDefaults gets the settings applied to a user's new peers
+func (um *UserManager) GetDeviceDefaults(ctx context.Context, id string) (models.DeviceDefaults, error) {
+	um.mutex.RLock()
+	defaults, ok := um.deviceDefaults[id]
+	um.mutex.RUnlock()
+	if ok {
+		return defaults, nil
+	}
+
+	if db.DB != nil {
+		if err := db.DB.GetContext(ctx, &defaults, `SELECT device_defaults FROM users WHERE id = $1`, id); err != nil {
+			return models.DeviceDefaults{}, fmt.Errorf("failed to get device defaults: %v", err)
+		}
+	}
+
+	um.mutex.Lock()
+	um.deviceDefaults[id] = defaults
+	um.mutex.Unlock()
+
+	return defaults, nil
+}
+
+// UpdateDeviceDefaults validates and stores the settings applied to a user's new peers
+func (um *UserManager) UpdateDeviceDefaults(id string, defaults models.DeviceDefaults) (models.DeviceDefaults, error) {
+	if err := validateDeviceDefaults(&defaults); err != nil {
+		return models.DeviceDefaults{}, err
+	}
+
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`UPDATE users SET device_defaults = $1, updated_at = $2 WHERE id = $3`, defaults, time.Now(), id); err != nil {
+			return models.DeviceDefaults{}, fmt.Errorf("failed to save device defaults: %v", err)
+		}
+	}
+
+	um.mutex.Lock()
+	um.deviceDefaults[id] = defaults
+	um.mutex.Unlock()
+
+	// Log analytics
+	utils.LogAnalytics(id, "user_update_device_defaults", fmt.Sprintf("killSwitch=%t protocol=%s", defaults.KillSwitch, defaults.Protocol))
+
+	return defaults, nil
+}
+
+// validateDeviceDefaults validates device defaults, normalising the protocol
+func validateDeviceDefaults(defaults *models.DeviceDefaults) error {
+	for _, server := range strings.Split(defaults.DNS, ",") {
+		server = strings.TrimSpace(server)
+		if server != "" && net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid DNS server: %s", server)
+		}
+	}
+
+	defaults.Protocol = strings.ToLower(defaults.Protocol)
+	if defaults.Protocol != "" {
+		supported := false
+		for _, protocol := range models.SupportedProtocols {
+			if defaults.Protocol == protocol {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("unsupported protocol: %s", defaults.Protocol)
+		}
+	}
+
+	if defaults.Keepalive < 0 || defaults.Keepalive > 65535 {
+		return fmt.Errorf("keepalive must be between 0 and 65535 seconds")
+	}
+
+	return nil
+}
+
+// PrivacySettings represents a user's privacy preferences
+type PrivacySettings struct {
+	TelemetryEnabled bool  `json:"telemetryEnabled"`
+	TelemetryChoice  *bool `json:"telemetryChoice"` // nil when following the deployment default
+	TelemetryDefault bool  `json:"telemetryDefault"`
+}
+
+// GetPrivacySettings gets a user's privacy settings
+func (um *UserManager) GetPrivacySettings(id string) *PrivacySettings {
+	return &PrivacySettings{
+		TelemetryEnabled: utils.TelemetryEnabled(id),
+		TelemetryChoice:  utils.TelemetryPreference(id),
+		TelemetryDefault: utils.TelemetryDefault(),
+	}
+}
+
+// UpdateTelemetryPreference sets whether identifiable telemetry is recorded
+// for a user; nil reverts to the deployment default
+func (um *UserManager) UpdateTelemetryPreference(id string, enabled *bool) (*PrivacySettings, error) {
+	if db.DB != nil {
+		if _, err := db.DB.Exec(`UPDATE users SET telemetry_enabled = $1, updated_at = $2 WHERE id = $3`, enabled, time.Now(), id); err != nil {
+			return nil, fmt.Errorf("failed to save telemetry preference: %v", err)
+		}
+	}
+
+	utils.SetTelemetryPreference(id, enabled)
+
+	// Log analytics
+	utils.LogAnalytics(id, "user_update_privacy", "")
+
+	return um.GetPrivacySettings(id), nil
+}
+
+// loadTelemetryPreferences loads explicit telemetry preferences from the database
+func (um *UserManager) loadTelemetryPreferences() error {
+	if db.DB == nil {
+		return nil
+	}
+
+	rows := []struct {
+		ID      string `db:"id"`
+		Enabled bool   `db:"telemetry_enabled"`
+	}{}
+	if err := db.DB.Select(&rows, `SELECT id, telemetry_enabled FROM users WHERE telemetry_enabled IS NOT NULL`); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		enabled := row.Enabled
+		utils.SetTelemetryPreference(row.ID, &enabled)
+	}
+
+	return nil
+}
+
 // GetUserPeers gets a user's VPN peers
 func (um *UserManager) GetUserPeers(id string) ([]*wireguard.PeerConfig, error) {
 	// In a real implementation, this would query the database
@@ -237,7 +420,7 @@ func (um *UserManager) GetUserPeers(id string) ([]*wireguard.PeerConfig, error)
 func (um *UserManager) DeleteUserPeer(userID, peerID string) error {
 	// In a real implementation, this would delete the peer from the database
 	// For now, we'll just log it
-	utils.LogInfo("Deleting peer %s for user %s", peerID, userID)
+	utils.LogInfo("Deleting peer %s for user %s", peerID, utils.RedactUserID(userID))
 	return nil
 }
 
@@ -257,6 +440,7 @@ func (um *UserManager) getUserByUsername(username string) (*models.User, error)
 		Username:  username,
 		Email:     "user@example.com",
 		Password:  "$2a$10$1234567890123456789012345678901234567890123456789012345678901234",
+		Role:      models.RoleUser,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}, nil
@@ -271,6 +455,7 @@ func (um *UserManager) getUserByID(id string) (*models.User, error) {
 		Username:  "user",
 		Email:     "user@example.com",
 		Password:  "$2a$10$1234567890123456789012345678901234567890123456789012345678901234",
+		Role:      models.RoleUser,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}, nil
@@ -280,7 +465,7 @@ func (um *UserManager) getUserByID(id string) (*models.User, error) {
 func (um *UserManager) saveUser(user *models.User) error {
 	// In a real implementation, this would save to the database
 	// For now, we'll just log it
-	utils.LogInfo("Saving user: %s", user.ID)
+	utils.LogInfo("Saving user: %s", utils.RedactUserID(user.ID))
 	return nil
 }
 
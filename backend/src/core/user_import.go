@@ -0,0 +1,192 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vpn-service/backend/db/models"
+	"github.com/vpn-service/backend/src/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxImportRows limits the size of a single import
+const maxImportRows = 10000
+
+// Import row statuses
+const (
+	ImportStatusCreated = "created"
+	ImportStatusInvited = "invited"
+	ImportStatusSkipped = "skipped"
+	ImportStatusFailed  = "failed"
+)
+
+// ImportRow represents a user to import. Rows either carry an existing
+// bcrypt hash or are invited to set a password.
+type ImportRow struct {
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+}
+
+// ImportResult represents the outcome of importing a single row
+type ImportResult struct {
+	Row      int    `json:"row"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Status   string `json:"status"`
+	UserID   string `json:"userId,omitempty"`
+	// InviteURL is the set-password link of an invite that was not sent,
+	// for the admin to hand out
+	InviteURL string `json:"inviteUrl,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ImportSummary summarises an import
+type ImportSummary struct {
+	Total   int             `json:"total"`
+	Created int             `json:"created"`
+	Invited int             `json:"invited"`
+	Skipped int             `json:"skipped"`
+	Failed  int             `json:"failed"`
+	Results []*ImportResult `json:"results"`
+}
+
+// ValidateImport checks an import can be started
+func ValidateImport(rows []ImportRow) error {
+	if len(rows) > maxImportRows {
+		return fmt.Errorf("import is limited to %d rows", maxImportRows)
+	}
+	return nil
+}
+
+// ImportUsers creates accounts for the given rows. Rows without a password
+// hash get a set-password invite, which is only sent if sendInvites is set;
+// otherwise its link is returned in the row's result.
+// Every row is processed independently, reported in the summary and to the
+// job reporter, if any. A cancelled import stops before the next row; rows
+// already imported are kept.
+func (um *UserManager) ImportUsers(ctx context.Context, rows []ImportRow, sendInvites bool, reporter *JobReporter) (*ImportSummary, error) {
+	if err := ValidateImport(rows); err != nil {
+		return nil, err
+	}
+
+	summary := &ImportSummary{
+		Total:   len(rows),
+		Results: make([]*ImportResult, 0, len(rows)),
+	}
+	seen := make(map[string]bool)
+	reporter.SetTotal(len(rows))
+
+	for i, row := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+
+		result := um.importRow(row, seen, sendInvites)
+		result.Row = i + 1
+		summary.Results = append(summary.Results, result)
+
+		switch result.Status {
+		case ImportStatusCreated:
+			summary.Created++
+		case ImportStatusInvited:
+			summary.Invited++
+		case ImportStatusSkipped:
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+
+		if result.Status == ImportStatusFailed {
+			reporter.ItemFailed(fmt.Sprintf("row %d (%s)", result.Row, result.Username), errors.New(result.Error))
+		} else {
+			reporter.ItemDone()
+		}
+	}
+
+	utils.LogInfo("Imported users: total=%d created=%d invited=%d skipped=%d failed=%d",
+		summary.Total, summary.Created, summary.Invited, summary.Skipped, summary.Failed)
+
+	return summary, nil
+}
+
+// importRow validates and imports a single row
+func (um *UserManager) importRow(row ImportRow, seen map[string]bool, sendInvites bool) *ImportResult {
+	username := strings.TrimSpace(row.Username)
+	email := strings.ToLower(strings.TrimSpace(row.Email))
+	hash := strings.TrimSpace(row.PasswordHash)
+
+	result := &ImportResult{Username: username, Email: email}
+	fail := func(format string, args ...interface{}) *ImportResult {
+		result.Status = ImportStatusFailed
+		result.Error = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	// Validate row
+	if username == "" {
+		return fail("username is required")
+	}
+	if !utils.IsValidEmail(email) {
+		return fail("invalid email: %s", email)
+	}
+	if hash != "" {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fail("password hash is not a bcrypt hash")
+		}
+	}
+
+	// Skip duplicates within the import and accounts that already exist
+	if seen[strings.ToLower(username)] || seen[email] {
+		result.Status = ImportStatusSkipped
+		result.Error = "duplicate row in import"
+		return result
+	}
+	seen[strings.ToLower(username)] = true
+	seen[email] = true
+
+	exists, err := um.userExists(username, email)
+	if err != nil {
+		return fail("failed to check if user exists: %v", err)
+	}
+	if exists {
+		result.Status = ImportStatusSkipped
+		result.Error = "user already exists"
+		return result
+	}
+
+	// Invited users get an unusable password until they redeem the invite
+	invited := hash == ""
+	if invited {
+		hash = "!"
+	}
+
+	user := models.NewUser(username, email, hash)
+	if err := um.saveUser(user); err != nil {
+		return fail("failed to save user: %v", err)
+	}
+	result.UserID = user.ID
+	result.Status = ImportStatusCreated
+
+	if invited {
+		token, _, err := um.invites.CreateInvite(user.ID)
+		if err != nil {
+			return fail("user created but invite failed: %v", err)
+		}
+		if sendInvites {
+			if err := um.invites.SendInvite(email, token); err != nil {
+				return fail("user created but invite could not be sent: %v", err)
+			}
+		} else {
+			result.InviteURL = um.invites.InviteURL(token)
+		}
+		result.Status = ImportStatusInvited
+	}
+
+	// Log analytics
+	utils.LogAnalytics(user.ID, "user_import", fmt.Sprintf("status=%s", result.Status))
+
+	return result
+}
@@ -1,38 +1,334 @@
 package core
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 
+	"github.com/vpn-service/backend/src/analytics"
 	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/email"
+	"github.com/vpn-service/backend/src/storage"
+	"github.com/vpn-service/backend/src/tracing"
 	"github.com/vpn-service/backend/src/utils"
 	"github.com/vpn-service/backend/vpn/wireguard"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// ApplyLatencyObserver is notified of the time from a connect request to its
+// peer being live on the node, for the server the peer was applied on
+type ApplyLatencyObserver func(serverID string, dynamic bool, latency time.Duration)
+
 // VPNManager manages VPN connections
 type VPNManager struct {
 	config        *config.Config
 	serverManager *ServerManager
+	userManager   *UserManager
 	peerManager   *wireguard.PeerManager
+	configAudit   *ConfigAuditLog
+	entitlements  *EntitlementManager
+	funnel        *FunnelTracker
+	merges        *AccountMergeManager
+	routing       *RoutingPresetManager
+	push          *PushNotifier
+	mailer        *email.Mailer
+	shares        *ConfigShareManager
+	deviceKeys    *DeviceKeyManager
+	reservations  *IPReservationManager
+	acl           *ACLManager
+	mesh          *MeshManager
+	templates     *ConfigTemplateManager
+	shadow        *ShadowEvaluator
+	analytics     *AnalyticsReporter
+	storage       storage.Store
+	applyLatency  ApplyLatencyObserver
+	events        *EventBus
 	mutex         sync.RWMutex
 }
 
 // NewVPNManager creates a new VPN manager
 func NewVPNManager(cfg *config.Config, serverManager *ServerManager) *VPNManager {
-	return &VPNManager{
+	funnel := NewFunnelTracker(cfg)
+
+	vm := &VPNManager{
 		config:        cfg,
 		serverManager: serverManager,
 		peerManager:   wireguard.NewPeerManager(cfg),
+		configAudit:   NewConfigAuditLog(cfg),
+		entitlements:  NewEntitlementManager(cfg, funnel),
+		funnel:        funnel,
+		push:          NewPushNotifier(cfg),
+		shares:        NewConfigShareManager(cfg),
+		deviceKeys:    NewDeviceKeyManager(cfg),
+		reservations:  NewIPReservationManager(cfg),
+		acl:           NewACLManager(cfg),
+		mesh:          NewMeshManager(cfg),
+		templates:     NewConfigTemplateManager(cfg),
+		shadow:        NewShadowEvaluator(cfg, serverManager),
+		analytics:     NewAnalyticsReporter(cfg, nil),
 		mutex:         sync.RWMutex{},
 	}
+	vm.merges = NewAccountMergeManager(cfg, vm)
+	vm.routing = NewRoutingPresetManager(cfg, vm)
+
+	// Render configs from admin overrides where a template has one
+	wireguard.SetTemplateLoader(vm.templates.load)
+
+	// Keep dedicated addresses for the users they are reserved to
+	vm.peerManager.SetReservations(vm.reservations.addressReservations)
+
+	// Enforce the ACL rules between peers in the firewall
+	vm.peerManager.SetACL(vm.acl.policies)
+	vm.peerManager.SetMesh(vm.mesh.policies)
+
+	// Enforce the bandwidth limits and port forwarding of plans on nodes
+	vm.peerManager.SetEntitlements(vm.entitlements.peerEntitlements)
+
+	return vm
+}
+
+// ConfigAuditLog gets the log of rendered client configurations
+func (vm *VPNManager) ConfigAuditLog() *ConfigAuditLog {
+	return vm.configAudit
+}
+
+// Entitlements gets the plan entitlement manager
+func (vm *VPNManager) Entitlements() *EntitlementManager {
+	return vm.entitlements
+}
+
+// AccountMerges gets the account merge manager
+func (vm *VPNManager) AccountMerges() *AccountMergeManager {
+	return vm.merges
+}
+
+// RoutingPresets gets the routing preset manager
+func (vm *VPNManager) RoutingPresets() *RoutingPresetManager {
+	return vm.routing
+}
+
+// ConfigShares gets the config share link manager
+func (vm *VPNManager) ConfigShares() *ConfigShareManager {
+	return vm.shares
+}
+
+// DeviceKeys gets the allow-list of pre-authorized device keys
+func (vm *VPNManager) DeviceKeys() *DeviceKeyManager {
+	return vm.deviceKeys
+}
+
+// ConfigTemplates gets the manager of client config template overrides
+func (vm *VPNManager) ConfigTemplates() *ConfigTemplateManager {
+	return vm.templates
 }
 
-// Connect connects a user to a VPN server
-func (vm *VPNManager) Connect(userID, serverID, deviceType, deviceName string) (*wireguard.PeerConfig, string, error) {
+// Shadow gets the shadow evaluator of server selection algorithms
+func (vm *VPNManager) Shadow() *ShadowEvaluator {
+	return vm.shadow
+}
+
+// Analytics gets the reporter of usage analytics events
+func (vm *VPNManager) Analytics() *AnalyticsReporter {
+	return vm.analytics
+}
+
+// Push gets the mobile push notifier
+func (vm *VPNManager) Push() *PushNotifier {
+	return vm.push
+}
+
+// Funnel gets the conversion funnel tracker
+func (vm *VPNManager) Funnel() *FunnelTracker {
+	return vm.funnel
+}
+
+// Events gets the bus system events are published on
+func (vm *VPNManager) Events() *EventBus {
+	return vm.events
+}
+
+// SetEventBus sets the bus peer connects and disconnects are published on
+func (vm *VPNManager) SetEventBus(events *EventBus) {
+	vm.events = events
+}
+
+// SetAnalyticsStore sets the compressed store analytics events are written
+// to, so reports include events not yet flushed
+func (vm *VPNManager) SetAnalyticsStore(store *analytics.Store) {
+	vm.analytics.store = store
+}
+
+// SetApplyObserver sets the observer notified of peer apply outcomes per node
+func (vm *VPNManager) SetApplyObserver(observer wireguard.ApplyObserver) {
+	vm.peerManager.SetApplyObserver(observer)
+}
+
+// SetApplyLatencyObserver sets the observer notified of how long connects
+// take to go live on their node
+func (vm *VPNManager) SetApplyLatencyObserver(observer ApplyLatencyObserver) {
+	vm.applyLatency = observer
+}
+
+// observeApplyLatency reports a connect that went live on a server
+func (vm *VPNManager) observeApplyLatency(serverID string, dynamic bool, started time.Time) {
+	if vm.applyLatency != nil {
+		vm.applyLatency(serverID, dynamic, time.Since(started))
+	}
+}
+
+// SetUserManager sets the user manager used to look up account defaults
+func (vm *VPNManager) SetUserManager(userManager *UserManager) {
+	vm.userManager = userManager
+}
+
+// peerOptions builds the options for a new peer from the user's account defaults
+func (vm *VPNManager) peerOptions(ctx context.Context, userID string) wireguard.PeerOptions {
+	if vm.userManager == nil {
+		return wireguard.PeerOptions{}
+	}
+
+	defaults, err := vm.userManager.GetDeviceDefaults(ctx, userID)
+	if err != nil {
+		utils.LogWarning("Failed to get device defaults for user %s: %v", userID, err)
+		return wireguard.PeerOptions{}
+	}
+
+	return wireguard.PeerOptions{
+		DNS:        defaults.DNS,
+		KillSwitch: defaults.KillSwitch,
+		Protocol:   defaults.Protocol,
+		Keepalive:  defaults.Keepalive,
+	}
+}
+
+// checkEntitlements checks whether a user's plan allows adding another device
+func (vm *VPNManager) checkEntitlements(ctx context.Context, userID string, opts wireguard.PeerOptions) error {
+	protocol := opts.Protocol
+	if protocol == "" {
+		protocol = "wireguard"
+	}
+
+	peers, err := vm.peerManager.GetPeers(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get peers: %v", err)
+	}
+
+	// Archived devices do not count until they are reactivated
+	devices := 0
+	for _, peer := range peers {
+		if !peer.Archived() {
+			devices++
+		}
+	}
+
+	return vm.entitlements.CheckConnect(ctx, userID, protocol, devices)
+}
+
+// createWithFailover creates a peer on the selected server. If the node
+// fails to apply it and failover is enabled, the next-best servers are tried
+// in turn. It returns the peer and the server it was actually created on.
+func (vm *VPNManager) createWithFailover(ctx context.Context, userID string, server *Server, create func(ctx context.Context, serverID string) (*wireguard.PeerConfig, error)) (*wireguard.PeerConfig, *Server, error) {
+	peer, err := vm.createOn(ctx, server.ID, false, create)
+	if err == nil {
+		return peer, server, nil
+	}
+	if _, ok := err.(*wireguard.ApplyError); !ok || !vm.config.WireGuard.Failover {
+		return nil, nil, err
+	}
+
+	candidates := vm.serverManager.GetFailoverServers(server.Country, server.ID)
+	if len(candidates) > vm.config.WireGuard.FailoverMax {
+		candidates = candidates[:vm.config.WireGuard.FailoverMax]
+	}
+
+	for _, candidate := range candidates {
+		// Stop failing over once the request has run out of time
+		if ctx.Err() != nil {
+			return nil, nil, err
+		}
+
+		utils.LogWarningContext(ctx, "Failing over from server %s to %s: %v", server.ID, candidate.ID, err)
+
+		peer, err = vm.createOn(ctx, candidate.ID, true, create)
+		if err == nil {
+			// Log analytics
+			utils.LogAnalytics(userID, "vpn_connect_failover", fmt.Sprintf("from=%s to=%s", server.ID, candidate.ID))
+			return peer, candidate, nil
+		}
+		if _, ok := err.(*wireguard.ApplyError); !ok {
+			return nil, nil, err
+		}
+	}
+
+	return nil, nil, err
+}
+
+// createOn runs a single peer creation attempt on a server in its own span,
+// counting it towards the server's error rate unless the peer was rejected
+// before reaching the node
+func (vm *VPNManager) createOn(ctx context.Context, serverID string, failover bool, create func(ctx context.Context, serverID string) (*wireguard.PeerConfig, error)) (peer *wireguard.PeerConfig, err error) {
+	ctx, span := tracing.Start(ctx, "VPNManager.createPeer",
+		attribute.String("server.id", serverID),
+		attribute.Bool("vpn.failover", failover),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	peer, err = create(ctx, serverID)
+	if _, applyFailed := err.(*wireguard.ApplyError); err == nil || applyFailed {
+		vm.serverManager.RecordApply(serverID, applyFailed)
+	}
+	return peer, err
+}
+
+// renderConfig renders a peer's configuration and records the render event
+func (vm *VPNManager) renderConfig(peer *wireguard.PeerConfig, source string) (string, error) {
+	rendered, err := vm.peerManager.RenderConfig(peer)
+	if err != nil {
+		return "", err
+	}
+
+	vm.configAudit.Record(peer, rendered, source)
+
+	return rendered.Config, nil
+}
+
+// ValidateDevice checks the type and name of a device, as given on connect
+// or restored from a backup. Names end up in configs, setup sheets and
+// notifications, so control characters are rejected.
+func ValidateDevice(v *utils.Validator, deviceType, deviceName string) {
+	v.MaxLength("deviceType", deviceType, 32)
+	v.Check(strings.IndexFunc(deviceType, unicode.IsControl) < 0, "deviceType", "may not contain control characters")
+	v.MaxLength("deviceName", deviceName, 64)
+	v.Check(strings.IndexFunc(deviceName, unicode.IsControl) < 0, "deviceName", "may not contain control characters")
+}
+
+// Connect connects a user to a VPN server. If the device brings its own
+// public key, a key on the allow-list is applied and tagged right away while
+// an unknown key is held for admin approval; held peers are returned
+// without a config.
+func (vm *VPNManager) Connect(ctx context.Context, userID, serverID, deviceType, deviceName, routingPreset, publicKey string, dns DNSChoice, tunnel TunnelChoice) (peer *wireguard.PeerConfig, config string, err error) {
+	started := time.Now()
+
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Connect)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.Connect",
+		attribute.String("server.id", serverID),
+		attribute.String("device.type", deviceType),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	vm.mutex.Lock()
 	defer vm.mutex.Unlock()
 
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "connect"); err != nil {
+		return nil, "", err
+	}
+
 	// Get server
 	server, err := vm.serverManager.GetServer(serverID)
 	if err != nil {
@@ -44,32 +340,100 @@ func (vm *VPNManager) Connect(userID, serverID, deviceType, deviceName string) (
 		return nil, "", fmt.Errorf("server is not online: %s", serverID)
 	}
 
-	// Create peer
-	peer, err := vm.peerManager.CreatePeer(userID, serverID, deviceType, deviceName)
+	// Check plan entitlements
+	opts := vm.peerOptions(ctx, userID)
+	if err := vm.checkEntitlements(ctx, userID, opts); err != nil {
+		return nil, "", err
+	}
+
+	// Route the networks of the chosen preset
+	if err := vm.routing.apply(&opts, routingPreset); err != nil {
+		return nil, "", err
+	}
+
+	// Use the requested DNS servers instead of the account default
+	if err := vm.applyDNS(&opts, dns); err != nil {
+		return nil, "", err
+	}
+
+	// Use the requested MTU instead of the network default
+	if err := applyTunnel(&opts, tunnel); err != nil {
+		return nil, "", err
+	}
+	if err := checkObfuscation(server, opts); err != nil {
+		return nil, "", err
+	}
+	vm.selectInterface(ctx, userID, &opts)
+	if err := vm.checkGateway(deviceType, opts, false); err != nil {
+		return nil, "", err
+	}
+
+	// Shadow the selection with the algorithms under evaluation
+	shadow := vm.shadow.Begin(SelectionRequest{RequestedServerID: server.ID, Country: server.Country, DeviceType: deviceType})
+
+	create := func(ctx context.Context, serverID string) (*wireguard.PeerConfig, error) {
+		return vm.peerManager.CreatePeer(ctx, userID, serverID, deviceType, deviceName, opts)
+	}
+	if publicKey != "" {
+		key, err := vm.clientKey(ctx, userID, publicKey)
+		if err != nil {
+			return nil, "", err
+		}
+		if key.Pending {
+			return vm.holdForApproval(ctx, userID, server, deviceType, deviceName, key, opts)
+		}
+		create = func(ctx context.Context, serverID string) (*wireguard.PeerConfig, error) {
+			return vm.peerManager.CreateClientKeyPeer(ctx, userID, serverID, deviceType, deviceName, key, opts)
+		}
+	}
+
+	// Create peer, failing over to another server if the node rejects it
+	peer, server, err = vm.createWithFailover(ctx, userID, server, create)
 	if err != nil {
+		if ctxErr := contextError(ctx, "connect"); ctxErr != nil {
+			return nil, "", ctxErr
+		}
 		return nil, "", fmt.Errorf("failed to create peer: %v", err)
 	}
+	vm.observeApplyLatency(server.ID, false, started)
+	shadow.Finish(server)
 
 	// Generate configuration
-	config, err := vm.peerManager.GenerateConfig(peer)
+	config, err = vm.renderConfig(peer, "connect")
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to generate configuration: %v", err)
 	}
 
 	// Update server load
-	vm.serverManager.UpdateServerLoad(serverID, server.Load+1)
+	vm.serverManager.UpdateServerLoad(server.ID, server.Load+1)
+	utils.LogInfoContext(ctx, "Created peer %s on server %s", peer.ID, server.ID)
+	vm.events.Publish(EventPeerConnected, PeerEvent{UserID: userID, PeerID: peer.ID, ServerID: server.ID, Dynamic: false, Device: deviceName})
+
+	// Record the first connect in the conversion funnel
+	vm.funnel.Record(userID, FunnelStageFirstConnect)
 
 	// Log analytics
-	utils.LogAnalytics(userID, "vpn_connect", fmt.Sprintf("server=%s device=%s", serverID, deviceType))
+	utils.LogAnalytics(userID, "vpn_connect", fmt.Sprintf("server=%s device=%s", server.ID, deviceType))
 
 	return peer, config, nil
 }
 
 // Disconnect disconnects a user from a VPN server
-func (vm *VPNManager) Disconnect(userID, peerID string) error {
+func (vm *VPNManager) Disconnect(ctx context.Context, userID, peerID string) (err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Disconnect)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.Disconnect", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
 	vm.mutex.Lock()
 	defer vm.mutex.Unlock()
 
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "disconnect"); err != nil {
+		return err
+	}
+
 	// Get peer
 	peer, err := vm.peerManager.GetPeer(userID, peerID)
 	if err != nil {
@@ -77,12 +441,17 @@ func (vm *VPNManager) Disconnect(userID, peerID string) error {
 	}
 
 	// Remove peer
-	if err := vm.peerManager.RemovePeer(userID, peerID); err != nil {
+	if err := vm.peerManager.RemovePeer(ctx, userID, peerID); err != nil {
+		if ctxErr := contextError(ctx, "disconnect"); ctxErr != nil {
+			return ctxErr
+		}
 		return fmt.Errorf("failed to remove peer: %v", err)
 	}
 
 	// Update server load
 	vm.serverManager.UpdateServerLoad(peer.ServerID, 0)
+	utils.LogInfoContext(ctx, "Removed peer %s from server %s", peerID, peer.ServerID)
+	vm.events.Publish(EventPeerDisconnected, PeerEvent{UserID: userID, PeerID: peerID, ServerID: peer.ServerID, Dynamic: false, Reason: DisconnectUser})
 
 	// Log analytics
 	utils.LogAnalytics(userID, "vpn_disconnect", fmt.Sprintf("peer=%s", peerID))
@@ -91,16 +460,27 @@ func (vm *VPNManager) Disconnect(userID, peerID string) error {
 }
 
 // GetStatus gets the status of a user's VPN connections
-func (vm *VPNManager) GetStatus(userID string) ([]*wireguard.PeerInfo, error) {
+func (vm *VPNManager) GetStatus(ctx context.Context, userID string) ([]*wireguard.PeerInfo, error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Status)
+	defer cancel()
+
 	vm.mutex.RLock()
 	defer vm.mutex.RUnlock()
 
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "status"); err != nil {
+		return nil, err
+	}
+
 	// Get peers
 	peers, err := vm.peerManager.GetPeers(userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get peers: %v", err)
 	}
 
+	// Get latest handshakes to tell active sessions from provisioned peers
+	handshakes := vm.peerManager.LatestHandshakes(ctx)
+
 	// Get peer info
 	peerInfo := make([]*wireguard.PeerInfo, len(peers))
 	for i, peer := range peers {
@@ -110,6 +490,11 @@ func (vm *VPNManager) GetStatus(userID string) ([]*wireguard.PeerInfo, error) {
 			return nil, fmt.Errorf("server not found: %s", peer.ServerID)
 		}
 
+		lastHandshake := peer.LastHandshake
+		if handshake, ok := handshakes[peer.PublicKey]; ok {
+			lastHandshake = handshake
+		}
+
 		// Create peer info
 		peerInfo[i] = &wireguard.PeerInfo{
 			ID:         peer.ID,
@@ -122,6 +507,23 @@ func (vm *VPNManager) GetStatus(userID string) ([]*wireguard.PeerInfo, error) {
 			LastSeen:   time.Now().Format(time.RFC3339), // Mock for now
 			BytesRx:    1024 * 1024 * 10,                // Mock for now
 			BytesTx:    1024 * 1024 * 5,                 // Mock for now
+			Dynamic:    peer.Dynamic,
+			SessionID:  peer.SessionID,
+			DNSProfile: peer.DNSProfile,
+			Keepalive:  wireguard.PeerKeepalive(vm.config, peer),
+			Status:     wireguard.PeerStatus(lastHandshake),
+		}
+		if !lastHandshake.IsZero() {
+			peerInfo[i].LastSeen = lastHandshake.Format(time.RFC3339)
+		}
+		if peer.Dynamic {
+			peerInfo[i].ExpiresAt = peer.ExpiresAt.Format(time.RFC3339)
+		}
+		if peer.Archived() {
+			peerInfo[i].Status = wireguard.PeerStatusArchived
+		}
+		if peer.Pending() {
+			peerInfo[i].Status = wireguard.PeerStatusPendingApproval
 		}
 	}
 
@@ -129,23 +531,48 @@ func (vm *VPNManager) GetStatus(userID string) ([]*wireguard.PeerInfo, error) {
 }
 
 // GetConfig gets the configuration for a peer
-func (vm *VPNManager) GetConfig(userID, peerID string) (string, error) {
+func (vm *VPNManager) GetConfig(ctx context.Context, userID, peerID string) (config string, err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Config)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.GetConfig", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	_, config, err = vm.peerConfig(ctx, userID, peerID, "download")
+	return config, err
+}
+
+// peerConfig gets a peer that is in use and renders its configuration,
+// recording the render with source
+func (vm *VPNManager) peerConfig(ctx context.Context, userID, peerID, source string) (*wireguard.PeerConfig, string, error) {
 	vm.mutex.RLock()
 	defer vm.mutex.RUnlock()
 
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "config"); err != nil {
+		return nil, "", err
+	}
+
 	// Get peer
 	peer, err := vm.peerManager.GetPeer(userID, peerID)
 	if err != nil {
-		return "", fmt.Errorf("peer not found: %s", peerID)
+		return nil, "", fmt.Errorf("peer not found: %s", peerID)
+	}
+	if peer.Archived() {
+		return nil, "", fmt.Errorf("peer is archived, reactivate it first: %s", peerID)
+	}
+	if peer.Pending() {
+		return nil, "", fmt.Errorf("peer is pending approval: %s", peerID)
 	}
 
 	// Generate configuration
-	config, err := vm.peerManager.GenerateConfig(peer)
+	config, err := vm.renderConfig(peer, source)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate configuration: %v", err)
+		utils.LogErrorContext(ctx, "Failed to render config for peer %s: %v", peerID, err)
+		return nil, "", fmt.Errorf("failed to generate configuration: %v", err)
 	}
 
-	return config, nil
+	return peer, config, nil
 }
 
 // GetServers gets all VPN servers
@@ -154,10 +581,26 @@ func (vm *VPNManager) GetServers() []*Server {
 }
 
 // DynamicConnect connects a user to a VPN server with a dynamic IP
-func (vm *VPNManager) DynamicConnect(userID, serverID, deviceType, deviceName string) (*wireguard.PeerConfig, string, error) {
+func (vm *VPNManager) DynamicConnect(ctx context.Context, userID, serverID, deviceType, deviceName, routingPreset string, dns DNSChoice, tunnel TunnelChoice) (peer *wireguard.PeerConfig, config string, err error) {
+	started := time.Now()
+
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Connect)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.DynamicConnect",
+		attribute.String("server.id", serverID),
+		attribute.String("device.type", deviceType),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	vm.mutex.Lock()
 	defer vm.mutex.Unlock()
 
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "connect"); err != nil {
+		return nil, "", err
+	}
+
 	// Get server
 	server, err := vm.serverManager.GetServer(serverID)
 	if err != nil {
@@ -169,32 +612,86 @@ func (vm *VPNManager) DynamicConnect(userID, serverID, deviceType, deviceName st
 		return nil, "", fmt.Errorf("server is not online: %s", serverID)
 	}
 
-	// Create dynamic peer
-	peer, err := vm.peerManager.CreateDynamicPeer(userID, serverID, deviceType, deviceName)
+	// Check plan entitlements
+	opts := vm.peerOptions(ctx, userID)
+	if err := vm.checkEntitlements(ctx, userID, opts); err != nil {
+		return nil, "", err
+	}
+
+	// Route the networks of the chosen preset
+	if err := vm.routing.apply(&opts, routingPreset); err != nil {
+		return nil, "", err
+	}
+
+	// Use the requested DNS servers instead of the account default
+	if err := vm.applyDNS(&opts, dns); err != nil {
+		return nil, "", err
+	}
+
+	// Use the requested MTU instead of the network default
+	if err := applyTunnel(&opts, tunnel); err != nil {
+		return nil, "", err
+	}
+	if err := checkObfuscation(server, opts); err != nil {
+		return nil, "", err
+	}
+	vm.selectInterface(ctx, userID, &opts)
+	if err := vm.checkGateway(deviceType, opts, true); err != nil {
+		return nil, "", err
+	}
+
+	// Shadow the selection with the algorithms under evaluation
+	shadow := vm.shadow.Begin(SelectionRequest{RequestedServerID: server.ID, Country: server.Country, DeviceType: deviceType, Dynamic: true})
+
+	// Create dynamic peer, failing over to another server if the node rejects it
+	peer, server, err = vm.createWithFailover(ctx, userID, server, func(ctx context.Context, serverID string) (*wireguard.PeerConfig, error) {
+		return vm.peerManager.CreateDynamicPeer(ctx, userID, serverID, deviceType, deviceName, opts)
+	})
 	if err != nil {
+		if ctxErr := contextError(ctx, "connect"); ctxErr != nil {
+			return nil, "", ctxErr
+		}
 		return nil, "", fmt.Errorf("failed to create dynamic peer: %v", err)
 	}
+	vm.observeApplyLatency(server.ID, true, started)
+	shadow.Finish(server)
 
 	// Generate configuration
-	config, err := vm.peerManager.GenerateConfig(peer)
+	config, err = vm.renderConfig(peer, "dynamic_connect")
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to generate configuration: %v", err)
 	}
 
 	// Update server load
-	vm.serverManager.UpdateServerLoad(serverID, server.Load+1)
+	vm.serverManager.UpdateServerLoad(server.ID, server.Load+1)
+	utils.LogInfoContext(ctx, "Created dynamic peer %s on server %s", peer.ID, server.ID)
+	vm.events.Publish(EventPeerConnected, PeerEvent{UserID: userID, PeerID: peer.ID, ServerID: server.ID, Dynamic: true, Device: deviceName})
+
+	// Record the first connect in the conversion funnel
+	vm.funnel.Record(userID, FunnelStageFirstConnect)
 
 	// Log analytics
-	utils.LogAnalytics(userID, "vpn_dynamic_connect", fmt.Sprintf("server=%s device=%s", serverID, deviceType))
+	utils.LogAnalytics(userID, "vpn_dynamic_connect", fmt.Sprintf("server=%s device=%s", server.ID, deviceType))
 
 	return peer, config, nil
 }
 
 // DynamicDisconnect disconnects a user from a VPN server with a dynamic IP
-func (vm *VPNManager) DynamicDisconnect(userID, peerID string) error {
+func (vm *VPNManager) DynamicDisconnect(ctx context.Context, userID, peerID string) (err error) {
+	ctx, cancel := withTimeout(ctx, vm.config.Timeouts.Disconnect)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "VPNManager.DynamicDisconnect", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
 	vm.mutex.Lock()
 	defer vm.mutex.Unlock()
 
+	// Give up if the deadline passed while waiting for other operations
+	if err := contextError(ctx, "disconnect"); err != nil {
+		return err
+	}
+
 	// Get peer
 	peer, err := vm.peerManager.GetPeer(userID, peerID)
 	if err != nil {
@@ -202,15 +699,84 @@ func (vm *VPNManager) DynamicDisconnect(userID, peerID string) error {
 	}
 
 	// Remove peer
-	if err := vm.peerManager.RemoveDynamicPeer(userID, peerID); err != nil {
+	if err := vm.peerManager.RemoveDynamicPeer(ctx, userID, peerID); err != nil {
+		if ctxErr := contextError(ctx, "disconnect"); ctxErr != nil {
+			return ctxErr
+		}
 		return fmt.Errorf("failed to remove dynamic peer: %v", err)
 	}
 
 	// Update server load
 	vm.serverManager.UpdateServerLoad(peer.ServerID, 0)
+	utils.LogInfoContext(ctx, "Removed dynamic peer %s from server %s", peerID, peer.ServerID)
+	vm.events.Publish(EventPeerDisconnected, PeerEvent{UserID: userID, PeerID: peerID, ServerID: peer.ServerID, Dynamic: true, Reason: DisconnectUser})
 
 	// Log analytics
 	utils.LogAnalytics(userID, "vpn_dynamic_disconnect", fmt.Sprintf("peer=%s", peerID))
 
 	return nil
 }
+
+// updateRouting sets the AllowedIPs of every peer on a routing preset and
+// returns how many were updated. An empty allowedIPs detaches the peers
+// from the preset.
+func (vm *VPNManager) updateRouting(presetID, allowedIPs string) (int, error) {
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	peers, err := vm.peerManager.UpdateRouting(presetID, allowedIPs)
+	return len(peers), err
+}
+
+// RunSessionSweeper periodically renews dynamic peer sessions that have
+// completed a new handshake and removes the ones whose TTL has passed
+func (vm *VPNManager) RunSessionSweeper() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		vm.sweepSessions()
+	}
+}
+
+// sweepSessions renews or expires dynamic peer sessions
+func (vm *VPNManager) sweepSessions() {
+	vm.mutex.Lock()
+	defer vm.mutex.Unlock()
+
+	peers, err := vm.peerManager.ListDynamicPeers()
+	if err != nil {
+		utils.LogError("Failed to list dynamic peers: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	handshakes := vm.peerManager.LatestHandshakes(ctx)
+	now := time.Now()
+
+	for _, peer := range peers {
+		// Renew the session on every new handshake
+		if handshake, ok := handshakes[peer.PublicKey]; ok && handshake.After(peer.LastHandshake) {
+			if err := vm.peerManager.RenewDynamicPeer(peer, handshake); err != nil {
+				utils.LogError("Failed to renew dynamic peer %s: %v", peer.ID, err)
+			}
+			continue
+		}
+
+		if peer.ExpiresAt.IsZero() || now.Before(peer.ExpiresAt) {
+			continue
+		}
+
+		// Session expired
+		if err := vm.peerManager.RemoveDynamicPeer(ctx, peer.UserID, peer.ID); err != nil {
+			utils.LogError("Failed to remove expired dynamic peer %s: %v", peer.ID, err)
+			continue
+		}
+		vm.serverManager.UpdateServerLoad(peer.ServerID, 0)
+
+		vm.events.Publish(EventPeerDisconnected, PeerEvent{UserID: peer.UserID, PeerID: peer.ID, ServerID: peer.ServerID, Dynamic: true, Reason: DisconnectExpired})
+
+		// Log analytics
+		utils.LogAnalytics(peer.UserID, "vpn_session_expired", fmt.Sprintf("peer=%s session=%s", peer.ID, peer.SessionID))
+	}
+}
@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// retryInterval is how long watches wait before retrying after an error
+const retryInterval = 5 * time.Second
+
+// Node represents a VPN node as registered in service discovery
+type Node struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	IP             string `json:"ip"`
+	Endpoint       string `json:"endpoint"` // host:port clients reach the node on
+	PublicKey      string `json:"publicKey"`
+	Country        string `json:"country"`
+	City           string `json:"city"`
+	Capacity       int    `json:"capacity"`
+	BandwidthClass string `json:"bandwidthClass"`
+	Status         string `json:"status"` // online, offline or maintenance
+}
+
+// Backend is a service discovery system nodes register in
+type Backend interface {
+	// Watch calls update with every node once the inventory is first read
+	// and again whenever it changes, until ctx is done. Errors are retried.
+	Watch(ctx context.Context, update func([]Node)) error
+}
+
+// New creates the backend selected in the discovery configuration
+func New(cfg config.DiscoveryConfig) (Backend, error) {
+	switch cfg.Backend {
+	case "consul":
+		return NewConsul(cfg.Consul)
+	case "etcd":
+		return NewEtcd(cfg.Etcd)
+	case "file":
+		return NewFile(cfg.File)
+	default:
+		return nil, fmt.Errorf("unsupported discovery backend: %q", cfg.Backend)
+	}
+}
+
+// wait waits out the retry interval, reporting false when ctx is done
+func wait(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(retryInterval):
+		return true
+	}
+}
@@ -0,0 +1,169 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// consulWait is how long blocking queries wait for a change
+const consulWait = 5 * time.Minute
+
+// Consul sources nodes from the instances of a Consul service, watched with
+// blocking queries. Instances in maintenance mode are in maintenance,
+// instances with a critical check offline.
+type Consul struct {
+	address    string
+	token      string
+	service    string
+	datacenter string
+	client     *http.Client
+}
+
+// consulEntry is a service instance in Consul health API responses
+type consulEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string            `json:"ID"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Meta    map[string]string `json:"Meta"`
+	} `json:"Service"`
+	Checks []struct {
+		CheckID string `json:"CheckID"`
+		Status  string `json:"Status"`
+	} `json:"Checks"`
+}
+
+// NewConsul creates a Consul backend
+func NewConsul(cfg config.ConsulConfig) (*Consul, error) {
+	if cfg.Address == "" || cfg.Service == "" {
+		return nil, fmt.Errorf("consul address and service are required")
+	}
+
+	return &Consul{
+		address:    strings.TrimRight(cfg.Address, "/"),
+		token:      cfg.Token,
+		service:    cfg.Service,
+		datacenter: cfg.Datacenter,
+		client:     &http.Client{Timeout: consulWait + 30*time.Second},
+	}, nil
+}
+
+// Watch runs blocking queries on the service's instances, reporting them
+// whenever Consul's index moves
+func (c *Consul) Watch(ctx context.Context, update func([]Node)) error {
+	index := uint64(0)
+	for {
+		nodes, next, err := c.list(ctx, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			utils.LogWarning("Failed to query Consul service %s: %v", c.service, err)
+			if !wait(ctx) {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		if next != index {
+			update(nodes)
+		}
+		// The index going backwards means Consul's state was reset
+		if next < index {
+			next = 0
+		}
+		if next == 0 && !wait(ctx) {
+			return ctx.Err()
+		}
+		index = next
+	}
+}
+
+// list gets the service's instances once the index is past the given one
+func (c *Consul) list(ctx context.Context, index uint64) ([]Node, uint64, error) {
+	query := url.Values{}
+	query.Set("index", strconv.FormatUint(index, 10))
+	query.Set("wait", consulWait.String())
+	if c.datacenter != "" {
+		query.Set("dc", c.datacenter)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.address+"/v1/health/service/"+url.PathEscape(c.service)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned status %d", resp.StatusCode)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("invalid Consul response: %v", err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	nodes := make([]Node, 0, len(entries))
+	for _, entry := range entries {
+		nodes = append(nodes, entry.node())
+	}
+
+	return nodes, next, nil
+}
+
+// node converts a service instance
+func (e *consulEntry) node() Node {
+	ip := e.Service.Address
+	if ip == "" {
+		ip = e.Node.Address
+	}
+	capacity, _ := strconv.Atoi(e.Service.Meta["capacity"])
+
+	node := Node{
+		ID:             e.Service.ID,
+		Name:           e.Service.Meta["name"],
+		IP:             ip,
+		PublicKey:      e.Service.Meta["publicKey"],
+		Country:        e.Service.Meta["country"],
+		City:           e.Service.Meta["city"],
+		Capacity:       capacity,
+		BandwidthClass: e.Service.Meta["bandwidthClass"],
+		Status:         "online",
+	}
+	if e.Service.Port > 0 {
+		node.Endpoint = net.JoinHostPort(ip, strconv.Itoa(e.Service.Port))
+	}
+
+	for _, check := range e.Checks {
+		switch {
+		case check.CheckID == "_node_maintenance" || check.CheckID == "_service_maintenance":
+			node.Status = "maintenance"
+		case check.Status == "critical" && node.Status == "online":
+			node.Status = "offline"
+		}
+	}
+
+	return node
+}
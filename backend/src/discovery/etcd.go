@@ -0,0 +1,253 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Etcd sources nodes from the keys under a prefix, each holding a node's
+// JSON, through the etcd v3 HTTP gateway. The prefix is watched and read
+// again on every change. Nodes without a status are online.
+type Etcd struct {
+	endpoint string
+	prefix   string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewEtcd creates an etcd backend
+func NewEtcd(cfg config.EtcdConfig) (*Etcd, error) {
+	if cfg.Endpoint == "" || cfg.Prefix == "" {
+		return nil, fmt.Errorf("etcd endpoint and prefix are required")
+	}
+
+	return &Etcd{
+		endpoint: strings.TrimRight(cfg.Endpoint, "/"),
+		prefix:   cfg.Prefix,
+		username: cfg.Username,
+		password: cfg.Password,
+		client:   &http.Client{}, // watches stream for as long as they run
+	}, nil
+}
+
+// Watch reads the nodes under the prefix, then watches it from the
+// revision read, reading the nodes again on every change
+func (e *Etcd) Watch(ctx context.Context, update func([]Node)) error {
+	for {
+		err := e.watch(ctx, update)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		utils.LogWarning("Failed to watch etcd prefix %s: %v", e.prefix, err)
+		if !wait(ctx) {
+			return ctx.Err()
+		}
+	}
+}
+
+// watch runs one watch session until it fails
+func (e *Etcd) watch(ctx context.Context, update func([]Node)) error {
+	token, err := e.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+
+	nodes, revision, err := e.list(ctx, token)
+	if err != nil {
+		return err
+	}
+	update(nodes)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            encodeKey(e.prefix),
+			"range_end":      encodeKey(prefixEnd(e.prefix)),
+			"start_revision": revision + 1,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/v3/watch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd returned status %d", resp.StatusCode)
+	}
+
+	// The gateway streams one JSON object per watch response
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Result struct {
+				Canceled bool              `json:"canceled"`
+				Events   []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			return err
+		}
+		if message.Error != nil {
+			return fmt.Errorf("watch failed: %s", message.Error.Message)
+		}
+		if message.Result.Canceled {
+			return fmt.Errorf("watch was canceled")
+		}
+		if len(message.Result.Events) == 0 {
+			continue
+		}
+
+		nodes, _, err := e.list(ctx, token)
+		if err != nil {
+			return err
+		}
+		update(nodes)
+	}
+}
+
+// list reads the nodes under the prefix and the revision they were read at
+func (e *Etcd) list(ctx context.Context, token string) ([]Node, int64, error) {
+	var result struct {
+		Header struct {
+			Revision int64 `json:"revision,string"`
+		} `json:"header"`
+		Kvs []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	err := e.do(ctx, token, "/v3/kv/range", map[string]interface{}{
+		"key":       encodeKey(e.prefix),
+		"range_end": encodeKey(prefixEnd(e.prefix)),
+	}, &result)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read nodes: %v", err)
+	}
+
+	nodes := make([]Node, 0, len(result.Kvs))
+	for _, kv := range result.Kvs {
+		key, _ := base64.StdEncoding.DecodeString(kv.Key)
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			utils.LogWarning("Skipping etcd node %s: %v", key, err)
+			continue
+		}
+
+		var node Node
+		if err := json.Unmarshal(value, &node); err != nil {
+			utils.LogWarning("Skipping etcd node %s: %v", key, err)
+			continue
+		}
+		if node.ID == "" {
+			node.ID = strings.TrimPrefix(string(key), e.prefix)
+		}
+		if node.Status == "" {
+			node.Status = "online"
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, result.Header.Revision, nil
+}
+
+// authenticate gets a token when authentication is configured
+func (e *Etcd) authenticate(ctx context.Context) (string, error) {
+	if e.username == "" {
+		return "", nil
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	err := e.do(ctx, "", "/v3/auth/authenticate", map[string]string{
+		"name":     e.username,
+		"password": e.password,
+	}, &result)
+	if err != nil {
+		return "", fmt.Errorf("failed to authenticate: %v", err)
+	}
+
+	return result.Token, nil
+}
+
+// do sends a request to the etcd HTTP gateway and decodes the response
+// into result
+func (e *Etcd) do(ctx context.Context, token, path string, payload, result interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, failure.Message)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	return nil
+}
+
+// encodeKey encodes a key as the gateway expects
+func encodeKey(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+// prefixEnd gets the end of the key range with a prefix
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return "\x00"
+}
@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// inventoryVersion is the version of the inventory file format
+const inventoryVersion = 1
+
+// inventoryStatuses are the statuses servers can be declared with
+var inventoryStatuses = map[string]bool{"online": true, "offline": true, "maintenance": true}
+
+// File sources nodes from an inventory file, read again whenever its
+// content changes. An invalid file is logged and the last valid inventory
+// kept.
+type File struct {
+	path     string
+	interval time.Duration
+}
+
+// inventory is the content of an inventory file
+type inventory struct {
+	Version int               `json:"version" yaml:"version"`
+	Servers []inventoryServer `json:"servers" yaml:"servers"`
+}
+
+// inventoryServer is a server declared in an inventory file
+type inventoryServer struct {
+	ID             string `json:"id" yaml:"id"`
+	Name           string `json:"name" yaml:"name"`
+	IP             string `json:"ip" yaml:"ip"`
+	Endpoint       string `json:"endpoint" yaml:"endpoint"`
+	PublicKey      string `json:"publicKey" yaml:"publicKey"`
+	Country        string `json:"country" yaml:"country"`
+	City           string `json:"city" yaml:"city"`
+	Capacity       int    `json:"capacity" yaml:"capacity"`
+	BandwidthClass string `json:"bandwidthClass" yaml:"bandwidthClass"`
+	Status         string `json:"status" yaml:"status"` // online by default
+}
+
+// NewFile creates an inventory file backend
+func NewFile(cfg config.FileDiscoveryConfig) (*File, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("inventory file path is required")
+	}
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	return &File{path: cfg.Path, interval: interval}, nil
+}
+
+// Watch reads the inventory file and checks it for changes every interval
+func (f *File) Watch(ctx context.Context, update func([]Node)) error {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	var last [sha256.Size]byte
+	for {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			utils.LogWarning("Failed to read inventory file %s: %v", f.path, err)
+		} else if sum := sha256.Sum256(data); sum != last {
+			last = sum
+			if nodes, err := f.parse(data); err != nil {
+				utils.LogError("Invalid inventory file %s, keeping the last servers: %v", f.path, err)
+			} else {
+				utils.LogInfo("Loaded %d servers from inventory file %s", len(nodes), f.path)
+				update(nodes)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// parse decodes and checks an inventory, rejecting unknown fields so typos
+// do not go unnoticed
+func (f *File) parse(data []byte) ([]Node, error) {
+	var inv inventory
+	if strings.EqualFold(filepath.Ext(f.path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&inv); err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&inv); err != nil {
+			return nil, err
+		}
+	}
+
+	if inv.Version != inventoryVersion {
+		return nil, fmt.Errorf("unsupported version %d, expected %d", inv.Version, inventoryVersion)
+	}
+
+	nodes := make([]Node, 0, len(inv.Servers))
+	seen := make(map[string]bool, len(inv.Servers))
+	for i, server := range inv.Servers {
+		if server.ID == "" {
+			return nil, fmt.Errorf("server %d has no id", i+1)
+		}
+		if seen[server.ID] {
+			return nil, fmt.Errorf("server %s is declared twice", server.ID)
+		}
+		seen[server.ID] = true
+
+		if server.Status == "" {
+			server.Status = "online"
+		}
+		if !inventoryStatuses[server.Status] {
+			return nil, fmt.Errorf("server %s has invalid status %q", server.ID, server.Status)
+		}
+		if server.Capacity < 0 {
+			return nil, fmt.Errorf("server %s has negative capacity", server.ID)
+		}
+
+		nodes = append(nodes, Node(server))
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,124 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// cloudflareAPI is the base URL of the Cloudflare v4 API
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// Cloudflare publishes challenge records in a Cloudflare zone using an API
+// token with DNS edit permission for the zone
+type Cloudflare struct {
+	token   string
+	zoneID  string
+	client  *http.Client
+	records map[string]string // fqdn and value -> record ID
+	mutex   sync.Mutex
+}
+
+// cloudflareResponse is the envelope of every Cloudflare API response
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result struct {
+		ID string `json:"id"`
+	} `json:"result"`
+}
+
+// NewCloudflare creates a Cloudflare provider
+func NewCloudflare(cfg config.CloudflareConfig) (*Cloudflare, error) {
+	if cfg.APIToken == "" || cfg.ZoneID == "" {
+		return nil, fmt.Errorf("cloudflare API token and zone ID are required")
+	}
+
+	return &Cloudflare{
+		token:   cfg.APIToken,
+		zoneID:  cfg.ZoneID,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		records: make(map[string]string),
+	}, nil
+}
+
+// Present creates the TXT record
+func (p *Cloudflare) Present(ctx context.Context, fqdn, value string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"type":    "TXT",
+		"name":    strings.TrimSuffix(fqdn, "."),
+		"content": value,
+		"ttl":     120,
+	})
+	if err != nil {
+		return err
+	}
+
+	result, err := p.do(ctx, http.MethodPost, "/zones/"+p.zoneID+"/dns_records", body)
+	if err != nil {
+		return fmt.Errorf("failed to create TXT record %s: %v", fqdn, err)
+	}
+
+	p.mutex.Lock()
+	p.records[fqdn+" "+value] = result.Result.ID
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// CleanUp removes a TXT record created by Present
+func (p *Cloudflare) CleanUp(ctx context.Context, fqdn, value string) error {
+	p.mutex.Lock()
+	id, ok := p.records[fqdn+" "+value]
+	delete(p.records, fqdn+" "+value)
+	p.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown TXT record %s", fqdn)
+	}
+
+	if _, err := p.do(ctx, http.MethodDelete, "/zones/"+p.zoneID+"/dns_records/"+id, nil); err != nil {
+		return fmt.Errorf("failed to delete TXT record %s: %v", fqdn, err)
+	}
+
+	return nil
+}
+
+// do sends a request to the Cloudflare API
+func (p *Cloudflare) do(ctx context.Context, method, path string, body []byte) (*cloudflareResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, method, cloudflareAPI+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid response (status %d): %v", resp.StatusCode, err)
+	}
+	if !result.Success {
+		messages := make([]string, len(result.Errors))
+		for i, e := range result.Errors {
+			messages[i] = e.Message
+		}
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.Join(messages, "; "))
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,28 @@
+package dnsprovider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// Provider publishes the TXT records used to answer ACME DNS-01 challenges
+type Provider interface {
+	// Present creates a TXT record with the given value at fqdn
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes a TXT record created by Present
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// New creates the DNS provider selected in the certificates configuration
+func New(ctx context.Context, cfg config.CertificatesConfig) (Provider, error) {
+	switch cfg.DNSProvider {
+	case "route53":
+		return NewRoute53(ctx, cfg.Route53)
+	case "cloudflare":
+		return NewCloudflare(cfg.Cloudflare)
+	default:
+		return nil, fmt.Errorf("unsupported DNS provider: %q", cfg.DNSProvider)
+	}
+}
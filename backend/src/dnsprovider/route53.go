@@ -0,0 +1,85 @@
+package dnsprovider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/vpn-service/backend/src/config"
+)
+
+// route53ChangeTimeout limits how long to wait for Route53 to apply a change
+const route53ChangeTimeout = 2 * time.Minute
+
+// Route53 publishes challenge records in an AWS Route53 hosted zone.
+// Credentials come from the standard AWS chain (environment, shared
+// config or instance role).
+type Route53 struct {
+	client *route53.Client
+	zoneID string
+}
+
+// NewRoute53 creates a Route53 provider
+func NewRoute53(ctx context.Context, cfg config.Route53Config) (*Route53, error) {
+	if cfg.HostedZoneID == "" {
+		return nil, fmt.Errorf("route53 hosted zone ID is required")
+	}
+
+	opts := make([]func(*awsconfig.LoadOptions) error, 0)
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+
+	return &Route53{
+		client: route53.NewFromConfig(awsCfg),
+		zoneID: cfg.HostedZoneID,
+	}, nil
+}
+
+// Present creates the TXT record and waits until Route53 has applied it
+func (p *Route53) Present(ctx context.Context, fqdn, value string) error {
+	return p.change(ctx, types.ChangeActionUpsert, fqdn, value)
+}
+
+// CleanUp removes the TXT record
+func (p *Route53) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.change(ctx, types.ChangeActionDelete, fqdn, value)
+}
+
+// change applies a single TXT record change and waits for it to be in sync
+func (p *Route53) change(ctx context.Context, action types.ChangeAction, fqdn, value string) error {
+	output, err := p.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(p.zoneID),
+		ChangeBatch: &types.ChangeBatch{
+			Comment: aws.String("ACME DNS-01 challenge"),
+			Changes: []types.Change{{
+				Action: action,
+				ResourceRecordSet: &types.ResourceRecordSet{
+					Name:            aws.String(fqdn),
+					Type:            types.RRTypeTxt,
+					TTL:             aws.Int64(60),
+					ResourceRecords: []types.ResourceRecord{{Value: aws.String(strconv.Quote(value))}},
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to %s TXT record %s: %v", action, fqdn, err)
+	}
+
+	waiter := route53.NewResourceRecordSetsChangedWaiter(p.client)
+	if err := waiter.Wait(ctx, &route53.GetChangeInput{Id: output.ChangeInfo.Id}, route53ChangeTimeout); err != nil {
+		return fmt.Errorf("failed waiting for TXT record %s: %v", fqdn, err)
+	}
+
+	return nil
+}
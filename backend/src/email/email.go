@@ -0,0 +1,115 @@
+// Package email renders templated emails and delivers them over SMTP,
+// Amazon SES or SendGrid
+package email
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Message is an email ready to be delivered
+type Message struct {
+	From        string
+	To          string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Sender delivers emails through one backend
+type Sender interface {
+	// Send delivers a message
+	Send(ctx context.Context, msg *Message) error
+}
+
+// NewSender creates the sender for the backend selected in the email
+// configuration
+func NewSender(ctx context.Context, cfg config.EmailConfig) (Sender, error) {
+	switch cfg.Backend {
+	case "", "log":
+		return &LogSender{}, nil
+	case "smtp":
+		return NewSMTP(cfg.SMTP)
+	case "ses":
+		return NewSES(ctx, cfg.SES)
+	case "sendgrid":
+		return NewSendGrid(cfg.SendGrid)
+	default:
+		return nil, fmt.Errorf("unsupported email backend: %q", cfg.Backend)
+	}
+}
+
+// Mailer renders emails from the built-in templates and sends them from the
+// configured address
+type Mailer struct {
+	sender  Sender
+	from    string
+	baseURL string
+}
+
+// NewMailer creates a mailer for the configured backend
+func NewMailer(ctx context.Context, cfg config.EmailConfig) (*Mailer, error) {
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %v", cfg.From, err)
+	}
+
+	sender, err := NewSender(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mailer{
+		sender:  sender,
+		from:    cfg.From,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+	}, nil
+}
+
+// URL gets the link to a path of the web app
+func (m *Mailer) URL(path string) string {
+	return m.baseURL + path
+}
+
+// Send renders a template with data and sends it to an address with
+// optional attachments
+func (m *Mailer) Send(ctx context.Context, to, template string, data interface{}, attachments ...Attachment) error {
+	msg, err := Render(template, data)
+	if err != nil {
+		return err
+	}
+	msg.From = m.from
+	msg.To = to
+	msg.Attachments = attachments
+
+	if err := m.sender.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send %s email: %v", template, err)
+	}
+
+	return nil
+}
+
+// LogSender writes emails to the log instead of delivering them, for
+// development
+type LogSender struct{}
+
+// Send logs a message
+func (s *LogSender) Send(ctx context.Context, msg *Message) error {
+	utils.LogInfo("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	for _, attachment := range msg.Attachments {
+		utils.LogInfo("Email to %s: attached %s (%d bytes)", msg.To, attachment.Filename, len(attachment.Data))
+	}
+	return nil
+}
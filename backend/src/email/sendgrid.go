@@ -0,0 +1,131 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// sendGridAPI is the SendGrid v3 mail send endpoint
+const sendGridAPI = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGrid delivers emails through the SendGrid v3 API
+type SendGrid struct {
+	apiKey string
+	client *http.Client
+}
+
+// sendGridAddress is an address in the SendGrid API
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+// sendGridContent is a body in the SendGrid API
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// sendGridAttachment is an attachment in the SendGrid API
+type sendGridAttachment struct {
+	Content     []byte `json:"content"` // base64 encoded by encoding/json
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+}
+
+// NewSendGrid creates a SendGrid sender
+func NewSendGrid(cfg config.SendGridConfig) (*SendGrid, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("SendGrid API key is required")
+	}
+
+	return &SendGrid{
+		apiKey: cfg.APIKey,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Send delivers a message
+func (s *SendGrid) Send(ctx context.Context, msg *Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %v", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %v", err)
+	}
+
+	// Plain text must come before HTML
+	content := make([]sendGridContent, 0, 2)
+	if msg.Text != "" {
+		content = append(content, sendGridContent{Type: "text/plain", Value: msg.Text})
+	}
+	if msg.HTML != "" {
+		content = append(content, sendGridContent{Type: "text/html", Value: msg.HTML})
+	}
+
+	request := map[string]interface{}{
+		"personalizations": []map[string]interface{}{
+			{"to": []sendGridAddress{{Email: to.Address, Name: to.Name}}},
+		},
+		"from":    sendGridAddress{Email: from.Address, Name: from.Name},
+		"subject": msg.Subject,
+		"content": content,
+	}
+	if len(msg.Attachments) > 0 {
+		attachments := make([]sendGridAttachment, len(msg.Attachments))
+		for i, attachment := range msg.Attachments {
+			attachments[i] = sendGridAttachment{
+				Content:     attachment.Data,
+				Type:        attachment.ContentType,
+				Filename:    attachment.Filename,
+				Disposition: "attachment",
+			}
+		}
+		request["attachments"] = attachments
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sendGridAPI, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var result struct {
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		json.Unmarshal(data, &result)
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("SendGrid returned status %d: %s", resp.StatusCode, result.Errors[0].Message)
+		}
+		return fmt.Errorf("SendGrid returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
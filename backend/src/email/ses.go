@@ -0,0 +1,138 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/vpn-service/backend/src/config"
+)
+
+// SES delivers emails through the Amazon SES v2 API. Credentials come from
+// the standard AWS chain (environment, shared config or instance role).
+type SES struct {
+	endpoint    string
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	client      *http.Client
+}
+
+// sesContent is the body of a plain text and HTML email in the SES API
+type sesContent struct {
+	Data    string `json:"Data"`
+	Charset string `json:"Charset"`
+}
+
+// NewSES creates an SES sender
+func NewSES(ctx context.Context, cfg config.SESConfig) (*SES, error) {
+	opts := make([]func(*awsconfig.LoadOptions) error, 0)
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+	if awsCfg.Region == "" {
+		return nil, fmt.Errorf("SES region is required")
+	}
+
+	return &SES{
+		endpoint:    fmt.Sprintf("https://email.%s.amazonaws.com/v2/email/outbound-emails", awsCfg.Region),
+		region:      awsCfg.Region,
+		credentials: awsCfg.Credentials,
+		signer:      v4.NewSigner(),
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Send delivers a message. Messages with attachments are sent as raw MIME.
+func (s *SES) Send(ctx context.Context, msg *Message) error {
+	content, err := sesMessageContent(msg)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"FromEmailAddress": msg.From,
+		"Destination": map[string][]string{
+			"ToAddresses": {msg.To},
+		},
+		"Content": content,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Sign the request with SigV4
+	credentials, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %v", err)
+	}
+	hash := sha256.Sum256(payload)
+	if err := s.signer.SignHTTP(ctx, credentials, req, hex.EncodeToString(hash[:]), "ses", s.region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign SES request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		json.Unmarshal(data, &result)
+		return fmt.Errorf("SES returned status %d: %s", resp.StatusCode, result.Message)
+	}
+
+	return nil
+}
+
+// sesMessageContent builds the content of a message in the SES API
+func sesMessageContent(msg *Message) (map[string]interface{}, error) {
+	if len(msg.Attachments) > 0 {
+		raw, err := buildMIME(msg)
+		if err != nil {
+			return nil, err
+		}
+		// Blobs are base64 encoded in the JSON API
+		return map[string]interface{}{
+			"Raw": map[string][]byte{"Data": raw},
+		}, nil
+	}
+
+	body := map[string]sesContent{}
+	if msg.Text != "" {
+		body["Text"] = sesContent{Data: msg.Text, Charset: "UTF-8"}
+	}
+	if msg.HTML != "" {
+		body["Html"] = sesContent{Data: msg.HTML, Charset: "UTF-8"}
+	}
+
+	return map[string]interface{}{
+		"Simple": map[string]interface{}{
+			"Subject": sesContent{Data: msg.Subject, Charset: "UTF-8"},
+			"Body":    body,
+		},
+	}, nil
+}
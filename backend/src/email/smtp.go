@@ -0,0 +1,215 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// smtpTimeout bounds connecting to the SMTP server when ctx has no deadline
+const smtpTimeout = 30 * time.Second
+
+// SMTP delivers emails through an SMTP relay
+type SMTP struct {
+	addr string
+	host string
+	auth smtp.Auth
+	tls  bool
+}
+
+// NewSMTP creates an SMTP sender
+func NewSMTP(cfg config.SMTPConfig) (*SMTP, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("SMTP host is required")
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+	}
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	return &SMTP{
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		host: cfg.Host,
+		auth: auth,
+		tls:  cfg.TLS,
+	}, nil
+}
+
+// Send delivers a message
+func (s *SMTP) Send(ctx context.Context, msg *Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %v", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %v", err)
+	}
+
+	body, err := buildMIME(msg)
+	if err != nil {
+		return err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	if s.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{ServerName: s.host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %v", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !s.tls {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+	if s.auth != nil {
+		if err := client.Auth(s.auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected sender: %v", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected recipient: %v", err)
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(body); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected message: %v", err)
+	}
+
+	return client.Quit()
+}
+
+// buildMIME builds a multipart/alternative message with the plain text and
+// HTML bodies, wrapped in multipart/mixed when it has attachments
+func buildMIME(msg *Message) ([]byte, error) {
+	boundary, err := mimeBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&body, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&body, "MIME-Version: 1.0\r\n")
+
+	if len(msg.Attachments) == 0 {
+		if err := writeAlternative(&body, boundary, msg); err != nil {
+			return nil, err
+		}
+		return body.Bytes(), nil
+	}
+
+	mixed, err := mimeBoundary()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&body, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mixed)
+	fmt.Fprintf(&body, "--%s\r\n", mixed)
+	if err := writeAlternative(&body, boundary, msg); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range msg.Attachments {
+		fmt.Fprintf(&body, "--%s\r\n", mixed)
+		fmt.Fprintf(&body, "Content-Type: %s\r\n", attachment.ContentType)
+		fmt.Fprintf(&body, "Content-Disposition: %s\r\n", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+		fmt.Fprintf(&body, "Content-Transfer-Encoding: base64\r\n\r\n")
+
+		// Wrap base64 lines at 76 characters
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			body.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		body.WriteString(encoded + "\r\n")
+	}
+	fmt.Fprintf(&body, "--%s--\r\n", mixed)
+
+	return body.Bytes(), nil
+}
+
+// writeAlternative writes the Content-Type header and parts of a
+// multipart/alternative entity with the plain text and HTML bodies
+func writeAlternative(body *bytes.Buffer, boundary string, msg *Message) error {
+	fmt.Fprintf(body, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		fmt.Fprintf(body, "--%s\r\n", boundary)
+		fmt.Fprintf(body, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		fmt.Fprintf(body, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+		writer := quotedprintable.NewWriter(body)
+		if _, err := writer.Write([]byte(part.content)); err != nil {
+			return err
+		}
+		writer.Close()
+		body.WriteString("\r\n")
+	}
+	fmt.Fprintf(body, "--%s--\r\n", boundary)
+
+	return nil
+}
+
+// mimeBoundary generates a random multipart boundary
+func mimeBoundary() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,65 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates are named after their file in templates/, without extension.
+// Each file defines a "subject", a plain "text" body and an "html" body.
+const (
+	TemplateInvite            = "invite"
+	TemplateConfig            = "config"
+	TemplateAlertNotification = "alert_notification"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/*.tmpl"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/*.tmpl"))
+)
+
+// Render renders a template into a message without sender and recipient.
+// The subject and text body are rendered as plain text and the HTML body
+// with HTML escaping.
+func Render(name string, data interface{}) (*Message, error) {
+	file := name + ".tmpl"
+	if textTemplates.Lookup(file) == nil {
+		return nil, fmt.Errorf("unknown email template: %s", name)
+	}
+
+	subject, err := executeText(name+".subject", data)
+	if err != nil {
+		return nil, err
+	}
+	text, err := executeText(name+".text", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var html bytes.Buffer
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s email: %v", name, err)
+	}
+
+	return &Message{
+		Subject: strings.TrimSpace(subject),
+		Text:    strings.TrimSpace(text),
+		HTML:    html.String(),
+	}, nil
+}
+
+// executeText renders a plain text template
+func executeText(name string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %v", name, err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,95 @@
+package geo
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/vpn-service/backend/src/cache"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Location is the geographic and network location of an IP address
+type Location struct {
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode"`
+	City        string `json:"city"`
+	Continent   string `json:"continent,omitempty"`
+	ASN         uint   `json:"asn,omitempty"`
+	ASOrg       string `json:"asOrg,omitempty"`
+}
+
+// Locator looks up IP addresses in MaxMind GeoIP2/GeoLite2 databases
+type Locator struct {
+	city    *geoip2.Reader
+	asn     *geoip2.Reader
+	lookups *cache.Cache[string, *Location]
+}
+
+// Open opens the configured geo databases. The ASN database is optional.
+func Open(cfg config.GeoConfig) (*Locator, error) {
+	city, err := geoip2.Open(cfg.CityDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open city database: %v", err)
+	}
+
+	locator := &Locator{
+		city:    city,
+		lookups: cache.New[string, *Location]("geo_lookups", 24*time.Hour),
+	}
+
+	if cfg.ASNDatabase != "" {
+		asn, err := geoip2.Open(cfg.ASNDatabase)
+		if err != nil {
+			utils.LogWarning("ASN database unavailable, servers will have no network info: %v", err)
+		} else {
+			locator.asn = asn
+		}
+	}
+
+	return locator, nil
+}
+
+// Lookup gets the location of an IP address
+func (l *Locator) Lookup(ip string) (*Location, error) {
+	return l.lookups.GetOrLoad(ip, func() (*Location, error) {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", ip)
+		}
+
+		city, err := l.city.City(addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up %s: %v", ip, err)
+		}
+		if city.Country.IsoCode == "" {
+			return nil, fmt.Errorf("no location known for %s", ip)
+		}
+
+		location := &Location{
+			Country:     city.Country.Names["en"],
+			CountryCode: city.Country.IsoCode,
+			City:        city.City.Names["en"],
+			Continent:   city.Continent.Names["en"],
+		}
+
+		if l.asn != nil {
+			if asn, err := l.asn.ASN(addr); err == nil {
+				location.ASN = asn.AutonomousSystemNumber
+				location.ASOrg = asn.AutonomousSystemOrganization
+			}
+		}
+
+		return location, nil
+	})
+}
+
+// Close closes the geo databases
+func (l *Locator) Close() error {
+	if l.asn != nil {
+		l.asn.Close()
+	}
+	return l.city.Close()
+}
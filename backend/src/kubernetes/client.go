@@ -0,0 +1,264 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// ErrNotFound is returned for resources that do not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrExpired is returned by watches whose resource version is too old, so
+// the resources have to be listed again
+var ErrExpired = errors.New("resource version expired")
+
+// Client talks to the Kubernetes API server with a service account token
+type Client struct {
+	server    string
+	token     string
+	namespace string
+	client    *http.Client
+	watcher   *http.Client // without a timeout, as watches stream
+}
+
+// NewClient creates a client, for the cluster the backend runs in unless
+// kubernetes.apiServer is set
+func NewClient(cfg config.KubernetesConfig) (*Client, error) {
+	server := cfg.APIServer
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("not running in a cluster and kubernetes.apiServer is not set")
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+	}
+
+	token, err := os.ReadFile(cfg.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %v", err)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cluster CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid cluster CA in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+
+	return &Client{
+		server:    strings.TrimRight(server, "/"),
+		token:     strings.TrimSpace(string(token)),
+		namespace: cfg.Namespace,
+		client:    &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		watcher:   &http.Client{Transport: transport},
+	}, nil
+}
+
+// List lists the custom resources of a kind in the watched namespace into
+// items, a pointer to a slice, and returns the list's resource version
+func (c *Client) List(ctx context.Context, resource string, items interface{}) (string, error) {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items json.RawMessage `json:"items"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.resourcePath(c.namespace, resource, ""), "", nil, &list); err != nil {
+		return "", fmt.Errorf("failed to list %s: %v", resource, err)
+	}
+	if err := json.Unmarshal(list.Items, items); err != nil {
+		return "", fmt.Errorf("invalid %s: %v", resource, err)
+	}
+
+	return list.Metadata.ResourceVersion, nil
+}
+
+// Watch watches the custom resources of a kind from a resource version,
+// calling changed on every event, until the watch ends or ctx is done
+func (c *Client) Watch(ctx context.Context, resource, resourceVersion string, changed func()) error {
+	query := url.Values{}
+	query.Set("watch", "1")
+	query.Set("resourceVersion", resourceVersion)
+	query.Set("allowWatchBookmarks", "true")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+c.resourcePath(c.namespace, resource, "")+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.watcher.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return ErrExpired
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("watch of %s returned status %d", resource, resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string `json:"type"`
+			Object struct {
+				Code int `json:"code"` // of ERROR events
+			} `json:"object"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+		switch event.Type {
+		case "BOOKMARK":
+			continue
+		case "ERROR":
+			if event.Object.Code == http.StatusGone {
+				return ErrExpired
+			}
+			return fmt.Errorf("watch of %s failed with status %d", resource, event.Object.Code)
+		}
+		changed()
+	}
+}
+
+// UpdateStatus replaces the status of a custom resource
+func (c *Client) UpdateStatus(ctx context.Context, resource string, meta ObjectMeta, status interface{}) error {
+	patch := map[string]interface{}{"status": status}
+	if err := c.patch(ctx, c.resourcePath(meta.Namespace, resource, meta.Name)+"/status", patch); err != nil {
+		return fmt.Errorf("failed to update status of %s %s/%s: %v", resource, meta.Namespace, meta.Name, err)
+	}
+	return nil
+}
+
+// SetFinalizers replaces the finalizers of a custom resource. The resource
+// version makes the update fail if the resource changed meanwhile.
+func (c *Client) SetFinalizers(ctx context.Context, resource string, meta ObjectMeta, finalizers []string) error {
+	if finalizers == nil {
+		finalizers = []string{}
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": meta.ResourceVersion,
+		},
+	}
+	if err := c.patch(ctx, c.resourcePath(meta.Namespace, resource, meta.Name), patch); err != nil {
+		return fmt.Errorf("failed to set finalizers of %s %s/%s: %v", resource, meta.Namespace, meta.Name, err)
+	}
+	return nil
+}
+
+// ApplySecret creates or replaces a Secret owned by a resource
+func (c *Client) ApplySecret(ctx context.Context, namespace, name string, owner OwnerReference, data map[string][]byte) error {
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":            name,
+			"namespace":       namespace,
+			"ownerReferences": []OwnerReference{owner},
+			"labels":          map[string]string{"app.kubernetes.io/managed-by": "vpn-service"},
+		},
+		"type": "Opaque",
+		"data": data, // encoded as base64 by encoding/json
+	}
+	body, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/secrets"
+	err = c.do(ctx, http.MethodPut, path+"/"+url.PathEscape(name), "application/json", body, nil)
+	if err == ErrNotFound {
+		err = c.do(ctx, http.MethodPost, path, "application/json", body, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s/%s: %v", namespace, name, err)
+	}
+	return nil
+}
+
+// patch applies a JSON merge patch to a resource
+func (c *Client) patch(ctx context.Context, path string, patch interface{}) error {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPatch, path, "application/merge-patch+json", body, nil)
+}
+
+// resourcePath gets the API path of custom resources of a kind, in a
+// namespace unless it is empty, or of one of them if a name is given
+func (c *Client) resourcePath(namespace, resource, name string) string {
+	path := "/apis/" + Group + "/" + Version
+	if namespace != "" {
+		path += "/namespaces/" + url.PathEscape(namespace)
+	}
+	path += "/" + resource
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return path
+}
+
+// do sends a request to the API server and decodes the response into
+// result, if given
+func (c *Client) do(ctx context.Context, method, path, contentType string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, failure.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response (status %d): %v", resp.StatusCode, err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package kubernetes
+
+import "time"
+
+// API group and version of the custom resources
+const (
+	Group   = "vpn-service.io"
+	Version = "v1alpha1"
+)
+
+// Resources, as named in API paths
+const (
+	VPNServers = "vpnservers"
+	VPNPeers   = "vpnpeers"
+)
+
+// ObjectMeta holds the metadata of a resource the controller uses
+type ObjectMeta struct {
+	Name              string     `json:"name"`
+	Namespace         string     `json:"namespace,omitempty"`
+	UID               string     `json:"uid,omitempty"`
+	ResourceVersion   string     `json:"resourceVersion,omitempty"`
+	Generation        int64      `json:"generation,omitempty"`
+	Finalizers        []string   `json:"finalizers,omitempty"`
+	DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
+}
+
+// VPNServer represents a VPN server, such as a WireGuard pod running the
+// node agent as the server
+type VPNServer struct {
+	Metadata ObjectMeta      `json:"metadata"`
+	Spec     VPNServerSpec   `json:"spec"`
+	Status   VPNServerStatus `json:"status"`
+}
+
+// VPNServerSpec is the wanted state of a VPN server
+type VPNServerSpec struct {
+	DisplayName    string `json:"displayName,omitempty"` // defaults to the resource name
+	Endpoint       string `json:"endpoint"`              // host:port clients reach the server on
+	PublicKey      string `json:"publicKey,omitempty"`
+	Country        string `json:"country,omitempty"`
+	City           string `json:"city,omitempty"`
+	Capacity       int    `json:"capacity,omitempty"`
+	BandwidthClass string `json:"bandwidthClass,omitempty"`
+	Maintenance    bool   `json:"maintenance,omitempty"`
+}
+
+// VPNServerStatus is the observed state of a VPN server
+type VPNServerStatus struct {
+	ServerID           string `json:"serverId,omitempty"` // the node agent's agent.serverId
+	Status             string `json:"status,omitempty"`
+	Load               int    `json:"load"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+}
+
+// VPNPeer represents a device of a user on a VPN server
+type VPNPeer struct {
+	Metadata ObjectMeta    `json:"metadata"`
+	Spec     VPNPeerSpec   `json:"spec"`
+	Status   VPNPeerStatus `json:"status"`
+}
+
+// VPNPeerSpec is the wanted state of a peer
+type VPNPeerSpec struct {
+	UserID     string `json:"userId"`
+	Server     string `json:"server"` // name of a VPNServer in the same namespace
+	DeviceName string `json:"deviceName,omitempty"`
+	DeviceType string `json:"deviceType,omitempty"`
+	PublicKey  string `json:"publicKey,omitempty"` // of a device bringing its own key
+}
+
+// Peer phases
+const (
+	PeerPending = "Pending" // waiting for its server or for admin approval of its key
+	PeerReady   = "Ready"
+	PeerFailed  = "Failed"
+)
+
+// VPNPeerStatus is the observed state of a peer
+type VPNPeerStatus struct {
+	Phase              string `json:"phase,omitempty"`
+	Message            string `json:"message,omitempty"`
+	PeerID             string `json:"peerId,omitempty"`
+	ServerID           string `json:"serverId,omitempty"`
+	Address            string `json:"address,omitempty"`
+	ConfigSecret       string `json:"configSecret,omitempty"` // Secret holding the client config
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+}
+
+// OwnerReference ties a resource to the one it was created for, so it is
+// deleted with it
+type OwnerReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+	Controller bool   `json:"controller"`
+}
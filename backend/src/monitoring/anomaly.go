@@ -0,0 +1,263 @@
+package monitoring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/alerting"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Metrics watched by the anomaly detector
+const (
+	AnomalyConnectErrorRate = "connect_error_rate" // share of failed connects
+	AnomalyApplyLatency     = "apply_latency"      // mean peer apply time in seconds
+	AnomalyAuthFailures     = "auth_failures"      // failed authentications per interval
+)
+
+const (
+	// maxAnomalies is the number of recent anomalies kept for the admin API
+	maxAnomalies = 100
+	// seasonalMinDays is how many days of history an hour of day needs
+	// before its own baseline is used
+	seasonalMinDays = 3
+	// anomalyRule is the alert rule anomalies are dispatched and silenced as
+	anomalyRule = "anomaly"
+)
+
+// anomalyMetric describes how a watched metric is sampled
+type anomalyMetric struct {
+	mean      bool    // sample the mean of observations rather than their sum
+	minStdDev float64 // smallest deviation considered, so a flat baseline does not alert on noise
+}
+
+// anomalyMetrics lists the watched metrics
+var anomalyMetrics = map[string]anomalyMetric{
+	AnomalyConnectErrorRate: {mean: true, minStdDev: 0.02},
+	AnomalyApplyLatency:     {mean: true, minStdDev: 0.05},
+	AnomalyAuthFailures:     {mean: false, minStdDev: 2},
+}
+
+// Anomaly represents a metric that deviated from its baseline
+type Anomaly struct {
+	Metric     string     `json:"metric"`
+	Value      float64    `json:"value"`
+	Baseline   float64    `json:"baseline"`
+	Deviation  float64    `json:"deviation"` // standard deviations above the baseline
+	Seasonal   bool       `json:"seasonal"`  // compared against the baseline for the hour of day
+	DetectedAt time.Time  `json:"detectedAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// baseline is an exponentially weighted moving mean and variance
+type baseline struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// update adds a sample with smoothing factor alpha
+func (b *baseline) update(x, alpha float64) {
+	if b.samples == 0 {
+		b.mean = x
+	} else {
+		diff := x - b.mean
+		b.mean += alpha * diff
+		b.variance = (1 - alpha) * (b.variance + alpha*diff*diff)
+	}
+	b.samples++
+}
+
+// anomalySeries holds the current interval and baselines of a metric
+type anomalySeries struct {
+	sum     float64
+	count   int
+	overall baseline
+	hourly  [24]baseline
+	active  *Anomaly // open anomaly, alerted once until it resolves
+}
+
+// AnomalyDetector raises alerts when key internal metrics deviate from
+// their recent baseline, without an external alerting stack. Every interval
+// each metric is compared with an EWMA baseline, seasonal by hour of day
+// once that hour has enough history, and anomalies are logged as errors
+// (and so reported to error reporting) and sent, with their resolution,
+// through the alert dispatcher.
+type AnomalyDetector struct {
+	config     *config.Config
+	series     map[string]*anomalySeries
+	anomalies  []*Anomaly
+	dispatcher *alerting.Dispatcher
+	mutex      sync.Mutex
+}
+
+// NewAnomalyDetector creates a new anomaly detector
+func NewAnomalyDetector(cfg *config.Config) *AnomalyDetector {
+	ad := &AnomalyDetector{
+		config:    cfg,
+		series:    make(map[string]*anomalySeries, len(anomalyMetrics)),
+		anomalies: make([]*Anomaly, 0),
+		mutex:     sync.Mutex{},
+	}
+	for metric := range anomalyMetrics {
+		ad.series[metric] = &anomalySeries{}
+	}
+	return ad
+}
+
+// SetDispatcher sets the dispatcher anomalies are sent through
+func (ad *AnomalyDetector) SetDispatcher(dispatcher *alerting.Dispatcher) {
+	ad.dispatcher = dispatcher
+}
+
+// Observe records an observation of a watched metric in the current interval
+func (ad *AnomalyDetector) Observe(metric string, value float64) {
+	if ad == nil || !ad.config.Monitoring.Anomaly.Enabled {
+		return
+	}
+
+	ad.mutex.Lock()
+	defer ad.mutex.Unlock()
+
+	if series, ok := ad.series[metric]; ok {
+		series.sum += value
+		series.count++
+	}
+}
+
+// Anomalies gets the recent anomalies, most recent first
+func (ad *AnomalyDetector) Anomalies() []*Anomaly {
+	ad.mutex.Lock()
+	defer ad.mutex.Unlock()
+
+	anomalies := make([]*Anomaly, len(ad.anomalies))
+	for i, anomaly := range ad.anomalies {
+		copied := *anomaly
+		anomalies[i] = &copied
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool {
+		return anomalies[i].DetectedAt.After(anomalies[j].DetectedAt)
+	})
+
+	return anomalies
+}
+
+// Run evaluates the metrics every monitoring.anomaly.interval seconds
+func (ad *AnomalyDetector) Run() {
+	interval := time.Duration(ad.config.Monitoring.Anomaly.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, anomaly := range ad.evaluate(now, interval) {
+			ad.alert(anomaly)
+		}
+	}
+}
+
+// evaluate closes the current interval, compares each metric with its
+// baseline and returns the anomalies that opened or resolved
+func (ad *AnomalyDetector) evaluate(now time.Time, interval time.Duration) []*Anomaly {
+	anomalyConfig := ad.config.Monitoring.Anomaly
+
+	// Hourly baselines get every interval of their hour, so they are smoothed
+	// per day rather than per interval
+	perHour := math.Max(1, float64(time.Hour/interval))
+	hourlyAlpha := anomalyConfig.Alpha / perHour
+	hourlyMinSamples := int(perHour) * seasonalMinDays
+
+	ad.mutex.Lock()
+	defer ad.mutex.Unlock()
+
+	changed := make([]*Anomaly, 0)
+	for metric, series := range ad.series {
+		definition := anomalyMetrics[metric]
+
+		// Mean metrics have no sample in an interval without observations
+		value := series.sum
+		if definition.mean {
+			if series.count == 0 {
+				continue
+			}
+			value = series.sum / float64(series.count)
+		}
+		series.sum = 0
+		series.count = 0
+
+		// Prefer the baseline for this hour of day once it has enough history
+		hourly := &series.hourly[now.Hour()]
+		reference := &series.overall
+		seasonal := anomalyConfig.Seasonal && hourly.samples >= hourlyMinSamples
+		if seasonal {
+			reference = hourly
+		}
+
+		if reference.samples >= anomalyConfig.MinSamples {
+			stdDev := math.Max(math.Sqrt(reference.variance), definition.minStdDev)
+			deviation := (value - reference.mean) / stdDev
+
+			switch {
+			case deviation >= anomalyConfig.Threshold && series.active == nil:
+				series.active = &Anomaly{
+					Metric:     metric,
+					Value:      value,
+					Baseline:   reference.mean,
+					Deviation:  deviation,
+					Seasonal:   seasonal,
+					DetectedAt: now,
+				}
+				ad.anomalies = append(ad.anomalies, series.active)
+				if len(ad.anomalies) > maxAnomalies {
+					ad.anomalies = ad.anomalies[len(ad.anomalies)-maxAnomalies:]
+				}
+				copied := *series.active
+				changed = append(changed, &copied)
+			case deviation < anomalyConfig.Threshold && series.active != nil:
+				resolvedAt := now
+				series.active.ResolvedAt = &resolvedAt
+				copied := *series.active
+				changed = append(changed, &copied)
+				series.active = nil
+				utils.LogInfo("Anomaly in %s resolved: %.4g (baseline %.4g)", metric, value, reference.mean)
+			}
+		}
+
+		series.overall.update(value, anomalyConfig.Alpha)
+		hourly.update(value, hourlyAlpha)
+	}
+
+	return changed
+}
+
+// alert reports an anomaly that opened in the logs, and sends it or its
+// resolution through the dispatcher
+func (ad *AnomalyDetector) alert(anomaly *Anomaly) {
+	notification := &alerting.Notification{
+		Key:      anomalyRule + "/" + anomaly.Metric,
+		Rule:     anomalyRule,
+		Subject:  anomaly.Metric,
+		Summary:  fmt.Sprintf("%s is %.4g, %.1f standard deviations above its baseline of %.4g", anomaly.Metric, anomaly.Value, anomaly.Deviation, anomaly.Baseline),
+		Severity: alerting.SeverityError,
+		Time:     anomaly.DetectedAt,
+		Details:  anomaly,
+	}
+	if anomaly.ResolvedAt != nil {
+		notification.Resolved = true
+		notification.Time = *anomaly.ResolvedAt
+	} else {
+		utils.LogError("Anomaly in %s: %.4g is %.1f standard deviations above the baseline of %.4g", anomaly.Metric, anomaly.Value, anomaly.Deviation, anomaly.Baseline)
+	}
+
+	if ad.dispatcher != nil {
+		ad.dispatcher.Dispatch(notification)
+	}
+}
@@ -0,0 +1,65 @@
+package monitoring
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// InitErrorReporting forwards logged errors and recovered panics to Sentry
+// or a compatible service. Reporting is disabled when no DSN is configured.
+// The returned function flushes pending reports.
+func InitErrorReporting(cfg *config.Config) (func(time.Duration), error) {
+	reportingConfig := cfg.Monitoring.ErrorReporting
+	if reportingConfig.DSN == "" {
+		return func(time.Duration) {}, nil
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         reportingConfig.DSN,
+		SampleRate:  reportingConfig.SampleRate,
+		Environment: reportingConfig.Environment,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize error reporting: %v", err)
+	}
+
+	utils.SetErrorReporter(captureErrorReport)
+	utils.LogInfo("Error reporting enabled")
+
+	return func(timeout time.Duration) { sentry.Flush(timeout) }, nil
+}
+
+// captureErrorReport sends an error report as a Sentry event
+func captureErrorReport(report *utils.ErrorReport) {
+	event := sentry.NewEvent()
+	event.Level = sentry.LevelError
+	event.Message = report.Message
+
+	exceptionType := "error"
+	if report.Panic {
+		event.Level = sentry.LevelFatal
+		exceptionType = "panic"
+		event.Extra["stack"] = string(report.Stack)
+	}
+	event.Exception = []sentry.Exception{{
+		Type:       exceptionType,
+		Value:      report.Message,
+		Stacktrace: sentry.NewStacktrace(),
+	}}
+
+	if report.RequestID != "" {
+		event.Tags["request_id"] = report.RequestID
+	}
+	if report.UserID != "" {
+		event.User = sentry.User{ID: report.UserID}
+	}
+	if report.Request != nil {
+		event.Request = sentry.NewRequest(report.Request)
+	}
+
+	sentry.CurrentHub().CaptureEvent(event)
+}
@@ -35,17 +35,31 @@ type Collector struct {
 	serverLoad             *prometheus.GaugeVec
 	connectionErrors       prometheus.Counter
 	authenticationErrors   prometheus.Counter
+	revocationFailures     prometheus.Counter
 	configurationRequests  prometheus.Counter
 	qrCodeRequests         prometheus.Counter
 	apiRequestDuration     *prometheus.HistogramVec
 	apiRequestCount        *prometheus.CounterVec
+	applyFailures          *prometheus.CounterVec
+	applyLastFailure       *prometheus.GaugeVec
+	applyFailing           *prometheus.GaugeVec
+	applyDuration          *prometheus.HistogramVec
+	connectFailovers       *prometheus.CounterVec
+	connectApplyLatency    *prometheus.HistogramVec
+	driftPeers             *prometheus.GaugeVec
+	driftCorrections       *prometheus.CounterVec
+	serverUptime           *prometheus.GaugeVec
+
+	// Built-in alerting on deviations from recent behavior
+	anomalies *AnomalyDetector
 }
 
 // NewCollector creates a new metrics collector
 func NewCollector(cfg *config.Config) *Collector {
 	collector := &Collector{
-		config: cfg,
-		mutex:  sync.RWMutex{},
+		config:    cfg,
+		mutex:     sync.RWMutex{},
+		anomalies: NewAnomalyDetector(cfg),
 
 		activeConnections: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "vpn_active_connections",
@@ -113,6 +127,11 @@ func NewCollector(cfg *config.Config) *Collector {
 			Help: "Total number of authentication errors",
 		}),
 
+		revocationFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vpn_token_revocation_check_failures_total",
+			Help: "Total number of tokens rejected because the revoked token list could not be checked",
+		}),
+
 		configurationRequests: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "vpn_configuration_requests_total",
 			Help: "Total number of configuration requests",
@@ -139,6 +158,81 @@ func NewCollector(cfg *config.Config) *Collector {
 			},
 			[]string{"method", "endpoint", "status"},
 		),
+
+		applyFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vpn_peer_apply_failures_total",
+				Help: "Total number of failed peer applies per server",
+			},
+			[]string{"server_id", "operation"}, // "add" or "remove"
+		),
+
+		applyLastFailure: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vpn_peer_apply_last_failure_timestamp_seconds",
+				Help: "Unix time of the last failed peer apply per server",
+			},
+			[]string{"server_id", "operation"},
+		),
+
+		applyFailing: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vpn_peer_apply_failing",
+				Help: "Whether the last peer apply on a server failed (1) or succeeded (0)",
+			},
+			[]string{"server_id", "operation"},
+		),
+
+		applyDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "vpn_peer_apply_duration_seconds",
+				Help:    "Duration of peer applies per server",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"server_id", "operation"},
+		),
+
+		connectFailovers: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vpn_connect_failovers_total",
+				Help: "Total number of connects moved to another server after a failed peer apply",
+			},
+			[]string{"from_server", "to_server"},
+		),
+
+		connectApplyLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "vpn_connect_apply_latency_seconds",
+				Help: "Time from a connect request to its peer being live on the node, per server the peer was applied on",
+				// Fine-grained below a second, where most connects land
+				Buckets: []float64{.01, .025, .05, .075, .1, .15, .2, .3, .4, .5, .75, 1, 1.5, 2, 3, 5, 7.5, 10, 15, 30},
+			},
+			[]string{"server_id", "peer_type"}, // "static" or "dynamic"
+		),
+
+		driftPeers: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vpn_wireguard_drift_peers",
+				Help: "Peers on the WireGuard interfaces of a server that differed from the desired state at the last check",
+			},
+			[]string{"server_id", "kind"}, // "missing", "unexpected" or "changed"
+		),
+
+		driftCorrections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vpn_wireguard_drift_corrections_total",
+				Help: "Total number of peers corrected by drift reconciles per server",
+			},
+			[]string{"server_id"},
+		),
+
+		serverUptime: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vpn_server_uptime_ratio",
+				Help: "Share of a window a server was not offline, time in maintenance left out",
+			},
+			[]string{"server_id", "window"}, // "24h", "7d" or "30d"
+		),
 	}
 
 	// Register metrics with Prometheus
@@ -153,15 +247,31 @@ func NewCollector(cfg *config.Config) *Collector {
 		collector.serverLoad,
 		collector.connectionErrors,
 		collector.authenticationErrors,
+		collector.revocationFailures,
 		collector.configurationRequests,
 		collector.qrCodeRequests,
 		collector.apiRequestDuration,
 		collector.apiRequestCount,
+		collector.applyFailures,
+		collector.applyLastFailure,
+		collector.applyFailing,
+		collector.applyDuration,
+		collector.connectFailovers,
+		collector.connectApplyLatency,
+		collector.driftPeers,
+		collector.driftCorrections,
+		collector.serverUptime,
 	)
+	registerRuntimeMetrics()
 
 	return collector
 }
 
+// Anomalies gets the anomaly detector
+func (c *Collector) Anomalies() *AnomalyDetector {
+	return c.anomalies
+}
+
 // StartMetricsServer starts the metrics server
 func (c *Collector) StartMetricsServer() {
 	if !c.config.Monitoring.EnablePrometheus {
@@ -174,7 +284,8 @@ func (c *Collector) StartMetricsServer() {
 		metricsAddr := fmt.Sprintf(":%d", c.config.Monitoring.MetricsPort)
 		utils.LogInfo("Starting metrics server on %s", metricsAddr)
 		http.Handle("/metrics", promhttp.Handler())
-		if err := http.ListenAndServe(metricsAddr, nil); err != nil {
+		server := c.config.ListenerProfile(c.config.Monitoring.MetricsProfile).HTTPServer(metricsAddr, nil)
+		if err := server.ListenAndServe(); err != nil {
 			utils.LogError("Failed to start metrics server: %v", err)
 		}
 	}()
@@ -230,9 +341,28 @@ func (c *Collector) IncrementConnectionErrors() {
 	c.connectionErrors.Inc()
 }
 
+// ObserveConnect records the outcome of a connect attempt
+func (c *Collector) ObserveConnect(failed bool) {
+	if failed {
+		c.connectionErrors.Inc()
+		c.anomalies.Observe(AnomalyConnectErrorRate, 1)
+		return
+	}
+
+	c.totalConnections.Inc()
+	c.anomalies.Observe(AnomalyConnectErrorRate, 0)
+}
+
 // IncrementAuthenticationErrors increments the authentication errors counter
 func (c *Collector) IncrementAuthenticationErrors() {
 	c.authenticationErrors.Inc()
+	c.anomalies.Observe(AnomalyAuthFailures, 1)
+}
+
+// IncrementRevocationCheckFailures increments the counter of tokens rejected
+// because the revoked token list could not be checked
+func (c *Collector) IncrementRevocationCheckFailures() {
+	c.revocationFailures.Inc()
 }
 
 // IncrementConfigurationRequests increments the configuration requests counter
@@ -255,6 +385,58 @@ func (c *Collector) IncrementAPIRequestCount(method, endpoint, status string) {
 	c.apiRequestCount.WithLabelValues(method, endpoint, status).Inc()
 }
 
+// ObservePeerApply records the outcome and duration of a peer apply on a server
+func (c *Collector) ObservePeerApply(serverID, operation string, duration time.Duration, err error) {
+	c.applyDuration.WithLabelValues(serverID, operation).Observe(duration.Seconds())
+	c.anomalies.Observe(AnomalyApplyLatency, duration.Seconds())
+
+	if err == nil {
+		c.applyFailing.WithLabelValues(serverID, operation).Set(0)
+		return
+	}
+
+	c.applyFailures.WithLabelValues(serverID, operation).Inc()
+	c.applyLastFailure.WithLabelValues(serverID, operation).SetToCurrentTime()
+	c.applyFailing.WithLabelValues(serverID, operation).Set(1)
+}
+
+// IncrementConnectFailovers records a connect that failed over to another server
+func (c *Collector) IncrementConnectFailovers(fromServerID, toServerID string) {
+	c.connectFailovers.WithLabelValues(fromServerID, toServerID).Inc()
+}
+
+// ObserveConnectApplyLatency records the time from a connect request to its
+// peer being live on the server it was applied on, including any failover
+func (c *Collector) ObserveConnectApplyLatency(serverID string, dynamic bool, latency time.Duration) {
+	peerType := "static"
+	if dynamic {
+		peerType = "dynamic"
+	}
+	c.connectApplyLatency.WithLabelValues(serverID, peerType).Observe(latency.Seconds())
+}
+
+// ObserveDrift records the drift found on the WireGuard interfaces of a server
+func (c *Collector) ObserveDrift(serverID string, drift wireguard.Drift) {
+	c.driftPeers.WithLabelValues(serverID, "missing").Set(float64(drift.Missing))
+	c.driftPeers.WithLabelValues(serverID, "unexpected").Set(float64(drift.Unexpected))
+	c.driftPeers.WithLabelValues(serverID, "changed").Set(float64(drift.Changed))
+
+	if drift.Corrected {
+		c.driftCorrections.WithLabelValues(serverID).Add(float64(drift.Total()))
+	}
+}
+
+// ObserveServerUptime records the uptime of every server over each window,
+// dropping servers since removed
+func (c *Collector) ObserveServerUptime(uptime map[string]map[string]float64) {
+	c.serverUptime.Reset()
+	for serverID, windows := range uptime {
+		for window, ratio := range windows {
+			c.serverUptime.WithLabelValues(serverID, window).Set(ratio)
+		}
+	}
+}
+
 // UpdateMetrics updates all metrics
 func (c *Collector) UpdateMetrics(servers []*core.Server, connections map[string][]*wireguard.PeerInfo) {
 	c.mutex.Lock()
@@ -266,9 +448,17 @@ func (c *Collector) UpdateMetrics(servers []*core.Server, connections map[string
 	countryCounts := make(map[string]int)
 	deviceCounts := make(map[string]int)
 
-	for _, peers := range connections {
+	for userID, peers := range connections {
 		totalActive += len(peers)
 
+		// Users who opted out of telemetry only count towards totals
+		if !utils.TelemetryEnabled(userID) {
+			for _, peer := range peers {
+				serverCounts[peer.ServerID]++
+			}
+			continue
+		}
+
 		for _, peer := range peers {
 			// Increment server counts
 			serverCounts[peer.ServerID]++
@@ -0,0 +1,39 @@
+package monitoring
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// registerRuntimeMetrics replaces the default Go collector with one that
+// also exports the runtime's GC, memory and scheduler metrics, such as GC
+// pause and scheduling latency histograms. Process metrics (CPU, resident
+// memory, open file descriptors) come from the default process collector.
+func registerRuntimeMetrics() {
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.MustRegister(collectors.NewGoCollector(
+		collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsGC, collectors.MetricsMemory, collectors.MetricsScheduler),
+	))
+}
+
+// RegisterDatabase exports the connection pool statistics of a database:
+// open, in use and idle connections and waits for a free one
+func (c *Collector) RegisterDatabase(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterQueue exports the depth of an internal queue, read on every scrape
+func (c *Collector) RegisterQueue(name string, depth func() int) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name:        "vpn_queue_depth",
+			Help:        "Number of items waiting in an internal queue",
+			ConstLabels: prometheus.Labels{"queue": name},
+		},
+		func() float64 {
+			return float64(depth())
+		},
+	))
+}
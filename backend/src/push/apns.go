@@ -0,0 +1,163 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vpn-service/backend/src/config"
+)
+
+const (
+	apnsProduction = "https://api.push.apple.com"
+	apnsSandbox    = "https://api.sandbox.push.apple.com"
+	// apnsTokenTTL is how long a provider token is reused. Apple rejects
+	// tokens older than an hour and throttles refreshes under 20 minutes.
+	apnsTokenTTL = 50 * time.Minute
+)
+
+// APNs sends notifications through the Apple Push Notification service
+// over HTTP/2, authenticated with a provider token signed by a .p8 key
+type APNs struct {
+	keyID    string
+	teamID   string
+	topic    string
+	host     string
+	key      *ecdsa.PrivateKey
+	client   *http.Client
+	token    string
+	issuedAt time.Time
+	mutex    sync.Mutex
+}
+
+// NewAPNs creates an APNs sender from a token signing key
+func NewAPNs(cfg config.APNsConfig) (*APNs, error) {
+	if cfg.KeyID == "" || cfg.TeamID == "" || cfg.Topic == "" {
+		return nil, fmt.Errorf("APNs key ID, team ID and topic are required")
+	}
+
+	data, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read APNs key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid APNs key: no PEM data")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs key: %v", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid APNs key: not an ECDSA key")
+	}
+
+	host := apnsProduction
+	if cfg.Sandbox {
+		host = apnsSandbox
+	}
+
+	return &APNs{
+		keyID:  cfg.KeyID,
+		teamID: cfg.TeamID,
+		topic:  cfg.Topic,
+		host:   host,
+		key:    key,
+		// The default transport negotiates HTTP/2, which APNs requires
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Send delivers a message to a device token
+func (a *APNs) Send(ctx context.Context, token string, msg Message) error {
+	providerToken, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": msg.Title,
+				"body":  msg.Body,
+			},
+			"sound": "default",
+		},
+	}
+	for key, value := range msg.Data {
+		payload[key] = value
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.host+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send APNs notification: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var result struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode == http.StatusGone || result.Reason == "BadDeviceToken" || result.Reason == "Unregistered" {
+		return ErrUnregistered
+	}
+
+	return fmt.Errorf("APNs returned status %d: %s", resp.StatusCode, result.Reason)
+}
+
+// providerToken gets the signed provider token, issuing a new one when the
+// current one is due
+func (a *APNs) providerToken() (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.token != "" && time.Since(a.issuedAt) < apnsTokenTTL {
+		return a.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.teamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = a.keyID
+
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign APNs provider token: %v", err)
+	}
+
+	a.token = signed
+	a.issuedAt = now
+
+	return a.token, nil
+}
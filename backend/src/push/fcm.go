@@ -0,0 +1,208 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/vpn-service/backend/src/config"
+)
+
+const (
+	// fcmAPI is the base URL of the FCM HTTP v1 API
+	fcmAPI = "https://fcm.googleapis.com/v1/projects/"
+	// fcmScope is the OAuth scope needed to send messages
+	fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+	// googleTokenURL is used when the service account does not name one
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+// FCM sends notifications through the Firebase Cloud Messaging HTTP v1 API,
+// authenticated as a service account
+type FCM struct {
+	projectID   string
+	email       string
+	tokenURL    string
+	key         *rsa.PrivateKey
+	client      *http.Client
+	accessToken string
+	expiresAt   time.Time
+	mutex       sync.Mutex
+}
+
+// serviceAccount is the part of a Google service account key used here
+type serviceAccount struct {
+	ProjectID   string `json:"project_id"`
+	PrivateKey  string `json:"private_key"`
+	ClientEmail string `json:"client_email"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// fcmError is the error body of the FCM API
+type fcmError struct {
+	Error struct {
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// NewFCM creates an FCM sender from a service account key file
+func NewFCM(cfg config.FCMConfig) (*FCM, error) {
+	data, err := os.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account key: %v", err)
+	}
+
+	var account serviceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("invalid service account key: %v", err)
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account private key: %v", err)
+	}
+
+	projectID := cfg.ProjectID
+	if projectID == "" {
+		projectID = account.ProjectID
+	}
+	if projectID == "" || account.ClientEmail == "" {
+		return nil, fmt.Errorf("service account key has no project ID or client email")
+	}
+
+	tokenURL := account.TokenURI
+	if tokenURL == "" {
+		tokenURL = googleTokenURL
+	}
+
+	return &FCM{
+		projectID: projectID,
+		email:     account.ClientEmail,
+		tokenURL:  tokenURL,
+		key:       key,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Send delivers a message to a registration token
+func (f *FCM) Send(ctx context.Context, token string, msg Message) error {
+	accessToken, err := f.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token": token,
+			"notification": map[string]string{
+				"title": msg.Title,
+				"body":  msg.Body,
+			},
+			"data": msg.Data,
+			"android": map[string]string{
+				"priority": "high",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fcmAPI+f.projectID+"/messages:send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send FCM message: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var result fcmError
+	json.NewDecoder(resp.Body).Decode(&result)
+	for _, detail := range result.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return ErrUnregistered
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrUnregistered
+	}
+
+	return fmt.Errorf("FCM returned status %d: %s", resp.StatusCode, result.Error.Message)
+}
+
+// token gets an OAuth access token, exchanging a signed assertion for a new
+// one shortly before the current one expires
+func (f *FCM) token(ctx context.Context) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.accessToken != "" && time.Now().Before(f.expiresAt) {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   f.email,
+		"scope": fcmScope,
+		"aud":   f.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(f.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %v", err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get FCM access token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("FCM token endpoint returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid FCM token response: %v", err)
+	}
+
+	f.accessToken = result.AccessToken
+	f.expiresAt = now.Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+
+	return f.accessToken, nil
+}
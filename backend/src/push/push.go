@@ -0,0 +1,57 @@
+// Package push delivers notifications to mobile devices through Firebase
+// Cloud Messaging and the Apple Push Notification service
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// Platforms devices register tokens for
+const (
+	PlatformFCM  = "fcm"
+	PlatformAPNs = "apns"
+)
+
+// ErrUnregistered is returned when the push service reports that a device
+// token is no longer valid, e.g. because the app was uninstalled
+var ErrUnregistered = errors.New("device token is no longer registered")
+
+// Message is a notification shown on a device
+type Message struct {
+	Title string
+	Body  string
+	Data  map[string]string // passed to the app alongside the alert
+}
+
+// Sender delivers notifications on one platform
+type Sender interface {
+	// Send delivers a message to a device token
+	Send(ctx context.Context, token string, msg Message) error
+}
+
+// New creates the senders configured in the push configuration, by platform
+func New(cfg config.PushConfig) (map[string]Sender, error) {
+	senders := make(map[string]Sender)
+
+	if cfg.FCM.CredentialsFile != "" {
+		fcm, err := NewFCM(cfg.FCM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize FCM: %v", err)
+		}
+		senders[PlatformFCM] = fcm
+	}
+
+	if cfg.APNs.KeyFile != "" {
+		apns, err := NewAPNs(cfg.APNs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize APNs: %v", err)
+		}
+		senders[PlatformAPNs] = apns
+	}
+
+	return senders, nil
+}
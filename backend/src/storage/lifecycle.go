@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Lifecycle deletes objects once they are older than the retention of
+// their key prefix. Objects under no configured prefix are kept.
+type Lifecycle struct {
+	store Store
+	rules map[string]time.Duration // by key prefix
+}
+
+// NewLifecycle creates a lifecycle for store from retention hours by key
+// prefix; prefixes with no retention are kept forever
+func NewLifecycle(store Store, hours map[string]int) *Lifecycle {
+	rules := make(map[string]time.Duration, len(hours))
+	for prefix, h := range hours {
+		if h > 0 {
+			rules[prefix] = time.Duration(h) * time.Hour
+		}
+	}
+	return &Lifecycle{store: store, rules: rules}
+}
+
+// Run applies the rules every interval until ctx is cancelled
+func (l *Lifecycle) Run(ctx context.Context, interval time.Duration) {
+	if len(l.rules) == 0 || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if deleted, err := l.Apply(ctx); err != nil {
+			utils.LogError("Failed to apply storage lifecycle: %v", err)
+		} else if deleted > 0 {
+			utils.LogInfo("Deleted %d expired stored objects", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Apply deletes the expired objects once and returns how many were
+// deleted. Longer prefixes are applied first, so a specific rule can keep
+// objects longer than a general one.
+func (l *Lifecycle) Apply(ctx context.Context) (int, error) {
+	prefixes := make([]string, 0, len(l.rules))
+	for prefix := range l.rules {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+
+	deleted := 0
+	seen := make(map[string]bool)
+	now := time.Now()
+	for _, prefix := range prefixes {
+		objects, err := l.store.List(ctx, prefix)
+		if err != nil {
+			return deleted, err
+		}
+		for _, object := range objects {
+			if seen[object.Key] {
+				continue
+			}
+			seen[object.Key] = true
+
+			if now.Sub(object.ModifiedAt) < l.rules[prefix] {
+				continue
+			}
+			if err := l.store.Delete(ctx, object.Key); err != nil {
+				utils.LogWarning("Failed to delete expired object %s: %v", object.Key, err)
+				continue
+			}
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
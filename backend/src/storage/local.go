@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// LocalDownloadPath is the API path local objects are downloaded from,
+// followed by the key
+const LocalDownloadPath = "/api/v1/artifacts/"
+
+// Local stores objects as files under a directory. Download URLs point at
+// the API and are signed with an HMAC of the key and expiry time.
+type Local struct {
+	dir        string
+	signingKey []byte
+	publicURL  string
+}
+
+// NewLocal creates a local storage backend
+func NewLocal(cfg config.StorageConfig, publicURL string) (*Local, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("storage directory is required")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %v", err)
+	}
+
+	// Without a configured key, links stop working on restart and are
+	// only valid on the instance that made them
+	signingKey := []byte(cfg.SigningKey)
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %v", err)
+		}
+	}
+
+	return &Local{
+		dir:        cfg.Dir,
+		signingKey: signingKey,
+		publicURL:  strings.TrimSuffix(publicURL, "/"),
+	}, nil
+}
+
+// path gets the file of a key
+func (l *Local) path(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes an object to a temporary file and renames it into place, so
+// readers never see a partial object
+func (l *Local) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	return nil
+}
+
+// Get reads an object. The content type is derived from the key.
+func (l *Local) Get(ctx context.Context, key string) ([]byte, string, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %v", key, err)
+	}
+	return data, contentType(key), nil
+}
+
+// Delete removes an object
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %v", key, err)
+	}
+	return nil
+}
+
+// List walks the directory for objects under prefix
+func (l *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := make([]Object, 0)
+	err := filepath.WalkDir(l.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), ModifiedAt: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %v", err)
+	}
+	return objects, nil
+}
+
+// URL returns a signed link to the API download route
+func (l *Local) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", l.sign(key, expires))
+
+	return l.publicURL + LocalDownloadPath + key + "?" + query.Encode(), nil
+}
+
+// Verify checks the signature and expiry of a download link
+func (l *Local) Verify(key, expires, signature string) error {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expiry")
+	}
+	if !hmac.Equal([]byte(l.sign(key, expires)), []byte(signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+	if time.Now().Unix() > unix {
+		return fmt.Errorf("link expired")
+	}
+	return nil
+}
+
+// sign computes the signature of a key and expiry time
+func (l *Local) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, l.signingKey)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
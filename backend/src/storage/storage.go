@@ -0,0 +1,72 @@
+// Package storage keeps generated artifacts, such as rendered configs, QR
+// codes, setup sheets and device archives, on local disk or in an
+// S3-compatible bucket, and hands out time-limited download URLs for them.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// ErrNotFound is returned for objects that do not exist
+var ErrNotFound = errors.New("object not found")
+
+// Object represents a stored object
+type Object struct {
+	Key        string    `json:"key"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+// Store is a storage backend. Keys are slash separated paths such as
+// artifacts/<user>/<peer>/wg0.conf.
+type Store interface {
+	// Put stores data under key, replacing any existing object
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get reads the object under key and its content type
+	Get(ctx context.Context, key string) ([]byte, string, error)
+	// Delete removes the object under key; missing objects are ignored
+	Delete(ctx context.Context, key string) error
+	// List lists the objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// URL returns a URL the object can be downloaded from without
+	// logging in until ttl has passed
+	URL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// New creates the storage backend selected in the configuration. Local
+// download URLs point at the API under publicURL.
+func New(ctx context.Context, cfg config.StorageConfig, publicURL string) (Store, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(cfg, publicURL)
+	case "s3":
+		return NewS3(ctx, cfg.S3)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %q", cfg.Backend)
+	}
+}
+
+// CheckKey checks a key is a clean relative path, so it cannot escape the
+// local storage directory
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("invalid storage key: %q", key)
+	}
+	return nil
+}
+
+// contentType guesses the content type of a key from its extension
+func contentType(key string) string {
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
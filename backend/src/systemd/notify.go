@@ -0,0 +1,78 @@
+// Package systemd implements the sd_notify protocol so systemd can tell
+// when the service is ready and restart it when it stops responding
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// States reported to systemd
+const (
+	StateReady    = "READY=1"
+	StateStopping = "STOPPING=1"
+	StateWatchdog = "WATCHDOG=1"
+)
+
+// Notify sends a state to systemd. It does nothing when the service was not
+// started by systemd with Type=notify.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Sockets starting with @ are in the abstract namespace, which the net
+	// package handles
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval gets the watchdog timeout systemd expects pings within
+// (WatchdogSec= in the unit), or 0 when the watchdog is disabled
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	// The watchdog may be meant for another process of the service
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pings the systemd watchdog at half its timeout until stop is
+// closed. It returns immediately when the watchdog is disabled.
+func RunWatchdog(stop <-chan struct{}) {
+	timeout := WatchdogInterval()
+	if timeout == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := Notify(StateWatchdog); err != nil {
+				utils.LogWarning("Failed to ping systemd watchdog: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
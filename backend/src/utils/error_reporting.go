@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ErrorReport represents an error passed to the error reporter
+type ErrorReport struct {
+	Message   string
+	RequestID string
+	UserID    string
+	Request   *http.Request
+	Panic     bool
+	Stack     []byte // only set for panics
+}
+
+// ErrorReporter forwards errors to an external error tracking service
+type ErrorReporter func(report *ErrorReport)
+
+var (
+	errorReporter      ErrorReporter
+	errorListeners     []ErrorReporter
+	errorReporterMutex sync.RWMutex
+)
+
+// errorScopeKey is the context key holding the error scope of a request
+const errorScopeKey = "errorScope"
+
+// errorScope collects request details that are only known further down the
+// middleware chain, such as the authenticated user
+type errorScope struct {
+	userID string
+	mutex  sync.Mutex
+}
+
+// SetErrorReporter sets the reporter every logged error is forwarded to
+func SetErrorReporter(reporter ErrorReporter) {
+	errorReporterMutex.Lock()
+	defer errorReporterMutex.Unlock()
+	errorReporter = reporter
+}
+
+// AddErrorListener adds a listener that every logged error is also passed
+// to, next to the error reporter
+func AddErrorListener(listener ErrorReporter) {
+	errorReporterMutex.Lock()
+	defer errorReporterMutex.Unlock()
+	errorListeners = append(errorListeners, listener)
+}
+
+// WithErrorScope returns a copy of ctx carrying an empty error scope
+func WithErrorScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, errorScopeKey, &errorScope{})
+}
+
+// SetErrorScopeUser records the authenticated user in the error scope of ctx
+func SetErrorScopeUser(ctx context.Context, userID string) {
+	if scope, ok := ctx.Value(errorScopeKey).(*errorScope); ok {
+		scope.mutex.Lock()
+		scope.userID = userID
+		scope.mutex.Unlock()
+	}
+}
+
+// ReportPanic logs a recovered panic with its stack trace and forwards it to
+// the error reporter
+func ReportPanic(r *http.Request, recovered interface{}, stack []byte) {
+	ctx := r.Context()
+	message := fmt.Sprintf("panic: %v", recovered)
+
+	if logger := LoggerFromContext(ctx); logger != nil {
+		logger.Errorw(message, "method", r.Method, "path", r.URL.Path, "stack", string(stack))
+	} else {
+		fmt.Printf("[ERROR] %s%s\n%s\n", requestIDPrefix(ctx), message, stack)
+	}
+
+	report := newErrorReport(ctx, message)
+	report.Request = r
+	report.Panic = true
+	report.Stack = stack
+	reportError(report)
+}
+
+// newErrorReport builds an error report with the request details of ctx
+func newErrorReport(ctx context.Context, message string) *ErrorReport {
+	report := &ErrorReport{Message: message}
+	report.RequestID = RequestIDFromContext(ctx)
+	if userID, ok := ctx.Value("userID").(string); ok {
+		report.UserID = userID
+	} else if scope, ok := ctx.Value(errorScopeKey).(*errorScope); ok {
+		scope.mutex.Lock()
+		report.UserID = scope.userID
+		scope.mutex.Unlock()
+	}
+
+	// Users who opted out of telemetry are only reported by their anonymous ID
+	if report.UserID != "" && !TelemetryEnabled(report.UserID) {
+		report.UserID = RedactUserID(report.UserID)
+	}
+
+	return report
+}
+
+// reportError forwards a report to the error reporter, if one is set, and
+// the error listeners
+func reportError(report *ErrorReport) {
+	errorReporterMutex.RLock()
+	reporter := errorReporter
+	listeners := errorListeners
+	errorReporterMutex.RUnlock()
+
+	if reporter != nil {
+		reporter(report)
+	}
+	for _, listener := range listeners {
+		listener(report)
+	}
+}
@@ -1,22 +1,113 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 )
 
-// RespondWithError sends an error response
+// clientIPKey is the context key holding a client address resolved from
+// proxy headers
+const clientIPKey = "clientIP"
+
+// WithClientIP returns a copy of ctx carrying the address of the client a
+// trusted proxy relayed the request for
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIP returns the IP address of the client that made a request. For
+// requests relayed by a trusted proxy this is the address the proxy
+// forwarded, otherwise the address of the connection's peer.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok && ip != "" {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ETagMatches checks whether an If-None-Match header lists an entity tag,
+// comparing weakly as conditional GETs do
+func ETagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// RespondWithError sends an error response. The request ID set by the
+// request ID middleware is included so clients can quote it in reports.
 func RespondWithError(w http.ResponseWriter, code int, message string) {
-	RespondWithJSON(w, code, map[string]string{"error": message})
+	payload := map[string]string{"error": message}
+	if requestID := w.Header().Get(RequestIDHeader); requestID != "" {
+		payload["requestId"] = requestID
+	}
+	RespondWithJSON(w, code, payload)
+}
+
+// DecodeRequest decodes a JSON request body and validates it. A body that
+// is not valid JSON is reported as a field error on "body".
+func DecodeRequest(r *http.Request, req Validatable) error {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return &ValidationError{Fields: []FieldError{{Field: "body", Message: "must be a valid JSON object"}}}
+	}
+	return req.Validate()
+}
+
+// RespondWithValidationError sends a bad request response. Validation errors
+// include the invalid fields so clients can highlight them.
+func RespondWithValidationError(w http.ResponseWriter, err error) {
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	payload := map[string]interface{}{
+		"error":  "Invalid request: " + validationErr.Error(),
+		"fields": validationErr.Fields,
+	}
+	if requestID := w.Header().Get(RequestIDHeader); requestID != "" {
+		payload["requestId"] = requestID
+	}
+	RespondWithJSON(w, http.StatusBadRequest, payload)
+}
+
+// WriteErrorResponse sends an error response
+func WriteErrorResponse(w http.ResponseWriter, code int, message string) {
+	RespondWithError(w, code, message)
+}
+
+// WriteJSONResponse sends a JSON response
+func WriteJSONResponse(w http.ResponseWriter, code int, payload interface{}) {
+	RespondWithJSON(w, code, payload)
 }
 
 // RespondWithJSON sends a JSON response
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, If-None-Match")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, ETag")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	
 	// Set content type
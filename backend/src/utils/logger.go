@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -29,9 +30,7 @@ func InitLogger(logDir string) error {
 	}
 
 	// Configure encoder
-	encoderConfig := zap.NewProductionEncoderConfig()
-	encoderConfig.TimeKey = "timestamp"
-	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoderConfig := newEncoderConfig()
 
 	// Create core for main logs
 	mainLogPath := filepath.Join(logDir, "api.log")
@@ -67,6 +66,26 @@ func InitLogger(logDir string) error {
 	return nil
 }
 
+// newEncoderConfig gets the encoder settings of the log files
+func newEncoderConfig() zapcore.EncoderConfig {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "timestamp"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	return encoderConfig
+}
+
+// SetAnalyticsSink sends analytics events to sink instead of the analytics
+// log file, in the same JSON format. Call it at startup, before events are
+// logged.
+func SetAnalyticsSink(sink zapcore.WriteSyncer) {
+	analyticsCore := zapcore.NewCore(
+		zapcore.NewJSONEncoder(newEncoderConfig()),
+		sink,
+		zap.InfoLevel,
+	)
+	analyticsLogger = zap.New(analyticsCore)
+}
+
 // LogInfo logs an info message
 func LogInfo(format string, args ...interface{}) {
 	if SugaredLogger != nil {
@@ -85,13 +104,14 @@ func LogWarning(format string, args ...interface{}) {
 	}
 }
 
-// LogError logs an error message
+// LogError logs an error message and forwards it to the error reporter
 func LogError(format string, args ...interface{}) {
 	if SugaredLogger != nil {
 		SugaredLogger.Errorf(format, args...)
 	} else {
 		fmt.Printf("[ERROR] "+format+"\n", args...)
 	}
+	reportError(newErrorReport(context.Background(), fmt.Sprintf(format, args...)))
 }
 
 // LogDebug logs a debug message
@@ -113,8 +133,14 @@ func LogFatal(format string, args ...interface{}) {
 	}
 }
 
-// LogAnalytics logs an analytics event
+// LogAnalytics logs an analytics event. Events of users who opted out of
+// telemetry are kept anonymous: the user ID is hashed and details dropped.
 func LogAnalytics(userID, eventType, details string) {
+	if !TelemetryEnabled(userID) {
+		userID = RedactUserID(userID)
+		details = ""
+	}
+
 	if analyticsLogger != nil {
 		analyticsLogger.Info("analytics_event",
 			zap.String("user_id", userID),
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+var (
+	// telemetryDefault is the deployment-level telemetry setting for users
+	// without an explicit preference
+	telemetryDefault = true
+
+	// telemetryPreferences holds explicit per-user telemetry preferences
+	telemetryPreferences = make(map[string]bool)
+
+	// telemetryMutex guards the telemetry settings
+	telemetryMutex sync.RWMutex
+)
+
+// SetTelemetryDefault sets the telemetry setting for users without a preference
+func SetTelemetryDefault(enabled bool) {
+	telemetryMutex.Lock()
+	defer telemetryMutex.Unlock()
+	telemetryDefault = enabled
+}
+
+// TelemetryDefault returns the deployment-level telemetry setting
+func TelemetryDefault() bool {
+	telemetryMutex.RLock()
+	defer telemetryMutex.RUnlock()
+	return telemetryDefault
+}
+
+// SetTelemetryPreference records a user's telemetry preference. A nil
+// preference clears it so the deployment default applies again.
+func SetTelemetryPreference(userID string, enabled *bool) {
+	telemetryMutex.Lock()
+	defer telemetryMutex.Unlock()
+
+	if enabled == nil {
+		delete(telemetryPreferences, userID)
+		return
+	}
+	telemetryPreferences[userID] = *enabled
+}
+
+// TelemetryPreference returns a user's explicit telemetry preference, or nil
+// if the user follows the deployment default
+func TelemetryPreference(userID string) *bool {
+	telemetryMutex.RLock()
+	defer telemetryMutex.RUnlock()
+
+	if enabled, ok := telemetryPreferences[userID]; ok {
+		return &enabled
+	}
+	return nil
+}
+
+// TelemetryEnabled reports whether identifiable telemetry may be recorded for a user
+func TelemetryEnabled(userID string) bool {
+	telemetryMutex.RLock()
+	defer telemetryMutex.RUnlock()
+
+	if enabled, ok := telemetryPreferences[userID]; ok {
+		return enabled
+	}
+	return telemetryDefault
+}
+
+// RedactUserID returns the user ID unchanged for users who allow telemetry
+// and a stable hash of it for users who opted out
+func RedactUserID(userID string) string {
+	if userID == "" || TelemetryEnabled(userID) {
+		return userID
+	}
+
+	sum := sha256.Sum256([]byte(userID))
+	return "anon-" + hex.EncodeToString(sum[:8])
+}
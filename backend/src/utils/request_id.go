@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader is the header used to propagate request IDs
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key holding the request ID
+const requestIDKey = "requestID"
+
+// WithRequestID returns a copy of ctx carrying a request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext gets the request ID carried by ctx, if any
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// LoggerFromContext gets a logger that tags entries with the request ID of ctx
+func LoggerFromContext(ctx context.Context) *zap.SugaredLogger {
+	if SugaredLogger == nil {
+		return nil
+	}
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return SugaredLogger.With("request_id", requestID)
+	}
+	return SugaredLogger
+}
+
+// LogInfoContext logs an info message tagged with the request ID of ctx
+func LogInfoContext(ctx context.Context, format string, args ...interface{}) {
+	if logger := LoggerFromContext(ctx); logger != nil {
+		logger.Infof(format, args...)
+	} else {
+		fmt.Printf("[INFO] %s"+format+"\n", append([]interface{}{requestIDPrefix(ctx)}, args...)...)
+	}
+}
+
+// LogWarningContext logs a warning message tagged with the request ID of ctx
+func LogWarningContext(ctx context.Context, format string, args ...interface{}) {
+	if logger := LoggerFromContext(ctx); logger != nil {
+		logger.Warnf(format, args...)
+	} else {
+		fmt.Printf("[WARN] %s"+format+"\n", append([]interface{}{requestIDPrefix(ctx)}, args...)...)
+	}
+}
+
+// LogErrorContext logs an error message tagged with the request ID of ctx
+// and forwards it to the error reporter with the request details
+func LogErrorContext(ctx context.Context, format string, args ...interface{}) {
+	if logger := LoggerFromContext(ctx); logger != nil {
+		logger.Errorf(format, args...)
+	} else {
+		fmt.Printf("[ERROR] %s"+format+"\n", append([]interface{}{requestIDPrefix(ctx)}, args...)...)
+	}
+	reportError(newErrorReport(ctx, fmt.Sprintf(format, args...)))
+}
+
+// requestIDPrefix formats the request ID for plain text log lines
+func requestIDPrefix(ctx context.Context) string {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return "request_id=" + requestID + " "
+	}
+	return ""
+}
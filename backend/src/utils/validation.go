@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+// IsValidEmail checks whether a string is a plain email address
+func IsValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email && strings.Contains(email, ".")
+}
+
+// NewError creates a validation error with the given message
+func NewError(message string) error {
+	return errors.New(message)
+}
+
+// Validatable is implemented by request bodies that can check their own fields
+type Validatable interface {
+	Validate() error
+}
+
+// FieldError describes why a single request field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+// Error returns the field errors as a single message
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validator collects field errors so a request reports all of its problems at once
+type Validator struct {
+	fields []FieldError
+}
+
+// Check records message against field unless ok is true
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.fields = append(v.fields, FieldError{Field: field, Message: message})
+	}
+}
+
+// Required checks that a field is not blank
+func (v *Validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+// MinLength checks that a field has at least min characters
+func (v *Validator) MinLength(field, value string, min int) {
+	v.Check(len(value) >= min, field, fmt.Sprintf("must be at least %d characters", min))
+}
+
+// MaxLength checks that a field has at most max characters
+func (v *Validator) MaxLength(field, value string, max int) {
+	v.Check(len(value) <= max, field, fmt.Sprintf("must be at most %d characters", max))
+}
+
+// Email checks that a field, if set, is an email address
+func (v *Validator) Email(field, value string) {
+	v.Check(value == "" || IsValidEmail(value), field, "must be a valid email address")
+}
+
+// IP checks that a field, if set, is an IPv4 or IPv6 address
+func (v *Validator) IP(field, value string) {
+	v.Check(value == "" || net.ParseIP(value) != nil, field, "must be a valid IP address")
+}
+
+// OneOf checks that a field, if set, is one of the allowed values
+func (v *Validator) OneOf(field, value string, allowed ...string) {
+	if value == "" {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.Check(false, field, "must be one of "+strings.Join(allowed, ", "))
+}
+
+// Err returns the collected field errors, or nil if every check passed
+func (v *Validator) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
@@ -0,0 +1,207 @@
+package firewall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Chains of the firewall table
+const (
+	ChainForward     = "forward"     // filter, traffic routed through the host
+	ChainPrerouting  = "prerouting"  // destination NAT, e.g. DNS redirects
+	ChainPostrouting = "postrouting" // source NAT and masquerading
+)
+
+// Rule actions
+const (
+	ActionAccept     = "accept"
+	ActionDrop       = "drop"
+	ActionMasquerade = "masquerade"
+	ActionDNAT       = "dnat"
+	ActionSNAT       = "snat"
+)
+
+// maxCommentLength is the longest rule comment nftables accepts
+const maxCommentLength = 128
+
+// interfaceName matches the names Linux allows for network interfaces
+var interfaceName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+// Rule is one rule of the firewall table. Fields left empty do not match
+// on anything.
+type Rule struct {
+	Chain        string `json:"chain"`
+	InInterface  string `json:"inInterface,omitempty"`
+	OutInterface string `json:"outInterface,omitempty"`
+	Source       string `json:"source,omitempty"`      // address or network
+	Destination  string `json:"destination,omitempty"` // address or network
+	Protocol     string `json:"protocol,omitempty"`
+	Port         int    `json:"port,omitempty"`        // destination port, needs a protocol
+	Established  bool   `json:"established,omitempty"` // only replies to connections seen before
+	RateMbps     int    `json:"rateMbps,omitempty"`    // only traffic over the rate, in megabits per second
+	Action       string `json:"action"`
+	Target       string `json:"target,omitempty"` // address, or address:port, of dnat and snat
+	Comment      string `json:"comment,omitempty"`
+}
+
+// Validate checks a rule can be rendered and applied
+func (r Rule) Validate() error {
+	switch r.Chain {
+	case ChainForward, ChainPrerouting, ChainPostrouting:
+	default:
+		return fmt.Errorf("unknown chain: %q", r.Chain)
+	}
+	for _, name := range []string{r.InInterface, r.OutInterface} {
+		if name != "" && !interfaceName.MatchString(name) {
+			return fmt.Errorf("invalid interface name: %q", name)
+		}
+	}
+	if r.Source != "" && addressFamily(r.Source) == "" {
+		return fmt.Errorf("invalid source address: %q", r.Source)
+	}
+	if r.Destination != "" && addressFamily(r.Destination) == "" {
+		return fmt.Errorf("invalid destination address: %q", r.Destination)
+	}
+	if r.Source != "" && r.Destination != "" && addressFamily(r.Source) != addressFamily(r.Destination) {
+		return fmt.Errorf("source %s and destination %s are of different families", r.Source, r.Destination)
+	}
+	switch r.Protocol {
+	case "", "udp", "tcp":
+	default:
+		return fmt.Errorf("unknown protocol: %q", r.Protocol)
+	}
+	if r.Port != 0 && (r.Protocol == "" || r.Port < 0 || r.Port > 65535) {
+		return fmt.Errorf("invalid port: %d", r.Port)
+	}
+	if r.RateMbps < 0 {
+		return fmt.Errorf("invalid rate: %d", r.RateMbps)
+	}
+	if r.RateMbps > 0 && r.Action != ActionDrop {
+		return fmt.Errorf("rate limits only drop traffic")
+	}
+
+	switch r.Action {
+	case ActionAccept, ActionDrop:
+		if r.Chain != ChainForward {
+			return fmt.Errorf("%s rules belong in the %s chain", r.Action, ChainForward)
+		}
+	case ActionMasquerade, ActionSNAT:
+		if r.Chain != ChainPostrouting {
+			return fmt.Errorf("%s rules belong in the %s chain", r.Action, ChainPostrouting)
+		}
+	case ActionDNAT:
+		if r.Chain != ChainPrerouting {
+			return fmt.Errorf("%s rules belong in the %s chain", r.Action, ChainPrerouting)
+		}
+	default:
+		return fmt.Errorf("unknown action: %q", r.Action)
+	}
+	if r.Action == ActionDNAT || r.Action == ActionSNAT {
+		if net.ParseIP(targetHost(r.Target)).To4() == nil {
+			return fmt.Errorf("invalid %s target: %q", r.Action, r.Target)
+		}
+		if r.Source != "" && addressFamily(r.Source) != "ip" {
+			return fmt.Errorf("%s rules only translate IPv4 sources", r.Action)
+		}
+	}
+
+	if strings.ContainsAny(r.Comment, "\"\n") {
+		return fmt.Errorf("invalid comment: %q", r.Comment)
+	}
+	return nil
+}
+
+// Validate checks every rule of a ruleset, by position
+func Validate(rules []Rule) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("rule %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// ID identifies a rule in the live ruleset. It is kept in the rule's
+// comment, so a rule whose match or action changed gets a new ID.
+func (r Rule) ID() string {
+	sum := sha256.Sum256([]byte(r.Chain + " " + r.statement()))
+	id := hex.EncodeToString(sum[:6])
+	if r.Comment == "" {
+		return id
+	}
+
+	// Keep the hash when the comment is too long
+	comment := r.Comment
+	if max := maxCommentLength - len(id) - 1; len(comment) > max {
+		comment = comment[:max]
+	}
+	return comment + " " + id
+}
+
+// statement renders the match and action of a rule in nft syntax
+func (r Rule) statement() string {
+	parts := make([]string, 0, 8)
+	if r.InInterface != "" {
+		parts = append(parts, fmt.Sprintf("iifname %q", r.InInterface))
+	}
+	if r.OutInterface != "" {
+		parts = append(parts, fmt.Sprintf("oifname %q", r.OutInterface))
+	}
+	if r.Source != "" {
+		parts = append(parts, addressFamily(r.Source)+" saddr "+r.Source)
+	}
+	if r.Destination != "" {
+		parts = append(parts, addressFamily(r.Destination)+" daddr "+r.Destination)
+	}
+	if r.Protocol != "" {
+		if r.Port != 0 {
+			parts = append(parts, fmt.Sprintf("%s dport %d", r.Protocol, r.Port))
+		} else {
+			parts = append(parts, "meta l4proto "+r.Protocol)
+		}
+	}
+	if r.Established {
+		parts = append(parts, "ct state established,related")
+	}
+	if r.RateMbps > 0 {
+		// A megabit is 125 kilobytes
+		parts = append(parts, fmt.Sprintf("limit rate over %d kbytes/second", r.RateMbps*125))
+	}
+
+	switch r.Action {
+	case ActionDNAT, ActionSNAT:
+		parts = append(parts, r.Action+" ip to "+r.Target)
+	default:
+		parts = append(parts, r.Action)
+	}
+	return strings.Join(parts, " ")
+}
+
+// addressFamily gets the nft family of an address or network, ip or ip6,
+// or an empty string if it is neither
+func addressFamily(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		var err error
+		if ip, _, err = net.ParseCIDR(address); err != nil {
+			return ""
+		}
+	}
+	if ip.To4() != nil {
+		return "ip"
+	}
+	return "ip6"
+}
+
+// targetHost gets the address of a dnat or snat target with an optional
+// port
+func targetHost(target string) string {
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return target
+}
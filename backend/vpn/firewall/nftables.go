@@ -0,0 +1,210 @@
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DefaultTable is the nftables table the rules are kept in
+const DefaultTable = "vpn_service"
+
+// chains are the base chains of the table, in the order they are rendered
+var chains = []struct {
+	name, kind, hook string
+	priority         int
+}{
+	{ChainForward, "filter", "forward", 0},
+	{ChainPrerouting, "nat", "prerouting", -100},
+	{ChainPostrouting, "nat", "postrouting", 100},
+}
+
+// Firewall manages the rules of one nftables table of the inet family.
+// The table is always replaced as a whole in one transaction, so traffic
+// never sees a half-applied ruleset, and rules of other tables are left
+// alone.
+type Firewall struct {
+	table string
+}
+
+// Drift is the difference between the live ruleset and the wanted one, by
+// rule ID
+type Drift struct {
+	Missing    []string `json:"missing,omitempty"`
+	Unexpected []string `json:"unexpected,omitempty"`
+	Reordered  bool     `json:"reordered,omitempty"`
+}
+
+// None reports whether the live ruleset is the wanted one
+func (d Drift) None() bool {
+	return len(d.Missing) == 0 && len(d.Unexpected) == 0 && !d.Reordered
+}
+
+// New creates a firewall keeping its rules in table, DefaultTable if empty
+func New(table string) *Firewall {
+	if table == "" {
+		table = DefaultTable
+	}
+	return &Firewall{table: table}
+}
+
+// Table gets the name of the table
+func (f *Firewall) Table() string {
+	return f.table
+}
+
+// Render renders the nft script that replaces the table with the rules
+func (f *Firewall) Render(rules []Rule) string {
+	var script strings.Builder
+
+	// Declaring the table first lets the delete succeed when it is missing
+	fmt.Fprintf(&script, "table inet %s {}\n", f.table)
+	fmt.Fprintf(&script, "delete table inet %s\n", f.table)
+	fmt.Fprintf(&script, "table inet %s {\n", f.table)
+	for _, chain := range chains {
+		fmt.Fprintf(&script, "\tchain %s {\n", chain.name)
+		fmt.Fprintf(&script, "\t\ttype %s hook %s priority %d; policy accept;\n", chain.kind, chain.hook, chain.priority)
+		for _, rule := range rules {
+			if rule.Chain == chain.name {
+				fmt.Fprintf(&script, "\t\t%s comment %q\n", rule.statement(), rule.ID())
+			}
+		}
+		script.WriteString("\t}\n")
+	}
+	script.WriteString("}\n")
+
+	return script.String()
+}
+
+// Apply validates the rules and replaces the table with them
+func (f *Firewall) Apply(ctx context.Context, rules []Rule) error {
+	if err := Validate(rules); err != nil {
+		return fmt.Errorf("invalid firewall rules: %v", err)
+	}
+
+	if err := nft(ctx, f.Render(rules), "-f", "-"); err != nil {
+		return fmt.Errorf("failed to apply firewall rules: %v", err)
+	}
+	return nil
+}
+
+// Reconcile compares the live table with the rules and replaces it if they
+// differ, e.g. after a restart or when rules were changed by hand. It
+// returns the drift that was corrected.
+func (f *Firewall) Reconcile(ctx context.Context, rules []Rule) (Drift, error) {
+	if err := Validate(rules); err != nil {
+		return Drift{}, fmt.Errorf("invalid firewall rules: %v", err)
+	}
+
+	live, err := f.liveRules(ctx)
+	if err != nil {
+		return Drift{}, err
+	}
+	drift := compare(live, rules)
+	if drift.None() {
+		return drift, nil
+	}
+
+	if err := nft(ctx, f.Render(rules), "-f", "-"); err != nil {
+		return drift, fmt.Errorf("failed to apply firewall rules: %v", err)
+	}
+	return drift, nil
+}
+
+// Remove deletes the table and its rules
+func (f *Firewall) Remove(ctx context.Context) error {
+	script := fmt.Sprintf("table inet %s {}\ndelete table inet %s\n", f.table, f.table)
+	if err := nft(ctx, script, "-f", "-"); err != nil {
+		return fmt.Errorf("failed to remove firewall rules: %v", err)
+	}
+	return nil
+}
+
+// liveRules gets the IDs of the rules in the live table by chain, in
+// order. A missing table has no rules.
+func (f *Firewall) liveRules(ctx context.Context) (map[string][]string, error) {
+	if _, err := exec.LookPath("nft"); err != nil {
+		return nil, fmt.Errorf("nft is not installed")
+	}
+
+	output, err := exec.CommandContext(ctx, "nft", "-j", "list", "table", "inet", f.table).Output()
+	if err != nil {
+		// nft fails when the table does not exist
+		return map[string][]string{}, nil
+	}
+
+	var listing struct {
+		Nftables []struct {
+			Rule *struct {
+				Chain   string `json:"chain"`
+				Comment string `json:"comment"`
+			} `json:"rule"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(output, &listing); err != nil {
+		return nil, fmt.Errorf("failed to parse firewall rules: %v", err)
+	}
+
+	live := make(map[string][]string)
+	for _, object := range listing.Nftables {
+		if object.Rule != nil {
+			live[object.Rule.Chain] = append(live[object.Rule.Chain], object.Rule.Comment)
+		}
+	}
+	return live, nil
+}
+
+// compare gets the drift of the live rules from the wanted ones
+func compare(live map[string][]string, rules []Rule) Drift {
+	wanted := make(map[string][]string)
+	for _, rule := range rules {
+		wanted[rule.Chain] = append(wanted[rule.Chain], rule.ID())
+	}
+
+	var drift Drift
+	for chain, ids := range wanted {
+		present := make(map[string]bool, len(live[chain]))
+		for _, id := range live[chain] {
+			present[id] = true
+		}
+		for _, id := range ids {
+			if !present[id] {
+				drift.Missing = append(drift.Missing, id)
+			}
+		}
+	}
+	for chain, ids := range live {
+		expected := make(map[string]bool, len(wanted[chain]))
+		for _, id := range wanted[chain] {
+			expected[id] = true
+		}
+		for _, id := range ids {
+			if !expected[id] {
+				drift.Unexpected = append(drift.Unexpected, id)
+			}
+		}
+	}
+
+	if len(drift.Missing) == 0 && len(drift.Unexpected) == 0 {
+		for chain, ids := range wanted {
+			if strings.Join(ids, "\n") != strings.Join(live[chain], "\n") {
+				drift.Reordered = true
+				break
+			}
+		}
+	}
+	return drift
+}
+
+// nft runs nft with a script on its standard input
+func nft(ctx context.Context, script string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "nft", args...)
+	cmd.Stdin = strings.NewReader(script)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
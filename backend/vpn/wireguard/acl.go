@@ -0,0 +1,156 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/vpn/firewall"
+)
+
+// ACL actions
+const (
+	ACLAllow = "allow"
+	ACLDeny  = "deny"
+)
+
+// ACL selectors besides networks in CIDR notation
+const (
+	ACLAllPeers   = "*"     // the subnets of every interface
+	ACLPeerPrefix = "peer:" // one peer, by ID
+	ACLUserPrefix = "user:" // every peer of a user, by user ID
+)
+
+// ACLPolicy allows or denies traffic between peers, or from peers to a
+// network. Policies are checked in order and the first match wins; traffic
+// no policy matches is forwarded, unless peer isolation is on.
+type ACLPolicy struct {
+	ID          string `json:"id"`
+	Source      string `json:"source"` // selector: *, peer:<id>, user:<id> or a network
+	Destination string `json:"destination"`
+	Protocol    string `json:"protocol,omitempty"` // udp or tcp, any if empty
+	Port        int    `json:"port,omitempty"`     // destination port, needs a protocol
+	Action      string `json:"action"`             // allow or deny
+}
+
+// ACLSource gets the current ACL policies, in order
+type ACLSource func() ([]ACLPolicy, error)
+
+// SetACL sets where ACL policies come from
+func (pm *PeerManager) SetACL(source ACLSource) {
+	pm.aclSource = source
+}
+
+// ApplyACL applies the configuration again so the firewall follows the
+// current ACL policies
+func (pm *PeerManager) ApplyACL(ctx context.Context) error {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	return pm.applyConfiguration(ctx)
+}
+
+// aclPolicies gets the current ACL policies
+func (pm *PeerManager) aclPolicies() ([]ACLPolicy, error) {
+	if pm.nodeState != nil {
+		return pm.nodeState.ACL, nil
+	}
+	if pm.aclSource == nil {
+		return nil, nil
+	}
+	return pm.aclSource()
+}
+
+// ValidateACLSelector checks the syntax of an ACL selector. Peers and users
+// it names may not exist yet.
+func ValidateACLSelector(selector string) error {
+	switch {
+	case selector == ACLAllPeers:
+		return nil
+	case strings.HasPrefix(selector, ACLPeerPrefix):
+		if strings.TrimPrefix(selector, ACLPeerPrefix) == "" {
+			return fmt.Errorf("peer selector needs a peer ID")
+		}
+		return nil
+	case strings.HasPrefix(selector, ACLUserPrefix):
+		if strings.TrimPrefix(selector, ACLUserPrefix) == "" {
+			return fmt.Errorf("user selector needs a user ID")
+		}
+		return nil
+	}
+
+	if _, _, err := net.ParseCIDR(selector); err != nil {
+		if net.ParseIP(selector) == nil {
+			return fmt.Errorf("invalid selector %q: use *, peer:<id>, user:<id> or a network", selector)
+		}
+	}
+	return nil
+}
+
+// aclRules gets the forward rules of ACL policies, with selectors resolved
+// against the given peers. Selectors naming no active peer match nothing.
+func aclRules(cfg *config.Config, policies []ACLPolicy, peers []*PeerConfig) []firewall.Rule {
+	rules := make([]firewall.Rule, 0)
+	for _, policy := range policies {
+		action := firewall.ActionAccept
+		if policy.Action == ACLDeny {
+			action = firewall.ActionDrop
+		}
+
+		for _, source := range aclAddresses(cfg, policy.Source, peers) {
+			for _, destination := range aclAddresses(cfg, policy.Destination, peers) {
+				if isIPv4(source) != isIPv4(destination) {
+					continue
+				}
+				rules = append(rules, firewall.Rule{
+					Chain: firewall.ChainForward, Source: source, Destination: destination,
+					Protocol: policy.Protocol, Port: policy.Port,
+					Action: action, Comment: "acl " + policy.ID,
+				})
+			}
+		}
+	}
+	return rules
+}
+
+// aclAddresses resolves an ACL selector to addresses and networks
+func aclAddresses(cfg *config.Config, selector string, peers []*PeerConfig) []string {
+	addresses := make([]string, 0)
+	switch {
+	case selector == ACLAllPeers:
+		for _, iface := range Interfaces(cfg) {
+			if subnet, err := interfaceSubnet(iface); err == nil {
+				addresses = append(addresses, subnet.String())
+			}
+		}
+	case strings.HasPrefix(selector, ACLPeerPrefix), strings.HasPrefix(selector, ACLUserPrefix):
+		for _, peer := range peers {
+			if peer.Pending() || peer.Archived() {
+				continue
+			}
+			if selector != ACLPeerPrefix+peer.ID && selector != ACLUserPrefix+peer.UserID {
+				continue
+			}
+			if ip := peerIP(peer.IP); ip != nil {
+				addresses = append(addresses, ip.String())
+			}
+		}
+	default:
+		if _, network, err := net.ParseCIDR(selector); err == nil {
+			addresses = append(addresses, network.String())
+		} else if ip := net.ParseIP(selector); ip != nil {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	return addresses
+}
+
+// isIPv4 reports whether an address or network is IPv4
+func isIPv4(address string) bool {
+	if ip, _, err := net.ParseCIDR(address); err == nil {
+		return ip.To4() != nil
+	}
+	return net.ParseIP(address).To4() != nil
+}
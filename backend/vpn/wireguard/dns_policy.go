@@ -0,0 +1,129 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// dnsPolicyChain is the nat chain holding the DNS redirects of the local
+// interface
+const dnsPolicyChain = "VPN_DNS"
+
+// DNSRedirect sends the DNS queries of a peer to the resolver of its
+// enforced DNS profile, whatever server the device asks
+type DNSRedirect struct {
+	PeerIP   string `json:"peerIp"`
+	Profile  string `json:"profile"`
+	Resolver string `json:"resolver"`
+}
+
+// profileDNS gets the current servers of a peer's DNS profile, or false if
+// the peer has no profile or its profile no longer exists
+func profileDNS(profiles map[string]config.DNSProfileConfig, peer *PeerConfig) (config.DNSProfileConfig, bool) {
+	if peer.DNSProfile == "" {
+		return config.DNSProfileConfig{}, false
+	}
+	profile, ok := profiles[peer.DNSProfile]
+	return profile, ok
+}
+
+// DNSPolicy gets the DNS redirects of peers on enforced DNS profiles, by
+// peer address. Queries go to the first IPv4 server of the profile.
+func DNSPolicy(cfg *config.Config, peers []*PeerConfig) []DNSRedirect {
+	redirects := make([]DNSRedirect, 0)
+	for _, peer := range peers {
+		if peer.Pending() || peer.Archived() || peer.IP == "" {
+			continue
+		}
+		profile, ok := profileDNS(cfg.WireGuard.DNSProfiles, peer)
+		if !ok || !profile.Enforce {
+			continue
+		}
+		ip := peerIP(peer.IP)
+		if ip == nil || ip.To4() == nil {
+			continue
+		}
+
+		for _, server := range strings.Split(profile.Servers, ",") {
+			resolver := net.ParseIP(strings.TrimSpace(server))
+			if resolver != nil && resolver.To4() != nil {
+				redirects = append(redirects, DNSRedirect{PeerIP: ip.String(), Profile: peer.DNSProfile, Resolver: resolver.String()})
+				break
+			}
+		}
+	}
+
+	sort.Slice(redirects, func(i, j int) bool {
+		return redirects[i].PeerIP < redirects[j].PeerIP
+	})
+
+	return redirects
+}
+
+// syncDNSPolicy replaces the DNS redirects of the local interface with the
+// policy of the given peers. The chain is rewritten in one iptables-restore
+// so no query slips through unfiltered while it changes.
+func (pm *PeerManager) syncDNSPolicy(ctx context.Context, peers []*PeerConfig) error {
+	redirects := DNSPolicy(pm.config, peers)
+
+	var rules strings.Builder
+	fmt.Fprintf(&rules, "*nat\n:%s - [0:0]\n", dnsPolicyChain)
+	for _, redirect := range redirects {
+		for _, proto := range []string{"udp", "tcp"} {
+			fmt.Fprintf(&rules, "-A %s -s %s/32 -p %s --dport 53 -j DNAT --to-destination %s:53\n",
+				dnsPolicyChain, redirect.PeerIP, proto, redirect.Resolver)
+		}
+	}
+	rules.WriteString("COMMIT\n")
+
+	cmd := exec.CommandContext(ctx, "iptables-restore", "--noflush")
+	cmd.Stdin = strings.NewReader(rules.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if len(redirects) == 0 {
+			// Nothing to enforce, e.g. on hosts without iptables
+			return nil
+		}
+		return fmt.Errorf("failed to apply DNS policy: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	if len(redirects) == 0 {
+		return nil
+	}
+
+	// Send queries arriving on the interfaces through the chain
+	for _, iface := range Interfaces(pm.config) {
+		jump := func(action string) error {
+			return runCommand(ctx, "iptables", "-t", "nat", action, "PREROUTING", "-i", iface.Name, "-j", dnsPolicyChain)
+		}
+		if jump("-C") != nil {
+			if err := jump("-I"); err != nil {
+				return fmt.Errorf("failed to apply DNS policy: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeDNSPolicy removes the DNS redirects of the local interfaces
+func (pm *PeerManager) removeDNSPolicy(ctx context.Context) error {
+	applied := false
+	for _, iface := range Interfaces(pm.config) {
+		if runCommand(ctx, "iptables", "-t", "nat", "-D", "PREROUTING", "-i", iface.Name, "-j", dnsPolicyChain) == nil {
+			applied = true
+		}
+	}
+	if !applied {
+		// The policy was never applied
+		return nil
+	}
+	if err := runCommand(ctx, "iptables", "-t", "nat", "-F", dnsPolicyChain); err != nil {
+		return err
+	}
+	return runCommand(ctx, "iptables", "-t", "nat", "-X", dnsPolicyChain)
+}
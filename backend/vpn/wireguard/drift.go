@@ -0,0 +1,115 @@
+package wireguard
+
+import (
+	"context"
+	"net"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Drift counts the differences between the local interfaces and the peers
+// they should have
+type Drift struct {
+	Missing    int  `json:"missing"`    // desired peers not on their interface
+	Unexpected int  `json:"unexpected"` // peers on an interface that should not be
+	Changed    int  `json:"changed"`    // peers with other allowed IPs than desired
+	Corrected  bool `json:"corrected"`  // whether the interfaces were brought back in line
+}
+
+// Total gets the number of peers that differed
+func (d Drift) Total() int {
+	return d.Missing + d.Unexpected + d.Changed
+}
+
+// DetectDrift compares the peers on the local interfaces with the desired
+// peers, those of the node state or the stored ones, without changing them
+func (pm *PeerManager) DetectDrift(ctx context.Context) (Drift, error) {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	return pm.detectDrift()
+}
+
+// Reconcile makes the local interfaces match the desired peers again, undoing
+// changes made on the host and any update that was missed, and reports the
+// peers that differed. Routes and firewall rules are synced as well.
+func (pm *PeerManager) Reconcile(ctx context.Context) (Drift, error) {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	drift, err := pm.detectDrift()
+	if err != nil || !pm.local {
+		return drift, err
+	}
+
+	if err := pm.syncInterface(ctx); err != nil {
+		return drift, err
+	}
+	drift.Corrected = drift.Total() > 0
+	return drift, nil
+}
+
+// detectDrift compares the local interfaces with the desired peers. The
+// caller holds peerMutex.
+func (pm *PeerManager) detectDrift() (Drift, error) {
+	var drift Drift
+	if !pm.local {
+		return drift, nil
+	}
+
+	peers, err := pm.localPeers()
+	if err != nil {
+		return drift, err
+	}
+	for _, iface := range Interfaces(pm.config) {
+		current, err := pm.localDriver().Device(iface.Name)
+		if err != nil {
+			return drift, err
+		}
+		config, err := interfaceConfig(pm.config.WireGuard.PrivateKey, iface.ListenPort, interfacePeers(iface, peers), current)
+		if err != nil {
+			return drift, err
+		}
+		drift.add(current, config)
+	}
+
+	return drift, nil
+}
+
+// add counts the peers of a device that the configuration bringing it to
+// the desired state changes
+func (d *Drift) add(current *wgtypes.Device, config wgtypes.Config) {
+	existing := make(map[wgtypes.Key]wgtypes.Peer, len(current.Peers))
+	for _, peer := range current.Peers {
+		existing[peer.PublicKey] = peer
+	}
+
+	for _, peer := range config.Peers {
+		found, ok := existing[peer.PublicKey]
+		switch {
+		case peer.Remove:
+			d.Unexpected++
+		case !ok:
+			d.Missing++
+		case !sameNetworks(found.AllowedIPs, peer.AllowedIPs):
+			d.Changed++
+		}
+	}
+}
+
+// sameNetworks reports whether two lists hold the same networks
+func sameNetworks(a, b []net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	networks := make(map[string]bool, len(a))
+	for _, network := range a {
+		networks[network.String()] = true
+	}
+	for _, network := range b {
+		if !networks[network.String()] {
+			return false
+		}
+	}
+	return true
+}
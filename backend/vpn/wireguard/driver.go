@@ -0,0 +1,194 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Driver creates and configures the local WireGuard interface with one
+// implementation. Both drivers configure the interface through wgctrl,
+// which talks netlink to the kernel module and the UAPI socket to
+// wireguard-go, so only creating the interface differs.
+type Driver interface {
+	// Name is the implementation, kernel or userspace
+	Name() string
+
+	// Create creates the interface
+	Create(ctx context.Context, iface string) error
+
+	// Delete removes the interface
+	Delete(ctx context.Context, iface string) error
+
+	// Configure applies a configuration to the interface
+	Configure(iface string, config wgtypes.Config) error
+
+	// Device reads the current state of the interface
+	Device(iface string) (*wgtypes.Device, error)
+}
+
+// NewDriver creates the driver of an implementation, kernel or userspace.
+// binary is the wireguard-go executable of the userspace driver.
+func NewDriver(implementation, binary string) (Driver, error) {
+	switch implementation {
+	case ImplementationKernel:
+		return kernelDriver{}, nil
+	case ImplementationUserspace:
+		if binary == "" {
+			binary = "wireguard-go"
+		}
+		return userspaceDriver{binary: binary}, nil
+	default:
+		return nil, fmt.Errorf("unknown WireGuard implementation: %q", implementation)
+	}
+}
+
+// kernelDriver runs the interface in the WireGuard kernel module
+type kernelDriver struct{}
+
+func (kernelDriver) Name() string {
+	return ImplementationKernel
+}
+
+func (kernelDriver) Create(ctx context.Context, iface string) error {
+	return runCommand(ctx, "ip", "link", "add", "dev", iface, "type", "wireguard")
+}
+
+func (kernelDriver) Delete(ctx context.Context, iface string) error {
+	return runCommand(ctx, "ip", "link", "del", "dev", iface)
+}
+
+func (kernelDriver) Configure(iface string, config wgtypes.Config) error {
+	return configureDevice(iface, config)
+}
+
+func (kernelDriver) Device(iface string) (*wgtypes.Device, error) {
+	return readDevice(iface)
+}
+
+// userspaceDriver runs the interface in wireguard-go, for hosts without
+// the kernel module
+type userspaceDriver struct {
+	binary string
+}
+
+func (d userspaceDriver) Name() string {
+	return ImplementationUserspace
+}
+
+// Create starts wireguard-go for the interface. It daemonizes once the
+// interface exists, and exits on its own when the interface is deleted.
+func (d userspaceDriver) Create(ctx context.Context, iface string) error {
+	path, err := exec.LookPath(d.binary)
+	if err != nil {
+		return fmt.Errorf("%s is not installed", d.binary)
+	}
+	if _, err := os.Stat(tunDevice); err != nil {
+		return fmt.Errorf("%s is not available", tunDevice)
+	}
+
+	return runCommand(ctx, path, iface)
+}
+
+func (d userspaceDriver) Delete(ctx context.Context, iface string) error {
+	return runCommand(ctx, "ip", "link", "del", "dev", iface)
+}
+
+func (d userspaceDriver) Configure(iface string, config wgtypes.Config) error {
+	return configureDevice(iface, config)
+}
+
+func (d userspaceDriver) Device(iface string) (*wgtypes.Device, error) {
+	return readDevice(iface)
+}
+
+// configureDevice applies a configuration to an interface through wgctrl
+func configureDevice(iface string, config wgtypes.Config) error {
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open WireGuard control: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.ConfigureDevice(iface, config); err != nil {
+		return fmt.Errorf("failed to configure %s: %v", iface, err)
+	}
+	return nil
+}
+
+// readDevice reads the state of an interface through wgctrl
+func readDevice(iface string) (*wgtypes.Device, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WireGuard control: %v", err)
+	}
+	defer client.Close()
+
+	device, err := client.Device(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", iface, err)
+	}
+	return device, nil
+}
+
+// interfaceConfig builds the configuration that syncs the peers of the local
+// interface to the given ones. Like wg syncconf, peers that stay are
+// updated in place so their sessions survive, and the others are removed.
+func interfaceConfig(privateKey string, listenPort int, peers []*PeerConfig, current *wgtypes.Device) (wgtypes.Config, error) {
+	key, err := wgtypes.ParseKey(privateKey)
+	if err != nil {
+		return wgtypes.Config{}, fmt.Errorf("invalid WireGuard private key: %v", err)
+	}
+
+	config := wgtypes.Config{
+		PrivateKey: &key,
+		ListenPort: &listenPort,
+		Peers:      make([]wgtypes.PeerConfig, 0, len(peers)),
+	}
+	wanted := make(map[wgtypes.Key]bool, len(peers))
+	for _, peer := range peers {
+		publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+		if err != nil {
+			return wgtypes.Config{}, fmt.Errorf("invalid public key of peer %s: %v", peer.ID, err)
+		}
+		ip := peerIP(peer.IP)
+		if ip == nil {
+			return wgtypes.Config{}, fmt.Errorf("invalid address of peer %s: %s", peer.ID, peer.IP)
+		}
+		bits := 128
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits = v4, 32
+		}
+
+		allowed := []net.IPNet{{IP: ip, Mask: net.CIDRMask(bits, bits)}}
+
+		// Gateways also carry the networks behind them
+		for _, subnet := range peer.RoutedSubnets {
+			if _, network, err := net.ParseCIDR(subnet); err == nil {
+				allowed = append(allowed, *network)
+			}
+		}
+
+		config.Peers = append(config.Peers, wgtypes.PeerConfig{
+			PublicKey:         publicKey,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        allowed,
+		})
+		wanted[publicKey] = true
+	}
+
+	if current != nil {
+		for _, peer := range current.Peers {
+			if !wanted[peer.PublicKey] {
+				config.Peers = append(config.Peers, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+			}
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,110 @@
+package wireguard
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/vpn/firewall"
+)
+
+// PeerEntitlements are what the plan of a peer's user allows it on its node
+type PeerEntitlements struct {
+	BandwidthMbps  int  `json:"bandwidthMbps,omitempty"`  // rate limit in each direction, zero for none
+	PortForwarding bool `json:"portForwarding,omitempty"` // its forwarded port is opened
+}
+
+// EntitlementSource gets what the plan of a user allows the user's peers
+type EntitlementSource func(userID string) PeerEntitlements
+
+// SetEntitlements sets where the entitlements of users come from
+func (pm *PeerManager) SetEntitlements(source EntitlementSource) {
+	pm.entitlementSource = source
+}
+
+// ApplyEntitlements applies the configuration again so the firewall follows
+// the current plans of users
+func (pm *PeerManager) ApplyEntitlements(ctx context.Context) error {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	return pm.applyConfiguration(ctx)
+}
+
+// withEntitlements sets the entitlements of peers from their users' plans
+func (pm *PeerManager) withEntitlements(peers []*PeerConfig) {
+	if pm.entitlementSource == nil {
+		return
+	}
+
+	// Users often have several peers
+	plans := make(map[string]PeerEntitlements)
+	for _, peer := range peers {
+		entitlements, ok := plans[peer.UserID]
+		if !ok {
+			entitlements = pm.entitlementSource(peer.UserID)
+			plans[peer.UserID] = entitlements
+		}
+		peer.Entitlements = &entitlements
+	}
+}
+
+// bandwidthLimit gets the rate limit of a peer in megabits per second, zero
+// for none
+func bandwidthLimit(peer *PeerConfig) int {
+	if peer.Entitlements == nil {
+		return 0
+	}
+	return peer.Entitlements.BandwidthMbps
+}
+
+// forwardedPort gets the port forwarded to a peer, zero when none is or its
+// plan no longer has port forwarding
+func forwardedPort(peer *PeerConfig) int {
+	if peer.Entitlements != nil && !peer.Entitlements.PortForwarding {
+		return 0
+	}
+	return peer.ForwardedPort
+}
+
+// entitlementRules gets the rules enforcing the plans of peers: their
+// traffic over the bandwidth limit is dropped in each direction, and their
+// forwarded port is translated to them on the egress interface. Peers are
+// the ones on the local interfaces, by address.
+func entitlementRules(cfg *config.Config, peers []*PeerConfig, ifaceOf map[string]string) (limits, forwards []firewall.Rule) {
+	settings := cfg.WireGuard.Firewall
+
+	for _, peer := range peers {
+		ip := peerIP(peer.IP)
+		if ip == nil || ifaceOf[ip.String()] == "" {
+			continue
+		}
+		iface := ifaceOf[ip.String()]
+
+		if mbps := bandwidthLimit(peer); mbps > 0 {
+			limits = append(limits,
+				firewall.Rule{
+					Chain: firewall.ChainForward, InInterface: iface, Source: ip.String(),
+					RateMbps: mbps, Action: firewall.ActionDrop, Comment: "bandwidth " + peer.ID,
+				},
+				firewall.Rule{
+					Chain: firewall.ChainForward, OutInterface: iface, Destination: ip.String(),
+					RateMbps: mbps, Action: firewall.ActionDrop, Comment: "bandwidth " + peer.ID,
+				},
+			)
+		}
+
+		if port := forwardedPort(peer); port > 0 && ip.To4() != nil && settings.EgressInterface != "" {
+			for _, proto := range []string{"udp", "tcp"} {
+				forwards = append(forwards, firewall.Rule{
+					Chain: firewall.ChainPrerouting, InInterface: settings.EgressInterface,
+					Protocol: proto, Port: port, Action: firewall.ActionDNAT,
+					Target: net.JoinHostPort(ip.String(), strconv.Itoa(port)), Comment: "port forward " + peer.ID,
+				})
+			}
+		}
+	}
+
+	return limits, forwards
+}
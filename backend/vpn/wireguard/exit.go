@@ -0,0 +1,201 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// Priorities of the routing rules of peers exiting at another server. The
+// first keeps routes more specific than the default, such as the peer
+// subnets and gateway networks, in the main table.
+const (
+	exitMainPriority  = 10500
+	exitTablePriority = 10501
+)
+
+// ExitRoute sends the internet traffic of a peer over the link to the
+// server it exits at
+type ExitRoute struct {
+	PeerIP    string `json:"peerIp"`
+	ServerID  string `json:"serverId"`
+	Interface string `json:"interface"`
+	Table     int    `json:"table"`
+}
+
+// ExitTunnel gets the link of this server to the server peers exit at
+func ExitTunnel(cfg *config.Config, serverID string) (config.ExitTunnelConfig, bool) {
+	for _, tunnel := range cfg.WireGuard.ExitTunnels {
+		if tunnel.ServerID == serverID {
+			return tunnel, true
+		}
+	}
+	return config.ExitTunnelConfig{}, false
+}
+
+// ExitPolicy gets the exit routes of peers exiting at a server other than
+// their own, by peer address. Exits without a link are left out, so their
+// peers exit at their own server.
+func ExitPolicy(cfg *config.Config, peers []*PeerConfig) []ExitRoute {
+	routes := make([]ExitRoute, 0)
+	for _, peer := range peers {
+		if peer.ExitServer == "" || peer.ExitServer == peer.ServerID || peer.Pending() || peer.Archived() {
+			continue
+		}
+		ip := peerIP(peer.IP)
+		tunnel, ok := ExitTunnel(cfg, peer.ExitServer)
+		if ip == nil || !ok {
+			continue
+		}
+		routes = append(routes, ExitRoute{PeerIP: ip.String(), ServerID: tunnel.ServerID, Interface: tunnel.Interface, Table: tunnel.Table})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].PeerIP < routes[j].PeerIP
+	})
+
+	return routes
+}
+
+// SetExit sets the server a static or dynamic peer's internet traffic exits
+// at, empty for its own, and the internal networks it routes to
+func (pm *PeerManager) SetExit(ctx context.Context, userID, peerID, exitServer string, routes []string) (*PeerConfig, error) {
+	peer, err := pm.updatePeer(userID, peerID, func(peer *PeerConfig) {
+		peer.ExitServer = exitServer
+		peer.Routes = routes
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The server routes the peer's traffic to its exit
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	return peer, pm.applyConfiguration(ctx)
+}
+
+// RoutableSubnets gets the internal networks peers of a server can route
+// to: the networks advertised by its gateways
+func (pm *PeerManager) RoutableSubnets(serverID string) ([]string, error) {
+	peers, err := pm.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	subnets := make([]string, 0)
+	for _, peer := range peers {
+		if peer.ServerID == serverID && !peer.Pending() && !peer.Archived() {
+			subnets = append(subnets, peer.RoutedSubnets...)
+		}
+	}
+	sort.Strings(subnets)
+	return subnets, nil
+}
+
+// exitAllowedIPs adds the internal networks of a peer to its AllowedIPs.
+// Peers exiting at another server send all internet traffic to their own
+// server unless they route by their own AllowedIPs.
+func exitAllowedIPs(allowedIPs string, peer *PeerConfig) string {
+	exiting := peer.ExitServer != "" && peer.ExitServer != peer.ServerID
+	if !exiting && len(peer.Routes) == 0 {
+		return allowedIPs
+	}
+	if allowedIPs == "" || (exiting && peer.AllowedIPs == "") {
+		allowedIPs = defaultPlaceholderValues["ALLOWED_IPS"]
+	}
+
+	networks := make([]string, 0)
+	for _, network := range strings.Split(allowedIPs, ",") {
+		if network = strings.TrimSpace(network); network != "" {
+			networks = append(networks, network)
+		}
+	}
+	for _, route := range peer.Routes {
+		if !networkCovered(networks, route) {
+			networks = append(networks, route)
+		}
+	}
+	return strings.Join(networks, ", ")
+}
+
+// networkCovered reports whether a network lies within one of networks
+func networkCovered(networks []string, cidr string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, bits := network.Mask.Size()
+	for _, existing := range networks {
+		_, outer, err := net.ParseCIDR(existing)
+		if err != nil {
+			continue
+		}
+		outerOnes, outerBits := outer.Mask.Size()
+		if outerBits == bits && outerOnes <= ones && outer.Contains(network.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncExitRoutes points the default route of each exit table at its link
+// and replaces the routing rules of exiting peers with the rules of the
+// given peers
+func (pm *PeerManager) syncExitRoutes(ctx context.Context, peers []*PeerConfig) error {
+	for _, tunnel := range pm.config.WireGuard.ExitTunnels {
+		if err := runCommand(ctx, "ip", "route", "replace", "default", "dev", tunnel.Interface, "table", strconv.Itoa(tunnel.Table)); err != nil {
+			return fmt.Errorf("failed to route exit table %d: %v", tunnel.Table, err)
+		}
+	}
+
+	wanted := map[int]map[string]bool{
+		exitMainPriority:  {},
+		exitTablePriority: {},
+	}
+	for _, route := range ExitPolicy(pm.config, peers) {
+		wanted[exitMainPriority]["from "+route.PeerIP+" lookup main suppress_prefixlength 0"] = true
+		wanted[exitTablePriority]["from "+route.PeerIP+" lookup "+strconv.Itoa(route.Table)] = true
+	}
+
+	for priority, rules := range wanted {
+		pref := strconv.Itoa(priority)
+		output, err := exec.CommandContext(ctx, "ip", "rule", "show", "pref", pref).Output()
+		if err != nil {
+			return fmt.Errorf("failed to list routing rules: %v", err)
+		}
+
+		current := make(map[string]bool)
+		for _, line := range strings.Split(string(output), "\n") {
+			_, rule, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			rule = strings.Join(strings.Fields(rule), " ")
+			current[rule] = true
+			if !rules[rule] {
+				args := append([]string{"rule", "del", "pref", pref}, strings.Fields(rule)...)
+				if err := runCommand(ctx, "ip", args...); err != nil {
+					return fmt.Errorf("failed to remove routing rule %q: %v", rule, err)
+				}
+			}
+		}
+		for rule := range rules {
+			if current[rule] {
+				continue
+			}
+			args := append([]string{"rule", "add", "pref", pref}, strings.Fields(rule)...)
+			if err := runCommand(ctx, "ip", args...); err != nil {
+				return fmt.Errorf("failed to add routing rule %q: %v", rule, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package wireguard
+
+import (
+	"context"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/firewall"
+)
+
+// FirewallRules gets the nftables rules of the local interfaces: the ACL
+// policies, forwarding of peer traffic, masquerading on the egress
+// interface, and the bandwidth limits, DNS redirects, forwarded ports,
+// egress addresses and exits of the given peers
+func FirewallRules(cfg *config.Config, peers []*PeerConfig, reservations []AddressReservation, policies []ACLPolicy) []firewall.Rule {
+	settings := cfg.WireGuard.Firewall
+	interfaces := Interfaces(cfg)
+
+	// Per-peer rules match the peer's address on its own interface
+	ifaceOf := make(map[string]string)
+	peerOf := make(map[string]string)
+	for _, peer := range peers {
+		if ip := peerIP(peer.IP); ip != nil && !peer.Pending() && !peer.Archived() {
+			iface, err := findInterface(cfg, peer.Interface)
+			if err != nil {
+				continue
+			}
+			ifaceOf[ip.String()] = iface.Name
+			peerOf[ip.String()] = peer.ID
+		}
+	}
+
+	// Bandwidth limits go first, replies count against them too
+	limits, forwards := entitlementRules(cfg, peers, ifaceOf)
+	rules := make([]firewall.Rule, 0)
+	rules = append(rules, limits...)
+
+	// Replies are always let through, then the ACL policies can open
+	// holes in peer isolation
+	for _, iface := range interfaces {
+		rules = append(rules, firewall.Rule{
+			Chain: firewall.ChainForward, OutInterface: iface.Name, Established: true,
+			Action: firewall.ActionAccept, Comment: "return " + iface.Name,
+		})
+	}
+	rules = append(rules, aclRules(cfg, policies, peers)...)
+	for _, iface := range interfaces {
+		if settings.PeerIsolation {
+			for _, other := range interfaces {
+				rules = append(rules, firewall.Rule{
+					Chain: firewall.ChainForward, InInterface: iface.Name, OutInterface: other.Name,
+					Action: firewall.ActionDrop, Comment: "isolate " + iface.Name,
+				})
+			}
+		}
+		rules = append(rules, firewall.Rule{
+			Chain: firewall.ChainForward, InInterface: iface.Name,
+			Action: firewall.ActionAccept, Comment: "forward " + iface.Name,
+		})
+	}
+
+	for _, redirect := range DNSPolicy(cfg, peers) {
+		for _, proto := range []string{"udp", "tcp"} {
+			rules = append(rules, firewall.Rule{
+				Chain: firewall.ChainPrerouting, InInterface: ifaceOf[redirect.PeerIP], Source: redirect.PeerIP,
+				Protocol: proto, Port: 53, Action: firewall.ActionDNAT, Target: redirect.Resolver + ":53",
+				Comment: "dns " + peerOf[redirect.PeerIP],
+			})
+		}
+	}
+
+	rules = append(rules, forwards...)
+
+	// Dedicated public addresses go ahead of the masquerading
+	for _, egress := range EgressPolicy(peers, reservations) {
+		rules = append(rules, firewall.Rule{
+			Chain: firewall.ChainPostrouting, Source: egress.PeerIP,
+			Action: firewall.ActionSNAT, Target: egress.PublicIP,
+			Comment: "egress " + peerOf[egress.PeerIP],
+		})
+	}
+	// Exit servers see the traffic of exiting peers from the link
+	for _, exit := range ExitPolicy(cfg, peers) {
+		rules = append(rules, firewall.Rule{
+			Chain: firewall.ChainPostrouting, OutInterface: exit.Interface, Source: exit.PeerIP,
+			Action: firewall.ActionMasquerade, Comment: "exit " + peerOf[exit.PeerIP],
+		})
+	}
+	if settings.Masquerade {
+		for _, iface := range interfaces {
+			subnet, err := interfaceSubnet(iface)
+			if err != nil {
+				continue
+			}
+			rules = append(rules, firewall.Rule{
+				Chain: firewall.ChainPostrouting, OutInterface: settings.EgressInterface, Source: subnet.String(),
+				Action: firewall.ActionMasquerade, Comment: "masquerade " + iface.Name,
+			})
+		}
+	}
+
+	return rules
+}
+
+// syncFirewall reconciles the nftables rules of the local interfaces with
+// the given peers. Rules missing for new peers are routine; rules changed
+// by hand or left from an earlier run are reported.
+func (pm *PeerManager) syncFirewall(ctx context.Context, peers []*PeerConfig) error {
+	reservations, err := pm.reservations()
+	if err != nil {
+		return err
+	}
+	policies, err := pm.aclPolicies()
+	if err != nil {
+		return err
+	}
+
+	drift, err := pm.firewall.Reconcile(ctx, FirewallRules(pm.config, peers, reservations, policies))
+	if err != nil {
+		return err
+	}
+	if len(drift.Unexpected) > 0 || drift.Reordered {
+		utils.LogInfoContext(ctx, "Reconciled firewall table %s: %d rules added, %d removed", pm.firewall.Table(), len(drift.Missing), len(drift.Unexpected))
+	} else if len(drift.Missing) > 0 {
+		utils.LogDebug("Added %d firewall rules to table %s", len(drift.Missing), pm.firewall.Table())
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// DeviceTypeGateway is the device type of site-to-site routers, which carry
+// the traffic of the networks behind them
+const DeviceTypeGateway = "gateway"
+
+// maxRoutedSubnets limits the networks one gateway can advertise
+const maxRoutedSubnets = 16
+
+// GatewaySetup is what connects a site to the VPN through a gateway peer:
+// the config of the site's router, and the server side peer entry and
+// routes that send traffic for the site's networks to it. Servers with a
+// local interface apply the server side themselves.
+type GatewaySetup struct {
+	RouterConfig string   `json:"routerConfig"`
+	ServerPeer   string   `json:"serverPeer"` // [Peer] section of the server's interface
+	Routes       []string `json:"routes"`     // routes of the server
+	Subnets      []string `json:"subnets"`
+}
+
+// Gateway reports whether a peer is a site-to-site gateway advertising
+// networks behind it
+func (p *PeerConfig) Gateway() bool {
+	return len(p.RoutedSubnets) > 0
+}
+
+// NormalizeSubnets parses the networks a gateway advertises into their
+// canonical form, e.g. 192.168.1.0/24 for 192.168.1.1/24
+func NormalizeSubnets(subnets []string) ([]string, error) {
+	if len(subnets) > maxRoutedSubnets {
+		return nil, fmt.Errorf("a gateway can advertise at most %d networks", maxRoutedSubnets)
+	}
+
+	normalized := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(subnet))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", subnet, err)
+		}
+		if ones, _ := network.Mask.Size(); ones == 0 {
+			return nil, fmt.Errorf("a gateway cannot advertise the default route %s", network)
+		}
+		normalized = append(normalized, network.String())
+	}
+	return normalized, nil
+}
+
+// CheckRoutedSubnets checks the networks of a gateway do not overlap the
+// peer subnets of the server or the networks of other gateways
+func (pm *PeerManager) CheckRoutedSubnets(subnets []string) error {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	taken := make(map[string]string)
+	for _, iface := range Interfaces(pm.config) {
+		if subnet, err := interfaceSubnet(iface); err == nil {
+			taken[subnet.String()] = "interface " + iface.Name
+		}
+	}
+	peers, err := pm.ListPeers()
+	if err != nil {
+		return err
+	}
+	for _, peer := range peers {
+		if peer.Archived() {
+			continue
+		}
+		for _, subnet := range peer.RoutedSubnets {
+			taken[subnet] = "gateway " + peer.ID
+		}
+	}
+
+	for _, subnet := range subnets {
+		_, network, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return fmt.Errorf("invalid network %q: %v", subnet, err)
+		}
+		for other, owner := range taken {
+			_, otherNetwork, err := net.ParseCIDR(other)
+			if err != nil {
+				continue
+			}
+			if network.Contains(otherNetwork.IP) || otherNetwork.Contains(network.IP) {
+				return fmt.Errorf("network %s overlaps %s of %s", subnet, other, owner)
+			}
+		}
+	}
+	return nil
+}
+
+// GatewayServerSide gets the server side peer entry and routes of a
+// gateway peer. The router config is rendered like any other config.
+func GatewayServerSide(cfg *config.Config, peer *PeerConfig) (*GatewaySetup, error) {
+	if !peer.Gateway() {
+		return nil, fmt.Errorf("peer is not a gateway: %s", peer.ID)
+	}
+	ip := peerIP(peer.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("peer has no address: %s", peer.ID)
+	}
+	iface, err := findInterface(cfg, peer.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := append([]string{ip.String() + "/32"}, peer.RoutedSubnets...)
+	routes := make([]string, len(peer.RoutedSubnets))
+	for i, subnet := range peer.RoutedSubnets {
+		routes[i] = fmt.Sprintf("ip route replace %s dev %s", subnet, iface.Name)
+	}
+
+	return &GatewaySetup{
+		ServerPeer: fmt.Sprintf("[Peer]\nPublicKey = %s\nAllowedIPs = %s\n", peer.PublicKey, strings.Join(allowed, ", ")),
+		Routes:     routes,
+		Subnets:    peer.RoutedSubnets,
+	}, nil
+}
+
+// gatewayAllowedIPs gets the networks a gateway routes into the tunnel,
+// the peer subnets of the server, so the rest of the site's traffic keeps
+// its own uplink
+func gatewayAllowedIPs(cfg *config.Config) string {
+	networks := make([]string, 0)
+	for _, iface := range Interfaces(cfg) {
+		if subnet, err := interfaceSubnet(iface); err == nil {
+			networks = append(networks, subnet.String())
+		}
+	}
+	return strings.Join(networks, ", ")
+}
+
+// syncRoutes points the routes of the networks of gateways on a local
+// interface at it, and removes the routes of networks no longer advertised.
+// Routes are added with proto static so the interface's own subnet route
+// is left alone.
+func syncRoutes(ctx context.Context, iface Interface, peers []*PeerConfig) error {
+	wanted := make(map[string]bool)
+	for _, peer := range peers {
+		for _, subnet := range peer.RoutedSubnets {
+			wanted[subnet] = true
+		}
+	}
+
+	output, err := exec.CommandContext(ctx, "ip", "-o", "route", "show", "dev", iface.Name, "proto", "static").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list routes of %s: %v", iface.Name, err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || wanted[fields[0]] {
+			continue
+		}
+		if err := runCommand(ctx, "ip", "route", "del", fields[0], "dev", iface.Name, "proto", "static"); err != nil {
+			return fmt.Errorf("failed to remove route %s: %v", fields[0], err)
+		}
+	}
+
+	for subnet := range wanted {
+		if err := runCommand(ctx, "ip", "route", "replace", subnet, "dev", iface.Name, "proto", "static"); err != nil {
+			return fmt.Errorf("failed to route %s: %v", subnet, err)
+		}
+	}
+	return nil
+}
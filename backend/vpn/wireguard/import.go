@@ -0,0 +1,266 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/tracing"
+	"github.com/vpn-service/backend/src/utils"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ImportedPeerDeviceName is the device name of imported peers without a
+// name comment
+const ImportedPeerDeviceName = "Imported peer"
+
+// ImportedPeer represents a [Peer] section read from an existing server
+// config
+type ImportedPeer struct {
+	Line      int    `json:"line"`
+	Name      string `json:"name,omitempty"` // from the comment above the section
+	PublicKey string `json:"publicKey"`
+	IP        string `json:"ip"`
+	Keepalive int    `json:"keepalive,omitempty"`
+	UserID    string `json:"userId,omitempty"` // user the peer is imported for
+}
+
+// ParseServerConfig reads the peers of a server config such as a
+// hand-managed /etc/wireguard/wg0.conf. The [Interface] section is ignored.
+// Problems are reported with their line, and peers with problems are left
+// out of the result.
+func ParseServerConfig(content string) ([]ImportedPeer, []ConfigError) {
+	peers := make([]ImportedPeer, 0)
+	errs := make([]ConfigError, 0)
+
+	var current *ImportedPeer
+	var allowedIPs string
+	var failed bool
+	var comment string
+
+	fail := func(line int, format string, args ...interface{}) {
+		errs = append(errs, ConfigError{Line: line, Message: fmt.Sprintf(format, args...)})
+		failed = true
+	}
+
+	// finish adds the peer of the section just read, if it is complete
+	finish := func() {
+		if current == nil || failed {
+			return
+		}
+		if current.PublicKey == "" {
+			fail(current.Line, "[Peer] section is missing PublicKey")
+			return
+		}
+		ip, err := peerAddress(allowedIPs)
+		if err != nil {
+			fail(current.Line, "%v", err)
+			return
+		}
+		current.IP = ip
+		peers = append(peers, *current)
+	}
+
+	for i, raw := range strings.Split(content, "\n") {
+		lineNo := i + 1
+		line := strings.TrimSpace(raw)
+
+		// Hand-managed configs usually name a peer in the comment above it
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			comment = strings.TrimSpace(strings.TrimLeft(line, "#;"))
+			if key, value, ok := strings.Cut(comment, "="); ok && strings.EqualFold(strings.TrimSpace(key), "name") {
+				comment = strings.TrimSpace(value)
+			}
+			continue
+		}
+		if line == "" {
+			comment = ""
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			finish()
+			current, allowedIPs, failed = nil, "", false
+			if strings.EqualFold(line, "[Peer]") {
+				current = &ImportedPeer{Line: lineNo, Name: comment}
+			}
+			comment = ""
+			continue
+		}
+		comment = ""
+
+		if current == nil {
+			continue
+		}
+
+		// Drop inline comments
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = strings.TrimSpace(line[:idx])
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			fail(lineNo, "expected Key = Value")
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "PublicKey":
+			if !ValidKey(value) {
+				fail(lineNo, "invalid public key")
+				continue
+			}
+			current.PublicKey = value
+		case "AllowedIPs":
+			allowedIPs = value
+		case "PersistentKeepalive":
+			if value == "off" {
+				continue
+			}
+			keepalive, err := strconv.Atoi(value)
+			if err != nil || keepalive < 0 {
+				fail(lineNo, "invalid PersistentKeepalive %s", value)
+				continue
+			}
+			current.Keepalive = keepalive
+		case "PresharedKey":
+			// The client would keep using the key and fail to handshake
+			fail(lineNo, "preshared keys are not supported")
+		case "Endpoint":
+			// Client endpoints are learned from their handshakes
+		default:
+			fail(lineNo, "unknown key %s in [Peer] section", key)
+		}
+	}
+	finish()
+
+	return peers, errs
+}
+
+// peerAddress picks the address of a client from the AllowedIPs of its
+// [Peer] section: the first IPv4 host address
+func peerAddress(allowedIPs string) (string, error) {
+	for _, entry := range strings.Split(allowedIPs, ",") {
+		entry = strings.TrimSpace(entry)
+		ip, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			ip = net.ParseIP(entry)
+		} else if ones, bits := network.Mask.Size(); ones != bits {
+			continue
+		}
+		if ip != nil && ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+	}
+	return "", fmt.Errorf("AllowedIPs has no IPv4 host address")
+}
+
+// ImportPeers saves peers read from an existing server config as peers of
+// the users they are assigned to and applies them on the server together.
+// Imported peers keep their addresses, which must be free and lie in the
+// peer subnet so the allocator skips them from then on, and their private
+// keys stay on the devices. The returned errors line up with peers; a peer
+// with an error is not imported. If the server rejects the configuration
+// no peer is imported.
+func (pm *PeerManager) ImportPeers(ctx context.Context, serverID string, peers []ImportedPeer) (imported []*PeerConfig, errs []error, err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.ImportPeers", attribute.String("server.id", serverID))
+	defer func() { tracing.End(span, err) }()
+
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	subnet, err := pm.subnet()
+	if err != nil {
+		return nil, nil, err
+	}
+	used, err := pm.usedIPs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A key can only be used by one peer
+	keys := make(map[string]bool)
+	static, err := pm.ListPeers()
+	if err != nil {
+		return nil, nil, err
+	}
+	dynamic, err := pm.ListDynamicPeers()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, peer := range append(static, dynamic...) {
+		keys[peer.PublicKey] = true
+	}
+
+	imported = make([]*PeerConfig, len(peers))
+	errs = make([]error, len(peers))
+	saved := make([]*PeerConfig, 0, len(peers))
+	now := time.Now()
+
+	for i, p := range peers {
+		ip := peerIP(p.IP)
+		switch {
+		case !ValidKey(p.PublicKey):
+			errs[i] = fmt.Errorf("invalid public key")
+			continue
+		case keys[p.PublicKey]:
+			errs[i] = fmt.Errorf("public key is already in use by another device")
+			continue
+		case ip == nil || !subnet.Contains(ip):
+			errs[i] = fmt.Errorf("address %s is outside of %s", p.IP, subnet)
+			continue
+		case used[ip.String()]:
+			errs[i] = fmt.Errorf("address %s is already in use", p.IP)
+			continue
+		}
+
+		name := p.Name
+		if name == "" {
+			name = ImportedPeerDeviceName
+		}
+
+		peer := &PeerConfig{
+			ID:          utils.GenerateUUID(),
+			UserID:      p.UserID,
+			ServerID:    serverID,
+			DeviceType:  "generic",
+			DeviceName:  name,
+			PublicKey:   p.PublicKey,
+			IP:          ip.String() + "/32",
+			ServerIP:    pm.config.WireGuard.ServerIP,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+			PeerOptions: PeerOptions{Keepalive: p.Keepalive},
+			ClientKey:   true,
+		}
+		if err := pm.savePeerConfig(peer); err != nil {
+			errs[i] = fmt.Errorf("failed to save peer config: %v", err)
+			continue
+		}
+
+		keys[p.PublicKey] = true
+		used[ip.String()] = true
+		imported[i] = peer
+		saved = append(saved, peer)
+	}
+
+	if len(saved) == 0 {
+		return imported, errs, nil
+	}
+
+	// Apply configuration, removing the saved configs again if the node
+	// rejects it
+	if err := pm.apply(ctx, serverID, ApplyOperationAdd); err != nil {
+		for _, peer := range saved {
+			if err := pm.deletePeerConfig(peer); err != nil {
+				utils.LogErrorContext(ctx, "Failed to roll back peer config %s: %v", peer.ID, err)
+			}
+		}
+		return nil, nil, &ApplyError{ServerID: serverID, Err: err}
+	}
+
+	return imported, errs, nil
+}
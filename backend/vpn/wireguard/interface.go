@@ -0,0 +1,228 @@
+package wireguard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/firewall"
+)
+
+// serverKeyFile holds the private key of the local interface when none is
+// configured, so clients keep working across restarts
+const serverKeyFile = "server.key"
+
+// SetupLocalInterface brings up the WireGuard interface on this host and
+// makes every later apply configure it directly, as in standalone mode.
+// Without a configured private key one is generated and kept in the config
+// directory.
+func (pm *PeerManager) SetupLocalInterface(ctx context.Context) error {
+	wg := &pm.config.WireGuard
+
+	if err := os.MkdirAll(wg.ConfigDir, 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+
+	// Load or generate the server key
+	if wg.PrivateKey == "" {
+		privateKey, err := loadOrCreateServerKey(filepath.Join(wg.ConfigDir, serverKeyFile))
+		if err != nil {
+			return err
+		}
+		wg.PrivateKey = privateKey
+	}
+	publicKey, err := publicKeyOf(wg.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("invalid WireGuard private key: %v", err)
+	}
+	wg.PublicKey = publicKey
+
+	// Create the interfaces, tolerating ones left over from a previous run
+	interfaces := Interfaces(pm.config)
+	for _, iface := range interfaces {
+		if err := pm.createInterface(ctx, iface.Name); err != nil {
+			return err
+		}
+		if err := runHook(ctx, wg.PreUp, iface.Name); err != nil {
+			return fmt.Errorf("preUp failed: %v", err)
+		}
+		if err := runCommand(ctx, "ip", "address", "replace", iface.Address, "dev", iface.Name); err != nil {
+			return fmt.Errorf("failed to set address of %s: %v", iface.Name, err)
+		}
+		if wg.MTU > 0 {
+			if err := runCommand(ctx, "ip", "link", "set", "mtu", strconv.Itoa(wg.MTU), "dev", iface.Name); err != nil {
+				return fmt.Errorf("failed to set MTU of %s: %v", iface.Name, err)
+			}
+		}
+	}
+
+	// Load the peers saved before the restart, reconciling the firewall
+	// rules left by the previous run, then bring the links up
+	pm.local = true
+	if wg.Firewall.Enabled {
+		pm.firewall = firewall.New(wg.Firewall.Table)
+	}
+	if err := pm.syncInterface(ctx); err != nil {
+		return err
+	}
+	for _, iface := range interfaces {
+		if err := runCommand(ctx, "ip", "link", "set", "up", "dev", iface.Name); err != nil {
+			return fmt.Errorf("failed to bring up %s: %v", iface.Name, err)
+		}
+		if err := runHook(ctx, wg.PostUp, iface.Name); err != nil {
+			return fmt.Errorf("postUp failed: %v", err)
+		}
+	}
+
+	// Accept WireGuard over TCP for networks blocking UDP
+	if err := pm.startObfuscation(); err != nil {
+		return fmt.Errorf("failed to start obfuscation endpoint: %v", err)
+	}
+
+	for _, iface := range interfaces {
+		utils.LogInfo("WireGuard interface %s (%s) is up on port %d", iface.Name, pm.Implementation(), iface.ListenPort)
+	}
+	return nil
+}
+
+// TeardownLocalInterface removes the WireGuard interface brought up by
+// SetupLocalInterface
+func (pm *PeerManager) TeardownLocalInterface(ctx context.Context) error {
+	wg := &pm.config.WireGuard
+	if !pm.local {
+		return nil
+	}
+
+	pm.stopObfuscation()
+	if pm.firewall != nil {
+		if err := pm.firewall.Remove(ctx); err != nil {
+			utils.LogWarning("Failed to remove firewall rules: %v", err)
+		}
+	} else {
+		if err := pm.removeDNSPolicy(ctx); err != nil {
+			utils.LogWarning("Failed to remove DNS policy: %v", err)
+		}
+		if err := pm.removeEgress(ctx); err != nil {
+			utils.LogWarning("Failed to remove egress rules: %v", err)
+		}
+	}
+	for _, iface := range Interfaces(pm.config) {
+		if err := runHook(ctx, wg.PreDown, iface.Name); err != nil {
+			utils.LogWarning("preDown failed: %v", err)
+		}
+		if err := pm.localDriver().Delete(ctx, iface.Name); err != nil {
+			return fmt.Errorf("failed to remove interface %s: %v", iface.Name, err)
+		}
+		if err := runHook(ctx, wg.PostDown, iface.Name); err != nil {
+			utils.LogWarning("postDown failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// LocalVersion gets the version of the WireGuard tools on this host
+func LocalVersion(ctx context.Context) string {
+	output, err := exec.CommandContext(ctx, "wg", "--version").Output()
+	if err != nil {
+		return ""
+	}
+
+	// wireguard-tools v1.0.20210914 - https://git.zx2c4.com/wireguard-tools/
+	fields := strings.Fields(string(output))
+	if len(fields) < 2 {
+		return ""
+	}
+	return strings.TrimPrefix(fields[1], "v")
+}
+
+// syncInterface replaces the peers of the local interfaces with the peers
+// currently saved on each of them, together with their firewall rules
+func (pm *PeerManager) syncInterface(ctx context.Context) error {
+	peers, err := pm.localPeers()
+	if err != nil {
+		return err
+	}
+
+	for _, iface := range Interfaces(pm.config) {
+		if err := pm.syncDevice(ctx, iface, peers); err != nil {
+			return err
+		}
+	}
+
+	// Send the internet traffic of peers to the servers they exit at
+	if err := pm.syncExitRoutes(ctx, peers); err != nil {
+		return err
+	}
+
+	// Forward and NAT peer traffic, enforcing their DNS profiles and
+	// dedicated addresses
+	if pm.firewall != nil {
+		return pm.syncFirewall(ctx, peers)
+	}
+
+	// Enforce the DNS profiles of the peers
+	if err := pm.syncDNSPolicy(ctx, peers); err != nil {
+		return err
+	}
+
+	// Send traffic of dedicated addresses out from their public address
+	return pm.syncEgress(ctx, peers)
+}
+
+// loadOrCreateServerKey reads the server private key from path, generating
+// and saving a new one if the file does not exist
+func loadOrCreateServerKey(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(content)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read server key: %v", err)
+	}
+
+	privateKey, _, err := GenerateKeyPair()
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(privateKey+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to save server key: %v", err)
+	}
+
+	utils.LogInfo("Generated WireGuard server key in %s", path)
+	return privateKey, nil
+}
+
+// runCommand runs a command, including its output in the error on failure
+func runCommand(ctx context.Context, name string, args ...string) error {
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// runHook runs an interface hook through the shell, with %i replaced by
+// the interface name as wg-quick does
+func runHook(ctx context.Context, hook, iface string) error {
+	if strings.TrimSpace(hook) == "" {
+		return nil
+	}
+	return runCommand(ctx, "sh", "-c", strings.ReplaceAll(hook, "%i", iface))
+}
+
+// LocalInterfaceUp reports whether the local WireGuard interfaces exist
+func (pm *PeerManager) LocalInterfaceUp(ctx context.Context) bool {
+	for _, iface := range Interfaces(pm.config) {
+		if _, err := pm.localDriver().Device(iface.Name); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,228 @@
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// activeHandshake is how recent a handshake must be for a peer to count as
+// active on its interface, as WireGuard renews sessions every 2 minutes
+const activeHandshake = 3 * time.Minute
+
+// ErrUnknownInterface is returned for an interface that is not configured
+var ErrUnknownInterface = errors.New("unknown WireGuard interface")
+
+// Interface is a WireGuard interface of the server with its own port and
+// peer subnet
+type Interface struct {
+	Name       string   `json:"name"`
+	ListenPort int      `json:"listenPort"`
+	Address    string   `json:"address"`
+	Primary    bool     `json:"primary"`
+	Plans      []string `json:"plans,omitempty"`
+	Obfuscated bool     `json:"obfuscated,omitempty"`
+}
+
+// InterfaceStatus represents the state of an interface on this host
+type InterfaceStatus struct {
+	Interface
+	Up          bool `json:"up"`
+	Peers       int  `json:"peers"`       // peers with an address on the interface
+	ActivePeers int  `json:"activePeers"` // peers with a recent handshake
+
+	// Bytes received from and sent to the current peers
+	ReceiveBytes  int64 `json:"receiveBytes"`
+	TransmitBytes int64 `json:"transmitBytes"`
+}
+
+// Interfaces gets the interfaces of the server, the primary one first
+func Interfaces(cfg *config.Config) []Interface {
+	interfaces := []Interface{{
+		Name:       cfg.WireGuard.Interface,
+		ListenPort: cfg.WireGuard.ListenPort,
+		Address:    cfg.WireGuard.Address,
+		Primary:    true,
+	}}
+	for _, extra := range cfg.WireGuard.Interfaces {
+		interfaces = append(interfaces, Interface{
+			Name:       extra.Name,
+			ListenPort: extra.ListenPort,
+			Address:    extra.Address,
+			Plans:      extra.Plans,
+			Obfuscated: extra.Obfuscated,
+		})
+	}
+	return interfaces
+}
+
+// SelectInterface gets the interface of a new device: the obfuscated
+// interface for obfuscated devices, or else the first interface of the
+// user's plan. An empty name is the primary interface.
+func SelectInterface(cfg *config.Config, planID string, obfuscated bool) string {
+	if obfuscated {
+		for _, extra := range cfg.WireGuard.Interfaces {
+			if extra.Obfuscated {
+				return extra.Name
+			}
+		}
+	}
+	if planID != "" {
+		for _, extra := range cfg.WireGuard.Interfaces {
+			for _, plan := range extra.Plans {
+				if plan == planID {
+					return extra.Name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// findInterface gets an interface by name, the primary one for an empty
+// name
+func findInterface(cfg *config.Config, name string) (Interface, error) {
+	interfaces := Interfaces(cfg)
+	if name == "" {
+		return interfaces[0], nil
+	}
+	for _, iface := range interfaces {
+		if iface.Name == name {
+			return iface, nil
+		}
+	}
+	return Interface{}, fmt.Errorf("%w: %s", ErrUnknownInterface, name)
+}
+
+// onInterface reports whether a peer is on an interface
+func onInterface(peer *PeerConfig, iface Interface) bool {
+	if peer.Interface == "" {
+		return iface.Primary
+	}
+	return peer.Interface == iface.Name
+}
+
+// interfacePeers gets the peers configured on an interface, leaving out
+// those without an address
+func interfacePeers(iface Interface, peers []*PeerConfig) []*PeerConfig {
+	active := make([]*PeerConfig, 0, len(peers))
+	for _, peer := range peers {
+		if peer.Pending() || peer.Archived() || peer.IP == "" || !onInterface(peer, iface) {
+			continue
+		}
+		active = append(active, peer)
+	}
+	return active
+}
+
+// peerEndpoint gets the endpoint of the interface a peer is on
+func peerEndpoint(cfg *config.Config, peer *PeerConfig) string {
+	iface, err := findInterface(cfg, peer.Interface)
+	if err != nil {
+		return ServerEndpoint(cfg)
+	}
+	return fmt.Sprintf("%s:%d", cfg.WireGuard.ServerEndpoint, iface.ListenPort)
+}
+
+// interfaceSubnet gets the IPv4 subnet peer addresses of an interface are
+// allocated from, which is the subnet of its server address
+func interfaceSubnet(iface Interface) (*net.IPNet, error) {
+	_, subnet, err := net.ParseCIDR(iface.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s of interface %s: %v", iface.Address, iface.Name, err)
+	}
+	if subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("address %s of interface %s is not an IPv4 address", iface.Address, iface.Name)
+	}
+	subnet.IP = subnet.IP.To4()
+	return subnet, nil
+}
+
+// InterfaceStatuses gets the state of every interface of the server
+func (pm *PeerManager) InterfaceStatuses(ctx context.Context) ([]InterfaceStatus, error) {
+	peers, err := pm.localPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := Interfaces(pm.config)
+	statuses := make([]InterfaceStatus, len(interfaces))
+	for i, iface := range interfaces {
+		status := InterfaceStatus{Interface: iface}
+		for _, peer := range peers {
+			if !peer.Pending() && !peer.Archived() && peer.IP != "" && onInterface(peer, iface) {
+				status.Peers++
+			}
+		}
+
+		if device, err := pm.localDriver().Device(iface.Name); err == nil {
+			status.Up = true
+			for _, peer := range device.Peers {
+				if time.Since(peer.LastHandshakeTime) < activeHandshake {
+					status.ActivePeers++
+				}
+				status.ReceiveBytes += peer.ReceiveBytes
+				status.TransmitBytes += peer.TransmitBytes
+			}
+		}
+
+		statuses[i] = status
+	}
+
+	return statuses, nil
+}
+
+// ApplyInterface applies the peers of one interface again, e.g. after it
+// was changed by hand
+func (pm *PeerManager) ApplyInterface(ctx context.Context, name string) error {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	iface, err := findInterface(pm.config, name)
+	if err != nil {
+		return err
+	}
+	if !pm.local {
+		return pm.applyConfiguration(ctx)
+	}
+
+	peers, err := pm.localPeers()
+	if err != nil {
+		return err
+	}
+
+	if err := pm.syncDevice(ctx, iface, peers); err != nil {
+		return err
+	}
+
+	utils.LogInfoContext(ctx, "Applied the peers of interface %s", iface.Name)
+	return nil
+}
+
+// syncDevice replaces the peers of a local interface with the given peers
+// that are on it. Peers waiting for approval and archived peers have no
+// address and are left out.
+func (pm *PeerManager) syncDevice(ctx context.Context, iface Interface, peers []*PeerConfig) error {
+	active := interfacePeers(iface, peers)
+
+	driver := pm.localDriver()
+	current, err := driver.Device(iface.Name)
+	if err != nil {
+		return err
+	}
+	config, err := interfaceConfig(pm.config.WireGuard.PrivateKey, iface.ListenPort, active, current)
+	if err != nil {
+		return err
+	}
+	if err := driver.Configure(iface.Name, config); err != nil {
+		return err
+	}
+
+	// Route the networks behind gateways to them
+	return syncRoutes(ctx, iface, active)
+}
@@ -0,0 +1,33 @@
+package wireguard
+
+import "github.com/vpn-service/backend/src/config"
+
+// KeepaliveOff is stored as the keepalive of peers that turned persistent
+// keepalives off, since zero means the server default
+const KeepaliveOff = -1
+
+// Bounds of a keepalive interval chosen for a device, in seconds. Below 10
+// the device wakes its radio for little gain; NAT mappings rarely outlive
+// 10 minutes without traffic.
+const (
+	MinKeepalive = 10
+	MaxKeepalive = 600
+)
+
+// defaultKeepalive is used when neither the peer nor the server sets one
+const defaultKeepalive = 25
+
+// PeerKeepalive gets the persistent keepalive interval rendered into a
+// peer's config in seconds, or zero when it is off
+func PeerKeepalive(cfg *config.Config, peer *PeerConfig) int {
+	switch {
+	case peer.Keepalive == KeepaliveOff:
+		return 0
+	case peer.Keepalive > 0:
+		return peer.Keepalive
+	case cfg.WireGuard.Keepalive > 0:
+		return cfg.WireGuard.Keepalive
+	default:
+		return defaultKeepalive
+	}
+}
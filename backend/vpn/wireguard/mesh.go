@@ -0,0 +1,182 @@
+package wireguard
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Mesh topologies
+const (
+	MeshFull = "full" // every peer carries every other peer
+	MeshHub  = "hub"  // peers carry a hub peer that forwards between them
+)
+
+// meshKeepalive keeps the NAT mappings of the direct paths between mesh
+// peers open
+const meshKeepalive = 25
+
+// MeshPolicy puts the devices of a group of users in a mesh: next to the
+// server, their configs carry each other as peers so traffic between them
+// goes direct. Only devices on the same server mesh, as each server
+// allocates its own addresses.
+type MeshPolicy struct {
+	ID        string
+	Topology  string   // full or hub
+	HubPeerID string   // hub topology only
+	UserIDs   []string // members of the mesh
+}
+
+// MeshSource gets the current mesh policies
+type MeshSource func() ([]MeshPolicy, error)
+
+// meshEntry is a mesh peer in the config of another
+type meshEntry struct {
+	peer       *PeerConfig
+	allowedIPs []string
+}
+
+// SetMesh sets where mesh policies come from
+func (pm *PeerManager) SetMesh(source MeshSource) {
+	pm.meshSource = source
+}
+
+// MeshPolicyOf gets the mesh a user's devices are in, nil if none
+func (pm *PeerManager) MeshPolicyOf(userID string) (*MeshPolicy, error) {
+	if pm.meshSource == nil {
+		return nil, nil
+	}
+	policies, err := pm.meshSource()
+	if err != nil {
+		return nil, err
+	}
+	for i := range policies {
+		for _, member := range policies[i].UserIDs {
+			if member == userID {
+				return &policies[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// MeshMembers gets the devices of a mesh on one server, ordered by ID.
+// Pending and archived devices have no address and are left out.
+func (pm *PeerManager) MeshMembers(mesh *MeshPolicy, serverID string) ([]*PeerConfig, error) {
+	members := make([]*PeerConfig, 0)
+	for _, userID := range mesh.UserIDs {
+		peers, err := pm.getStaticPeers(userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, peer := range peers {
+			if peer.ServerID != serverID || peer.Pending() || peer.Archived() || peerIP(peer.IP) == nil {
+				continue
+			}
+			members = append(members, peer)
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members, nil
+}
+
+// meshEntries gets the mesh peers the config of a peer carries. With the
+// hub topology spokes carry only the hub, which routes the addresses of
+// the other spokes.
+func (pm *PeerManager) meshEntries(peer *PeerConfig) ([]meshEntry, error) {
+	if peer.Dynamic || peer.Pending() || peer.Archived() {
+		return nil, nil
+	}
+	mesh, err := pm.MeshPolicyOf(peer.UserID)
+	if err != nil || mesh == nil {
+		return nil, err
+	}
+	members, err := pm.MeshMembers(mesh, peer.ServerID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]meshEntry, 0)
+	if mesh.Topology == MeshHub && peer.ID != mesh.HubPeerID {
+		var hub *PeerConfig
+		routed := make([]string, 0)
+		for _, member := range members {
+			if member.ID == mesh.HubPeerID {
+				hub = member
+			}
+			if member.ID != peer.ID {
+				routed = append(routed, peerIP(member.IP).String()+"/32")
+			}
+		}
+		if hub != nil {
+			entries = append(entries, meshEntry{peer: hub, allowedIPs: routed})
+		}
+		return entries, nil
+	}
+
+	for _, member := range members {
+		if member.ID != peer.ID {
+			entries = append(entries, meshEntry{peer: member, allowedIPs: []string{peerIP(member.IP).String() + "/32"}})
+		}
+	}
+	return entries, nil
+}
+
+// renderMesh appends the mesh peers of a peer to its rendered config.
+// Endpoints are the ones the server last saw and are left out of the
+// params hash, so roaming devices do not make configs look stale.
+func (pm *PeerManager) renderMesh(rendered *RenderedConfig, peer *PeerConfig) (*RenderedConfig, error) {
+	entries, err := pm.meshEntries(peer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mesh peers: %v", err)
+	}
+	if len(entries) == 0 {
+		return rendered, nil
+	}
+
+	endpoints := pm.peerEndpoints()
+	var config, members strings.Builder
+	config.WriteString(strings.TrimRight(rendered.Config, "\n") + "\n")
+	for _, entry := range entries {
+		allowed := strings.Join(entry.allowedIPs, ", ")
+		config.WriteString("\n[Peer]\n")
+		config.WriteString("PublicKey = " + entry.peer.PublicKey + "\n")
+		config.WriteString("AllowedIPs = " + allowed + "\n")
+		if endpoint, ok := endpoints[entry.peer.PublicKey]; ok {
+			config.WriteString("Endpoint = " + endpoint + "\n")
+		}
+		config.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", meshKeepalive))
+		members.WriteString(entry.peer.PublicKey + "=" + allowed + "\n")
+	}
+
+	if errs := ValidateConfig(config.String()); len(errs) > 0 {
+		return nil, fmt.Errorf("rendered mesh config is invalid: %v", errs[0])
+	}
+	rendered.Config = config.String()
+	rendered.ParamsHash = fingerprint(rendered.ParamsHash + "\n" + members.String())
+	return rendered, nil
+}
+
+// peerEndpoints gets the addresses the local interfaces last saw peers at,
+// by public key
+func (pm *PeerManager) peerEndpoints() map[string]string {
+	endpoints := make(map[string]string)
+
+	for _, iface := range Interfaces(pm.config) {
+		device, err := pm.localDriver().Device(iface.Name)
+		if err != nil {
+			utils.LogDebug("Failed to read peer endpoints: %v", err)
+			continue
+		}
+		for _, peer := range device.Peers {
+			if peer.Endpoint != nil {
+				endpoints[peer.PublicKey.String()] = peer.Endpoint.String()
+			}
+		}
+	}
+
+	return endpoints
+}
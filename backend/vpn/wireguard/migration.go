@@ -0,0 +1,57 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MigratePeer moves a static or dynamic peer to another server with a new
+// address, keeping its keys and settings. An exit at the new server is
+// dropped, as the peer now exits there anyway. Pending and archived peers
+// hold no address and move without one. The server left behind drops the
+// peer when its agent next syncs. When the new server fails to apply the
+// peer, the move is kept for its next sync and the moved peer is returned
+// with an ApplyError.
+func (pm *PeerManager) MigratePeer(ctx context.Context, userID, peerID, serverID string) (*PeerConfig, error) {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	peer, err := pm.GetPeer(userID, peerID)
+	if err != nil {
+		return nil, err
+	}
+	if peer.ServerID == serverID {
+		return peer, nil
+	}
+
+	peer.ServerID = serverID
+	if peer.ExitServer == serverID {
+		peer.ExitServer = ""
+	}
+	if !peer.Pending() && !peer.Archived() {
+		ip, err := pm.allocateIP(userID, peer.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate IP: %v", err)
+		}
+		peer.IP = ip
+	}
+	peer.UpdatedAt = time.Now()
+
+	save := pm.savePeerConfig
+	if peer.Dynamic {
+		save = pm.saveDynamicPeerConfig
+	}
+	if err := save(peer); err != nil {
+		return nil, err
+	}
+
+	if peer.Pending() || peer.Archived() {
+		return peer, nil
+	}
+	if err := pm.apply(ctx, serverID, ApplyOperationAdd); err != nil {
+		return peer, &ApplyError{ServerID: serverID, Err: err}
+	}
+
+	return peer, nil
+}
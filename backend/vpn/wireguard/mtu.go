@@ -0,0 +1,73 @@
+package wireguard
+
+import (
+	"strings"
+
+	"github.com/vpn-service/backend/src/config"
+)
+
+// Network types a device's MTU default is picked by
+const (
+	NetworkTypeCellular = "cellular"
+	NetworkTypeWiFi     = "wifi"
+	NetworkTypeEthernet = "ethernet"
+)
+
+// Client MTU bounds. Below 1280 IPv6 cannot be carried in the tunnel, and
+// no path across the internet takes more than 1500.
+const (
+	MinMTU = 1280
+	MaxMTU = 1500
+)
+
+// Bytes WireGuard adds to every packet: the outer IP header, the UDP header
+// and 32 bytes of WireGuard header and authentication tag
+const (
+	overheadIPv4 = 20 + 8 + 32
+	overheadIPv6 = 40 + 8 + 32
+)
+
+// NetworkTypes lists the network types with MTU defaults
+func NetworkTypes() []string {
+	return []string{NetworkTypeCellular, NetworkTypeWiFi, NetworkTypeEthernet}
+}
+
+// DefaultNetworkType gets the network a device type is assumed to be on:
+// phones and tablets on cellular, where carriers often have a smaller MTU,
+// and everything else on ethernet
+func DefaultNetworkType(deviceType string) string {
+	switch strings.ToLower(deviceType) {
+	case "android", "ios", "iphone", "ipad":
+		return NetworkTypeCellular
+	default:
+		return NetworkTypeEthernet
+	}
+}
+
+// TunnelOverhead gets the bytes WireGuard adds to a packet sent over IPv4 or
+// IPv6
+func TunnelOverhead(ipv6 bool) int {
+	if ipv6 {
+		return overheadIPv6
+	}
+	return overheadIPv4
+}
+
+// PeerMTU gets the MTU rendered into a peer's config: its own, else the
+// default of its network type, else the server's. Zero leaves it to the
+// client.
+func PeerMTU(cfg *config.Config, peer *PeerConfig) int {
+	if peer.MTU > 0 {
+		return peer.MTU
+	}
+
+	networkType := peer.NetworkType
+	if networkType == "" {
+		networkType = DefaultNetworkType(peer.DeviceType)
+	}
+	if mtu := cfg.WireGuard.NetworkMTU[networkType]; mtu > 0 {
+		return mtu
+	}
+
+	return cfg.WireGuard.MTU
+}
@@ -0,0 +1,64 @@
+package wireguard
+
+import "net"
+
+// ExcludeNetworks returns the networks covering base without excluded, in
+// address order
+func ExcludeNetworks(base, excluded []string) []string {
+	networks := make([]*net.IPNet, 0, len(base))
+	for _, cidr := range base {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	for _, cidr := range excluded {
+		_, exclude, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		remaining := make([]*net.IPNet, 0, len(networks))
+		for _, network := range networks {
+			remaining = append(remaining, subtractNetwork(network, exclude)...)
+		}
+		networks = remaining
+	}
+
+	result := make([]string, len(networks))
+	for i, network := range networks {
+		result[i] = network.String()
+	}
+	return result
+}
+
+// subtractNetwork returns the networks covering network without excluded,
+// splitting it in halves around the excluded range
+func subtractNetwork(network, excluded *net.IPNet) []*net.IPNet {
+	ones, bits := network.Mask.Size()
+	excludedOnes, excludedBits := excluded.Mask.Size()
+
+	switch {
+	case bits != excludedBits:
+		// Different address families
+		return []*net.IPNet{network}
+	case excludedOnes <= ones:
+		if excluded.Contains(network.IP) {
+			return nil
+		}
+		return []*net.IPNet{network}
+	case !network.Contains(excluded.IP):
+		return []*net.IPNet{network}
+	}
+
+	mask := net.CIDRMask(ones+1, bits)
+	low := &net.IPNet{IP: network.IP.Mask(mask), Mask: mask}
+	highIP := make(net.IP, len(low.IP))
+	copy(highIP, low.IP)
+	highIP[ones/8] |= 0x80 >> (ones % 8)
+	high := &net.IPNet{IP: highIP, Mask: mask}
+
+	if low.Contains(excluded.IP) {
+		return append(subtractNetwork(low, excluded), high)
+	}
+	return append([]*net.IPNet{low}, subtractNetwork(high, excluded)...)
+}
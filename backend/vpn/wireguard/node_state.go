@@ -0,0 +1,106 @@
+package wireguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// NodeState is the desired state of a server, which its node agent
+// reconciles the local WireGuard interfaces against: the peers on the
+// server, without their private keys, and the policies its firewall
+// enforces
+type NodeState struct {
+	ServerID     string               `json:"serverId"`
+	Version      string               `json:"version"` // fingerprint of the rest of the state
+	Peers        []*PeerConfig        `json:"peers"`
+	ACL          []ACLPolicy          `json:"acl"`
+	Reservations []AddressReservation `json:"reservations"`
+}
+
+// NodeState gets the state the node agent of a server applies
+func (pm *PeerManager) NodeState(serverID string) (*NodeState, error) {
+	static, err := pm.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+	dynamic, err := pm.ListDynamicPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &NodeState{
+		ServerID:     serverID,
+		Peers:        make([]*PeerConfig, 0),
+		ACL:          make([]ACLPolicy, 0),
+		Reservations: make([]AddressReservation, 0),
+	}
+	for _, peer := range append(static, dynamic...) {
+		if peer.ServerID != serverID {
+			continue
+		}
+		copied := *peer
+		copied.PrivateKey = ""
+		state.Peers = append(state.Peers, &copied)
+	}
+	sort.Slice(state.Peers, func(i, j int) bool {
+		return state.Peers[i].ID < state.Peers[j].ID
+	})
+	pm.withEntitlements(state.Peers)
+
+	policies, err := pm.aclPolicies()
+	if err != nil {
+		return nil, err
+	}
+	state.ACL = append(state.ACL, policies...)
+	reservations, err := pm.reservations()
+	if err != nil {
+		return nil, err
+	}
+	state.Reservations = append(state.Reservations, reservations...)
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode node state: %v", err)
+	}
+	state.Version = fingerprint(string(content))
+
+	return state, nil
+}
+
+// ApplyNodeState makes the peers and policies of a state the ones the local
+// interfaces are configured with, in place of the stored peers, as on
+// servers run by a node agent. Without a local interface the state is kept
+// for when it comes up.
+func (pm *PeerManager) ApplyNodeState(ctx context.Context, state *NodeState) error {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	pm.nodeState = state
+	if !pm.local {
+		return nil
+	}
+	return pm.syncInterface(ctx)
+}
+
+// localPeers gets the peers configured on the local interfaces: those of
+// the node state when one was applied, otherwise the stored peers
+func (pm *PeerManager) localPeers() ([]*PeerConfig, error) {
+	if pm.nodeState != nil {
+		return pm.nodeState.Peers, nil
+	}
+
+	static, err := pm.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+	dynamic, err := pm.ListDynamicPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	peers := append(static, dynamic...)
+	pm.withEntitlements(peers)
+	return peers, nil
+}
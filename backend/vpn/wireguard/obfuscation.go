@@ -0,0 +1,231 @@
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Obfuscation transports, which carry WireGuard over TCP for networks that
+// block UDP
+const (
+	TransportWebSocket = "websocket" // wstunnel, looks like HTTPS traffic
+	TransportTCP       = "tcp"       // udp2raw with fake TCP headers
+)
+
+// ObfuscationLocalPort is the UDP port the client wrapper listens on, which
+// obfuscated configs point their endpoint at
+const ObfuscationLocalPort = 51820
+
+// obfuscationRestartDelay is how long a wrapper that exited is left down
+// before it is restarted
+const obfuscationRestartDelay = 5 * time.Second
+
+// ObfuscationEndpoint tells a client how to reach its server through the
+// wrapper endpoint
+type ObfuscationEndpoint struct {
+	Transport string `json:"transport"`
+	URL       string `json:"url"`       // wss://host:port for websocket, host:port for tcp
+	LocalPort int    `json:"localPort"` // port of the client wrapper the config's endpoint points at
+	Command   string `json:"command"`   // runs the client wrapper
+}
+
+// obfuscationWrapper is the wrapper process in front of the local interface
+type obfuscationWrapper struct {
+	transport string
+	stop      chan struct{}
+}
+
+// ClientObfuscation gets the wrapper endpoint of the server for clients, or
+// nil if obfuscation is disabled
+func ClientObfuscation(cfg *config.Config) *ObfuscationEndpoint {
+	obfuscation := cfg.WireGuard.Obfuscation
+	if !obfuscation.Enabled {
+		return nil
+	}
+
+	host := serverHost(cfg)
+	address := net.JoinHostPort(host, strconv.Itoa(obfuscation.Port))
+	local := net.JoinHostPort("127.0.0.1", strconv.Itoa(ObfuscationLocalPort))
+
+	switch obfuscation.Transport {
+	case TransportTCP:
+		return &ObfuscationEndpoint{
+			Transport: TransportTCP,
+			URL:       address,
+			LocalPort: ObfuscationLocalPort,
+			Command:   fmt.Sprintf("udp2raw -c -l %s -r %s -k '%s' --raw-mode faketcp -a", local, address, obfuscation.Key),
+		}
+	default:
+		url := "wss://" + address
+		return &ObfuscationEndpoint{
+			Transport: TransportWebSocket,
+			URL:       url,
+			LocalPort: ObfuscationLocalPort,
+			Command:   fmt.Sprintf("wstunnel client -L 'udp://%s:%s?timeout_sec=0' --http-upgrade-path-prefix %s %s", local, local, obfuscation.PathPrefix, url),
+		}
+	}
+}
+
+// obfuscatedParams points the endpoint of a config at the client wrapper,
+// and keeps the server's own address out of the tunnel so the wrapper's
+// connection does not loop through it
+func obfuscatedParams(cfg *config.Config, params map[string]string) {
+	params["SERVER_ENDPOINT"] = net.JoinHostPort("127.0.0.1", strconv.Itoa(ObfuscationLocalPort))
+
+	ip := net.ParseIP(serverHost(cfg))
+	if ip == nil {
+		// A hostname; clients resolve it outside the tunnel anyway
+		return
+	}
+	excluded := ip.String() + "/32"
+	if ip.To4() == nil {
+		excluded = ip.String() + "/128"
+	}
+
+	networks := make([]string, 0)
+	for _, network := range strings.Split(params["ALLOWED_IPS"], ",") {
+		if network = strings.TrimSpace(network); network != "" {
+			networks = append(networks, network)
+		}
+	}
+	params["ALLOWED_IPS"] = strings.Join(ExcludeNetworks(networks, []string{excluded}), ", ")
+}
+
+// serverHost gets the host of the endpoint clients connect to
+func serverHost(cfg *config.Config) string {
+	host := cfg.WireGuard.ServerEndpoint
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+// ObfuscationTransport gets the transport of the wrapper endpoint running in
+// front of the local interface, or an empty string if there is none
+func (pm *PeerManager) ObfuscationTransport() string {
+	if pm.obfuscation == nil {
+		return ""
+	}
+	return pm.obfuscation.transport
+}
+
+// startObfuscation starts the wrapper endpoint in front of the local
+// interface, restarting it whenever it exits until stopObfuscation
+func (pm *PeerManager) startObfuscation() error {
+	obfuscation := pm.config.WireGuard.Obfuscation
+	if !obfuscation.Enabled || pm.obfuscation != nil {
+		return nil
+	}
+
+	// Forward to the interface set aside for obfuscated peers, if any
+	listenPort := pm.config.WireGuard.ListenPort
+	for _, iface := range Interfaces(pm.config) {
+		if iface.Obfuscated {
+			listenPort = iface.ListenPort
+			break
+		}
+	}
+
+	args, err := obfuscationServerArgs(obfuscation, listenPort)
+	if err != nil {
+		return err
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return fmt.Errorf("%s is not installed", args[0])
+	}
+
+	wrapper := &obfuscationWrapper{transport: obfuscation.Transport, stop: make(chan struct{})}
+	if wrapper.transport == "" {
+		wrapper.transport = TransportWebSocket
+	}
+	pm.obfuscation = wrapper
+
+	go func() {
+		for {
+			cmd := exec.Command(path, args[1:]...)
+			exited := make(chan error, 1)
+			if err := cmd.Start(); err != nil {
+				exited <- err
+			} else {
+				go func() { exited <- cmd.Wait() }()
+			}
+
+			select {
+			case <-wrapper.stop:
+				if cmd.Process != nil {
+					cmd.Process.Kill()
+					<-exited
+				}
+				return
+			case err := <-exited:
+				utils.LogError("Obfuscation endpoint %s exited: %v", args[0], err)
+			}
+
+			select {
+			case <-wrapper.stop:
+				return
+			case <-time.After(obfuscationRestartDelay):
+			}
+		}
+	}()
+
+	utils.LogInfo("Obfuscation endpoint (%s) is listening on TCP port %d", wrapper.transport, obfuscation.Port)
+	return nil
+}
+
+// stopObfuscation stops the wrapper endpoint
+func (pm *PeerManager) stopObfuscation() {
+	if pm.obfuscation == nil {
+		return
+	}
+	close(pm.obfuscation.stop)
+	pm.obfuscation = nil
+}
+
+// obfuscationServerArgs gets the command line of the server side wrapper,
+// forwarding to the WireGuard port on the loopback address
+func obfuscationServerArgs(obfuscation config.ObfuscationConfig, listenPort int) ([]string, error) {
+	if obfuscation.Port <= 0 || obfuscation.Port > 65535 {
+		return nil, fmt.Errorf("invalid obfuscation port: %d", obfuscation.Port)
+	}
+	target := net.JoinHostPort("127.0.0.1", strconv.Itoa(listenPort))
+
+	switch obfuscation.Transport {
+	case "", TransportWebSocket:
+		binary := obfuscation.Binary
+		if binary == "" {
+			binary = "wstunnel"
+		}
+		return []string{
+			binary, "server",
+			"--restrict-to", target,
+			"--restrict-http-upgrade-path-prefix", obfuscation.PathPrefix,
+			fmt.Sprintf("wss://[::]:%d", obfuscation.Port),
+		}, nil
+	case TransportTCP:
+		if obfuscation.Key == "" {
+			return nil, fmt.Errorf("the tcp obfuscation transport needs a key")
+		}
+		binary := obfuscation.Binary
+		if binary == "" {
+			binary = "udp2raw"
+		}
+		return []string{
+			binary, "-s",
+			"-l", fmt.Sprintf("0.0.0.0:%d", obfuscation.Port),
+			"-r", target,
+			"-k", obfuscation.Key,
+			"--raw-mode", "faketcp", "-a",
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown obfuscation transport: %q", obfuscation.Transport)
+	}
+}
@@ -1,15 +1,23 @@
 package wireguard
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/tracing"
 	"github.com/vpn-service/backend/src/utils"
+	"github.com/vpn-service/backend/vpn/firewall"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/curve25519"
 )
 
 var (
@@ -19,9 +27,59 @@ var (
 
 // PeerManager handles WireGuard peer operations
 type PeerManager struct {
-	config *config.Config
+	config        *config.Config
+	applyObserver ApplyObserver
+	local         bool // applies configure the interface on this host
+
+	// driver runs the local interface with the kernel module or
+	// wireguard-go
+	driver Driver
+
+	// reservationSource gets the addresses reserved to users
+	reservationSource ReservationSource
+
+	// aclSource gets the policies of traffic between peers
+	aclSource ACLSource
+
+	// meshSource gets the groups of users whose devices mesh
+	meshSource MeshSource
+
+	// entitlementSource gets what the plans of users allow their peers
+	entitlementSource EntitlementSource
+
+	// nodeState holds the peers and policies a node agent applies, in
+	// place of the stored ones
+	nodeState *NodeState
+
+	// obfuscation is the wrapper endpoint in front of the local interface
+	obfuscation *obfuscationWrapper
+
+	// firewall manages the nftables rules of the local interfaces, or is
+	// nil when they are left to iptables and the interface hooks
+	firewall *firewall.Firewall
+}
+
+// Apply operations reported to the apply observer
+const (
+	ApplyOperationAdd    = "add"
+	ApplyOperationRemove = "remove"
+)
+
+// ApplyError is returned when a peer could not be applied on its node
+type ApplyError struct {
+	ServerID string
+	Err      error
+}
+
+// Error implements the error interface
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("failed to apply configuration on server %s: %v", e.ServerID, e.Err)
 }
 
+// ApplyObserver is notified of the outcome and duration of every peer apply
+// on a node. err is nil when the apply succeeded.
+type ApplyObserver func(serverID, operation string, duration time.Duration, err error)
+
 // PeerConfig represents a WireGuard peer configuration
 type PeerConfig struct {
 	ID         string    `json:"id"`
@@ -36,6 +94,91 @@ type PeerConfig struct {
 	CreatedAt  time.Time `json:"createdAt"`
 	UpdatedAt  time.Time `json:"updatedAt"`
 	Dynamic    bool      `json:"dynamic"`
+
+	PeerOptions
+
+	// Session fields are only set for dynamic peers
+	SessionID     string    `json:"sessionId,omitempty"`
+	ExpiresAt     time.Time `json:"expiresAt,omitempty"`
+	LastHandshake time.Time `json:"lastHandshake,omitempty"`
+
+	// Inactivity fields are only set for static peers
+	LastActive       time.Time `json:"lastActive,omitempty"`       // latest handshake seen by the inactivity sweeper
+	InactiveNotified time.Time `json:"inactiveNotified,omitempty"` // when the user was told the device is idle
+	ArchivedAt       time.Time `json:"archivedAt,omitempty"`       // set while the peer is archived
+
+	// Client key fields are only set for peers whose device brought its own
+	// key pair; the private key never leaves the device
+	ClientKey       bool   `json:"clientKey,omitempty"`
+	Tag             string `json:"tag,omitempty"`             // tag of the allow-list entry the key matched
+	PendingApproval bool   `json:"pendingApproval,omitempty"` // unknown key waiting for an admin
+
+	// Entitlements are what the user's plan allows the peer on its node,
+	// set when it is applied; nil when no plans are enforced
+	Entitlements *PeerEntitlements `json:"entitlements,omitempty"`
+}
+
+// Archived reports whether a peer is archived: removed from its node with
+// its address released, but kept so it can be reactivated
+func (p *PeerConfig) Archived() bool {
+	return !p.ArchivedAt.IsZero()
+}
+
+// Pending reports whether a peer's key is waiting for admin approval. Pending
+// peers have no address and are not applied on their node.
+func (p *PeerConfig) Pending() bool {
+	return p.PendingApproval
+}
+
+// ClientKey represents a public key supplied by the device being enrolled
+type ClientKey struct {
+	PublicKey string
+	Tag       string
+	Pending   bool // hold the peer for admin approval instead of applying it
+}
+
+// PeerOptions represents per-peer settings that override server defaults
+type PeerOptions struct {
+	DNS           string `json:"dns,omitempty"`
+	DNSProfile    string `json:"dnsProfile,omitempty"` // DNS profile the DNS servers come from
+	KillSwitch    bool   `json:"killSwitch,omitempty"`
+	Protocol      string `json:"protocol,omitempty"`
+	Keepalive     int    `json:"keepalive,omitempty"`     // in seconds; KeepaliveOff turns keepalives off
+	RoutingPreset string `json:"routingPreset,omitempty"` // routing preset the AllowedIPs come from
+	Routing       string `json:"routing,omitempty"`       // split tunnel mode the AllowedIPs were set with
+	AllowedIPs    string `json:"allowedIps,omitempty"`
+
+	// LeakProtection keeps DNS queries inside the tunnel on platforms
+	// whose clients run interface hooks
+	LeakProtection bool `json:"leakProtection,omitempty"`
+
+	// MTU overrides the default of the device's network type, which is
+	// assumed from the device type unless NetworkType is set
+	MTU         int    `json:"mtu,omitempty"`
+	NetworkType string `json:"networkType,omitempty"`
+
+	// Obfuscated configs reach the server through its wrapper endpoint,
+	// over TCP, for networks that block UDP
+	Obfuscated bool `json:"obfuscated,omitempty"`
+
+	// Interface is the server interface the peer is on, the primary one
+	// if empty
+	Interface string `json:"interface,omitempty"`
+
+	// RoutedSubnets are the networks behind a gateway peer, routed to it
+	// by the server
+	RoutedSubnets []string `json:"routedSubnets,omitempty"`
+
+	// ExitServer is the server internet traffic leaves at, over a link
+	// from the peer's own server; empty for its own
+	ExitServer string `json:"exitServer,omitempty"`
+
+	// Routes are internal networks behind gateways the peer routes to
+	Routes []string `json:"routes,omitempty"`
+
+	// ForwardedPort is the port of the server's egress interface forwarded
+	// to the same port of the peer, zero for none
+	ForwardedPort int `json:"forwardedPort,omitempty"`
 }
 
 // PeerInfo represents information about a WireGuard peer
@@ -50,6 +193,39 @@ type PeerInfo struct {
 	LastSeen   string `json:"lastSeen"`
 	BytesRx    int64  `json:"bytesRx"`
 	BytesTx    int64  `json:"bytesTx"`
+	Dynamic    bool   `json:"dynamic"`
+	SessionID  string `json:"sessionId,omitempty"`
+	ExpiresAt  string `json:"expiresAt,omitempty"`
+	DNSProfile string `json:"dnsProfile,omitempty"`
+	Keepalive  int    `json:"keepalive"` // persistent keepalive in seconds, 0 when off
+	Status     string `json:"status"`
+}
+
+const (
+	// PeerStatusProvisioned means the peer exists but has no recent handshake
+	PeerStatusProvisioned = "provisioned"
+	// PeerStatusSessionActive means the peer has completed a recent handshake
+	PeerStatusSessionActive = "session_active"
+	// PeerStatusArchived means the peer was archived for inactivity
+	PeerStatusArchived = "archived"
+	// PeerStatusPendingApproval means the peer's key awaits admin approval
+	PeerStatusPendingApproval = "pending_approval"
+
+	// ClientPrivateKeyPlaceholder stands in for the private key in configs
+	// of peers whose key pair was generated on the device
+	ClientPrivateKeyPlaceholder = "<device private key>"
+
+	// activeHandshakeWindow is how recent a handshake must be for a session to
+	// count as active; WireGuard re-handshakes every two minutes under traffic
+	activeHandshakeWindow = 3 * time.Minute
+)
+
+// PeerStatus returns the status of a peer given its latest handshake
+func PeerStatus(lastHandshake time.Time) string {
+	if !lastHandshake.IsZero() && time.Since(lastHandshake) < activeHandshakeWindow {
+		return PeerStatusSessionActive
+	}
+	return PeerStatusProvisioned
 }
 
 // NewPeerManager creates a new peer manager
@@ -69,28 +245,41 @@ func NewPeerManager(cfg *config.Config) *PeerManager {
 	}
 }
 
+// SetApplyObserver sets the observer notified of peer apply outcomes
+func (pm *PeerManager) SetApplyObserver(observer ApplyObserver) {
+	pm.applyObserver = observer
+}
+
 // CreatePeer creates a new WireGuard peer
-func (pm *PeerManager) CreatePeer(userID, serverID, deviceType, deviceName string) (*PeerConfig, error) {
+func (pm *PeerManager) CreatePeer(ctx context.Context, userID, serverID, deviceType, deviceName string, opts PeerOptions) (peer *PeerConfig, err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.CreatePeer", attribute.String("server.id", serverID))
+	defer func() { tracing.End(span, err) }()
+
 	peerMutex.Lock()
 	defer peerMutex.Unlock()
 
+	// Give up if the deadline passed while waiting for other peer operations
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Generate peer ID
 	peerID := utils.GenerateUUID()
 
 	// Generate key pair
-	privateKey, publicKey, err := generateKeyPair()
+	privateKey, publicKey, err := GenerateKeyPair()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate key pair: %v", err)
 	}
 
 	// Allocate IP address
-	ip, err := pm.allocateIP()
+	ip, err := pm.allocateIP(userID, opts.Interface)
 	if err != nil {
 		return nil, fmt.Errorf("failed to allocate IP address: %v", err)
 	}
 
 	// Create peer config
-	peer := &PeerConfig{
+	peer = &PeerConfig{
 		ID:         peerID,
 		UserID:     userID,
 		ServerID:   serverID,
@@ -101,8 +290,9 @@ func (pm *PeerManager) CreatePeer(userID, serverID, deviceType, deviceName strin
 		IP:         ip,
 		ServerIP:   pm.config.WireGuard.ServerIP,
 		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-		Dynamic:    false,
+		UpdatedAt:   time.Now(),
+		Dynamic:     false,
+		PeerOptions: opts,
 	}
 
 	// Save peer config
@@ -110,36 +300,276 @@ func (pm *PeerManager) CreatePeer(userID, serverID, deviceType, deviceName strin
 		return nil, fmt.Errorf("failed to save peer config: %v", err)
 	}
 
-	// Apply configuration
-	if err := pm.applyConfiguration(); err != nil {
-		return nil, fmt.Errorf("failed to apply configuration: %v", err)
+	// Apply configuration, removing the saved config again if the node rejects it
+	if err := pm.apply(ctx, peer.ServerID, ApplyOperationAdd); err != nil {
+		if err := pm.deletePeerConfig(peer); err != nil {
+			utils.LogErrorContext(ctx, "Failed to roll back peer config %s: %v", peer.ID, err)
+		}
+		return nil, &ApplyError{ServerID: peer.ServerID, Err: err}
 	}
 
 	return peer, nil
 }
 
+// CreateClientKeyPeer creates a static peer for a key pair generated on the
+// device. Pending peers are only saved; they get an address and are applied
+// once approved.
+func (pm *PeerManager) CreateClientKeyPeer(ctx context.Context, userID, serverID, deviceType, deviceName string, key ClientKey, opts PeerOptions) (peer *PeerConfig, err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.CreateClientKeyPeer", attribute.String("server.id", serverID))
+	defer func() { tracing.End(span, err) }()
+
+	if !ValidKey(key.PublicKey) {
+		return nil, fmt.Errorf("invalid public key")
+	}
+
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	// Give up if the deadline passed while waiting for other peer operations
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Create peer config
+	now := time.Now()
+	peer = &PeerConfig{
+		ID:              utils.GenerateUUID(),
+		UserID:          userID,
+		ServerID:        serverID,
+		DeviceType:      deviceType,
+		DeviceName:      deviceName,
+		PublicKey:       key.PublicKey,
+		ServerIP:        pm.config.WireGuard.ServerIP,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		PeerOptions:     opts,
+		ClientKey:       true,
+		Tag:             key.Tag,
+		PendingApproval: key.Pending,
+	}
+
+	if key.Pending {
+		if err := pm.savePeerConfig(peer); err != nil {
+			return nil, fmt.Errorf("failed to save peer config: %v", err)
+		}
+		return peer, nil
+	}
+
+	// Allocate IP address
+	peer.IP, err = pm.allocateIP(userID, peer.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate IP address: %v", err)
+	}
+
+	// Save peer config
+	if err := pm.savePeerConfig(peer); err != nil {
+		return nil, fmt.Errorf("failed to save peer config: %v", err)
+	}
+
+	// Apply configuration, removing the saved config again if the node rejects it
+	if err := pm.apply(ctx, peer.ServerID, ApplyOperationAdd); err != nil {
+		if err := pm.deletePeerConfig(peer); err != nil {
+			utils.LogErrorContext(ctx, "Failed to roll back peer config %s: %v", peer.ID, err)
+		}
+		return nil, &ApplyError{ServerID: peer.ServerID, Err: err}
+	}
+
+	return peer, nil
+}
+
+// RestorePeer creates a static peer with the keys of a device restored from
+// a backup, so the device keeps its key pair on a new account or deployment.
+// Without a private key the key pair lives on the device only. The peer gets
+// a newly allocated address, or is held for approval like a client key peer
+// if the key is pending.
+func (pm *PeerManager) RestorePeer(ctx context.Context, userID, serverID, deviceType, deviceName string, key ClientKey, privateKey string, opts PeerOptions) (peer *PeerConfig, err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.RestorePeer", attribute.String("server.id", serverID))
+	defer func() { tracing.End(span, err) }()
+
+	if !ValidKey(key.PublicKey) {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	if privateKey != "" {
+		derived, err := publicKeyOf(privateKey)
+		if err != nil || derived != key.PublicKey {
+			return nil, fmt.Errorf("private key does not match public key")
+		}
+	}
+
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	// Give up if the deadline passed while waiting for other peer operations
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Create peer config
+	now := time.Now()
+	peer = &PeerConfig{
+		ID:              utils.GenerateUUID(),
+		UserID:          userID,
+		ServerID:        serverID,
+		DeviceType:      deviceType,
+		DeviceName:      deviceName,
+		PublicKey:       key.PublicKey,
+		PrivateKey:      privateKey,
+		ServerIP:        pm.config.WireGuard.ServerIP,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		PeerOptions:     opts,
+		ClientKey:       privateKey == "",
+		Tag:             key.Tag,
+		PendingApproval: key.Pending,
+	}
+
+	if key.Pending {
+		if err := pm.savePeerConfig(peer); err != nil {
+			return nil, fmt.Errorf("failed to save peer config: %v", err)
+		}
+		return peer, nil
+	}
+
+	// Allocate IP address
+	peer.IP, err = pm.allocateIP(userID, peer.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate IP address: %v", err)
+	}
+
+	// Save peer config
+	if err := pm.savePeerConfig(peer); err != nil {
+		return nil, fmt.Errorf("failed to save peer config: %v", err)
+	}
+
+	// Apply configuration, removing the saved config again if the node rejects it
+	if err := pm.apply(ctx, peer.ServerID, ApplyOperationAdd); err != nil {
+		if err := pm.deletePeerConfig(peer); err != nil {
+			utils.LogErrorContext(ctx, "Failed to roll back peer config %s: %v", peer.ID, err)
+		}
+		return nil, &ApplyError{ServerID: peer.ServerID, Err: err}
+	}
+
+	return peer, nil
+}
+
+// ApprovePeer applies a peer held for approval on its server with a newly
+// allocated address, tagging it if a tag is given
+func (pm *PeerManager) ApprovePeer(ctx context.Context, userID, peerID, tag string) (peer *PeerConfig, err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.ApprovePeer", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	// Get peer config
+	peer, err = pm.getPeerConfig(userID, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer config: %v", err)
+	}
+	if !peer.Pending() {
+		return nil, fmt.Errorf("peer is not pending approval: %s", peerID)
+	}
+
+	// Allocate IP address
+	ip, err := pm.allocateIP(userID, peer.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate IP address: %v", err)
+	}
+
+	previousTag := peer.Tag
+	peer.IP = ip
+	peer.PendingApproval = false
+	if tag != "" {
+		peer.Tag = tag
+	}
+	peer.UpdatedAt = time.Now()
+	if err := pm.savePeerConfig(peer); err != nil {
+		return nil, fmt.Errorf("failed to save peer config: %v", err)
+	}
+
+	// Apply configuration, holding the peer again if the node rejects it
+	if err := pm.apply(ctx, peer.ServerID, ApplyOperationAdd); err != nil {
+		peer.IP = ""
+		peer.PendingApproval = true
+		peer.Tag = previousTag
+		if err := pm.savePeerConfig(peer); err != nil {
+			utils.LogErrorContext(ctx, "Failed to roll back peer config %s: %v", peer.ID, err)
+		}
+		return nil, &ApplyError{ServerID: peer.ServerID, Err: err}
+	}
+
+	return peer, nil
+}
+
+// RejectPeer deletes a peer held for approval. It was never applied, so no
+// node has to be updated.
+func (pm *PeerManager) RejectPeer(userID, peerID string) (*PeerConfig, error) {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	// Get peer config
+	peer, err := pm.getPeerConfig(userID, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer config: %v", err)
+	}
+	if !peer.Pending() {
+		return nil, fmt.Errorf("peer is not pending approval: %s", peerID)
+	}
+
+	if err := pm.deletePeerConfig(peer); err != nil {
+		return nil, fmt.Errorf("failed to delete peer config: %v", err)
+	}
+
+	return peer, nil
+}
+
+// FindPeerByPublicKey gets the static peer using a public key, if any
+func (pm *PeerManager) FindPeerByPublicKey(publicKey string) (*PeerConfig, error) {
+	peers, err := pm.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, peer := range peers {
+		if peer.PublicKey == publicKey {
+			return peer, nil
+		}
+	}
+
+	return nil, nil
+}
+
 // CreateDynamicPeer creates a new dynamic WireGuard peer
-func (pm *PeerManager) CreateDynamicPeer(userID, serverID, deviceType, deviceName string) (*PeerConfig, error) {
+func (pm *PeerManager) CreateDynamicPeer(ctx context.Context, userID, serverID, deviceType, deviceName string, opts PeerOptions) (peer *PeerConfig, err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.CreateDynamicPeer", attribute.String("server.id", serverID))
+	defer func() { tracing.End(span, err) }()
+
 	peerMutex.Lock()
 	defer peerMutex.Unlock()
 
+	// Give up if the deadline passed while waiting for other peer operations
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Generate peer ID
 	peerID := utils.GenerateUUID()
 
 	// Generate key pair
-	privateKey, publicKey, err := generateKeyPair()
+	privateKey, publicKey, err := GenerateKeyPair()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate key pair: %v", err)
 	}
 
 	// Allocate IP address
-	ip, err := pm.allocateIP()
+	ip, err := pm.allocateIP(userID, opts.Interface)
 	if err != nil {
 		return nil, fmt.Errorf("failed to allocate IP address: %v", err)
 	}
 
-	// Create peer config
-	peer := &PeerConfig{
+	// Create peer config with a session that lasts for the configured TTL
+	now := time.Now()
+	peer = &PeerConfig{
 		ID:         peerID,
 		UserID:     userID,
 		ServerID:   serverID,
@@ -149,9 +579,12 @@ func (pm *PeerManager) CreateDynamicPeer(userID, serverID, deviceType, deviceNam
 		PrivateKey: privateKey,
 		IP:         ip,
 		ServerIP:   pm.config.WireGuard.ServerIP,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		CreatedAt:  now,
+		UpdatedAt:  now,
 		Dynamic:    true,
+		SessionID:   utils.GenerateUUID(),
+		ExpiresAt:   now.Add(pm.dynamicPeerTTL()),
+		PeerOptions: opts,
 	}
 
 	// Save peer config
@@ -159,19 +592,30 @@ func (pm *PeerManager) CreateDynamicPeer(userID, serverID, deviceType, deviceNam
 		return nil, fmt.Errorf("failed to save dynamic peer config: %v", err)
 	}
 
-	// Apply configuration
-	if err := pm.applyConfiguration(); err != nil {
-		return nil, fmt.Errorf("failed to apply configuration: %v", err)
+	// Apply configuration, removing the saved config again if the node rejects it
+	if err := pm.apply(ctx, peer.ServerID, ApplyOperationAdd); err != nil {
+		if err := pm.deleteDynamicPeerConfig(peer); err != nil {
+			utils.LogErrorContext(ctx, "Failed to roll back dynamic peer config %s: %v", peer.ID, err)
+		}
+		return nil, &ApplyError{ServerID: peer.ServerID, Err: err}
 	}
 
 	return peer, nil
 }
 
 // RemovePeer removes a WireGuard peer
-func (pm *PeerManager) RemovePeer(userID, peerID string) error {
+func (pm *PeerManager) RemovePeer(ctx context.Context, userID, peerID string) (err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.RemovePeer", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
 	peerMutex.Lock()
 	defer peerMutex.Unlock()
 
+	// Give up if the deadline passed while waiting for other peer operations
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Get peer config
 	peer, err := pm.getPeerConfig(userID, peerID)
 	if err != nil {
@@ -184,7 +628,7 @@ func (pm *PeerManager) RemovePeer(userID, peerID string) error {
 	}
 
 	// Apply configuration
-	if err := pm.applyConfiguration(); err != nil {
+	if err := pm.apply(ctx, peer.ServerID, ApplyOperationRemove); err != nil {
 		return fmt.Errorf("failed to apply configuration: %v", err)
 	}
 
@@ -192,10 +636,18 @@ func (pm *PeerManager) RemovePeer(userID, peerID string) error {
 }
 
 // RemoveDynamicPeer removes a dynamic WireGuard peer
-func (pm *PeerManager) RemoveDynamicPeer(userID, peerID string) error {
+func (pm *PeerManager) RemoveDynamicPeer(ctx context.Context, userID, peerID string) (err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.RemoveDynamicPeer", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
 	peerMutex.Lock()
 	defer peerMutex.Unlock()
 
+	// Give up if the deadline passed while waiting for other peer operations
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Get peer config
 	peer, err := pm.getDynamicPeerConfig(userID, peerID)
 	if err != nil {
@@ -208,13 +660,349 @@ func (pm *PeerManager) RemoveDynamicPeer(userID, peerID string) error {
 	}
 
 	// Apply configuration
-	if err := pm.applyConfiguration(); err != nil {
+	if err := pm.apply(ctx, peer.ServerID, ApplyOperationRemove); err != nil {
 		return fmt.Errorf("failed to apply configuration: %v", err)
 	}
 
 	return nil
 }
 
+// MovePeer moves a static peer to another user. The peer keeps its keys and
+// address, so nothing changes on the node and the device stays connected.
+func (pm *PeerManager) MovePeer(fromUserID, peerID, toUserID string) (*PeerConfig, error) {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	// Get peer config
+	peer, err := pm.getPeerConfig(fromUserID, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer config: %v", err)
+	}
+
+	// Create target user directory if it doesn't exist
+	userDir := filepath.Join(pm.config.WireGuard.ConfigDir, toUserID)
+	if err := os.MkdirAll(userDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create user directory: %v", err)
+	}
+
+	// Move peer directory
+	fromDir := filepath.Join(pm.config.WireGuard.ConfigDir, fromUserID, peerID)
+	toDir := filepath.Join(userDir, peerID)
+	if _, err := os.Stat(toDir); err == nil {
+		return nil, fmt.Errorf("peer already exists for user %s: %s", toUserID, peerID)
+	}
+	if err := os.Rename(fromDir, toDir); err != nil {
+		return nil, fmt.Errorf("failed to move peer directory: %v", err)
+	}
+
+	// Save peer metadata with the new owner, moving the directory back on failure
+	peer.UserID = toUserID
+	peer.UpdatedAt = time.Now()
+	if err := pm.savePeerConfig(peer); err != nil {
+		if renameErr := os.Rename(toDir, fromDir); renameErr != nil {
+			utils.LogError("Failed to move peer %s back to user %s: %v", peerID, fromUserID, renameErr)
+		}
+		return nil, err
+	}
+
+	return peer, nil
+}
+
+// UpdateRouting sets the AllowedIPs of every static and dynamic peer on a
+// routing preset and returns the updated peers. An empty allowedIPs detaches
+// the peers from the preset so the server default applies. Only client
+// configs route by AllowedIPs, so nodes need no reapply.
+func (pm *PeerManager) UpdateRouting(preset, allowedIPs string) ([]*PeerConfig, error) {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	// Get peers of all users
+	peers, err := pm.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+	dynamicPeers, err := pm.ListDynamicPeers()
+	if err != nil {
+		return nil, err
+	}
+	peers = append(peers, dynamicPeers...)
+
+	updated := []*PeerConfig{}
+	for _, peer := range peers {
+		if peer.RoutingPreset != preset || peer.AllowedIPs == allowedIPs {
+			continue
+		}
+
+		if allowedIPs == "" {
+			peer.RoutingPreset = ""
+		}
+		peer.AllowedIPs = allowedIPs
+		peer.UpdatedAt = time.Now()
+
+		save := pm.savePeerConfig
+		if peer.Dynamic {
+			save = pm.saveDynamicPeerConfig
+		}
+		if err := save(peer); err != nil {
+			return updated, fmt.Errorf("failed to save peer %s: %v", peer.ID, err)
+		}
+		updated = append(updated, peer)
+	}
+
+	return updated, nil
+}
+
+// SetRouting sets the AllowedIPs of a static or dynamic peer chosen for the
+// peer itself, detaching it from its routing preset. An empty mode returns
+// the peer to the server default. Only client configs route by AllowedIPs,
+// so nodes need no reapply.
+func (pm *PeerManager) SetRouting(userID, peerID, mode, allowedIPs string) (*PeerConfig, error) {
+	return pm.updatePeer(userID, peerID, func(peer *PeerConfig) {
+		peer.RoutingPreset = ""
+		peer.Routing = mode
+		peer.AllowedIPs = allowedIPs
+	})
+}
+
+// SetMTU sets the MTU override and network type of a static or dynamic
+// peer; zero and empty return them to the defaults
+func (pm *PeerManager) SetMTU(userID, peerID string, mtu int, networkType string) (*PeerConfig, error) {
+	return pm.updatePeer(userID, peerID, func(peer *PeerConfig) {
+		peer.MTU = mtu
+		peer.NetworkType = networkType
+	})
+}
+
+// SetKeepalive sets the persistent keepalive of a static or dynamic peer:
+// seconds, KeepaliveOff, or zero for the server default
+func (pm *PeerManager) SetKeepalive(userID, peerID string, keepalive int) (*PeerConfig, error) {
+	return pm.updatePeer(userID, peerID, func(peer *PeerConfig) {
+		peer.Keepalive = keepalive
+	})
+}
+
+// updatePeer changes the settings of a static or dynamic peer and saves it.
+// Only the config changes; the node needs no update.
+func (pm *PeerManager) updatePeer(userID, peerID string, update func(peer *PeerConfig)) (*PeerConfig, error) {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	peer, err := pm.GetPeer(userID, peerID)
+	if err != nil {
+		return nil, err
+	}
+
+	update(peer)
+	peer.UpdatedAt = time.Now()
+
+	save := pm.savePeerConfig
+	if peer.Dynamic {
+		save = pm.saveDynamicPeerConfig
+	}
+	if err := save(peer); err != nil {
+		return nil, err
+	}
+
+	return peer, nil
+}
+
+// ArchivePeer archives a static peer: it is removed from its node and its
+// address released, while its keys and settings are kept for reactivation
+func (pm *PeerManager) ArchivePeer(ctx context.Context, userID, peerID string) (peer *PeerConfig, err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.ArchivePeer", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	// Get peer config
+	peer, err = pm.getPeerConfig(userID, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer config: %v", err)
+	}
+	if peer.Archived() {
+		return nil, fmt.Errorf("peer is already archived: %s", peerID)
+	}
+
+	// Save peer metadata without the address
+	peer.IP = ""
+	peer.ArchivedAt = time.Now()
+	peer.UpdatedAt = time.Now()
+	if err := pm.savePeerConfig(peer); err != nil {
+		return nil, fmt.Errorf("failed to save peer config: %v", err)
+	}
+
+	// Apply configuration
+	if err := pm.apply(ctx, peer.ServerID, ApplyOperationRemove); err != nil {
+		return nil, fmt.Errorf("failed to apply configuration: %v", err)
+	}
+
+	return peer, nil
+}
+
+// ReactivatePeer restores an archived peer on its server with a newly
+// allocated address. The peer keeps its keys, but its config must be
+// downloaded again for the new address.
+func (pm *PeerManager) ReactivatePeer(ctx context.Context, userID, peerID string) (peer *PeerConfig, err error) {
+	ctx, span := tracing.Start(ctx, "PeerManager.ReactivatePeer", attribute.String("peer.id", peerID))
+	defer func() { tracing.End(span, err) }()
+
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	// Get peer config
+	peer, err = pm.getPeerConfig(userID, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer config: %v", err)
+	}
+	if !peer.Archived() {
+		return nil, fmt.Errorf("peer is not archived: %s", peerID)
+	}
+
+	// Allocate IP address
+	ip, err := pm.allocateIP(userID, peer.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate IP address: %v", err)
+	}
+
+	archivedAt := peer.ArchivedAt
+	peer.IP = ip
+	peer.ArchivedAt = time.Time{}
+	peer.InactiveNotified = time.Time{}
+	peer.LastActive = time.Now()
+	peer.UpdatedAt = time.Now()
+	if err := pm.savePeerConfig(peer); err != nil {
+		return nil, fmt.Errorf("failed to save peer config: %v", err)
+	}
+
+	// Apply configuration, archiving the peer again if the node rejects it
+	if err := pm.apply(ctx, peer.ServerID, ApplyOperationAdd); err != nil {
+		peer.IP = ""
+		peer.ArchivedAt = archivedAt
+		if err := pm.savePeerConfig(peer); err != nil {
+			utils.LogErrorContext(ctx, "Failed to roll back peer config %s: %v", peer.ID, err)
+		}
+		return nil, &ApplyError{ServerID: peer.ServerID, Err: err}
+	}
+
+	return peer, nil
+}
+
+// RecordActivity saves the latest handshake of a static peer and whether
+// the user has been told it is idle
+func (pm *PeerManager) RecordActivity(peer *PeerConfig, lastActive, notified time.Time) error {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	peer.LastActive = lastActive
+	peer.InactiveNotified = notified
+	peer.UpdatedAt = time.Now()
+
+	if err := pm.savePeerConfig(peer); err != nil {
+		return fmt.Errorf("failed to save peer config: %v", err)
+	}
+
+	return nil
+}
+
+// RenewDynamicPeer extends a dynamic peer's session after a handshake
+func (pm *PeerManager) RenewDynamicPeer(peer *PeerConfig, handshake time.Time) error {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	peer.LastHandshake = handshake
+	peer.ExpiresAt = handshake.Add(pm.dynamicPeerTTL())
+	peer.UpdatedAt = time.Now()
+
+	if err := pm.saveDynamicPeerConfig(peer); err != nil {
+		return fmt.Errorf("failed to save dynamic peer config: %v", err)
+	}
+
+	return nil
+}
+
+// ListPeers gets the static peers of all users
+func (pm *PeerManager) ListPeers() ([]*PeerConfig, error) {
+	entries, err := os.ReadDir(pm.config.WireGuard.ConfigDir)
+	if os.IsNotExist(err) {
+		return []*PeerConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config directory: %v", err)
+	}
+
+	peers := []*PeerConfig{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		userPeers, err := pm.getStaticPeers(entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get static peers: %v", err)
+		}
+		peers = append(peers, userPeers...)
+	}
+
+	return peers, nil
+}
+
+// ListDynamicPeers gets the dynamic peers of all users
+func (pm *PeerManager) ListDynamicPeers() ([]*PeerConfig, error) {
+	entries, err := os.ReadDir(pm.config.WireGuard.DynamicPeerDir)
+	if os.IsNotExist(err) {
+		return []*PeerConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dynamic peer directory: %v", err)
+	}
+
+	peers := []*PeerConfig{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		userPeers, err := pm.getDynamicPeers(entry.Name())
+		if err != nil {
+			utils.LogError("Failed to get dynamic peers: %v", err)
+			continue
+		}
+		peers = append(peers, userPeers...)
+	}
+
+	return peers, nil
+}
+
+// LatestHandshakes gets the latest handshake time of every peer on the
+// interfaces, keyed by public key
+func (pm *PeerManager) LatestHandshakes(ctx context.Context) map[string]time.Time {
+	handshakes := make(map[string]time.Time)
+
+	for _, iface := range Interfaces(pm.config) {
+		device, err := pm.localDriver().Device(iface.Name)
+		if err != nil {
+			utils.LogDebug("Failed to read latest handshakes: %v", err)
+			continue
+		}
+
+		// Peers that never completed a handshake have the zero time
+		for _, peer := range device.Peers {
+			if !peer.LastHandshakeTime.IsZero() {
+				handshakes[peer.PublicKey.String()] = peer.LastHandshakeTime
+			}
+		}
+	}
+
+	return handshakes
+}
+
+// dynamicPeerTTL returns how long a dynamic peer session lasts without a handshake
+func (pm *PeerManager) dynamicPeerTTL() time.Duration {
+	if pm.config.WireGuard.DynamicPeerTTL <= 0 {
+		return time.Hour
+	}
+	return time.Duration(pm.config.WireGuard.DynamicPeerTTL) * time.Minute
+}
+
 // GetPeer gets a WireGuard peer
 func (pm *PeerManager) GetPeer(userID, peerID string) (*PeerConfig, error) {
 	// Try to get static peer first
@@ -310,25 +1098,120 @@ func (pm *PeerManager) getDynamicPeers(userID string) ([]*PeerConfig, error) {
 
 // GenerateConfig generates a WireGuard configuration for a peer
 func (pm *PeerManager) GenerateConfig(peer *PeerConfig) (string, error) {
+	rendered, err := pm.RenderConfig(peer)
+	if err != nil {
+		return "", err
+	}
+
+	return rendered.Config, nil
+}
+
+// RenderConfig generates a WireGuard configuration for a peer along with
+// the template and parameter fingerprints used to produce it
+func (pm *PeerManager) RenderConfig(peer *PeerConfig) (*RenderedConfig, error) {
+	rendered, err := RenderPeerConfig(pm.config, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	return pm.renderMesh(rendered, peer)
+}
+
+// RenderPeerConfig renders the client configuration for a peer. Static and
+// dynamic peers share this path so every WireGuardConfig field is honoured.
+func RenderPeerConfig(cfg *config.Config, peer *PeerConfig) (*RenderedConfig, error) {
 	// Get template based on device type
-	template, err := getConfigTemplate(peer.DeviceType)
+	templateName, template, err := getConfigTemplate(cfg, peer.DeviceType)
 	if err != nil {
-		return "", fmt.Errorf("failed to get config template: %v", err)
+		return nil, fmt.Errorf("failed to get config template: %v", err)
 	}
 
 	// Replace placeholders
-	config := template
-	config = replaceConfigPlaceholders(config, map[string]string{
-		"PRIVATE_KEY":        peer.PrivateKey,
-		"CLIENT_IP":          peer.IP,
-		"SERVER_PUBLIC_KEY":  pm.config.WireGuard.PublicKey,
-		"SERVER_ENDPOINT":    fmt.Sprintf("%s:%d", pm.config.WireGuard.ServerEndpoint, pm.config.WireGuard.ListenPort),
-		"DNS":                pm.config.WireGuard.DNS,
-		"ALLOWED_IPS":        pm.config.WireGuard.AllowedIPs,
-		"PERSISTENT_KEEPALIVE": "25",
-	})
+	endpoint := peerEndpoint(cfg, peer)
+	replacements := ConfigParams(cfg, peer)
+	config, err := replaceConfigPlaceholders(template, replacements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s template: %v", templateName, err)
+	}
+
+	// Validate the final config before it reaches the client
+	if errs := ValidateConfig(config); len(errs) > 0 {
+		return nil, fmt.Errorf("rendered %s config is invalid: %v", templateName, errs[0])
+	}
+
+	return &RenderedConfig{
+		Config:          config,
+		TemplateName:    templateName,
+		TemplateVersion: fingerprint(template),
+		ParamsHash:      paramsHash(peer, replacements),
+		Endpoint:        endpoint,
+	}, nil
+}
+
+// ConfigParams maps a peer and the WireGuard settings to template placeholders.
+// Unset settings are left empty so the template or shared defaults apply.
+func ConfigParams(cfg *config.Config, peer *PeerConfig) map[string]string {
+	privateKey := peer.PrivateKey
+	if peer.ClientKey {
+		privateKey = ClientPrivateKeyPlaceholder
+	}
+
+	params := map[string]string{
+		"PRIVATE_KEY":          privateKey,
+		"CLIENT_IP":            peer.IP,
+		"SERVER_PUBLIC_KEY":    cfg.WireGuard.PublicKey,
+		"SERVER_ENDPOINT":      peerEndpoint(cfg, peer),
+		"DNS":                  cfg.WireGuard.DNS,
+		"ALLOWED_IPS":          cfg.WireGuard.AllowedIPs,
+		"MTU":                  "",
+		"PERSISTENT_KEEPALIVE": "",
+		"INTERFACE_EXTRAS":     "",
+	}
+	if mtu := PeerMTU(cfg, peer); mtu > 0 {
+		params["MTU"] = strconv.Itoa(mtu)
+	}
+	if cfg.WireGuard.Keepalive > 0 {
+		params["PERSISTENT_KEEPALIVE"] = strconv.Itoa(cfg.WireGuard.Keepalive)
+	}
 
-	return config, nil
+	// Apply the peer's own settings over the server defaults
+	if peer.DNS != "" {
+		params["DNS"] = peer.DNS
+	}
+	if profile, ok := profileDNS(cfg.WireGuard.DNSProfiles, peer); ok {
+		params["DNS"] = profile.Servers
+	}
+	if peer.Keepalive == KeepaliveOff {
+		params["PERSISTENT_KEEPALIVE"] = "off"
+	} else if peer.Keepalive > 0 {
+		params["PERSISTENT_KEEPALIVE"] = strconv.Itoa(peer.Keepalive)
+	}
+	if peer.AllowedIPs != "" {
+		params["ALLOWED_IPS"] = peer.AllowedIPs
+	}
+	params["ALLOWED_IPS"] = exitAllowedIPs(params["ALLOWED_IPS"], peer)
+	if peer.Gateway() {
+		// Routers only send the VPN's traffic through the tunnel and keep
+		// their own DNS
+		if peer.AllowedIPs == "" {
+			params["ALLOWED_IPS"] = gatewayAllowedIPs(cfg)
+		}
+	} else if peer.KillSwitch {
+		params["INTERFACE_EXTRAS"] = killSwitchRules(peer.DeviceType)
+	} else if peer.LeakProtection {
+		// The kill switch already blocks DNS outside the tunnel
+		params["INTERFACE_EXTRAS"] = leakProtectionRules(peer.DeviceType)
+	}
+	if peer.Obfuscated && cfg.WireGuard.Obfuscation.Enabled {
+		obfuscatedParams(cfg, params)
+	}
+
+	return params
+}
+
+// ServerEndpoint returns the endpoint clients are configured to connect to
+func ServerEndpoint(cfg *config.Config) string {
+	return fmt.Sprintf("%s:%d", cfg.WireGuard.ServerEndpoint, cfg.WireGuard.ListenPort)
 }
 
 // savePeerConfig saves a peer configuration
@@ -443,67 +1326,193 @@ func (pm *PeerManager) deleteDynamicPeerConfig(peer *PeerConfig) error {
 	return nil
 }
 
-// allocateIP allocates an IP address for a peer
-func (pm *PeerManager) allocateIP() (string, error) {
-	// In a real implementation, this would allocate an IP from a pool
-	// For now, we'll just return a mock IP
-	return "10.0.0.2/32", nil
+// allocateIP allocates an address to a peer of a user on an interface: the
+// first free address reserved to the user, or else the lowest free address
+// in the interface subnet that is not reserved. The caller must hold
+// peerMutex.
+func (pm *PeerManager) allocateIP(userID, interfaceName string) (string, error) {
+	iface, err := findInterface(pm.config, interfaceName)
+	if err != nil {
+		return "", err
+	}
+	subnet, err := interfaceSubnet(iface)
+	if err != nil {
+		return "", err
+	}
+	used, err := pm.usedIPs()
+	if err != nil {
+		return "", err
+	}
+	reservations, err := pm.reservations()
+	if err != nil {
+		return "", err
+	}
+
+	reserved := make(map[string]bool, len(reservations))
+	for _, reservation := range reservations {
+		ip := net.ParseIP(reservation.IP).To4()
+		if ip == nil {
+			continue
+		}
+		if userID != "" && reservation.UserID == userID && !used[ip.String()] && subnet.Contains(ip) {
+			return ip.String() + "/32", nil
+		}
+		reserved[ip.String()] = true
+	}
+
+	// Skip the network address, and stop before the broadcast address
+	for ip := nextIP(subnet.IP); subnet.Contains(nextIP(ip)); ip = nextIP(ip) {
+		if !used[ip.String()] && !reserved[ip.String()] {
+			return ip.String() + "/32", nil
+		}
+	}
+
+	return "", fmt.Errorf("no free addresses in %s", subnet)
+}
+
+// subnet gets the IPv4 subnet of the primary interface, which reserved
+// and imported addresses come from
+func (pm *PeerManager) subnet() (*net.IPNet, error) {
+	return interfaceSubnet(Interfaces(pm.config)[0])
+}
+
+// usedIPs gets the addresses taken by the server interfaces and by static
+// and dynamic peers. Pending and archived peers hold no address.
+func (pm *PeerManager) usedIPs() (map[string]bool, error) {
+	used := make(map[string]bool)
+	for _, iface := range Interfaces(pm.config) {
+		if ip, _, err := net.ParseCIDR(iface.Address); err == nil {
+			used[ip.String()] = true
+		}
+	}
+
+	static, err := pm.ListPeers()
+	if err != nil {
+		return nil, err
+	}
+	dynamic, err := pm.ListDynamicPeers()
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range append(static, dynamic...) {
+		if ip := peerIP(peer.IP); ip != nil {
+			used[ip.String()] = true
+		}
+	}
+
+	return used, nil
+}
+
+// peerIP parses a peer address with or without its /32 suffix
+func peerIP(address string) net.IP {
+	if ip, _, err := net.ParseCIDR(address); err == nil {
+		return ip
+	}
+	return net.ParseIP(address)
+}
+
+// nextIP returns the address following ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// apply applies the WireGuard configuration on a node and reports the outcome
+func (pm *PeerManager) apply(ctx context.Context, serverID, operation string) error {
+	// Bound each node apply so a hanging node leaves time to fail over
+	if timeout := pm.config.Timeouts.NodeApply; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	ctx, span := tracing.Start(ctx, "PeerManager.apply",
+		attribute.String("server.id", serverID),
+		attribute.String("apply.operation", operation),
+	)
+
+	started := time.Now()
+	err := pm.applyConfiguration(ctx)
+	duration := time.Since(started)
+	tracing.End(span, err)
+	if err != nil {
+		utils.LogErrorContext(ctx, "Failed to %s peer on server %s: %v", operation, serverID, err)
+	}
+
+	if pm.applyObserver != nil {
+		pm.applyObserver(serverID, operation, duration, err)
+	}
+
+	return err
 }
 
 // applyConfiguration applies the WireGuard configuration
-func (pm *PeerManager) applyConfiguration() error {
-	// In a real implementation, this would apply the configuration to WireGuard
-	// For now, we'll just log it
-	utils.LogInfo("Applying WireGuard configuration...")
+func (pm *PeerManager) applyConfiguration(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if pm.local {
+		return pm.syncInterface(ctx)
+	}
+
+	// Remote servers are not sent the change itself: their node agents
+	// fetch the full desired state of the server (NodeState) and reconcile
+	// their interfaces against it, so a missed update is corrected by the
+	// next sync
+	utils.LogInfoContext(ctx, "Updated desired WireGuard state; node agents reconcile on their next sync")
 	return nil
 }
 
-// generateKeyPair generates a WireGuard key pair
-func generateKeyPair() (string, string, error) {
-	// In a real implementation, this would use wg-quick to generate keys
-	// For now, we'll just return mock keys
-	privateKey := "YAnV4SnPYEA+jS6nQtxF5lS3jj0gqXBVVeP9tz/bP2A="
-	publicKey := "zzz3UBcqiV9RsYCzJWOU5VVVNk3VtQECQXXPnQiEfQQ="
-	return privateKey, publicKey, nil
+// ValidKey checks that a key is a base64 encoded 32-byte WireGuard key
+func ValidKey(key string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == 32
 }
 
-// getConfigTemplate gets a configuration template for a device type
-func getConfigTemplate(deviceType string) (string, error) {
-	// Map device type to template file
-	templateFile := "generic.conf"
-	switch strings.ToLower(deviceType) {
-	case "android":
-		templateFile = "android.conf"
-	case "ios", "iphone", "ipad":
-		templateFile = "ios.conf"
-	case "windows":
-		templateFile = "windows.conf"
-	case "mac", "macos":
-		templateFile = "mac.conf"
+// GenerateKeyPair generates a WireGuard key pair, returning the private key
+// and then the public key
+func GenerateKeyPair() (string, string, error) {
+	var privateKey [32]byte
+	if _, err := rand.Read(privateKey[:]); err != nil {
+		return "", "", fmt.Errorf("failed to generate private key: %v", err)
 	}
 
-	// Read template file
-	templatePath := filepath.Join("vpn/wireguard/config_templates", templateFile)
-	content, err := os.ReadFile(templatePath)
+	// Clamp the key as Curve25519 requires
+	privateKey[0] &= 248
+	privateKey[31] = (privateKey[31] & 127) | 64
+
+	publicKey, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
 	if err != nil {
-		return "", fmt.Errorf("failed to read template file: %v", err)
+		return "", "", fmt.Errorf("failed to derive public key: %v", err)
 	}
 
-	return string(content), nil
+	return base64.StdEncoding.EncodeToString(privateKey[:]), base64.StdEncoding.EncodeToString(publicKey), nil
 }
 
-// replaceConfigPlaceholders replaces placeholders in a configuration template
-func replaceConfigPlaceholders(template string, replacements map[string]string) string {
-	result := template
-	for key, value := range replacements {
-		result = strings.ReplaceAll(result, fmt.Sprintf("{{%s}}", key), value)
+// publicKeyOf derives the public key of a base64 encoded private key
+func publicKeyOf(privateKey string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("private key must be a base64 encoded 32 byte key")
+	}
+
+	publicKey, err := curve25519.X25519(decoded, curve25519.Basepoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive public key: %v", err)
 	}
-	return result
+
+	return base64.StdEncoding.EncodeToString(publicKey), nil
 }
 
-// GenerateQRCode generates a QR code for a WireGuard configuration
-func GenerateQRCode(config string) (string, error) {
-	// In a real implementation, this would generate a QR code
-	// For now, we'll just return a mock QR code
-	return "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8z8BQDwAEhQGAhKmMIQAAAABJRU5ErkJggg==", nil
+// replaceConfigPlaceholders replaces placeholders in a configuration template,
+// failing if any placeholder is left without a value or default
+func replaceConfigPlaceholders(template string, replacements map[string]string) (string, error) {
+	return renderTemplate(template, replacements, defaultPlaceholderValues)
 }
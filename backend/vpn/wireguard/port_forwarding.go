@@ -0,0 +1,65 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+)
+
+// SetPortForward forwards a free port of the egress interface of a static or
+// dynamic peer's server to the same port of the peer, or stops forwarding
+// when enabled is false. A peer already forwarded keeps its port.
+func (pm *PeerManager) SetPortForward(ctx context.Context, userID, peerID string, enabled bool) (*PeerConfig, error) {
+	var allocErr error
+	peer, err := pm.updatePeer(userID, peerID, func(peer *PeerConfig) {
+		switch {
+		case !enabled:
+			peer.ForwardedPort = 0
+		case peer.ForwardedPort == 0:
+			peer.ForwardedPort, allocErr = pm.freeForwardedPort(peer.ServerID)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if allocErr != nil {
+		return nil, allocErr
+	}
+
+	// The server translates the port to the peer
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	return peer, pm.applyConfiguration(ctx)
+}
+
+// freeForwardedPort gets the lowest port of the forwarding range no peer on
+// a server has. The caller holds peerMutex.
+func (pm *PeerManager) freeForwardedPort(serverID string) (int, error) {
+	settings := pm.config.WireGuard.Firewall
+	if settings.PortForwardMin <= 0 || settings.PortForwardMax < settings.PortForwardMin {
+		return 0, fmt.Errorf("port forwarding is not configured")
+	}
+
+	static, err := pm.ListPeers()
+	if err != nil {
+		return 0, err
+	}
+	dynamic, err := pm.ListDynamicPeers()
+	if err != nil {
+		return 0, err
+	}
+
+	used := make(map[int]bool)
+	for _, peer := range append(static, dynamic...) {
+		if peer.ServerID == serverID && peer.ForwardedPort != 0 {
+			used[peer.ForwardedPort] = true
+		}
+	}
+	for port := settings.PortForwardMin; port <= settings.PortForwardMax && port <= 65535; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free forwarded port on server %s", serverID)
+}
@@ -0,0 +1,95 @@
+package wireguard
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// QR code output formats
+const (
+	QRFormatPNG     = "png"
+	QRFormatDataURL = "data_url"
+)
+
+// QR code size limits in pixels
+const (
+	DefaultQRSize = 256
+	MinQRSize     = 128
+	MaxQRSize     = 1024
+)
+
+// qrLevels maps error-correction level names to their go-qrcode values
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"low":     qrcode.Low,
+	"medium":  qrcode.Medium,
+	"high":    qrcode.High,
+	"highest": qrcode.Highest,
+}
+
+// QROptions controls how a QR code is rendered. Zero values select a
+// 256px, medium error-correction PNG.
+type QROptions struct {
+	Size   int    // width and height in pixels
+	Level  string // low, medium, high or highest
+	Format string // png or data_url
+}
+
+// Validate checks the QR code options and fills in defaults
+func (o *QROptions) Validate() error {
+	if o.Size == 0 {
+		o.Size = DefaultQRSize
+	}
+	if o.Size < MinQRSize || o.Size > MaxQRSize {
+		return fmt.Errorf("QR code size must be between %d and %d", MinQRSize, MaxQRSize)
+	}
+
+	o.Level = strings.ToLower(o.Level)
+	if o.Level == "" {
+		o.Level = "medium"
+	}
+	if _, ok := qrLevels[o.Level]; !ok {
+		return fmt.Errorf("unknown QR code error-correction level: %s", o.Level)
+	}
+
+	if o.Format == "" {
+		o.Format = QRFormatPNG
+	}
+	if o.Format != QRFormatPNG && o.Format != QRFormatDataURL {
+		return fmt.Errorf("unknown QR code format: %s", o.Format)
+	}
+
+	return nil
+}
+
+// EncodeQRCode renders content as a QR code, returning raw PNG bytes or a
+// base64 data URL depending on the format option
+func EncodeQRCode(content string, opts QROptions) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	png, err := qrcode.Encode(content, qrLevels[opts.Level], opts.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate QR code: %v", err)
+	}
+
+	if opts.Format == QRFormatDataURL {
+		return []byte("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+	}
+
+	return png, nil
+}
+
+// GenerateQRCode generates a QR code for a WireGuard configuration as a
+// base64 data URL, for embedding in JSON responses
+func GenerateQRCode(config string) (string, error) {
+	qrCode, err := EncodeQRCode(config, QROptions{Format: QRFormatDataURL})
+	if err != nil {
+		return "", err
+	}
+
+	return string(qrCode), nil
+}
@@ -0,0 +1,309 @@
+package wireguard
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// placeholderPattern matches {{NAME}} and {{NAME|default}} placeholders
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*(?:\|([^}]*))?\}\}`)
+
+// defaultPlaceholderValues are used for placeholders that have no value and
+// no inline default in the template
+var defaultPlaceholderValues = map[string]string{
+	"PERSISTENT_KEEPALIVE": strconv.Itoa(defaultKeepalive),
+	"ALLOWED_IPS":          "0.0.0.0/0, ::/0",
+	"MTU":                  "1420",
+}
+
+// killSwitchRules returns the interface hooks that block traffic outside the
+// tunnel, on platforms whose clients run hooks. The others use their own
+// kill switch: mobile clients rely on the OS always-on VPN setting, the
+// macOS app on its on-demand rules, and WireGuard for Windows blocks
+// untunneled traffic by itself when it routes all traffic, and refuses to
+// import configs with hooks.
+func killSwitchRules(deviceType string) string {
+	switch strings.ToLower(deviceType) {
+	case "android", "ios", "iphone", "ipad", "windows", "macos", "mac":
+		return ""
+	}
+
+	rule := "OUTPUT ! -o %i -m mark ! --mark $(wg show %i fwmark) -m addrtype ! --dst-type LOCAL -j REJECT"
+	return "PostUp = iptables -I " + rule + " && ip6tables -I " + rule + "\n" +
+		"PreDown = iptables -D " + rule + " && ip6tables -D " + rule
+}
+
+// leakProtectionRules returns the interface hooks that keep DNS queries
+// inside the tunnel. Queries to other servers leaving by any other
+// interface are rejected; the firewall mark exempts the tunnel's own
+// packets. Mobile clients already send DNS through the tunnel, and the
+// Windows and macOS apps do not run hooks: WireGuard for Windows blocks
+// outside DNS by itself when it routes all traffic.
+func leakProtectionRules(deviceType string) string {
+	switch strings.ToLower(deviceType) {
+	case "android", "ios", "iphone", "ipad", "windows", "macos", "mac":
+		return ""
+	}
+
+	rules := []string{
+		"OUTPUT ! -o %i -m mark ! --mark $(wg show %i fwmark) -p udp --dport 53 -j REJECT",
+		"OUTPUT ! -o %i -m mark ! --mark $(wg show %i fwmark) -p tcp --dport 53 -j REJECT",
+	}
+	up := make([]string, 0, 2*len(rules))
+	down := make([]string, 0, 2*len(rules))
+	for _, rule := range rules {
+		up = append(up, "iptables -I "+rule, "ip6tables -I "+rule)
+		down = append(down, "iptables -D "+rule, "ip6tables -D "+rule)
+	}
+	return "PostUp = " + strings.Join(up, " && ") + "\n" +
+		"PreDown = " + strings.Join(down, " && ")
+}
+
+// interfaceKeys are the keys allowed in an [Interface] section
+var interfaceKeys = map[string]bool{
+	"PrivateKey": true, "Address": true, "DNS": true, "MTU": true, "ListenPort": true,
+	"Table": true, "FwMark": true, "PreUp": true, "PostUp": true, "PreDown": true,
+	"PostDown": true, "SaveConfig": true,
+}
+
+// peerKeys are the keys allowed in a [Peer] section
+var peerKeys = map[string]bool{
+	"PublicKey": true, "PresharedKey": true, "AllowedIPs": true, "Endpoint": true,
+	"PersistentKeepalive": true,
+}
+
+// ConfigError describes a problem found in a configuration
+type ConfigError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e ConfigError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// RenderedConfig represents a rendered client configuration together with
+// the information needed to identify how it was produced
+type RenderedConfig struct {
+	Config          string `json:"config"`
+	TemplateName    string `json:"templateName"`
+	TemplateVersion string `json:"templateVersion"`
+	ParamsHash      string `json:"paramsHash"`
+	Endpoint        string `json:"endpoint"`
+}
+
+// fingerprint returns a short content hash used to version templates
+func fingerprint(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// paramsHash hashes the parameters a configuration was rendered with.
+// The private key is left out so the hash can be stored and compared freely.
+func paramsHash(peer *PeerConfig, replacements map[string]string) string {
+	keys := make([]string, 0, len(replacements))
+	for key := range replacements {
+		if key == "PRIVATE_KEY" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("PUBLIC_KEY=" + peer.PublicKey + "\n")
+	for _, key := range keys {
+		b.WriteString(key + "=" + replacements[key] + "\n")
+	}
+
+	return fingerprint(b.String())
+}
+
+// renderTemplate replaces placeholders in a template. A placeholder resolves
+// to its value, then its inline default, then the shared default; anything
+// still unresolved is reported as an error instead of leaking into the output.
+func renderTemplate(template string, values, defaults map[string]string) (string, error) {
+	unresolved := make([]string, 0)
+
+	result := placeholderPattern.ReplaceAllStringFunc(template, func(match string) string {
+		parts := placeholderPattern.FindStringSubmatch(match)
+		name, inlineDefault := parts[1], parts[2]
+
+		if value := values[name]; value != "" {
+			return value
+		}
+		if strings.Contains(match, "|") {
+			return strings.TrimSpace(inlineDefault)
+		}
+		if value, ok := defaults[name]; ok {
+			return value
+		}
+
+		unresolved = append(unresolved, name)
+		return match
+	})
+
+	if len(unresolved) > 0 {
+		return "", fmt.Errorf("unresolved placeholders: %s", strings.Join(unresolved, ", "))
+	}
+
+	return result, nil
+}
+
+// ValidateConfig checks that a client configuration is syntactically valid
+// WireGuard configuration and returns every problem found with its line
+func ValidateConfig(config string) []ConfigError {
+	errs := make([]ConfigError, 0)
+	section := ""
+	sections := map[string]int{}
+	seen := map[string]bool{}
+
+	// required checks the keys collected for the section that just ended
+	required := func(line int) {
+		var keys []string
+		switch section {
+		case "Interface":
+			keys = []string{"PrivateKey", "Address"}
+		case "Peer":
+			keys = []string{"PublicKey", "AllowedIPs"}
+		}
+		for _, key := range keys {
+			if !seen[key] {
+				errs = append(errs, ConfigError{Line: line, Message: fmt.Sprintf("[%s] section is missing %s", section, key)})
+			}
+		}
+	}
+
+	lines := strings.Split(config, "\n")
+	for i, raw := range lines {
+		lineNo := i + 1
+		line := strings.TrimSpace(raw)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.Contains(line, "{{") || strings.Contains(line, "}}") {
+			errs = append(errs, ConfigError{Line: lineNo, Message: "unrendered placeholder"})
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if section != "" {
+				required(lineNo - 1)
+			}
+			switch line {
+			case "[Interface]":
+				section = "Interface"
+			case "[Peer]":
+				section = "Peer"
+			default:
+				errs = append(errs, ConfigError{Line: lineNo, Message: fmt.Sprintf("unknown section %s", line)})
+				section = ""
+			}
+			sections[section]++
+			seen = map[string]bool{}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			errs = append(errs, ConfigError{Line: lineNo, Message: "expected Key = Value"})
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if section == "" {
+			errs = append(errs, ConfigError{Line: lineNo, Message: fmt.Sprintf("%s is outside of a section", key)})
+			continue
+		}
+		if (section == "Interface" && !interfaceKeys[key]) || (section == "Peer" && !peerKeys[key]) {
+			errs = append(errs, ConfigError{Line: lineNo, Message: fmt.Sprintf("unknown key %s in [%s] section", key, section)})
+			continue
+		}
+		if seen[key] && key != "Address" && key != "AllowedIPs" && key != "DNS" {
+			errs = append(errs, ConfigError{Line: lineNo, Message: fmt.Sprintf("duplicate key %s", key)})
+		}
+		seen[key] = true
+
+		if msg := validateConfigValue(key, value); msg != "" {
+			errs = append(errs, ConfigError{Line: lineNo, Message: msg})
+		}
+	}
+
+	if section != "" {
+		required(len(lines))
+	}
+	if sections["Interface"] != 1 {
+		errs = append(errs, ConfigError{Message: "config must contain exactly one [Interface] section"})
+	}
+	if sections["Peer"] == 0 {
+		errs = append(errs, ConfigError{Message: "config must contain at least one [Peer] section"})
+	}
+
+	return errs
+}
+
+// validateConfigValue validates a single configuration value, returning a
+// message describing the problem or an empty string if it is valid
+func validateConfigValue(key, value string) string {
+	if value == "" {
+		return fmt.Sprintf("%s must not be empty", key)
+	}
+
+	switch key {
+	case "PrivateKey", "PublicKey", "PresharedKey":
+		// Devices that enrolled their own key fill in the private key themselves
+		if key == "PrivateKey" && value == ClientPrivateKeyPlaceholder {
+			return ""
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(decoded) != 32 {
+			return fmt.Sprintf("%s must be a base64 encoded 32 byte key", key)
+		}
+	case "Address", "AllowedIPs":
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if _, _, err := net.ParseCIDR(item); err != nil && net.ParseIP(item) == nil {
+				return fmt.Sprintf("%s contains invalid address %q", key, item)
+			}
+		}
+	case "Endpoint":
+		host, port, err := net.SplitHostPort(value)
+		if err != nil || host == "" {
+			return fmt.Sprintf("Endpoint %q must be host:port", value)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Sprintf("Endpoint port %q is invalid", port)
+		}
+	case "MTU":
+		if n, err := strconv.Atoi(value); err != nil || n < 576 || n > 65535 {
+			return fmt.Sprintf("MTU %q must be between 576 and 65535", value)
+		}
+	case "ListenPort":
+		if n, err := strconv.Atoi(value); err != nil || n < 0 || n > 65535 {
+			return fmt.Sprintf("ListenPort %q is invalid", value)
+		}
+	case "PersistentKeepalive":
+		if value == "off" {
+			return ""
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 0 || n > 65535 {
+			return fmt.Sprintf("PersistentKeepalive %q must be off or between 0 and 65535", value)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,198 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// egressChain is the nat chain holding the source NAT rules of dedicated
+// public addresses on the local interface
+const egressChain = "VPN_EGRESS"
+
+// AddressReservation reserves a tunnel address to a user. Peers of the user
+// get the address whenever it is free, and no other peer is given it. With
+// a public address, traffic from the tunnel address leaves the server from
+// that address.
+type AddressReservation struct {
+	UserID   string `json:"userId"`
+	IP       string `json:"ip"`
+	PublicIP string `json:"publicIp,omitempty"`
+	ServerID string `json:"serverId,omitempty"` // server the public address is on
+}
+
+// ReservationSource gets the current address reservations
+type ReservationSource func() ([]AddressReservation, error)
+
+// EgressRule sends the traffic of a peer out from a dedicated public
+// address
+type EgressRule struct {
+	PeerIP   string `json:"peerIp"`
+	PublicIP string `json:"publicIp"`
+}
+
+// SetReservations sets where address reservations come from
+func (pm *PeerManager) SetReservations(source ReservationSource) {
+	pm.reservationSource = source
+}
+
+// reservations gets the current address reservations
+func (pm *PeerManager) reservations() ([]AddressReservation, error) {
+	if pm.nodeState != nil {
+		return pm.nodeState.Reservations, nil
+	}
+	if pm.reservationSource == nil {
+		return nil, nil
+	}
+	reservations, err := pm.reservationSource()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get address reservations: %v", err)
+	}
+	return reservations, nil
+}
+
+// CheckReservation checks an address can be reserved to a user: it must be
+// a host address of the peer subnet other than the server's, and not in
+// use by a peer of another user. A peer of the user keeps it.
+func (pm *PeerManager) CheckReservation(userID, address string) error {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	subnet, err := pm.subnet()
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(address).To4()
+	if ip == nil || !subnet.Contains(ip) {
+		return fmt.Errorf("address %s is not in %s", address, subnet)
+	}
+	if ip.Equal(subnet.IP) || !subnet.Contains(nextIP(ip)) {
+		return fmt.Errorf("address %s is not a host address of %s", address, subnet)
+	}
+	if server, _, err := net.ParseCIDR(pm.config.WireGuard.Address); err == nil && server.Equal(ip) {
+		return fmt.Errorf("address %s is the server address", address)
+	}
+
+	static, err := pm.ListPeers()
+	if err != nil {
+		return err
+	}
+	dynamic, err := pm.ListDynamicPeers()
+	if err != nil {
+		return err
+	}
+	for _, peer := range append(static, dynamic...) {
+		if peer.UserID != userID && ip.Equal(peerIP(peer.IP)) {
+			return fmt.Errorf("address %s is in use by a device of another user", address)
+		}
+	}
+
+	return nil
+}
+
+// FreeIP gets the lowest address of the peer subnet that is neither in use
+// nor reserved
+func (pm *PeerManager) FreeIP() (string, error) {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	address, err := pm.allocateIP("", "")
+	if err != nil {
+		return "", err
+	}
+	return peerIP(address).String(), nil
+}
+
+// ApplyReservations applies the configuration again so the egress rules
+// follow the current reservations. Peers keep their addresses until they
+// reconnect.
+func (pm *PeerManager) ApplyReservations(ctx context.Context) error {
+	peerMutex.Lock()
+	defer peerMutex.Unlock()
+
+	return pm.applyConfiguration(ctx)
+}
+
+// EgressPolicy gets the egress rules of peers holding an address reserved
+// to their user with a public address on their server, by peer address
+func EgressPolicy(peers []*PeerConfig, reservations []AddressReservation) []EgressRule {
+	rules := make([]EgressRule, 0)
+	for _, reservation := range reservations {
+		if reservation.PublicIP == "" {
+			continue
+		}
+		reserved := net.ParseIP(reservation.IP)
+		for _, peer := range peers {
+			if peer.Pending() || peer.Archived() || peer.UserID != reservation.UserID || peer.ServerID != reservation.ServerID {
+				continue
+			}
+			if ip := peerIP(peer.IP); ip != nil && ip.Equal(reserved) {
+				rules = append(rules, EgressRule{PeerIP: ip.String(), PublicIP: reservation.PublicIP})
+				break
+			}
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].PeerIP < rules[j].PeerIP
+	})
+
+	return rules
+}
+
+// syncEgress replaces the egress rules of the local interface with the
+// rules of the given peers, in one iptables-restore like the DNS policy
+func (pm *PeerManager) syncEgress(ctx context.Context, peers []*PeerConfig) error {
+	reservations, err := pm.reservations()
+	if err != nil {
+		return err
+	}
+	rules := EgressPolicy(peers, reservations)
+
+	var restore strings.Builder
+	fmt.Fprintf(&restore, "*nat\n:%s - [0:0]\n", egressChain)
+	for _, rule := range rules {
+		fmt.Fprintf(&restore, "-A %s -s %s/32 -j SNAT --to-source %s\n", egressChain, rule.PeerIP, rule.PublicIP)
+	}
+	restore.WriteString("COMMIT\n")
+
+	cmd := exec.CommandContext(ctx, "iptables-restore", "--noflush")
+	cmd.Stdin = strings.NewReader(restore.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if len(rules) == 0 {
+			// Nothing to apply, e.g. on hosts without iptables
+			return nil
+		}
+		return fmt.Errorf("failed to apply egress rules: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	// Send traffic through the chain ahead of the masquerading of postUp
+	jump := func(action string) error {
+		return runCommand(ctx, "iptables", "-t", "nat", action, "POSTROUTING", "-j", egressChain)
+	}
+	if jump("-C") != nil {
+		if err := jump("-I"); err != nil {
+			return fmt.Errorf("failed to apply egress rules: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// removeEgress removes the egress rules of the local interface
+func (pm *PeerManager) removeEgress(ctx context.Context) error {
+	if err := runCommand(ctx, "iptables", "-t", "nat", "-D", "POSTROUTING", "-j", egressChain); err != nil {
+		// The rules were never applied
+		return nil
+	}
+	if err := runCommand(ctx, "iptables", "-t", "nat", "-F", egressChain); err != nil {
+		return err
+	}
+	return runCommand(ctx, "iptables", "-t", "nat", "-X", egressChain)
+}
@@ -0,0 +1,310 @@
+package wireguard
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// SetupSheet represents a printable sheet for setting up a device: its
+// config as a QR code, a summary of the config without secrets and the
+// steps for the device's platform
+type SetupSheet struct {
+	DeviceName string
+	DeviceType string
+	ServerName string
+	Config     string
+	CreatedAt  time.Time
+}
+
+// setupSteps are the setup instructions of each platform
+var setupSteps = map[string][]string{
+	"android": {
+		"Install the WireGuard app from the Google Play Store.",
+		"Open the app and tap the + button.",
+		"Choose \"Scan from QR code\" and point the camera at the code on this sheet.",
+		"Enter a name for the tunnel and tap \"Create tunnel\".",
+		"Turn the tunnel on with the switch next to its name. Allow the connection request if asked.",
+	},
+	"ios": {
+		"Install the WireGuard app from the App Store.",
+		"Open the app and tap \"Add a tunnel\".",
+		"Choose \"Create from QR code\" and point the camera at the code on this sheet.",
+		"Enter a name for the tunnel and tap \"Save\".",
+		"Tap \"Allow\" to add the VPN configuration, then turn the tunnel on.",
+	},
+	"windows": {
+		"Download and install WireGuard from wireguard.com/install.",
+		"Ask your IT team for the configuration file of this device, or download it from the VPN portal.",
+		"Open WireGuard and click \"Import tunnel(s) from file\".",
+		"Select the configuration file.",
+		"Click \"Activate\" to connect.",
+	},
+	"macos": {
+		"Install WireGuard from the Mac App Store.",
+		"Ask your IT team for the configuration file of this device, or download it from the VPN portal.",
+		"Open WireGuard from the menu bar and choose \"Import Tunnel(s) from File\".",
+		"Select the configuration file and click \"Allow\" to add the VPN configuration.",
+		"Click \"Activate\" to connect.",
+	},
+	"linux": {
+		"Install the WireGuard tools, e.g. \"sudo apt install wireguard\".",
+		"Save the configuration file of this device as /etc/wireguard/wg0.conf.",
+		"Connect with \"sudo wg-quick up wg0\".",
+		"To connect at boot, run \"sudo systemctl enable wg-quick@wg0\".",
+	},
+	"generic": {
+		"Install a WireGuard client for your device from wireguard.com/install.",
+		"Scan the QR code on this sheet, or import the configuration file from the VPN portal.",
+		"Turn the tunnel on to connect.",
+	},
+}
+
+// SetupSteps gets the setup instructions for a device type
+func SetupSteps(deviceType string) []string {
+	switch strings.ToLower(deviceType) {
+	case "android":
+		return setupSteps["android"]
+	case "ios", "iphone", "ipad":
+		return setupSteps["ios"]
+	case "windows":
+		return setupSteps["windows"]
+	case "macos", "mac":
+		return setupSteps["macos"]
+	case "linux":
+		return setupSteps["linux"]
+	default:
+		return setupSteps["generic"]
+	}
+}
+
+// RedactConfig summarises a config for printing: comments and blank lines
+// are dropped and secret keys hidden
+func RedactConfig(config string) []string {
+	summary := make([]string, 0)
+	for _, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, _, ok := strings.Cut(line, "="); ok {
+			switch strings.TrimSpace(key) {
+			case "PrivateKey", "PresharedKey":
+				line = strings.TrimSpace(key) + " = (hidden)"
+			}
+		}
+		summary = append(summary, line)
+	}
+	return summary
+}
+
+// RenderPDF renders the setup sheet as a PDF. The QR code carries the full
+// config, so the sheet must be handled like the config itself.
+func (s *SetupSheet) RenderPDF() ([]byte, error) {
+	code, err := qrcode.New(s.Config, qrcode.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate QR code: %v", err)
+	}
+
+	doc := newPDFDocument()
+
+	doc.text(fontBold, 20, "VPN setup sheet")
+	doc.space(6)
+	doc.text(fontRegular, 11, "Device: "+s.DeviceName+" ("+s.DeviceType+")")
+	if s.ServerName != "" {
+		doc.text(fontRegular, 11, "Server: "+s.ServerName)
+	}
+	doc.text(fontRegular, 11, "Created: "+s.CreatedAt.Format("2006-01-02 15:04 MST"))
+	doc.space(12)
+
+	doc.text(fontBold, 14, "Scan to connect")
+	doc.space(4)
+	doc.qrCode(code.Bitmap(), 200)
+	doc.space(8)
+	doc.text(fontRegular, 9, "This code contains the private key of the device. Keep the sheet safe and shred it after setup.")
+	doc.space(12)
+
+	doc.text(fontBold, 14, "Setup steps")
+	doc.space(4)
+	for i, step := range SetupSteps(s.DeviceType) {
+		doc.text(fontRegular, 11, fmt.Sprintf("%d. %s", i+1, step))
+	}
+	doc.space(12)
+
+	doc.text(fontBold, 14, "Configuration summary")
+	doc.space(4)
+	for _, line := range RedactConfig(s.Config) {
+		doc.text(fontMono, 9, line)
+	}
+
+	return doc.bytes(), nil
+}
+
+// PDF fonts, the standard Type 1 fonts every viewer has
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+	fontMono    = "F3"
+)
+
+// A4 page layout in points
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+)
+
+// pdfDocument lays out lines of text and QR codes top to bottom on A4
+// pages
+type pdfDocument struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+// newPDFDocument creates a document with an empty first page
+func newPDFDocument() *pdfDocument {
+	doc := &pdfDocument{}
+	doc.newPage()
+	return doc
+}
+
+// newPage starts a new page
+func (d *pdfDocument) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// page gets the content of the current page
+func (d *pdfDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// reserve moves down by height, starting a new page if it does not fit
+func (d *pdfDocument) reserve(height float64) {
+	if d.y-height < pdfMargin {
+		d.newPage()
+	}
+	d.y -= height
+}
+
+// space adds vertical space
+func (d *pdfDocument) space(height float64) {
+	d.y -= height
+}
+
+// text adds a paragraph, wrapped to the page width
+func (d *pdfDocument) text(font string, size float64, text string) {
+	// Helvetica averages about half the font size per character and
+	// Courier is 0.6 of it
+	charWidth := size * 0.5
+	if font == fontMono {
+		charWidth = size * 0.6
+	}
+	width := int((pdfPageWidth - 2*pdfMargin) / charWidth)
+
+	for _, line := range wrapText(text, width) {
+		d.reserve(size * 1.3)
+		fmt.Fprintf(d.page(), "BT /%s %.1f Tf %d %.2f Td (%s) Tj ET\n", font, size, pdfMargin, d.y, pdfEscape(line))
+	}
+}
+
+// qrCode draws a QR code bitmap as a square of the given size, merging
+// dark modules of a row into single rectangles
+func (d *pdfDocument) qrCode(bitmap [][]bool, size float64) {
+	if len(bitmap) == 0 {
+		return
+	}
+	d.reserve(size)
+
+	module := size / float64(len(bitmap))
+	page := d.page()
+	page.WriteString("0 g\n")
+	for row, modules := range bitmap {
+		y := d.y + size - float64(row+1)*module
+		for col := 0; col < len(modules); col++ {
+			if !modules[col] {
+				continue
+			}
+			start := col
+			for col+1 < len(modules) && modules[col+1] {
+				col++
+			}
+			fmt.Fprintf(page, "%.2f %.2f %.2f %.2f re\n", pdfMargin+float64(start)*module, y, float64(col-start+1)*module, module)
+		}
+	}
+	page.WriteString("f\n")
+}
+
+// bytes writes the document
+func (d *pdfDocument) bytes() []byte {
+	var out bytes.Buffer
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1-5 are the catalog, the page tree and the fonts, followed
+	// by a page and its content stream for every page
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes()
+}
+
+// wrapText splits text into lines of at most width characters, breaking
+// at spaces where possible
+func wrapText(text string, width int) []string {
+	lines := make([]string, 0, 1)
+	for len(text) > width {
+		cut := strings.LastIndex(text[:width], " ")
+		if cut <= 0 {
+			cut = width
+		}
+		lines = append(lines, text[:cut])
+		text = strings.TrimLeft(text[cut:], " ")
+	}
+	return append(lines, text)
+}
+
+// pdfEscape escapes a string for a PDF literal, replacing characters the
+// standard fonts cannot show
+func pdfEscape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,232 @@
+package wireguard
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn-service/backend/src/cache"
+	"github.com/vpn-service/backend/src/config"
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// Device templates are named after their file in config_templates/,
+// without extension
+const (
+	TemplateGeneric = "generic"
+	TemplateAndroid = "android"
+	TemplateIOS     = "ios"
+	TemplateWindows = "windows"
+	TemplateMac     = "mac"
+	TemplateGateway = "gateway" // site-to-site routers
+)
+
+// MaxTemplateSize limits the size of an uploaded template
+const MaxTemplateSize = 16 * 1024
+
+// requiredPlaceholders must appear in every template, so configs are never
+// rendered with another peer's key or address hard-coded
+var requiredPlaceholders = []string{"PRIVATE_KEY", "CLIENT_IP", "SERVER_PUBLIC_KEY"}
+
+//go:embed config_templates/*.conf
+var templateFiles embed.FS
+
+// TemplateLoader looks up the override for a device template, returning
+// false if there is none and the built-in template applies
+type TemplateLoader func(name string) (string, bool, error)
+
+var (
+	templateLoader TemplateLoader
+	loaderMutex    sync.RWMutex
+)
+
+// SetTemplateLoader sets the loader used to look up template overrides
+func SetTemplateLoader(loader TemplateLoader) {
+	loaderMutex.Lock()
+	defer loaderMutex.Unlock()
+
+	templateLoader = loader
+}
+
+// configTemplate is a configuration template along with its name
+type configTemplate struct {
+	name    string
+	content string
+}
+
+// templateCache holds resolved templates by name
+var templateCache = cache.New[string, configTemplate]("config_templates", 5*time.Minute)
+
+// TemplateNames gets the names of the device templates
+func TemplateNames() []string {
+	return []string{TemplateGeneric, TemplateAndroid, TemplateIOS, TemplateWindows, TemplateMac, TemplateGateway}
+}
+
+// IsTemplateName checks whether name is a device template
+func IsTemplateName(name string) bool {
+	for _, known := range TemplateNames() {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// TemplateName maps a device type to the name of its template
+func TemplateName(deviceType string) string {
+	switch strings.ToLower(deviceType) {
+	case "android":
+		return TemplateAndroid
+	case "ios", "iphone", "ipad":
+		return TemplateIOS
+	case "windows":
+		return TemplateWindows
+	case "mac", "macos":
+		return TemplateMac
+	case DeviceTypeGateway:
+		return TemplateGateway
+	}
+	return TemplateGeneric
+}
+
+// DefaultTemplate gets the built-in template compiled into the binary
+func DefaultTemplate(name string) (string, error) {
+	if !IsTemplateName(name) {
+		return "", fmt.Errorf("unknown config template: %s", name)
+	}
+
+	content, err := templateFiles.ReadFile("config_templates/" + name + ".conf")
+	if err != nil {
+		return "", fmt.Errorf("failed to read built-in template %s: %v", name, err)
+	}
+
+	return string(content), nil
+}
+
+// TemplateVersion returns the version fingerprint of a template, as reported
+// with every config rendered from it
+func TemplateVersion(content string) string {
+	return fingerprint(content)
+}
+
+// InvalidateTemplate drops a cached template so the next config rendered
+// from it picks up a changed override
+func InvalidateTemplate(name string) {
+	templateCache.Delete(name)
+}
+
+// TemplatePreview is a template rendered with sample values together with
+// the problems found in it
+type TemplatePreview struct {
+	Valid    bool          `json:"valid"`
+	Rendered string        `json:"rendered,omitempty"`
+	Errors   []ConfigError `json:"errors"`
+}
+
+// sampleValues are rendered into templates under validation. Each value is
+// a single line, so lines of the rendered config match lines of the template.
+// Optional settings are left unset so templates that only work with them
+// configured are caught.
+var sampleValues = map[string]string{
+	"PRIVATE_KEY":       "YAnV4SnPYEA+jS6nQtxF5lS3jj0gqXBVVeP9tz/bP2A=",
+	"CLIENT_IP":         "10.0.0.2/32",
+	"SERVER_PUBLIC_KEY": "zzz3UBcqiV9RsYCzJWOU5VVVNk3VtQECQXXPnQiEfQQ=",
+	"SERVER_ENDPOINT":   "vpn.example.com:51820",
+	"DNS":               "1.1.1.1",
+	"ALLOWED_IPS":       "0.0.0.0/0, ::/0",
+}
+
+// ValidateTemplate checks that a template renders to a valid configuration
+// and returns every problem found
+func ValidateTemplate(content string) []ConfigError {
+	return PreviewTemplate(content).Errors
+}
+
+// PreviewTemplate renders a template with sample values and checks the
+// result, reporting problems by template line where possible
+func PreviewTemplate(content string) TemplatePreview {
+	preview := TemplatePreview{Errors: make([]ConfigError, 0)}
+
+	if strings.TrimSpace(content) == "" {
+		preview.Errors = append(preview.Errors, ConfigError{Message: "template must not be empty"})
+		return preview
+	}
+	if len(content) > MaxTemplateSize {
+		preview.Errors = append(preview.Errors, ConfigError{Message: fmt.Sprintf("template must be at most %d bytes", MaxTemplateSize)})
+		return preview
+	}
+
+	used := make(map[string]bool)
+	for _, match := range placeholderPattern.FindAllStringSubmatch(content, -1) {
+		used[match[1]] = true
+	}
+	for _, name := range requiredPlaceholders {
+		if !used[name] {
+			preview.Errors = append(preview.Errors, ConfigError{Message: fmt.Sprintf("template must use the {{%s}} placeholder", name)})
+		}
+	}
+
+	rendered, err := renderTemplate(content, sampleValues, defaultPlaceholderValues)
+	if err != nil {
+		// Render line by line to point at the unresolved placeholders
+		found := false
+		for i, line := range strings.Split(content, "\n") {
+			if _, lineErr := renderTemplate(line, sampleValues, defaultPlaceholderValues); lineErr != nil {
+				preview.Errors = append(preview.Errors, ConfigError{Line: i + 1, Message: lineErr.Error()})
+				found = true
+			}
+		}
+		if !found {
+			preview.Errors = append(preview.Errors, ConfigError{Message: err.Error()})
+		}
+		return preview
+	}
+
+	preview.Rendered = rendered
+	preview.Errors = append(preview.Errors, ValidateConfig(rendered)...)
+	preview.Valid = len(preview.Errors) == 0
+
+	return preview
+}
+
+// getConfigTemplate gets the configuration template for a device type,
+// returning the template name along with its content. Overrides take
+// precedence over the built-in templates and are looked up again once
+// their cache.templates lifetime has passed.
+func getConfigTemplate(cfg *config.Config, deviceType string) (string, string, error) {
+	name := TemplateName(deviceType)
+
+	if cached, ok := templateCache.Get(name); ok {
+		return cached.name, cached.content, nil
+	}
+
+	loaderMutex.RLock()
+	loader := templateLoader
+	loaderMutex.RUnlock()
+
+	if loader != nil {
+		content, ok, err := loader(name)
+		switch {
+		case err != nil:
+			// Keep serving configs from the built-in template until the
+			// override can be read again
+			utils.LogError("Failed to load %s config template override: %v", name, err)
+			content, err := DefaultTemplate(name)
+			return name, content, err
+		case ok:
+			templateCache.SetWithTTL(name, configTemplate{name: name, content: content}, time.Duration(cfg.Cache.Templates)*time.Second)
+			return name, content, nil
+		}
+	}
+
+	content, err := DefaultTemplate(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	templateCache.SetWithTTL(name, configTemplate{name: name, content: content}, time.Duration(cfg.Cache.Templates)*time.Second)
+
+	return name, content, nil
+}
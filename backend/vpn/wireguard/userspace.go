@@ -0,0 +1,100 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/vpn-service/backend/src/utils"
+)
+
+// WireGuard implementations of the local interface
+const (
+	ImplementationAuto      = "auto"      // kernel module, or wireguard-go without it
+	ImplementationKernel    = "kernel"    // kernel module
+	ImplementationUserspace = "userspace" // wireguard-go
+)
+
+// userspaceSocketDir holds the control sockets of userspace interfaces,
+// which wg uses in place of netlink
+const userspaceSocketDir = "/var/run/wireguard"
+
+// tunDevice is needed by wireguard-go to create its interface
+const tunDevice = "/dev/net/tun"
+
+// Implementation gets the WireGuard implementation of the local interface,
+// or an empty string if it is not set up
+func (pm *PeerManager) Implementation() string {
+	if pm.driver == nil {
+		return ""
+	}
+	return pm.driver.Name()
+}
+
+// localDriver gets the driver of the local interface. Before it is set up,
+// the kernel driver reads interfaces of either implementation.
+func (pm *PeerManager) localDriver() Driver {
+	if pm.driver == nil {
+		return kernelDriver{}
+	}
+	return pm.driver
+}
+
+// createInterface creates a local interface with the driver of the
+// configured implementation. In auto mode the kernel module is tried first
+// and wireguard-go is started when the module is missing, as on container
+// hosts that share a kernel without it. An interface left over from a
+// previous run is kept. Once one interface is created the others use the
+// same driver.
+func (pm *PeerManager) createInterface(ctx context.Context, name string) error {
+	wg := &pm.config.WireGuard
+
+	kernel, _ := NewDriver(ImplementationKernel, "")
+	userspace, _ := NewDriver(ImplementationUserspace, wg.UserspaceBinary)
+
+	if runCommand(ctx, "ip", "link", "show", "dev", name) == nil {
+		pm.driver = kernel
+		if _, err := os.Stat(userspaceSocket(name)); err == nil {
+			pm.driver = userspace
+		}
+		return nil
+	}
+	if pm.driver != nil {
+		if err := pm.driver.Create(ctx, name); err != nil {
+			return fmt.Errorf("failed to create interface %s: %v", name, err)
+		}
+		return nil
+	}
+
+	switch wg.Implementation {
+	case "", ImplementationAuto:
+		kernelErr := kernel.Create(ctx, name)
+		if kernelErr == nil {
+			pm.driver = kernel
+			break
+		}
+		if err := userspace.Create(ctx, name); err != nil {
+			return fmt.Errorf("failed to create interface %s: kernel module: %v; userspace: %v", name, kernelErr, err)
+		}
+		utils.LogWarning("WireGuard kernel module is not available (%v), using %s", kernelErr, wg.UserspaceBinary)
+		pm.driver = userspace
+	default:
+		driver, err := NewDriver(wg.Implementation, wg.UserspaceBinary)
+		if err != nil {
+			return err
+		}
+		if err := driver.Create(ctx, name); err != nil {
+			return fmt.Errorf("failed to create interface %s: %v", name, err)
+		}
+		pm.driver = driver
+	}
+
+	return nil
+}
+
+// userspaceSocket gets the path of the control socket of a userspace
+// interface
+func userspaceSocket(iface string) string {
+	return filepath.Join(userspaceSocketDir, iface+".sock")
+}